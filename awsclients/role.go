@@ -0,0 +1,52 @@
+package awsclients
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"time"
+)
+
+//refreshBefore is how long before they expire the credentials of an assumed role are refreshed
+const refreshBefore = 5 * time.Minute
+
+//assumeRole returns a provider of the credentials of role roleARN, assumed with those of base, assuming
+// it again before they expire, so a run may outlast them
+// The role is assumed before returning, so a role that can't be assumed fails at once.
+func (s *Session) assumeRole(ctx context.Context, base aws.Config, roleARN, externalID string) (aws.CredentialsProvider, error) {
+	stsCfg := base.Copy()
+	if stsCfg.Region == "" {
+		// the global endpoint
+		stsCfg.Region = "us-east-1"
+	}
+	p := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(stsCfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = s.cfg.RoleSessionName
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+		if s.cfg.RoleDuration > 0 {
+			o.Duration = s.cfg.RoleDuration
+		}
+	})
+	creds := aws.NewCredentialsCache(p, func(o *aws.CredentialsCacheOptions) { o.ExpiryWindow = refreshBefore })
+	if _, err := creds.Retrieve(ctx); err != nil {
+		return nil, fmt.Errorf("assuming %s: %w", roleARN, err)
+	}
+	return creds, nil
+}
+
+//WithRole returns a session like s whose clients use the credentials of role roleARN, assumed with
+// the credentials of s, e.g. to write to another account than the one read from
+// externalID is required by some roles of other accounts.
+func (s *Session) WithRole(ctx context.Context, roleARN, externalID string) (*Session, error) {
+	creds, err := s.assumeRole(ctx, s.Config, roleARN, externalID)
+	if err != nil {
+		return nil, fmt.Errorf("WithRole: %w", err)
+	}
+	cfg := s.Config.Copy()
+	cfg.Credentials = creds
+	return &Session{Region: s.Region, Config: cfg, cfg: s.cfg, s3: make(map[string]*awsconfig.S3Client)}, nil
+}
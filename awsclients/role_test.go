@@ -0,0 +1,117 @@
+package awsclients
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//assumeRoleCall is an AssumeRole request made of fakeSTS
+type assumeRoleCall struct {
+	role, session, externalID, duration string
+	// signer is the access key the request was signed with
+	signer string
+}
+
+//fakeSTS answers AssumeRole with credentials of the role expiring after expires, the access key of each
+// the role's name and the count of calls
+type fakeSTS struct {
+	expires time.Duration
+
+	mu    sync.Mutex
+	calls []assumeRoleCall
+}
+
+func (fs *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRole" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	_, credential, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+	signer, _, _ := strings.Cut(credential, "/")
+
+	fs.mu.Lock()
+	fs.calls = append(fs.calls, assumeRoleCall{role: r.Form.Get("RoleArn"), session: r.Form.Get("RoleSessionName"),
+		externalID: r.Form.Get("ExternalId"), duration: r.Form.Get("DurationSeconds"), signer: signer})
+	n := len(fs.calls)
+	fs.mu.Unlock()
+
+	role := r.Form.Get("RoleArn")[strings.LastIndexByte(r.Form.Get("RoleArn"), '/')+1:]
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>
+<Credentials><AccessKeyId>ASIA-%s-%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>%s</Expiration></Credentials>
+<AssumedRoleUser><Arn>%s/%s</Arn><AssumedRoleId>AROA:%s</AssumedRoleId></AssumedRoleUser>
+</AssumeRoleResult><ResponseMetadata><RequestId>r-%d</RequestId></ResponseMetadata></AssumeRoleResponse>`,
+		role, n, time.Now().Add(fs.expires).UTC().Format(time.RFC3339), r.Form.Get("RoleArn"), r.Form.Get("RoleSessionName"),
+		r.Form.Get("RoleSessionName"), n)
+}
+
+func (fs *fakeSTS) made() []assumeRoleCall {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]assumeRoleCall(nil), fs.calls...)
+}
+
+//TestRoleChain checks a session's role is assumed at once with its base credentials, and a role of WithRole
+// with the session's, and that either is assumed again only once its credentials are within refreshBefore
+// of expiring
+func TestRoleChain(t *testing.T) {
+	sharedFiles(t, "", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDBASE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		expires time.Duration
+		// calls are the roles assumed, each with the access key it's assumed with
+		calls []string
+		creds string
+	}{
+		{"lasting", time.Hour, []string{"reader by AKIDBASE", "writer by ASIA-reader-1"}, "ASIA-writer-2"},
+		// each retrieval of credentials about to expire assumes the role again, with its base's
+		// credentials, themselves refreshed
+		{"expiring", refreshBefore - time.Minute, []string{"reader by AKIDBASE", "reader by AKIDBASE", "writer by ASIA-reader-2",
+			"reader by AKIDBASE", "writer by ASIA-reader-4"}, "ASIA-writer-5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := &fakeSTS{expires: test.expires}
+			srv := httptest.NewServer(fs)
+			defer srv.Close()
+
+			s, err := New(ctx, Config{Region: "eu-west-1", EndpointURL: srv.URL, RoleARN: "arn:aws:iam::111111111111:role/reader",
+				ExternalID: "chd", RoleDuration: 2 * time.Hour, MaxRetries: -1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls := fs.made(); len(calls) != 1 ||
+				calls[0] != (assumeRoleCall{"arn:aws:iam::111111111111:role/reader", defaultSessionName, "chd", "7200", "AKIDBASE"}) {
+				t.Fatalf("AssumeRole calls %+v, want the role assumed by New", calls)
+			}
+			writer, err := s.WithRole(ctx, "arn:aws:iam::222222222222:role/writer", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			creds, err := writer.Config.Credentials.Retrieve(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var calls []string
+			for _, c := range fs.made() {
+				calls = append(calls, c.role[strings.LastIndexByte(c.role, '/')+1:]+" by "+c.signer)
+			}
+			if !reflect.DeepEqual(calls, test.calls) || creds.AccessKeyID != test.creds {
+				t.Errorf("credentials %s after AssumeRole calls %q, want %s after %q", creds.AccessKeyID, calls, test.creds, test.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+//Package awsclients builds the clients of the AWS services the decoder reads from and writes to,
+// from one configuration of region, credentials, retries and endpoints
+// The configuration is the AWS SDK's default, loaded from the environment and the shared config and
+// credentials files, so credentials may be a profile's, including one using SSO, credential_process or
+// a role to assume, the environment's, a web identity's, or an ECS task's or EC2 instance's. They may be
+// exchanged for those of a role to assume, refreshed before they expire. Endpoints may be overridden,
+// e.g. for LocalStack.
+package awsclients
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//defaultSessionName names the sessions of assumed roles without a RoleSessionName
+const defaultSessionName = "decode_config_history"
+
+//Config configures the clients of a Session
+type Config struct {
+	// Region of the clients, by default the SDK's: $AWS_REGION, $AWS_DEFAULT_REGION, or the profile's
+	Region string
+	// Profile in the shared config and credentials files to take credentials from, by default
+	// $AWS_PROFILE if the environment has no access keys
+	Profile string
+	// RoleARN, if set, is assumed with the credentials of the profile or environment
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	// RoleDuration is how long the credentials of assumed roles last before they're refreshed;
+	// the role's default if 0
+	RoleDuration time.Duration
+	// MaxRetries is how many times the SDK's retryer retries a throttled or failed request;
+	// 0 is awsconfig.DefaultMaxRetries, and a negative number never retries
+	MaxRetries int
+	// EndpointURL, if set, is the base endpoint of every service, e.g. http://localhost:4566 for LocalStack;
+	// $AWS_ENDPOINT_URL_<SERVICE> overrides it for one service
+	EndpointURL string
+	// S3PathStyle addresses S3 buckets path-style, as they always are at an overridden endpoint
+	S3PathStyle bool
+	// InsecureSkipVerify skips verifying the TLS certificates of endpoints, e.g. a test MinIO's
+	// self-signed certificate; never in production
+	InsecureSkipVerify bool
+}
+
+//Session creates the clients of AWS services sharing a Config and its credentials
+type Session struct {
+	// Region is the region of clients not given one
+	Region string
+	// Config is the SDK configuration of the session's clients, with their credentials, retryer and
+	// HTTP client
+	Config aws.Config
+
+	cfg Config
+
+	mu sync.Mutex
+	s3 map[string]*awsconfig.S3Client
+}
+
+//New creates a Session, loading the SDK's configuration and assuming its role, if any
+func New(ctx context.Context, cfg Config) (*Session, error) {
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = awsconfig.DefaultMaxRetries
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RoleSessionName == "" {
+		cfg.RoleSessionName = defaultSessionName
+	}
+	cfg.EndpointURL = strings.TrimSuffix(cfg.EndpointURL, "/")
+
+	s := &Session{cfg: cfg, s3: make(map[string]*awsconfig.S3Client)}
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Region),
+		config.WithSharedConfigProfile(cfg.Profile),
+		config.WithRetryMaxAttempts(cfg.MaxRetries + 1),
+		config.WithHTTPClient(s.sdkHTTPClient()),
+	}
+	if cfg.EndpointURL != "" {
+		opts = append(opts, config.WithBaseEndpoint(cfg.EndpointURL))
+	}
+	var err error
+	if s.Config, err = config.LoadDefaultConfig(ctx, opts...); err != nil {
+		return nil, fmt.Errorf("New: %w", err)
+	}
+	s.Region = s.Config.Region
+
+	if cfg.RoleARN != "" {
+		if s.Config.Credentials, err = s.assumeRole(ctx, s.Config, cfg.RoleARN, cfg.ExternalID); err != nil {
+			return nil, fmt.Errorf("New: %w", err)
+		}
+	}
+	return s, nil
+}
+
+//endpointOverridden reports whether the endpoint of service, its $AWS_ENDPOINT_URL_<SERVICE> suffix,
+// is overridden
+func (s *Session) endpointOverridden(service string) bool {
+	return s.Config.BaseEndpoint != nil || os.Getenv("AWS_ENDPOINT_URL_"+service) != ""
+}
+
+//sdkHTTPClient returns the HTTP client of the session's SDK clients
+// It's the SDK's, so a CA bundle of $AWS_CA_BUNDLE or the profile's ca_bundle is added to it. It has no
+// overall timeout, as reading a large object from S3 may take a while.
+func (s *Session) sdkHTTPClient() *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+		if s.cfg.InsecureSkipVerify {
+			t.TLSClientConfig.InsecureSkipVerify = true
+		}
+	})
+}
+
+//HTTPClient returns an HTTP client of AWS endpoints whose requests time out after timeout, or never if 0
+func (s *Session) HTTPClient(timeout time.Duration) *http.Client {
+	c := &http.Client{Timeout: timeout}
+	if s.cfg.InsecureSkipVerify {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		c.Transport = t
+	}
+	return c
+}
+
+//region returns region, or if it's empty the session's region
+func (s *Session) region(region string) (string, error) {
+	if region == "" {
+		region = s.Region
+	}
+	if region == "" {
+		return "", fmt.Errorf("no region given, and none is configured")
+	}
+	return region, nil
+}
+
+//ConfigClient returns a client of the AWS Config service in region, or the session's region if it's empty
+// Its endpoint is $AWS_ENDPOINT_URL_CONFIG_SERVICE, or the session's base endpoint, if either is set.
+func (s *Session) ConfigClient(region string) (*awsconfig.Client, error) {
+	region, err := s.region(region)
+	if err != nil {
+		return nil, fmt.Errorf("ConfigClient: %w", err)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_CONFIG_SERVICE")
+	if endpoint == "" {
+		endpoint = aws.ToString(s.Config.BaseEndpoint)
+	}
+	return &awsconfig.Client{Region: region, Config: s.Config, Endpoint: endpoint}, nil
+}
+
+//SecretsClient returns a client of Secrets Manager and SSM Parameter Store in region, or the session's
+// region if it's empty
+func (s *Session) SecretsClient(region string) (*awsconfig.SecretsClient, error) {
+	region, err := s.region(region)
+	if err != nil {
+		return nil, fmt.Errorf("SecretsClient: %w", err)
+	}
+	return &awsconfig.SecretsClient{
+		SecretsManager: secretsmanager.NewFromConfig(s.Config, func(o *secretsmanager.Options) { o.Region = region }),
+		SSM:            ssm.NewFromConfig(s.Config, func(o *ssm.Options) { o.Region = region }),
+	}, nil
+}
+
+//SQSClient returns a client of SQS in region, or the session's region if it's empty
+func (s *Session) SQSClient(region string) (*sqs.Client, error) {
+	region, err := s.region(region)
+	if err != nil {
+		return nil, fmt.Errorf("SQSClient: %w", err)
+	}
+	return sqs.NewFromConfig(s.Config, func(o *sqs.Options) { o.Region = region }), nil
+}
+
+//S3Client returns the client of S3 in region, or the session's region if it's empty
+// Clients are shared by every caller asking for the same region.
+func (s *Session) S3Client(region string) (*awsconfig.S3Client, error) {
+	region, err := s.region(region)
+	if err != nil {
+		return nil, fmt.Errorf("S3Client: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.s3[region]; ok {
+		return c, nil
+	}
+	client := s3.NewFromConfig(s.Config, func(o *s3.Options) {
+		o.Region = region
+		o.UsePathStyle = s.cfg.S3PathStyle || s.endpointOverridden("S3")
+	})
+	c := &awsconfig.S3Client{Client: client, MaxRetries: s.cfg.MaxRetries}
+	s.s3[region] = c
+	return c, nil
+}
+
+//Signer returns a function signing requests to service in region, or the session's region if it's
+// empty, with the SDK's Signature Version 4 signer, for HTTP clients of AWS services without an SDK
+// client here, such as OpenSearchWriters
+func (s *Session) Signer(service, region string) (func(req *http.Request, body []byte) error, error) {
+	region, err := s.region(region)
+	if err != nil {
+		return nil, fmt.Errorf("Signer: %w", err)
+	}
+	if s.Config.Credentials == nil {
+		return nil, fmt.Errorf("Signer: no credentials are configured")
+	}
+	signer := v4.NewSigner()
+	return func(req *http.Request, body []byte) error {
+		creds, err := s.Config.Credentials.Retrieve(req.Context())
+		if err != nil {
+			return fmt.Errorf("Signer: %w", err)
+		}
+		hash := sha256.Sum256(body)
+		payloadHash := hex.EncodeToString(hash[:])
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+		return signer.SignHTTP(req.Context(), creds, req, payloadHash, service, region, time.Now())
+	}, nil
+}
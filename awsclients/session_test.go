@@ -0,0 +1,81 @@
+package awsclients
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//sharedFiles points the SDK at shared config and credentials files of the test's, and clears the
+// environment's credentials and region
+func sharedFiles(t *testing.T, config, credentials string) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"config": config, "credentials": credentials} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE",
+		"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_S3", "AWS_CA_BUNDLE"} {
+		t.Setenv(env, "")
+	}
+}
+
+func TestNew(t *testing.T) {
+	sharedFiles(t, "[profile audit]\nregion = eu-west-1\n", "[audit]\naws_access_key_id = AKIDAUDIT\naws_secret_access_key = secret\n")
+	ctx := context.Background()
+
+	s, err := New(ctx, Config{Profile: "audit", MaxRetries: 2, EndpointURL: "http://localhost:4566/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Region != "eu-west-1" {
+		t.Errorf("region %q, want the profile's", s.Region)
+	}
+	creds, err := s.Config.Credentials.Retrieve(ctx)
+	if err != nil || creds.AccessKeyID != "AKIDAUDIT" {
+		t.Errorf("credentials %q, %v, want the profile's", creds.AccessKeyID, err)
+	}
+	if s.Config.RetryMaxAttempts != 3 {
+		t.Errorf("%d attempts, want 3", s.Config.RetryMaxAttempts)
+	}
+
+	// an overridden endpoint addresses buckets path-style, in the region asked for
+	c, err := s.S3Client("us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := c.Client.Options(); !o.UsePathStyle || o.Region != "us-east-1" {
+		t.Errorf("S3 client of %s, path-style %v", o.Region, o.UsePathStyle)
+	}
+	if again, _ := s.S3Client("us-east-1"); again != c {
+		t.Error("S3 client of the region not shared")
+	}
+	if cc, _ := s.ConfigClient(""); cc.Endpoint != "http://localhost:4566" || cc.Region != "eu-west-1" ||
+		cc.Config.RetryMaxAttempts != 3 {
+		t.Errorf("Config client of %s at %q", cc.Region, cc.Endpoint)
+	}
+
+	sign, err := s.Signer("es", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "https://search.eu-west-1.es.amazonaws.com/_bulk", nil)
+	if err := sign(req, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDAUDIT/") ||
+		!strings.Contains(auth, "/eu-west-1/es/aws4_request") {
+		t.Errorf("Authorization %q", auth)
+	}
+
+	// a profile named explicitly must exist
+	if _, err := New(ctx, Config{Profile: "missing"}); err == nil {
+		t.Error("missing profile loaded")
+	}
+}
@@ -0,0 +1,165 @@
+//Package awsconfig queries current resource configuration from the AWS Config service, lists
+// and reads the snapshots it delivers to S3, and reads secrets from Secrets Manager and SSM Parameter Store
+// S3, Secrets Manager and SSM are called with the AWS SDK's clients. AWS Config is called over its JSON
+// protocol, as its SDK client isn't a dependency, with requests signed by the SDK's Signature Version 4
+// signer and retried by the SDK's retryer.
+package awsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//DefaultMaxRetries is how many times a throttled or failed request is retried by default
+const DefaultMaxRetries = 5
+
+//Client calls the AWS Config service in one region
+type Client struct {
+	Region string
+	// Config is the SDK configuration whose credentials sign requests, whose HTTP client sends them and
+	// whose retryer retries them
+	Config aws.Config
+	// Endpoint defaults to the regional service endpoint
+	Endpoint string
+}
+
+//APIError is an error returned by the service
+type APIError struct {
+	StatusCode int
+	Type       string `json:"__type"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Type, e.StatusCode, e.Message)
+}
+
+// ErrorCode implements smithy.APIError for APIError
+func (e *APIError) ErrorCode() string {
+	return e.Type
+}
+
+// ErrorMessage implements smithy.APIError for APIError
+func (e *APIError) ErrorMessage() string {
+	return e.Message
+}
+
+// ErrorFault implements smithy.APIError for APIError
+func (e *APIError) ErrorFault() smithy.ErrorFault {
+	if e.StatusCode >= 500 {
+		return smithy.FaultServer
+	}
+	return smithy.FaultClient
+}
+
+//HTTPStatusCode is the status of the response, by which the SDK's retryer retries server errors
+func (e *APIError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+//retryer returns the retryer of the client's requests, the SDK's standard one making the Config's
+// RetryMaxAttempts if it has none, as the SDK's clients do
+func (c *Client) retryer() aws.Retryer {
+	if c.Config.Retryer != nil {
+		return c.Config.Retryer()
+	}
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		if c.Config.RetryMaxAttempts > 0 {
+			o.MaxAttempts = c.Config.RetryMaxAttempts
+		}
+	})
+}
+
+//call invokes the service operation op with request in, decoding the response into out
+// Throttled and server errors are retried as the retryer has them retried.
+func (c *Client) call(ctx context.Context, op string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	r := c.retryer()
+	for attempt := 1; ; attempt++ {
+		err := c.do(ctx, op, body, out)
+		if err == nil || attempt >= r.MaxAttempts() || !r.IsErrorRetryable(err) {
+			return err
+		}
+		delay, delayErr := r.RetryDelay(attempt, err)
+		if delayErr != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+//do makes one signed request of the AWS JSON 1.1 protocol for op, decoding the response into out
+func (c *Client) do(ctx context.Context, op string, body []byte, out any) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://config.%s.amazonaws.com", c.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "StarlingDoveService."+op)
+
+	if c.Config.Credentials == nil {
+		return fmt.Errorf("%s: no credentials", op)
+	}
+	creds, err := c.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "config", c.Region, time.Now()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	client := c.Config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(b, apiErr)
+		if i := strings.LastIndexByte(apiErr.Type, '#'); i >= 0 {
+			apiErr.Type = apiErr.Type[i+1:]
+		}
+		return apiErr
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package awsconfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//queryPageSize is the largest page of results the service returns, and the most resources fetched at once
+const queryPageSize = 100
+
+//ItemProperties are the configuration item properties selected by ResourceQuery
+// They're named as the service names them, which isn't always as a snapshot does; results are renamed to
+// the snapshot's names by NewItemsReader.
+var ItemProperties = []string{
+	"accountId",
+	"arn",
+	"availabilityZone",
+	"awsRegion",
+	"configuration",
+	"configurationItemCaptureTime",
+	"configurationItemStatus",
+	"resourceCreationTime",
+	"resourceId",
+	"resourceName",
+	"resourceType",
+	"supplementaryConfiguration",
+	"tags",
+}
+
+//KeyProperties are the properties selected by KeyQuery, those identifying a resource to fetch
+var KeyProperties = []string{
+	"accountId",
+	"awsRegion",
+	"resourceId",
+	"resourceName",
+	"resourceType",
+}
+
+//ResourceQuery builds an advanced query expression selecting the current configuration of resources of types
+func ResourceQuery(types []string) string {
+	return selectQuery(ItemProperties, types)
+}
+
+//KeyQuery builds an advanced query expression selecting the keys of resources of types, for a Query
+// that fetches their configuration
+func KeyQuery(types []string) string {
+	return selectQuery(KeyProperties, types)
+}
+
+//selectQuery builds an advanced query expression selecting properties of resources of types
+func selectQuery(properties, types []string) string {
+	q := "SELECT " + strings.Join(properties, ", ")
+	if len(types) == 0 {
+		return q
+	}
+
+	quoted := make([]string, len(types))
+	for i, t := range types {
+		quoted[i] = "'" + strings.ReplaceAll(t, "'", "") + "'"
+	}
+	return q + " WHERE resourceType IN (" + strings.Join(quoted, ", ") + ")"
+}
+
+//Query is an advanced query of current resource configuration
+// Without an Aggregator the query runs against the account and region of the Client.
+// With Fetch the configuration items of the resources the query selects are fetched with
+// BatchGetResourceConfig, or BatchGetAggregateResourceConfig, which return items with all of the
+// properties of a snapshot's, such as configurationItemVersion and configurationStateId; the query need only
+// select KeyProperties.
+type Query struct {
+	Expression string
+	Aggregator string
+	Fetch      bool
+}
+
+type selectRequest struct {
+	Expression                  string
+	ConfigurationAggregatorName string `json:",omitempty"`
+	Limit                       int
+	NextToken                   string `json:",omitempty"`
+}
+
+type selectResponse struct {
+	Results   []string
+	NextToken string
+}
+
+//Select runs one page of q, returning its results as json objects and the token of the next page
+// The token is "" after the last page.
+func (c *Client) Select(ctx context.Context, q Query, nextToken string) ([]string, string, error) {
+	req := selectRequest{Expression: q.Expression, Limit: queryPageSize, NextToken: nextToken}
+	op := "SelectResourceConfig"
+	if q.Aggregator != "" {
+		op = "SelectAggregateResourceConfig"
+		req.ConfigurationAggregatorName = q.Aggregator
+	}
+
+	var resp selectResponse
+	if err := c.call(ctx, op, req, &resp); err != nil {
+		return nil, "", fmt.Errorf("Select: %w", err)
+	}
+	return resp.Results, resp.NextToken, nil
+}
+
+//ResourceKey identifies a resource to fetch; Account and Region are needed to fetch it from an aggregator
+type ResourceKey struct {
+	Type    string
+	ID      string
+	Name    string
+	Account string
+	Region  string
+}
+
+type batchGetRequest struct {
+	ResourceKeys []resourceKey `json:"resourceKeys"`
+}
+
+type resourceKey struct {
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+}
+
+type batchGetAggregateRequest struct {
+	ConfigurationAggregatorName string
+	ResourceIdentifiers         []aggregateResourceIdentifier
+}
+
+type aggregateResourceIdentifier struct {
+	SourceAccountID string `json:"SourceAccountId"`
+	SourceRegion    string
+	ResourceID      string `json:"ResourceId"`
+	ResourceType    string
+	ResourceName    string `json:",omitempty"`
+}
+
+//batchGetResponse is the response of either operation; json field names match case-insensitively
+type batchGetResponse struct {
+	BaseConfigurationItems         []json.RawMessage
+	UnprocessedResourceKeys        []resourceKey
+	UnprocessedResourceIdentifiers []aggregateResourceIdentifier
+}
+
+//BatchGet fetches the current configuration items of up to 100 resources, from aggregator if it isn't "",
+// returning the items as json objects, and the keys of resources the service didn't process, which may be
+// fetched again
+// Resources that no longer exist are left out of the items.
+func (c *Client) BatchGet(ctx context.Context, aggregator string, keys []ResourceKey) ([]string, []ResourceKey, error) {
+	var op string
+	var req any
+	if aggregator == "" {
+		op = "BatchGetResourceConfig"
+		r := batchGetRequest{ResourceKeys: make([]resourceKey, len(keys))}
+		for i, k := range keys {
+			r.ResourceKeys[i] = resourceKey{ResourceType: k.Type, ResourceID: k.ID}
+		}
+		req = r
+	} else {
+		op = "BatchGetAggregateResourceConfig"
+		r := batchGetAggregateRequest{ConfigurationAggregatorName: aggregator, ResourceIdentifiers: make([]aggregateResourceIdentifier, len(keys))}
+		for i, k := range keys {
+			r.ResourceIdentifiers[i] = aggregateResourceIdentifier{SourceAccountID: k.Account, SourceRegion: k.Region,
+				ResourceID: k.ID, ResourceType: k.Type, ResourceName: k.Name}
+		}
+		req = r
+	}
+
+	var resp batchGetResponse
+	if err := c.call(ctx, op, req, &resp); err != nil {
+		return nil, nil, fmt.Errorf("BatchGet: %w", err)
+	}
+
+	items := make([]string, len(resp.BaseConfigurationItems))
+	for i, item := range resp.BaseConfigurationItems {
+		items[i] = string(item)
+	}
+	var unprocessed []ResourceKey
+	for _, k := range resp.UnprocessedResourceKeys {
+		unprocessed = append(unprocessed, ResourceKey{Type: k.ResourceType, ID: k.ResourceID})
+	}
+	for _, k := range resp.UnprocessedResourceIdentifiers {
+		unprocessed = append(unprocessed, ResourceKey{Type: k.ResourceType, ID: k.ResourceID, Name: k.ResourceName,
+			Account: k.SourceAccountID, Region: k.SourceRegion})
+	}
+	return items, unprocessed, nil
+}
+
+//fetch fetches the configuration items of the resources whose keys the query results select, fetching
+// those the service doesn't process again, with backoff, until it's made no progress as many times as
+// the retryer retries a request
+func (c *Client) fetch(ctx context.Context, aggregator string, results []string) ([]string, error) {
+	keys := make([]ResourceKey, len(results))
+	for i, r := range results {
+		var k struct {
+			ResourceType string
+			ResourceID   string
+			ResourceName string
+			AccountID    string
+			AWSRegion    string
+		}
+		if err := json.Unmarshal([]byte(r), &k); err != nil {
+			return nil, fmt.Errorf("fetch: %w", err)
+		}
+		keys[i] = ResourceKey{Type: k.ResourceType, ID: k.ResourceID, Name: k.ResourceName, Account: k.AccountID, Region: k.AWSRegion}
+	}
+
+	var items []string
+	maxAttempts := c.retryer().MaxAttempts()
+	backoff := 500 * time.Millisecond
+	for attempt := 0; len(keys) > 0; {
+		got, unprocessed, err := c.BatchGet(ctx, aggregator, keys)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, got...)
+		if len(unprocessed) < len(keys) {
+			keys = unprocessed
+			continue
+		}
+
+		if attempt++; attempt >= maxAttempts {
+			return nil, fmt.Errorf("fetch: %d resources not processed", len(keys))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+	return items, nil
+}
+
+//itemNames are the snapshot's names of properties the service names differently
+var itemNames = map[string]string{
+	"accountId": "awsAccountId",
+	"arn":       "ARN",
+	"version":   "configurationItemVersion",
+}
+
+//itemTimes are properties that are times, which BatchGetResourceConfig returns in seconds since the epoch
+var itemTimes = []string{"configurationItemCaptureTime", "configurationItemDeliveryTime", "resourceCreationTime"}
+
+//itemTimeFormat is the format of the times in a snapshot's items
+const itemTimeFormat = "2006-01-02T15:04:05.000Z"
+
+//snapshotItem converts a configuration item returned by the service to one like a snapshot's
+// Properties are renamed as the snapshot names them, times are formatted as its times are, the configuration
+// and supplementary configuration, which BatchGetResourceConfig returns as json in strings, are decoded,
+// and tags, which queries return as a list of keys and values, become an object.
+func snapshotItem(b string) (string, error) {
+	var in map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(b), &in); err != nil {
+		return "", fmt.Errorf("snapshotItem: %w", err)
+	}
+
+	item := make(map[string]json.RawMessage, len(in))
+	for k, v := range in {
+		if name, ok := itemNames[k]; ok {
+			k = name
+		}
+		item[k] = v
+	}
+
+	for _, k := range itemTimes {
+		var seconds float64
+		if json.Unmarshal(item[k], &seconds) == nil {
+			t := time.UnixMilli(int64(seconds * 1000)).UTC().Format(itemTimeFormat)
+			item[k], _ = json.Marshal(t)
+		}
+	}
+	var stateID string
+	if json.Unmarshal(item["configurationStateId"], &stateID) == nil {
+		if _, err := strconv.ParseInt(stateID, 10, 64); err == nil {
+			item["configurationStateId"] = json.RawMessage(stateID)
+		}
+	}
+	if v, ok := embeddedJSON(item["configuration"]); ok {
+		item["configuration"] = v
+	}
+	var supplementary map[string]json.RawMessage
+	if json.Unmarshal(item["supplementaryConfiguration"], &supplementary) == nil && supplementary != nil {
+		for k, v := range supplementary {
+			if v, ok := embeddedJSON(v); ok {
+				supplementary[k] = v
+			}
+		}
+		item["supplementaryConfiguration"], _ = json.Marshal(supplementary)
+	}
+	var tags []struct{ Key, Value string }
+	if json.Unmarshal(item["tags"], &tags) == nil && tags != nil {
+		m := make(map[string]string, len(tags))
+		for _, t := range tags {
+			m[t.Key] = t.Value
+		}
+		item["tags"], _ = json.Marshal(m)
+	}
+
+	out, err := json.Marshal(item)
+	if err != nil {
+		return "", fmt.Errorf("snapshotItem: %w", err)
+	}
+	return string(out), nil
+}
+
+//embeddedJSON returns the json in v if it's a string holding json
+func embeddedJSON(v json.RawMessage) (json.RawMessage, bool) {
+	var s string
+	if json.Unmarshal(v, &s) != nil || !json.Valid([]byte(s)) {
+		return nil, false
+	}
+	return json.RawMessage(s), true
+}
+
+//NewItemsReader runs q to completion, presenting its results as a json document
+// with the results, or the items fetched for them, in an array named itemsField, like a configuration
+// snapshot, and with the properties of a snapshot's items.
+// Pages are fetched as the document is read; a query error is returned by Read.
+func NewItemsReader(ctx context.Context, c *Client, q Query, itemsField string) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		_, err := fmt.Fprintf(pw, "{%q:[", itemsField)
+
+		first := true
+		token := ""
+		for err == nil {
+			var results []string
+			results, token, err = c.Select(ctx, q, token)
+			if err == nil && q.Fetch && len(results) > 0 {
+				results, err = c.fetch(ctx, q.Aggregator, results)
+			}
+			for _, r := range results {
+				if err != nil {
+					break
+				}
+				if r, err = snapshotItem(r); err != nil {
+					break
+				}
+				if !first {
+					_, err = io.WriteString(pw, ",")
+				}
+				if err == nil {
+					_, err = io.WriteString(pw, r)
+				}
+				first = false
+			}
+			if token == "" {
+				break
+			}
+		}
+
+		if err == nil {
+			_, err = io.WriteString(pw, "]}")
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr
+}
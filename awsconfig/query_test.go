@@ -0,0 +1,227 @@
+package awsconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//configServer serves advanced queries and batch gets of the resources in items, as the AWS Config service does
+// The first batch get of more than one resource leaves the last unprocessed.
+type configServer struct {
+	mu          sync.Mutex
+	targets     []string
+	requests    []map[string]any
+	unprocessed bool
+}
+
+func (cs *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	target := r.Header.Get("X-Amz-Target")
+
+	cs.mu.Lock()
+	cs.targets = append(cs.targets, target)
+	cs.requests = append(cs.requests, req)
+	cs.mu.Unlock()
+
+	switch target {
+	case "StarlingDoveService.SelectResourceConfig", "StarlingDoveService.SelectAggregateResourceConfig":
+		if req["NextToken"] == nil {
+			writeJSON(w, map[string]any{"Results": []string{queryResult("i-1"), queryResult("i-2")}, "NextToken": "2"})
+		} else {
+			writeJSON(w, map[string]any{"Results": []string{queryResult("i-3")}})
+		}
+
+	case "StarlingDoveService.BatchGetResourceConfig":
+		keys := req["resourceKeys"].([]any)
+		var unprocessed []any
+		if !cs.unprocessed && len(keys) > 1 {
+			cs.unprocessed = true
+			keys, unprocessed = keys[:len(keys)-1], keys[len(keys)-1:]
+		}
+		var items []json.RawMessage
+		for _, k := range keys {
+			items = append(items, batchItem(k.(map[string]any)["resourceId"].(string)))
+		}
+		writeJSON(w, map[string]any{"baseConfigurationItems": items, "unprocessedResourceKeys": unprocessed})
+
+	case "StarlingDoveService.BatchGetAggregateResourceConfig":
+		var items []json.RawMessage
+		for _, k := range req["ResourceIdentifiers"].([]any) {
+			items = append(items, batchItem(k.(map[string]any)["ResourceId"].(string)))
+		}
+		writeJSON(w, map[string]any{"BaseConfigurationItems": items})
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]any{"__type": "InvalidAction", "message": target})
+	}
+}
+
+func writeJSON(w io.Writer, v any) {
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+//queryResult is a result of ResourceQuery, with the properties named as the service names them
+func queryResult(id string) string {
+	return `{"accountId":"111111111111","arn":"arn:aws:ec2:us-east-1:111111111111:instance/` + id + `",` +
+		`"awsRegion":"us-east-1","configuration":{"instanceId":"` + id + `"},` +
+		`"configurationItemCaptureTime":"2024-05-01T10:00:00.000Z","resourceId":"` + id + `",` +
+		`"resourceType":"AWS::EC2::Instance","tags":[{"key":"Team","value":"search","tag":"Team=search"}]}`
+}
+
+//batchItem is a base configuration item as BatchGetResourceConfig returns it
+func batchItem(id string) json.RawMessage {
+	return json.RawMessage(`{"version":"1.3","accountId":"111111111111","configurationItemCaptureTime":1714557600.5,` +
+		`"configurationItemStatus":"OK","configurationStateId":"1714557600500","arn":"arn:aws:ec2:us-east-1:111111111111:instance/` + id + `",` +
+		`"resourceType":"AWS::EC2::Instance","resourceId":"` + id + `","awsRegion":"us-east-1",` +
+		`"configuration":"{\"instanceId\":\"` + id + `\"}","supplementaryConfiguration":{"Tags":"[{\"key\":\"Team\"}]"}}`)
+}
+
+//testConfig is the SDK configuration of a client of srv, retrying a request once
+func testConfig(srv *httptest.Server) aws.Config {
+	return aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("x", "y", ""),
+		HTTPClient:  srv.Client(),
+		Retryer:     func() aws.Retryer { return retry.AddWithMaxAttempts(retry.NewStandard(), 2) },
+	}
+}
+
+func newTestClient(t *testing.T, cs *configServer) *Client {
+	srv := httptest.NewServer(cs)
+	t.Cleanup(srv.Close)
+	return &Client{Region: "us-east-1", Config: testConfig(srv), Endpoint: srv.URL}
+}
+
+//readItems reads the items of q as NewItemsReader presents them
+func readItems(t *testing.T, c *Client, q Query) []map[string]any {
+	in := NewItemsReader(context.Background(), c, q, "configurationItems")
+	defer in.Close()
+
+	var doc struct {
+		ConfigurationItems []map[string]any `json:"configurationItems"`
+	}
+	if err := json.NewDecoder(in).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.ConfigurationItems
+}
+
+func TestNewItemsReader(t *testing.T) {
+	cs := &configServer{}
+	items := readItems(t, newTestClient(t, cs), Query{Expression: ResourceQuery([]string{"AWS::EC2::Instance"})})
+
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want the 3 of both pages", len(items))
+	}
+	want := map[string]any{
+		"awsAccountId":                 "111111111111",
+		"ARN":                          "arn:aws:ec2:us-east-1:111111111111:instance/i-1",
+		"awsRegion":                    "us-east-1",
+		"configuration":                map[string]any{"instanceId": "i-1"},
+		"configurationItemCaptureTime": "2024-05-01T10:00:00.000Z",
+		"resourceId":                   "i-1",
+		"resourceType":                 "AWS::EC2::Instance",
+		"tags":                         map[string]any{"Team": "search"},
+	}
+	if !reflect.DeepEqual(items[0], want) {
+		t.Errorf("item = %v, want %v", items[0], want)
+	}
+	if !reflect.DeepEqual(cs.targets, []string{"StarlingDoveService.SelectResourceConfig", "StarlingDoveService.SelectResourceConfig"}) {
+		t.Errorf("targets = %v", cs.targets)
+	}
+	if q := cs.requests[0]["Expression"]; q != "SELECT "+strings.Join(ItemProperties, ", ")+" WHERE resourceType IN ('AWS::EC2::Instance')" {
+		t.Errorf("Expression = %v", q)
+	}
+}
+
+func TestNewItemsReaderFetch(t *testing.T) {
+	want := map[string]any{
+		"configurationItemVersion":     "1.3",
+		"awsAccountId":                 "111111111111",
+		"configurationItemCaptureTime": "2024-05-01T10:00:00.500Z",
+		"configurationItemStatus":      "OK",
+		"configurationStateId":         float64(1714557600500),
+		"ARN":                          "arn:aws:ec2:us-east-1:111111111111:instance/i-1",
+		"resourceType":                 "AWS::EC2::Instance",
+		"resourceId":                   "i-1",
+		"awsRegion":                    "us-east-1",
+		"configuration":                map[string]any{"instanceId": "i-1"},
+		"supplementaryConfiguration":   map[string]any{"Tags": []any{map[string]any{"key": "Team"}}},
+	}
+
+	tests := []struct {
+		name       string
+		aggregator string
+		targets    []string
+	}{
+		{"account", "", []string{"SelectResourceConfig", "BatchGetResourceConfig", "BatchGetResourceConfig",
+			"SelectResourceConfig", "BatchGetResourceConfig"}},
+		{"aggregator", "org", []string{"SelectAggregateResourceConfig", "BatchGetAggregateResourceConfig",
+			"SelectAggregateResourceConfig", "BatchGetAggregateResourceConfig"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := &configServer{}
+			items := readItems(t, newTestClient(t, cs), Query{Expression: KeyQuery(nil), Aggregator: test.aggregator, Fetch: true})
+
+			var ids []string
+			for _, item := range items {
+				ids = append(ids, item["resourceId"].(string))
+			}
+			// the resource left unprocessed is fetched again
+			if !reflect.DeepEqual(ids, []string{"i-1", "i-2", "i-3"}) {
+				t.Fatalf("items = %v, want i-1, i-2 and i-3", ids)
+			}
+			if !reflect.DeepEqual(items[0], want) {
+				t.Errorf("item = %v, want %v", items[0], want)
+			}
+
+			var targets []string
+			for _, target := range cs.targets {
+				targets = append(targets, strings.TrimPrefix(target, "StarlingDoveService."))
+			}
+			if !reflect.DeepEqual(targets, test.targets) {
+				t.Errorf("targets = %v, want %v", targets, test.targets)
+			}
+			if test.aggregator != "" {
+				want := map[string]any{"ConfigurationAggregatorName": "org", "ResourceIdentifiers": []any{
+					map[string]any{"SourceAccountId": "111111111111", "SourceRegion": "us-east-1", "ResourceId": "i-1", "ResourceType": "AWS::EC2::Instance"},
+					map[string]any{"SourceAccountId": "111111111111", "SourceRegion": "us-east-1", "ResourceId": "i-2", "ResourceType": "AWS::EC2::Instance"},
+				}}
+				if !reflect.DeepEqual(cs.requests[1], want) {
+					t.Errorf("request = %v, want %v", cs.requests[1], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewItemsReaderError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]any{"__type": "com.amazonaws#NoSuchConfigurationAggregatorException", "message": "org"})
+	}))
+	defer srv.Close()
+	c := &Client{Region: "us-east-1", Config: testConfig(srv), Endpoint: srv.URL}
+
+	in := NewItemsReader(context.Background(), c, Query{Expression: KeyQuery(nil), Aggregator: "org"}, "configurationItems")
+	defer in.Close()
+	_, err := io.ReadAll(in)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Type != "NoSuchConfigurationAggregatorException" {
+		t.Errorf("ReadAll: %v, want NoSuchConfigurationAggregatorException", err)
+	}
+}
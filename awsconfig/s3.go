@@ -0,0 +1,72 @@
+package awsconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"time"
+)
+
+//S3Client lists, reads and writes objects in S3, such as the snapshots AWS Config delivers, with an SDK client
+// of one region
+type S3Client struct {
+	Client *s3.Client
+	// MaxRetries is how many times in a row an ObjectReader resumes reading without reading anything;
+	// the SDK client retries each request as its retryer does
+	MaxRetries int
+}
+
+//Region is the region of the client
+func (c *S3Client) Region() string {
+	return c.Client.Options().Region
+}
+
+//Object is an object listed in a bucket
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+//ListObjects lists the objects in bucket whose keys begin with prefix, in key order
+func (c *S3Client) ListObjects(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	var objects []Object
+	pages := s3.NewListObjectsV2Paginator(c.Client, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return objects, fmt.Errorf("ListObjects: s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, o := range page.Contents {
+			objects = append(objects, Object{
+				Key:          aws.ToString(o.Key),
+				Size:         aws.ToInt64(o.Size),
+				LastModified: aws.ToTime(o.LastModified),
+				ETag:         aws.ToString(o.ETag),
+			})
+		}
+	}
+	return objects, nil
+}
+
+//GetObject returns the content of the object key in bucket, which the caller must close
+func (c *S3Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	out, err := c.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("GetObject: s3://%s/%s: %w", bucket, key, err)
+	}
+	return out.Body, nil
+}
+
+//PutObject writes body to the object key in bucket, replacing any object there
+func (c *S3Client) PutObject(ctx context.Context, bucket, key string, body []byte) error {
+	_, err := c.Client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: bytes.NewReader(body)})
+	if err != nil {
+		return fmt.Errorf("PutObject: s3://%s/%s: %w", bucket, key, err)
+	}
+	return nil
+}
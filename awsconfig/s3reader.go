@@ -0,0 +1,162 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"io"
+	"time"
+)
+
+//ObjectReader reads an S3 object as a stream, resuming from the byte it got to with a ranged GET if
+// reading fails, so a reset connection doesn't end reading a multi-GB object
+// Resumed reads require the object's ETag to be unchanged, so a replaced object isn't spliced into
+// the one being read. Attempts to resume back off exponentially, up to maxResumeBackoff, and reading
+// fails once MaxRetries in a row have read nothing.
+type ObjectReader struct {
+	c      *S3Client
+	ctx    context.Context
+	bucket string
+	key    string
+
+	body io.ReadCloser
+	etag string
+	// size is the object's, or -1 if unknown
+	size     int64
+	pos      int64
+	failures int
+	resumes  int
+}
+
+//OpenObject opens the object key in bucket for reading from its start, which the caller must close
+func (c *S3Client) OpenObject(ctx context.Context, bucket, key string) (*ObjectReader, error) {
+	r := &ObjectReader{c: c, ctx: ctx, bucket: bucket, key: key, size: -1}
+	if err := r.open(); err != nil {
+		return nil, fmt.Errorf("OpenObject: s3://%s/%s: %w", bucket, key, err)
+	}
+	return r, nil
+}
+
+//open requests the object from the current position
+// The SDK client reads the object as stored, as ranges are of its stored bytes.
+func (r *ObjectReader) open() error {
+	in := &s3.GetObjectInput{Bucket: aws.String(r.bucket), Key: aws.String(r.key)}
+	if r.pos > 0 {
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-", r.pos))
+		if r.etag != "" {
+			in.IfMatch = aws.String(r.etag)
+		}
+	}
+	out, err := r.c.Client.GetObject(r.ctx, in)
+	if err != nil {
+		return err
+	}
+	if r.pos == 0 {
+		r.etag = aws.ToString(out.ETag)
+		if out.ContentLength != nil {
+			r.size = *out.ContentLength
+		}
+	} else if out.ContentRange == nil {
+		out.Body.Close()
+		return fmt.Errorf("resuming at byte %d: range not satisfied", r.pos)
+	}
+	r.body = out.Body
+	return nil
+}
+
+//resumeBackoff and maxResumeBackoff are the waits before the first and any later attempt to resume
+var resumeBackoff, maxResumeBackoff = 500 * time.Millisecond, 30 * time.Second
+
+// Read implements io.Reader for ObjectReader
+// Bytes read before a failure are returned straight away; the next Read backs off and resumes.
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if err := r.resume(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.pos += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == nil || err == io.EOF && (r.size < 0 || r.pos >= r.size) {
+			return n, err
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.body.Close()
+		r.body = nil
+		if err := r.fail(err); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+//fail counts err as a failure to read, returning the error reading ends with if it's not to be resumed
+// An error of the service is resumed from only if the client's retryer would retry it.
+func (r *ObjectReader) fail(err error) error {
+	var apiErr smithy.APIError
+	if r.ctx.Err() != nil || errors.As(err, &apiErr) && !r.c.Client.Options().Retryer.IsErrorRetryable(err) ||
+		r.failures >= r.c.MaxRetries {
+		return fmt.Errorf("ObjectReader: s3://%s/%s at byte %d: %w", r.bucket, r.key, r.pos, err)
+	}
+	r.failures++
+	r.resumes++
+	return nil
+}
+
+//resumeWait returns the wait before resuming after failures in a row, doubling from resumeBackoff
+func resumeWait(failures int) time.Duration {
+	wait := resumeBackoff
+	for i := 1; i < failures && wait < maxResumeBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxResumeBackoff {
+		wait = maxResumeBackoff
+	}
+	return wait
+}
+
+//resume backs off for the failures in a row so far, then reopens the object from the current position
+func (r *ObjectReader) resume() error {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(resumeWait(r.failures)):
+		}
+
+		err := r.open()
+		if err == nil {
+			return nil
+		}
+		if err := r.fail(err); err != nil {
+			return err
+		}
+	}
+}
+
+//Resumes is the number of attempts to resume reading after it failed
+func (r *ObjectReader) Resumes() int {
+	return r.resumes
+}
+
+// Close implements io.Closer for ObjectReader
+func (r *ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
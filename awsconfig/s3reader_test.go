@@ -0,0 +1,211 @@
+package awsconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//objectServer serves an object as S3 does, from the byte range requested, resetting connections as scripted
+// cuts are the bytes at which successive responses are cut off, by closing the connection; etags are the
+// object's ETag at each request, the last repeating.
+type objectServer struct {
+	mu      sync.Mutex
+	data    []byte
+	etags   []string
+	cuts    []int64
+	ranges  []string
+	ifMatch []string
+}
+
+func (os *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	os.mu.Lock()
+	etag := os.etags[len(os.etags)-1]
+	if len(os.ranges) < len(os.etags) {
+		etag = os.etags[len(os.ranges)]
+	}
+	os.ranges = append(os.ranges, r.Header.Get("Range"))
+	os.ifMatch = append(os.ifMatch, r.Header.Get("If-Match"))
+	cut := int64(len(os.data))
+	if len(os.cuts) > 0 {
+		cut, os.cuts = os.cuts[0], os.cuts[1:]
+	}
+	os.mu.Unlock()
+
+	if m := r.Header.Get("If-Match"); m != "" && m != etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		_, _ = io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+		return
+	}
+
+	var start int64
+	status := http.StatusOK
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err == nil {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(os.data)-1, len(os.data)))
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Length", fmt.Sprint(int64(len(os.data))-start))
+	w.WriteHeader(status)
+	if cut >= int64(len(os.data)) {
+		_, _ = w.Write(os.data[start:])
+		return
+	}
+
+	_, _ = w.Write(os.data[start:cut])
+	w.(http.Flusher).Flush()
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+//fastResumes shortens the waits before resuming for the test
+func fastResumes(t *testing.T, backoff, max time.Duration) {
+	b, m := resumeBackoff, maxResumeBackoff
+	resumeBackoff, maxResumeBackoff = backoff, max
+	t.Cleanup(func() { resumeBackoff, maxResumeBackoff = b, m })
+}
+
+func openTestObject(t *testing.T, os *objectServer, maxRetries int) *ObjectReader {
+	srv := httptest.NewServer(os)
+	t.Cleanup(srv.Close)
+	c := &S3Client{Client: s3.NewFromConfig(testConfig(srv), func(o *s3.Options) {
+		o.BaseEndpoint, o.UsePathStyle = aws.String(srv.URL), true
+	}), MaxRetries: maxRetries}
+	r, err := c.OpenObject(context.Background(), "bucket", "snapshot.json.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func testObject(size int) []byte {
+	return bytes.Repeat([]byte("0123456789"), size/10)
+}
+
+func TestObjectReader(t *testing.T) {
+	fastResumes(t, time.Millisecond, time.Millisecond)
+	os := &objectServer{data: testObject(1000), etags: []string{`"e-1"`}, cuts: []int64{100, 250}}
+	r := openTestObject(t, os, 2)
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, os.data) {
+		t.Errorf("read %d bytes, want the object's %d", len(got), len(os.data))
+	}
+	if r.Resumes() != 2 {
+		t.Errorf("%d resumes, want 2", r.Resumes())
+	}
+	// each resumed read starts where the last left off, of the object as it was first read
+	if want := []string{"", "bytes=100-", "bytes=250-"}; !reflect.DeepEqual(os.ranges, want) {
+		t.Errorf("ranges %q, want %q", os.ranges, want)
+	}
+	if want := []string{"", `"e-1"`, `"e-1"`}; !reflect.DeepEqual(os.ifMatch, want) {
+		t.Errorf("If-Match %q, want %q", os.ifMatch, want)
+	}
+}
+
+//TestObjectReaderReturnsRead checks the bytes read before a reset are returned at once, and the
+// wait to resume is taken by the next Read
+func TestObjectReaderReturnsRead(t *testing.T) {
+	fastResumes(t, 200*time.Millisecond, time.Second)
+	os := &objectServer{data: testObject(1000), etags: []string{`"e-1"`}, cuts: []int64{100}}
+	r := openTestObject(t, os, 2)
+
+	p := make([]byte, 1000)
+	var read int
+	start := time.Now()
+	for read < 100 {
+		n, err := r.Read(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read += n
+	}
+	if elapsed := time.Since(start); read != 100 || elapsed >= 200*time.Millisecond {
+		t.Errorf("read %d bytes in %s, want the 100 before the reset without waiting", read, elapsed)
+	}
+
+	start = time.Now()
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); len(rest) != 900 || elapsed < 200*time.Millisecond {
+		t.Errorf("read %d more bytes in %s, want the other 900 after waiting to resume", len(rest), elapsed)
+	}
+}
+
+func TestObjectReaderChangedETag(t *testing.T) {
+	fastResumes(t, time.Millisecond, time.Millisecond)
+	os := &objectServer{data: testObject(1000), etags: []string{`"e-1"`, `"e-2"`}, cuts: []int64{100}}
+	r := openTestObject(t, os, 5)
+
+	got, err := io.ReadAll(r)
+	var respErr *awshttp.ResponseError
+	if !errors.As(err, &respErr) || respErr.HTTPStatusCode() != http.StatusPreconditionFailed || !strings.Contains(err.Error(), "at byte 100") {
+		t.Fatalf("read error %v, want the replaced object's 412 at byte 100", err)
+	}
+	// the replaced object isn't retried
+	if len(got) != 100 || len(os.ranges) != 2 {
+		t.Errorf("read %d bytes in %d requests, want the 100 of the first", len(got), len(os.ranges))
+	}
+}
+
+func TestObjectReaderMaxRetries(t *testing.T) {
+	fastResumes(t, time.Millisecond, time.Millisecond)
+	// every resumed read is reset before it reads anything
+	os := &objectServer{data: testObject(1000), etags: []string{`"e-1"`}, cuts: []int64{100, 100, 100, 100, 100}}
+	r := openTestObject(t, os, 3)
+
+	got, err := io.ReadAll(r)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || len(got) != 100 {
+		t.Fatalf("read %d bytes and %v, want 100 then an unexpected EOF", len(got), err)
+	}
+	if r.Resumes() != 3 || len(os.ranges) != 4 {
+		t.Errorf("%d resumes in %d requests, want 3 after the first", r.Resumes(), len(os.ranges))
+	}
+
+	// progress resets the count
+	os = &objectServer{data: testObject(1000), etags: []string{`"e-1"`}, cuts: []int64{100, 100, 200, 200, 300, 300}}
+	r = openTestObject(t, os, 2)
+	if got, err := io.ReadAll(r); err != nil || len(got) != 1000 {
+		t.Errorf("read %d bytes and %v, want all 1000", len(got), err)
+	}
+}
+
+func TestResumeWait(t *testing.T) {
+	fastResumes(t, 500*time.Millisecond, 30*time.Second)
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 500 * time.Millisecond},
+		{1, 500 * time.Millisecond},
+		{2, time.Second},
+		{4, 4 * time.Second},
+		{7, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := resumeWait(tt.failures); got != tt.want {
+			t.Errorf("resumeWait(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
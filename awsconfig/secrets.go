@@ -0,0 +1,40 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+//SecretsClient reads secrets from AWS Secrets Manager and SSM Parameter Store with SDK clients of one region
+type SecretsClient struct {
+	SecretsManager *secretsmanager.Client
+	SSM            *ssm.Client
+}
+
+//GetSecretValue returns the current value of the Secrets Manager secret id, its name or ARN
+// A binary secret's value is returned as its bytes.
+func (c *SecretsClient) GetSecretValue(ctx context.Context, id string) (string, error) {
+	out, err := c.SecretsManager.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", fmt.Errorf("GetSecretValue: %s: %w", id, err)
+	}
+	if out.SecretBinary != nil {
+		return string(out.SecretBinary), nil
+	}
+	return aws.ToString(out.SecretString), nil
+}
+
+//GetParameter returns the value of the SSM parameter name, decrypting a SecureString
+func (c *SecretsClient) GetParameter(ctx context.Context, name string) (string, error) {
+	out, err := c.SSM.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", fmt.Errorf("GetParameter: %s: %w", name, err)
+	}
+	if out.Parameter == nil {
+		return "", fmt.Errorf("GetParameter: %s: no parameter returned", name)
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/awsclients"
+)
+
+//components of a run whose AWS clients may assume a role of their own
+const (
+	awsInput  = "input"
+	awsOutput = "output"
+)
+
+//newAWSSession creates the session of the AWS clients of component, awsInput or awsOutput, from the
+// -region, -aws-* and role flags
+// The component's role is -input-role-arn or -output-role-arn, or else -role-arn, so a run may read
+// from one account and write to another.
+func newAWSSession(ctx context.Context, component string) (*awsclients.Session, error) {
+	cfg := awsclients.Config{
+		Region:             awsRegion,
+		Profile:            awsProfile,
+		RoleARN:            roleARN,
+		ExternalID:         roleExternalID,
+		RoleDuration:       roleDuration,
+		MaxRetries:         awsMaxRetries,
+		EndpointURL:        awsEndpointURL,
+		S3PathStyle:        awsS3PathStyle,
+		InsecureSkipVerify: awsInsecure,
+	}
+	if arn := componentRoles[component]; *arn != "" {
+		cfg.RoleARN = *arn
+	}
+	// the session takes 0 retries for the default
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = -1
+	}
+
+	if cfg.InsecureSkipVerify {
+		logger.Warnf("the TLS certificates of the %s's AWS endpoints aren't verified", component)
+	}
+	s, err := awsclients.New(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("newAWSSession: %s: %w", component, err)
+	}
+	return s, nil
+}
+
+//componentRoles are the flags naming the role of each component
+var componentRoles = map[string]*string{
+	awsInput:  &inputRoleARN,
+	awsOutput: &outputRoleARN,
+}
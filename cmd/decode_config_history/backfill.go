@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//backfillObject is a snapshot object of a backfill, as its checkpoint records it
+type backfillObject struct {
+	Account      string `json:"account"`
+	Region       string `json:"region"`
+	BucketRegion string `json:"bucketRegion,omitempty"`
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	ETag         string `json:"etag,omitempty"`
+}
+
+//backfillState is the -checkpoint file of a backfill: the snapshots it decodes, fixed when it starts,
+// the items of those it's done, and the errors of those that failed, to be decoded again on resume
+type backfillState struct {
+	Started time.Time         `json:"started"`
+	Updated time.Time         `json:"updated"`
+	Objects []backfillObject  `json:"objects"`
+	Done    map[string]int    `json:"done"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+//backfill checkpoints the snapshots an orchestrate run decodes to its -checkpoint file, every
+// -checkpoint-interval and when the run ends, so an interrupted backfill resumes where it left off
+type backfill struct {
+	path     string
+	interval time.Duration
+	resumed  bool
+
+	mu    sync.Mutex
+	state backfillState
+	dirty bool
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+//openBackfill opens the backfill checkpointed to path, resuming it if the file exists
+func openBackfill(path string, interval time.Duration) (*backfill, error) {
+	b := &backfill{path: path, interval: interval,
+		state: backfillState{Done: make(map[string]int), Failed: make(map[string]string)}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("openBackfill: %w", err)
+	}
+	if err := json.Unmarshal(data, &b.state); err != nil {
+		return nil, fmt.Errorf("openBackfill: %s: %w", path, err)
+	}
+	if b.state.Done == nil {
+		b.state.Done = make(map[string]int)
+	}
+	if b.state.Failed == nil {
+		b.state.Failed = make(map[string]string)
+	}
+	b.resumed = true
+	return b, nil
+}
+
+//snapshots returns the function listing the snapshots of a resumed backfill, for orchestrate, with a
+// client of session for each region their buckets are in
+func (b *backfill) snapshots() (func(ctx context.Context, o *orchestration) []snapshotObject, error) {
+	session, err := newAWSSession(context.Background(), awsInput)
+	if err != nil {
+		return nil, &exitError{code: exitInput, err: fmt.Errorf("backfill: %w", err)}
+	}
+	clients := make(map[string]*awsconfig.S3Client)
+	snapshots := make([]snapshotObject, 0, len(b.state.Objects))
+	for _, obj := range b.state.Objects {
+		client, ok := clients[obj.BucketRegion]
+		if !ok {
+			if client, err = session.S3Client(obj.BucketRegion); err != nil {
+				return nil, &exitError{code: exitInput, err: fmt.Errorf("backfill: %w", err)}
+			}
+			clients[obj.BucketRegion] = client
+		}
+		snapshots = append(snapshots, snapshotObject{obj.Account, obj.Region, client, obj.BucketRegion, obj.Bucket, obj.Key, obj.ETag})
+	}
+
+	return func(ctx context.Context, o *orchestration) []snapshotObject {
+		for _, so := range snapshots {
+			o.target(so.account, so.region)
+		}
+		logger.Infof("resuming the backfill of %d snapshots started %s", len(snapshots), b.state.Started.Format(time.RFC3339))
+		return snapshots
+	}, nil
+}
+
+//plan returns the snapshots left to decode, recording the snapshots of a new backfill in its checkpoint,
+// and starts checkpointing every interval until finish is called
+func (b *backfill) plan(ctx context.Context, snapshots []snapshotObject) ([]snapshotObject, error) {
+	b.mu.Lock()
+	if !b.resumed {
+		b.state.Started = time.Now().UTC()
+		b.state.Objects = make([]backfillObject, 0, len(snapshots))
+		for _, so := range snapshots {
+			b.state.Objects = append(b.state.Objects, backfillObject{so.account, so.region, so.clientRegion, so.bucket, so.key, so.etag})
+		}
+		b.dirty = true
+	}
+	var left []snapshotObject
+	for _, so := range snapshots {
+		if _, done := b.state.Done[so.name()]; !done {
+			left = append(left, so)
+		}
+	}
+	logger.Infof("backfill: %d of %d snapshots done, %d failed, %d to decode",
+		len(b.state.Done), len(b.state.Objects), len(b.state.Failed), len(left))
+	b.mu.Unlock()
+
+	// a new backfill's snapshots are checkpointed before any are decoded, so it resumes with the same ones
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+	b.stop = make(chan struct{})
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.checkpointEvery(ctx)
+	}()
+	return left, nil
+}
+
+//checkpointEvery saves the checkpoint every interval, if a snapshot has been decoded since it was saved,
+// until ctx is done or finish is called
+// A checkpoint that fails to save is logged, and tried again at the next interval.
+func (b *backfill) checkpointEvery(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.save(); err != nil {
+				logger.Errorf("error saving the backfill checkpoint: %s", err)
+			}
+		case <-b.stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//decoded records the result of decoding a snapshot
+// A snapshot interrupted by the run's timeout or cancellation is neither done nor failed, so it's decoded
+// again on resume, like one not yet decoded; one that reached its -file-timeout failed.
+func (b *backfill) decoded(so snapshotObject, result runResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	name := so.name()
+	switch {
+	case result.Err == nil:
+		b.state.Done[name] = result.ItemCount
+		delete(b.state.Failed, name)
+	case errorCategory(result.Err) == errTimeout, errorCategory(result.Err) == errCanceled:
+		return
+	default:
+		b.state.Failed[name] = result.Err.Error()
+	}
+	b.dirty = true
+}
+
+//save writes the checkpoint, if it's changed since it was last saved, replacing the file atomically
+func (b *backfill) save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.dirty {
+		return nil
+	}
+	b.state.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(b.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("backfill: %w", err)
+	}
+
+	out, err := createOutput(b.path, fileOptions{})
+	if err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("backfill: checkpoint: %w", err)}
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.abort()
+		return &exitError{code: exitWrite, err: fmt.Errorf("backfill: checkpoint: %w", err)}
+	}
+	if err := out.commit(); err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("backfill: checkpoint: %w", err)}
+	}
+	b.dirty = false
+	logger.Infof("backfill checkpoint: %d of %d snapshots done, %d failed",
+		len(b.state.Done), len(b.state.Objects), len(b.state.Failed))
+	return nil
+}
+
+//finish stops checkpointing every interval, and saves the checkpoint a last time
+func (b *backfill) finish() error {
+	close(b.stop)
+	b.wg.Wait()
+	return b.save()
+}
+
+//runBackfill implements the backfill subcommand, decoding a large set of snapshots in S3 over a long
+// run, or many, as orchestrate does, checkpointing the snapshots done to the -checkpoint file
+// The snapshots are those of the -manifest's accounts and regions from -from to -to, or under an
+// s3:// -file prefix, that -since, -until and -latest-per-region select when the backfill starts; a
+// -checkpoint file that exists resumes its backfill, decoding the snapshots it hasn't done. -concurrency
+// snapshots are decoded at once, and -rate-limit limits the items written a second over all of them.
+func runBackfill(args []string) error {
+	start := time.Now()
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	switch {
+	case checkpointFile == "":
+		return fmt.Errorf("backfill: -checkpoint is required")
+	case checkpointInterval <= 0:
+		return fmt.Errorf("backfill: -checkpoint-interval %s is not positive", checkpointInterval)
+	case rateLimit < 0:
+		return fmt.Errorf("backfill: -rate-limit %g is negative", rateLimit)
+	case summaryFormat != "text" && summaryFormat != "json":
+		return fmt.Errorf("unknown summary format %q", summaryFormat)
+	}
+
+	b, err := openBackfill(checkpointFile, checkpointInterval)
+	if err != nil {
+		return err
+	}
+	var find func(ctx context.Context, o *orchestration) []snapshotObject
+	switch {
+	case b.resumed:
+		if manifestFile != "" || strings.HasPrefix(inputFile, "s3://") {
+			logger.Warnf("resuming the backfill of %s; its snapshots were chosen when it started, so -manifest and -file are ignored",
+				checkpointFile)
+		}
+		find, err = b.snapshots()
+	case manifestFile != "":
+		find, err = manifestSnapshots()
+	case strings.HasPrefix(inputFile, "s3://"):
+		find, err = prefixSnapshots()
+	default:
+		return fmt.Errorf("backfill: a new backfill needs a -manifest, or an s3:// -file prefix")
+	}
+	if err != nil {
+		return err
+	}
+	return orchestrate(start, find, b)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//readConfigFile reads a yaml, toml or json settings file, chosen by its extension
+func readConfigFile(name string) (map[string]any, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("readConfigFile: %w", err)
+	}
+
+	settings := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &settings)
+	case ".toml":
+		err = toml.Unmarshal(b, &settings)
+	case ".json":
+		err = json.Unmarshal(b, &settings)
+	default:
+		return nil, fmt.Errorf("readConfigFile: %s: want a .yaml, .toml or .json file", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readConfigFile: %s: %w", name, err)
+	}
+	return settings, nil
+}
+
+//applyConfigFile sets flags from the settings in the -config file
+// Settings are named after flags, e.g. pool-size or opensearch-url; flags given on the
+// command line override them. The spec setting holds a transform spec, as in a -spec file, and the specs
+// setting the spec profiles chosen for the input files they match.
+func applyConfigFile(name string) error {
+	settings, err := readConfigFile(name)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, k := range keys {
+		v := settings[k]
+		if k == "spec" {
+			if _, err := configFileSpec(v); err != nil {
+				problems = append(problems, err.Error())
+			}
+			continue
+		}
+		if k == "specs" {
+			if _, err := configFileProfiles(v); err != nil {
+				problems = append(problems, err.Error())
+			}
+			continue
+		}
+
+		f := flag.Lookup(k)
+		if f == nil || k == "config" {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", k))
+			continue
+		}
+		if explicit[k] {
+			continue
+		}
+		for _, value := range settingStrings(f, v) {
+			if err := f.Value.Set(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", k, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("applyConfigFile: %s: %s", name, strings.Join(problems, "; "))
+	}
+	appliedSettings = flagSettings(settings, explicit)
+	return nil
+}
+
+//appliedSettings are the settings of the -config file applyConfigFile set flags from
+var appliedSettings map[string]any
+
+//flagSettings returns the settings that set flags, those other than spec and specs and the flags in explicit
+func flagSettings(settings map[string]any, explicit map[string]bool) map[string]any {
+	flags := make(map[string]any, len(settings))
+	for k, v := range settings {
+		if k != "spec" && k != "specs" && !explicit[k] {
+			flags[k] = v
+		}
+	}
+	return flags
+}
+
+//checkConfigFile reads the -config file again, as serve does to reload it, checking its spec and specs
+// are valid and its other settings are those applied at startup
+// Flags can't be set again while files are being decoded, so changing them needs a restart.
+func checkConfigFile(name string) error {
+	settings, err := readConfigFile(name)
+	if err != nil {
+		return err
+	}
+	if v, ok := settings["spec"]; ok {
+		if _, err := configFileSpec(v); err != nil {
+			return fmt.Errorf("checkConfigFile: %s: %w", name, err)
+		}
+	}
+	if v, ok := settings["specs"]; ok {
+		if _, err := configFileProfiles(v); err != nil {
+			return fmt.Errorf("checkConfigFile: %s: %w", name, err)
+		}
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	flags := flagSettings(settings, explicit)
+	var changed []string
+	for k := range flags {
+		if _, ok := appliedSettings[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	for k, v := range appliedSettings {
+		if w, ok := flags[k]; !ok || !reflect.DeepEqual(v, w) {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		return fmt.Errorf("checkConfigFile: %s: settings %s changed, which take effect on restart",
+			name, strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+//configFileSpec converts the spec setting to a transform spec
+func configFileSpec(v any) (config_decoder.ItemTransformSpec, error) {
+	var spec config_decoder.ItemTransformSpec
+	b, err := json.Marshal(v)
+	if err != nil {
+		return spec, fmt.Errorf("spec: %w", err)
+	}
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return spec, fmt.Errorf("spec: %w", err)
+	}
+	if spec.ItemsField == "" {
+		return spec, fmt.Errorf("spec: ItemsField is required")
+	}
+	return spec, nil
+}
+
+//settingString formats a setting as a flag value; lists become comma-separated values
+func settingString(v any) string {
+	if list, ok := v.([]any); ok {
+		s := make([]string, len(list))
+		for i, e := range list {
+			s[i] = fmt.Sprint(e)
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+//settingStrings formats a setting as the values of flag f
+// A repeatable flag is set once per element of a list, or per key of a table as key=value.
+func settingStrings(f *flag.Flag, v any) []string {
+	if _, ok := f.Value.(*optionList); !ok {
+		return []string{settingString(v)}
+	}
+
+	var values []string
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			values = append(values, fmt.Sprint(e))
+		}
+	case map[string]any:
+		for k, e := range v {
+			values = append(values, k+"="+fmt.Sprint(e))
+		}
+		sort.Strings(values)
+	default:
+		values = append(values, fmt.Sprint(v))
+	}
+	return values
+}
+
+//runConfig implements the config subcommand
+// config validate checks a settings file without decoding anything.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("usage: %s config validate [-config] file", filepath.Base(os.Args[0]))
+	}
+
+	args = args[1:]
+	if len(args) == 1 && !strings.HasPrefix(args[0], "-") {
+		args = []string{"-config", args[0]}
+	}
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if configFile == "" {
+		return fmt.Errorf("config validate: no -config file given")
+	}
+
+	if err := validateSettings(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s is valid\n", configFile)
+	return nil
+}
+
+//validateSettings checks the settings are usable, without contacting any sink or reading input
+func validateSettings() error {
+	if _, err := loadValidSpec(specFile); err != nil {
+		return err
+	}
+
+	if rateLimit < 0 {
+		return fmt.Errorf("validateSettings: -rate-limit %g is negative", rateLimit)
+	}
+	if (writerHTTP.CertFile == "") != (writerHTTP.KeyFile == "") {
+		return fmt.Errorf("validateSettings: -writer-cert and -writer-key must be given together")
+	}
+	switch writerKind {
+	case "null", "file", "opensearch", "neo4j", "sentinel":
+	case "chronicle":
+		if chronicle.CustomerID == "" || chronicleKey == "" {
+			return fmt.Errorf("validateSettings: -writer chronicle requires -chronicle-customer-id and -chronicle-credentials")
+		}
+	case "securitylake":
+		if !strings.HasPrefix(securityLake, "s3://") || lakeFileSize <= 0 {
+			return fmt.Errorf("validateSettings: -writer securitylake requires an s3:// -security-lake and a positive file size")
+		}
+	case "kafka":
+		if kafkaConfig.Format == "avro" && kafkaConfig.SchemaRegistry == "" {
+			return fmt.Errorf("validateSettings: -kafka-format avro requires -schema-registry")
+		}
+	default:
+		return fmt.Errorf("validateSettings: unknown writer type %q", writerKind)
+	}
+
+	if (serveMode || watchMode) && watchDir == "" {
+		return fmt.Errorf("validateSettings: -watch-dir is required in serve and watch modes")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"strings"
+	"time"
+)
+
+//decodeConfigAPI queries current resource configuration from the AWS Config service
+// and decodes the results as if they were the items of a snapshot.
+// Items are read from the aggregator named by -aggregator, or from the account if it's empty;
+// with -batch-get the query selects only the resources' keys, and their items are fetched.
+func decodeConfigAPI(ctx context.Context, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory, poolSpec config_decoder.PoolSpec) runResult {
+	start := time.Now()
+	result := runResult{File: "config-api"}
+
+	session, err := newAWSSession(ctx, awsInput)
+	if err != nil {
+		result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+		return result
+	}
+	client, err := session.ConfigClient("")
+	if err != nil {
+		result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+		return result
+	}
+
+	var types []string
+	for _, t := range strings.Split(resourceTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" && t != "all" {
+			types = append(types, t)
+		}
+	}
+	q := awsconfig.Query{Expression: awsconfig.ResourceQuery(types), Aggregator: aggregator, Fetch: batchGet}
+	if q.Fetch {
+		q.Expression = awsconfig.KeyQuery(types)
+	}
+	if q.Aggregator != "" {
+		result.File = "aggregator " + q.Aggregator
+	}
+	spec.Source = result.File
+	defer activeInputs.decoding(result.File)()
+
+	in := awsconfig.NewItemsReader(ctx, client, q, spec.ItemsField)
+	defer in.Close()
+	inCounter := &countingReader{r: in}
+
+	logger.Infof("querying AWS Config in %s: %s", client.Region, q.Expression)
+
+	// the query results stand in for a snapshot, so there are no parent fields to copy
+	spec.Fields = nil
+	chStatus, chErrors := config_decoder.DecodeAndSplitItems(ctx, bufio.NewReader(inCounter), wFactory, poolSpec, spec)
+
+	awaitResult(ctx, chStatus, chErrors, poolSpec.Size, &result)
+	result.InputBytes = inCounter.n.Load()
+	result.DocumentBytes = result.InputBytes
+	result.Duration = time.Since(start)
+	return result
+}
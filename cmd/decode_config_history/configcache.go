@@ -0,0 +1,35 @@
+package main
+
+import "github.com/mfrasier/decode_json_stream/config_decoder"
+
+//configCache is the run's -config-cache, created by the first spec loaded
+var configCache *config_decoder.ConfigurationCache
+
+//configCacheSummary counts the configurations found in the -config-cache, and those decoded
+type configCacheSummary struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+//configCacheOf returns the run's -config-cache, creating it if need be, or nil if there's none
+func configCacheOf() *config_decoder.ConfigurationCache {
+	if configCache == nil && configCacheSize > 0 {
+		configCache = config_decoder.NewConfigurationCache(int64(configCacheSize) << 20)
+	}
+	return configCache
+}
+
+//configCacheCounts returns the hits and misses of the -config-cache, or nil if there's none
+func configCacheCounts() *configCacheSummary {
+	if configCache == nil {
+		return nil
+	}
+	return &configCacheSummary{Hits: configCache.Hits(), Misses: configCache.Misses()}
+}
+
+//logConfigCache logs how well the -config-cache did, if there's one
+func logConfigCache() {
+	if configCache != nil {
+		logger.Infof("configuration cache: %s", configCache)
+	}
+}
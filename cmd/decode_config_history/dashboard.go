@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//dashboardRecent is the number of recent resource types the dashboard lists
+const dashboardRecent = 8
+
+//dashboardRefresh is how often the dashboard is refreshed
+const dashboardRefresh = time.Second
+
+//dashboard is the -dashboard live view of a decode, a bubbletea program shown on a terminal in place of
+// the progress bar
+// It shows each writer pool worker's items, errors and whether it's busy writing, from the pool's live
+// stats, and the resource types of the items written most recently. The item channel is unbuffered, so
+// the queue of items waiting to be written is the workers' backlog: when all are busy, the decoder
+// waits on them.
+type dashboard struct {
+	mu     sync.Mutex
+	stats  *config_decoder.PoolStats
+	recent [dashboardRecent]string
+	next   int
+}
+
+//saw records the resource type of an item written
+func (d *dashboard) saw(resourceType string) {
+	d.mu.Lock()
+	d.recent[d.next%dashboardRecent] = resourceType
+	d.next++
+	d.mu.Unlock()
+}
+
+//recentTypes returns the resource types of the items written most recently, the latest first
+func (d *dashboard) recentTypes() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var types []string
+	for i := 1; i <= dashboardRecent && i <= d.next; i++ {
+		types = append(types, d.recent[(d.next-i)%dashboardRecent])
+	}
+	return types
+}
+
+//run shows the dashboard of p on stderr until done is closed, refreshing it a last time before returning
+// It reads no input, which may be the document decoded, and leaves signals to the command.
+func (d *dashboard) run(p *progress, done <-chan struct{}) error {
+	program := tea.NewProgram(dashboardModel{p: p, last: make(map[int]int)},
+		tea.WithOutput(os.Stderr), tea.WithInput(nil), tea.WithoutSignalHandler())
+	go func() {
+		<-done
+		program.Send(dashboardDone{})
+	}()
+	_, err := program.Run()
+	return err
+}
+
+//dashboardTick refreshes the dashboard
+type dashboardTick time.Time
+
+//dashboardDone refreshes the dashboard a last time, once decoding's done
+type dashboardDone struct{}
+
+//workerRow is a worker's line on the dashboard
+type workerRow struct {
+	worker int
+	items  int
+	rate   float64
+	errors int
+	busy   bool
+}
+
+//dashboardModel is the bubbletea model of the dashboard, as of its last refresh
+type dashboardModel struct {
+	p       *progress
+	status  string
+	bar     string
+	elapsed time.Duration
+	workers []workerRow
+	recent  []string
+	// last is each worker's items as of the previous refresh, at refreshed, for its items/sec
+	last      map[int]int
+	refreshed time.Time
+}
+
+// Init implements tea.Model for dashboardModel
+func (m dashboardModel) Init() tea.Cmd {
+	return func() tea.Msg { return dashboardTick(time.Now()) }
+}
+
+// Update implements tea.Model for dashboardModel
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case dashboardTick:
+		m = m.refresh(time.Time(msg))
+		return m, tea.Tick(dashboardRefresh, func(t time.Time) tea.Msg { return dashboardTick(t) })
+	case dashboardDone:
+		return m.refresh(time.Now()), tea.Quit
+	}
+	return m, nil
+}
+
+//refresh reads the progress and the workers' live stats at now
+func (m dashboardModel) refresh(now time.Time) dashboardModel {
+	since := now.Sub(m.refreshed).Seconds()
+	if m.refreshed.IsZero() {
+		since = 0
+	}
+	m.refreshed = now
+	m.status, m.bar, m.elapsed = m.p.status(), m.p.bar(), now.Sub(m.p.start)
+
+	m.workers = m.workers[:0:0]
+	for _, ws := range m.p.dash.stats.Snapshot() {
+		row := workerRow{worker: ws.WorkerNum, items: ws.ItemCount, errors: ws.ErrorCount, busy: ws.Status == "busy"}
+		if since > 0 {
+			row.rate = float64(ws.ItemCount-m.last[ws.WorkerNum]) / since
+		}
+		m.last[ws.WorkerNum] = ws.ItemCount
+		m.workers = append(m.workers, row)
+	}
+	m.recent = m.p.dash.recentTypes()
+	return m
+}
+
+// View implements tea.Model for dashboardModel
+func (m dashboardModel) View() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "decoding, run %s, %s elapsed\n", runID, m.elapsed.Round(time.Second))
+	fmt.Fprintf(&b, "[%s] %s\n\n", m.bar, m.status)
+
+	busy := 0
+	fmt.Fprintf(&b, "%6s %12s %12s %8s  %s\n", "worker", "items", "items/sec", "errors", "state")
+	for _, w := range m.workers {
+		state := "idle"
+		if w.busy {
+			state = "busy"
+			busy++
+		}
+		fmt.Fprintf(&b, "%6d %12d %12.0f %8d  %s\n", w.worker, w.items, w.rate, w.errors, state)
+	}
+	fmt.Fprintf(&b, "\nqueue: %d of %d workers busy\n\n", busy, len(m.workers))
+
+	b.WriteString("recent resource types:\n")
+	for _, t := range m.recent {
+		fmt.Fprintf(&b, "  %s\n", t)
+	}
+	return b.String()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardModel(t *testing.T) {
+	p := &progress{dash: &dashboard{stats: config_decoder.NewPoolStats()}, start: time.Now()}
+	for _, rt := range []string{"AWS::S3::Bucket", "AWS::EC2::Instance"} {
+		p.items.Add(1)
+		p.dash.saw(rt)
+	}
+
+	var m tea.Model = dashboardModel{p: p, last: make(map[int]int)}
+	m, cmd := m.Update(dashboardTick(time.Now()))
+	if cmd == nil {
+		t.Error("a tick didn't schedule the next")
+	}
+	view := m.View()
+	if !strings.Contains(view, "2 items") {
+		t.Errorf("view without the items written:\n%s", view)
+	}
+	if first, second := strings.Index(view, "AWS::EC2::Instance"), strings.Index(view, "AWS::S3::Bucket"); first < 0 || second < first {
+		t.Errorf("view without the recent resource types, latest first:\n%s", view)
+	}
+
+	// once decoding's done, the program quits
+	_, cmd = m.Update(dashboardDone{})
+	if cmd == nil {
+		t.Fatal("done didn't quit")
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("done didn't quit")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/schema"
+	"github.com/mfrasier/decode_json_stream/securitylake"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+//jsonSerde reads newline-delimited json, as written by the file writer
+const jsonSerde = "org.openx.data.jsonserde.JsonSerDe"
+
+//parquetSerde, with its input and output formats, reads Parquet, as written by the securitylake writer
+const (
+	parquetSerde        = "org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe"
+	parquetInputFormat  = "org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat"
+	parquetOutputFormat = "org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat"
+)
+
+//firstEventDay is the first day projected of a Security Lake table's eventday partitions, when AWS
+// Config was launched
+const firstEventDay = "20141112"
+
+//tableDef is a table to define over the objects at location
+// Partitioned tables are of Parquet objects, whose partitions Athena projects from Projection.
+type tableDef struct {
+	Database   string
+	Name       string
+	Location   string
+	Columns    []schema.Column
+	Partitions []schema.Column
+	Projection map[string]string
+}
+
+//runDDL implements the ddl subcommand, which prints a table definition for decoded items
+// With -writer file, the columns are inferred from a sample of items it wrote; with -writer securitylake,
+// they're those of the events it writes, partitioned as it writes them.
+func runDDL(args []string) error {
+	fs := flag.NewFlagSet("ddl", flag.ContinueOnError)
+	target := fs.String("writer", "file", "writer the items were written with [file|securitylake]")
+	sample := fs.String("sample", "-", "file of decoded items, one json object per line (- is stdin)")
+	sampleSize := fs.Int("sample-size", 1000, "items to read from -sample (0 reads all)")
+	table := fs.String("table", "config_items", "table name")
+	database := fs.String("database", "default", "database name")
+	location := fs.String("location", "", "s3:// location of the decoded items (required)")
+	format := fs.String("format", "athena", "output format [athena|glue]")
+	maxFields := fs.Int("max-fields", 100, "objects with more fields than this become string columns (0 is unlimited)")
+	if err := parseFlags(fs, "ddl", args); err != nil {
+		return err
+	}
+
+	if *location == "" {
+		return fmt.Errorf("ddl: -location is required")
+	}
+
+	def := tableDef{Database: *database, Name: *table, Location: *location}
+	switch *target {
+	case "file":
+		cols, err := sampleColumns(*sample, *sampleSize, *maxFields)
+		if err != nil {
+			return fmt.Errorf("ddl: %w", err)
+		}
+		def.Columns = cols
+	case "securitylake":
+		def = lakeTable(def)
+	default:
+		return fmt.Errorf("ddl: unknown writer %q", *target)
+	}
+
+	var err error
+	switch *format {
+	case "athena":
+		_, err = fmt.Fprint(os.Stdout, athenaDDL(def))
+	case "glue":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(glueTableInput(def))
+	default:
+		return fmt.Errorf("ddl: unknown format %q", *format)
+	}
+	return err
+}
+
+//sampleColumns infers the columns of up to size items of the file sample
+func sampleColumns(sample string, size, maxFields int) ([]schema.Column, error) {
+	var r io.Reader = os.Stdin
+	if sample != "-" {
+		f, err := os.Open(sample)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	t, n, err := schema.Infer(r, size)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "inferred %d columns from %d items\n", len(t.Fields), n)
+	return t.Columns(schema.HiveOptions{MaxFields: maxFields}), nil
+}
+
+//lakeTable completes def as the table of a Security Lake custom source at its location
+// Region and account are injected, so queries must name them; event days are projected as dates.
+func lakeTable(def tableDef) tableDef {
+	if !strings.HasSuffix(def.Location, "/") {
+		def.Location += "/"
+	}
+	def.Columns = securitylake.Columns()
+	def.Partitions = securitylake.PartitionKeys
+	def.Projection = map[string]string{
+		"projection.enabled":                "true",
+		"projection.region.type":            "injected",
+		"projection.accountid.type":         "injected",
+		"projection.eventday.type":          "date",
+		"projection.eventday.format":        "yyyyMMdd",
+		"projection.eventday.range":         firstEventDay + ",NOW",
+		"projection.eventday.interval":      "1",
+		"projection.eventday.interval.unit": "DAYS",
+		"storage.location.template":         def.Location + securitylake.PartitionTemplate,
+	}
+	return def
+}
+
+//serdeMappings maps columns to item fields where their names differ
+func serdeMappings(cols []schema.Column) map[string]string {
+	m := make(map[string]string)
+	for _, c := range cols {
+		if c.Name != strings.ToLower(c.Key) {
+			m["mapping."+c.Name] = c.Key
+		}
+	}
+	return m
+}
+
+//columnList renders cols as the column list of a CREATE EXTERNAL TABLE statement
+func columnList(b *strings.Builder, cols []schema.Column) {
+	b.WriteString("(\n")
+	for i, c := range cols {
+		sep := ","
+		if i == len(cols)-1 {
+			sep = ""
+		}
+		fmt.Fprintf(b, "  `%s` %s%s\n", c.Name, c.Type, sep)
+	}
+	b.WriteString(")\n")
+}
+
+//athenaDDL renders a CREATE EXTERNAL TABLE statement for Athena
+func athenaDDL(def tableDef) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE EXTERNAL TABLE IF NOT EXISTS `%s`.`%s` ", def.Database, def.Name)
+	columnList(&b, def.Columns)
+
+	if len(def.Partitions) > 0 {
+		b.WriteString("PARTITIONED BY ")
+		columnList(&b, def.Partitions)
+		b.WriteString("STORED AS PARQUET\n")
+		fmt.Fprintf(&b, "LOCATION '%s'\n", def.Location)
+		keys := make([]string, 0, len(def.Projection))
+		for k := range def.Projection {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		props := make([]string, len(keys))
+		for i, k := range keys {
+			props[i] = fmt.Sprintf("'%s' = '%s'", k, def.Projection[k])
+		}
+		fmt.Fprintf(&b, "TBLPROPERTIES (\n  %s\n);\n", strings.Join(props, ",\n  "))
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "ROW FORMAT SERDE '%s'\n", jsonSerde)
+	props := []string{"'ignore.malformed.json' = 'true'"}
+	mappings := serdeMappings(def.Columns)
+	for _, c := range def.Columns {
+		if key, ok := mappings["mapping."+c.Name]; ok {
+			props = append(props, fmt.Sprintf("'mapping.%s' = '%s'", c.Name, key))
+		}
+	}
+	fmt.Fprintf(&b, "WITH SERDEPROPERTIES (\n  %s\n)\n", strings.Join(props, ",\n  "))
+	fmt.Fprintf(&b, "LOCATION '%s';\n", def.Location)
+	return b.String()
+}
+
+//glueColumns renders cols as Glue columns
+func glueColumns(cols []schema.Column) []map[string]string {
+	columns := make([]map[string]string, len(cols))
+	for i, c := range cols {
+		columns[i] = map[string]string{"Name": c.Name, "Type": c.Type}
+	}
+	return columns
+}
+
+//glueTableInput renders the TableInput of a Glue CreateTable request,
+// as taken by aws glue create-table --table-input
+func glueTableInput(def tableDef) map[string]any {
+	if len(def.Partitions) > 0 {
+		params := map[string]string{"classification": "parquet"}
+		for k, v := range def.Projection {
+			params[k] = v
+		}
+		return map[string]any{
+			"Name":          def.Name,
+			"TableType":     "EXTERNAL_TABLE",
+			"Parameters":    params,
+			"PartitionKeys": glueColumns(def.Partitions),
+			"StorageDescriptor": map[string]any{
+				"Columns":      glueColumns(def.Columns),
+				"Location":     def.Location,
+				"InputFormat":  parquetInputFormat,
+				"OutputFormat": parquetOutputFormat,
+				"SerdeInfo": map[string]any{
+					"SerializationLibrary": parquetSerde,
+					"Parameters":           map[string]string{"serialization.format": "1"},
+				},
+			},
+		}
+	}
+
+	params := serdeMappings(def.Columns)
+	params["ignore.malformed.json"] = "true"
+
+	return map[string]any{
+		"Name":       def.Name,
+		"TableType":  "EXTERNAL_TABLE",
+		"Parameters": map[string]string{"classification": "json"},
+		"StorageDescriptor": map[string]any{
+			"Columns":      glueColumns(def.Columns),
+			"Location":     def.Location,
+			"InputFormat":  "org.apache.hadoop.mapred.TextInputFormat",
+			"OutputFormat": "org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat",
+			"SerdeInfo": map[string]any{
+				"SerializationLibrary": jsonSerde,
+				"Parameters":           params,
+			},
+		},
+	}
+}
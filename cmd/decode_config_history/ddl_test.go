@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/mfrasier/decode_json_stream/schema"
+	"strings"
+	"testing"
+)
+
+func TestAthenaDDL(t *testing.T) {
+	def := tableDef{Database: "db", Name: "items", Location: "s3://bucket/items/", Columns: []schema.Column{
+		{Name: "arn", Key: "ARN", Type: "string"},
+		{Name: "resourcetype", Key: "resourceType", Type: "string"},
+		{Name: "tag_set", Key: "tag-set", Type: "string"},
+	}}
+	want := "CREATE EXTERNAL TABLE IF NOT EXISTS `db`.`items` (\n" +
+		"  `arn` string,\n" +
+		"  `resourcetype` string,\n" +
+		"  `tag_set` string\n" +
+		")\n" +
+		"ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'\n" +
+		"WITH SERDEPROPERTIES (\n" +
+		"  'ignore.malformed.json' = 'true',\n" +
+		"  'mapping.tag_set' = 'tag-set'\n" +
+		")\n" +
+		"LOCATION 's3://bucket/items/';\n"
+	if got := athenaDDL(def); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAthenaDDLSecurityLake(t *testing.T) {
+	def := lakeTable(tableDef{Database: "db", Name: "lake", Location: "s3://lake/ext/config/1.0"})
+	got := athenaDDL(def)
+	for _, want := range []string{
+		"  `time` timestamp,\n",
+		"PARTITIONED BY (\n  `region` string,\n  `accountid` string,\n  `eventday` string\n)\nSTORED AS PARQUET\n",
+		"LOCATION 's3://lake/ext/config/1.0/'\n",
+		"'storage.location.template' = 's3://lake/ext/config/1.0/region=${region}/accountId=${accountid}/eventDay=${eventday}/'",
+		"'projection.eventday.format' = 'yyyyMMdd'",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("no %q in\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "SERDE") {
+		t.Errorf("parquet table has a json serde:\n%s", got)
+	}
+}
+
+func TestGlueTableInput(t *testing.T) {
+	cols := []schema.Column{{Name: "tag_set", Key: "tag-set", Type: "string"}}
+	in := glueTableInput(tableDef{Name: "items", Location: "s3://bucket/items/", Columns: cols})
+	sd := in["StorageDescriptor"].(map[string]any)
+	serde := sd["SerdeInfo"].(map[string]any)
+	if serde["SerializationLibrary"] != jsonSerde {
+		t.Errorf("serde %v", serde["SerializationLibrary"])
+	}
+	if params := serde["Parameters"].(map[string]string); params["mapping.tag_set"] != "tag-set" {
+		t.Errorf("serde parameters %v", params)
+	}
+	if _, ok := in["PartitionKeys"]; ok {
+		t.Errorf("unpartitioned table has partition keys")
+	}
+
+	in = glueTableInput(lakeTable(tableDef{Name: "lake", Location: "s3://lake/ext/config/1.0/"}))
+	sd = in["StorageDescriptor"].(map[string]any)
+	if sd["InputFormat"] != parquetInputFormat || sd["SerdeInfo"].(map[string]any)["SerializationLibrary"] != parquetSerde {
+		t.Errorf("storage %v", sd)
+	}
+	keys := in["PartitionKeys"].([]map[string]string)
+	if len(keys) != 3 || keys[2]["Name"] != "eventday" {
+		t.Errorf("partition keys %v", keys)
+	}
+	if params := in["Parameters"].(map[string]string); params["classification"] != "parquet" || params["projection.enabled"] != "true" {
+		t.Errorf("parameters %v", params)
+	}
+}
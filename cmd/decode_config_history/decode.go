@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/internal/apispec"
+	"github.com/mfrasier/decode_json_stream/transform"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+//runResult summarizes the decoding of one input file
+type runResult struct {
+	File       string
+	ItemCount  int
+	ItemBytes  int
+	InputBytes int64
+	// DocumentBytes is the size of the input once decompressed
+	DocumentBytes int64
+	Workers       []config_decoder.WorkerStatus
+	Duration      time.Duration
+	Err           error
+	// Outputs are the files the file writer completed, for the -run-manifest
+	Outputs []string
+}
+
+//runID identifies this run in the metadata of every item it decodes, and in its summary
+var runID = newRunID()
+
+//ingestClock, if set by -ingest-time, tells the ingest_time of items rather than the system clock;
+// ingestDelivery, set by -ingest-time delivery, tells that of each input's items by its name instead
+var (
+	ingestClock    config_decoder.Clock
+	ingestDelivery bool
+)
+
+//setIngestTime stamps items with the RFC 3339 time v rather than the time they're decoded, or with
+// the time their input was delivered if v is delivery
+func setIngestTime(v string) error {
+	if v == "delivery" {
+		ingestClock, ingestDelivery = nil, true
+		return nil
+	}
+	ingestDelivery = false
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return fmt.Errorf("want an RFC 3339 time such as 2024-05-01T12:00:00Z, not %q", v)
+	}
+	ingestClock = config_decoder.FixedClock(t)
+	return nil
+}
+
+//newRunID returns a random (version 4) UUID
+func newRunID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+//defaultSpec is the transform spec for AWS Config snapshots
+func defaultSpec() config_decoder.ItemTransformSpec {
+	return apispec.ItemTransformSpec(transform.Snapshot())
+}
+
+//loadSpec reads a json transform spec from file name, or returns the default spec if name is ""
+// Without a spec file, a spec given in the -config file is used; it's read again each time,
+// so reloads pick up changes to it. Limits, Decoders, Selection, provenance and the metadata envelope
+// always come from the command line, as may Strict, and the RunID is this run's.
+func loadSpec(name string) (config_decoder.ItemTransformSpec, error) {
+	spec, profiles, err := readSpec(name)
+	if err != nil {
+		return spec, err
+	}
+	setSpecProfiles(profiles)
+	return spec, nil
+}
+
+//loadValidSpec loads the spec as loadSpec does, checking its limits and selection
+// The spec profiles are only replaced once the spec is valid, so a reload that fails keeps those in use.
+func loadValidSpec(name string) (config_decoder.ItemTransformSpec, error) {
+	spec, profiles, err := readSpec(name)
+	if err != nil {
+		return spec, err
+	}
+	if err := spec.Limits.Validate(); err != nil {
+		return spec, err
+	}
+	if err := spec.Selection.Validate(); err != nil {
+		return spec, err
+	}
+	setSpecProfiles(profiles)
+	return spec, nil
+}
+
+//readSpec reads the spec for loadSpec, and the -config file's spec profiles, without setting them
+func readSpec(name string) (config_decoder.ItemTransformSpec, []specProfile, error) {
+	spec := defaultSpec()
+	var profiles []specProfile
+	if name != "" {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return spec, nil, fmt.Errorf("loadSpec: %w", err)
+		}
+
+		spec = config_decoder.ItemTransformSpec{}
+		if err := json.Unmarshal(b, &spec); err != nil {
+			return spec, nil, fmt.Errorf("loadSpec: %s: %w", name, err)
+		}
+		if spec.ItemsField == "" {
+			return spec, nil, fmt.Errorf("loadSpec: %s: ItemsField is required", name)
+		}
+	} else if configFile != "" {
+		settings, err := readConfigFile(configFile)
+		if err != nil {
+			return spec, nil, fmt.Errorf("loadSpec: %w", err)
+		}
+		if v, ok := settings["spec"]; ok {
+			if spec, err = configFileSpec(v); err != nil {
+				return spec, nil, fmt.Errorf("loadSpec: %s: %w", configFile, err)
+			}
+		}
+		if v, ok := settings["specs"]; ok {
+			if profiles, err = configFileProfiles(v); err != nil {
+				return spec, nil, fmt.Errorf("loadSpec: %s: %w", configFile, err)
+			}
+		}
+	}
+
+	if selection.SampleRate > 0 && selection.Seed == 0 {
+		selection.Seed = time.Now().UnixNano()
+		logger.Infof("sampling with -sample-seed %d", selection.Seed)
+	}
+
+	spec.Limits = limits
+	spec.Decoders = decoders
+	spec.Selection = selection
+	spec.NoProvenance = !provenance
+	spec.Envelope = envelope
+	spec.Envelope.Collisions = metadataCollisions
+	skipped, err := skippedFieldsOf()
+	if err != nil {
+		return spec, nil, fmt.Errorf("loadSpec: %w", err)
+	}
+	spec.Skipped = skipped
+	spec.Matches = fieldMatches
+	spec.Configurations = configCacheOf()
+	spec.Strict = spec.Strict || strict
+	spec.Reproducible = canonical
+	spec.UseNumber = useNumber
+	spec.Verbatim = verbatim
+	spec.Clock = ingestClock
+	spec.RunID = runID
+	if itemHash.Field != "" || len(itemHash.Fields) > 0 {
+		spec.Hash = itemHash
+	}
+	if err := spec.Envelope.Validate(); err != nil {
+		return spec, nil, fmt.Errorf("loadSpec: %w", err)
+	}
+	if err := spec.Hash.Validate(); err != nil {
+		return spec, nil, fmt.Errorf("loadSpec: %w", err)
+	}
+	if len(ruleIDs) > 0 || len(ruleFiles) > 0 {
+		fs, err := findingsWriter()
+		if err != nil {
+			return spec, nil, fmt.Errorf("loadSpec: %w", err)
+		}
+		spec.Rules = config_decoder.ItemRules{Rules: ruleIDs, Custom: ruleFiles, Findings: fs}
+	}
+	if err := spec.Rules.Validate(); err != nil {
+		return spec, nil, fmt.Errorf("loadSpec: %w", err)
+	}
+	return spec, profiles, nil
+}
+
+//fileTimeout, set by -file-timeout, bounds the time decoding each file of a run, within -timeout
+var fileTimeout time.Duration
+
+//errFileTimeout is the cause of decoding a file being abandoned at its -file-timeout, rather than the run's -timeout
+var errFileTimeout = fmt.Errorf("-file-timeout reached: %w", context.DeadlineExceeded)
+
+//fileContext returns the context of decoding one file of the run whose context is ctx, ending at any
+// -file-timeout with errFileTimeout as its cause
+func fileContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if fileTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, fileTimeout, errFileTimeout)
+}
+
+//stampDelivery sets the clock of spec, that of input, to the time input was delivered, with -ingest-time
+// delivery, failing an input that isn't named as AWS Config names what it delivers
+func stampDelivery(spec *config_decoder.ItemTransformSpec, input string) error {
+	if !ingestDelivery {
+		return nil
+	}
+	t, ok := deliveryTime(input)
+	if !ok {
+		return fmt.Errorf("-ingest-time delivery: can't tell when %s was delivered, as it isn't named as AWS Config names "+
+			"its snapshots and history files", path.Base(input))
+	}
+	spec.Clock = config_decoder.FixedClock(t)
+	return nil
+}
+
+//decodeFile decodes file name, writing its items with writers from wFactory
+// With -events, the file is a stream of AWS Config change events rather than a snapshot.
+// Decoding is abandoned early if ctx is done, failing with its cause.
+// Progress is reported to prog, unless it's nil.
+func decodeFile(ctx context.Context, name string, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory, poolSpec config_decoder.PoolSpec, prog *progress) runResult {
+	start := time.Now()
+	result := runResult{File: name}
+	spec.Source = name
+	defer activeInputs.decoding(name)()
+	if err := stampDelivery(&spec, name); err != nil {
+		result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+		return result
+	}
+
+	// handle memory-mapped, gzipped or uncompressed files
+	// gzip input is decompressed in parallel blocks
+	var r io.Reader
+	var mapped *config_decoder.MappedFile
+	var at *io.SectionReader
+	inCounter := &countingReader{}
+	docCounter := inCounter
+	if useMmap {
+		if eventsMode {
+			result.Err = fmt.Errorf("%w: -mmap doesn't read -events", errInputFailed)
+			return result
+		}
+		if strings.HasSuffix(name, ".gz") {
+			result.Err = fmt.Errorf("%w: -mmap requires an uncompressed input file", errInputFailed)
+			return result
+		}
+		m, err := config_decoder.OpenMmap(name)
+		if err != nil {
+			result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+			return result
+		}
+		defer m.Close()
+		mapped = m
+		prefix := make([]byte, 4)
+		n, _ := m.ReadAt(prefix, 0)
+		switch enc, bom := config_decoder.DetectEncoding(prefix[:n]); enc {
+		case config_decoder.EncodingUTF8BOM:
+			logger.Info("skipping the input's byte order mark")
+			at = io.NewSectionReader(m, int64(bom), m.Len()-int64(bom))
+		case config_decoder.EncodingUTF16LE, config_decoder.EncodingUTF16BE:
+			result.Err = fmt.Errorf("%w: -mmap can't transcode %s input", errInputFailed, enc)
+			return result
+		}
+	} else {
+		in, err := os.Open(name)
+		if err != nil {
+			result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+			return result
+		}
+		defer in.Close()
+
+		inCounter.r = in
+		r = bufio.NewReaderSize(inCounter, readBuffer)
+		if strings.HasSuffix(name, ".gz") {
+			gz, err := config_decoder.NewGzipReader(r)
+			if err != nil {
+				result.Err = fmt.Errorf("%w: gzip error reading input file: %w", errInputFailed, err)
+				return result
+			}
+			docCounter = &countingReader{r: gz}
+			r = docCounter
+		}
+		if r, docCounter, err = utf8Input(r, docCounter); err != nil {
+			result.Err = err
+			return result
+		}
+	}
+
+	logger.Infof("opened file %s", name)
+	logger.Debug("decoding json as stream ...")
+
+	if prog != nil {
+		wFactory = prog.wrap(wFactory)
+		if mapped != nil {
+			prog.begin(mapped.Len(), nil)
+		} else if info, err := os.Stat(name); err == nil {
+			prog.begin(info.Size(), inCounter.n.Load)
+		} else {
+			prog.begin(0, nil)
+		}
+	}
+
+	var chStatus chan config_decoder.WorkerStatus
+	var chErrors chan error
+	if mapped != nil {
+		inCounter.n.Store(mapped.Len())
+		if at == nil {
+			at = io.NewSectionReader(mapped, 0, mapped.Len())
+		}
+		prefix := make([]byte, min(sniffSize, at.Size()))
+		n, _ := at.ReadAt(prefix, 0)
+		spec = profileSpec(spec, name, prefix[:n])
+		chStatus, chErrors = config_decoder.DecodeAndSplitItemsAt(ctx, at, at.Size(), wFactory, poolSpec, spec)
+	} else if eventsMode {
+		chStatus, chErrors = config_decoder.DecodeChangeEvents(ctx, r, wFactory, poolSpec, spec)
+	} else {
+		spec, r = sniffSpec(spec, name, r)
+		chStatus, chErrors = config_decoder.DecodeAndSplitItems(ctx, r, wFactory, poolSpec, spec)
+	}
+
+	awaitResult(ctx, chStatus, chErrors, poolSpec.Size, &result)
+	if prog != nil {
+		prog.end()
+	}
+	result.InputBytes = inCounter.n.Load()
+	result.DocumentBytes = docCounter.n.Load()
+	result.Duration = time.Since(start)
+	return result
+}
+
+//logResultError logs the error that ended decoding a file, and for a truncated file, what was salvaged
+func logResultError(result runResult) {
+	logger.Errorf("error decoding %s: %s", result.File, result.Err)
+	var te *config_decoder.TruncatedError
+	if errors.As(result.Err, &te) {
+		size := byteCountSI(int(result.DocumentBytes))
+		if strings.HasSuffix(result.File, ".gz") {
+			size += " decompressed"
+		}
+		logger.Warnf("%s is truncated: salvaged %d whole items, the last ending at offset %d of %s",
+			result.File, te.Items, te.Offset, size)
+	}
+}
+
+//utf8Input returns the UTF-8 text of the document in r, stripping any byte order mark and, with
+// -transcode, transcoding UTF-16
+// docCounter counts the bytes of the document; transcoded, it's replaced by a counter of its UTF-8 text,
+// which its items' provenance offsets are into.
+func utf8Input(r io.Reader, docCounter *countingReader) (io.Reader, *countingReader, error) {
+	text, enc, err := config_decoder.NewUTF8Reader(r, transcode)
+	if errors.Is(err, config_decoder.ErrUTF16) {
+		return nil, nil, fmt.Errorf("%w: %w; -transcode transcodes it to UTF-8", errInputFailed, err)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errInputFailed, err)
+	}
+
+	switch enc {
+	case config_decoder.EncodingUTF8BOM:
+		logger.Info("skipping the input's byte order mark")
+	case config_decoder.EncodingUTF16LE, config_decoder.EncodingUTF16BE:
+		logger.Infof("transcoding %s input to utf-8", enc)
+		docCounter = &countingReader{r: text}
+		text = docCounter
+	}
+	return text, docCounter, nil
+}
+
+//awaitResult waits for decoding to finish or be cancelled, then collects the status of each writer into result
+func awaitResult(ctx context.Context, chStatus chan config_decoder.WorkerStatus, chErrors chan error, workers int, result *runResult) {
+ForSelectLoop:
+	for {
+		select {
+		case err := <-chErrors:
+			result.Err = err
+			break ForSelectLoop
+		case <-ctx.Done():
+			// the cause tells a file's own timeout from the run's, and both from a signal
+			logger.Warnf("decoder cancelled: %s", context.Cause(ctx))
+			result.Err = context.Cause(ctx)
+			break ForSelectLoop
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		s := <-chStatus
+		result.ItemCount += s.ItemCount
+		result.ItemBytes += s.ByteCount
+		result.Workers = append(result.Workers, s)
+	}
+}
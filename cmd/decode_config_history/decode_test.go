@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//slowWriter takes each item a while to write, signalling the process once it has written signalAt of them
+type slowWriter struct {
+	written  *atomic.Int64
+	signalAt int64
+}
+
+func (w slowWriter) Write(map[string]interface{}) error {
+	time.Sleep(10 * time.Millisecond)
+	if w.written.Add(1) == w.signalAt {
+		return syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}
+	return nil
+}
+
+func TestDecodeInputSignal(t *testing.T) {
+	defer func(f string, d time.Duration, p int) { inputFile, timeout, poolSize = f, d, p }(inputFile, timeout, poolSize)
+
+	// 1000 items at 10ms each take 5s to write with two workers
+	items := make([]map[string]any, 1000)
+	for i := range items {
+		items[i] = volume(fmt.Sprintf("vol-%04d", i), 8, 2)
+	}
+	doc, err := json.Marshal(map[string]any{"fileVersion": "1.0", "configurationItems": items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile = filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(inputFile, doc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	timeout, poolSize = time.Minute, 2
+
+	var written atomic.Int64
+	start := time.Now()
+	result, err := decodeInput(config_decoder.FactoryOf(func() config_decoder.ItemWriter {
+		return slowWriter{written: &written, signalAt: 20}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(result.Err, errSignalled) {
+		t.Errorf("decoding ended with %v, want it stopped by the signal", result.Err)
+	}
+	// the decoder and writers stop, rather than the run waiting for every item to be written
+	if elapsed := time.Since(start); elapsed > time.Second || written.Load() >= int64(len(items)) {
+		t.Errorf("wrote %d items in %s after the signal, want decoding abandoned", written.Load(), elapsed)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//diffIgnored are item fields that change between snapshots without the resource changing
+// With -metadata-prefix, the prefixed metadata fields are ignored too.
+var diffIgnored = map[string]bool{
+	"configurationItemCaptureTime": true,
+	"configurationStateId":         true,
+	"configurationItemMD5Hash":     true,
+	"event_type":                   true,
+	"event_source":                 true,
+	"ingest_time":                  true,
+	"decoder_version":              true,
+	"run_id":                       true,
+	"source_file":                  true,
+	"source_offset_start":          true,
+	"source_offset_end":            true,
+	"source_index":                 true,
+	"config_snapshot":              true,
+	"metadata":                     true,
+}
+
+//resourceDigests maps each resource in a snapshot to a digest of its configuration
+type resourceDigests struct {
+	mu      sync.Mutex
+	digests map[string][sha256.Size]byte
+}
+
+//digestWriter is an ItemWriter recording the digest of each item
+type digestWriter struct {
+	rd *resourceDigests
+}
+
+// Write implements ItemWriter for digestWriter
+func (dw digestWriter) Write(item map[string]interface{}) error {
+	key := resourceKey(item)
+	digest, err := itemDigest(item)
+	if err != nil {
+		return err
+	}
+
+	dw.rd.mu.Lock()
+	dw.rd.digests[key] = digest
+	dw.rd.mu.Unlock()
+	return nil
+}
+
+//itemDigest is a digest of item, less the fields that change without the resource changing
+func itemDigest(item map[string]any) ([sha256.Size]byte, error) {
+	compared := make(map[string]any, len(item))
+	for k, v := range item {
+		if !diffIgnored[k] && !(envelope.Prefix != "" && strings.HasPrefix(k, envelope.Prefix)) {
+			compared[k] = v
+		}
+	}
+	// json.Marshal sorts map keys, so equal items have equal encodings
+	b, err := json.Marshal(compared)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+//resourceKey identifies the resource an item describes
+func resourceKey(item map[string]any) string {
+	t, _ := item["resourceType"].(string)
+	if id, ok := item["resourceId"].(string); ok {
+		return t + " " + id
+	}
+	arn, _ := item["ARN"].(string)
+	if arn == "" {
+		arn, _ = item["arn"].(string)
+	}
+	return t + " " + arn
+}
+
+//runDiff implements the diff subcommand, comparing the items of two snapshots
+// Resources are listed as added (+), removed (-) or changed (~) in the new snapshot.
+func runDiff(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if flag.NArg() != 2 {
+		return fmt.Errorf("usage: %s diff [flags] old new", os.Args[0])
+	}
+
+	var snapshots [2]*resourceDigests
+	for i, name := range flag.Args() {
+		rd := &resourceDigests{digests: make(map[string][sha256.Size]byte)}
+		inputFile = name
+		result, err := decodeInput(config_decoder.FactoryOf(func() config_decoder.ItemWriter { return digestWriter{rd: rd} }))
+		if err != nil {
+			return err
+		}
+		if result.Err != nil {
+			return decodeFailed(fmt.Errorf("diff: %s: %w", name, result.Err))
+		}
+		snapshots[i] = rd
+	}
+	old, cur := snapshots[0].digests, snapshots[1].digests
+
+	var lines []string
+	for k, d := range cur {
+		if od, ok := old[k]; !ok {
+			lines = append(lines, "+ "+k)
+		} else if od != d {
+			lines = append(lines, "~ "+k)
+		}
+	}
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			lines = append(lines, "- "+k)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2:] < lines[j][2:] })
+
+	for _, l := range lines {
+		_, _ = fmt.Fprintln(os.Stdout, l)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%d resources in old, %d in new, %d differences\n", len(old), len(cur), len(lines))
+	return nil
+}
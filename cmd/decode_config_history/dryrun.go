@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+//dryRun stands in for the configured writer in a -dry-run, counting what would have been written where
+type dryRun struct {
+	mu      sync.Mutex
+	targets []*dryRunTarget
+}
+
+//dryRunTarget counts the items that would have been written to one destination
+type dryRunTarget struct {
+	destination string
+	writers     []*dryRunWriter
+}
+
+//dryRunWriter is a stub ItemWriter counting the items written to it and their size as json
+// Like FileWriter, each one reuses its own marshal buffer.
+type dryRunWriter struct {
+	items int
+	bytes int64
+	buf   *bytes.Buffer
+	enc   *json.Encoder
+}
+
+func (dw *dryRunWriter) Write(item map[string]interface{}) error {
+	dw.buf.Reset()
+	if err := dw.enc.Encode(item); err != nil {
+		return err
+	}
+	dw.items++
+	// don't count the encoder's newline
+	dw.bytes += int64(dw.buf.Len() - 1)
+	return nil
+}
+
+// BytesWritten implements ByteCounter for dryRunWriter
+func (dw *dryRunWriter) BytesWritten() int64 {
+	return dw.bytes
+}
+
+//factory creates stub writers counting the items that would have been written to destination
+func (d *dryRun) factory(destination string) config_decoder.WriterFactory {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var target *dryRunTarget
+	for _, t := range d.targets {
+		if t.destination == destination {
+			target = t
+		}
+	}
+	if target == nil {
+		target = &dryRunTarget{destination: destination}
+		d.targets = append(d.targets, target)
+	}
+
+	return config_decoder.FactoryOf(func() config_decoder.ItemWriter {
+		buf := new(bytes.Buffer)
+		dw := &dryRunWriter{buf: buf, enc: json.NewEncoder(buf)}
+		d.mu.Lock()
+		target.writers = append(target.writers, dw)
+		d.mu.Unlock()
+		return dw
+	})
+}
+
+//report prints what would have been written to each destination, by each writer
+// It's called once decoding has finished, as the counts aren't synchronized.
+func (d *dryRun) report(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, _ = fmt.Fprintln(w, "dry run, nothing was written:")
+	for _, t := range d.targets {
+		items, size := 0, int64(0)
+		for _, dw := range t.writers {
+			items += dw.items
+			size += dw.bytes
+		}
+		_, _ = fmt.Fprintf(w, "  %s: would have written %d items (%s as json)\n",
+			t.destination, items, byteCountSI(int(size)))
+		for i, dw := range t.writers {
+			_, _ = fmt.Fprintf(w, "    writer %d: %d items (%s)\n", i, dw.items, byteCountSI(int(dw.bytes)))
+		}
+	}
+}
+
+//writerDestination describes where the configured writer would write the items of input
+func writerDestination(input string) string {
+	switch writerKind {
+	case "file":
+		d := []string{"file stdout"}
+		if outputTemplate != "" {
+			d[0] = "file " + expandOutput(outputTemplate, input)
+		}
+		if fileOpts.gzipped(outputTemplate) {
+			d = append(d, "gzipped")
+		}
+		if fileOpts.Append {
+			d = append(d, "appended")
+		}
+		if fileOpts.Exclusive {
+			d = append(d, "if it doesn't exist")
+		}
+		if fileOpts.Sync != "commit" {
+			d = append(d, "fsync "+fileOpts.Sync)
+		}
+		if fileOpts.perm() != 0o644 {
+			d = append(d, fmt.Sprintf("mode %#o", fileOpts.perm()))
+		}
+		if fileOpts.Format == "protobuf" {
+			d = append(d, "as protobuf")
+		} else if canonical {
+			d = append(d, "as canonical json")
+		} else if verbatim {
+			d = append(d, "as verbatim json")
+		}
+		return strings.Join(d, ", ")
+	case "opensearch":
+		u := openSearch.URL
+		if parsed, err := url.Parse(u); err == nil {
+			u = parsed.Redacted()
+		}
+		return fmt.Sprintf("opensearch index %s at %s", openSearch.Index, u)
+	case "neo4j":
+		u := neo4j.URL
+		if parsed, err := url.Parse(u); err == nil {
+			u = parsed.Redacted()
+		}
+		return fmt.Sprintf("neo4j database %s at %s", neo4j.Database, u)
+	case "sentinel":
+		return fmt.Sprintf("sentinel stream %s of rule %s at %s", sentinel.Stream, sentinel.RuleID, sentinel.Endpoint)
+	case "chronicle":
+		return fmt.Sprintf("chronicle customer %s at %s as %s", chronicle.CustomerID, chronicle.Endpoint, chronicle.LogType)
+	case "securitylake":
+		return fmt.Sprintf("security lake %s in objects of %d MiB", securityLake, lakeFileSize)
+	case "kafka":
+		return fmt.Sprintf("kafka topic %s at %s as %s", kafkaConfig.Topic, kafkaBrokers, kafkaConfig.Format)
+	default:
+		return writerKind + " writer"
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+//envPrefix prefixes the environment variables that set flags
+const envPrefix = "CHD_"
+
+//envName is the environment variable setting flag name of command
+// The flags shared by decode, stats, validate and diff are set by CHD_<NAME>, e.g. CHD_POOL_SIZE
+// for -pool-size; those of other commands by CHD_<COMMAND>_<NAME>, e.g. CHD_GENERATE_COUNT.
+func envName(command, name string) string {
+	n := envPrefix
+	if command != "" {
+		n += strings.ToUpper(command) + "_"
+	}
+	return n + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+//applyEnv sets the flags of fs not given on the command line from their environment variables
+// Flags set this way count as given, so a -config file doesn't override them. A repeatable flag,
+// such as -writer-opt, takes a whitespace-separated list.
+func applyEnv(fs *flag.FlagSet, command string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var problems []string
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(command, f.Name)
+		v, ok := os.LookupEnv(name)
+		if !ok || explicit[f.Name] {
+			return
+		}
+
+		values := []string{v}
+		if _, ok := f.Value.(*optionList); ok {
+			values = strings.Fields(v)
+		}
+		for _, value := range values {
+			if err := fs.Set(f.Name, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s=%q: %s", name, value, err))
+			}
+		}
+	})
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("applyEnv: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+//parseFlags parses the args of command with its own flag set fs, then applies their environment variables
+func parseFlags(fs *flag.FlagSet, command string, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return parseError{err}
+	}
+	return applyEnv(fs, command)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	count := fs.Int("count", 500, "")
+	seed := fs.Int64("seed", 0, "")
+	output := fs.String("output", "", "")
+	var opts optionList
+	fs.Var(&opts, "writer-opt", "")
+
+	t.Setenv("CHD_GENERATE_COUNT", "10")
+	t.Setenv("CHD_GENERATE_SEED", "7")
+	t.Setenv("CHD_GENERATE_WRITER_OPT", "a=1  b=2")
+	// the variables of the shared flags don't set a command's
+	t.Setenv("CHD_OUTPUT", "shared.json")
+
+	// flags given on the command line take precedence
+	if err := parseFlags(fs, "generate", []string{"-seed", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if *count != 10 || *seed != 3 || *output != "" {
+		t.Errorf("count %d, seed %d, output %q", *count, *seed, *output)
+	}
+	if strings.Join(opts, ",") != "a=1,b=2" {
+		t.Errorf("writer-opt %v", opts)
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.Int("pool-size", 8, "")
+	var opts optionList
+	fs.Var(&opts, "writer-opt", "")
+
+	t.Setenv("CHD_POOL_SIZE", "many")
+	t.Setenv("CHD_WRITER_OPT", "novalue")
+	err := applyEnv(fs, "")
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{`CHD_POOL_SIZE="many"`, `CHD_WRITER_OPT="novalue"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%v doesn't name %s", err, want)
+		}
+	}
+}
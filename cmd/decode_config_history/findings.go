@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//findingsSink writes the findings of the rules to the -findings destination, counting them by rule
+// One findingsSink is shared by every decoder of the run, so its writes are serialized. out is the
+// output of a findings file, and nil for other writers.
+type findingsSink struct {
+	mu     sync.Mutex
+	name   string
+	out    *output
+	w      config_decoder.ItemWriter
+	byRule map[string]int64
+}
+
+//findings is the run's findingsSink, created by the first decode checking rules
+var findings *findingsSink
+
+//findingsWriter returns the run's findingsSink, creating its -findings writer if need be
+// -findings is a sink as sinkWriterFactory takes it.
+func findingsWriter() (*findingsSink, error) {
+	if findings != nil {
+		return findings, nil
+	}
+	if findingsFile == "" {
+		return nil, fmt.Errorf("-rules and -rules-file require -findings")
+	}
+
+	fs := &findingsSink{byRule: make(map[string]int64)}
+	factory, out, name, err := sinkWriterFactory(findingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("findingsWriter: %w", err)
+	}
+	fs.name, fs.out = name, out
+	if fs.w, err = factory(context.Background(), 0); err != nil {
+		return nil, fmt.Errorf("findingsWriter: %w", err)
+	}
+	findings = fs
+	return findings, nil
+}
+
+// Write implements ItemWriter for findingsSink
+func (fs *findingsSink) Write(item map[string]interface{}) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.w.Write(item); err != nil {
+		return err
+	}
+	rule, _ := item["rule"].(string)
+	fs.byRule[rule]++
+	return nil
+}
+
+//counts returns the findings written so far by rule, or nil if rules weren't checked
+func (fs *findingsSink) counts() map[string]int64 {
+	if fs == nil {
+		return nil
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	counts := make(map[string]int64, len(fs.byRule))
+	for rule, n := range fs.byRule {
+		counts[rule] = n
+	}
+	return counts
+}
+
+//closeFindings completes the -findings output, if rules were checked, logging the findings by rule
+// Findings are kept even if decoding failed, as what was found is no less so.
+func closeFindings() error {
+	if findings == nil {
+		return nil
+	}
+	if f, ok := findings.w.(config_decoder.Flusher); ok {
+		if err := f.Flush(); err != nil {
+			return &exitError{code: exitWrite, err: fmt.Errorf("findings: %w", err)}
+		}
+	}
+	if c, ok := findings.w.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return &exitError{code: exitWrite, err: fmt.Errorf("findings: %w", err)}
+		}
+	}
+	if findings.out != nil {
+		if err := findings.out.commit(); err != nil {
+			return &exitError{code: exitWrite, err: err}
+		}
+	}
+
+	counts := findings.counts()
+	rules := make([]string, 0, len(counts))
+	var total int64
+	for rule, n := range counts {
+		rules = append(rules, fmt.Sprintf("%s %d", rule, n))
+		total += n
+	}
+	sort.Strings(rules)
+	if total == 0 {
+		logger.Infof("no findings written to %s", findings.name)
+		return nil
+	}
+	logger.Warnf("%d findings written to %s: %s", total, findings.name, strings.Join(rules, ", "))
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/klauspost/pgzip"
+	"github.com/mfrasier/decode_json_stream/generator"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//runGenerate implements the generate subcommand, writing a snapshot for testing to stdout, -output,
+// or a batch of -files files named as AWS Config names them in -dir
+// With -gzip the snapshot is gzipped, as AWS Config delivers them, in members of -gzip-member-bytes if set.
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	var opts generator.Options
+	var gzipped bool
+	var memberBytes int64
+	var output, dir string
+	var files int
+	fs.IntVar(&opts.Count, "count", 500, "approximate desired config item count")
+	fs.BoolVar(&opts.Synthesize, "synth", false,
+		"synthesize randomized items of several resource types, with random ids, ARNs, regions,\n"+
+			"capture times and tags, instead of repeating the embedded items")
+	fs.StringVar(&opts.Format, "format", generator.FormatSnapshot,
+		"document generated [snapshot|history]; a ConfigHistory document of resource timelines with diffs\n"+
+			"always has synthesized items")
+	fs.Func("accounts", "comma-separated accounts of synthesized items (default three random accounts)", listFlag(&opts.Accounts))
+	fs.Func("regions", "comma-separated regions of synthesized items (default several regions)", listFlag(&opts.Regions))
+	fs.StringVar(&opts.SnapshotID, "snapshot-id", "", "configSnapshotId of a synthesized snapshot (default a random UUID)")
+	fs.StringVar(&opts.FileVersion, "file-version", "", "fileVersion of a synthesized document (default 1.0)")
+	fs.Func("mix", "relative shares of synthesized resource types, e.g.\n"+
+		"AWS::EC2::Instance=50,AWS::IAM::Role=30,AWS::S3::Bucket=20 (default the types with templates, equally)",
+		func(v string) (err error) {
+			opts.Mix, err = generator.ParseMix(v)
+			return err
+		})
+	fs.IntVar(&opts.Oversized, "oversized", 0, "number of synthesized items, chosen at random, padded out to -oversized-bytes")
+	fs.IntVar(&opts.OversizedBytes, "oversized-bytes", 1<<20, "size of the padding of -oversized items")
+	fs.Int64Var(&opts.Seed, "seed", 0, "seed for synthesized content, which is then the same on every run (0 seeds from the time)")
+	fs.BoolVar(&gzipped, "gzip", false, "gzip the snapshot, like the .json.gz files AWS Config delivers")
+	fs.Int64Var(&memberBytes, "gzip-member-bytes", 0,
+		"gzip the snapshot as a multi-member stream, starting a member every this many bytes, as S3 multipart\n"+
+			"uploads of separately compressed parts are (0 writes one member)")
+	fs.Int64Var(&opts.Defects.Truncate, "truncate", 0, "cut the snapshot off after this many bytes, before any gzip (0 doesn't)")
+	fs.BoolVar(&opts.Defects.MissingEnd, "missing-end", false, "leave off the closing ]} of the snapshot")
+	fs.Func("invalid-utf8", "put invalid UTF-8 in the resourceId of the item at this index, with -synth (repeatable)",
+		itemDefect(&opts.Defects, generator.InvalidUTF8))
+	fs.Func("malformed", "make the item at this index malformed json, with -synth (repeatable)",
+		itemDefect(&opts.Defects, generator.Malformed))
+	fs.Func("duplicate-key", "give the item at this index a duplicated resourceId key, with -synth (repeatable)",
+		itemDefect(&opts.Defects, generator.DuplicateKey))
+	fs.BoolVar(&opts.Endless, "endless", false,
+		"stream synthesized items without end, never closing the items array, e.g. to soak test serve mode")
+	fs.Float64Var(&opts.Rate, "rate", 0, "synthesized items written per second (0 is as fast as possible)")
+	fs.StringVar(&output, "output", "", "file to write, gzipped if it ends .gz (default stdout)")
+	fs.StringVar(&dir, "dir", "", "directory to write synthesized files to, named as AWS Config names them")
+	fs.IntVar(&files, "files", 1, "number of files written to -dir, cycling through -accounts and -regions")
+	if err := parseFlags(fs, "generate", args); err != nil {
+		return err
+	}
+
+	switch {
+	case output != "" && dir != "":
+		return fmt.Errorf("generate: -output and -dir are exclusive")
+	case files != 1 && dir == "":
+		return fmt.Errorf("generate: -files requires -dir")
+	case files < 1:
+		return fmt.Errorf("generate: -files %d is not positive", files)
+	case memberBytes < 0:
+		return fmt.Errorf("generate: -gzip-member-bytes %d is negative", memberBytes)
+	case memberBytes > 0 && !gzipped && !strings.HasSuffix(output, ".gz"):
+		return fmt.Errorf("generate: -gzip-member-bytes requires -gzip")
+	case opts.Endless && dir != "":
+		return fmt.Errorf("generate: -endless writes to stdout or -output")
+	case output != "":
+		return generateFile(output, opts, gzipped, memberBytes)
+	case dir != "":
+		for _, f := range generator.Batch(opts, files, gzipped) {
+			if err := generateFile(filepath.Join(dir, f.Name), f.Options, gzipped, memberBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	var gz io.WriteCloser
+	out := &countingWriter{w: os.Stdout}
+	if gzipped && memberBytes > 0 {
+		gz = generator.NewMemberWriter(out, memberBytes)
+		w = gz
+	} else if gzipped {
+		gz = pgzip.NewWriter(out)
+		w = gz
+	}
+
+	stats, err := generator.Write(w, opts)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "wrote chunks: %d, items: %d, bytes: %d", stats.Chunks, stats.Items, stats.Bytes)
+	if gz != nil {
+		_, _ = fmt.Fprintf(os.Stderr, ", gzipped: %d", out.n)
+	}
+	_, _ = fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+//generateFile writes a snapshot generated with opts to file path, gzipped if it ends .gz or with gzipped,
+// in members of memberBytes if it's set
+// Like decoded output, it's written to a temporary file renamed into place once complete, unless it's endless.
+func generateFile(path string, opts generator.Options, gzipped bool, memberBytes int64) error {
+	fOpts := fileOptions{}
+	if gzipped {
+		fOpts.Gzip = &gzipped
+	}
+	multiMember := memberBytes > 0 && fOpts.gzipped(path)
+	if multiMember {
+		// the members are gzipped here, not by the output
+		fOpts.Gzip = new(bool)
+	}
+	if opts.Endless {
+		// an endless file is never complete, so it's written in place, for readers to follow
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("generate: %w", err)
+		}
+		fOpts.Append = true
+	}
+	out, err := createOutput(path, fOpts)
+	if err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+
+	var w io.Writer = out
+	var members *generator.MemberWriter
+	if multiMember {
+		members = generator.NewMemberWriter(out, memberBytes)
+		w = members
+	}
+	stats, err := generator.Write(w, opts)
+	if err == nil && members != nil {
+		err = members.Close()
+	}
+	if err != nil {
+		out.abort()
+		return fmt.Errorf("generate: %s: %w", path, err)
+	}
+	if err := out.commit(); err != nil {
+		return fmt.Errorf("generate: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "wrote %s: items: %d, bytes: %d", path, stats.Items, stats.Bytes)
+	if members != nil {
+		_, _ = fmt.Fprintf(os.Stderr, ", gzip members: %d", members.Members())
+	}
+	_, _ = fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+//listFlag returns a flag.Func setting list from a comma-separated list
+func listFlag(list *[]string) func(string) error {
+	return func(v string) error {
+		*list = nil
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				*list = append(*list, e)
+			}
+		}
+		return nil
+	}
+}
+
+//itemDefect returns a flag.Func setting injecting defect into the item at the index given
+func itemDefect(defects *generator.Defects, defect generator.ItemDefect) func(string) error {
+	return func(v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if defects.Items == nil {
+			defects.Items = make(map[int]generator.ItemDefect)
+		}
+		defects.Items[i] = defect
+		return nil
+	}
+}
+
+//countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//graphNode is a resource of a graph; a resource only related to is a stub, known by its type and id
+type graphNode struct {
+	key, resourceType, id, name, region, account string
+	stub                                         bool
+}
+
+//graphEdge is a relationship from one resource to another
+type graphEdge struct {
+	from, to, name string
+}
+
+//resourceGraph is the graph of a snapshot's resources and their relationships
+// One resourceGraph is shared by every worker's graphWriter. It holds at most maxNodes resources,
+// setting overflow instead of adding more.
+type resourceGraph struct {
+	mu       sync.Mutex
+	types    []string
+	maxNodes int
+	nodes    map[string]*graphNode
+	edges    map[graphEdge]bool
+	overflow bool
+}
+
+//graphWriter is an ItemWriter adding each item's resource and relationships to a resourceGraph
+type graphWriter struct {
+	g *resourceGraph
+}
+
+//graphKey identifies a resource in a graph
+func graphKey(resourceType, id string) string {
+	return resourceType + "/" + id
+}
+
+//admits reports whether resources of type t are in the graph, by the -graph-types patterns
+func (g *resourceGraph) admits(t string) bool {
+	if len(g.types) == 0 {
+		return true
+	}
+	for _, pattern := range g.types {
+		if ok, _ := path.Match(pattern, t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//node returns the graph's node for a resource, adding a stub if it's new, or nil if it isn't admitted
+func (g *resourceGraph) node(resourceType, id string) *graphNode {
+	if !g.admits(resourceType) {
+		return nil
+	}
+	key := graphKey(resourceType, id)
+	if n, ok := g.nodes[key]; ok {
+		return n
+	}
+	if len(g.nodes) >= g.maxNodes {
+		g.overflow = true
+		return nil
+	}
+	n := &graphNode{key: key, resourceType: resourceType, id: id, stub: true}
+	g.nodes[key] = n
+	return n
+}
+
+// Write implements ItemWriter for graphWriter
+func (gw graphWriter) Write(item map[string]interface{}) error {
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	if t == "" || id == "" {
+		return nil
+	}
+
+	g := gw.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := g.node(t, id)
+	if n == nil {
+		return nil
+	}
+	n.stub = false
+	n.name = resourceName(item)
+	n.region, _ = item["awsRegion"].(string)
+	n.account, _ = item["awsAccountId"].(string)
+
+	relationships, _ := item["relationships"].([]any)
+	for _, r := range relationships {
+		rel, _ := r.(map[string]any)
+		toType, _ := rel["resourceType"].(string)
+		toID, _ := rel["resourceId"].(string)
+		if toType == "" || toID == "" {
+			continue
+		}
+		to := g.node(toType, toID)
+		if to == nil {
+			continue
+		}
+		if to.stub && to.name == "" {
+			to.name, _ = rel["resourceName"].(string)
+		}
+		name, _ := rel["name"].(string)
+		if name == "" {
+			name, _ = rel["relationshipName"].(string)
+		}
+		g.edges[graphEdge{from: n.key, to: to.key, name: name}] = true
+	}
+	return nil
+}
+
+//sorted returns the graph's nodes and edges in order, for output that's the same from run to run
+func (g *resourceGraph) sorted() ([]*graphNode, []graphEdge) {
+	nodes := make([]*graphNode, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].key < nodes[j].key })
+
+	edges := make([]graphEdge, 0, len(g.edges))
+	for e := range g.edges {
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		if edges[i].to != edges[j].to {
+			return edges[i].to < edges[j].to
+		}
+		return edges[i].name < edges[j].name
+	})
+	return nodes, edges
+}
+
+//graphMLKeys are the GraphML attributes of nodes and edges, as id, element and name
+var graphMLKeys = [][3]string{
+	{"type", "node", "resourceType"},
+	{"id", "node", "resourceId"},
+	{"name", "node", "name"},
+	{"region", "node", "awsRegion"},
+	{"account", "node", "awsAccountId"},
+	{"stub", "node", "stub"},
+	{"relationship", "edge", "name"},
+}
+
+//writeGraphML prints the graph as GraphML, with the resources' attributes as data
+func (g *resourceGraph) writeGraphML(w io.Writer) error {
+	escape := func(s string) string {
+		var b strings.Builder
+		_ = xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	nodes, edges := g.sorted()
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, k := range graphMLKeys {
+		attrType := "string"
+		if k[0] == "stub" {
+			attrType = "boolean"
+		}
+		fmt.Fprintf(&b, `  <key id="%s" for="%s" attr.name="%s" attr.type="%s"/>`+"\n", k[0], k[1], k[2], attrType)
+	}
+	b.WriteString(`  <graph id="resources" edgedefault="directed">` + "\n")
+	for _, n := range nodes {
+		fmt.Fprintf(&b, `    <node id="%s">`, escape(n.key))
+		for _, d := range [][2]string{{"type", n.resourceType}, {"id", n.id}, {"name", n.name}, {"region", n.region},
+			{"account", n.account}, {"stub", fmt.Sprint(n.stub)}} {
+			if d[1] != "" {
+				fmt.Fprintf(&b, `<data key="%s">%s</data>`, d[0], escape(d[1]))
+			}
+		}
+		b.WriteString("</node>\n")
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, `    <edge source="%s" target="%s"><data key="relationship">%s</data></edge>`+"\n",
+			escape(e.from), escape(e.to), escape(e.name))
+	}
+	b.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+//writeDOT prints the graph in Graphviz DOT, each resource labelled with its name and type, stubs dashed
+func (g *resourceGraph) writeDOT(w io.Writer) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	nodes, edges := g.sorted()
+
+	var b strings.Builder
+	b.WriteString("digraph resources {\n  rankdir=LR;\n  node [shape=box];\n")
+	for _, n := range nodes {
+		label := n.id
+		if n.name != "" {
+			label = n.name
+		}
+		style := ""
+		if n.stub {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  \"%s\" [label=\"%s\\n%s\"%s];\n", quote.Replace(n.key), quote.Replace(label),
+			quote.Replace(n.resourceType), style)
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  \"%s\" -> \"%s\" [label=\"%s\"];\n", quote.Replace(e.from), quote.Replace(e.to), quote.Replace(e.name))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+//runGraph implements the graph subcommand, writing the graph of the input's resources and their
+// relationships as GraphML or Graphviz DOT (-graph-format), bounded by -graph-types and -graph-max-nodes
+func runGraph(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if graphFormat != "graphml" && graphFormat != "dot" {
+		return fmt.Errorf("graph: unknown format %q", graphFormat)
+	}
+
+	g := &resourceGraph{maxNodes: graphMaxNodes, nodes: make(map[string]*graphNode), edges: make(map[graphEdge]bool)}
+	if graphTypes != "" {
+		g.types = strings.Split(graphTypes, ",")
+	}
+	for _, pattern := range g.types {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("graph: -graph-types %q: %w", pattern, err)
+		}
+	}
+
+	result, err := decodeInput(config_decoder.FactoryOf(func() config_decoder.ItemWriter {
+		return graphWriter{g: g}
+	}))
+	if err != nil {
+		return err
+	}
+	if result.Err != nil {
+		return decodeFailed(fmt.Errorf("graph: %s: %w", result.File, result.Err))
+	}
+	if g.overflow {
+		return fmt.Errorf("graph: more than %d resources; narrow it with -graph-types, or raise -graph-max-nodes", g.maxNodes)
+	}
+
+	if graphFormat == "dot" {
+		return g.writeDOT(os.Stdout)
+	}
+	return g.writeGraphML(os.Stdout)
+}
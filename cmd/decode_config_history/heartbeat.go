@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//heartbeatEvery, set by -heartbeat, is how often a heartbeat is logged; 0 logs none
+var heartbeatEvery time.Duration
+
+//stopHeartbeat stops logging heartbeats, once startHeartbeat has started
+var stopHeartbeat = func() {}
+
+//inputSet is the inputs being decoded, by name, counting those decoded more than once at a time
+type inputSet struct {
+	mu    sync.Mutex
+	names map[string]int
+}
+
+//activeInputs are the inputs the run is decoding, for its heartbeat
+var activeInputs = &inputSet{names: make(map[string]int)}
+
+//decoding notes that name is being decoded, returning the func noting that it's done
+func (s *inputSet) decoding(name string) func() {
+	s.mu.Lock()
+	s.names[name]++
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.names[name]--; s.names[name] <= 0 {
+			delete(s.names, name)
+		}
+	}
+}
+
+//list returns the names of the inputs being decoded, sorted
+func (s *inputSet) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//startHeartbeat logs a heartbeat every -heartbeat, if it's set, until stopHeartbeat is called
+// Heartbeats are logged even with -quiet, so an unattended run leaves evidence it's alive, and its
+// throughput over time, without metrics infrastructure.
+func startHeartbeat() {
+	if heartbeatEvery <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(heartbeatEvery)
+		defer ticker.Stop()
+		var last int
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				var status string
+				status, last = heartbeatStatus(now.Sub(start), last)
+				heartbeatLog.Infof("heartbeat %s: %s", now.UTC().Format(time.RFC3339), status)
+			}
+		}
+	}()
+	stopHeartbeat = func() {
+		close(done)
+		<-stopped
+		stopHeartbeat = func() {}
+	}
+}
+
+//heartbeatStatus describes the run, up for elapsed, in one line, with its throughput since the last
+// heartbeat, when it had written last items; it returns the items written so far
+// The item channel is unbuffered, so the queue of items waiting to be written is the workers' backlog,
+// as the dashboard shows it: when all are busy, the decoder waits on them.
+func heartbeatStatus(elapsed time.Duration, last int) (string, int) {
+	var items, bytes, errors, busy int
+	workers := liveStats.Snapshot()
+	for _, ws := range workers {
+		items += ws.ItemCount
+		bytes += ws.ByteCount
+		errors += ws.ErrorCount
+		if ws.Status == "busy" {
+			busy++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "up %s, %d items (%s), %.0f items/sec, %d errors, queue %d of %d workers busy",
+		elapsed.Round(time.Second), items, byteCountSI(bytes), float64(items-last)/heartbeatEvery.Seconds(), errors,
+		busy, len(workers))
+	if inputs := activeInputs.list(); len(inputs) > 0 {
+		fmt.Fprintf(&b, ", decoding %s", strings.Join(inputs, ", "))
+	} else {
+		b.WriteString(", idle")
+	}
+	return b.String(), items
+}
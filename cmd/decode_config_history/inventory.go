@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//inventoryColumns are the headings of an inventory's columns
+var inventoryColumns = []string{"ARN", "Resource type", "Resource id", "Region", "Account", "Name", "Captured"}
+
+//inventory lists one row per resource of a snapshot
+// One inventory is shared by every worker's inventoryWriter.
+type inventory struct {
+	mu   sync.Mutex
+	rows [][]string
+}
+
+//inventoryWriter is an ItemWriter adding a row to an inventory for each item
+type inventoryWriter struct {
+	inv *inventory
+}
+
+// Write implements ItemWriter for inventoryWriter
+func (iw inventoryWriter) Write(item map[string]interface{}) error {
+	arn, _ := item["ARN"].(string)
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	region, _ := item["awsRegion"].(string)
+	account, _ := item["awsAccountId"].(string)
+	captured, _ := item["configurationItemCaptureTime"].(string)
+	row := []string{arn, t, id, region, account, resourceName(item), captured}
+
+	iw.inv.mu.Lock()
+	iw.inv.rows = append(iw.inv.rows, row)
+	iw.inv.mu.Unlock()
+	return nil
+}
+
+//resourceName is the value of an item's Name tag, or else its resourceName
+// Snapshots give tags as an object; the AWS Config API gives them as a list of key and value pairs.
+func resourceName(item map[string]any) string {
+	switch tags := item["tags"].(type) {
+	case map[string]any:
+		if name, ok := tags["Name"].(string); ok {
+			return name
+		}
+	case []any:
+		for _, t := range tags {
+			if tag, ok := t.(map[string]any); ok && tag["key"] == "Name" {
+				if name, ok := tag["value"].(string); ok {
+					return name
+				}
+			}
+		}
+	}
+	name, _ := item["resourceName"].(string)
+	return name
+}
+
+//sort orders the rows by resource type, then resource id
+func (inv *inventory) sort() {
+	sort.Slice(inv.rows, func(i, j int) bool {
+		if inv.rows[i][1] != inv.rows[j][1] {
+			return inv.rows[i][1] < inv.rows[j][1]
+		}
+		return inv.rows[i][2] < inv.rows[j][2]
+	})
+}
+
+//writeCSV prints the inventory as CSV, with a header row
+func (inv *inventory) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(inventoryColumns)
+	_ = cw.WriteAll(inv.rows)
+	return cw.Error()
+}
+
+//writeMarkdown prints the inventory as a Markdown table
+func (inv *inventory) writeMarkdown(w io.Writer) error {
+	// pipes would end a cell early, and newlines the row
+	escape := strings.NewReplacer("|", `\|`, "\r", " ", "\n", " ")
+	line := func(cells []string) error {
+		escaped := make([]string, len(cells))
+		for i, c := range cells {
+			escaped[i] = escape.Replace(c)
+		}
+		_, err := fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+		return err
+	}
+
+	if err := line(inventoryColumns); err != nil {
+		return err
+	}
+	rule := make([]string, len(inventoryColumns))
+	for i := range rule {
+		rule[i] = "---"
+	}
+	if err := line(rule); err != nil {
+		return err
+	}
+	for _, row := range inv.rows {
+		if err := line(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//runInventory implements the inventory subcommand, listing the resources of the input, one row each,
+// as CSV or a Markdown table (-inventory-format)
+func runInventory(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if inventoryFormat != "csv" && inventoryFormat != "markdown" {
+		return fmt.Errorf("inventory: unknown format %q", inventoryFormat)
+	}
+
+	inv := &inventory{}
+	result, err := decodeInput(config_decoder.FactoryOf(func() config_decoder.ItemWriter {
+		return inventoryWriter{inv: inv}
+	}))
+	if err != nil {
+		return err
+	}
+	if result.Err != nil {
+		return decodeFailed(fmt.Errorf("inventory: %s: %w", result.File, result.Err))
+	}
+
+	inv.sort()
+	if inventoryFormat == "markdown" {
+		return inv.writeMarkdown(os.Stdout)
+	}
+	return inv.writeCSV(os.Stdout)
+}
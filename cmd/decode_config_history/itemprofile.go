@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//profileDepth is how deep into items' objects fields are profiled; 2 profiles configuration.instanceType,
+// for example, but not its own fields
+const profileDepth = 2
+
+//profileCardinality is how many of the most common values of resourceType and awsRegion a table lists
+const profileCardinality = 10
+
+//itemProfile profiles the items of a snapshot: the fields they have and the types of their values,
+// the cardinality of resourceType and awsRegion, their sizes, and a reservoir sample of them
+// One itemProfile is shared by every worker's profileWriter.
+type itemProfile struct {
+	mu       sync.Mutex
+	items    int
+	fields   map[string]*fieldProfile
+	byType   map[string]*statGroup
+	byRegion map[string]*statGroup
+	sizes    []int64
+	sample   []json.RawMessage
+	keep     int
+	rnd      *rand.Rand
+}
+
+//fieldProfile counts the items having a field, by the json type of its value
+type fieldProfile struct {
+	Path     string         `json:"path"`
+	Items    int            `json:"items"`
+	Presence float64        `json:"presencePercent"`
+	Types    map[string]int `json:"types"`
+}
+
+//newItemProfile creates a profile keeping a sample of keep items, chosen with seed
+func newItemProfile(keep int, seed int64) *itemProfile {
+	return &itemProfile{
+		fields:   make(map[string]*fieldProfile),
+		byType:   make(map[string]*statGroup),
+		byRegion: make(map[string]*statGroup),
+		keep:     keep,
+		rnd:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+//add profiles an item, encoded as encoded
+// encoded is copied if the item is sampled, as the item and its encoding are reused once written.
+func (p *itemProfile) add(item map[string]any, encoded []byte) {
+	t, _ := item["resourceType"].(string)
+	region, _ := item["awsRegion"].(string)
+	size := int64(len(encoded))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.items++
+	p.addFields("", item, 1)
+	addToGroup(p.byType, t, size)
+	addToGroup(p.byRegion, region, size)
+	p.sizes = append(p.sizes, size)
+
+	// reservoir sampling: each item seen so far is in the sample with the same probability
+	if len(p.sample) < p.keep {
+		p.sample = append(p.sample, bytes.Clone(encoded))
+	} else if i := p.rnd.Intn(p.items); i < p.keep {
+		p.sample[i] = bytes.Clone(encoded)
+	}
+}
+
+//addFields counts the fields of obj, named under prefix, and those of its objects down to profileDepth
+func (p *itemProfile) addFields(prefix string, obj map[string]any, depth int) {
+	for k, v := range obj {
+		path := prefix + k
+		f, ok := p.fields[path]
+		if !ok {
+			f = &fieldProfile{Path: path, Types: make(map[string]int)}
+			p.fields[path] = f
+		}
+		f.Items++
+		f.Types[jsonType(v)]++
+
+		if m, ok := v.(map[string]any); ok && depth < profileDepth {
+			p.addFields(path+".", m, depth+1)
+		}
+	}
+}
+
+//jsonType names the json type of a decoded value
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		// float64, json.Number and the provenance fields' ints
+		return "number"
+	}
+}
+
+//profileWriter is an ItemWriter that profiles items instead of writing them
+// Like FileWriter, each profileWriter reuses its own encode buffer to measure items.
+type profileWriter struct {
+	profile *itemProfile
+	buf     *bytes.Buffer
+	enc     *json.Encoder
+}
+
+// Write implements ItemWriter for profileWriter
+func (pw profileWriter) Write(item map[string]interface{}) error {
+	pw.buf.Reset()
+	if err := pw.enc.Encode(item); err != nil {
+		return err
+	}
+	// don't keep the encoder's newline
+	pw.profile.add(item, bytes.TrimSuffix(pw.buf.Bytes(), []byte{'\n'}))
+	return nil
+}
+
+//sizeDistribution is the distribution of items' encoded sizes in bytes
+type sizeDistribution struct {
+	Min  int64 `json:"min"`
+	Mean int64 `json:"mean"`
+	P50  int64 `json:"p50"`
+	P90  int64 `json:"p90"`
+	P99  int64 `json:"p99"`
+	Max  int64 `json:"max"`
+}
+
+//cardinality is the distinct values of a field, most common first
+type cardinality struct {
+	Distinct int         `json:"distinct"`
+	Values   []statGroup `json:"values"`
+}
+
+//profileReport is the json form of the profile
+type profileReport struct {
+	File          string            `json:"file"`
+	Items         int               `json:"items"`
+	Seed          int64             `json:"seed"`
+	Sizes         sizeDistribution  `json:"sizes"`
+	Fields        []fieldProfile    `json:"fields"`
+	ResourceTypes cardinality       `json:"resourceType"`
+	Regions       cardinality       `json:"awsRegion"`
+	Sample        []json.RawMessage `json:"sample"`
+}
+
+func (p *itemProfile) report(file string, seed int64) profileReport {
+	r := profileReport{
+		File:          file,
+		Items:         p.items,
+		Seed:          seed,
+		Sizes:         distribution(p.sizes),
+		ResourceTypes: cardinality{Distinct: len(p.byType), Values: sortedGroups(p.byType)},
+		Regions:       cardinality{Distinct: len(p.byRegion), Values: sortedGroups(p.byRegion)},
+		Sample:        p.sample,
+	}
+	for _, f := range p.fields {
+		f.Presence = 100 * float64(f.Items) / float64(p.items)
+		r.Fields = append(r.Fields, *f)
+	}
+	sort.Slice(r.Fields, func(i, j int) bool { return r.Fields[i].Path < r.Fields[j].Path })
+	return r
+}
+
+//distribution summarizes sizes, which it sorts
+func distribution(sizes []int64) sizeDistribution {
+	if len(sizes) == 0 {
+		return sizeDistribution{}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	var total int64
+	for _, s := range sizes {
+		total += s
+	}
+	percentile := func(p int) int64 {
+		return sizes[(len(sizes)-1)*p/100]
+	}
+	return sizeDistribution{
+		Min:  sizes[0],
+		Mean: total / int64(len(sizes)),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P99:  percentile(99),
+		Max:  sizes[len(sizes)-1],
+	}
+}
+
+//writeTable prints the profile as aligned text tables, followed by the sampled items
+func (r profileReport) writeTable(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "%8s  %s\n", "presence", "field: types")
+	for _, f := range r.Fields {
+		types := make([]string, 0, len(f.Types))
+		for t, n := range f.Types {
+			types = append(types, fmt.Sprintf("%s %d", t, n))
+		}
+		sort.Strings(types)
+		_, _ = fmt.Fprintf(w, "%7.1f%%  %s: %s\n", f.Presence, f.Path, strings.Join(types, ", "))
+	}
+	_, _ = fmt.Fprintln(w)
+
+	values := []struct {
+		title string
+		c     cardinality
+	}{
+		{"resourceType", r.ResourceTypes},
+		{"awsRegion", r.Regions},
+	}
+	for _, v := range values {
+		_, _ = fmt.Fprintf(w, "%10s  %s (%d distinct)\n", "items", v.title, v.c.Distinct)
+		for i, g := range v.c.Values {
+			if i == profileCardinality {
+				_, _ = fmt.Fprintf(w, "%10s  %d more\n", "...", len(v.c.Values)-i)
+				break
+			}
+			name := g.Name
+			if name == "" {
+				name = "(none)"
+			}
+			_, _ = fmt.Fprintf(w, "%10d  %s\n", g.Items, name)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+
+	s := r.Sizes
+	_, _ = fmt.Fprintf(w, "item sizes: min %s, mean %s, p50 %s, p90 %s, p99 %s, max %s\n\n",
+		byteCountSI(int(s.Min)), byteCountSI(int(s.Mean)), byteCountSI(int(s.P50)),
+		byteCountSI(int(s.P90)), byteCountSI(int(s.P99)), byteCountSI(int(s.Max)))
+
+	_, _ = fmt.Fprintf(w, "%d sampled items (-sample-seed %d):\n", len(r.Sample), r.Seed)
+	for _, item := range r.Sample {
+		_, _ = fmt.Fprintf(w, "%s\n", item)
+	}
+	_, _ = fmt.Fprintf(w, "\n%d items in %s\n", r.Items, r.File)
+}
+
+//runProfile implements the profile subcommand, profiling the items of the input without writing them
+// It reports the presence and types of items' fields, the cardinality of resourceType and awsRegion,
+// the distribution of item sizes and a sample of -profile-sample items, as a table or json (-profile-format).
+func runProfile(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if profileFormat != "table" && profileFormat != "json" {
+		return fmt.Errorf("profile: unknown format %q", profileFormat)
+	}
+	if profileSample < 0 {
+		return fmt.Errorf("profile: -profile-sample %d is negative", profileSample)
+	}
+
+	// the seed is shared with any -sample
+	if selection.Seed == 0 {
+		selection.Seed = time.Now().UnixNano()
+		logger.Infof("sampling with -sample-seed %d", selection.Seed)
+	}
+	seed := selection.Seed
+
+	profile := newItemProfile(profileSample, seed)
+	result, err := decodeInput(config_decoder.FactoryOf(func() config_decoder.ItemWriter {
+		buf := new(bytes.Buffer)
+		return profileWriter{profile: profile, buf: buf, enc: json.NewEncoder(buf)}
+	}))
+	if err != nil {
+		return err
+	}
+	if result.Err != nil {
+		return decodeFailed(fmt.Errorf("profile: %s: %w", result.File, result.Err))
+	}
+
+	r := profile.report(result.File, seed)
+	if profileFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	r.writeTable(os.Stdout)
+	return nil
+}
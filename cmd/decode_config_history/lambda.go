@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"os"
+	"time"
+)
+
+//lambdaResult is the response to an invocation
+type lambdaResult struct {
+	Items int    `json:"items"`
+	RunID string `json:"runId"`
+}
+
+//runLambda implements the lambda subcommand, a Lambda function decoding the AWS Config change events
+// EventBridge invokes it with, as -events decodes them, and writing their items with the configured writer.
+// It handles invocations until the execution environment is shut down.
+func runLambda(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	// lambda.Start exits the process without it
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") == "" {
+		return fmt.Errorf("lambda: AWS_LAMBDA_RUNTIME_API is not set; run this as a Lambda function's bootstrap")
+	}
+
+	spec, err := loadSpec(specFile)
+	if err != nil {
+		return err
+	}
+	if err := spec.Limits.Validate(); err != nil {
+		return err
+	}
+	if err := spec.Selection.Validate(); err != nil {
+		return err
+	}
+	wFactory, err := newWriterFactory()
+	if err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("lambda: %w", err)}
+	}
+
+	logger.Infof("handling AWS Config change events from %s", os.Getenv("AWS_LAMBDA_RUNTIME_API"))
+	lambda.Start(lambdaHandler{spec: spec, wFactory: wFactory}.handle)
+	return nil
+}
+
+//lambdaHandler handles the function's invocations, writing their items with writers from wFactory
+type lambdaHandler struct {
+	spec     config_decoder.ItemTransformSpec
+	wFactory config_decoder.WriterFactory
+}
+
+//handle decodes the change event an invocation delivers
+// A failure fails the invocation, its error typed by its category, so EventBridge retries it.
+func (h lambdaHandler) handle(ctx context.Context, event events.CloudWatchEvent) (lambdaResult, error) {
+	id := event.ID
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		id = lc.AwsRequestID
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return lambdaResult{}, fmt.Errorf("lambda: %w", err)
+	}
+
+	result := decodeInvocation(ctx, id, payload, h.spec, h.wFactory)
+	if result.Err != nil {
+		logResultError(result)
+		return lambdaResult{}, messages.InvokeResponse_Error{Message: result.Err.Error(), Type: errorCategory(result.Err)}
+	}
+	logger.Infof("read %d config items from %s in %s", result.ItemCount, result.File, result.Duration)
+	return lambdaResult{Items: result.ItemCount, RunID: runID}, nil
+}
+
+//decodeInvocation decodes the change events of the invocation id, payload, writing their items with writers
+// from wFactory
+// Decoding is abandoned when ctx is done, at the invocation's deadline, or after -timeout if that's sooner.
+func decodeInvocation(ctx context.Context, id string, payload []byte, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory) runResult {
+	start := time.Now()
+	result := runResult{File: "lambda/" + id, InputBytes: int64(len(payload)), DocumentBytes: int64(len(payload))}
+	spec.Source = result.File
+	defer activeInputs.decoding(result.File)()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	wFactory, out, err := outputFactory(result.File, wFactory)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	poolSpec := newPoolSpec()
+	chStatus, chErrors := config_decoder.DecodeChangeEvents(ctx, bytes.NewReader(payload), wFactory, poolSpec, spec)
+	awaitResult(ctx, chStatus, chErrors, poolSpec.Size, &result)
+	finishOutput(out, &result)
+	result.Duration = time.Since(start)
+	return result
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"testing"
+	"time"
+)
+
+func TestLambdaHandler(t *testing.T) {
+	defer func(d time.Duration, p int) { timeout, poolSize = d, p }(timeout, poolSize)
+	timeout, poolSize = time.Minute, 2
+
+	var event events.CloudWatchEvent
+	if err := json.Unmarshal([]byte(`{"id":"e1","detail-type":"Config Configuration Item Change","source":"aws.config",
+  "time":"2026-10-15T08:14:22Z","detail":{"messageType":"ConfigurationItemChangeNotification",
+  "configurationItemDiff":{"changeType":"UPDATE"},
+  "configurationItem":{"resourceType":"AWS::EC2::Instance","resourceId":"i-1","configuration":{"state":"stopped"}}}}`), &event); err != nil {
+		t.Fatal(err)
+	}
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "req-1"})
+
+	cw := &config_decoder.CollectorWriter{}
+	h := lambdaHandler{wFactory: config_decoder.CollectorWriterFactory(cw)}
+	result, err := h.handle(ctx, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Items != 1 || result.RunID != runID {
+		t.Errorf("result %+v, want 1 item of run %s", result, runID)
+	}
+	items := cw.Items()
+	if len(items) != 1 || items[0]["resourceId"] != "i-1" {
+		t.Fatalf("items written %v, want i-1", items)
+	}
+
+	// an invocation past its deadline is failed with its error's category, so EventBridge retries it
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = h.handle(ctx, event)
+	ire, ok := err.(messages.InvokeResponse_Error)
+	if !ok || ire.Type != errTimeout {
+		t.Errorf("invocation failed with %#v, want a %s error", err, errTimeout)
+	}
+}
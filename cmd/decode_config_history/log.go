@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+)
+
+//logger logs diagnostics to stderr at the level chosen by -quiet, -v and -vv
+// It discards everything until setupLogging is called.
+var logger = zap.NewNop().Sugar()
+
+//heartbeatLog logs -heartbeat's heartbeats as logger would, but at info level whatever logger's level
+var heartbeatLog = zap.NewNop().Sugar()
+
+//logLevel is the least severe level logged: errors with -quiet, info by default,
+// debug with -v and token-level detail with -vv
+func logLevel() zapcore.Level {
+	switch {
+	case quiet:
+		return zapcore.ErrorLevel
+	case veryVerbose:
+		return config_decoder.TraceLevel
+	case verbose:
+		return zapcore.DebugLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
+//encodeLevel names TraceLevel, which zap doesn't know
+func encodeLevel(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	if l == config_decoder.TraceLevel {
+		enc.AppendString("trace")
+		return
+	}
+	zapcore.LowercaseLevelEncoder(l, enc)
+}
+
+//setupLogging creates the logger for -log-format, also used by the decoder library
+// Console logs are just the messages, as they've always been printed; json logs are
+// one object per line with the time, level and message.
+func setupLogging() error {
+	var enc zapcore.Encoder
+	switch logFormat {
+	case "console":
+		enc = zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "msg", LineEnding: zapcore.DefaultLineEnding})
+	case "json":
+		enc = zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+			TimeKey:        "ts",
+			LevelKey:       "level",
+			MessageKey:     "msg",
+			LineEnding:     zapcore.DefaultLineEnding,
+			EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+			EncodeLevel:    encodeLevel,
+			EncodeDuration: zapcore.StringDurationEncoder,
+		})
+	default:
+		return fmt.Errorf("unknown log format %q", logFormat)
+	}
+
+	l := zap.New(zapcore.NewCore(enc, zapcore.Lock(os.Stderr), logLevel()))
+	logger = l.Sugar()
+	heartbeatLog = zap.New(zapcore.NewCore(enc, zapcore.Lock(os.Stderr), zapcore.InfoLevel)).Sugar()
+	config_decoder.SetLogger(l)
+	return nil
+}
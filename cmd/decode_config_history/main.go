@@ -1,18 +1,25 @@
 package main
 
 import (
-	"compress/gzip"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
 	"github.com/mfrasier/decode_json_stream/config_decoder"
-	"go.uber.org/zap"
+	"github.com/mfrasier/decode_json_stream/kafka"
+	"github.com/mfrasier/decode_json_stream/securitylake"
+	"github.com/mfrasier/decode_json_stream/version"
 	"io"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -25,46 +32,565 @@ var (
 	poolSize   int
 	timeout    time.Duration
 	writerKind string
+	breaker    config_decoder.BreakerConfig
+	reuseItems bool
+	readBuffer int
+	bench      bool
+	limits     config_decoder.ItemLimits
+	selection  config_decoder.ItemSelection
+	provenance bool
+	envelope   config_decoder.MetadataEnvelope
+	itemHash   config_decoder.ItemHash
+	ruleIDs    []string
+	ruleFiles  []config_decoder.Rule
+	strict     bool
+	canonical  bool
+	useNumber  bool
+	verbatim   bool
+	transcode  bool
+	useMmap    bool
+	decoders   int
+	specFile   string
+	serveMode  bool
+	watchDir   string
+	listenAddr string
+	pollEvery  time.Duration
+	watchMode  bool
+	ledgerURI  string
+	queueURL   string
+	stateFile  string
+
+	runLedgerURI string
+	onDuplicate  string
+
+	manifestFile string
+	fromDate     string
+	toDate       string
+	concurrency  int
+
+	checkpointFile     string
+	checkpointInterval time.Duration
+	rateLimit          float64
+
+	since           string
+	until           string
+	latestPerRegion bool
+	eventsMode      bool
+
+	spoolDir   string
+	spoolBatch int
+
+	inventoryFormat string
+	graphFormat     string
+	graphTypes      string
+	graphMaxNodes   int
+	findingsFile    string
+	skippedFile     string
+	configCacheSize int
+
+	resourceTypes  string
+	aggregator     string
+	batchGet       bool
+	awsRegion      string
+	awsProfile     string
+	awsMaxRetries  int
+	awsEndpointURL string
+	awsS3PathStyle bool
+	awsInsecure    bool
+	roleARN        string
+	inputRoleARN   string
+	outputRoleARN  string
+	roleExternalID string
+	roleDuration   time.Duration
+	openSearchSign bool
+	openSearch     config_decoder.OpenSearchConfig
+	kafkaBrokers   string
+	kafkaConfig    kafka.Config
+	neo4j          config_decoder.Neo4jConfig
+	sentinel       config_decoder.SentinelConfig
+	chronicle      config_decoder.ChronicleConfig
+	chronicleKey   string
+	writerHTTP     config_decoder.HTTPConfig
+	recordEnvelope config_decoder.RecordEnvelope
+	securityLake   string
+	lakeFileSize   int64
+	configFile     string
+	statsFormat    string
+	statsTop       int
+	largestItems   int
+	profileFormat  string
+	profileSample  int
+	validateMax    int
+	summaryFormat  string
+	summaryFile    string
+	progressEvery  time.Duration
+	dashboardMode  bool
+	outputTemplate string
+	dryRunMode     bool
+	stopOnError    bool
+	verbose        bool
+	veryVerbose    bool
+	quiet          bool
+	logFormat      string
+	cpuProfile     string
+	memProfile     string
+	traceFile      string
+	servePprof     bool
+	showVersion    bool
 )
 
-//signalHandler handles OS termination signals
-func signalHandler() chan bool {
+//dry counts what would have been written in a -dry-run
+var dry *dryRun
+
+//liveStats are the live counters of the run's writer pool workers, totalled by worker number
+var liveStats = config_decoder.NewPoolStats()
+
+//metadataCollisions counts the run's metadata fields that collided with items' own fields
+var metadataCollisions = config_decoder.NewMetadataCollisions()
+
+//fieldMatches counts the run's documents each of the spec's fields was found in
+var fieldMatches = config_decoder.NewFieldMatches()
+
+//errSignalled is the cause of a run's context being cancelled by SIGINT or SIGTERM
+var errSignalled = fmt.Errorf("stopped by signal: %w", context.Canceled)
+
+//signalHandler handles OS termination signals, cancelling with errSignalled the context whose cancel is given,
+// and returns a function that stops handling them
+func signalHandler(cancel context.CancelCauseFunc) func() {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	done := make(chan bool, 1)
+	done := make(chan struct{})
 
 	go func() {
-		sig := <-sigs
-		fmt.Printf("\nreceived signal %s\n", sig)
-		done <- true
+		select {
+		case sig := <-sigs:
+			logger.Warnf("received signal %s", sig)
+			cancel(errSignalled)
+		case <-done:
+		}
 	}()
 
-	return done
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
 }
 
-func parseCmdLine() {
-	flag.StringVar(&inputFile, "file", defaultFile, "name of input file")
+//defineFlags defines the command line flags, which are also the settings of a -config file
+func defineFlags() {
+	flag.StringVar(&configFile, "config", "",
+		"yaml, toml or json file of settings named after these flags, which override it")
+	flag.StringVar(&inputFile, "file", defaultFile,
+		"name of input file, or s3://bucket/prefix of snapshots delivered by AWS Config, decoded as orchestrate does")
 	flag.DurationVar(&timeout, "timeout", 1*time.Hour, "maximum time for program to run (a duration)")
-	flag.StringVar(&writerKind, "writer", "null", "item writer type [null|file]")
+	flag.DurationVar(&fileTimeout, "file-timeout", 0,
+		"maximum time to decode each file or snapshot, after which it's failed and the run goes on to the next, within -timeout;\n"+
+			"in serve mode, which runs until it's stopped, -timeout bounds each file unless this is set")
+	flag.StringVar(&writerKind, "writer", "null",
+		"item writer type [null|file|opensearch|kafka|neo4j|sentinel|chronicle|securitylake], or a URI with the type as scheme and options as query,\n"+
+			"e.g. file:///out/items.ndjson?gzip=true or kafka://broker:9092/topic?format=avro")
+	flag.Var(&writerOpts, "writer-opt",
+		"writer option key=value, repeatable: file path, gzip, append, exclusive, sync, perm, terminator, format; opensearch url, index,\n"+
+			"batch, template, sigv4; kafka brokers, topic, format, schema-registry; neo4j url, database, batch;\n"+
+			"sentinel endpoint, dcr, stream, batch, tenant, client-id, client-secret; chronicle endpoint, customer-id, log-type, batch,\n"+
+			"credentials; securitylake location, file-size; and HTTP writers ca, cert, key, insecure, proxy")
+	flag.StringVar(&outputTemplate, "output", "",
+		"file for -writer file instead of stdout, renamed into place once complete; gzipped if it ends .gz,\n"+
+			"and {basename} and {dir} are replaced by the input's name without extension and its directory;\n"+
+			"a template, e.g. out/{{.awsAccountId}}/{{.resourceType}}.ndjson, routes items to files by their fields")
+	flag.BoolVar(&fileOpts.Append, "file-append", false,
+		"append to -output files in place, with no temporary file, rather than replacing them, e.g. to accumulate a -watch's items")
+	flag.BoolVar(&fileOpts.Exclusive, "file-exclusive", false, "fail an -output file that already exists rather than replacing or appending to it")
+	flag.StringVar(&fileOpts.Sync, "file-sync", "commit",
+		"when -output files are fsynced [commit|flush|none]: once complete, also whenever a writer flushes, or never")
+	flag.Func("file-perm", "octal permissions of -output files created (default 0644)", setFilePerm)
+	flag.StringVar(&fileOpts.Format, "file-format", "json",
+		"format of -writer file items [json|protobuf], protobuf being ConfigurationItem messages each prefixed by its varint length")
+	flag.Func("route-size", "bytes=writer: items whose json is over bytes go to writer as json rather than -writer (repeatable);\n"+
+		"writer is a file, - for stdout, or a file://, opensearch:// or kafka:// writer URI, e.g. 900000=file:///out/large.ndjson", addSizeRoute)
+	flag.BoolVar(&canonical, "canonical", false,
+		"byte-stable output, for diffs between runs and golden files: canonical json from the file and kafka writers, items in\n"+
+			"document order with one decoder and writer, and none of the metadata that differs between runs (ingest_time, decoder_version, run_id)")
+	flag.BoolVar(&useNumber, "use-number", true,
+		"keep numbers as they're written in the input, rather than decoding them as floats, which round integers over 2^53\n"+
+			"and write large ones in scientific notation; -use-number=false decodes them as floats")
+	flag.BoolVar(&verbatim, "verbatim", false,
+		"write -writer file json items as they are in the input: their fields in the input's order, their values as written\n"+
+			"unless changed, less whitespace, and <, > and & unescaped, followed by the fields added, such as the metadata")
+	flag.Func("ingest-time", "RFC 3339 time to stamp items' ingest_time with rather than the time they're decoded, or delivery,\n"+
+		"the time each snapshot or history file was delivered, as its name says, for replays indexed by time; it's kept with\n"+
+		"-canonical, so their output is the same every run", setIngestTime)
+	flag.StringVar(&openSearch.URL, "opensearch-url", "http://localhost:9200",
+		"OpenSearch endpoint for -writer opensearch, with any basic auth credentials as user info, or a secret reference to it")
+	flag.StringVar(&openSearch.Index, "opensearch-index", "config-items", "OpenSearch index for -writer opensearch, or a template naming each item's")
+	flag.IntVar(&openSearch.BatchSize, "opensearch-batch", 500, "items per OpenSearch bulk request")
+	flag.BoolVar(&openSearchSign, "opensearch-sigv4", false,
+		"sign OpenSearch requests with the AWS credentials of -output-role-arn, for Amazon OpenSearch Service")
+	flag.BoolVar(&openSearch.Template, "opensearch-template", false,
+		"create or update an index template mapping item fields before the first bulk write")
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", "localhost:9092", "comma-separated Kafka brokers for -writer kafka")
+	flag.StringVar(&kafkaConfig.Topic, "kafka-topic", "config-items", "Kafka topic for -writer kafka, or a template naming each item's")
+	flag.StringVar(&kafkaConfig.Format, "kafka-format", "json", "Kafka record format [json|avro|protobuf]")
+	flag.StringVar(&kafkaConfig.SchemaRegistry, "schema-registry", "",
+		"Schema Registry url with which avro and protobuf records' schema is registered, or a secret reference to it")
+	flag.StringVar(&neo4j.URL, "neo4j-url", "http://localhost:7474",
+		"Neo4j HTTP endpoint for -writer neo4j, with any basic auth credentials as user info, or a secret reference to it")
+	flag.StringVar(&neo4j.Database, "neo4j-database", "neo4j", "Neo4j database for -writer neo4j")
+	flag.IntVar(&neo4j.BatchSize, "neo4j-batch", 500, "items per Neo4j transaction")
+	flag.StringVar(&sentinel.Endpoint, "sentinel-endpoint", "",
+		"logs ingestion endpoint of the data collection rule (or endpoint) -writer sentinel posts to")
+	flag.StringVar(&sentinel.RuleID, "sentinel-dcr", "", "immutable id of the data collection rule of -writer sentinel")
+	flag.StringVar(&sentinel.Stream, "sentinel-stream", "Custom-ConfigItems_CL", "stream of the data collection rule items are posted to")
+	flag.IntVar(&sentinel.BatchSize, "sentinel-batch", 500, "most items per Logs Ingestion API request, which is also kept under 1 MB")
+	flag.StringVar(&sentinel.TenantID, "sentinel-tenant", os.Getenv("AZURE_TENANT_ID"),
+		"Entra ID tenant of the application -writer sentinel authenticates as")
+	flag.StringVar(&sentinel.ClientID, "sentinel-client-id", os.Getenv("AZURE_CLIENT_ID"),
+		"client id of the application -writer sentinel authenticates as")
+	flag.StringVar(&sentinel.ClientSecret, "sentinel-client-secret", "env:AZURE_CLIENT_SECRET",
+		"secret reference to the client secret of the application -writer sentinel authenticates as")
+	flag.StringVar(&chronicle.Endpoint, "chronicle-endpoint", "https://malachiteingestion-pa.googleapis.com",
+		"regional Chronicle ingestion API endpoint -writer chronicle sends entities to")
+	flag.StringVar(&chronicle.CustomerID, "chronicle-customer-id", "", "customer id of the Chronicle instance of -writer chronicle")
+	flag.StringVar(&chronicle.LogType, "chronicle-log-type", "AWS_CONFIG", "Chronicle log type entities are ingested as")
+	flag.IntVar(&chronicle.BatchSize, "chronicle-batch", 500, "most entities per ingestion API request, which is also kept under 1 MB")
+	flag.StringVar(&chronicleKey, "chronicle-credentials", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
+		"json key file, or secret reference to the json key, of the service account -writer chronicle authenticates as")
+	flag.StringVar(&writerHTTP.CAFile, "writer-ca", "",
+		"PEM file of certificate authorities HTTP writers (opensearch, neo4j, sentinel, chronicle) trust besides the system's")
+	flag.StringVar(&writerHTTP.CertFile, "writer-cert", "", "PEM client certificate HTTP writers present, with -writer-key")
+	flag.StringVar(&writerHTTP.KeyFile, "writer-key", "", "PEM key of -writer-cert")
+	flag.BoolVar(&writerHTTP.InsecureSkipVerify, "writer-insecure", false,
+		"skip verifying the certificates of HTTP writers' servers; never in production")
+	flag.StringVar(&writerHTTP.Proxy, "writer-proxy", "",
+		"url of the proxy HTTP writers go through, or none; by default that of $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY")
+	flag.StringVar(&securityLake, "security-lake", "",
+		"s3:// location of the Security Lake custom source -writer securitylake writes to, e.g.\n"+
+			"s3://aws-security-data-lake-us-east-1-abc/ext/config-history/1.0/")
+	flag.Int64Var(&lakeFileSize, "security-lake-file-size", securitylake.DefaultFileSize>>20,
+		"MiB at which -writer securitylake rolls over to a new Parquet object")
 	flag.IntVar(&poolSize, "pool-size", runtime.GOMAXPROCS(0), "writer pool size")
+	flag.IntVar(&breaker.Threshold, "breaker-threshold", 0,
+		"consecutive write failures that pause a writer (0 disables the circuit breaker)")
+	flag.DurationVar(&breaker.Cooldown, "breaker-cooldown", 5*time.Second,
+		"time between sink health probes while a writer is paused")
+	flag.IntVar(&breaker.BufferSize, "breaker-buffer", 1000, "items held in memory per paused writer")
+	flag.StringVar(&breaker.SpillDir, "spill-dir", "",
+		"directory to spill items to when a paused writer's buffer is full")
+	flag.DurationVar(&breaker.DrainTimeout, "breaker-drain-timeout", 30*time.Second,
+		"time a paused writer waits for its sink to recover once decoding ends, before failing the items it holds\n"+
+			"(kept in -spill-dir if set)")
+	flag.StringVar(&spoolDir, "spool-dir", "",
+		"directory of a disk-backed queue between decoding and the writer, delivering items as fast as the sink\n"+
+			"takes them; items not yet delivered are delivered the next time it's used (not with -writer file)")
+	flag.IntVar(&spoolBatch, "spool-batch", config_decoder.DefaultSpoolBatch,
+		"items delivered from -spool-dir before they're acknowledged, and not delivered again")
+	flag.BoolVar(&useMmap, "mmap", false,
+		"memory-map an uncompressed input file; parent fields may then follow the items array")
+	flag.IntVar(&decoders, "decoders", 1, "goroutines decoding the items array in parallel (requires -mmap)")
+	flag.IntVar(&configCacheSize, "config-cache", 0,
+		"MiB of item configurations decoded once and shared by the items repeating them, as fleets of identical resources do,\n"+
+			"sparing the CPU of decoding and hashing each again (default 0, off)")
+	flag.IntVar(&readBuffer, "read-buffer", 1<<20, "input read buffer size in bytes")
+	flag.BoolVar(&transcode, "transcode", false,
+		"transcode UTF-16 input to UTF-8 (a UTF-8 byte order mark is always skipped)")
+	flag.IntVar(&limits.MaxItemSize, "max-item-size", 0, "largest item in bytes emitted as is (0 is unlimited)")
+	flag.StringVar((*string)(&limits.Oversize), "oversize", string(config_decoder.OversizeTruncate),
+		"policy for items over -max-item-size [truncate|offload|deadletter]")
+	flag.StringVar(&limits.OffloadDir, "offload-dir", "", "directory for offloaded and dead-lettered items")
+	flag.Int64Var(&limits.MaxInFlight, "max-in-flight", 0,
+		"bytes of decoded items waiting to be written before decoding pauses (0 is unlimited)")
+	flag.IntVar(&limits.LazyFieldSize, "lazy-field-size", 0,
+		"bytes of an item field's json over which it's kept raw rather than decoded, and with -mmap read again\n"+
+			"from the input as it's written, so a huge configuration isn't held in memory decoded (0 decodes every field)")
+	flag.Func("quota", "cap the items of a resource type decoded from each file, resourceType=N, repeatable,\n"+
+		"e.g. AWS::EC2::NetworkInterface=100000", setQuota)
+	flag.StringVar((*string)(&limits.OverQuota), "over-quota", string(config_decoder.OverQuotaDrop),
+		"policy for items over their -quota [drop|sample|deadletter]; deadletter appends them to -offload-dir")
+	flag.Int64Var(&limits.QuotaSample, "quota-sample", 100, "emit 1 in this many items over their -quota with -over-quota sample")
+	flag.Int64Var(&selection.MaxItems, "max-items", 0, "stop after emitting this many items, leaving the rest unread (0 is unlimited)")
+	flag.Func("sample", "emit a random sample of items, 1/N or a percentage such as 5%", setSample)
+	flag.Int64Var(&selection.Seed, "sample-seed", 0, "seed for -sample and profile's sample, to repeat a sample (0 picks one and logs it)")
+	flag.StringVar(&specFile, "spec", "", "json transform spec file (default is the AWS Config snapshot spec)")
+	flag.BoolVar(&strict, "strict", false,
+		"fail unless the input's top-level fields are exactly those of the spec, each once, to catch the wrong or a corrupt file early")
+	flag.BoolVar(&eventsMode, "events", false,
+		"read -file as a stream of AWS Config change events delivered by EventBridge, rather than a snapshot,\n"+
+			"writing the configuration item of each with its change_type")
+	flag.BoolVar(&serveMode, "serve", false, "run indefinitely, decoding files arriving in -watch-dir")
+	flag.StringVar(&watchDir, "watch-dir", "", "directory to take input files from in serve mode")
+	flag.StringVar(&queueURL, "sqs-queue-url", "",
+		"SQS queue receiving the S3 event notifications of new snapshots, decoded in serve and watch modes instead of -watch-dir;\n"+
+			"requires -ledger")
+	flag.StringVar(&listenAddr, "listen", ":8080", "address for the /healthz and /metrics endpoints in serve mode")
+	flag.BoolVar(&watchMode, "watch", false, "decode files arriving in -watch-dir until interrupted")
+	flag.StringVar(&ledgerURI, "ledger", "",
+		"ledger of decoded files in watch and serve modes, file://path or bolt://path (default <watch-dir>/.decode_config_history_state.json)")
+	flag.StringVar(&stateFile, "state", "",
+		"bolt database of the latest known configuration of each resource, which materialize brings up to date")
+	flag.DurationVar(&pollEvery, "poll-interval", 10*time.Second, "how often serve mode looks for new files")
+	flag.StringVar(&resourceTypes, "resource-types", "",
+		"comma-separated resource types to query from the AWS Config service instead of reading -file, or \"all\"")
+	flag.StringVar(&aggregator, "aggregator", "", "AWS Config aggregator to query with -resource-types (default is the account)")
+	flag.BoolVar(&batchGet, "batch-get", false,
+		"fetch the resources -resource-types selects with BatchGetResourceConfig, whose items have all the properties of a snapshot's")
+	flag.StringVar(&awsRegion, "region", "", "AWS region of AWS clients, e.g. to query with -resource-types (default $AWS_REGION)")
+	flag.StringVar(&awsProfile, "aws-profile", "",
+		"profile of the shared AWS config and credentials files to take credentials from (default the environment's keys, or $AWS_PROFILE)")
+	flag.IntVar(&awsMaxRetries, "aws-max-retries", awsconfig.DefaultMaxRetries,
+		"how many times a throttled or failed AWS request is retried, with backoff")
+	flag.StringVar(&awsEndpointURL, "aws-endpoint-url", "",
+		"endpoint of every AWS service, e.g. http://localhost:4566 for LocalStack ($AWS_ENDPOINT_URL_<SERVICE> overrides it for one)")
+	flag.BoolVar(&awsS3PathStyle, "aws-s3-path-style", false,
+		"address S3 buckets path-style, as they always are at -aws-endpoint-url, e.g. for bucket names with dots")
+	flag.BoolVar(&awsInsecure, "aws-insecure-skip-verify", false,
+		"don't verify the TLS certificates of AWS endpoints, e.g. a test MinIO's self-signed one; never in production")
+	flag.StringVar(&roleARN, "role-arn", "", "IAM role the AWS clients assume, refreshing its credentials before they expire")
+	flag.StringVar(&inputRoleARN, "input-role-arn", "",
+		"IAM role assumed to read snapshots from S3 or query AWS Config, e.g. of a log archive account (default -role-arn)")
+	flag.StringVar(&outputRoleARN, "output-role-arn", "",
+		"IAM role assumed to write items to AWS services, e.g. of a security tooling account (default -role-arn)")
+	flag.StringVar(&roleExternalID, "role-external-id", "", "external id required to assume the roles of another account")
+	flag.DurationVar(&roleDuration, "role-duration", 0, "how long assumed roles' credentials last before they're refreshed (default the role's)")
+	flag.StringVar(&manifestFile, "manifest", "",
+		"yaml, toml or json organization manifest of the accounts, regions and buckets orchestrate decodes snapshots from")
+	flag.StringVar(&fromDate, "from", "", "first day, YYYY-MM-DD, of the snapshots orchestrate decodes (default -to)")
+	flag.StringVar(&toDate, "to", "", "last day, YYYY-MM-DD, of the snapshots orchestrate decodes (default today, UTC)")
+	flag.IntVar(&concurrency, "concurrency", 4,
+		"snapshots orchestrate, or an s3:// -file, lists or decodes at once, over all accounts and regions,\n"+
+			"each with its own -pool-size writers")
+	flag.StringVar(&since, "since", "",
+		"decode the snapshots in S3 captured at or after this YYYY-MM-DD date or RFC 3339 time, as named in their keys")
+	flag.StringVar(&until, "until", "", "decode the snapshots in S3 captured before this YYYY-MM-DD date or RFC 3339 time")
+	flag.BoolVar(&latestPerRegion, "latest-per-region", false,
+		"decode only the latest snapshot in S3 of each account and region, after -since and -until")
+	flag.StringVar(&runLedgerURI, "run-ledger", "",
+		"ledger of the inputs decoded, with the configuration they were decoded with, file://path or bolt://path;\n"+
+			"decoding an unchanged input again with the same configuration is refused, or with -on-duplicate warn, warned of")
+	flag.StringVar(&onDuplicate, "on-duplicate", "refuse", "what to do with an input the -run-ledger records as decoded [refuse|warn]")
+	flag.StringVar(&checkpointFile, "checkpoint", "",
+		"json file backfill checkpoints the snapshots it's decoded to, resuming the backfill it records if it exists")
+	flag.DurationVar(&checkpointInterval, "checkpoint-interval", 30*time.Second, "how often backfill saves its -checkpoint")
+	flag.Float64Var(&rateLimit, "rate-limit", 0,
+		"most items written a second, over all the snapshots decoded at once, e.g. to spare a production sink (default no limit)")
+	flag.StringVar(&statsFormat, "stats-format", "table", "stats output format [table|json]")
+	flag.IntVar(&statsTop, "stats-top", 10, "largest items listed by stats")
+	flag.IntVar(&largestItems, "largest", 0,
+		"list this many of the largest items written, by size as encoded for the sink, with their ARN and resourceType,\n"+
+			"in the run summary")
+	flag.StringVar(&profileFormat, "profile-format", "table", "profile output format [table|json]")
+	flag.IntVar(&profileSample, "profile-sample", 5, "items kept in profile's random sample, chosen with -sample-seed")
+	flag.StringVar(&inventoryFormat, "inventory-format", "csv", "inventory output format [csv|markdown]")
+	flag.StringVar(&graphFormat, "graph-format", "graphml", "graph output format [graphml|dot]")
+	flag.StringVar(&graphTypes, "graph-types", "",
+		"comma-separated resource types in graph's graph, which may be patterns such as AWS::EC2::* (default all)")
+	flag.IntVar(&graphMaxNodes, "graph-max-nodes", 5000, "most resources graph holds in memory before failing")
+	flag.IntVar(&validateMax, "validate-max", 100, "problems listed by validate (0 lists all)")
+	flag.StringVar(&summaryFormat, "summary-format", "text", "run summary printed on exit [text|json]")
+	flag.StringVar(&summaryFile, "summary-file", "", "file for the json run summary (default stderr)")
+	flag.StringVar(&runManifestFile, "run-manifest", "",
+		"json file listing every input the run decoded, with its items, bytes, errors and the outputs it completed, written\n"+
+			"on exit for downstream jobs to consume exactly what was produced")
+	flag.StringVar(&skippedFile, "skipped-file", "",
+		"file the raw values of skipped top-level fields are written to as ndjson, with their source and field")
+	flag.DurationVar(&progressEvery, "progress-interval", 10*time.Second,
+		"how often progress is logged when stderr isn't a terminal, which shows a progress bar instead (0 disables)")
+	flag.DurationVar(&heartbeatEvery, "heartbeat", 0,
+		"log a heartbeat this often, even with -quiet: the run's items and bytes so far, items/sec, errors, busy workers and\n"+
+			"the files being decoded, as evidence of liveness and throughput for unattended runs (0 disables)")
+	flag.BoolVar(&dashboardMode, "dashboard", false,
+		"show a live dashboard of worker throughput, busy workers, errors and recent resource types\n"+
+			"in place of the progress bar; needs a terminal")
+	flag.BoolVar(&stopOnError, "stop-on-error", false,
+		"stop at the first write error, and in serve and watch modes at the first file that fails;\n"+
+			"otherwise the run continues and exits non-zero at the end")
+	flag.BoolVar(&dryRunMode, "dry-run", false,
+		"decode and transform items without writing them, reporting what would have been written where")
+	flag.BoolVar(&verbose, "v", false, "log debug detail, such as fields skipped")
+	flag.BoolVar(&veryVerbose, "vv", false, "log token-level detail as well as -v")
+	flag.BoolVar(&quiet, "quiet", false, "log errors only")
+	flag.StringVar(&logFormat, "log-format", "console", "log format [console|json]")
+	flag.StringVar(&cpuProfile, "cpuprofile", "", "write a CPU profile to this file")
+	flag.StringVar(&memProfile, "memprofile", "", "write a heap profile to this file on exit")
+	flag.StringVar(&traceFile, "trace", "", "write an execution trace to this file")
+	flag.BoolVar(&servePprof, "pprof", false, "serve the /debug/pprof endpoints on -listen in serve mode")
+	flag.BoolVar(&showVersion, "version", false, "print the version and exit")
+	flag.BoolVar(&bench, "bench", false, "report items/sec and MB/sec throughput on exit")
+	flag.BoolVar(&reuseItems, "reuse-items", true, "reuse item maps once written to reduce allocations")
+	flag.BoolVar(&provenance, "provenance", true,
+		"stamp items with their source_file, source_index and source_offset_start/end, to trace them to their source")
+	flag.Func("metadata-envelope",
+		"shape of the metadata added to items [both|nested|flat]: nested under metadata, copied to the top level, or both (default both)",
+		setEnvelope)
+	flag.StringVar(&envelope.Prefix, "metadata-prefix", "",
+		"prefix of the metadata fields copied to the top level of items, such as _meta_")
+	flag.StringVar((*string)(&envelope.OnCollision), "metadata-collision", string(config_decoder.CollisionOverwrite),
+		"policy for metadata fields named like an item's own [overwrite|preserve|prefix|error]; prefix adds them as _meta_<name>")
+	flag.StringVar(&recordEnvelope.Format, "record-envelope", "",
+		"wrap each item written in an event record [cloudevents|template]: a CloudEvents 1.0 event, or -record-template's")
+	flag.Func("record-template", "file of a text/template of the json record -record-envelope template wraps items in", setRecordTemplate)
+	flag.StringVar(&recordEnvelope.Source, "event-source", "",
+		"source of -record-envelope events, or a template naming each item's (default arn:aws:config:<region>:<account>)")
+	flag.StringVar(&recordEnvelope.Type, "event-type", config_decoder.DefaultEventType, "type of -record-envelope events")
+	flag.StringVar(&itemHash.Field, "hash-field", "",
+		"field to stamp items with the SHA-256 of their canonical configuration in, e.g. config_hash, for change detection")
+	flag.Func("hash-fields", "comma-separated fields hashed into -hash-field (default configuration)", setHashFields)
+	flag.Func("rules", "comma-separated rules checking items as they're decoded, or all:\n"+
+		"s3-bucket-public, ebs-volume-unencrypted, security-group-open-ingress", setRules)
+	flag.Func("rules-file", "yaml or json file of CEL rules checking items as they're decoded (repeatable)", addRulesFile)
+	flag.StringVar(&findingsFile, "findings", "",
+		"file the findings of the rules are written to as ndjson, - for stdout, or an opensearch:// or kafka:// writer URI")
+}
 
-	flag.Parse()
+//setRules sets the rules checked by -rules
+func setRules(s string) error {
+	ruleIDs = nil
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ruleIDs = append(ruleIDs, id)
+		}
+	}
+	_, err := config_decoder.SelectRules(ruleIDs)
+	return err
 }
 
-//createLogger builds a zap loqger
-func createLogger() (*zap.SugaredLogger, error) {
-	zapLogger, err := zap.NewDevelopment()
+//addRulesFile loads the rules of a -rules-file
+func addRulesFile(path string) error {
+	rules, err := config_decoder.LoadRules(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to instantiate zap logger: %s", err)
+		return err
 	}
-	defer func(logger *zap.Logger) {
-		err := logger.Sync()
-		if err != nil {
-			log.Printf("zap logger sync failed: %s\n", err)
+	ruleFiles = append(ruleFiles, rules...)
+	return addRuleFileSum(path)
+}
+
+//setHashFields sets the fields hashed into -hash-field
+func setHashFields(s string) error {
+	itemHash.Fields = nil
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			itemHash.Fields = append(itemHash.Fields, f)
+		}
+	}
+	return nil
+}
+
+//setEnvelope sets the shape of the metadata envelope
+func setEnvelope(s string) error {
+	e := config_decoder.MetadataEnvelope{Shape: s}
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	envelope.Shape = s
+	return nil
+}
+
+//setRecordTemplate loads the template of -record-envelope template records
+func setRecordTemplate(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	recordEnvelope.Template = string(b)
+	return nil
+}
+
+//setQuota adds a quota of items of a resourceType, from resourceType=N
+func setQuota(s string) error {
+	resourceType, n, ok := strings.Cut(s, "=")
+	quota, err := strconv.ParseInt(n, 10, 64)
+	if !ok || resourceType == "" || err != nil || quota < 0 {
+		return fmt.Errorf("want resourceType=N, not %q", s)
+	}
+	if limits.Quotas == nil {
+		limits.Quotas = make(map[string]int64)
+	}
+	limits.Quotas[resourceType] = quota
+	return nil
+}
+
+//setSample sets the sample rate from 1/N or a percentage
+func setSample(s string) error {
+	var rate float64
+	var err error
+	if n, ok := strings.CutPrefix(s, "1/"); ok {
+		var d float64
+		if d, err = strconv.ParseFloat(n, 64); err == nil && d >= 1 {
+			rate = 1 / d
+		}
+	} else if p, ok := strings.CutSuffix(s, "%"); ok {
+		if rate, err = strconv.ParseFloat(p, 64); err == nil {
+			rate /= 100
+		}
+	}
+	if err != nil || rate <= 0 || rate > 1 {
+		return fmt.Errorf("want 1/N or a percentage up to 100%%, not %q", s)
+	}
+	selection.SampleRate = rate
+	return nil
+}
+
+//parseError is a command line parse error, which the flag package has already printed
+type parseError struct {
+	error
+}
+
+func (e parseError) Unwrap() error {
+	return e.error
+}
+
+//parseArgs parses command line args, then applies CHD_ environment variables and any -config file
+// to flags not given in args, in that order of precedence, and the options of the -writer URI and
+// -writer-opt flags, and sets up logging, profiling and any heartbeat.
+func parseArgs(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return parseError{err}
+	}
+	if err := applyEnv(flag.CommandLine, ""); err != nil {
+		return err
+	}
+	if configFile != "" {
+		if err := applyConfigFile(configFile); err != nil {
+			return err
 		}
-	}(zapLogger)
+	}
+	if err := checkFlags(); err != nil {
+		return err
+	}
+	if err := setupLogging(); err != nil {
+		return err
+	}
+	if err := resolveWriter(); err != nil {
+		return err
+	}
+	if err := startProfiling(); err != nil {
+		return err
+	}
+	startHeartbeat()
+	return nil
+}
+
+//checkFlags checks flags that only take effect with others are given with them
+func checkFlags() error {
+	if decoders < 1 {
+		return fmt.Errorf("checkFlags: -decoders %d must be at least 1", decoders)
+	}
+	if decoders > 1 && !useMmap {
+		return fmt.Errorf("checkFlags: -decoders %d requires -mmap, as only a memory-mapped file is decoded in parallel", decoders)
+	}
+	return nil
+}
+
+//countingReader counts the bytes read through it
+// The count may be read while another goroutine reads through it.
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
 
-	return zapLogger.Sugar(), nil
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
 }
 
 // formats number as human readable
@@ -83,100 +609,367 @@ func byteCountSI(b int) string {
 		float64(b)/float64(div), "kMGTPE"[exp])
 }
 
+//newWriterFactory creates the writer factory for the pool from the command line
+func newWriterFactory() (config_decoder.WriterFactory, error) {
+	if err := resolveWriterSecrets(); err != nil {
+		return nil, fmt.Errorf("newWriterFactory: %w", err)
+	}
+	switch writerKind {
+	case "null":
+		return config_decoder.NullWriterFactory(), nil
+	case "file":
+		return config_decoder.EncodedFileWriterFactory(os.Stdout, fileOpts.encoding()), nil
+	case "opensearch":
+		if err := applyWriterHTTP(&openSearch.HTTPClient); err != nil {
+			return nil, err
+		}
+		if err := signOpenSearch(&openSearch); err != nil {
+			return nil, err
+		}
+		return config_decoder.OpenSearchWriterFactory(openSearch)
+	case "kafka":
+		kafkaConfig.Brokers = strings.Split(kafkaBrokers, ",")
+		kafkaConfig.Canonical = canonical
+		return kafka.WriterFactory(kafkaConfig)
+	case "neo4j":
+		if err := applyWriterHTTP(&neo4j.HTTPClient); err != nil {
+			return nil, err
+		}
+		return config_decoder.Neo4jWriterFactory(neo4j)
+	case "sentinel":
+		if err := applyWriterHTTP(&sentinel.HTTPClient); err != nil {
+			return nil, err
+		}
+		return config_decoder.SentinelWriterFactory(sentinel)
+	case "chronicle":
+		if err := applyWriterHTTP(&chronicle.HTTPClient); err != nil {
+			return nil, err
+		}
+		ref := chronicleKey
+		if !isSecretRef(ref) {
+			ref = "file:" + ref
+		}
+		key, err := resolveSecret(context.Background(), ref)
+		if err != nil {
+			return nil, fmt.Errorf("newWriterFactory: -chronicle-credentials: %w", err)
+		}
+		chronicle.Credentials = []byte(key)
+		return config_decoder.ChronicleWriterFactory(chronicle)
+	case "securitylake":
+		return securityLakeWriterFactory()
+	default:
+		return nil, fmt.Errorf("unknown writer type %q specified", writerKind)
+	}
+}
+
+//applyWriterHTTP sets client to one configured by the -writer-ca, -writer-cert, -writer-key,
+// -writer-insecure and -writer-proxy flags, if any are set, leaving the writer's default otherwise
+func applyWriterHTTP(client **http.Client) error {
+	if writerHTTP.IsZero() {
+		return nil
+	}
+	c, err := writerHTTP.Client(time.Minute)
+	if err != nil {
+		return fmt.Errorf("applyWriterHTTP: %w", err)
+	}
+	*client = c
+	return nil
+}
+
+//signOpenSearch has cfg's requests signed with SigV4 if -opensearch-sigv4 is set, and they aren't already
+// The session's HTTP client sends them unless cfg has one.
+func signOpenSearch(cfg *config_decoder.OpenSearchConfig) error {
+	if !openSearchSign || cfg.Sign != nil {
+		return nil
+	}
+	session, err := newAWSSession(context.Background(), awsOutput)
+	if err != nil {
+		return err
+	}
+	if cfg.Sign, err = session.Signer("es", ""); err != nil {
+		return err
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = session.HTTPClient(time.Minute)
+	}
+	return nil
+}
+
+//lakeBucketRegion matches the region in the name of a Security Lake bucket
+var lakeBucketRegion = regexp.MustCompile(`^aws-security-data-lake-([a-z]{2}(?:-gov)?-[a-z]+-\d)-`)
+
+//securityLakeWriterFactory creates the writer factory of -security-lake, storing objects with the output
+// role's S3 client in the region of the lake's bucket, or else the session's
+func securityLakeWriterFactory() (config_decoder.WriterFactory, error) {
+	if !strings.HasPrefix(securityLake, "s3://") {
+		return nil, fmt.Errorf("-writer securitylake requires an s3:// -security-lake location")
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(securityLake, "s3://"), "/")
+	var region string
+	if m := lakeBucketRegion.FindStringSubmatch(bucket); m != nil {
+		region = m[1]
+	}
+
+	session, err := newAWSSession(context.Background(), awsOutput)
+	if err != nil {
+		return nil, err
+	}
+	client, err := session.S3Client(region)
+	if err != nil {
+		return nil, err
+	}
+	return securitylake.WriterFactory(securitylake.Config{Bucket: bucket, Prefix: prefix, FileSize: lakeFileSize << 20,
+		RunID: runID, Put: client.PutObject})
+}
+
+//commands are the subcommands; decode is run if none is named
+var commands = map[string]func(args []string) error{
+	"decode":      runDecode,
+	"stats":       runStats,
+	"profile":     runProfile,
+	"validate":    runValidate,
+	"diff":        runDiff,
+	"inventory":   runInventory,
+	"graph":       runGraph,
+	"materialize": runMaterialize,
+	"generate":    runGenerate,
+	"ddl":         runDDL,
+	"config":      runConfig,
+	"orchestrate": runOrchestrate,
+	"backfill":    runBackfill,
+	"lambda":      runLambda,
+}
+
+//usage prints the subcommands and the flags they share
+func usage() {
+	out := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(out, "Usage: %s [command] [flags] [args]\n\n", filepath.Base(os.Args[0]))
+	_, _ = fmt.Fprintln(out, "Commands:")
+	_, _ = fmt.Fprintln(out, "  decode       decode a snapshot, writing its items (the default)")
+	_, _ = fmt.Fprintln(out, "  stats        summarize a snapshot's items without writing them")
+	_, _ = fmt.Fprintln(out, "  profile      profile the fields, values and sizes of a snapshot's items, with a sample of them")
+	_, _ = fmt.Fprintln(out, "  validate     check a snapshot's integrity, reporting problems as json")
+	_, _ = fmt.Fprintln(out, "  diff         compare the items of two snapshots")
+	_, _ = fmt.Fprintln(out, "  inventory    list a snapshot's resources, one row each, as CSV or a Markdown table")
+	_, _ = fmt.Fprintln(out, "  graph        write the graph of a snapshot's resources and relationships as GraphML or DOT")
+	_, _ = fmt.Fprintln(out, "  materialize  write the items of resources new or changed since a -state store last saw them")
+	_, _ = fmt.Fprintln(out, "  generate     write a snapshot for testing")
+	_, _ = fmt.Fprintln(out, "  ddl          print a table definition for decoded items")
+	_, _ = fmt.Fprintln(out, "  config       validate a -config file")
+	_, _ = fmt.Fprintln(out, "  orchestrate  decode the snapshots in S3 of the accounts and regions of a -manifest")
+	_, _ = fmt.Fprintln(out, "  backfill     orchestrate a long reprocessing of snapshots in S3, resumable from its -checkpoint")
+	_, _ = fmt.Fprintln(out, "  lambda       run as a Lambda function decoding the AWS Config change events it's invoked with")
+	_, _ = fmt.Fprintln(out, "\nFlags may also be set by environment variables, e.g. CHD_POOL_SIZE for -pool-size,")
+	_, _ = fmt.Fprintln(out, "or CHD_GENERATE_COUNT for generate's -count; flags given override them.")
+	_, _ = fmt.Fprintln(out, "\nFlags of decode, stats, profile, validate, diff, inventory, graph, materialize, orchestrate, backfill and lambda:")
+	flag.PrintDefaults()
+}
+
 func main() {
-	logger, err := createLogger()
+	// parse errors are returned, to exit with exitUsage rather than the flag package's 2
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	defineFlags()
+	flag.Usage = usage
+
+	name, args := "decode", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	run, ok := commands[name]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(exitUsage)
+	}
+	err := run(args)
+	if fErr := closeFindings(); err == nil {
+		err = fErr
+	}
+	if rErr := closeRoutes(); err == nil {
+		err = rErr
+	}
+	if sErr := closeSkipped(); err == nil {
+		err = sErr
+	}
+	if lErr := closeRunLedger(); err == nil && lErr != nil {
+		err = &exitError{code: exitWrite, err: lErr}
+	}
+	logUnmatchedFields()
+	logConfigCache()
+	stopHeartbeat()
+	stopProfiling()
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		// flag parse errors have already been printed, with usage
+		if !errors.As(err, &parseError{}) {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(exitCode(err))
 	}
+}
 
+//runDecode implements the decode subcommand, writing the items of the input with the configured writer
+func runDecode(args []string) error {
 	start := time.Now()
-	chSignalHandler := signalHandler()
 
-	// get any config values from command line
-	parseCmdLine()
+	// get any config values from command line and config file
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if showVersion {
+		fmt.Printf("%s %s\n", filepath.Base(os.Args[0]), version.Get())
+		return nil
+	}
+
+	if summaryFormat != "text" && summaryFormat != "json" {
+		return fmt.Errorf("unknown summary format %q", summaryFormat)
+	}
 
-	in, err := os.Open(inputFile)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if strings.HasPrefix(inputFile, "s3://") {
+		return decodeS3Prefix(start)
 	}
-	defer in.Close()
 
-	// handle gzipped or uncompressed files
-	var r io.Reader
-	if strings.HasSuffix(inputFile, ".gz") {
-		r, err = gzip.NewReader(in)
+	// create writer factory for pool; a dry run doesn't touch the configured writer's sink
+	var wFactory config_decoder.WriterFactory
+	var err error
+	if dryRunMode {
+		if err := validateSettings(); err != nil {
+			return err
+		}
+		dry = &dryRun{}
+		defer dry.report(os.Stderr)
+	} else if wFactory, err = newWriterFactory(); err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("%w\nfor help, run %s -h", err, os.Args[0])}
+	}
+	summary := newRunSummary(start)
+
+	// with a spool, the configured writer delivers what's decoded into it
+	var sd *spoolDrain
+	if spoolDir != "" && !dryRunMode {
+		if sd, err = startSpool(wFactory); err != nil {
+			return err
+		}
+		wFactory = sd.factory()
+	}
+
+	if serveMode || watchMode {
+		spec, err := loadValidSpec(specFile)
 		if err != nil {
-			_, _ = fmt.Fprintf(os.Stdout, "gzip error reading input file: %s\n", err)
+			return err
+		}
+		err = serve(spec, wFactory, newPoolSpec(), serveMode, summary)
+		if sd != nil {
+			summary.addDelivery(sd.finish())
 		}
-	} else {
-		r = in
+		logMetadataCollisions()
+		if mErr := emitRunManifest(summary); err == nil {
+			err = mErr
+		}
+		if sErr := emitSummary(summary); err == nil {
+			err = sErr
+		}
+		if err == nil {
+			err = summary.err()
+		}
+		return err
 	}
 
-	_, _ = fmt.Fprintf(os.Stderr, "opened file %s\n", inputFile)
+	// a duplicate is refused before its output is created
+	mark, err := checkFileRun(inputFile)
+	if err != nil {
+		if sd != nil {
+			sd.cancel()
+			sd.finish()
+		}
+		return err
+	}
+	wFactory, out, err := outputFactory(inputFile, wFactory)
+	if err != nil {
+		return decodeFailed(err)
+	}
+	result, err := decodeInput(wFactory)
+	if err != nil {
+		if out != nil {
+			out.abort()
+		}
+		if sd != nil {
+			sd.cancel()
+			sd.finish()
+		}
+		return err
+	}
+	finishOutput(out, &result)
+	recordRun(mark, result)
+	if result.Err != nil {
+		logResultError(result)
+	}
 
-	// create context for downstream
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	summary.add(result)
+	if sd != nil {
+		logger.Infof("decoded %d config items into %s in %s", result.ItemCount, spoolDir, time.Since(start))
+		summary.addDelivery(sd.finish())
+	}
+	logMetadataCollisions()
+	if err := emitRunManifest(summary); err != nil {
+		return err
+	}
+	if summaryFormat == "json" {
+		if err := emitSummary(summary); err != nil {
+			return err
+		}
+		return summary.err()
+	}
 
-	spec := config_decoder.ItemTransformSpec{
-		Fields: map[string]string{
-			"configSnapshotId": "",
-			"fileVersion":      "",
-		},
-		ItemsField: "configurationItems",
+	for _, s := range result.Workers {
+		logger.Debugf("worker status message: %+v", s)
 	}
 
-	// create writer factory for pool
-	var wFactory func() config_decoder.ItemWriter
-	switch writerKind {
-	case "null":
-		wFactory = config_decoder.NullWriterFactory()
-	case "file":
-		wFactory = config_decoder.FileWriterFactory(os.Stdout, []byte{'\n'})
-	default:
-		_, _ = fmt.Fprintf(os.Stderr, "unknown writer type %q specified\n", writerKind)
-		_, _ = fmt.Fprintf(os.Stderr, "for help, run %s -h \n", os.Args[0])
-		os.Exit(1)
+	logger.Infof("read %d config items (%s) in %s",
+		result.ItemCount, byteCountSI(result.ItemBytes), time.Since(start))
+	if dryRunMode {
+		// sizes by resource type, for sizing the sink
+		writeResourceTypes(os.Stderr, summary.resourceTypeReport())
+	}
+	if largestItems > 0 {
+		writeLargest(os.Stderr, summary.Largest)
 	}
 
-	_, _ = fmt.Fprintln(os.Stderr, "decoding json as stream ...")
-	chStatus, chErrors := config_decoder.DecodeAndSplitItems(ctx, r, wFactory, poolSize, spec)
+	if bench {
+		secs := time.Since(start).Seconds()
+		_, _ = fmt.Fprintf(os.Stderr, "bench: %.0f items/sec, %.2f MB/sec input, %.2f MB/sec items\n",
+			float64(result.ItemCount)/secs, float64(result.InputBytes)/1e6/secs, float64(result.ItemBytes)/1e6/secs)
+	}
+	return summary.err()
+}
 
-ForSelectLoop:
-	for {
-		select {
-		case err := <-chErrors:
-			if err != nil {
-				_, _ = fmt.Fprintf(os.Stderr, "error decoding web log object stream: %s\n", err)
-				logger.Errorw("error decoding web log object stream",
-					"message", "error decoding web log object stream",
-					"cause", err.Error())
-			}
-			break ForSelectLoop
-		case <-ctx.Done():
-			_, _ = fmt.Fprintf(os.Stderr, "\ndecoder cancelled: %s", ctx.Err())
-			break ForSelectLoop
-		case <-chSignalHandler:
-			_, _ = fmt.Fprintln(os.Stderr, "received shutdown signal")
-			break ForSelectLoop
-		}
-	}
-
-	itemCount, itemBytes := 0, 0
-	for i := 0; i < poolSize; i++ {
-		s := <-chStatus
-		itemCount += s.ItemCount
-		itemBytes += s.ByteCount
-		_, _ = fmt.Fprintf(os.Stderr, "worker status message: %+v\n", s)
-	}
-
-	_, _ = fmt.Fprintf(os.Stderr, "read %d config items (%s) in %s\n",
-		itemCount, byteCountSI(itemBytes), time.Since(start))
-	//logger.Infow("done",
-	//	"message", "application is done",
-	//	"timestamp", time.Now().UTC().Format(time.RFC3339Nano),
-	//	"itemCount", itemCount,
-	//	"duration", time.Since(start),
-	//	"tags", []string{"tag1", "tag2"})
+//newPoolSpec creates the writer pool spec from the command line
+func newPoolSpec() config_decoder.PoolSpec {
+	return config_decoder.PoolSpec{Size: poolSize, Breaker: breaker, ReuseItems: reuseItems, StopOnError: stopOnError,
+		Stats: liveStats, Largest: largestItems}
+}
+
+//decodeInput decodes -file, or the AWS Config query given by -resource-types, with writers from wFactory
+// The error is for bad settings; decoding errors are in the result.
+func decodeInput(wFactory config_decoder.WriterFactory) (runResult, error) {
+	spec, err := loadValidSpec(specFile)
+	if err != nil {
+		return runResult{}, err
+	}
+
+	// create context for downstream, which a signal cancels so the decoder and writers stop
+	ctx, cancelRun := context.WithCancelCause(context.Background())
+	defer cancelRun(nil)
+	defer signalHandler(cancelRun)()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ctx, cancelFile := fileContext(ctx)
+	defer cancelFile()
+
+	if resourceTypes != "" {
+		return decodeConfigAPI(ctx, spec, wFactory, newPoolSpec()), nil
+	}
+	return decodeFile(ctx, inputFile, spec, wFactory, newPoolSpec(), newProgress(progressEvery)), nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"testing"
+)
+
+func TestCheckFlags(t *testing.T) {
+	defer func(d int, m bool) { decoders, useMmap = d, m }(decoders, useMmap)
+
+	tests := []struct {
+		decoders int
+		mmap     bool
+		ok       bool
+	}{
+		{1, false, true},
+		{1, true, true},
+		{4, true, true},
+		// parallel decoding needs a memory-mapped file, so isn't silently ignored without one
+		{4, false, false},
+		{0, true, false},
+	}
+	for _, tt := range tests {
+		decoders, useMmap = tt.decoders, tt.mmap
+		if err := checkFlags(); (err == nil) != tt.ok {
+			t.Errorf("-decoders %d -mmap=%t: %v, want ok %t", tt.decoders, tt.mmap, err, tt.ok)
+		}
+	}
+}
+
+func TestSetSample(t *testing.T) {
+	defer func(s config_decoder.ItemSelection) { selection = s }(selection)
+
+	tests := []struct {
+		sample string
+		rate   float64
+		ok     bool
+	}{
+		{"1/100", 0.01, true},
+		{"1/1", 1, true},
+		{"1/2.5", 0.4, true},
+		{"5%", 0.05, true},
+		{"100%", 1, true},
+		{"0.5%", 0.005, true},
+		{"1/0", 0, false},
+		{"1/0.5", 0, false},
+		{"0%", 0, false},
+		{"101%", 0, false},
+		{"-5%", 0, false},
+		{"0.1", 0, false},
+		{"1/x", 0, false},
+	}
+	for _, tt := range tests {
+		selection.SampleRate = 0
+		err := setSample(tt.sample)
+		if (err == nil) != tt.ok {
+			t.Errorf("-sample %s: %v, want ok %t", tt.sample, err, tt.ok)
+			continue
+		}
+		if tt.ok && selection.SampleRate != tt.rate {
+			t.Errorf("-sample %s: rate %g, want %g", tt.sample, selection.SampleRate, tt.rate)
+		}
+	}
+}
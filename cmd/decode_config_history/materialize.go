@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/state"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//changeFilter passes on the items of resources that are new or changed since the state store last
+// saw them, recording them in the store
+// An item captured before the resource's latest known configuration is stale, e.g. from a file
+// given out of order, and is dropped too.
+type changeFilter struct {
+	store *state.Store
+	file  string
+	// mu makes looking up and putting a resource atomic, as it may appear more than once in a history file
+	mu sync.Mutex
+
+	created, updated, unchanged, stale atomic.Int64
+}
+
+//admit reports whether item is of a new or changed resource, putting it in the store if so
+func (cf *changeFilter) admit(item map[string]any) (bool, error) {
+	key := resourceKey(item)
+	sum, err := itemDigest(item)
+	if err != nil {
+		return false, err
+	}
+	digest := hex.EncodeToString(sum[:])
+	captured, _ := item["configurationItemCaptureTime"].(string)
+	captureTime, _ := time.Parse(time.RFC3339, captured)
+
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	e, ok, err := cf.store.Get(key)
+	switch {
+	case err != nil:
+		return false, err
+	case ok && captureTime.Before(e.CaptureTime):
+		cf.stale.Add(1)
+		return false, nil
+	case ok && e.Digest == digest:
+		cf.unchanged.Add(1)
+		return false, nil
+	case ok:
+		cf.updated.Add(1)
+	default:
+		cf.created.Add(1)
+	}
+	return true, cf.store.Put(key, state.Entry{Digest: digest, CaptureTime: captureTime, SourceFile: cf.file,
+		UpdatedAt: time.Now().UTC()})
+}
+
+//wrap filters the items written by writers from f
+func (cf *changeFilter) wrap(f config_decoder.WriterFactory) config_decoder.WriterFactory {
+	return func(ctx context.Context, worker int) (config_decoder.ItemWriter, error) {
+		w, err := f(ctx, worker)
+		if err != nil {
+			return nil, err
+		}
+		return changeWriter{w: w, cf: cf}, nil
+	}
+}
+
+//changeWriter is an ItemWriter writing only the items its changeFilter admits
+// It passes Flush, Close and Healthy through to writers implementing them.
+type changeWriter struct {
+	w  config_decoder.ItemWriter
+	cf *changeFilter
+}
+
+// Write implements ItemWriter for changeWriter
+func (cw changeWriter) Write(item map[string]interface{}) error {
+	ok, err := cw.cf.admit(item)
+	if err != nil || !ok {
+		return err
+	}
+	return cw.w.Write(item)
+}
+
+// Flush implements Flusher for changeWriter
+func (cw changeWriter) Flush() error {
+	if f, ok := cw.w.(config_decoder.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close implements io.Closer for changeWriter
+func (cw changeWriter) Close() error {
+	if c, ok := cw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Healthy implements HealthChecker for changeWriter
+func (cw changeWriter) Healthy() error {
+	if hc, ok := cw.w.(config_decoder.HealthChecker); ok {
+		return hc.Healthy()
+	}
+	return nil
+}
+
+//runMaterialize implements the materialize subcommand, writing the items of each file, in order, whose
+// resources are new or changed since the -state store last saw them
+// The store is advanced a file at a time, once its changes have all been written, so a failed file
+// is materialized again when the command is rerun.
+func runMaterialize(args []string) error {
+	start := time.Now()
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if flag.NArg() == 0 || stateFile == "" {
+		return fmt.Errorf("usage: %s materialize -state state.db [flags] file...", os.Args[0])
+	}
+	if dryRunMode {
+		return fmt.Errorf("materialize doesn't support -dry-run")
+	}
+
+	store, err := state.Open(stateFile)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	wFactory, err := newWriterFactory()
+	if err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("%w\nfor help, run %s -h", err, os.Args[0])}
+	}
+
+	var changed int64
+	for _, name := range flag.Args() {
+		inputFile = name
+		cf := &changeFilter{store: store, file: name}
+		wf, out, err := outputFactory(name, wFactory)
+		if err != nil {
+			return decodeFailed(err)
+		}
+		result, err := decodeInput(cf.wrap(wf))
+		if err != nil {
+			store.Rollback()
+			if out != nil {
+				out.abort()
+			}
+			return err
+		}
+		finishOutput(out, &result)
+
+		writeErrors := 0
+		for _, s := range result.Workers {
+			writeErrors += s.ErrorCount
+		}
+		if result.Err == nil && writeErrors > 0 {
+			result.Err = fmt.Errorf("%w: %d items not written", errOutputFailed, writeErrors)
+		}
+		if result.Err == nil {
+			result.Err = store.Commit()
+		}
+		if result.Err != nil {
+			store.Rollback()
+			return decodeFailed(fmt.Errorf("materialize: %s: %w", name, result.Err))
+		}
+
+		logger.Infof("materialized %s: %d new, %d changed, %d unchanged, %d stale resources",
+			name, cf.created.Load(), cf.updated.Load(), cf.unchanged.Load(), cf.stale.Load())
+		changed += cf.created.Load() + cf.updated.Load()
+	}
+
+	n, err := store.Len()
+	if err != nil {
+		return err
+	}
+	logger.Infof("wrote %d new or changed items of %d files in %s; %d resources in %s",
+		changed, flag.NArg(), time.Since(start), n, stateFile)
+	return nil
+}
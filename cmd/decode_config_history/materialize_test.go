@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/state"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//volume is a configuration item of the volume id, of size, captured at day of January 2024
+func volume(id string, size, day int) map[string]any {
+	return map[string]any{"resourceType": "AWS::EC2::Volume", "resourceId": id, "awsRegion": "us-east-1",
+		"configurationItemCaptureTime": fmt.Sprintf("2024-01-%02dT00:00:00.000Z", day), "configuration": map[string]any{"size": size}}
+}
+
+//testSnapshot is a snapshot document, whose fields marshal before its items as AWS Config writes them
+type testSnapshot struct {
+	FileVersion      string           `json:"fileVersion"`
+	ConfigSnapshotID string           `json:"configSnapshotId,omitempty"`
+	Items            []map[string]any `json:"configurationItems"`
+}
+
+//materialize decodes a snapshot of items through a changeFilter on store, committing the store once
+// they're written, and returns the ids of the resources emitted, sorted, and the filter
+func materialize(t *testing.T, store *state.Store, file string, items ...map[string]any) ([]string, *changeFilter) {
+	t.Helper()
+	doc, err := json.Marshal(testSnapshot{FileVersion: "1.0", ConfigSnapshotID: file, Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cf := &changeFilter{store: store, file: file}
+	var cw config_decoder.CollectorWriter
+	spec := defaultSpec()
+	spec.Source = file
+	chStatus, chErrors := config_decoder.DecodeAndSplitItems(context.Background(), strings.NewReader(string(doc)),
+		cf.wrap(config_decoder.CollectorWriterFactory(&cw)), config_decoder.PoolSpec{Size: 2}, spec)
+	var result runResult
+	awaitResult(context.Background(), chStatus, chErrors, 2, &result)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, item := range cw.Items() {
+		ids = append(ids, item["resourceId"].(string))
+	}
+	sort.Strings(ids)
+	return ids, cf
+}
+
+func TestMaterialize(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ids, _ := materialize(t, store, "day2.json",
+		volume("vol-a", 8, 2), volume("vol-b", 8, 2), volume("vol-c", 8, 2), volume("vol-d", 8, 2))
+	if strings.Join(ids, ",") != "vol-a,vol-b,vol-c,vol-d" {
+		t.Fatalf("first snapshot emitted %v, want every resource", ids)
+	}
+
+	ids, cf := materialize(t, store, "day3.json",
+		// recaptured as it was
+		volume("vol-a", 8, 3),
+		// resized
+		volume("vol-b", 16, 3),
+		// captured before the state's, as in a file given out of order
+		volume("vol-c", 32, 1),
+		// new
+		volume("vol-e", 8, 3))
+	if strings.Join(ids, ",") != "vol-b,vol-e" {
+		t.Errorf("second snapshot emitted %v, want only the changed and new resources", ids)
+	}
+	if cf.created.Load() != 1 || cf.updated.Load() != 1 || cf.unchanged.Load() != 1 || cf.stale.Load() != 1 {
+		t.Errorf("%d new, %d changed, %d unchanged, %d stale", cf.created.Load(), cf.updated.Load(), cf.unchanged.Load(), cf.stale.Load())
+	}
+
+	// the same snapshot again changes nothing
+	if ids, _ := materialize(t, store, "day3.json", volume("vol-b", 16, 3), volume("vol-e", 8, 3)); len(ids) != 0 {
+		t.Errorf("repeated snapshot emitted %v", ids)
+	}
+	if n, err := store.Len(); err != nil || n != 5 {
+		t.Errorf("%d resources in the state, %v", n, err)
+	}
+}
+
+func TestMaterializeRollback(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// a file whose changes weren't all written leaves the state as it was, so it's emitted again
+	cf := &changeFilter{store: store, file: "day2.json"}
+	if ok, err := cf.admit(volume("vol-a", 8, 2)); !ok || err != nil {
+		t.Fatalf("admit: %t, %v", ok, err)
+	}
+	store.Rollback()
+	if ids, _ := materialize(t, store, "day2.json", volume("vol-a", 8, 2)); strings.Join(ids, ",") != "vol-a" {
+		t.Errorf("after a rollback, emitted %v", ids)
+	}
+}
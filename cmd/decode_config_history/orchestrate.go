@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//defaultSnapshotPrefix is where AWS Config delivers the snapshots of an account and region each day
+// AWS Config doesn't zero-pad the month and day.
+const defaultSnapshotPrefix = "AWSLogs/{account}/Config/{region}/{year}/{month}/{day}/ConfigSnapshot/"
+
+//manifest describes an organization's accounts and regions, and the naming convention of the buckets
+// and keys their snapshots are delivered to. Bucket, Prefix and BucketRegion are templates in which
+// {account}, {region}, {year}, {month} and {day} are replaced.
+type manifest struct {
+	Accounts []string
+	Regions  []string
+	Bucket   string
+	Prefix   string
+	// BucketRegion is the region of the buckets, by default -region or $AWS_REGION; only {account}
+	// and {region} are replaced in it
+	BucketRegion string
+}
+
+//readManifest reads the yaml, toml or json -manifest file
+// Its settings are accounts and regions, lists or comma-separated, bucket, prefix and bucket_region.
+func readManifest(name string) (manifest, error) {
+	m := manifest{Prefix: defaultSnapshotPrefix, BucketRegion: awsRegion}
+	settings, err := readConfigFile(name)
+	if err != nil {
+		return m, fmt.Errorf("readManifest: %w", err)
+	}
+
+	for k, v := range settings {
+		switch k {
+		case "accounts":
+			m.Accounts = splitList(settingString(v))
+		case "regions":
+			m.Regions = splitList(settingString(v))
+		case "bucket":
+			m.Bucket = settingString(v)
+		case "prefix":
+			m.Prefix = settingString(v)
+		case "bucket_region":
+			m.BucketRegion = settingString(v)
+		default:
+			return m, fmt.Errorf("readManifest: %s: unknown setting %q", name, k)
+		}
+	}
+	if len(m.Accounts) == 0 || len(m.Regions) == 0 || m.Bucket == "" {
+		return m, fmt.Errorf("readManifest: %s: accounts, regions and bucket are required", name)
+	}
+	return m, nil
+}
+
+//splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+//expand replaces the placeholders of a manifest template
+func expand(template, account, region string, day time.Time) string {
+	return strings.NewReplacer(
+		"{account}", account,
+		"{region}", region,
+		"{year}", strconv.Itoa(day.Year()),
+		"{month}", strconv.Itoa(int(day.Month())),
+		"{day}", strconv.Itoa(day.Day()),
+	).Replace(template)
+}
+
+//dateRange returns the days from -from to -to inclusive
+func dateRange(from, to string) ([]time.Time, error) {
+	last := time.Now().UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		var err error
+		if last, err = time.Parse(time.DateOnly, to); err != nil {
+			return nil, fmt.Errorf("dateRange: -to: %w", err)
+		}
+	}
+	first := last
+	if from != "" {
+		var err error
+		if first, err = time.Parse(time.DateOnly, from); err != nil {
+			return nil, fmt.Errorf("dateRange: -from: %w", err)
+		}
+	}
+	if first.After(last) {
+		return nil, fmt.Errorf("dateRange: -from %s is after -to %s", first.Format(time.DateOnly), last.Format(time.DateOnly))
+	}
+
+	var days []time.Time
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+//snapshotObject is a snapshot found in S3, and the account and region it was listed for
+// clientRegion is the region client was created for, "" being the session's, and etag the object's
+// ETag when it was listed.
+type snapshotObject struct {
+	account      string
+	region       string
+	client       *awsconfig.S3Client
+	clientRegion string
+	bucket       string
+	key          string
+	etag         string
+}
+
+func (so snapshotObject) name() string {
+	return "s3://" + so.bucket + "/" + so.key
+}
+
+//targetSummary totals the snapshots of one account and region, in the orchestrate report
+type targetSummary struct {
+	Account    string `json:"account"`
+	Region     string `json:"region"`
+	Snapshots  int    `json:"snapshots"`
+	Failed     int    `json:"failed"`
+	ListErrors int    `json:"listErrors"`
+	Items      int    `json:"items"`
+	ItemBytes  int64  `json:"itemBytes"`
+	InputBytes int64  `json:"inputBytes"`
+}
+
+//orchestration decodes the snapshots of a manifest's accounts and regions, at most -concurrency at once
+type orchestration struct {
+	spec     config_decoder.ItemTransformSpec
+	wFactory config_decoder.WriterFactory
+	poolSpec config_decoder.PoolSpec
+	summary  *runSummary
+	// decoded, if set, is called with the result of each snapshot decoded
+	decoded func(so snapshotObject, result runResult)
+
+	mu      sync.Mutex
+	targets map[[2]string]*targetSummary
+}
+
+//target returns the summary of account and region
+func (o *orchestration) target(account, region string) *targetSummary {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k := [2]string{account, region}
+	t, ok := o.targets[k]
+	if !ok {
+		t = &targetSummary{Account: account, Region: region}
+		o.targets[k] = t
+	}
+	return t
+}
+
+//add totals result, of a snapshot of account and region, in the report
+// A listing that failed is counted as a list error, rather than a snapshot.
+func (o *orchestration) add(account, region string, result runResult, listing bool) {
+	o.summary.add(result)
+
+	t := o.target(account, region)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if listing {
+		t.ListErrors++
+		return
+	}
+	t.Snapshots++
+	t.Items += result.ItemCount
+	t.ItemBytes += int64(result.ItemBytes)
+	t.InputBytes += result.InputBytes
+	if result.Err != nil {
+		t.Failed++
+	}
+}
+
+//report returns the summaries of each account and region, in order
+func (o *orchestration) report() []targetSummary {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	report := make([]targetSummary, 0, len(o.targets))
+	for _, t := range o.targets {
+		report = append(report, *t)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Account != report[j].Account {
+			return report[i].Account < report[j].Account
+		}
+		return report[i].Region < report[j].Region
+	})
+	return report
+}
+
+//parallel calls f with each of n indexes, at most -concurrency at once
+func parallel(n int, f func(i int)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+//list lists the snapshots of every account and region of m delivered on days
+// A listing that fails is reported as a list error of its account and region, and as a failed file
+// in the run summary.
+func (o *orchestration) list(ctx context.Context, m manifest, days []time.Time, clients map[string]*awsconfig.S3Client) []snapshotObject {
+	type listing struct {
+		account, region string
+		day             time.Time
+	}
+	var listings []listing
+	for _, account := range m.Accounts {
+		for _, region := range m.Regions {
+			o.target(account, region)
+			for _, day := range days {
+				listings = append(listings, listing{account, region, day})
+			}
+		}
+	}
+
+	found := make([][]snapshotObject, len(listings))
+	parallel(len(listings), func(i int) {
+		l := listings[i]
+		clientRegion := expand(m.BucketRegion, l.account, l.region, time.Time{})
+		client := clients[clientRegion]
+		bucket, prefix := expand(m.Bucket, l.account, l.region, l.day), expand(m.Prefix, l.account, l.region, l.day)
+
+		objects, err := client.ListObjects(ctx, bucket, prefix)
+		if err != nil {
+			logger.Errorf("error listing snapshots: %s", err)
+			o.add(l.account, l.region, runResult{File: "s3://" + bucket + "/" + prefix, Err: fmt.Errorf("%w: %w", errInputFailed, err)}, true)
+			return
+		}
+		for _, obj := range objects {
+			// skip anything else in the prefix, such as AWS Config's ConfigWritabilityCheckFile
+			if strings.HasSuffix(obj.Key, ".json") || strings.HasSuffix(obj.Key, ".json.gz") {
+				found[i] = append(found[i], snapshotObject{l.account, l.region, client, clientRegion, bucket, obj.Key, obj.ETag})
+			}
+		}
+		logger.Debugf("found %d snapshots in s3://%s/%s", len(found[i]), bucket, prefix)
+	})
+
+	var snapshots []snapshotObject
+	for _, f := range found {
+		snapshots = append(snapshots, f...)
+	}
+	return snapshots
+}
+
+//decode decodes each snapshot, writing its items with the configured writer
+// Decoding stops early if ctx is done, and with -stop-on-error cancel is called at the first failure.
+func (o *orchestration) decode(ctx context.Context, cancel context.CancelFunc, snapshots []snapshotObject) {
+	parallel(len(snapshots), func(i int) {
+		so := snapshots[i]
+		mark, err := checkRun(o.spec, so.name(), so.etag)
+		if errors.Is(err, errDuplicateRun) {
+			logger.Warnf("%s; skipping it", err)
+			return
+		}
+		var result runResult
+		// outputs are named after the key, so {dir} is the key's directory
+		var wFactory config_decoder.WriterFactory
+		var out *output
+		if err == nil {
+			wFactory, out, err = outputFactory(so.key, o.wFactory)
+		}
+		if err != nil {
+			result = runResult{File: so.name(), Err: err}
+		} else {
+			fileCtx, cancelFile := fileContext(ctx)
+			result = decodeSnapshot(fileCtx, so, o.spec, wFactory, o.poolSpec)
+			cancelFile()
+			finishOutput(out, &result)
+			recordRun(mark, result)
+		}
+
+		o.add(so.account, so.region, result, false)
+		if o.decoded != nil {
+			o.decoded(so, result)
+		}
+		if result.Err != nil {
+			logResultError(result)
+			if stopOnError {
+				logger.Warn("stopping at the first failed snapshot (-stop-on-error)")
+				cancel()
+			}
+		}
+	})
+}
+
+//decodeSnapshot reads snapshot so from S3, writing its items with writers from wFactory
+func decodeSnapshot(ctx context.Context, so snapshotObject, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory, poolSpec config_decoder.PoolSpec) runResult {
+	start := time.Now()
+	result := runResult{File: so.name()}
+	spec.Source = result.File
+	defer activeInputs.decoding(result.File)()
+	if err := stampDelivery(&spec, so.key); err != nil {
+		result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+		return result
+	}
+	if err := ctx.Err(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	body, err := so.client.OpenObject(ctx, so.bucket, so.key)
+	if err != nil {
+		result.Err = fmt.Errorf("%w: %w", errInputFailed, err)
+		return result
+	}
+	defer body.Close()
+	defer func() {
+		if n := body.Resumes(); n > 0 {
+			logger.Warnf("%s: resumed reading %d times after it failed", result.File, n)
+		}
+	}()
+
+	inCounter := &countingReader{r: body}
+	docCounter := inCounter
+	var r io.Reader = bufio.NewReaderSize(inCounter, readBuffer)
+	if strings.HasSuffix(so.key, ".gz") {
+		gz, err := config_decoder.NewGzipReader(r)
+		if err != nil {
+			result.Err = fmt.Errorf("%w: gzip error reading input file: %w", errInputFailed, err)
+			return result
+		}
+		docCounter = &countingReader{r: gz}
+		r = docCounter
+	}
+	if r, docCounter, err = utf8Input(r, docCounter); err != nil {
+		result.Err = err
+		return result
+	}
+
+	logger.Infof("decoding %s", result.File)
+	spec, r = sniffSpec(spec, so.key, r)
+	chStatus, chErrors := config_decoder.DecodeAndSplitItems(ctx, r, wFactory, poolSpec, spec)
+	awaitResult(ctx, chStatus, chErrors, poolSpec.Size, &result)
+	result.InputBytes = inCounter.n.Load()
+	result.DocumentBytes = docCounter.n.Load()
+	result.Duration = time.Since(start)
+	return result
+}
+
+//writeTargets prints the report of each account and region as an aligned text table
+func writeTargets(w io.Writer, targets []targetSummary) {
+	_, _ = fmt.Fprintf(w, "%-14s  %-14s  %9s  %6s  %11s  %10s  %10s  %10s\n",
+		"account", "region", "snapshots", "failed", "list errors", "items", "bytes", "input")
+	for _, t := range targets {
+		account, region := t.Account, t.Region
+		if account == "" {
+			account = "(none)"
+		}
+		if region == "" {
+			region = "(none)"
+		}
+		_, _ = fmt.Fprintf(w, "%-14s  %-14s  %9d  %6d  %11d  %10d  %10s  %10s\n", account, region, t.Snapshots, t.Failed,
+			t.ListErrors, t.Items, byteCountSI(int(t.ItemBytes)), byteCountSI(int(t.InputBytes)))
+	}
+}
+
+//runOrchestrate implements the orchestrate subcommand, decoding the snapshots AWS Config delivered to S3
+// from -from to -to for every account and region of the -manifest, at most -concurrency at once, then
+// reporting on each account and region, and the run as a whole with -summary-format json.
+func runOrchestrate(args []string) error {
+	start := time.Now()
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	switch {
+	case manifestFile == "":
+		return fmt.Errorf("orchestrate: -manifest is required")
+	case summaryFormat != "text" && summaryFormat != "json":
+		return fmt.Errorf("unknown summary format %q", summaryFormat)
+	}
+
+	find, err := manifestSnapshots()
+	if err != nil {
+		return err
+	}
+	return orchestrate(start, find, nil)
+}
+
+//manifestSnapshots returns the function listing the snapshots AWS Config delivered to S3 from -from to
+// -to for every account and region of the -manifest, for orchestrate
+func manifestSnapshots() (func(ctx context.Context, o *orchestration) []snapshotObject, error) {
+	m, err := readManifest(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	days, err := dateRange(fromDate, toDate)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := newAWSSession(context.Background(), awsInput)
+	if err != nil {
+		return nil, &exitError{code: exitInput, err: fmt.Errorf("orchestrate: %w", err)}
+	}
+	// one client for each region the buckets are in
+	clients := make(map[string]*awsconfig.S3Client)
+	for _, account := range m.Accounts {
+		for _, region := range m.Regions {
+			r := expand(m.BucketRegion, account, region, time.Time{})
+			if _, ok := clients[r]; ok {
+				continue
+			}
+			if clients[r], err = session.S3Client(r); err != nil {
+				return nil, &exitError{code: exitInput, err: fmt.Errorf("orchestrate: %w", err)}
+			}
+		}
+	}
+
+	return func(ctx context.Context, o *orchestration) []snapshotObject {
+		snapshots := o.list(ctx, m, days, clients)
+		logger.Infof("found %d snapshots of %d accounts in %d regions from %s to %s", len(snapshots),
+			len(m.Accounts), len(m.Regions), days[0].Format(time.DateOnly), days[len(days)-1].Format(time.DateOnly))
+		return snapshots
+	}, nil
+}
+
+//orchestrate decodes the snapshots find lists that -since, -until and -latest-per-region select,
+// at most -concurrency at once, then reports on each account and region, and the run as a whole
+// with -summary-format json
+// A backfill, if given, checkpoints the snapshots decoded; those of a resumed backfill aren't selected
+// again, and those it's already done are skipped.
+func orchestrate(start time.Time, find func(ctx context.Context, o *orchestration) []snapshotObject, b *backfill) error {
+	switch {
+	case concurrency < 1:
+		return fmt.Errorf("orchestrate: -concurrency %d is not positive", concurrency)
+	case writerKind == "file" && !dryRunMode && concurrency > 1 && !strings.Contains(outputTemplate, "{basename}"):
+		// snapshots decoded at once would otherwise be interleaved in one output
+		return fmt.Errorf("orchestrate: -writer file needs an -output template with {basename}, or -concurrency 1")
+	}
+	sel, err := newSnapshotSelection()
+	if err != nil {
+		return err
+	}
+
+	spec, err := loadSpec(specFile)
+	if err != nil {
+		return err
+	}
+	if err := spec.Limits.Validate(); err != nil {
+		return err
+	}
+	if err := spec.Selection.Validate(); err != nil {
+		return err
+	}
+
+	var wFactory config_decoder.WriterFactory
+	if dryRunMode {
+		if err := validateSettings(); err != nil {
+			return err
+		}
+		dry = &dryRun{}
+		defer dry.report(os.Stderr)
+	} else if wFactory, err = newWriterFactory(); err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("%w\nfor help, run %s -h", err, os.Args[0])}
+	}
+
+	ctx, cancelRun := context.WithCancelCause(context.Background())
+	defer cancelRun(nil)
+	defer signalHandler(cancelRun)()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	summary := newRunSummary(start)
+	o := &orchestration{spec: spec, wFactory: wFactory, poolSpec: newPoolSpec(), summary: summary,
+		targets: make(map[[2]string]*targetSummary)}
+
+	snapshots := find(ctx, o)
+	if b == nil || !b.resumed {
+		snapshots = sel.apply(snapshots)
+	}
+	if b != nil {
+		if snapshots, err = b.plan(ctx, snapshots); err != nil {
+			return err
+		}
+		o.decoded = b.decoded
+	}
+	o.decode(ctx, cancel, snapshots)
+	var checkpointErr error
+	if b != nil {
+		checkpointErr = b.finish()
+	}
+
+	summary.Targets = o.report()
+	logMetadataCollisions()
+	if err := emitRunManifest(summary); err != nil {
+		return err
+	}
+	if summaryFormat == "json" {
+		if err := emitSummary(summary); err != nil {
+			return err
+		}
+	} else {
+		writeTargets(os.Stderr, summary.Targets)
+		if dryRunMode {
+			writeResourceTypes(os.Stderr, summary.resourceTypeReport())
+		}
+		if largestItems > 0 {
+			writeLargest(os.Stderr, summary.Largest)
+		}
+		logger.Infof("read %d config items (%s) from %d snapshots in %s",
+			summary.Items, byteCountSI(int(summary.ItemBytes)), summary.Files, time.Since(start))
+	}
+	if checkpointErr != nil {
+		return checkpointErr
+	}
+	return summary.err()
+}
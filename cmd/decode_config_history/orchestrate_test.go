@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+//TestOrchestrationList checks the snapshots of each account and region of a manifest are listed from the
+// prefixes it names for each day, over pages and retries, and a listing that fails is a list error
+func TestOrchestrationList(t *testing.T) {
+	defer func(c int) { concurrency = c }(concurrency)
+	concurrency = 3
+
+	fs, srv := newFakeS3(t, 1)
+	day := func(account, region string, d int) string {
+		return expand(defaultSnapshotPrefix, account, region, time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC))
+	}
+	snapshots := map[string][]string{
+		"config-111111111111": {
+			day("111111111111", "us-east-1", 1) + snapshotName("111111111111", "us-east-1", "20240101T010000Z"),
+			day("111111111111", "us-east-1", 1) + snapshotName("111111111111", "us-east-1", "20240101T130000Z"),
+			day("111111111111", "us-east-1", 2) + snapshotName("111111111111", "us-east-1", "20240102T010000Z"),
+			day("111111111111", "eu-west-1", 2) + snapshotName("111111111111", "eu-west-1", "20240102T010000Z"),
+		},
+		"config-222222222222": {
+			day("222222222222", "us-east-1", 1) + snapshotName("222222222222", "us-east-1", "20240101T010000Z"),
+		},
+	}
+	for bucket, keys := range snapshots {
+		for _, key := range keys {
+			fs.put(bucket, key, snapshotDoc(t, 1))
+		}
+	}
+	// outside the days listed, or the regions of the manifest
+	fs.put("config-111111111111", day("111111111111", "us-east-1", 3)+snapshotName("111111111111", "us-east-1", "20240103T010000Z"),
+		snapshotDoc(t, 1))
+	fs.put("config-111111111111", day("111111111111", "ap-south-1", 1)+snapshotName("111111111111", "ap-south-1", "20240101T010000Z"),
+		snapshotDoc(t, 1))
+	// not a snapshot
+	fs.put("config-111111111111", day("111111111111", "eu-west-1", 2)+"ConfigWritabilityCheckFile", []byte("x"))
+	fs.throttle["config-111111111111/"+day("111111111111", "us-east-1", 1)+"@1"] = 1
+	fs.denied["config-333333333333"] = true
+
+	m := manifest{Accounts: []string{"111111111111", "222222222222", "333333333333"}, Regions: []string{"us-east-1", "eu-west-1"},
+		Bucket: "config-{account}", Prefix: defaultSnapshotPrefix, BucketRegion: "us-east-1"}
+	days := []time.Time{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	client := &awsconfig.S3Client{Client: s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("x", "y", ""),
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		HTTPClient:   srv.Client(),
+		Retryer:      retry.AddWithMaxAttempts(retry.NewStandard(), 3),
+	}), MaxRetries: 2}
+	o := &orchestration{summary: newRunSummary(time.Now()), targets: make(map[[2]string]*targetSummary)}
+
+	found := make(map[string][]string)
+	for _, so := range o.list(context.Background(), m, days, map[string]*awsconfig.S3Client{"us-east-1": client}) {
+		if so.client != client || so.clientRegion != "us-east-1" || so.etag == "" {
+			t.Errorf("snapshot %+v, want it read with the bucket region's client", so)
+		}
+		found[so.bucket] = append(found[so.bucket], so.key)
+		if key, _ := parseSnapshotKey(so.key); key.account != so.account || key.region != so.region {
+			t.Errorf("%s listed for %s %s", so.key, so.account, so.region)
+		}
+	}
+	for _, keys := range found {
+		sort.Strings(keys)
+	}
+	for _, keys := range snapshots {
+		sort.Strings(keys)
+	}
+	if !reflect.DeepEqual(found, snapshots) {
+		t.Errorf("found %q, want %q", found, snapshots)
+	}
+
+	// every account and region is reported, the denied account's four listings as list errors
+	var listErrors []int
+	for _, target := range o.report() {
+		listErrors = append(listErrors, target.ListErrors)
+	}
+	if want := []int{0, 0, 0, 0, 2, 2}; !reflect.DeepEqual(listErrors, want) {
+		t.Errorf("list errors %v, want %v", listErrors, want)
+	}
+	if o.summary.Files != 4 || o.summary.FilesFailed != 4 {
+		t.Errorf("%d files, %d failed in the summary, want the 4 failed listings", o.summary.Files, o.summary.FilesFailed)
+	}
+}
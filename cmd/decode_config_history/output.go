@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/klauspost/pgzip"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//output is the file writer's destination: stdout, a file appended to, or by default a file written
+// under a temporary name and renamed into place once complete, so a partially decoded output never
+// masquerades as a complete one. Writes are serialized, so the workers of a pool may share it.
+// staged, if set, is called once a temporary file is complete, before it's renamed; an error keeps
+// it from being renamed.
+// An output whose path is a template routing items to files by their fields has no file of its own,
+// but one output part for each file named, committed or aborted together. opts are the options it
+// was created with, or its parts are.
+type output struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	tmp    bool
+	buf    *bufio.Writer
+	gz     *pgzip.Writer
+	w      io.Writer
+	staged func() error
+	route  *config_decoder.NameTemplate
+	opts   fileOptions
+	parts  map[string]*output
+}
+
+//expandOutput expands the placeholders in an -output template for input file name
+// {basename} is the input's name without directory or .json/.json.gz extension, {dir} its directory.
+func expandOutput(template, input string) string {
+	base := filepath.Base(input)
+	base = strings.TrimSuffix(base, ".gz")
+	base = strings.TrimSuffix(base, ".json")
+	return strings.NewReplacer("{basename}", base, "{dir}", filepath.Dir(input)).Replace(template)
+}
+
+//createOutput creates the output for path with opts; "" is stdout
+// Unless opts.Append is set, a file is written to a temporary file next to path, renamed to path by commit.
+// With opts.Exclusive, a path that already exists fails here, before anything is written to it.
+func createOutput(path string, opts fileOptions) (*output, error) {
+	o := &output{path: path, file: os.Stdout, opts: opts}
+	if path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("createOutput: %w", err)
+		}
+
+		var err error
+		if opts.Append {
+			o.file, err = openAppend(path, opts)
+		} else if _, statErr := os.Lstat(path); opts.Exclusive && statErr == nil {
+			err = &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+		} else {
+			o.file, err = os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+			o.tmp = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("createOutput: %w", err)
+		}
+	}
+
+	o.buf = bufio.NewWriterSize(o.file, 1<<20)
+	o.w = o.buf
+	if opts.gzipped(path) {
+		o.gz = pgzip.NewWriter(o.buf)
+		o.w = o.gz
+	}
+	return o, nil
+}
+
+//openAppend opens path to append to, creating it with opts' permissions if it doesn't exist
+// The permissions of a file that exists are left alone.
+func openAppend(path string, opts fileOptions) (*os.File, error) {
+	const flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	f, err := os.OpenFile(path, flags|os.O_EXCL, opts.perm())
+	if errors.Is(err, os.ErrExist) && !opts.Exclusive {
+		return os.OpenFile(path, flags, opts.perm())
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the file was created subject to the umask
+	if err := f.Chmod(opts.perm()); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+//createRoutedOutput creates the output for the path template route, whose parts are created with opts
+// as items are routed to them
+func createRoutedOutput(route *config_decoder.NameTemplate, opts fileOptions) *output {
+	return &output{path: route.String(), route: route, opts: opts, parts: make(map[string]*output)}
+}
+
+//part returns the output part for path, creating it the first time
+func (o *output) part(path string) (io.Writer, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if p, ok := o.parts[path]; ok {
+		return p, nil
+	}
+	p, err := createOutput(path, o.opts)
+	if err != nil {
+		return nil, err
+	}
+	o.parts[path] = p
+	return p, nil
+}
+
+//eachPart calls f with each part of a routed output, in path order, joining the errors
+func (o *output) eachPart(f func(p *output) error) error {
+	o.mu.Lock()
+	paths := make([]string, 0, len(o.parts))
+	parts := make(map[string]*output, len(o.parts))
+	for path, p := range o.parts {
+		paths = append(paths, path)
+		parts[path] = p
+	}
+	o.mu.Unlock()
+	sort.Strings(paths)
+	var errs []error
+	for _, path := range paths {
+		if err := f(parts[path]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+//WriteStream implements config_decoder.StreamWriter, holding the output for the several writes of an item
+func (o *output) WriteStream(write func(w io.Writer) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return write(o.w)
+}
+
+//Flush writes out what's been written so far, through any gzip stream, without completing the output
+// With -file-sync flush, a file is fsynced too.
+func (o *output) Flush() error {
+	if o.route != nil {
+		return o.eachPart((*output).Flush)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.gz != nil {
+		if err := o.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := o.buf.Flush(); err != nil {
+		return err
+	}
+	if onFlush, _ := o.opts.syncs(); onFlush && o.path != "" {
+		return o.file.Sync()
+	}
+	return nil
+}
+
+//commit completes the output, renaming a temporary file into place
+// Each part of a routed output is committed, even if another can't be.
+func (o *output) commit() error {
+	if o.route != nil {
+		return o.eachPart((*output).commit)
+	}
+	err := o.finish()
+	if err == nil && o.tmp && o.staged != nil {
+		err = o.staged()
+	}
+	if err == nil && o.tmp {
+		err = publish(o.file.Name(), o.path, o.opts)
+	}
+	if err != nil {
+		if o.tmp {
+			_ = os.Remove(o.file.Name())
+		}
+		return fmt.Errorf("%w: %s: %w", errOutputFailed, o.name(), err)
+	}
+	return nil
+}
+
+//publish renames the complete temporary file staged into place at path
+// With opts.Exclusive, it's linked into place instead, which fails if path exists, unless path is
+// already staged's link, as when a crash interrupted publishing it. Unless opts.Sync is none, the
+// directory is synced, so the rename survives a crash.
+func publish(staged, path string, opts fileOptions) error {
+	if !opts.Exclusive {
+		if err := os.Rename(staged, path); err != nil {
+			return err
+		}
+	} else if err := os.Link(staged, path); err != nil && !sameFile(staged, path) {
+		return err
+	} else if err := os.Remove(staged); err != nil {
+		return err
+	}
+	if _, onCommit := opts.syncs(); !onCommit {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+//sameFile reports whether paths a and b are links to the same file
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+//finish flushes the output and closes its file, fsyncing it unless -file-sync is none
+func (o *output) finish() error {
+	var err error
+	if o.gz != nil {
+		err = o.gz.Close()
+	}
+	if err == nil {
+		err = o.buf.Flush()
+	}
+	if o.path == "" {
+		return err
+	}
+
+	if err == nil && o.tmp {
+		// CreateTemp files are private; give the output its permissions
+		err = o.file.Chmod(o.opts.perm())
+	}
+	if _, onCommit := o.opts.syncs(); err == nil && onCommit {
+		err = o.file.Sync()
+	}
+	if cErr := o.file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+//abort discards a temporary file; what was written to stdout or appended to a file is kept
+func (o *output) abort() {
+	if o.route != nil {
+		_ = o.eachPart(func(p *output) error {
+			p.abort()
+			return nil
+		})
+		return
+	}
+	if !o.tmp {
+		_ = o.finish()
+		return
+	}
+	_ = o.file.Close()
+	_ = os.Remove(o.file.Name())
+}
+
+func (o *output) name() string {
+	if o.path == "" {
+		return "stdout"
+	}
+	return o.path
+}
+
+//itemLimiter limits the items written a second to -rate-limit, over every file the run decodes
+var itemLimiter = sync.OnceValue(func() *config_decoder.RateLimiter {
+	return config_decoder.NewRateLimiter(rateLimit)
+})
+
+//outputFactory returns the writer factory for decoding input, and the output it writes to
+// The output is nil unless the writer is the file writer. In a -dry-run, the factory's
+// writers only count the items that would have been written. With -record-envelope, the
+// writers wrap items in event records, and with -rate-limit they share its limit.
+func outputFactory(input string, wFactory config_decoder.WriterFactory) (config_decoder.WriterFactory, *output, error) {
+	f, out, err := destinationFactory(input, wFactory)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f, err = routeBySize(f); err != nil {
+		if out != nil {
+			out.abort()
+		}
+		return nil, nil, fmt.Errorf("%w: %w", errOutputFailed, err)
+	}
+	if dry == nil {
+		f = config_decoder.RateLimitedWriterFactory(f, itemLimiter())
+	}
+	if f, err = recordEnvelope.Wrap(f); err != nil {
+		if out != nil {
+			out.abort()
+		}
+		return nil, nil, err
+	}
+	return f, out, nil
+}
+
+//destinationFactory returns the factory of writers writing items for decoding input to their
+// destination, and the output it writes to, for outputFactory
+func destinationFactory(input string, wFactory config_decoder.WriterFactory) (config_decoder.WriterFactory, *output, error) {
+	if dry != nil {
+		return dry.factory(writerDestination(input)), nil, nil
+	}
+	if writerKind != "file" {
+		return wFactory, nil, nil
+	}
+
+	path := ""
+	if outputTemplate != "" {
+		path = expandOutput(outputTemplate, input)
+	}
+	if config_decoder.IsNameTemplate(path) {
+		route, err := config_decoder.ParseNameTemplate(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", errOutputFailed, err)
+		}
+		out := createRoutedOutput(route, fileOpts)
+		return config_decoder.RoutedFileWriterFactory(route, out.part, fileOpts.encoding()), out, nil
+	}
+	out, err := createOutput(path, fileOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errOutputFailed, err)
+	}
+	return config_decoder.EncodedFileWriterFactory(out, fileOpts.encoding()), out, nil
+}
+
+//finishOutput completes out if every item of result was written, and otherwise aborts it
+// A failure to complete the output becomes the result's error.
+func finishOutput(out *output, result *runResult) {
+	if out == nil {
+		return
+	}
+
+	writeErrors := 0
+	for _, s := range result.Workers {
+		writeErrors += s.ErrorCount
+	}
+	if result.Err != nil || writeErrors > 0 {
+		out.abort()
+		if out.tmp {
+			logger.Warnf("discarded incomplete output %s", out.path)
+		}
+		return
+	}
+
+	if err := out.commit(); err != nil {
+		result.Err = err
+		return
+	}
+	if out.route != nil {
+		logger.Infof("wrote %d files named by %s", len(out.parts), out.path)
+		_ = out.eachPart(func(p *output) error {
+			result.Outputs = append(result.Outputs, p.path)
+			return nil
+		})
+	} else {
+		if out.path != "" {
+			logger.Infof("wrote %s", out.path)
+		}
+		result.Outputs = []string{out.name()}
+	}
+}
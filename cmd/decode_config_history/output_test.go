@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestExpandOutput(t *testing.T) {
+	tests := []struct {
+		template, input, want string
+	}{
+		{"out/{basename}.ndjson", "snapshots/a.json.gz", "out/a.ndjson"},
+		{"out/{basename}.ndjson", "snapshots/a.json", "out/a.ndjson"},
+		{"{dir}/{basename}.items", "/data/in/b.gz", "/data/in/b.items"},
+		// only the .json and .gz extensions are dropped
+		{"{basename}.out", "c.ndjson", "c.ndjson.out"},
+		{"{dir}/{basename}", "d.json", "./d"},
+		{"fixed.json", "e.json", "fixed.json"},
+	}
+	for _, tt := range tests {
+		if got := expandOutput(tt.template, tt.input); got != tt.want {
+			t.Errorf("expandOutput(%q, %q) = %q, want %q", tt.template, tt.input, got, tt.want)
+		}
+	}
+}
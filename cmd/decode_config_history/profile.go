@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runpprof "runtime/pprof"
+	"runtime/trace"
+)
+
+//stopProfiling stops the profiles started by startProfiling, writing them out
+var stopProfiling = func() {}
+
+//startProfiling starts the CPU profile and execution trace asked for by -cpuprofile and -trace
+// The heap profile for -memprofile is written when profiling stops.
+func startProfiling() error {
+	var stops []func()
+	stopProfiling = func() {
+		for i := len(stops) - 1; i >= 0; i-- {
+			stops[i]()
+		}
+		stops = nil
+	}
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return fmt.Errorf("startProfiling: %w", err)
+		}
+		if err := runpprof.StartCPUProfile(f); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("startProfiling: %w", err)
+		}
+		stops = append(stops, func() {
+			runpprof.StopCPUProfile()
+			_ = f.Close()
+		})
+	}
+
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			stopProfiling()
+			return fmt.Errorf("startProfiling: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			_ = f.Close()
+			stopProfiling()
+			return fmt.Errorf("startProfiling: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			_ = f.Close()
+		})
+	}
+
+	if memProfile != "" {
+		stops = append(stops, writeHeapProfile)
+	}
+	return nil
+}
+
+//writeHeapProfile writes the -memprofile heap profile, after a GC so it's up to date
+func writeHeapProfile() {
+	f, err := os.Create(memProfile)
+	if err != nil {
+		logger.Errorf("memprofile: %s", err)
+		return
+	}
+	defer f.Close()
+
+	runtime.GC()
+	if err := runpprof.WriteHeapProfile(f); err != nil {
+		logger.Errorf("memprofile: %s", err)
+	}
+}
+
+//handlePprof serves the net/http/pprof endpoints under /debug/pprof/ on mux
+func handlePprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"path"
+	"slices"
+	"sync"
+)
+
+//sniffSize is how much of a document is read to find the top-level fields a spec profile matches
+const sniffSize = 64 << 10
+
+//specProfile is a named spec of the -config file's specs setting, chosen for the input files it matches
+// A profile matches a file whose base name matches one of its Files patterns, if it has any, and whose
+// first sniffSize bytes have all its top-level Fields, if it has any; one with neither matches any file.
+type specProfile struct {
+	Name   string   `json:"name"`
+	Files  []string `json:"files"`
+	Fields []string `json:"fields"`
+	Spec   any      `json:"spec"`
+	spec   config_decoder.ItemTransformSpec
+}
+
+var (
+	profilesMu sync.Mutex
+	//specProfiles are the spec profiles of the -config file, in the order they're tried
+	specProfiles []specProfile
+)
+
+//configFileProfiles converts the specs setting to spec profiles
+func configFileProfiles(v any) ([]specProfile, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("specs: %w", err)
+	}
+	var profiles []specProfile
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return nil, fmt.Errorf("specs: want a list of profiles with a name, files, fields and spec: %w", err)
+	}
+
+	names := make(map[string]bool, len(profiles))
+	for i := range profiles {
+		p := &profiles[i]
+		if p.Name == "" || names[p.Name] {
+			return nil, fmt.Errorf("specs: profile %d needs a name of its own", i+1)
+		}
+		names[p.Name] = true
+		for _, pattern := range p.Files {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("specs: %s: files %q: %w", p.Name, pattern, err)
+			}
+		}
+		if p.spec, err = configFileSpec(p.Spec); err != nil {
+			return nil, fmt.Errorf("specs: %s: %w", p.Name, err)
+		}
+		if err := p.spec.Hash.Validate(); err != nil {
+			return nil, fmt.Errorf("specs: %s: %w", p.Name, err)
+		}
+	}
+	return profiles, nil
+}
+
+//setSpecProfiles replaces the spec profiles files are matched with
+func setSpecProfiles(profiles []specProfile) {
+	profilesMu.Lock()
+	specProfiles = profiles
+	profilesMu.Unlock()
+}
+
+//matches reports whether the profile is for the file name, whose document begins with prefix
+func (p specProfile) matches(name string, prefix []byte) bool {
+	if len(p.Files) > 0 && !slices.ContainsFunc(p.Files, func(pattern string) bool {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}) {
+		return false
+	}
+	if len(p.Fields) == 0 {
+		return true
+	}
+	fields := config_decoder.TopLevelFields(prefix)
+	for _, f := range p.Fields {
+		if !slices.Contains(fields, f) {
+			return false
+		}
+	}
+	return true
+}
+
+//sniffSpec returns spec with the items and fields of the spec profile matching file name, as profileSpec
+// does, sniffing the first bytes of its document from r, and a reader of the whole document
+func sniffSpec(spec config_decoder.ItemTransformSpec, name string, r io.Reader) (config_decoder.ItemTransformSpec, io.Reader) {
+	profilesMu.Lock()
+	n := len(specProfiles)
+	profilesMu.Unlock()
+	if n == 0 {
+		return spec, r
+	}
+	br := bufio.NewReaderSize(r, sniffSize)
+	// a short document is peeked whole; errors reading it are left for decoding to report
+	prefix, _ := br.Peek(sniffSize)
+	return profileSpec(spec, name, prefix), br
+}
+
+//profileSpec returns spec with the items and fields of the first spec profile matching file name, whose
+// document begins with prefix, or spec itself if there are no profiles or none matches
+// The profile's ItemsField, Fields, Strict and Hash replace spec's; the rest, set by the command line,
+// is kept, though -strict and -hash-field still apply.
+func profileSpec(spec config_decoder.ItemTransformSpec, name string, prefix []byte) config_decoder.ItemTransformSpec {
+	profilesMu.Lock()
+	profiles := specProfiles
+	profilesMu.Unlock()
+	if len(profiles) == 0 {
+		return spec
+	}
+
+	for _, p := range profiles {
+		if !p.matches(name, prefix) {
+			continue
+		}
+		logger.Infof("decoding %s with spec profile %s", name, p.Name)
+		spec.ItemsField, spec.Fields = p.spec.ItemsField, p.spec.Fields
+		spec.Strict = p.spec.Strict || strict
+		if itemHash.Field == "" && len(itemHash.Fields) == 0 {
+			spec.Hash = p.spec.Hash
+		}
+		return spec
+	}
+	logger.Infof("no spec profile matches %s, decoding it with the default spec", name)
+	return spec
+}
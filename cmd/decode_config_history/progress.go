@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//progressBarWidth is the number of cells in the progress bar
+const progressBarWidth = 30
+
+//progress reports how far decoding has got
+// On a terminal it redraws a progress bar every fraction of a second; otherwise it logs a line
+// every interval. Progress through the input is bytes read of its size, so ETA is only shown
+// when both are known. With -dashboard, a terminal shows the dashboard instead of the bar.
+type progress struct {
+	tty      bool
+	dash     *dashboard
+	interval time.Duration
+	items    atomic.Int64
+	total    int64
+	read     func() int64
+	start    time.Time
+	done     chan struct{}
+	stopped  chan struct{}
+	// last is each worker's items as of the previous progress line
+	last map[int]int
+}
+
+//newProgress creates a progress reporter, or returns nil if interval is 0 without -dashboard, or with -quiet
+// The progress bar would garble json logs, so with -log-format json progress is always logged.
+func newProgress(interval time.Duration) *progress {
+	if (interval <= 0 && !dashboardMode) || quiet {
+		return nil
+	}
+	p := &progress{tty: isTerminal(os.Stderr) && logFormat == "console", interval: interval}
+	if dashboardMode {
+		if !p.tty {
+			logger.Warn("-dashboard needs stderr to be a terminal and -log-format console; logging progress instead")
+		} else {
+			p.dash = &dashboard{stats: liveStats}
+		}
+	}
+	if !p.tty && interval <= 0 {
+		return nil
+	}
+	return p
+}
+
+//isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+//wrap counts the items written by writers from f
+func (p *progress) wrap(f config_decoder.WriterFactory) config_decoder.WriterFactory {
+	return func(ctx context.Context, worker int) (config_decoder.ItemWriter, error) {
+		w, err := f(ctx, worker)
+		if err != nil {
+			return nil, err
+		}
+		pw := progressWriter{w: w, p: p}
+		if _, ok := pw.w.(config_decoder.ByteCounter); ok {
+			return countingProgressWriter{pw}, nil
+		}
+		return pw, nil
+	}
+}
+
+//begin starts reporting; read returns the bytes of total read so far, and may be nil
+func (p *progress) begin(total int64, read func() int64) {
+	p.total, p.read = total, read
+	p.start = time.Now()
+	p.done = make(chan struct{})
+	p.stopped = make(chan struct{})
+	p.last = make(map[int]int)
+
+	if p.dash != nil {
+		go func() {
+			defer close(p.stopped)
+			if err := p.dash.run(p, p.done); err != nil {
+				logger.Warnf("dashboard: %v", err)
+			}
+		}()
+		return
+	}
+
+	every := p.interval
+	if p.tty {
+		every = 200 * time.Millisecond
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				if p.tty {
+					p.draw()
+					_, _ = fmt.Fprintln(os.Stderr)
+				}
+				return
+			case <-ticker.C:
+				if p.tty {
+					p.draw()
+				} else {
+					logger.Infof("progress: %s", p.status())
+					p.logWorkers(every)
+				}
+			}
+		}
+	}()
+}
+
+//logWorkers logs each worker's throughput over the last interval, with -v
+func (p *progress) logWorkers(interval time.Duration) {
+	for _, ws := range liveStats.Snapshot() {
+		rate := float64(ws.ItemCount-p.last[ws.WorkerNum]) / interval.Seconds()
+		p.last[ws.WorkerNum] = ws.ItemCount
+		logger.Debugf("progress: worker %d %s, %d items, %.0f items/sec, %d errors",
+			ws.WorkerNum, ws.Status, ws.ItemCount, rate, ws.ErrorCount)
+	}
+}
+
+//end stops reporting, finishing the progress bar
+func (p *progress) end() {
+	close(p.done)
+	<-p.stopped
+}
+
+//draw redraws the progress bar in place
+func (p *progress) draw() {
+	_, _ = fmt.Fprintf(os.Stderr, "\r[%s] %s\033[K", p.bar(), p.status())
+}
+
+//bar is the progress bar's cells, filled as far as the input's read, or empty if that's unknown
+func (p *progress) bar() string {
+	frac := p.fraction()
+	if frac < 0 {
+		return strings.Repeat(" ", progressBarWidth)
+	}
+	filled := int(frac * progressBarWidth)
+	return strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+}
+
+//fraction is the fraction of the input read, or -1 if unknown
+func (p *progress) fraction() float64 {
+	if p.read == nil || p.total <= 0 {
+		return -1
+	}
+	f := float64(p.read()) / float64(p.total)
+	if f > 1 {
+		f = 1
+	}
+	return f
+}
+
+//status describes progress so far in one line
+func (p *progress) status() string {
+	elapsed := time.Since(p.start)
+	items := p.items.Load()
+
+	var b strings.Builder
+	frac := p.fraction()
+	if frac >= 0 {
+		fmt.Fprintf(&b, "%3.0f%% %s of %s, ", frac*100, byteCountSI(int(p.read())), byteCountSI(int(p.total)))
+	}
+	fmt.Fprintf(&b, "%d items, %.0f items/sec", items, float64(items)/elapsed.Seconds())
+	if frac > 0 && frac < 1 {
+		eta := time.Duration(float64(elapsed) * (1 - frac) / frac)
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+	return b.String()
+}
+
+//progressWriter is an ItemWriter counting the items written through it
+// With a dashboard it also records resource types.
+// It passes Flush, Close and Healthy through to writers implementing them; countingProgressWriter passes
+// BytesWritten through too, so writers not counting bytes aren't taken for ones that do.
+type progressWriter struct {
+	w config_decoder.ItemWriter
+	p *progress
+}
+
+// Write implements ItemWriter for progressWriter
+func (pw progressWriter) Write(item map[string]interface{}) error {
+	if pw.p.dash == nil {
+		err := pw.w.Write(item)
+		pw.p.items.Add(1)
+		return err
+	}
+
+	// a reused item is cleared by the write
+	resourceType, _ := item["resourceType"].(string)
+	err := pw.w.Write(item)
+	pw.p.items.Add(1)
+	pw.p.dash.saw(resourceType)
+	return err
+}
+
+// Flush implements Flusher for progressWriter
+func (pw progressWriter) Flush() error {
+	if f, ok := pw.w.(config_decoder.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close implements io.Closer for progressWriter
+func (pw progressWriter) Close() error {
+	if c, ok := pw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//countingProgressWriter is a progressWriter of a ByteCounter
+type countingProgressWriter struct {
+	progressWriter
+}
+
+// BytesWritten implements ByteCounter for countingProgressWriter
+func (pw countingProgressWriter) BytesWritten() int64 {
+	return pw.w.(config_decoder.ByteCounter).BytesWritten()
+}
+
+// Healthy implements HealthChecker for progressWriter
+func (pw progressWriter) Healthy() error {
+	if hc, ok := pw.w.(config_decoder.HealthChecker); ok {
+		return hc.Healthy()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//sizeRoute is a -route-size rule, sending items over a size to their own sink
+type sizeRoute struct {
+	sink  string
+	name  string
+	out   *output
+	route *config_decoder.SizeRoute
+}
+
+var (
+	routesMu sync.Mutex
+	//sizeRoutes are the -route-size rules; their sinks are created by the first decode
+	sizeRoutes []*sizeRoute
+)
+
+//addSizeRoute adds a -route-size rule, bytes=sink
+func addSizeRoute(v string) error {
+	size, sink, ok := strings.Cut(v, "=")
+	n, err := strconv.Atoi(size)
+	if !ok || err != nil || n <= 0 || sink == "" {
+		return fmt.Errorf("want bytes=writer, e.g. 900000=file:///out/large.ndjson, not %q", v)
+	}
+	for _, r := range sizeRoutes {
+		if r.route.MinSize == n {
+			return fmt.Errorf("items over %d bytes are already routed to %s", n, r.sink)
+		}
+	}
+	sizeRoutes = append(sizeRoutes, &sizeRoute{sink: sink, name: sink, route: &config_decoder.SizeRoute{MinSize: n}})
+	return nil
+}
+
+//routeBySize returns the factory of writers sending the items of the -route-size rules to their sinks,
+// and the rest to writers from f
+// In a dry run, the items are counted for each sink instead.
+func routeBySize(f config_decoder.WriterFactory) (config_decoder.WriterFactory, error) {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if len(sizeRoutes) == 0 {
+		return f, nil
+	}
+
+	routes := make([]*config_decoder.SizeRoute, 0, len(sizeRoutes))
+	for _, r := range sizeRoutes {
+		if dry != nil {
+			destination := fmt.Sprintf("%s (items over %d bytes)", r.sink, r.route.MinSize)
+			routes = append(routes, &config_decoder.SizeRoute{MinSize: r.route.MinSize, Factory: dry.factory(destination)})
+			continue
+		}
+		if r.route.Factory == nil {
+			factory, out, name, err := sinkWriterFactory(r.sink)
+			if err != nil {
+				return nil, fmt.Errorf("routeBySize: %w", err)
+			}
+			r.route.Factory, r.out, r.name = factory, out, name
+		}
+		routes = append(routes, r.route)
+	}
+	return config_decoder.SizeRoutedWriterFactory(f, routes), nil
+}
+
+//routedCounts returns the items sent to each -route-size sink so far, or nil if there are none
+func routedCounts() map[string]int64 {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if len(sizeRoutes) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64, len(sizeRoutes))
+	for _, r := range sizeRoutes {
+		counts[r.name] = r.route.Items()
+	}
+	return counts
+}
+
+//closeRoutes completes the outputs of -route-size file sinks, logging the items sent to each sink
+// Like the run's findings, routed items are kept even if decoding failed.
+func closeRoutes() error {
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	var err error
+	for _, r := range sizeRoutes {
+		if r.route.Factory == nil {
+			continue
+		}
+		if r.out != nil {
+			if cErr := r.out.commit(); cErr != nil && err == nil {
+				err = &exitError{code: exitWrite, err: cErr}
+			}
+		}
+		logger.Infof("routed %d items over %d bytes to %s", r.route.Items(), r.route.MinSize, r.name)
+	}
+	return err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/ledger"
+	"os"
+	"sync"
+	"time"
+)
+
+//errDuplicateRun is an input the -run-ledger records as decoded with the same configuration, refused
+// with -on-duplicate refuse
+var errDuplicateRun = errors.New("already decoded with the same configuration")
+
+var (
+	runLedgerMu sync.Mutex
+	//runLedger is the -run-ledger, opened by the first input checked against it
+	runLedger ledger.Ledger
+)
+
+//runMark identifies an input, by its name, content and the configuration it's decoded with, in the
+// -run-ledger; its key is empty if there's no -run-ledger, or the input's content can't be identified
+type runMark struct {
+	key         string
+	fingerprint string
+}
+
+//openRunLedger returns the -run-ledger, opening it if need be
+func openRunLedger() (ledger.Ledger, error) {
+	runLedgerMu.Lock()
+	defer runLedgerMu.Unlock()
+	if runLedger != nil {
+		return runLedger, nil
+	}
+	if onDuplicate != "refuse" && onDuplicate != "warn" {
+		return nil, fmt.Errorf("openRunLedger: unknown -on-duplicate %q", onDuplicate)
+	}
+	l, err := ledger.Open(runLedgerURI)
+	if err != nil {
+		return nil, fmt.Errorf("openRunLedger: %w", err)
+	}
+	runLedger = l
+	return l, nil
+}
+
+//closeRunLedger closes the -run-ledger, if it was opened
+func closeRunLedger() error {
+	runLedgerMu.Lock()
+	defer runLedgerMu.Unlock()
+	if runLedger == nil {
+		return nil
+	}
+	err := runLedger.Close()
+	runLedger = nil
+	return err
+}
+
+//ruleFileSums are the hashes of the contents of the -rules-file files, which stand in for their rules
+// in configFingerprint, as compiled rules can't be hashed
+var ruleFileSums []string
+
+//addRuleFileSum adds the hash of the contents of the -rules-file path to ruleFileSums
+func addRuleFileSum(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("addRuleFileSum: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	ruleFileSums = append(ruleFileSums, hex.EncodeToString(sum[:]))
+	return nil
+}
+
+//configFingerprint returns the hash of the configuration input is decoded with: spec, but for its run
+// id and source, the -config file's spec profiles, the contents of the -rules-file files and the
+// writer's destination for input
+func configFingerprint(spec config_decoder.ItemTransformSpec, input string) (string, error) {
+	spec.RunID, spec.Source = "", ""
+	profilesMu.Lock()
+	profiles := specProfiles
+	profilesMu.Unlock()
+	b, err := json.Marshal(struct {
+		Spec        config_decoder.ItemTransformSpec
+		Profiles    []specProfile
+		RuleFiles   []string `json:",omitempty"`
+		Destination string
+	}{spec, profiles, ruleFileSums, writerDestination(input)})
+	if err != nil {
+		return "", fmt.Errorf("configFingerprint: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+//checkRun checks the -run-ledger for input, whose content is identified by fingerprint, decoded with spec
+// An input recorded as decoded with the same configuration, and unchanged since, is refused with
+// errDuplicateRun, or with -on-duplicate warn, warned of. Dry runs, which write nothing, aren't checked.
+func checkRun(spec config_decoder.ItemTransformSpec, input, fingerprint string) (runMark, error) {
+	if runLedgerURI == "" || dryRunMode || fingerprint == "" {
+		return runMark{}, nil
+	}
+	l, err := openRunLedger()
+	if err != nil {
+		return runMark{}, err
+	}
+	config, err := configFingerprint(spec, input)
+	if err != nil {
+		return runMark{}, err
+	}
+
+	mark := runMark{key: input + " " + config, fingerprint: fingerprint}
+	e, ok, err := l.Get(mark.key)
+	if err != nil {
+		return runMark{}, fmt.Errorf("checkRun: %w", err)
+	}
+	if !ok || e.State != ledger.Done || e.Fingerprint != fingerprint {
+		return mark, nil
+	}
+	if onDuplicate == "warn" {
+		logger.Warnf("%s was decoded with the same configuration at %s; decoding it again (-on-duplicate warn)",
+			input, e.UpdatedAt.Format(time.RFC3339))
+		return mark, nil
+	}
+	return runMark{}, fmt.Errorf("%s was %w at %s, writing %d items (-on-duplicate warn decodes it again)",
+		input, errDuplicateRun, e.UpdatedAt.Format(time.RFC3339), e.Items)
+}
+
+//checkFileRun checks the -run-ledger for the local input file name, as checkRun does
+// Standard input and AWS Config queries can't be identified, so aren't checked.
+func checkFileRun(name string) (runMark, error) {
+	if runLedgerURI == "" || dryRunMode || name == "-" || resourceTypes != "" {
+		return runMark{}, nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		// left for decoding to report
+		return runMark{}, nil
+	}
+	spec, err := loadSpec(specFile)
+	if err != nil {
+		return runMark{}, err
+	}
+	return checkRun(spec, name, fingerprint(info))
+}
+
+//recordRun records the input of mark in the -run-ledger as decoded, if every item of result was written
+// A failure to record it is logged, as its items have been written all the same.
+func recordRun(mark runMark, result runResult) {
+	if mark.key == "" || result.Err != nil {
+		return
+	}
+	for _, s := range result.Workers {
+		if s.ErrorCount > 0 {
+			return
+		}
+	}
+
+	l, err := openRunLedger()
+	if err == nil {
+		err = l.Put(ledger.Entry{Key: mark.key, Fingerprint: mark.fingerprint, State: ledger.Done,
+			UpdatedAt: time.Now().UTC(), Items: result.ItemCount})
+	}
+	if err != nil {
+		logger.Errorf("error recording %s in the -run-ledger, so it may be decoded again: %s", result.File, err)
+	}
+}
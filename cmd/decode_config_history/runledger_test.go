@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//writeRules writes a -rules-file of one rule, of expression, to dir
+func writeRules(t *testing.T, dir, expression string) string {
+	path := filepath.Join(dir, "rules.yaml")
+	rules := "rules:\n  - id: untagged\n    expression: \"" + expression + "\"\n"
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckRun(t *testing.T) {
+	defer func(uri, on string, rf []config_decoder.Rule, sums []string) {
+		runLedgerURI, onDuplicate, ruleFiles, ruleFileSums = uri, on, rf, sums
+	}(runLedgerURI, onDuplicate, ruleFiles, ruleFileSums)
+	defer closeRunLedger()
+
+	dir := t.TempDir()
+	runLedgerURI, onDuplicate = filepath.Join(dir, "runs.ledger"), "refuse"
+	spec := config_decoder.ItemTransformSpec{ItemsField: "configurationItems", RunID: "run-1", Source: "a.json"}
+	done := func(mark runMark) {
+		t.Helper()
+		if mark.key == "" {
+			t.Fatal("input not checked")
+		}
+		recordRun(mark, runResult{File: "a.json", ItemCount: 3})
+	}
+
+	mark, err := checkRun(spec, "a.json", "fp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(mark)
+
+	// a new run of the same input with the same configuration is a duplicate
+	spec.RunID = "run-2"
+	if _, err := checkRun(spec, "a.json", "fp-1"); !errors.Is(err, errDuplicateRun) {
+		t.Fatalf("second run: %v, want errDuplicateRun", err)
+	}
+	onDuplicate = "warn"
+	if mark, err := checkRun(spec, "a.json", "fp-1"); err != nil || mark.key == "" {
+		t.Errorf("-on-duplicate warn: %+v, %v", mark, err)
+	}
+	onDuplicate = "refuse"
+
+	// a changed input, or configuration, isn't
+	if _, err := checkRun(spec, "a.json", "fp-2"); err != nil {
+		t.Errorf("changed input: %v", err)
+	}
+	changed := spec
+	changed.UseNumber = true
+	if _, err := checkRun(changed, "a.json", "fp-1"); err != nil {
+		t.Errorf("changed spec: %v", err)
+	}
+
+	// nor are runs that failed to write items
+	mark, err = checkRun(spec, "b.json", "fp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordRun(mark, runResult{File: "b.json", Workers: []config_decoder.WorkerStatus{{ErrorCount: 1}}})
+	if _, err := checkRun(spec, "b.json", "fp-1"); err != nil {
+		t.Errorf("failed run: %v", err)
+	}
+}
+
+func TestCheckRunRuleFiles(t *testing.T) {
+	defer func(uri, on string, rf []config_decoder.Rule, sums []string) {
+		runLedgerURI, onDuplicate, ruleFiles, ruleFileSums = uri, on, rf, sums
+	}(runLedgerURI, onDuplicate, ruleFiles, ruleFileSums)
+	defer closeRunLedger()
+
+	dir := t.TempDir()
+	runLedgerURI, onDuplicate = filepath.Join(dir, "runs.ledger"), "refuse"
+	ruleFiles, ruleFileSums = nil, nil
+	if err := addRulesFile(writeRules(t, dir, "size(item.tags) == 0")); err != nil {
+		t.Fatal(err)
+	}
+	spec := config_decoder.ItemTransformSpec{ItemsField: "configurationItems", Rules: config_decoder.ItemRules{Custom: ruleFiles}}
+
+	mark, err := checkRun(spec, "a.json", "fp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordRun(mark, runResult{File: "a.json"})
+	if _, err := checkRun(spec, "a.json", "fp-1"); !errors.Is(err, errDuplicateRun) {
+		t.Fatalf("same rules: %v, want errDuplicateRun", err)
+	}
+
+	// the rules aren't in the spec's json, so an edited rule file only shows in its hash
+	ruleFiles, ruleFileSums = nil, nil
+	if err := addRulesFile(writeRules(t, dir, "!has(item.tags)")); err != nil {
+		t.Fatal(err)
+	}
+	spec.Rules.Custom = ruleFiles
+	if _, err := checkRun(spec, "a.json", "fp-1"); err != nil {
+		t.Errorf("edited rule file: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/version"
+	"time"
+)
+
+//runManifestFile, set by -run-manifest, is the file the run's manifest is written to on exit; "" writes none
+var runManifestFile string
+
+//runManifestInput is an input decoded by the run, in its manifest
+// Outputs are the files the file writer completed, stdout, or for an -output template, every file it
+// named; another writer's items went to its Destination. Outputs are only listed once complete, so an
+// input that failed has none, though what it appended to a file, or wrote to stdout, is kept.
+type runManifestInput struct {
+	Input           string   `json:"input"`
+	Items           int      `json:"items"`
+	ItemBytes       int      `json:"itemBytes"`
+	InputBytes      int64    `json:"inputBytes"`
+	DocumentBytes   int64    `json:"documentBytes"`
+	WriteErrors     int      `json:"writeErrors"`
+	Error           string   `json:"error,omitempty"`
+	ErrorCategory   string   `json:"errorCategory,omitempty"`
+	Outputs         []string `json:"outputs,omitempty"`
+	Destination     string   `json:"destination,omitempty"`
+	DurationSeconds float64  `json:"durationSeconds"`
+}
+
+//runManifest lists every input a run decoded, and what it produced, for downstream jobs to consume
+type runManifest struct {
+	Version  string             `json:"version"`
+	RunID    string             `json:"runId"`
+	Start    time.Time          `json:"start"`
+	End      time.Time          `json:"end"`
+	ExitCode int                `json:"exitCode"`
+	Inputs   []runManifestInput `json:"inputs"`
+}
+
+//newRunManifestInput returns the manifest entry of result
+func newRunManifestInput(result runResult) runManifestInput {
+	mi := runManifestInput{Input: result.File, Items: result.ItemCount, ItemBytes: result.ItemBytes, InputBytes: result.InputBytes,
+		DocumentBytes: result.DocumentBytes, Outputs: result.Outputs, DurationSeconds: result.Duration.Seconds()}
+	for _, s := range result.Workers {
+		mi.WriteErrors += s.ErrorCount
+	}
+	if result.Err != nil {
+		mi.Error = result.Err.Error()
+		mi.ErrorCategory = errorCategory(result.Err)
+	}
+	if writerKind != "file" {
+		mi.Destination = writerDestination(result.File)
+	}
+	return mi
+}
+
+//emitRunManifest writes the manifest of the inputs rs totals to -run-manifest, if it's set, replacing the
+// file atomically, so a job waiting on it never reads part of one
+func emitRunManifest(rs *runSummary) error {
+	if runManifestFile == "" {
+		return nil
+	}
+	rs.mu.Lock()
+	m := runManifest{Version: version.Get().Version, RunID: runID, Start: rs.Start.UTC(), End: time.Now().UTC(),
+		ExitCode: rs.exitCodeLocked(), Inputs: append([]runManifestInput{}, rs.inputs...)}
+	rs.mu.Unlock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("emitRunManifest: %w", err)
+	}
+
+	out, err := createOutput(runManifestFile, fileOptions{})
+	if err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("emitRunManifest: %w", err)}
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.abort()
+		return &exitError{code: exitWrite, err: fmt.Errorf("emitRunManifest: %w", err)}
+	}
+	if err := out.commit(); err != nil {
+		return &exitError{code: exitWrite, err: fmt.Errorf("emitRunManifest: %w", err)}
+	}
+	logger.Infof("wrote the manifest of %d inputs to %s", len(m.Inputs), runManifestFile)
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//snapshotKeyPattern matches the name AWS Config gives a snapshot it delivers,
+// <account>_Config_<region>_ConfigSnapshot_<time>_<snapshot id>.json.gz
+var snapshotKeyPattern = regexp.MustCompile(`^(\d{12})_Config_([a-z0-9-]+)_ConfigSnapshot_(\d{8}T\d{6}Z)_[^_]+\.json(\.gz)?$`)
+
+//historyKeyPattern matches the name AWS Config gives a history file it delivers, of the period to <end>,
+// <account>_Config_<region>_ConfigHistory_<resource type>_<start>_<end>_<n>.json.gz
+var historyKeyPattern = regexp.MustCompile(`^(\d{12})_Config_([a-z0-9-]+)_ConfigHistory_.+_(\d{8}T\d{6}Z)_(\d{8}T\d{6}Z)_\d+\.json(\.gz)?$`)
+
+//snapshotKey is what a snapshot's key says about it
+type snapshotKey struct {
+	account  string
+	region   string
+	captured time.Time
+}
+
+//parseSnapshotKey parses the name of a snapshot key, reporting whether it's named as AWS Config names them
+func parseSnapshotKey(key string) (snapshotKey, bool) {
+	m := snapshotKeyPattern.FindStringSubmatch(path.Base(key))
+	if m == nil {
+		return snapshotKey{}, false
+	}
+	captured, err := time.Parse("20060102T150405Z", m[3])
+	if err != nil {
+		return snapshotKey{}, false
+	}
+	return snapshotKey{account: m[1], region: m[2], captured: captured}, true
+}
+
+//deliveryTime returns the time the snapshot or history file named name was delivered, as its name says,
+// reporting whether it's named as AWS Config names them
+// A history file is delivered at the end of the period it records.
+func deliveryTime(name string) (time.Time, bool) {
+	if key, ok := parseSnapshotKey(name); ok {
+		return key.captured, true
+	}
+	m := historyKeyPattern.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse("20060102T150405Z", m[4])
+	return end, err == nil
+}
+
+//snapshotSelection selects snapshot objects by the time and place in their keys
+type snapshotSelection struct {
+	since, until time.Time
+	latest       bool
+}
+
+//newSnapshotSelection creates the selection given by -since, -until and -latest-per-region
+func newSnapshotSelection() (snapshotSelection, error) {
+	sel := snapshotSelection{latest: latestPerRegion}
+	var err error
+	if sel.since, err = parseSelectionTime(since); err != nil {
+		return sel, fmt.Errorf("-since: %w", err)
+	}
+	if sel.until, err = parseSelectionTime(until); err != nil {
+		return sel, fmt.Errorf("-until: %w", err)
+	}
+	if !sel.since.IsZero() && !sel.until.IsZero() && !sel.since.Before(sel.until) {
+		return sel, fmt.Errorf("-since %s is not before -until %s", since, until)
+	}
+	return sel, nil
+}
+
+//parseSelectionTime parses a YYYY-MM-DD date or RFC 3339 time; "" is the zero time
+func parseSelectionTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (sel snapshotSelection) active() bool {
+	return !sel.since.IsZero() || !sel.until.IsZero() || sel.latest
+}
+
+//apply returns the snapshots selected, in order
+// Snapshots are taken from -since up to but not including -until, and with -latest-per-region only
+// the latest of each account and region is kept. Objects whose keys aren't named as AWS Config names
+// snapshots can't be selected, so are skipped if any selection is made.
+func (sel snapshotSelection) apply(snapshots []snapshotObject) []snapshotObject {
+	if !sel.active() {
+		return snapshots
+	}
+
+	type candidate struct {
+		so  snapshotObject
+		key snapshotKey
+	}
+	var candidates []candidate
+	latest := make(map[[2]string]time.Time)
+	for _, so := range snapshots {
+		key, ok := parseSnapshotKey(so.key)
+		switch {
+		case !ok:
+			logger.Debugf("skipped %s, which isn't named as a snapshot", so.name())
+			continue
+		case !sel.since.IsZero() && key.captured.Before(sel.since),
+			!sel.until.IsZero() && !key.captured.Before(sel.until):
+			continue
+		}
+		candidates = append(candidates, candidate{so, key})
+		if k := [2]string{key.account, key.region}; key.captured.After(latest[k]) {
+			latest[k] = key.captured
+		}
+	}
+
+	var selected []snapshotObject
+	for _, c := range candidates {
+		if sel.latest && c.key.captured.Before(latest[[2]string{c.key.account, c.key.region}]) {
+			continue
+		}
+		selected = append(selected, c.so)
+	}
+	logger.Infof("selected %d of %d snapshots", len(selected), len(snapshots))
+	return selected
+}
+
+//decodeS3Prefix decodes the snapshots under -file s3://bucket/prefix that -since, -until and
+// -latest-per-region select, as orchestrate does
+// Snapshots are reported under the account and region their keys name.
+func decodeS3Prefix(start time.Time) error {
+	find, err := prefixSnapshots()
+	if err != nil {
+		return err
+	}
+	return orchestrate(start, find, nil)
+}
+
+//prefixSnapshots returns the function listing the snapshots under -file s3://bucket/prefix, for orchestrate
+func prefixSnapshots() (func(ctx context.Context, o *orchestration) []snapshotObject, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(inputFile, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("decodeS3Prefix: %s names no bucket", inputFile)
+	}
+	session, err := newAWSSession(context.Background(), awsInput)
+	if err != nil {
+		return nil, &exitError{code: exitInput, err: fmt.Errorf("decodeS3Prefix: %w", err)}
+	}
+	client, err := session.S3Client("")
+	if err != nil {
+		return nil, &exitError{code: exitInput, err: fmt.Errorf("decodeS3Prefix: %w", err)}
+	}
+
+	return func(ctx context.Context, o *orchestration) []snapshotObject {
+		objects, err := client.ListObjects(ctx, bucket, prefix)
+		if err != nil {
+			logger.Errorf("error listing snapshots: %s", err)
+			o.add("", "", runResult{File: inputFile, Err: fmt.Errorf("%w: %w", errInputFailed, err)}, true)
+			return nil
+		}
+
+		var snapshots []snapshotObject
+		for _, obj := range objects {
+			if !strings.HasSuffix(obj.Key, ".json") && !strings.HasSuffix(obj.Key, ".json.gz") {
+				continue
+			}
+			key, _ := parseSnapshotKey(obj.Key)
+			snapshots = append(snapshots, snapshotObject{key.account, key.region, client, "", bucket, obj.Key, obj.ETag})
+		}
+		logger.Infof("found %d snapshots in %s", len(snapshots), inputFile)
+		return snapshots
+	}, nil
+}
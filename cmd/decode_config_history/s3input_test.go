@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliveryTime(t *testing.T) {
+	tests := []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{"123456789012_Config_us-east-1_ConfigSnapshot_20220809T134016Z_0f1d63cc-aee4-48b8-82ab-4f38087be14e.json.gz",
+			time.Date(2022, 8, 9, 13, 40, 16, 0, time.UTC), true},
+		{"AWSLogs/123456789012/Config/us-east-1/2022/8/9/ConfigSnapshot/123456789012_Config_us-east-1_ConfigSnapshot_20220809T134016Z_abc.json",
+			time.Date(2022, 8, 9, 13, 40, 16, 0, time.UTC), true},
+		// a history file is delivered at the end of its period
+		{"123456789012_Config_eu-west-1_ConfigHistory_AWS::EC2::Instance_20240101T000000Z_20240101T060000Z_1.json.gz",
+			time.Date(2024, 1, 1, 6, 0, 0, 0, time.UTC), true},
+		{"snapshot.json", time.Time{}, false},
+		{"123456789012_Config_us-east-1_ConfigSnapshot_20221309T134016Z_abc.json.gz", time.Time{}, false},
+		{"123456789012_Config_us-east-1_ConfigHistory_AWS::S3::Bucket_20240101T000000Z_20240101T250000Z_1.json", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := deliveryTime(tt.name)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("deliveryTime(%s) = %s, %t, want %s, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+//listedObject is an object as ListObjectsV2 lists it
+type listedObject struct {
+	Key  string
+	Size int64
+	ETag string
+}
+
+//fakeS3 serves the objects of its buckets, path-style, as S3 does: listed by ListObjectsV2 in pages of
+// pageSize, and read by GetObject
+// throttle is how many times each listing request, by bucket, prefix and continuation token, is
+// throttled before it's served; denied buckets refuse every request. reads counts the GetObject
+// requests of each bucket/key.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string]map[string][]byte
+	pageSize int
+	throttle map[string]int
+	denied   map[string]bool
+	lists    []string
+	reads    map[string]int
+}
+
+func newFakeS3(t *testing.T, pageSize int) (*fakeS3, *httptest.Server) {
+	fs := &fakeS3{objects: make(map[string]map[string][]byte), pageSize: pageSize, throttle: make(map[string]int),
+		denied: make(map[string]bool), reads: make(map[string]int)}
+	srv := httptest.NewServer(fs)
+	t.Cleanup(srv.Close)
+	return fs, srv
+}
+
+func (fs *fakeS3) put(bucket, key string, body []byte) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.objects[bucket] == nil {
+		fs.objects[bucket] = make(map[string][]byte)
+	}
+	fs.objects[bucket][key] = body
+}
+
+//listed returns the listing requests served, and throttled, so far
+func (fs *fakeS3) listed() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]string(nil), fs.lists...)
+}
+
+//read returns the number of times the object key of bucket has been read so far
+func (fs *fakeS3) read(bucket, key string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.reads[bucket+"/"+key]
+}
+
+func (fs *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.denied[bucket] {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		return
+	}
+	if key != "" {
+		fs.reads[bucket+"/"+key]++
+		body, ok := fs.objects[bucket][key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, len(body)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+		return
+	}
+
+	query := r.URL.Query()
+	prefix, token := query.Get("prefix"), query.Get("continuation-token")
+	request := bucket + "/" + prefix + "@" + token
+	fs.lists = append(fs.lists, request)
+	if fs.throttle[request] > 0 {
+		fs.throttle[request]--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = io.WriteString(w, `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`)
+		return
+	}
+
+	var keys []string
+	for k := range fs.objects[bucket] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(token)
+	page := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []listedObject
+	}{}
+	for _, k := range keys[start:min(start+fs.pageSize, len(keys))] {
+		body := fs.objects[bucket][k]
+		page.Contents = append(page.Contents, listedObject{Key: k, Size: int64(len(body)), ETag: fmt.Sprintf(`"%x"`, len(body))})
+	}
+	if start+fs.pageSize < len(keys) {
+		page.IsTruncated, page.NextContinuationToken = true, strconv.Itoa(start+fs.pageSize)
+	}
+	_ = xml.NewEncoder(w).Encode(page)
+}
+
+//snapshotDoc is a snapshot document of n volumes
+func snapshotDoc(t *testing.T, n int) []byte {
+	t.Helper()
+	doc, err := json.Marshal(testSnapshot{FileVersion: "1.0", Items: testItems(n)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+//snapshotName is the key AWS Config gives the snapshot of account and region it delivers at captured
+func snapshotName(account, region, captured string) string {
+	return fmt.Sprintf("%s_Config_%s_ConfigSnapshot_%s_%s.json", account, region, captured, strings.ToLower(captured))
+}
+
+//TestDecodeS3Prefix checks the snapshots under an s3:// prefix are listed over several pages, with a
+// throttled page retried, and the snapshots selected are decoded
+func TestDecodeS3Prefix(t *testing.T) {
+	defer func(in, endpoint, region string, retries, conc, size int, kind, out string, d time.Duration, s, u string, l bool) {
+		inputFile, awsEndpointURL, awsRegion, awsMaxRetries, concurrency, poolSize = in, endpoint, region, retries, conc, size
+		writerKind, outputTemplate, timeout, since, until, latestPerRegion = kind, out, d, s, u, l
+	}(inputFile, awsEndpointURL, awsRegion, awsMaxRetries, concurrency, poolSize, writerKind, outputTemplate, timeout, since, until,
+		latestPerRegion)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	fs, srv := newFakeS3(t, 2)
+	const prefix = "AWSLogs/123456789012/Config/"
+	keys := []string{
+		prefix + "eu-west-1/2024/1/1/ConfigSnapshot/" + snapshotName("123456789012", "eu-west-1", "20240101T010000Z"),
+		prefix + "us-east-1/2024/1/1/ConfigSnapshot/" + snapshotName("123456789012", "us-east-1", "20240101T010000Z"),
+		prefix + "us-east-1/2024/1/2/ConfigSnapshot/" + snapshotName("123456789012", "us-east-1", "20240102T010000Z"),
+		prefix + "us-east-1/2024/1/3/ConfigSnapshot/" + snapshotName("123456789012", "us-east-1", "20240103T010000Z"),
+	}
+	for i, key := range keys {
+		fs.put("config-bucket", key, snapshotDoc(t, i+1))
+	}
+	// neither outside the prefix nor other objects under it are snapshots
+	fs.put("config-bucket", "AWSLogs/210987654321/Config/us-east-1/2024/1/1/ConfigSnapshot/"+
+		snapshotName("210987654321", "us-east-1", "20240101T010000Z"), snapshotDoc(t, 1))
+	fs.put("config-bucket", prefix+"ConfigWritabilityCheckFile", []byte("x"))
+	// the second page is throttled once
+	fs.throttle["config-bucket/"+prefix+"@2"] = 1
+
+	outDir := t.TempDir()
+	inputFile, awsEndpointURL, awsRegion, awsMaxRetries, concurrency, poolSize = "s3://config-bucket/"+prefix, srv.URL, "us-east-1", 2, 2, 2
+	writerKind, outputTemplate, timeout = "file", filepath.Join(outDir, "{basename}.jsonl"), time.Minute
+
+	find, err := prefixSnapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &orchestration{summary: newRunSummary(time.Now()), targets: make(map[[2]string]*targetSummary)}
+	var found []string
+	for _, so := range find(context.Background(), o) {
+		found = append(found, so.key)
+		if so.account != "123456789012" || so.bucket != "config-bucket" || so.etag == "" {
+			t.Errorf("snapshot %+v, want it of the account its key names", so)
+		}
+	}
+	if !reflect.DeepEqual(found, keys) {
+		t.Errorf("found %q, want each snapshot under the prefix once", found)
+	}
+	want := []string{"config-bucket/" + prefix + "@", "config-bucket/" + prefix + "@2", "config-bucket/" + prefix + "@2",
+		"config-bucket/" + prefix + "@4"}
+	if got := fs.listed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("listed %q, want three pages, the second retried", got)
+	}
+
+	// the latest snapshot of each region, since the second day
+	since, until, latestPerRegion = "2024-01-02", "", true
+	if err := decodeS3Prefix(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outputs []string
+	for _, e := range entries {
+		outputs = append(outputs, e.Name())
+	}
+	if want := []string{strings.TrimSuffix(filepath.Base(keys[3]), ".json") + ".jsonl"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs %q, want %q", outputs, want)
+	}
+	if ids := outputIDs(t, filepath.Join(outDir, outputs[0])); len(ids) != 4 {
+		t.Errorf("decoded %v, want the 4 items of the latest snapshot", ids)
+	}
+}
+
+func TestSnapshotSelection(t *testing.T) {
+	key := func(account, region, captured string) snapshotObject {
+		return snapshotObject{bucket: "config-bucket", key: "AWSLogs/" + account + "/Config/" + snapshotName(account, region, captured)}
+	}
+	a1 := key("111111111111", "us-east-1", "20240101T010000Z")
+	a2 := key("111111111111", "us-east-1", "20240102T010000Z")
+	a3 := key("111111111111", "us-east-1", "20240103T000000Z")
+	aEU := key("111111111111", "eu-west-1", "20240101T120000Z")
+	b2 := key("222222222222", "us-east-1", "20240102T060000Z")
+	history := snapshotObject{bucket: "config-bucket",
+		key: "123456789012_Config_us-east-1_ConfigHistory_AWS::EC2::Instance_20240101T000000Z_20240101T060000Z_1.json.gz"}
+	other := snapshotObject{bucket: "config-bucket", key: "exports/snapshot.json"}
+	all := []snapshotObject{a1, a2, a3, aEU, b2, history, other}
+
+	tests := []struct {
+		name         string
+		since, until string
+		latest       bool
+		want         []snapshotObject
+	}{
+		{"none", "", "", false, all},
+		// once any selection is made, history files, of a resource type, and objects not named as
+		// AWS Config names snapshots are skipped; since includes snapshots captured at it, until excludes them
+		{"since", "2024-01-02", "", false, []snapshotObject{a2, a3, b2}},
+		{"since time", "2024-01-02T06:00:00Z", "", false, []snapshotObject{a3, b2}},
+		{"until", "", "2024-01-02", false, []snapshotObject{a1, aEU}},
+		{"until time", "", "2024-01-03T00:00:00Z", false, []snapshotObject{a1, a2, aEU, b2}},
+		{"since and until", "2024-01-02", "2024-01-03", false, []snapshotObject{a2, b2}},
+		// the latest of each account and region
+		{"latest", "", "", true, []snapshotObject{a3, aEU, b2}},
+		{"latest until", "", "2024-01-03", true, []snapshotObject{a2, aEU, b2}},
+		{"latest since", "2024-01-01T06:00:00Z", "", true, []snapshotObject{a3, aEU, b2}},
+		// nothing captured in the window
+		{"empty", "2024-02-01", "2024-03-01", false, nil},
+		{"empty latest", "", "2023-12-31", true, nil},
+	}
+	for _, tt := range tests {
+		sel := snapshotSelection{latest: tt.latest}
+		sel.since, _ = parseSelectionTime(tt.since)
+		sel.until, _ = parseSelectionTime(tt.until)
+		if got := sel.apply(all); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: selected %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewSnapshotSelection(t *testing.T) {
+	defer func(s, u string, l bool) { since, until, latestPerRegion = s, u, l }(since, until, latestPerRegion)
+
+	tests := []struct {
+		since, until string
+		latest       bool
+		want         snapshotSelection
+		err          string
+	}{
+		{"", "", false, snapshotSelection{}, ""},
+		{"2024-01-01", "2024-02-01T12:00:00Z", true, snapshotSelection{since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			until: time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC), latest: true}, ""},
+		{"2024-02-01", "2024-01-01", false, snapshotSelection{}, "is not before -until"},
+		{"2024-01-01", "2024-01-01", false, snapshotSelection{}, "is not before -until"},
+		{"01/01/2024", "", false, snapshotSelection{}, "-since"},
+		{"", "tomorrow", false, snapshotSelection{}, "-until"},
+	}
+	for _, tt := range tests {
+		since, until, latestPerRegion = tt.since, tt.until, tt.latest
+		sel, err := newSnapshotSelection()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("-since %q -until %q: %v, want %q", tt.since, tt.until, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !sel.since.Equal(tt.want.since) || !sel.until.Equal(tt.want.until) || sel.latest != tt.want.latest {
+			t.Errorf("-since %q -until %q: %+v, %v, want %+v", tt.since, tt.until, sel, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/awsclients"
+	"os"
+	"strings"
+	"sync"
+)
+
+//secrets resolves secret references once each, with the output's AWS session if any is in AWS
+var secrets struct {
+	mu       sync.Mutex
+	resolved map[string]string
+	session  *awsclients.Session
+}
+
+//isSecretRef reports whether v is a reference to a secret rather than its value
+func isSecretRef(v string) bool {
+	for _, scheme := range []string{"env:", "file:", "secretsmanager://", "ssm://"} {
+		if strings.HasPrefix(v, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+//resolveSecret returns the secret ref refers to, or ref itself if it isn't a reference
+// env:NAME is an environment variable, file:/path a file, without a final newline,
+// secretsmanager://name-or-arn a Secrets Manager secret, one of whose json fields #key selects, and
+// ssm:///name an SSM parameter, decrypted if it's a SecureString. Secrets in AWS are read with the
+// output's credentials, in the region of an ARN or else the session's.
+func resolveSecret(ctx context.Context, ref string) (string, error) {
+	if !isSecretRef(ref) {
+		return ref, nil
+	}
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if v, ok := secrets.resolved[ref]; ok {
+		return v, nil
+	}
+
+	var v string
+	var err error
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		var ok bool
+		if v, ok = os.LookupEnv(name); !ok {
+			err = fmt.Errorf("$%s is not set", name)
+		}
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(ref, "file:"), "//")
+		var b []byte
+		if b, err = os.ReadFile(path); err == nil {
+			v = strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+		}
+	case strings.HasPrefix(ref, "secretsmanager://"):
+		id, key, _ := strings.Cut(strings.TrimPrefix(ref, "secretsmanager://"), "#")
+		v, err = awsSecret(ctx, id, func(c secretsReader) (string, error) { return c.GetSecretValue(ctx, id) })
+		if err == nil && key != "" {
+			v, err = secretField(v, key)
+		}
+	case strings.HasPrefix(ref, "ssm://"):
+		name := strings.TrimPrefix(ref, "ssm://")
+		v, err = awsSecret(ctx, name, func(c secretsReader) (string, error) { return c.GetParameter(ctx, name) })
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolveSecret: %s: %w", ref, err)
+	}
+
+	if secrets.resolved == nil {
+		secrets.resolved = make(map[string]string)
+	}
+	secrets.resolved[ref] = v
+	return v, nil
+}
+
+//secretsReader reads secrets in AWS, as awsconfig.SecretsClient does
+type secretsReader interface {
+	GetSecretValue(ctx context.Context, id string) (string, error)
+	GetParameter(ctx context.Context, name string) (string, error)
+}
+
+//awsSecret reads the secret id with get, in the region of id if it's an ARN
+func awsSecret(ctx context.Context, id string, get func(secretsReader) (string, error)) (string, error) {
+	if secrets.session == nil {
+		session, err := newAWSSession(ctx, awsOutput)
+		if err != nil {
+			return "", err
+		}
+		secrets.session = session
+	}
+	var region string
+	if strings.HasPrefix(id, "arn:") {
+		if parts := strings.SplitN(id, ":", 5); len(parts) == 5 {
+			region = parts[3]
+		}
+	}
+	client, err := secrets.session.SecretsClient(region)
+	if err != nil {
+		return "", err
+	}
+	return get(client)
+}
+
+//secretField returns the field key of the json object secret, as Secrets Manager stores key/value secrets
+func secretField(secret, key string) (string, error) {
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("#%s of a secret that isn't a json object", key)
+	}
+	v, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", key)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+//writerSecrets are the settings of each writer type that hold credentials, and so may be secret references
+var writerSecrets = map[string][]*string{
+	"opensearch": {&openSearch.URL},
+	"neo4j":      {&neo4j.URL},
+	"kafka":      {&kafkaConfig.SchemaRegistry},
+	"sentinel":   {&sentinel.ClientSecret},
+}
+
+//resolveWriterSecrets replaces the settings of the writer type that are secret references with their
+// secrets, so only the secrets of the writer used are read
+func resolveWriterSecrets() error {
+	for _, setting := range writerSecrets[writerKind] {
+		v, err := resolveSecret(context.Background(), *setting)
+		if err != nil {
+			return err
+		}
+		*setting = v
+	}
+	return nil
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/ledger"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//serverMetrics are the counters exposed on /metrics
+type serverMetrics struct {
+	filesProcessed atomic.Int64
+	filesFailed    atomic.Int64
+	items          atomic.Int64
+	itemBytes      atomic.Int64
+	inputBytes     atomic.Int64
+	reloads        atomic.Int64
+	busy           atomic.Int64
+}
+
+//settleTime is how long a file must go unmodified before it's considered completely written
+const settleTime = 2 * time.Second
+
+//server decodes files arriving in a directory, or objects named by queue messages, until it is stopped
+type server struct {
+	mu        sync.Mutex
+	spec      config_decoder.ItemTransformSpec
+	wFactory  config_decoder.WriterFactory
+	poolSpec  config_decoder.PoolSpec
+	ledger    ledger.Ledger
+	failed    map[string]string
+	unsettled bool
+	draining  atomic.Bool
+	metrics   serverMetrics
+	summary   *runSummary
+	stop      context.CancelFunc
+}
+
+//serve runs the server until SIGINT or SIGTERM, reloading the spec on SIGHUP
+// It decodes the files arriving in -watch-dir, or with -sqs-queue-url the objects named by the S3 event
+// notifications received from the queue.
+// A file being decoded when a signal arrives is always finished, so no items are dropped.
+// With -stop-on-error, the server also stops once a file fails.
+// The /healthz and /metrics endpoints are only served if withHTTP is set.
+// Every file decoded is added to summary.
+func serve(spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory, poolSpec config_decoder.PoolSpec, withHTTP bool, summary *runSummary) error {
+	source := watchDir
+	switch {
+	case watchDir != "" && queueURL != "":
+		return fmt.Errorf("serve: -watch-dir and -sqs-queue-url can't both be set")
+	case queueURL != "":
+		source = queueURL
+		if ledgerURI == "" {
+			return fmt.Errorf("serve: -ledger is required with -sqs-queue-url")
+		}
+	case watchDir == "":
+		return fmt.Errorf("serve: -watch-dir or -sqs-queue-url is required")
+	}
+
+	uri := ledgerURI
+	if uri == "" {
+		uri = filepath.Join(watchDir, ".decode_config_history_state.json")
+	}
+	l, err := ledger.Open(uri)
+	if err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	defer l.Close()
+
+	var q *sqsQueue
+	if queueURL != "" {
+		if q, err = newSQSQueue(context.Background(), queueURL); err != nil {
+			return fmt.Errorf("serve: %w", err)
+		}
+	}
+
+	s := &server{spec: spec, wFactory: wFactory, poolSpec: poolSpec, ledger: l, failed: make(map[string]string), summary: summary}
+
+	httpServer := &http.Server{Addr: listenAddr}
+	if withHTTP {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", s.handleHealthz)
+		mux.HandleFunc("/metrics", s.handleMetrics)
+		if servePprof {
+			handlePprof(mux)
+		}
+		httpServer.Handler = mux
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Errorf("serve: http server: %s", err)
+			}
+		}()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.stop = cancel
+
+	if withHTTP {
+		logger.Infof("serving %s, health and metrics on %s", source, listenAddr)
+	} else {
+		logger.Infof("watching %s", source)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if q != nil {
+			s.runQueue(ctx, q)
+		} else {
+			s.run(ctx)
+		}
+	}()
+
+	for {
+		select {
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				if err := s.reload(); err != nil {
+					logger.Errorf("reload failed, keeping current spec: %s", err)
+				} else {
+					logger.Info("reloaded spec")
+				}
+				continue
+			}
+
+			logger.Warnf("received signal %s, finishing in-flight work", sig)
+			s.draining.Store(true)
+			cancel()
+			<-done
+		case <-done:
+		}
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		return httpServer.Shutdown(shutdownCtx)
+	}
+}
+
+//run decodes new files in the watch directory one at a time until ctx is done
+// Files are picked up from file system notifications, with polling as a fallback.
+func (s *server) run(ctx context.Context) {
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(watchDir)
+		events, watchErrors = watcher.Events, watcher.Errors
+	}
+	if err != nil {
+		logger.Warnf("serve: can't watch %s, polling only: %s", watchDir, err)
+	}
+
+	ticker := time.NewTicker(pollEvery)
+	defer ticker.Stop()
+	settled := time.NewTicker(settleTime / 2)
+	defer settled.Stop()
+
+	var lastChange time.Time
+	s.decodePending(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			if isInputFile(ev.Name) && ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0 {
+				lastChange = time.Now()
+				s.unsettled = true
+			}
+		case err := <-watchErrors:
+			logger.Warnf("serve: watching %s: %s", watchDir, err)
+		case <-settled.C:
+			if s.unsettled && time.Since(lastChange) >= settleTime {
+				s.decodePending(ctx)
+			}
+		case <-ticker.C:
+			s.decodePending(ctx)
+		}
+	}
+}
+
+//decodePending decodes the files waiting in the watch directory
+func (s *server) decodePending(ctx context.Context) {
+	files, err := s.pending()
+	if err != nil {
+		logger.Errorf("serve: %s", err)
+	}
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		s.decode(s.fileInput(f))
+	}
+}
+
+//pending lists settled input files in the watch directory not yet decoded, oldest first
+// Files modified too recently to be completely written are left for a later pass.
+func (s *server) pending() ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(watchDir)
+	if err != nil {
+		return nil, fmt.Errorf("pending: %w", err)
+	}
+
+	s.unsettled = false
+	var files []os.FileInfo
+	for _, e := range entries {
+		if e.IsDir() || !isInputFile(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || s.seen(info) {
+			continue
+		}
+		if time.Since(info.ModTime()) < settleTime {
+			s.unsettled = true
+			continue
+		}
+		files = append(files, info)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	return files, nil
+}
+
+//fingerprint identifies the content of the file described by info
+// A file whose size or modification time changes is decoded again.
+func fingerprint(info os.FileInfo) string {
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+}
+
+//seen reports whether the file described by info has been decoded, or has failed to decode
+// Failures are remembered for the session so a bad file isn't retried on every pass,
+// and in the ledger so it isn't retried after a restart unless it changes.
+func (s *server) seen(info os.FileInfo) bool {
+	fp := fingerprint(info)
+	if s.failed[info.Name()] == fp {
+		return true
+	}
+
+	e, ok, err := s.ledger.Get(info.Name())
+	if err != nil {
+		logger.Errorf("serve: ledger: %s", err)
+		return false
+	}
+	if !ok || e.Fingerprint != fp {
+		return false
+	}
+	return e.State == ledger.Failed || s.resume(e)
+}
+
+//resume finishes the decoding of the input whose ledger entry is e, if it was interrupted, reporting
+// whether the input is done
+// A staged input's output is published; a started input's partial output is discarded, so it's
+// decoded again from scratch.
+func (s *server) resume(e ledger.Entry) bool {
+	switch e.State {
+	case ledger.Done:
+		return true
+	case ledger.Staged:
+		return s.recoverStaged(e)
+	case ledger.Started:
+		if e.Staged == "" {
+			logger.Warnf("serve: decoding of %s was interrupted at %s, decoding again; items may be duplicated",
+				e.Key, e.UpdatedAt.Format(time.RFC3339))
+			break
+		}
+		// none of its items were published, so they're decoded again from scratch
+		logger.Warnf("serve: decoding of %s was interrupted at %s, discarding its partial output and decoding again",
+			e.Key, e.UpdatedAt.Format(time.RFC3339))
+		_ = os.Remove(e.Staged)
+	}
+	return false
+}
+
+//recoverStaged publishes the staged output of an input interrupted after it was completely decoded,
+// reporting whether the input is done; if its output is lost, it's decoded again
+func (s *server) recoverStaged(e ledger.Entry) bool {
+	if _, err := os.Stat(e.Staged); err == nil {
+		if err := publish(e.Staged, e.Output, fileOpts); err != nil {
+			logger.Errorf("serve: publishing staged output of %s: %s, decoding again", e.Key, err)
+			_ = os.Remove(e.Staged)
+			return false
+		}
+		logger.Infof("serve: decoding of %s was interrupted once staged, published %s", e.Key, e.Output)
+	} else if _, err := os.Stat(e.Output); err != nil {
+		logger.Warnf("serve: staged output of %s is missing, decoding again", e.Key)
+		return false
+	}
+
+	e.State, e.Staged, e.UpdatedAt = ledger.Done, "", time.Now().UTC()
+	if err := s.ledger.Put(e); err != nil {
+		logger.Errorf("serve: recording %s: %s", e.Key, err)
+	}
+	return true
+}
+
+//record notes the decoding state of in in the ledger, with its output if that's staged
+func (s *server) record(in serveInput, state ledger.State, result runResult, out *output) error {
+	e := ledger.Entry{
+		Key:         in.key,
+		Fingerprint: in.fingerprint,
+		State:       state,
+		UpdatedAt:   time.Now().UTC(),
+		Items:       result.ItemCount,
+	}
+	if out != nil && out.tmp {
+		e.Staged, e.Output = out.file.Name(), out.path
+	}
+	if result.Err != nil {
+		e.Error = result.Err.Error()
+		s.failed[e.Key] = e.Fingerprint
+	}
+	if err := s.ledger.Put(e); err != nil {
+		logger.Errorf("serve: recording %s: %s", in.key, err)
+		return err
+	}
+	return nil
+}
+
+//isInputFile reports whether name looks like a snapshot or history file
+// Hidden files, such as the default state file, are never input.
+func isInputFile(name string) bool {
+	if strings.HasPrefix(filepath.Base(name), ".") {
+		return false
+	}
+	return strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz")
+}
+
+//serveInput is an input the server decodes, a file in the watch directory or an object a queue message
+// names
+// key is its ledger key and fingerprint identifies its content, so a changed input is decoded again.
+// name names it in results and logs, and output is the name its outputs are named after.
+type serveInput struct {
+	key, fingerprint string
+	name, output     string
+	decode           func(ctx context.Context, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory) runResult
+}
+
+//fileInput is the input of the file in the watch directory described by info
+func (s *server) fileInput(info os.FileInfo) serveInput {
+	name := filepath.Join(watchDir, info.Name())
+	return serveInput{key: info.Name(), fingerprint: fingerprint(info), name: name, output: name,
+		decode: func(ctx context.Context, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory) runResult {
+			return decodeFile(ctx, name, spec, wFactory, s.poolSpec, nil)
+		}}
+}
+
+//decode decodes one input with the current spec, recording it in the ledger, and reports whether it
+// was decoded
+// The input is marked started beforehand, so a restart can tell it was interrupted.
+// Decoding isn't tied to the server's context, so stopping the server lets it finish.
+// Output written to a temporary file is committed in two phases: once every item is written the
+// input is marked staged, and only then is the output renamed into place and the input marked done.
+// A crash at any point neither loses nor duplicates its items: on restart, a started input's partial
+// output is discarded and the input decoded again, and a staged input's output is just published.
+func (s *server) decode(in serveInput) bool {
+	s.mu.Lock()
+	spec := s.spec
+	s.mu.Unlock()
+
+	s.metrics.busy.Store(1)
+	defer s.metrics.busy.Store(0)
+
+	// the server runs until it's stopped, so -timeout bounds each input unless -file-timeout does
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if fileTimeout > 0 {
+		ctx, cancel = fileContext(context.Background())
+	} else {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer cancel()
+
+	var result runResult
+	wFactory, out, err := outputFactory(in.output, s.wFactory)
+	_ = s.record(in, ledger.Started, runResult{}, out)
+	if err != nil {
+		result = runResult{File: in.name, Err: err}
+	} else {
+		if out != nil {
+			out.staged = func() error {
+				return s.record(in, ledger.Staged, result, out)
+			}
+		}
+		result = in.decode(ctx, spec, wFactory)
+		finishOutput(out, &result)
+	}
+	if result.Err != nil {
+		_ = s.record(in, ledger.Failed, result, nil)
+	} else {
+		_ = s.record(in, ledger.Done, result, nil)
+	}
+
+	s.summary.add(result)
+	s.metrics.items.Add(int64(result.ItemCount))
+	s.metrics.itemBytes.Add(int64(result.ItemBytes))
+	s.metrics.inputBytes.Add(result.InputBytes)
+	if result.Err != nil {
+		s.metrics.filesFailed.Add(1)
+		logResultError(result)
+		if stopOnError {
+			logger.Warn("stopping at the first failed file (-stop-on-error)")
+			s.stop()
+		}
+		return false
+	}
+	s.metrics.filesProcessed.Add(1)
+	logger.Infof("read %d config items (%s) from %s in %s",
+		result.ItemCount, byteCountSI(result.ItemBytes), in.name, result.Duration)
+	return true
+}
+
+//reload reads the spec again from the -spec and -config files, as at startup; the new spec, and the
+// -config file's spec profiles, apply from the next file decoded
+// Nothing changes unless the new spec is valid and the -config file's other settings are as they were,
+// so a reload that fails keeps the spec in use.
+func (s *server) reload() error {
+	if configFile != "" {
+		if err := checkConfigFile(configFile); err != nil {
+			return err
+		}
+	}
+	spec, err := loadValidSpec(specFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.spec = spec
+	s.mu.Unlock()
+	s.metrics.reloads.Add(1)
+	return nil
+}
+
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+//handleMetrics writes the server's counters in the Prometheus text format
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metrics := []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"chd_files_processed_total", "counter", "Input files decoded successfully.", s.metrics.filesProcessed.Load()},
+		{"chd_files_failed_total", "counter", "Input files that failed to decode.", s.metrics.filesFailed.Load()},
+		{"chd_items_total", "counter", "Items written.", s.metrics.items.Load()},
+		{"chd_item_bytes_total", "counter", "Bytes of items written.", s.metrics.itemBytes.Load()},
+		{"chd_input_bytes_total", "counter", "Bytes of input read.", s.metrics.inputBytes.Load()},
+		{"chd_reloads_total", "counter", "Spec reloads.", s.metrics.reloads.Load()},
+		{"chd_busy", "gauge", "Whether a file is being decoded.", s.metrics.busy.Load()},
+	}
+	for _, m := range metrics {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+
+	// each writer pool worker's live counters, whose rates are its throughput
+	if s.poolSpec.Stats == nil {
+		return
+	}
+	workers := s.poolSpec.Stats.Snapshot()
+	workerMetrics := []struct {
+		name, kind, help string
+		value            func(config_decoder.WorkerStatus) int
+	}{
+		{"chd_worker_items_total", "counter", "Items written by the worker.",
+			func(ws config_decoder.WorkerStatus) int { return ws.ItemCount }},
+		{"chd_worker_errors_total", "counter", "Write errors of the worker.",
+			func(ws config_decoder.WorkerStatus) int { return ws.ErrorCount }},
+		{"chd_worker_busy", "gauge", "Whether the worker is writing an item.",
+			func(ws config_decoder.WorkerStatus) int {
+				if ws.Status == "busy" {
+					return 1
+				}
+				return 0
+			}},
+	}
+	for _, m := range workerMetrics {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, ws := range workers {
+			_, _ = fmt.Fprintf(w, "%s{worker=\"%d\"} %d\n", m.name, ws.WorkerNum, m.value(ws))
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/ledger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//serveDir sets up a watch directory holding input, whose items are written by the file writer to
+// {basename}.jsonl in an output directory it returns
+func serveDir(t *testing.T, input string, items ...map[string]any) (string, string) {
+	t.Helper()
+	w, k, o, d, fd := watchDir, writerKind, outputTemplate, timeout, fileTimeout
+	t.Cleanup(func() { watchDir, writerKind, outputTemplate, timeout, fileTimeout = w, k, o, d, fd })
+
+	outDir := t.TempDir()
+	watchDir = t.TempDir()
+	writerKind, outputTemplate = "file", filepath.Join(outDir, "{basename}.jsonl")
+	timeout, fileTimeout = time.Minute, 0
+
+	doc, err := json.Marshal(testSnapshot{FileVersion: "1.0", ConfigSnapshotID: input, Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(watchDir, input)
+	if err := os.WriteFile(path, doc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// settled, so it's pending
+	settled := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, settled, settled); err != nil {
+		t.Fatal(err)
+	}
+	return watchDir, outDir
+}
+
+//startServer starts a server on the watch directory with the ledger kept in it, as after a restart
+func startServer(t *testing.T) *server {
+	t.Helper()
+	l, err := ledger.OpenFile(filepath.Join(watchDir, ".state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return &server{spec: defaultSpec(), poolSpec: config_decoder.PoolSpec{Size: 2}, ledger: l, failed: make(map[string]string),
+		summary: newRunSummary(time.Now()), stop: func() {}}
+}
+
+//pendingNames lists the names of the files s would decode
+func pendingNames(t *testing.T, s *server) []string {
+	t.Helper()
+	files, err := s.pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+//outputIDs returns the resourceIds of the items in the output file path
+func outputIDs(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var item map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item["resourceId"].(string))
+	}
+	return ids
+}
+
+//interrupt decodes the file in the watch directory as s.decode does, up to the commit phase given,
+// then abandons it as a crash would
+func interrupt(t *testing.T, s *server, phase ledger.State) *output {
+	t.Helper()
+	return interruptInput(t, s, s.fileInput(pendingInfo(t, s)), phase)
+}
+
+//interruptInput decodes in as s.decode does, up to the commit phase given, then abandons it as a crash
+// would
+// At ledger.Started the items are written to the staged output but it isn't complete; at
+// ledger.Staged it's complete and recorded as staged, but not yet published.
+func interruptInput(t *testing.T, s *server, in serveInput, phase ledger.State) *output {
+	t.Helper()
+	wFactory, out, err := outputFactory(in.output, s.wFactory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.record(in, ledger.Started, runResult{}, out); err != nil {
+		t.Fatal(err)
+	}
+	result := in.decode(context.Background(), s.spec, wFactory)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if err := out.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if phase == ledger.Staged {
+		if err := out.finish(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.record(in, ledger.Staged, result, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+//pendingInfo returns the one file pending in the watch directory
+func pendingInfo(t *testing.T, s *server) os.FileInfo {
+	t.Helper()
+	files, err := s.pending()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("pending %d files, %v, want 1", len(files), err)
+	}
+	return files[0]
+}
+
+func testItems(n int) []map[string]any {
+	items := make([]map[string]any, n)
+	for i := range items {
+		items[i] = volume(fmt.Sprintf("vol-%02d", i), 8, 2)
+	}
+	return items
+}
+
+func TestServeDecode(t *testing.T) {
+	_, outDir := serveDir(t, "day2.json", testItems(5)...)
+	s := startServer(t)
+
+	s.decodePending(context.Background())
+	if ids := outputIDs(t, filepath.Join(outDir, "day2.jsonl")); len(ids) != 5 {
+		t.Fatalf("output %v, want the 5 items", ids)
+	}
+	e, ok, err := s.ledger.Get("day2.json")
+	if err != nil || !ok || e.State != ledger.Done || e.Items != 5 || e.Staged != "" {
+		t.Fatalf("ledger entry %+v, %v, %v, want done with 5 items", e, ok, err)
+	}
+
+	// a duplicate delivery of the same file is skipped, in this session and after a restart
+	if names := pendingNames(t, s); len(names) != 0 {
+		t.Errorf("pending %v once decoded, want none", names)
+	}
+	if names := pendingNames(t, startServer(t)); len(names) != 0 {
+		t.Errorf("pending %v after a restart, want none", names)
+	}
+
+	// a file whose content changes is decoded again
+	path := filepath.Join(watchDir, "day2.json")
+	changed := time.Now().Add(-30 * time.Second)
+	if err := os.Chtimes(path, changed, changed); err != nil {
+		t.Fatal(err)
+	}
+	if names := pendingNames(t, s); len(names) != 1 {
+		t.Errorf("pending %v once changed, want day2.json", names)
+	}
+}
+
+func TestServeDecodeFailed(t *testing.T) {
+	serveDir(t, "bad.json")
+	if err := os.WriteFile(filepath.Join(watchDir, "bad.json"), []byte(`{"configurationItems": [{`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	settled := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(filepath.Join(watchDir, "bad.json"), settled, settled); err != nil {
+		t.Fatal(err)
+	}
+	s := startServer(t)
+
+	s.decodePending(context.Background())
+	if e, _, _ := s.ledger.Get("bad.json"); e.State != ledger.Failed || e.Error == "" {
+		t.Fatalf("ledger entry %+v, want failed", e)
+	}
+	// a failed file isn't retried until it changes
+	if names := pendingNames(t, s); len(names) != 0 {
+		t.Errorf("pending %v once failed, want none", names)
+	}
+	if names := pendingNames(t, startServer(t)); len(names) != 0 {
+		t.Errorf("pending %v after a restart, want none", names)
+	}
+}
+
+//TestServeCrashStarted checks a file interrupted before its output was complete is decoded again
+// from scratch, its partial output discarded
+func TestServeCrashStarted(t *testing.T) {
+	_, outDir := serveDir(t, "day2.json", testItems(5)...)
+	out := interrupt(t, startServer(t), ledger.Started)
+	staged := out.file.Name()
+	if _, err := os.Stat(staged); err != nil {
+		t.Fatal(err)
+	}
+
+	s := startServer(t)
+	if names := pendingNames(t, s); len(names) != 1 {
+		t.Fatalf("pending %v after the crash, want day2.json", names)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("partial output %s: %v, want it discarded", staged, err)
+	}
+
+	s.decodePending(context.Background())
+	if ids := outputIDs(t, filepath.Join(outDir, "day2.jsonl")); len(ids) != 5 {
+		t.Errorf("output %v, want the 5 items once", ids)
+	}
+	if e, _, _ := s.ledger.Get("day2.json"); e.State != ledger.Done {
+		t.Errorf("ledger entry %+v, want done", e)
+	}
+}
+
+//TestServeCrashStaged checks a file interrupted once its output was staged is published at startup,
+// without being decoded again
+func TestServeCrashStaged(t *testing.T) {
+	_, outDir := serveDir(t, "day2.json", testItems(5)...)
+	out := interrupt(t, startServer(t), ledger.Staged)
+	if _, err := os.Stat(out.path); !os.IsNotExist(err) {
+		t.Fatalf("output %s: %v before publishing, want none", out.path, err)
+	}
+
+	s := startServer(t)
+	if names := pendingNames(t, s); len(names) != 0 {
+		t.Fatalf("pending %v after the crash, want none", names)
+	}
+	if ids := outputIDs(t, filepath.Join(outDir, "day2.jsonl")); len(ids) != 5 {
+		t.Errorf("output %v, want the 5 staged items", ids)
+	}
+	if _, err := os.Stat(out.file.Name()); !os.IsNotExist(err) {
+		t.Errorf("staged output %s: %v, want it published", out.file.Name(), err)
+	}
+	e, _, _ := s.ledger.Get("day2.json")
+	if e.State != ledger.Done || e.Staged != "" || e.Items != 5 {
+		t.Errorf("ledger entry %+v, want done with 5 items", e)
+	}
+}
+
+//TestServeCrashPublished checks a file interrupted once its output was published, but before it was
+// marked done, is marked done without decoding it again; and one whose staged output is lost is
+// decoded again
+func TestServeCrashPublished(t *testing.T) {
+	_, outDir := serveDir(t, "day2.json", testItems(5)...)
+	out := interrupt(t, startServer(t), ledger.Staged)
+	if err := publish(out.file.Name(), out.path, fileOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	s := startServer(t)
+	if names := pendingNames(t, s); len(names) != 0 {
+		t.Fatalf("pending %v after the crash, want none", names)
+	}
+	if e, _, _ := s.ledger.Get("day2.json"); e.State != ledger.Done {
+		t.Errorf("ledger entry %+v, want done", e)
+	}
+	if ids := outputIDs(t, filepath.Join(outDir, "day2.jsonl")); len(ids) != 5 {
+		t.Errorf("output %v, want the 5 items once", ids)
+	}
+
+	// the staged output and the published output are both lost
+	_, _ = serveDir(t, "day3.json", testItems(3)...)
+	out = interrupt(t, startServer(t), ledger.Staged)
+	if err := os.Remove(out.file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if names := pendingNames(t, startServer(t)); len(names) != 1 {
+		t.Errorf("pending %v with the staged output lost, want day3.json", names)
+	}
+}
+
+//writeFile writes content to the file name in dir, returning its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestServeReload(t *testing.T) {
+	defer func(sf, cf string, applied map[string]any) {
+		specFile, configFile, appliedSettings = sf, cf, applied
+		setSpecProfiles(nil)
+	}(specFile, configFile, appliedSettings)
+
+	serveDir(t, "day2.json")
+	dir := t.TempDir()
+	specFile, configFile = writeFile(t, dir, "spec.json", `{"ItemsField": "configurationItems"}`), ""
+	s := startServer(t)
+
+	// a valid spec file replaces the spec
+	writeFile(t, dir, "spec.json", `{"ItemsField": "items"}`)
+	if err := s.reload(); err != nil || s.spec.ItemsField != "items" || s.metrics.reloads.Load() != 1 {
+		t.Fatalf("reload: %v, ItemsField %q, want items", err, s.spec.ItemsField)
+	}
+	// an invalid one keeps it
+	for _, bad := range []string{`{"ItemsField": `, `{"UseNumber": true}`} {
+		writeFile(t, dir, "spec.json", bad)
+		if err := s.reload(); err == nil || s.spec.ItemsField != "items" || s.metrics.reloads.Load() != 1 {
+			t.Errorf("reload of %s: %v, ItemsField %q, want it failed keeping items", bad, err, s.spec.ItemsField)
+		}
+	}
+
+	// without a spec file, the -config file's spec and profiles are read again
+	specFile = ""
+	configFile = writeFile(t, dir, "config.yaml", "pool-size: 3\nspec:\n  ItemsField: records\n")
+	// as applied at startup
+	appliedSettings = map[string]any{"pool-size": 3}
+	if err := s.reload(); err != nil || s.spec.ItemsField != "records" {
+		t.Fatalf("reload: %v, ItemsField %q, want records", err, s.spec.ItemsField)
+	}
+	writeFile(t, dir, "config.yaml", "pool-size: 3\nspec:\n  ItemsField: records\n"+
+		"specs:\n  - name: cloudtrail\n    files: [\"*trail*\"]\n    spec:\n      ItemsField: Records\n")
+	if err := s.reload(); err != nil || len(specProfiles) != 1 {
+		t.Fatalf("reload: %v, %d spec profiles, want cloudtrail", err, len(specProfiles))
+	}
+
+	tests := []struct {
+		name, config, want string
+	}{
+		{"invalid spec", "pool-size: 3\nspec:\n  UseNumber: true\n", "ItemsField is required"},
+		{"invalid profile", "pool-size: 3\nspec:\n  ItemsField: items\nspecs:\n  - files: [\"*\"]\n", "needs a name"},
+		{"changed setting", "pool-size: 4\nspec:\n  ItemsField: items\n", "settings pool-size changed"},
+		{"new setting", "pool-size: 3\nstrict: true\nspec:\n  ItemsField: items\n", "settings strict changed"},
+		{"unreadable", "pool-size: [3\n", "config.yaml"},
+	}
+	for _, tt := range tests {
+		writeFile(t, dir, "config.yaml", tt.config)
+		err := s.reload()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: reload: %v, want %q", tt.name, err, tt.want)
+		}
+		if s.spec.ItemsField != "records" || len(specProfiles) != 1 {
+			t.Errorf("%s: ItemsField %q, %d spec profiles, want those in use kept", tt.name, s.spec.ItemsField, len(specProfiles))
+		}
+	}
+}
+
+//waitFor waits for the ledger of s to record the file key as in state
+func waitFor(t *testing.T, s *server, key string, state ledger.State) ledger.Entry {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if e, ok, _ := s.ledger.Get(key); ok && e.State == state {
+			return e
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s not %s", key, state)
+	return ledger.Entry{}
+}
+
+//TestServeWatch checks files arriving in the watch directory are decoded as they settle, with the spec
+// in use when they're picked up
+func TestServeWatch(t *testing.T) {
+	defer func(sf, cf string, every time.Duration) {
+		specFile, configFile, pollEvery = sf, cf, every
+	}(specFile, configFile, pollEvery)
+
+	_, outDir := serveDir(t, "day2.json", testItems(5)...)
+	specFile, configFile, pollEvery = writeFile(t, t.TempDir(), "spec.json", `{"ItemsField": "configurationItems"}`), "", 20*time.Millisecond
+	s := startServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// a file waiting at startup
+	if e := waitFor(t, s, "day2.json", ledger.Done); e.Items != 5 {
+		t.Errorf("day2.json: %d items, want 5", e.Items)
+	}
+
+	// one arriving once the spec is reloaded
+	writeFile(t, filepath.Dir(specFile), "spec.json", `{"ItemsField": "items"}`)
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := json.Marshal(map[string]any{"fileVersion": "1.0", "items": testItems(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, watchDir, "day3.json", string(doc))
+	settled := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, settled, settled); err != nil {
+		t.Fatal(err)
+	}
+	if e := waitFor(t, s, "day3.json", ledger.Done); e.Items != 3 {
+		t.Errorf("day3.json: %d items, want 3 with the reloaded spec", e.Items)
+	}
+	if ids := outputIDs(t, filepath.Join(outDir, "day3.jsonl")); len(ids) != 3 {
+		t.Errorf("output %v, want the 3 items", ids)
+	}
+
+	// one still being written is left until it settles
+	writeFile(t, watchDir, "day4.json", string(doc))
+	time.Sleep(5 * pollEvery)
+	if _, ok, _ := s.ledger.Get("day4.json"); ok {
+		t.Error("day4.json decoded before it settled")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"strings"
+)
+
+//skippedFields records the run's skipped top-level fields, created by the first spec loaded
+var skippedFields *config_decoder.SkippedFields
+
+//skippedOut is the -skipped-file output the raw values of skipped fields are written to, if set
+var skippedOut *output
+
+//skippedFieldsOf returns the run's SkippedFields, creating its -skipped-file output if need be
+func skippedFieldsOf() (*config_decoder.SkippedFields, error) {
+	if skippedFields != nil {
+		return skippedFields, nil
+	}
+	if skippedFile != "" {
+		out, err := createOutput(skippedFile, fileOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("skippedFieldsOf: %w", err)
+		}
+		skippedOut = out
+		skippedFields = config_decoder.NewSkippedFields(out)
+	} else {
+		skippedFields = config_decoder.NewSkippedFields(nil)
+	}
+	return skippedFields, nil
+}
+
+//skippedCounts returns the run's skipped fields, or nil if no spec was loaded
+func skippedCounts() map[string]config_decoder.SkippedField {
+	if skippedFields == nil {
+		return nil
+	}
+	return skippedFields.Fields()
+}
+
+//closeSkipped completes the -skipped-file output, if any, logging the run's skipped fields
+// Skipped values are kept even if decoding failed, as they were no less skipped.
+func closeSkipped() error {
+	if skippedFields == nil {
+		return nil
+	}
+	if skipped := skippedFields.String(); skipped != "" {
+		logger.Infof("top-level fields skipped: %s", skipped)
+	}
+	if skippedOut == nil {
+		return nil
+	}
+	if err := skippedFields.Err(); err != nil {
+		skippedOut.abort()
+		return &exitError{code: exitWrite, err: err}
+	}
+	if err := skippedOut.commit(); err != nil {
+		return &exitError{code: exitWrite, err: err}
+	}
+	return nil
+}
+
+//logUnmatchedFields warns of the spec's fields found in none of the run's documents, if any were decoded
+// They're quietly left out of items' metadata, though it's more likely a typo or the wrong kind of
+// document than fields to do without.
+func logUnmatchedFields() {
+	n := fieldMatches.Documents()
+	if n == 0 {
+		return
+	}
+	if unmatched := fieldMatches.Unmatched(); len(unmatched) > 0 {
+		logger.Warnf("spec fields not found in any of the %d documents decoded (a typo, or the wrong kind of document?): %s",
+			n, strings.Join(unmatched, ", "))
+	}
+}
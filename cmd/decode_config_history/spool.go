@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/spool"
+	"time"
+)
+
+//spoolDrain delivers the items decoded into -spool-dir with the configured writer, while decoding continues
+type spoolDrain struct {
+	s        *spool.Spool
+	ctx      context.Context
+	cancel   context.CancelFunc
+	chStatus chan config_decoder.WorkerStatus
+	chErrors chan error
+	start    time.Time
+}
+
+//startSpool opens -spool-dir and starts delivering its items with writers from wFactory
+// Items an earlier run left undelivered are delivered first. Delivery stops at -timeout or on a
+// signal, leaving what's undelivered for the next run.
+func startSpool(wFactory config_decoder.WriterFactory) (*spoolDrain, error) {
+	if writerKind == "file" {
+		return nil, fmt.Errorf("startSpool: -spool-dir doesn't apply to -writer file")
+	}
+	s, err := spool.Open(spoolDir, 0)
+	if err != nil {
+		return nil, &exitError{code: exitWrite, err: fmt.Errorf("startSpool: %w", err)}
+	}
+	if n := s.Pending(); n > 0 {
+		logger.Infof("resuming delivery of %s left in %s", byteCountSI(int(n)), spoolDir)
+	}
+
+	sd := &spoolDrain{s: s, start: time.Now()}
+	ctx, cancelRun := context.WithCancelCause(context.Background())
+	stopSignals := signalHandler(cancelRun)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	sd.ctx = ctx
+	sd.cancel = func() {
+		cancel()
+		stopSignals()
+		cancelRun(nil)
+	}
+	// delivery's workers aren't counted with decoding's
+	poolSpec := newPoolSpec()
+	poolSpec.Stats = nil
+	sd.chStatus, sd.chErrors = config_decoder.DrainSpool(sd.ctx, s, wFactory, poolSpec, spoolBatch)
+	return sd, nil
+}
+
+//factory returns the factory of writers spooling items for delivery
+func (sd *spoolDrain) factory() config_decoder.WriterFactory {
+	return config_decoder.SpoolWriterFactory(sd.s)
+}
+
+//finish closes the spool for writing, waits until its items are delivered, and closes it, returning
+// the result of delivery and the bytes left undelivered
+func (sd *spoolDrain) finish() (runResult, int64) {
+	defer sd.cancel()
+	result := runResult{File: spoolDir}
+	closeErr := sd.s.CloseWrites()
+	awaitResult(sd.ctx, sd.chStatus, sd.chErrors, poolSize, &result)
+	if result.Err == nil {
+		result.Err = closeErr
+	}
+	result.Duration = time.Since(sd.start)
+
+	pending := sd.s.Pending()
+	if pending == 0 && sd.ctx.Err() != nil && errors.Is(result.Err, sd.ctx.Err()) {
+		// stopped once everything was delivered
+		result.Err = nil
+	}
+	if err := sd.s.Close(); err != nil && result.Err == nil {
+		result.Err = err
+	}
+	if result.Err != nil {
+		logger.Errorf("error delivering items from %s: %s", spoolDir, result.Err)
+	}
+	errorCount := 0
+	for _, s := range result.Workers {
+		errorCount += s.ErrorCount
+	}
+	logger.Infof("sent %d items from %s, with %d write errors; %s left to deliver",
+		result.ItemCount, spoolDir, errorCount, byteCountSI(int(pending)))
+	return result, pending
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"strings"
+	"sync"
+	"time"
+)
+
+//sqsWait is how long, in seconds, a receive waits for a message to arrive, the longest SQS allows
+const sqsWait = 20
+
+//sqsVisibility is how long a message received is hidden from the queue's other consumers; it's extended
+// for as long as the objects it names are being decoded
+const sqsVisibility = 5 * time.Minute
+
+//sqsQueue is the queue of -sqs-queue-url, which S3 sends the event notifications of new snapshots to
+type sqsQueue struct {
+	client *sqs.Client
+	url    string
+	// s3 returns the client reading objects from a bucket in region
+	s3 func(region string) (*awsconfig.S3Client, error)
+}
+
+//newSQSQueue returns the queue at url, whose messages are received, and whose objects read, with the
+// input role's session
+func newSQSQueue(ctx context.Context, url string) (*sqsQueue, error) {
+	session, err := newAWSSession(ctx, awsInput)
+	if err != nil {
+		return nil, fmt.Errorf("newSQSQueue: %w", err)
+	}
+	client, err := session.SQSClient("")
+	if err != nil {
+		return nil, fmt.Errorf("newSQSQueue: %w", err)
+	}
+	return &sqsQueue{client: client, url: url, s3: session.S3Client}, nil
+}
+
+//runQueue decodes the objects named by the messages received from q, one at a time, until ctx is done
+func (s *server) runQueue(ctx context.Context, q *sqsQueue) {
+	for ctx.Err() == nil {
+		if err := s.receive(ctx, q); err != nil && ctx.Err() == nil {
+			logger.Errorf("serve: receiving from %s: %s", q.url, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollEvery):
+			}
+		}
+	}
+}
+
+//receive receives a batch of messages from q, long polling, and handles each in turn
+// Messages not yet handled once ctx is done are released for another consumer to receive.
+func (s *server) receive(ctx context.Context, q *sqsQueue) error {
+	resp, err := q.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: 10,
+		WaitTimeSeconds:     sqsWait,
+		VisibilityTimeout:   int32(sqsVisibility / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	for i, m := range resp.Messages {
+		if ctx.Err() != nil {
+			for _, m := range resp.Messages[i:] {
+				q.setVisibility(m, 0)
+			}
+			return nil
+		}
+		s.handle(q, m)
+	}
+	return nil
+}
+
+//handle decodes the objects created that the S3 event notification m names, deleting m once every one
+// is done
+// An object is done once its output is published and it's marked done in the ledger, keyed by its
+// s3:// name and fingerprinted by its ETag, so a message received again, after a crash or as SQS may
+// deliver it twice, finds its objects done, or staged and only to be published, and none is decoded
+// twice. A message that can't be read, or any of whose objects fails, is left on the queue, to be
+// received again once it's visible or moved to a dead-letter queue by the queue's redrive policy.
+func (s *server) handle(q *sqsQueue, m types.Message) {
+	id := aws.ToString(m.MessageId)
+	inputs, err := s.messageInputs(q, m)
+	if err != nil {
+		logger.Errorf("serve: message %s: %s", id, err)
+		return
+	}
+
+	stop := q.extend(m)
+	defer stop()
+	for _, in := range inputs {
+		if !s.done(in) && !s.decode(in) {
+			logger.Warnf("serve: leaving message %s on the queue, as %s failed", id, in.name)
+			return
+		}
+	}
+	if _, err := q.client.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+		QueueUrl: aws.String(q.url), ReceiptHandle: m.ReceiptHandle}); err != nil {
+		// its objects are done, so it's only deleted when it's received again
+		logger.Errorf("serve: deleting message %s: %s", id, err)
+	}
+}
+
+//messageInputs returns the input of each object whose creation the S3 event notification m reports
+// Other events, such as the test event S3 sends when notifications are set up, and objects that
+// aren't snapshot or history files, have none.
+func (s *server) messageInputs(q *sqsQueue, m types.Message) ([]serveInput, error) {
+	var event events.S3Event
+	if err := json.Unmarshal([]byte(aws.ToString(m.Body)), &event); err != nil {
+		return nil, fmt.Errorf("not an S3 event notification: %w", err)
+	}
+
+	var inputs []serveInput
+	for _, r := range event.Records {
+		if !strings.HasPrefix(r.EventName, "ObjectCreated:") || !isInputFile(r.S3.Object.URLDecodedKey) {
+			continue
+		}
+		client, err := q.s3(r.AWSRegion)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := parseSnapshotKey(r.S3.Object.URLDecodedKey)
+		so := snapshotObject{key.account, key.region, client, r.AWSRegion, r.S3.Bucket.Name, r.S3.Object.URLDecodedKey,
+			r.S3.Object.ETag}
+		inputs = append(inputs, s.objectInput(so))
+	}
+	return inputs, nil
+}
+
+//objectInput is the input of snapshot so, whose outputs are named after its key
+func (s *server) objectInput(so snapshotObject) serveInput {
+	return serveInput{key: so.name(), fingerprint: so.etag, name: so.name(), output: so.key,
+		decode: func(ctx context.Context, spec config_decoder.ItemTransformSpec, wFactory config_decoder.WriterFactory) runResult {
+			return decodeSnapshot(ctx, so, spec, wFactory, s.poolSpec)
+		}}
+}
+
+//done reports whether in has been decoded, publishing its output if it was interrupted once staged
+func (s *server) done(in serveInput) bool {
+	e, ok, err := s.ledger.Get(in.key)
+	if err != nil {
+		logger.Errorf("serve: ledger: %s", err)
+		return false
+	}
+	return ok && e.Fingerprint == in.fingerprint && s.resume(e)
+}
+
+//extend keeps m hidden from the queue's other consumers until the function it returns is called
+func (q *sqsQueue) extend(m types.Message) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(sqsVisibility / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				q.setVisibility(m, sqsVisibility)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+//setVisibility hides m from the queue's consumers for d from now, or shows it again if d is 0
+func (q *sqsQueue) setVisibility(m types.Message, d time.Duration) {
+	if _, err := q.client.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl: aws.String(q.url), ReceiptHandle: m.ReceiptHandle, VisibilityTimeout: int32(d / time.Second)}); err != nil {
+		logger.Warnf("serve: changing the visibility of message %s: %s", aws.ToString(m.MessageId), err)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/mfrasier/decode_json_stream/awsconfig"
+	"github.com/mfrasier/decode_json_stream/ledger"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeMessage is a message on a fakeSQS queue, in flight under receipt once it's received
+type fakeMessage struct {
+	id, body, receipt string
+	inFlight          bool
+}
+
+//fakeSQS is a queue served over SQS's JSON protocol, as much of it as the server uses
+// A message received is in flight, under a new receipt handle, until it's deleted, released, or expire
+// makes it visible again as the end of its visibility timeout would.
+type fakeSQS struct {
+	mu       sync.Mutex
+	messages []*fakeMessage
+	next     int
+}
+
+//send queues a message of body
+func (q *fakeSQS) send(body string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.next++
+	q.messages = append(q.messages, &fakeMessage{id: fmt.Sprintf("msg-%d", q.next), body: body})
+}
+
+//expire makes every message in flight visible again
+func (q *fakeSQS) expire() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range q.messages {
+		m.inFlight = false
+	}
+}
+
+//queued returns the ids of the messages not yet deleted
+func (q *fakeSQS) queued() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ids []string
+	for _, m := range q.messages {
+		ids = append(ids, m.id)
+	}
+	return ids
+}
+
+func (q *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ReceiptHandle     string
+		VisibilityTimeout int32
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	received := func(handle string) int {
+		for i, m := range q.messages {
+			if m.inFlight && m.receipt == handle {
+				return i
+			}
+		}
+		return -1
+	}
+	switch action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS."); action {
+	case "ReceiveMessage":
+		var messages []types.Message
+		for _, m := range q.messages {
+			if m.inFlight {
+				continue
+			}
+			q.next++
+			m.inFlight, m.receipt = true, fmt.Sprintf("%s-%d", m.id, q.next)
+			sum := md5.Sum([]byte(m.body))
+			messages = append(messages, types.Message{MessageId: aws.String(m.id), ReceiptHandle: aws.String(m.receipt),
+				Body: aws.String(m.body), MD5OfBody: aws.String(hex.EncodeToString(sum[:]))})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"Messages": messages})
+	case "DeleteMessage", "ChangeMessageVisibility":
+		i := received(req.ReceiptHandle)
+		if i < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"__type":"com.amazonaws.sqs#ReceiptHandleIsInvalid","message":"invalid receipt handle"}`)
+			return
+		}
+		if action == "DeleteMessage" {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+		} else if req.VisibilityTimeout == 0 {
+			q.messages[i].inFlight = false
+		}
+		_, _ = fmt.Fprint(w, `{}`)
+	default:
+		http.Error(w, "unsupported action "+action, http.StatusBadRequest)
+	}
+}
+
+//serveQueue sets up a fake queue, and a fake S3 whose objects are read in every region, as the queue of
+// a server whose ledger is kept in a directory of its own; items are written by the file writer to
+// {basename}.jsonl in an output directory it returns
+func serveQueue(t *testing.T) (*fakeSQS, *sqsQueue, *fakeS3, string) {
+	t.Helper()
+	w, k, o, d, fd := watchDir, writerKind, outputTemplate, timeout, fileTimeout
+	t.Cleanup(func() { watchDir, writerKind, outputTemplate, timeout, fileTimeout = w, k, o, d, fd })
+
+	outDir := t.TempDir()
+	watchDir = t.TempDir()
+	writerKind, outputTemplate = "file", filepath.Join(outDir, "{basename}.jsonl")
+	timeout, fileTimeout = time.Minute, 0
+
+	fq := &fakeSQS{}
+	srv := httptest.NewServer(fq)
+	t.Cleanup(srv.Close)
+	client := sqs.New(sqs.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("x", "y", ""),
+		BaseEndpoint: aws.String(srv.URL),
+		HTTPClient:   srv.Client(),
+	})
+
+	fs, s3srv := newFakeS3(t, 1)
+	s3Client := &awsconfig.S3Client{Client: s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("x", "y", ""),
+		BaseEndpoint: aws.String(s3srv.URL),
+		UsePathStyle: true,
+		HTTPClient:   s3srv.Client(),
+	})}
+	q := &sqsQueue{client: client, url: srv.URL + "/123456789012/snapshots",
+		s3: func(string) (*awsconfig.S3Client, error) { return s3Client, nil }}
+	return fq, q, fs, outDir
+}
+
+//s3Event is the S3 event notification of objects created under keys of bucket
+func s3Event(t *testing.T, bucket string, keys ...string) string {
+	t.Helper()
+	var records []map[string]any
+	for _, key := range keys {
+		records = append(records, map[string]any{
+			"eventSource": "aws:s3",
+			"awsRegion":   "us-east-1",
+			"eventName":   "ObjectCreated:Put",
+			"s3": map[string]any{
+				"bucket": map[string]any{"name": bucket},
+				"object": map[string]any{"key": url.QueryEscape(key), "eTag": fmt.Sprintf("%x", md5.Sum([]byte(key)))},
+			},
+		})
+	}
+	body, err := json.Marshal(map[string]any{"Records": records})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+//deliveredKey is the key AWS Config gives the snapshot it delivers on day of January 2024
+func deliveredKey(day int) string {
+	captured := fmt.Sprintf("202401%02dT010000Z", day)
+	return fmt.Sprintf("AWSLogs/123456789012/Config/us-east-1/2024/1/%d/ConfigSnapshot/", day) +
+		snapshotName("123456789012", "us-east-1", captured)
+}
+
+//outputOf is the output path of the object key
+func outputOf(outDir, key string) string {
+	return filepath.Join(outDir, strings.TrimSuffix(filepath.Base(key), ".json")+".jsonl")
+}
+
+//TestServeQueue checks the objects created that a message names are decoded before it's deleted, a
+// message naming an object that fails is left on the queue, and one delivered again once its objects are
+// done is deleted without decoding them again
+func TestServeQueue(t *testing.T) {
+	fq, q, fs, outDir := serveQueue(t)
+	day1, day2, missing := deliveredKey(1), deliveredKey(2), deliveredKey(3)
+	fs.put("config-bucket", day1, snapshotDoc(t, 3))
+	fs.put("config-bucket", day2, snapshotDoc(t, 4))
+
+	fq.send(s3Event(t, "config-bucket", day1, day2))
+	fq.send(`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"config-bucket"}`)
+	fq.send(s3Event(t, "config-bucket", missing))
+	fq.send(s3Event(t, "config-bucket", "AWSLogs/123456789012/Config/ConfigWritabilityCheckFile"))
+	fq.send("not an event")
+
+	s := startServer(t)
+	if err := s.receive(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fq.queued(), []string{"msg-3", "msg-5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %v after receiving, want %v, whose object failed and which isn't an event", got, want)
+	}
+	for key, n := range map[string]int{day1: 3, day2: 4} {
+		if ids := outputIDs(t, outputOf(outDir, key)); len(ids) != n {
+			t.Errorf("output of %s %v, want its %d items", key, ids, n)
+		}
+		if e, _, _ := s.ledger.Get("s3://config-bucket/" + key); e.State != ledger.Done {
+			t.Errorf("ledger entry %+v, want done", e)
+		}
+	}
+
+	// the first message is delivered again, as SQS may, and the failed one once it's visible
+	fq.send(s3Event(t, "config-bucket", day1, day2))
+	fq.expire()
+	if err := s.receive(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fq.queued(), []string{"msg-3", "msg-5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("queued %v after receiving again, want %v", got, want)
+	}
+	for key, n := range map[string]int{day1: 1, day2: 1, missing: 2} {
+		if got := fs.read("config-bucket", key); got != n {
+			t.Errorf("%s read %d times, want %d", key, got, n)
+		}
+	}
+	if ids := outputIDs(t, outputOf(outDir, day1)); len(ids) != 3 {
+		t.Errorf("output of %s %v, want its 3 items once", day1, ids)
+	}
+}
+
+//TestServeQueueCrash checks an object whose decoding was interrupted is finished when its message is
+// received again after a crash, its items published once, and only then is the message deleted
+func TestServeQueueCrash(t *testing.T) {
+	tests := []struct {
+		name  string
+		phase ledger.State
+		reads int
+	}{
+		// its partial output is discarded and it's decoded again
+		{"started", ledger.Started, 2},
+		// its staged output is published without decoding it again
+		{"staged", ledger.Staged, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fq, q, fs, outDir := serveQueue(t)
+			key := deliveredKey(1)
+			fs.put("config-bucket", key, snapshotDoc(t, 5))
+			fq.send(s3Event(t, "config-bucket", key))
+
+			// the message was received and its object decoded up to the phase when the server crashed
+			s := startServer(t)
+			inputs, err := s.messageInputs(q, types.Message{Body: aws.String(s3Event(t, "config-bucket", key))})
+			if err != nil || len(inputs) != 1 {
+				t.Fatalf("inputs %+v, %v, want the object", inputs, err)
+			}
+			interruptInput(t, s, inputs[0], tt.phase)
+
+			s = startServer(t)
+			if err := s.receive(context.Background(), q); err != nil {
+				t.Fatal(err)
+			}
+			if got := fq.queued(); len(got) != 0 {
+				t.Errorf("queued %v, want the message deleted", got)
+			}
+			if ids := outputIDs(t, outputOf(outDir, key)); len(ids) != 5 {
+				t.Errorf("output %v, want the 5 items once", ids)
+			}
+			if got := fs.read("config-bucket", key); got != tt.reads {
+				t.Errorf("read %d times, want %d", got, tt.reads)
+			}
+			if e, _, _ := s.ledger.Get("s3://config-bucket/" + key); e.State != ledger.Done || e.Items != 5 {
+				t.Errorf("ledger entry %+v, want done with 5 items", e)
+			}
+		})
+	}
+}
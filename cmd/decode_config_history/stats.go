@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//statGroup totals the items sharing a resource type, region or account
+type statGroup struct {
+	Name  string `json:"name"`
+	Items int    `json:"items"`
+	Bytes int64  `json:"bytes"`
+}
+
+//itemStats summarizes the items of a snapshot
+// One itemStats is shared by every worker's statsWriter.
+type itemStats struct {
+	mu         sync.Mutex
+	top        int
+	items      int
+	bytes      int64
+	byType     map[string]*statGroup
+	byRegion   map[string]*statGroup
+	byAccount  map[string]*statGroup
+	minCapture time.Time
+	maxCapture time.Time
+	largest    []config_decoder.ItemSize
+}
+
+func newItemStats(top int) *itemStats {
+	return &itemStats{
+		top:       top,
+		byType:    make(map[string]*statGroup),
+		byRegion:  make(map[string]*statGroup),
+		byAccount: make(map[string]*statGroup),
+	}
+}
+
+//add counts an item of size bytes
+func (st *itemStats) add(item map[string]any, size int64) {
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	arn, _ := item["ARN"].(string)
+	region, _ := item["awsRegion"].(string)
+	account, _ := item["awsAccountId"].(string)
+
+	var captured time.Time
+	if s, ok := item["configurationItemCaptureTime"].(string); ok {
+		captured, _ = time.Parse(time.RFC3339Nano, s)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.items++
+	st.bytes += size
+	addToGroup(st.byType, t, size)
+	addToGroup(st.byRegion, region, size)
+	addToGroup(st.byAccount, account, size)
+
+	if !captured.IsZero() {
+		if st.minCapture.IsZero() || captured.Before(st.minCapture) {
+			st.minCapture = captured
+		}
+		if captured.After(st.maxCapture) {
+			st.maxCapture = captured
+		}
+	}
+
+	// keep the top largest items, largest first
+	st.largest = config_decoder.KeepLargest(st.largest, st.top,
+		config_decoder.ItemSize{ResourceType: t, ResourceID: id, ARN: arn, Bytes: size})
+}
+
+func addToGroup(groups map[string]*statGroup, name string, size int64) {
+	g, ok := groups[name]
+	if !ok {
+		g = &statGroup{Name: name}
+		groups[name] = g
+	}
+	g.Items++
+	g.Bytes += size
+}
+
+//sortedGroups returns groups, most items first
+func sortedGroups(groups map[string]*statGroup) []statGroup {
+	s := make([]statGroup, 0, len(groups))
+	for _, g := range groups {
+		s = append(s, *g)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		if s[i].Items != s[j].Items {
+			return s[i].Items > s[j].Items
+		}
+		return s[i].Name < s[j].Name
+	})
+	return s
+}
+
+//statsWriter is an ItemWriter that summarizes items instead of writing them
+// Like FileWriter, each statsWriter reuses its own encode buffer to measure items.
+type statsWriter struct {
+	stats *itemStats
+	buf   *bytes.Buffer
+	enc   *json.Encoder
+}
+
+// Write implements ItemWriter for statsWriter
+func (sw statsWriter) Write(item map[string]interface{}) error {
+	sw.buf.Reset()
+	if err := sw.enc.Encode(item); err != nil {
+		return err
+	}
+	// don't count the encoder's newline
+	sw.stats.add(item, int64(sw.buf.Len()-1))
+	return nil
+}
+
+//statsReport is the json form of the summary
+type statsReport struct {
+	File       string                    `json:"file"`
+	Items      int                       `json:"items"`
+	Bytes      int64                     `json:"bytes"`
+	MinCapture *time.Time                `json:"minCaptureTime,omitempty"`
+	MaxCapture *time.Time                `json:"maxCaptureTime,omitempty"`
+	ByType     []statGroup               `json:"byResourceType"`
+	ByRegion   []statGroup               `json:"byRegion"`
+	ByAccount  []statGroup               `json:"byAccount"`
+	Largest    []config_decoder.ItemSize `json:"largest"`
+}
+
+func (st *itemStats) report(file string) statsReport {
+	r := statsReport{
+		File:      file,
+		Items:     st.items,
+		Bytes:     st.bytes,
+		ByType:    sortedGroups(st.byType),
+		ByRegion:  sortedGroups(st.byRegion),
+		ByAccount: sortedGroups(st.byAccount),
+		Largest:   st.largest,
+	}
+	if !st.minCapture.IsZero() {
+		r.MinCapture, r.MaxCapture = &st.minCapture, &st.maxCapture
+	}
+	return r
+}
+
+//writeTable prints the summary as aligned text tables
+func (r statsReport) writeTable(w io.Writer) {
+	groups := []struct {
+		title  string
+		groups []statGroup
+	}{
+		{"resource type", r.ByType},
+		{"region", r.ByRegion},
+		{"account", r.ByAccount},
+	}
+	for _, g := range groups {
+		_, _ = fmt.Fprintf(w, "%10s  %10s  %s\n", "items", "bytes", g.title)
+		for _, sg := range g.groups {
+			name := sg.Name
+			if name == "" {
+				name = "(none)"
+			}
+			_, _ = fmt.Fprintf(w, "%10d  %10s  %s\n", sg.Items, byteCountSI(int(sg.Bytes)), name)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+
+	if len(r.Largest) > 0 {
+		_, _ = fmt.Fprintf(w, "%10s  %s\n", "bytes", "largest items")
+		for _, l := range r.Largest {
+			_, _ = fmt.Fprintf(w, "%10s  %s %s\n", byteCountSI(int(l.Bytes)), l.ResourceType, l.ResourceID)
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+
+	if r.MinCapture != nil {
+		_, _ = fmt.Fprintf(w, "captured %s to %s\n", r.MinCapture.Format(time.RFC3339), r.MaxCapture.Format(time.RFC3339))
+	}
+	_, _ = fmt.Fprintf(w, "%d items (%s) in %s\n", r.Items, byteCountSI(int(r.Bytes)), r.File)
+}
+
+//runStats implements the stats subcommand, summarizing the items of the input without writing them
+// Items are counted and sized by resource type, region and account, as a table or json (-stats-format).
+func runStats(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	if statsFormat != "table" && statsFormat != "json" {
+		return fmt.Errorf("stats: unknown format %q", statsFormat)
+	}
+
+	stats := newItemStats(statsTop)
+	result, err := decodeInput(config_decoder.FactoryOf(func() config_decoder.ItemWriter {
+		buf := new(bytes.Buffer)
+		return statsWriter{stats: stats, buf: buf, enc: json.NewEncoder(buf)}
+	}))
+	if err != nil {
+		return err
+	}
+	if result.Err != nil {
+		return decodeFailed(fmt.Errorf("stats: %s: %w", result.File, result.Err))
+	}
+
+	r := stats.report(result.File)
+	if statsFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	r.writeTable(os.Stdout)
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/version"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Error categories counted in a runSummary
+const (
+	errInput     = "input"
+	errDecode    = "decode"
+	errWrite     = "write"
+	errTimeout   = "timeout"
+	errFileTime  = "fileTimeout"
+	errCanceled  = "canceled"
+	errTruncated = "truncated"
+)
+
+//Exit codes
+const (
+	exitOK        = 0
+	exitUsage     = 1
+	exitInput     = 2
+	exitDecode    = 3
+	exitWrite     = 4
+	exitCanceled  = 5
+	exitTruncated = 6
+)
+
+//categoryExitCodes are the exit codes for each error category
+var categoryExitCodes = map[string]int{
+	errInput:     exitInput,
+	errDecode:    exitDecode,
+	errWrite:     exitWrite,
+	errTimeout:   exitCanceled,
+	errFileTime:  exitCanceled,
+	errCanceled:  exitCanceled,
+	errTruncated: exitTruncated,
+}
+
+//errInputFailed marks errors opening or reading an input, as opposed to decoding it
+var errInputFailed = errors.New("input error")
+
+//errOutputFailed marks errors creating or completing an output file, which count as write errors
+var errOutputFailed = errors.New("output error")
+
+//exitError is an error that ends the program with an exit code other than exitUsage
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+//decodeFailed gives err, which ended decoding a file, the exit code of its category
+func decodeFailed(err error) error {
+	return &exitError{code: categoryExitCodes[errorCategory(err)], err: err}
+}
+
+//exitCode returns the exit code for the error a command returned
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	if err != nil {
+		return exitUsage
+	}
+	return exitOK
+}
+
+//workerSummary totals one worker's status over every file of a run
+type workerSummary struct {
+	Worker          int     `json:"worker"`
+	Items           int     `json:"items"`
+	Bytes           int     `json:"bytes"`
+	Errors          int     `json:"errors"`
+	BreakerTrips    int     `json:"breakerTrips"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+//resourceTypeSummary totals the items of one resourceType over every file of a run
+// InputBytes apportions each file's input, compressed as it was read, among its items by their
+// decoded size. DecodedBytes is their size in the uncompressed documents, and WrittenBytes their size
+// as encoded for the sink, before any compression.
+type resourceTypeSummary struct {
+	ResourceType string `json:"resourceType"`
+	Items        int    `json:"items"`
+	InputBytes   int64  `json:"inputBytes"`
+	DecodedBytes int64  `json:"decodedBytes"`
+	WrittenBytes int64  `json:"writtenBytes"`
+}
+
+//fileError is a file that failed to decode
+type fileError struct {
+	File      string            `json:"file"`
+	Error     string            `json:"error"`
+	Truncated *truncatedSummary `json:"truncated,omitempty"`
+}
+
+//truncatedSummary is how much of a truncated file was salvaged: its whole items, and the offset in the
+// decompressed file where the last of them ended
+type truncatedSummary struct {
+	SalvagedItems  int64 `json:"salvagedItems"`
+	LastGoodOffset int64 `json:"lastGoodOffset"`
+}
+
+//spoolSummary totals the delivery of items from -spool-dir
+type spoolSummary struct {
+	Dir          string `json:"dir"`
+	Items        int    `json:"items"`
+	Bytes        int    `json:"bytes"`
+	Errors       int    `json:"errors"`
+	BreakerTrips int    `json:"breakerTrips"`
+	PendingBytes int64  `json:"pendingBytes"`
+	Error        string `json:"error,omitempty"`
+}
+
+//timeoutSummary is the run's deadlines, -timeout and any -file-timeout, and what reached them
+type timeoutSummary struct {
+	RunSeconds    float64 `json:"runSeconds"`
+	FileSeconds   float64 `json:"fileSeconds,omitempty"`
+	RunTimedOut   bool    `json:"runTimedOut"`
+	FilesTimedOut int     `json:"filesTimedOut"`
+}
+
+//runSummary is the structured report of a run, printed on exit with -summary-format json
+type runSummary struct {
+	mu              sync.Mutex
+	Version         string                `json:"version"`
+	RunID           string                `json:"runId"`
+	Start           time.Time             `json:"start"`
+	DurationSeconds float64               `json:"durationSeconds"`
+	Files           int                   `json:"files"`
+	FilesFailed     int                   `json:"filesFailed"`
+	Items           int                   `json:"items"`
+	ItemBytes       int64                 `json:"itemBytes"`
+	InputBytes      int64                 `json:"inputBytes"`
+	Errors          map[string]int        `json:"errors"`
+	Timeouts        timeoutSummary        `json:"timeouts"`
+	ExitCode        int                   `json:"exitCode"`
+	FileErrors      []fileError           `json:"fileErrors,omitempty"`
+	Workers         []workerSummary       `json:"workers"`
+	ResourceTypes   []resourceTypeSummary `json:"resourceTypes,omitempty"`
+	Targets         []targetSummary       `json:"targets,omitempty"`
+	Spool           *spoolSummary         `json:"spool,omitempty"`
+	// Largest lists the -largest largest items of the run, largest first
+	Largest []config_decoder.ItemSize `json:"largest,omitempty"`
+	// MetadataCollisions counts the metadata fields named like an item's own, by name
+	MetadataCollisions map[string]int64 `json:"metadataCollisions,omitempty"`
+	// SkippedFields totals the top-level fields skipped, neither copied nor items, by name
+	SkippedFields map[string]config_decoder.SkippedField `json:"skippedFields,omitempty"`
+	// UnmatchedFields lists the spec's fields found in none of the documents decoded
+	UnmatchedFields []string `json:"unmatchedFields,omitempty"`
+	// SizeRoutes counts the items sent to each -route-size sink
+	SizeRoutes map[string]int64 `json:"sizeRoutes,omitempty"`
+	// ConfigurationCache counts the configurations found in the -config-cache, and those decoded
+	ConfigurationCache *configCacheSummary `json:"configurationCache,omitempty"`
+	// Findings counts the findings of the -rules, by rule
+	Findings      map[string]int64 `json:"findings,omitempty"`
+	workers       map[int]*workerSummary
+	resourceTypes map[string]*resourceTypeSummary
+	// inputs are the entries of the -run-manifest
+	inputs []runManifestInput
+}
+
+func newRunSummary(start time.Time) *runSummary {
+	return &runSummary{Version: version.Get().Version, RunID: runID, Start: start, Errors: make(map[string]int), workers: make(map[int]*workerSummary),
+		resourceTypes: make(map[string]*resourceTypeSummary),
+		Timeouts:      timeoutSummary{RunSeconds: timeout.Seconds(), FileSeconds: fileTimeout.Seconds()}}
+}
+
+//add totals the result of decoding one file
+func (rs *runSummary) add(result runResult) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.Files++
+	if runManifestFile != "" {
+		rs.inputs = append(rs.inputs, newRunManifestInput(result))
+	}
+	rs.Items += result.ItemCount
+	rs.ItemBytes += int64(result.ItemBytes)
+	rs.InputBytes += result.InputBytes
+
+	if result.Err != nil {
+		rs.FilesFailed++
+		// a write error stopping decoding is already among the workers' errors
+		switch category := errorCategory(result.Err); category {
+		case errWrite:
+		case errTimeout:
+			rs.Timeouts.RunTimedOut = true
+			rs.Errors[category]++
+		case errFileTime:
+			rs.Timeouts.FilesTimedOut++
+			rs.Errors[category]++
+		default:
+			rs.Errors[category]++
+		}
+		fe := fileError{File: result.File, Error: result.Err.Error()}
+		var te *config_decoder.TruncatedError
+		if errors.As(result.Err, &te) {
+			fe.Truncated = &truncatedSummary{SalvagedItems: te.Items, LastGoodOffset: te.Offset}
+		}
+		rs.FileErrors = append(rs.FileErrors, fe)
+	}
+
+	for _, s := range result.Workers {
+		w, ok := rs.workers[s.WorkerNum]
+		if !ok {
+			w = &workerSummary{Worker: s.WorkerNum}
+			rs.workers[s.WorkerNum] = w
+		}
+		w.Items += s.ItemCount
+		w.Bytes += s.ByteCount
+		w.Errors += s.ErrorCount
+		w.BreakerTrips += s.BreakerTrips
+		w.DurationSeconds += s.Duration.Seconds()
+		if s.ErrorCount > 0 {
+			rs.Errors[errWrite] += s.ErrorCount
+		}
+
+		for resourceType, b := range s.ResourceTypes {
+			t, ok := rs.resourceTypes[resourceType]
+			if !ok {
+				t = &resourceTypeSummary{ResourceType: resourceType}
+				rs.resourceTypes[resourceType] = t
+			}
+			t.Items += b.Items
+			t.DecodedBytes += b.DecodedBytes
+			t.WrittenBytes += b.WrittenBytes
+			if result.DocumentBytes > 0 {
+				t.InputBytes += b.DecodedBytes * result.InputBytes / result.DocumentBytes
+			}
+		}
+
+		for _, l := range s.Largest {
+			l.File = result.File
+			rs.Largest = config_decoder.KeepLargest(rs.Largest, largestItems, l)
+		}
+	}
+}
+
+//resourceTypeReport returns the totals of each resourceType, largest first
+func (rs *runSummary) resourceTypeReport() []resourceTypeSummary {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.resourceTypeReportLocked()
+}
+
+func (rs *runSummary) resourceTypeReportLocked() []resourceTypeSummary {
+	report := make([]resourceTypeSummary, 0, len(rs.resourceTypes))
+	for _, t := range rs.resourceTypes {
+		report = append(report, *t)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].DecodedBytes != report[j].DecodedBytes {
+			return report[i].DecodedBytes > report[j].DecodedBytes
+		}
+		return report[i].ResourceType < report[j].ResourceType
+	})
+	return report
+}
+
+//writeResourceTypes prints the totals of each resourceType as an aligned text table
+func writeResourceTypes(w io.Writer, types []resourceTypeSummary) {
+	_, _ = fmt.Fprintf(w, "%-40s  %8s  %10s  %10s  %10s\n", "resource type", "items", "input", "decoded", "written")
+	for _, t := range types {
+		resourceType := t.ResourceType
+		if resourceType == "" {
+			resourceType = "(none)"
+		}
+		_, _ = fmt.Fprintf(w, "%-40s  %8d  %10s  %10s  %10s\n", resourceType, t.Items, byteCountSI(int(t.InputBytes)),
+			byteCountSI(int(t.DecodedBytes)), byteCountSI(int(t.WrittenBytes)))
+	}
+}
+
+//writeLargest prints the largest items as an aligned text table, by ARN where they have one
+func writeLargest(w io.Writer, largest []config_decoder.ItemSize) {
+	_, _ = fmt.Fprintf(w, "%10s  %-40s  %s\n", "bytes", "resource type", "largest items")
+	for _, l := range largest {
+		id := l.ARN
+		if id == "" {
+			id = l.ResourceID
+		}
+		_, _ = fmt.Fprintf(w, "%10s  %-40s  %s (%s)\n", byteCountSI(int(l.Bytes)), l.ResourceType, id, l.File)
+	}
+}
+
+//addDelivery totals the delivery of items from -spool-dir, pending bytes being left undelivered
+// The run's workers are those spooling items, so delivery is totalled apart from them.
+func (rs *runSummary) addDelivery(result runResult, pending int64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	ss := &spoolSummary{Dir: result.File, Items: result.ItemCount, Bytes: result.ItemBytes, PendingBytes: pending}
+	for _, s := range result.Workers {
+		ss.Errors += s.ErrorCount
+		ss.BreakerTrips += s.BreakerTrips
+	}
+	rs.Errors[errWrite] += ss.Errors
+	if result.Err != nil {
+		ss.Error = result.Err.Error()
+		if category := errorCategory(result.Err); category != errWrite {
+			rs.Errors[category]++
+		}
+	}
+	rs.Spool = ss
+}
+
+//errorCategory classifies the error that ended decoding a file
+func errorCategory(err error) string {
+	switch {
+	// a file's timeout may stop it reading its input
+	case errors.Is(err, errFileTimeout):
+		return errFileTime
+	case errors.Is(err, errInputFailed):
+		return errInput
+	case errors.Is(err, config_decoder.ErrWriterFailed), errors.Is(err, errOutputFailed):
+		return errWrite
+	case errors.As(err, new(*config_decoder.TruncatedError)):
+		return errTruncated
+	case errors.Is(err, context.DeadlineExceeded):
+		return errTimeout
+	case errors.Is(err, context.Canceled):
+		return errCanceled
+	default:
+		return errDecode
+	}
+}
+
+//exitCode is the exit code for the run: the highest of the codes for each category of error seen
+func (rs *runSummary) exitCode() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.exitCodeLocked()
+}
+
+func (rs *runSummary) exitCodeLocked() int {
+	code := exitOK
+	for category, n := range rs.Errors {
+		if n > 0 && categoryExitCodes[category] > code {
+			code = categoryExitCodes[category]
+		}
+	}
+	return code
+}
+
+//err returns an exitError describing a run that saw errors, or nil
+func (rs *runSummary) err() error {
+	code := rs.exitCode()
+	if code == exitOK {
+		return nil
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return &exitError{code: code, err: fmt.Errorf("%d of %d files failed, %d write errors",
+		rs.FilesFailed, rs.Files, rs.Errors[errWrite])}
+}
+
+//writeJSON prints the summary as one line of json
+func (rs *runSummary) writeJSON(w io.Writer) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.DurationSeconds = time.Since(rs.Start).Seconds()
+	rs.ExitCode = rs.exitCodeLocked()
+	rs.Workers = rs.Workers[:0]
+	for _, ws := range rs.workers {
+		rs.Workers = append(rs.Workers, *ws)
+	}
+	sort.Slice(rs.Workers, func(i, j int) bool { return rs.Workers[i].Worker < rs.Workers[j].Worker })
+	rs.ResourceTypes = rs.resourceTypeReportLocked()
+	rs.MetadataCollisions = metadataCollisions.Counts()
+	rs.SkippedFields = skippedCounts()
+	if fieldMatches.Documents() > 0 {
+		rs.UnmatchedFields = fieldMatches.Unmatched()
+	}
+	rs.Findings = findings.counts()
+	rs.SizeRoutes = routedCounts()
+	rs.ConfigurationCache = configCacheCounts()
+
+	return json.NewEncoder(w).Encode(rs)
+}
+
+//logMetadataCollisions warns of the run's metadata fields that collided with items' own fields, if any
+func logMetadataCollisions() {
+	if counts := metadataCollisions.String(); counts != "" {
+		logger.Warnf("metadata fields collided with items' own (-metadata-collision %s): %s", envelope.OnCollision, counts)
+	}
+}
+
+//emitSummary prints the run summary in json to -summary-file, or stderr, if -summary-format json is set
+func emitSummary(rs *runSummary) error {
+	if summaryFormat != "json" {
+		return nil
+	}
+
+	var w io.Writer = os.Stderr
+	if summaryFile != "" {
+		f, err := os.Create(summaryFile)
+		if err != nil {
+			return fmt.Errorf("emitSummary: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := rs.writeJSON(w); err != nil {
+		return fmt.Errorf("emitSummary: %w", err)
+	}
+	return nil
+}
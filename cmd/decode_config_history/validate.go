@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/pgzip"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+	"os"
+	"strings"
+)
+
+//validationResult is the report printed by validate
+type validationResult struct {
+	File  string `json:"file"`
+	Valid bool   `json:"valid"`
+	*config_decoder.ValidationReport
+}
+
+//runValidate implements the validate subcommand, checking the integrity of the input
+// It streams -file once, checking gzip integrity, json well-formedness, the spec's top-level fields
+// and the shape of each item, then prints a json report of any problems and their byte offsets.
+// Offsets are into the uncompressed document.
+func runValidate(args []string) error {
+	if err := parseArgs(args); err != nil {
+		return err
+	}
+	spec, err := loadSpec(specFile)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("validate: %w", err)
+	}
+	defer in.Close()
+
+	var r io.Reader = bufio.NewReaderSize(in, readBuffer)
+	var gz *pgzip.Reader
+	if strings.HasSuffix(inputFile, ".gz") {
+		if gz, err = config_decoder.NewGzipReader(r); err != nil {
+			return fmt.Errorf("validate: %s: %w", inputFile, err)
+		}
+		r = gz
+	}
+	if r, _, err = utf8Input(r, nil); err != nil {
+		return fmt.Errorf("validate: %s: %w", inputFile, err)
+	}
+
+	report := config_decoder.Validate(r, spec, validateMax)
+
+	if gz != nil {
+		// read to the end so the gzip checksum is verified
+		if report.Valid() {
+			if _, err := io.Copy(io.Discard, gz); err != nil && err != io.EOF {
+				report.Add(config_decoder.Problem{Kind: config_decoder.ProblemGzip, Offset: -1, Item: -1, Message: err.Error()})
+			}
+		}
+		// errors reading the document came from decompressing it
+		for i, p := range report.Problems {
+			if p.Kind == config_decoder.ProblemRead {
+				report.Problems[i].Kind = config_decoder.ProblemGzip
+			}
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(validationResult{File: inputFile, Valid: report.Valid(), ValidationReport: report}); err != nil {
+		return err
+	}
+
+	if !report.Valid() {
+		return fmt.Errorf("validate: %s: %d problems in %d items", inputFile, report.ProblemCount, report.Items)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s: ok, %d items\n", inputFile, report.Items)
+	return nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/kafka"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//fileOptions are the file writer's options
+// A nil Gzip compresses output files whose name ends .gz. Append writes to the file in place rather
+// than replacing it; Exclusive fails an output file that already exists. Sync is when a file is
+// fsynced: commit, once it's complete, flush, also whenever a writer flushes it, or none. Perm is
+// the permissions of files created. Format is json, items each followed by Terminator, or protobuf,
+// delimited ConfigurationItem messages.
+type fileOptions struct {
+	Gzip       *bool
+	Append     bool
+	Exclusive  bool
+	Sync       string
+	Perm       os.FileMode
+	Terminator []byte
+	Format     string
+}
+
+var fileOpts = fileOptions{Sync: "commit", Perm: 0o644, Terminator: []byte{'\n'}, Format: "json"}
+
+//encoding returns how file writers encode items with the options
+func (o fileOptions) encoding() config_decoder.FileEncoding {
+	return config_decoder.FileEncoding{Termination: o.Terminator, Proto: o.Format == "protobuf", Canonical: canonical,
+		Verbatim: verbatim}
+}
+
+//gzipped reports whether output to path is gzipped
+func (o fileOptions) gzipped(path string) bool {
+	if o.Gzip == nil {
+		return strings.HasSuffix(path, ".gz")
+	}
+	return *o.Gzip
+}
+
+//perm returns the permissions of files created with the options, 0644 unless set
+func (o fileOptions) perm() os.FileMode {
+	if o.Perm == 0 {
+		return 0o644
+	}
+	return o.Perm
+}
+
+//syncs reports whether files are fsynced when they're flushed, and when they're committed
+func (o fileOptions) syncs() (onFlush, onCommit bool) {
+	return o.Sync == "flush", o.Sync != "none"
+}
+
+//optionList is a repeatable key=value flag
+type optionList []string
+
+func (l *optionList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *optionList) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("want key=value, not %q", s)
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+var writerOpts optionList
+
+//writerOptions are the options of each writer type, as set by -writer-opt or -writer URI query parameters
+// Most set the writer's own flags, which they override; every writer takes envelope, envelope-prefix
+// and envelope-collision, for the shape of the metadata in the items it writes.
+var writerOptions = map[string]map[string]func(string) error{
+	"null": {
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"file": {
+		"path":               setFlag("output"),
+		"gzip":               setFileGzip,
+		"append":             setFlag("file-append"),
+		"exclusive":          setFlag("file-exclusive"),
+		"sync":               setFlag("file-sync"),
+		"perm":               setFlag("file-perm"),
+		"terminator":         setFileTerminator,
+		"format":             setFlag("file-format"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"opensearch": {
+		"url":                setFlag("opensearch-url"),
+		"index":              setFlag("opensearch-index"),
+		"batch":              setFlag("opensearch-batch"),
+		"template":           setFlag("opensearch-template"),
+		"sigv4":              setFlag("opensearch-sigv4"),
+		"ca":                 setFlag("writer-ca"),
+		"cert":               setFlag("writer-cert"),
+		"key":                setFlag("writer-key"),
+		"insecure":           setFlag("writer-insecure"),
+		"proxy":              setFlag("writer-proxy"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"kafka": {
+		"brokers":            setFlag("kafka-brokers"),
+		"topic":              setFlag("kafka-topic"),
+		"format":             setFlag("kafka-format"),
+		"schema-registry":    setFlag("schema-registry"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"neo4j": {
+		"url":                setFlag("neo4j-url"),
+		"database":           setFlag("neo4j-database"),
+		"batch":              setFlag("neo4j-batch"),
+		"ca":                 setFlag("writer-ca"),
+		"cert":               setFlag("writer-cert"),
+		"key":                setFlag("writer-key"),
+		"insecure":           setFlag("writer-insecure"),
+		"proxy":              setFlag("writer-proxy"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"sentinel": {
+		"endpoint":           setFlag("sentinel-endpoint"),
+		"dcr":                setFlag("sentinel-dcr"),
+		"stream":             setFlag("sentinel-stream"),
+		"batch":              setFlag("sentinel-batch"),
+		"tenant":             setFlag("sentinel-tenant"),
+		"client-id":          setFlag("sentinel-client-id"),
+		"client-secret":      setFlag("sentinel-client-secret"),
+		"ca":                 setFlag("writer-ca"),
+		"cert":               setFlag("writer-cert"),
+		"key":                setFlag("writer-key"),
+		"insecure":           setFlag("writer-insecure"),
+		"proxy":              setFlag("writer-proxy"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"chronicle": {
+		"endpoint":           setFlag("chronicle-endpoint"),
+		"customer-id":        setFlag("chronicle-customer-id"),
+		"log-type":           setFlag("chronicle-log-type"),
+		"batch":              setFlag("chronicle-batch"),
+		"credentials":        setFlag("chronicle-credentials"),
+		"ca":                 setFlag("writer-ca"),
+		"cert":               setFlag("writer-cert"),
+		"key":                setFlag("writer-key"),
+		"insecure":           setFlag("writer-insecure"),
+		"proxy":              setFlag("writer-proxy"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+	"securitylake": {
+		"location":           setFlag("security-lake"),
+		"file-size":          setFlag("security-lake-file-size"),
+		"envelope":           setFlag("metadata-envelope"),
+		"envelope-prefix":    setFlag("metadata-prefix"),
+		"envelope-collision": setFlag("metadata-collision"),
+	},
+}
+
+func setFlag(name string) func(string) error {
+	return func(v string) error {
+		return flag.Set(name, v)
+	}
+}
+
+func setFileGzip(v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	fileOpts.Gzip = &b
+	return nil
+}
+
+//setFilePerm sets the permissions of output files from octal, e.g. 0640
+func setFilePerm(v string) error {
+	perm, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || perm == 0 || perm > 0o777 {
+		return fmt.Errorf("want octal permissions such as 0640, not %q", v)
+	}
+	fileOpts.Perm = os.FileMode(perm)
+	return nil
+}
+
+//setFileTerminator sets the item terminator from a Go-escaped string, e.g. \n or \r\n
+func setFileTerminator(v string) error {
+	s, err := strconv.Unquote(`"` + v + `"`)
+	if err != nil {
+		return fmt.Errorf("bad escape in %q", v)
+	}
+	fileOpts.Terminator = []byte(s)
+	return nil
+}
+
+//uriOptions returns the options given by the location in a -writer URI
+// file://path sets the path, opensearch://[user:pass@]host:port/index the url and index,
+// kafka://broker,broker/topic the brokers and topic, neo4j://[user:pass@]host:port/database the
+// url and database, sentinel://host/dcr/stream the endpoint, https://host, data collection rule and
+// stream, chronicle://host/customer-id the endpoint, https://host, and customer id, and
+// securitylake://bucket/prefix the location.
+func uriOptions(u *url.URL) url.Values {
+	opts := url.Values{}
+	switch u.Scheme {
+	case "file":
+		if u.Opaque != "" {
+			opts.Set("path", u.Opaque)
+		} else if u.Host+u.Path != "" {
+			opts.Set("path", u.Host+u.Path)
+		}
+	case "opensearch":
+		if u.Host != "" {
+			opts.Set("url", (&url.URL{Scheme: "http", User: u.User, Host: u.Host}).String())
+		}
+		if index := strings.Trim(u.Path, "/"); index != "" {
+			opts.Set("index", index)
+		}
+	case "kafka":
+		if u.Host != "" {
+			opts.Set("brokers", u.Host)
+		}
+		if topic := strings.Trim(u.Path, "/"); topic != "" {
+			opts.Set("topic", topic)
+		}
+	case "neo4j":
+		if u.Host != "" {
+			opts.Set("url", (&url.URL{Scheme: "http", User: u.User, Host: u.Host}).String())
+		}
+		if database := strings.Trim(u.Path, "/"); database != "" {
+			opts.Set("database", database)
+		}
+	case "sentinel":
+		if u.Host != "" {
+			opts.Set("endpoint", "https://"+u.Host)
+		}
+		dcr, stream, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+		if dcr != "" {
+			opts.Set("dcr", dcr)
+		}
+		if stream != "" {
+			opts.Set("stream", stream)
+		}
+	case "chronicle":
+		if u.Host != "" {
+			opts.Set("endpoint", "https://"+u.Host)
+		}
+		if customer := strings.Trim(u.Path, "/"); customer != "" {
+			opts.Set("customer-id", customer)
+		}
+	case "securitylake":
+		if u.Host != "" {
+			opts.Set("location", "s3://"+u.Host+u.Path)
+		}
+	}
+	return opts
+}
+
+//resolveWriter reduces a -writer URI to its writer type, applying its options and any -writer-opt
+// options, which override the URI's. -writer file:///out/items.ndjson.gz?append=true, for example,
+// is -writer file -output /out/items.ndjson.gz -writer-opt append=true.
+func resolveWriter() error {
+	opts := url.Values{}
+	if strings.Contains(writerKind, "://") || strings.HasPrefix(writerKind, "file:") {
+		u, err := url.Parse(writerKind)
+		if err != nil {
+			return fmt.Errorf("resolveWriter: %w", err)
+		}
+		opts = uriOptions(u)
+		for k, v := range u.Query() {
+			opts[k] = v
+		}
+		writerKind = u.Scheme
+	}
+	for _, kv := range writerOpts {
+		k, v, _ := strings.Cut(kv, "=")
+		opts.Set(k, v)
+	}
+
+	options, ok := writerOptions[writerKind]
+	if !ok {
+		return fmt.Errorf("resolveWriter: unknown writer type %q", writerKind)
+	}
+
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, k := range keys {
+		set, ok := options[k]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown %s writer option %q", writerKind, k))
+			continue
+		}
+		if err := set(opts.Get(k)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s writer option %s: %s", writerKind, k, err))
+		}
+	}
+	if writerKind != "file" && outputTemplate != "" {
+		problems = append(problems, "-output requires -writer file")
+	}
+	if writerKind == "file" && fileOpts.Format != "json" && fileOpts.Format != "protobuf" {
+		problems = append(problems, fmt.Sprintf("unknown -file-format %q", fileOpts.Format))
+	}
+	if writerKind == "file" && fileOpts.Sync != "commit" && fileOpts.Sync != "flush" && fileOpts.Sync != "none" {
+		problems = append(problems, fmt.Sprintf("unknown -file-sync %q", fileOpts.Sync))
+	}
+	if err := recordEnvelope.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	switch writerKind {
+	case "neo4j", "chronicle", "securitylake":
+		if recordEnvelope.Format != "" {
+			problems = append(problems, fmt.Sprintf("-record-envelope doesn't apply to -writer %s, which maps items itself", writerKind))
+		}
+	}
+	protobuf := (writerKind == "file" && fileOpts.Format == "protobuf") || (writerKind == "kafka" && kafkaConfig.Format == "protobuf")
+	if verbatim && canonical {
+		problems = append(problems, "-verbatim and -canonical write items in conflicting orders")
+	}
+	if verbatim && (writerKind != "file" || fileOpts.Format != "json") {
+		problems = append(problems, "-verbatim requires -writer file with json items")
+	}
+	if protobuf && recordEnvelope.Format != "" {
+		problems = append(problems, "-record-envelope doesn't apply to protobuf items, which are ConfigurationItem messages")
+	}
+	// destinations named by a template are checked before any item is routed
+	destination := map[string]string{"file": outputTemplate, "opensearch": openSearch.Index, "kafka": kafkaConfig.Topic}[writerKind]
+	if config_decoder.IsNameTemplate(destination) {
+		if _, err := config_decoder.ParseNameTemplate(destination); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("resolveWriter: %s", strings.Join(problems, "; "))
+	}
+	if canonical {
+		// one decoder and one writer write items in their order in the document
+		poolSize, decoders = 1, 1
+	}
+	return nil
+}
+
+//sinkWriterFactory returns the factory of writers writing json to sink, with the output of a file sink
+// and the sink's name
+// sink is a file, - for stdout, or a writer URI: file://path, opensearch://host:port/index or
+// kafka://broker,broker/topic; the rest of an OpenSearch writer's settings are the -opensearch flags'.
+// A file's writers share its output, which is to be committed once the run's done.
+func sinkWriterFactory(sink string) (config_decoder.WriterFactory, *output, string, error) {
+	name := sink
+	var out *output
+	var factory config_decoder.WriterFactory
+	var err error
+	scheme, _, _ := strings.Cut(sink, "://")
+	switch {
+	case sink == "-":
+		name = "stdout"
+		out, err = createOutput("", fileOptions{})
+	case !strings.Contains(sink, "://"):
+		out, err = createOutput(sink, fileOptions{})
+	case scheme == "file":
+		name = strings.TrimPrefix(sink, "file://")
+		out, err = createOutput(name, fileOptions{})
+	case scheme == "opensearch":
+		u, uErr := url.Parse(sink)
+		if uErr != nil {
+			return nil, nil, "", fmt.Errorf("sinkWriterFactory: %w", uErr)
+		}
+		opts := uriOptions(u)
+		cfg := openSearch
+		cfg.URL, cfg.Index = opts.Get("url"), opts.Get("index")
+		if err = applyWriterHTTP(&cfg.HTTPClient); err == nil {
+			err = signOpenSearch(&cfg)
+		}
+		if err == nil {
+			factory, err = config_decoder.OpenSearchWriterFactory(cfg)
+		}
+	case scheme == "kafka":
+		u, uErr := url.Parse(sink)
+		if uErr != nil {
+			return nil, nil, "", fmt.Errorf("sinkWriterFactory: %w", uErr)
+		}
+		opts := uriOptions(u)
+		factory, err = kafka.WriterFactory(kafka.Config{Brokers: strings.Split(opts.Get("brokers"), ","),
+			Topic: opts.Get("topic"), Format: "json"})
+	default:
+		return nil, nil, "", fmt.Errorf("sinkWriterFactory: unsupported writer %q", sink)
+	}
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("sinkWriterFactory: %w", err)
+	}
+
+	if out != nil {
+		factory = config_decoder.FileWriterFactory(out, []byte{'\n'})
+	}
+	return factory, out, name, nil
+}
@@ -0,0 +1,92 @@
+package config_decoder
+
+import (
+	"errors"
+	"fmt"
+)
+
+//BatchError is a batch of items a writer sent together that failed for good, so Items items weren't
+// written, though only the Write or Flush that sent them returned the error
+type BatchError struct {
+	Items int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch of %d items not written: %s", e.Items, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+//itemErrors returns the number of items err, returned by a Write or Flush, failed to write
+func itemErrors(err error) int {
+	var be *BatchError
+	if errors.As(err, &be) && be.Items > 0 {
+		return be.Items
+	}
+	return 1
+}
+
+//batch counts the items a writer sending them in batches has buffered to send together, and the bytes of
+// the batches sent
+// A send that fails, and should be retried, keeps the items for another attempt, less the one whose Write
+// sent them, which fails; one that fails for good drops them all, failing as a BatchError counting them.
+type batch struct {
+	size int
+	n    int
+	// written counts the bytes of the batches sent
+	written int64
+}
+
+//add counts an item the writer has buffered, sending the batch with flush if it's full; if that fails
+// to be retried, undo takes the item out of the writer's buffer again
+func (b *batch) add(flush func() error, undo func()) error {
+	b.n++
+	if b.n < b.size {
+		return nil
+	}
+	err := flush()
+	if err != nil && b.n > 0 {
+		// the caller still holds this item, so only the rest of the batch is kept
+		undo()
+		b.n--
+	}
+	return err
+}
+
+//makeRoom sends the batch with flush before an item that wouldn't fit in it is buffered; if that fails,
+// the item isn't buffered, so it's counted among those of a batch that failed for good
+func (b *batch) makeRoom(flush func() error) error {
+	err := flush()
+	var be *BatchError
+	if errors.As(err, &be) {
+		be.Items++
+	}
+	return err
+}
+
+//flush sends any buffered items with send, which returns the size of the request and whether a failure
+// should be retried; unless it should, reset empties the writer's buffer
+// A failure that isn't retried is a BatchError of all the items, unless send says how many failed with one.
+func (b *batch) flush(send func() (int64, bool, error), reset func()) error {
+	if b.n == 0 {
+		return nil
+	}
+	size, retry, err := send()
+	if retry {
+		return err
+	}
+	n := b.n
+	reset()
+	b.n = 0
+	var be *BatchError
+	if err != nil && !errors.As(err, &be) {
+		return &BatchError{Items: n, Err: err}
+	}
+	if err == nil {
+		b.written += size
+	}
+	return err
+}
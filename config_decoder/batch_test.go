@@ -0,0 +1,69 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+//rejectingWriter batches items as the batching writers do, failing its first retries sends as they
+// should be retried, and the rest for good
+type rejectingWriter struct {
+	batch   batch
+	ids     []string
+	retries int
+	sent    [][]string
+}
+
+func (rw *rejectingWriter) Write(item map[string]interface{}) error {
+	rw.ids = append(rw.ids, item["resourceId"].(string))
+	return rw.batch.add(rw.Flush, func() { rw.ids = rw.ids[:len(rw.ids)-1] })
+}
+
+func (rw *rejectingWriter) Flush() error {
+	return rw.batch.flush(func() (int64, bool, error) {
+		rw.sent = append(rw.sent, append([]string(nil), rw.ids...))
+		if rw.retries > 0 {
+			rw.retries--
+			return 0, true, errors.New("unavailable")
+		}
+		return 100, false, errors.New("rejected")
+	}, func() { rw.ids = rw.ids[:0] })
+}
+
+func TestBatchFailures(t *testing.T) {
+	rw := &rejectingWriter{batch: batch{size: 3}, retries: 1}
+	item := func(id string) map[string]any { return map[string]any{"resourceId": id} }
+	for _, id := range []string{"a", "b"} {
+		if err := rw.Write(item(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failure to retry fails just the item that sent the batch, keeping the rest
+	if err := rw.Write(item("c")); err == nil || itemErrors(err) != 1 {
+		t.Errorf("retried batch failed with %v, want an error of 1 item", err)
+	}
+	// one that fails for good fails them all
+	var be *BatchError
+	if err := rw.Write(item("d")); !errors.As(err, &be) || be.Items != 3 || itemErrors(err) != 3 {
+		t.Errorf("rejected batch failed with %v, want a BatchError of 3 items", err)
+	}
+	if len(rw.sent) != 2 || len(rw.sent[1]) != 3 || rw.sent[1][2] != "d" || rw.batch.n != 0 || len(rw.ids) != 0 {
+		t.Errorf("sent %v, %d left, want a b c, then a b d, and none left", rw.sent, rw.batch.n)
+	}
+	if rw.batch.written != 0 {
+		t.Errorf("%d bytes of rejected batches written", rw.batch.written)
+	}
+
+	// the pool counts each item of a rejected batch as an error
+	rw = &rejectingWriter{batch: batch{size: 3}}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(10, 10)),
+		FactoryOf(func() ItemWriter { return rw }), PoolSpec{Size: 1}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	if status := <-chStatus; status.ItemCount != 10 || status.ErrorCount != 10 {
+		t.Errorf("%d items, %d errors, want 10 of each", status.ItemCount, status.ErrorCount)
+	}
+}
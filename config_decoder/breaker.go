@@ -0,0 +1,319 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+//HealthChecker is optionally implemented by ItemWriters able to report the health of their sink
+// A nil result means the sink is ready to accept writes.
+type HealthChecker interface {
+	Healthy() error
+}
+
+//BreakerConfig configures the per-worker circuit breaker
+// Threshold is the number of consecutive write failures that opens the breaker; 0 disables it.
+// Cooldown is the minimum time between health probes while the breaker is open.
+// BufferSize is the number of items held in memory while the breaker is open.
+// SpillDir, if set, is where items beyond BufferSize are spilled to disk; otherwise the worker
+// stops receiving items until the sink recovers.
+// DrainTimeout bounds how long a worker waits for its sink to recover once its items end; the items
+// still held then are failed, and kept in the spill file if there's a SpillDir. 0 is three Cooldowns.
+//
+// Deprecated: importers should configure a breaker with pool.WithBreaker.
+type BreakerConfig struct {
+	Threshold    int
+	Cooldown     time.Duration
+	BufferSize   int
+	SpillDir     string
+	DrainTimeout time.Duration
+}
+
+//circuitBreaker guards an ItemWriter, holding items while its sink is failing
+type circuitBreaker struct {
+	cfg       BreakerConfig
+	worker    int
+	w         ItemWriter
+	failures  int
+	open      bool
+	lastProbe time.Time
+	buffer    []heldItem
+	spill     *os.File
+	spillEnc  *json.Encoder
+	spilled   int
+	trips     int
+	reuse     bool
+	// budget, if set, is freed of each item's size once the breaker is done with it
+	budget *memoryBudget
+	// clock times the cooldowns between probes
+	clock Clock
+}
+
+//heldItem is an item held in memory by an open breaker, and the size it's charged to the memory budget
+type heldItem struct {
+	item map[string]any
+	size int64
+}
+
+func newCircuitBreaker(cfg BreakerConfig, worker int, w ItemWriter, reuse bool, budget *memoryBudget, clock Clock) *circuitBreaker {
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = time.Second
+	}
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = 3 * cfg.Cooldown
+	}
+	return &circuitBreaker{cfg: cfg, worker: worker, w: w, reuse: reuse, budget: budget, clock: clock}
+}
+
+//writeItem writes item to the guarded writer, releasing it afterwards if items are reused
+func (cb *circuitBreaker) writeItem(item map[string]any) error {
+	err := cb.w.Write(item)
+	if err == nil && cb.reuse {
+		ReleaseItem(item)
+	}
+	return err
+}
+
+//held returns the number of items waiting for the sink to recover
+func (cb *circuitBreaker) held() int {
+	return len(cb.buffer) + cb.spilled
+}
+
+//receive receives the next item from ch; while the breaker is open and no item arrives, it probes the sink
+// at each cooldown, writing the items held if it's recovered, so they don't wait for another item
+// An open breaker stops receiving once ctx is done, as the items it holds may be all the emitter is
+// waiting for room in the memory budget for. A nil breaker only receives.
+func (cb *circuitBreaker) receive(ctx context.Context, ch <-chan map[string]any) (map[string]any, bool) {
+	for cb != nil && cb.open {
+		select {
+		case item, ok := <-ch:
+			return item, ok
+		case <-ctx.Done():
+			return nil, false
+		case <-time.After(cb.untilProbe()):
+			if cb.probe() {
+				_ = cb.release()
+			}
+		}
+	}
+	item, ok := <-ch
+	return item, ok
+}
+
+//write writes item, charged size bytes of the memory budget, through the breaker
+func (cb *circuitBreaker) write(ctx context.Context, item map[string]any, size int64) error {
+	if cb.open {
+		if !cb.probe() {
+			return cb.hold(ctx, item, size)
+		}
+		if err := cb.release(); err != nil {
+			return cb.hold(ctx, item, size)
+		}
+	}
+
+	err := cb.writeItem(item)
+	if err == nil {
+		cb.failures = 0
+		cb.budget.free(size)
+		return nil
+	}
+
+	cb.failures++
+	if cb.failures >= cb.cfg.Threshold {
+		cb.trip(err)
+		return cb.hold(ctx, item, size)
+	}
+	if cb.reuse {
+		ReleaseItem(item)
+	}
+	cb.budget.free(size)
+	return err
+}
+
+//trip opens the breaker
+func (cb *circuitBreaker) trip(cause error) {
+	cb.open = true
+	cb.trips++
+	cb.lastProbe = cb.clock.now()
+	logger.Warnf("writer (%d) circuit breaker open after %d failures: %s",
+		cb.worker, cb.failures, cause)
+}
+
+//untilProbe returns how long until the cooldown since the last probe has elapsed, by the breaker's clock
+func (cb *circuitBreaker) untilProbe() time.Duration {
+	return cb.lastProbe.Add(cb.cfg.Cooldown).Sub(cb.clock.now())
+}
+
+//probe reports whether the sink appears to have recovered
+// Writers not implementing HealthChecker are retried once the cooldown has elapsed.
+func (cb *circuitBreaker) probe() bool {
+	if cb.clock.now().Sub(cb.lastProbe) < cb.cfg.Cooldown {
+		return false
+	}
+	cb.lastProbe = cb.clock.now()
+
+	if hc, ok := cb.w.(HealthChecker); ok {
+		if err := hc.Healthy(); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+//hold keeps item until the sink recovers, in memory, on disk, or by waiting
+// An item held in memory stays charged to the memory budget until it's written.
+func (cb *circuitBreaker) hold(ctx context.Context, item map[string]any, size int64) error {
+	if cb.spilled == 0 && len(cb.buffer) < cb.cfg.BufferSize {
+		cb.buffer = append(cb.buffer, heldItem{item: item, size: size})
+		return nil
+	}
+
+	if cb.cfg.SpillDir != "" {
+		// once spilled, or dropped if it can't be, the item is out of memory
+		defer cb.budget.free(size)
+		return cb.spillItem(item)
+	}
+
+	// nowhere to put it; stop receiving until the sink recovers
+	if err := cb.wait(ctx); err != nil {
+		cb.budget.free(size)
+		return fmt.Errorf("circuitBreaker: item dropped: %w", err)
+	}
+	return cb.write(ctx, item, size)
+}
+
+//spillItem appends item to the worker's spill file
+func (cb *circuitBreaker) spillItem(item map[string]any) error {
+	if cb.spill == nil {
+		f, err := os.CreateTemp(cb.cfg.SpillDir, fmt.Sprintf("spill-worker-%d-*.ndjson", cb.worker))
+		if err != nil {
+			return fmt.Errorf("circuitBreaker: creating spill file: %w", err)
+		}
+		cb.spill = f
+		cb.spillEnc = json.NewEncoder(f)
+	}
+
+	// any source fields held to write it verbatim aren't spilled; it's written as it's encoded
+	delete(item, verbatimKey)
+	if err := cb.spillEnc.Encode(item); err != nil {
+		return fmt.Errorf("circuitBreaker: spilling item: %w", err)
+	}
+	cb.spilled++
+	if cb.reuse {
+		ReleaseItem(item)
+	}
+	return nil
+}
+
+//wait blocks until the breaker closes or ctx is done
+func (cb *circuitBreaker) wait(ctx context.Context) error {
+	for cb.open {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cb.untilProbe()):
+		}
+
+		if cb.probe() {
+			_ = cb.release()
+		}
+	}
+	return nil
+}
+
+//release writes held items, closing the breaker if all are written
+// On failure the breaker is re-opened and the unwritten items stay held.
+func (cb *circuitBreaker) release() error {
+	for len(cb.buffer) > 0 {
+		if err := cb.writeItem(cb.buffer[0].item); err != nil {
+			cb.trip(err)
+			return err
+		}
+		cb.budget.free(cb.buffer[0].size)
+		cb.buffer[0] = heldItem{}
+		cb.buffer = cb.buffer[1:]
+	}
+
+	if cb.spill != nil {
+		if err := cb.releaseSpill(); err != nil {
+			cb.trip(err)
+			return err
+		}
+	}
+
+	cb.open = false
+	cb.failures = 0
+	logger.Infof("writer (%d) circuit breaker closed", cb.worker)
+	return nil
+}
+
+//releaseSpill writes spilled items; on failure the remainder is moved to a new spill file
+func (cb *circuitBreaker) releaseSpill() error {
+	f := cb.spill
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cb.spill, cb.spillEnc, cb.spilled = nil, nil, 0
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("circuitBreaker: rewinding spill file: %w", err)
+	}
+
+	dec := json.NewDecoder(f)
+	// as a spool does, keeping the numbers as they were written
+	dec.UseNumber()
+	var failed error
+	for dec.More() {
+		var item map[string]any
+		if err := dec.Decode(&item); err != nil {
+			return fmt.Errorf("circuitBreaker: reading spill file %s: %w", f.Name(), err)
+		}
+
+		if failed == nil {
+			failed = cb.writeItem(item)
+			if failed == nil {
+				continue
+			}
+		}
+
+		if err := cb.spillItem(item); err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+//drain waits for held items to be written when the item stream ends
+// It gives up after the DrainTimeout, or once ctx ends, returning the number of items still held and
+// an error describing them; those in memory are spilled first, if there's a SpillDir, so they're all
+// kept in the spill file.
+func (cb *circuitBreaker) drain(ctx context.Context) (int, error) {
+	if !cb.open {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cb.cfg.DrainTimeout)
+	defer cancel()
+	if err := cb.wait(ctx); err != nil {
+		n := cb.held()
+		for _, h := range cb.buffer {
+			if cb.cfg.SpillDir != "" {
+				if spillErr := cb.spillItem(h.item); spillErr != nil {
+					logger.Errorf("writer (%d) %s", cb.worker, spillErr)
+				}
+			}
+			cb.budget.free(h.size)
+		}
+		cb.buffer = nil
+		if cb.spill != nil {
+			_ = cb.spill.Close()
+			return n, fmt.Errorf("circuitBreaker: %d items not written (%d left in %s): %w",
+				n, cb.spilled, cb.spill.Name(), err)
+		}
+		return n, fmt.Errorf("circuitBreaker: %d items not written: %w", n, err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,410 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//scriptedWriter fails the writes numbered in failures, from 1, and reports itself healthy unless
+// unhealthy is set
+type scriptedWriter struct {
+	calls     int
+	failures  map[int]bool
+	unhealthy bool
+	cw        CollectorWriter
+}
+
+func (sw *scriptedWriter) Write(item map[string]interface{}) error {
+	sw.calls++
+	if sw.failures[sw.calls] {
+		return errors.New("sink unavailable")
+	}
+	return sw.cw.Write(item)
+}
+
+func (sw *scriptedWriter) Healthy() error {
+	if sw.unhealthy {
+		return errors.New("sink unhealthy")
+	}
+	return nil
+}
+
+//failing returns failures for the writes numbered from..to
+func failing(from, to int) map[int]bool {
+	m := make(map[int]bool)
+	for n := from; n <= to; n++ {
+		m[n] = true
+	}
+	return m
+}
+
+//testClock tells a time the test advances, so a breaker's cooldowns elapse without waiting for them
+type testClock struct {
+	start   time.Time
+	elapsed atomic.Int64
+}
+
+func newTestClock() *testClock {
+	return &testClock{start: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) clock() Clock {
+	return func() time.Time {
+		return c.start.Add(time.Duration(c.elapsed.Load()))
+	}
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.elapsed.Add(int64(d))
+}
+
+func breakerItem(n int) map[string]any {
+	return map[string]any{"resourceId": fmt.Sprintf("r-%d", n)}
+}
+
+//writtenIDs returns the resourceIds of the items cw collected, in order
+func writtenIDs(cw *CollectorWriter) string {
+	var ids []string
+	for _, item := range cw.Items() {
+		ids = append(ids, item["resourceId"].(string))
+	}
+	return strings.Join(ids, " ")
+}
+
+//spillFiles returns the spill files in dir
+func spillFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "spill-worker-*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestBreakerTrip(t *testing.T) {
+	sw := &scriptedWriter{failures: failing(1, 100)}
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 2, Cooldown: time.Hour, BufferSize: 10}, 0, sw, false, nil, nil)
+	ctx := context.Background()
+
+	// a failure under the threshold fails the item
+	if err := cb.write(ctx, breakerItem(0), 0); err == nil || cb.open {
+		t.Fatalf("first failure: %v, open %t; want the error, closed", err, cb.open)
+	}
+	// the failure that reaches it opens the breaker, which holds the item
+	if err := cb.write(ctx, breakerItem(1), 0); err != nil || !cb.open || cb.trips != 1 {
+		t.Fatalf("second failure: %v, open %t, %d trips; want no error, open, 1 trip", err, cb.open, cb.trips)
+	}
+	// an open breaker holds items without writing them until the cooldown has elapsed
+	for n := 2; n < 5; n++ {
+		if err := cb.write(ctx, breakerItem(n), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sw.calls != 2 || cb.held() != 4 {
+		t.Errorf("%d writes and %d items held, want 2 and 4", sw.calls, cb.held())
+	}
+}
+
+func TestBreakerSpillAndRelease(t *testing.T) {
+	dir := t.TempDir()
+	sw := &scriptedWriter{failures: failing(1, 1)}
+	clock := newTestClock()
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 1, Cooldown: time.Hour, BufferSize: 2, SpillDir: dir}, 3, sw, false, nil, clock.clock())
+	ctx := context.Background()
+
+	for n := 0; n < 6; n++ {
+		if err := cb.write(ctx, breakerItem(n), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two items are held in memory, and those after them spilled
+	if len(cb.buffer) != 2 || cb.spilled != 4 {
+		t.Fatalf("%d items buffered and %d spilled, want 2 and 4", len(cb.buffer), cb.spilled)
+	}
+	files := spillFiles(t, dir)
+	if len(files) != 1 || !strings.Contains(files[0], "spill-worker-3-") {
+		t.Fatalf("spill files %v, want worker 3's", files)
+	}
+	if b, _ := os.ReadFile(files[0]); bytes.Count(b, []byte{'\n'}) != 4 {
+		t.Errorf("spill file holds %q, want 4 items", b)
+	}
+
+	// once the cooldown has elapsed the next write probes the sink, writing the held items first, in order
+	clock.advance(time.Hour)
+	if err := cb.write(ctx, breakerItem(6), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := writtenIDs(&sw.cw); got != "r-0 r-1 r-2 r-3 r-4 r-5 r-6" {
+		t.Errorf("wrote %s, want r-0 to r-6 in order", got)
+	}
+	if cb.open || cb.held() != 0 || len(spillFiles(t, dir)) != 0 {
+		t.Errorf("open %t, %d held, spill files %v; want closed, none held, and the spill file removed",
+			cb.open, cb.held(), spillFiles(t, dir))
+	}
+}
+
+//TestBreakerReleaseFails checks a sink failing again while held items are written re-opens the breaker,
+// keeping those not written, and in order
+func TestBreakerReleaseFails(t *testing.T) {
+	dir := t.TempDir()
+	// the 1st write trips the breaker; replaying, r-0 and r-1 are written and r-2 fails
+	sw := &scriptedWriter{failures: map[int]bool{1: true, 4: true}}
+	clock := newTestClock()
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 1, Cooldown: time.Hour, BufferSize: 1, SpillDir: dir}, 0, sw, false, nil, clock.clock())
+	ctx := context.Background()
+
+	for n := 0; n < 5; n++ {
+		if err := cb.write(ctx, breakerItem(n), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.advance(time.Hour)
+	if err := cb.write(ctx, breakerItem(5), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := writtenIDs(&sw.cw); got != "r-0 r-1" {
+		t.Fatalf("wrote %s, want r-0 r-1", got)
+	}
+	if !cb.open || cb.trips != 2 || cb.held() != 4 {
+		t.Fatalf("open %t, %d trips, %d held; want open, 2 trips, 4 held", cb.open, cb.trips, cb.held())
+	}
+
+	clock.advance(time.Hour)
+	if err := cb.write(ctx, breakerItem(6), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := writtenIDs(&sw.cw); got != "r-0 r-1 r-2 r-3 r-4 r-5 r-6" {
+		t.Errorf("wrote %s, want r-0 to r-6 in order", got)
+	}
+	if len(spillFiles(t, dir)) != 0 {
+		t.Errorf("spill files %v left", spillFiles(t, dir))
+	}
+}
+
+//TestBreakerProbe checks an open breaker probes a HealthChecker at most once per cooldown, releasing
+// held items only once it's healthy, and probes while no items arrive
+func TestBreakerProbe(t *testing.T) {
+	sw := &scriptedWriter{failures: failing(1, 1), unhealthy: true}
+	clock := newTestClock()
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 1, Cooldown: time.Hour, BufferSize: 10}, 0, sw, false, nil, clock.clock())
+	ctx := context.Background()
+
+	_ = cb.write(ctx, breakerItem(0), 0)
+	probed := cb.lastProbe
+	_ = cb.write(ctx, breakerItem(1), 0)
+	if cb.lastProbe != probed {
+		t.Error("probed within the cooldown")
+	}
+
+	// unhealthy, the sink isn't written to after the cooldown
+	clock.advance(time.Hour)
+	_ = cb.write(ctx, breakerItem(2), 0)
+	if sw.calls != 1 || !cb.open || cb.lastProbe == probed {
+		t.Fatalf("%d writes, open %t, probed %t; want 1 write and a failed probe", sw.calls, cb.open, cb.lastProbe != probed)
+	}
+
+	// healthy again, the held items are written at the next cooldown though no item arrives
+	sw.unhealthy = false
+	clock.advance(time.Hour)
+	ch := make(chan map[string]any)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(ch)
+	}()
+	start := time.Now()
+	if _, ok := cb.receive(context.Background(), ch); ok {
+		t.Fatal("received an item from a closed channel")
+	}
+	if cb.open {
+		t.Error("the breaker is still open")
+	}
+	if got := writtenIDs(&sw.cw); got != "r-0 r-1 r-2" {
+		t.Errorf("wrote %s, want r-0 r-1 r-2", got)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("receive returned before the channel closed")
+	}
+}
+
+//TestBreakerBudget checks items are freed from the memory budget once they're written, spilled or dropped
+func TestBreakerBudget(t *testing.T) {
+	budget := newMemoryBudget(1 << 20)
+	sw := &scriptedWriter{failures: failing(1, 2)}
+	clock := newTestClock()
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 2, Cooldown: time.Hour, BufferSize: 1, SpillDir: t.TempDir()}, 0, sw, false, budget, clock.clock())
+	ctx := context.Background()
+
+	for n := 0; n < 4; n++ {
+		_ = budget.acquire(context.Background(), 10)
+		_ = cb.write(ctx, breakerItem(n), 10)
+	}
+	// r-0 failed and r-2 and r-3 were spilled; r-1 is held in memory
+	if used := budget.usedBytes(); used != 10 {
+		t.Errorf("%d bytes charged, want the 10 of the item held", used)
+	}
+
+	clock.advance(time.Hour)
+	_ = budget.acquire(context.Background(), 10)
+	if err := cb.write(ctx, breakerItem(4), 10); err != nil {
+		t.Fatal(err)
+	}
+	if used := budget.usedBytes(); used != 0 {
+		t.Errorf("%d bytes charged, want 0", used)
+	}
+}
+
+func TestBreakerDrain(t *testing.T) {
+	dir := t.TempDir()
+	sw := &scriptedWriter{failures: failing(1, 1), unhealthy: true}
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 1, Cooldown: 10 * time.Millisecond, BufferSize: 1, SpillDir: dir}, 0, sw, false, nil, nil)
+	for n := 0; n < 3; n++ {
+		_ = cb.write(context.Background(), breakerItem(n), 0)
+	}
+
+	// the sink doesn't recover before the context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	held, err := cb.drain(ctx)
+	if held != 3 || err == nil || !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "3 items not written") ||
+		!strings.Contains(err.Error(), dir) {
+		t.Errorf("drain = %v, want 3 items not written, naming the spill file", err)
+	}
+	if len(spillFiles(t, dir)) != 1 {
+		t.Errorf("spill files %v, want the one kept", spillFiles(t, dir))
+	}
+}
+
+//TestBreakerDrainTimeout checks a sink that never recovers fails the items held after the DrainTimeout,
+// spilling those in memory if there's a SpillDir, rather than waiting as long as the context
+func TestBreakerDrainTimeout(t *testing.T) {
+	for _, spillDir := range []string{"", t.TempDir()} {
+		budget := newMemoryBudget(1 << 20)
+		sw := &scriptedWriter{failures: failing(1, 100), unhealthy: true}
+		cb := newCircuitBreaker(BreakerConfig{Threshold: 1, Cooldown: 10 * time.Millisecond, BufferSize: 2,
+			SpillDir: spillDir, DrainTimeout: 50 * time.Millisecond}, 0, sw, false, budget, nil)
+		n := 2
+		if spillDir != "" {
+			// the 3rd item is spilled
+			n = 3
+		}
+		for i := 0; i < n; i++ {
+			_ = budget.acquire(context.Background(), 10)
+			_ = cb.write(context.Background(), breakerItem(i), 10)
+		}
+
+		start := time.Now()
+		held, err := cb.drain(context.Background())
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("spill dir %q: drain took %s", spillDir, elapsed)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("spill dir %q: drain = %v, want the deadline exceeded", spillDir, err)
+		}
+		if used := budget.usedBytes(); used != 0 {
+			t.Errorf("spill dir %q: %d bytes charged, want 0", spillDir, used)
+		}
+		if spillDir == "" {
+			if held != 2 {
+				t.Errorf("%d items held, want 2", held)
+			}
+			continue
+		}
+
+		files := spillFiles(t, spillDir)
+		if held != 3 || len(files) != 1 {
+			t.Fatalf("%d items held in spill files %v, want 3 in one", held, files)
+		}
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+			t.Errorf("%d items spilled, want 3", lines)
+		}
+	}
+}
+
+func TestBreakerDrainRecovers(t *testing.T) {
+	sw := &scriptedWriter{failures: failing(1, 1)}
+	clock := newTestClock()
+	cb := newCircuitBreaker(BreakerConfig{Threshold: 1, Cooldown: time.Hour, BufferSize: 10}, 0, sw, false, nil, clock.clock())
+	for n := 0; n < 3; n++ {
+		_ = cb.write(context.Background(), breakerItem(n), 0)
+	}
+	clock.advance(time.Hour)
+	if _, err := cb.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := writtenIDs(&sw.cw); got != "r-0 r-1 r-2" {
+		t.Errorf("wrote %s, want r-0 r-1 r-2", got)
+	}
+}
+
+//TestBreakerPoolClock checks a pool's breakers time their cooldowns by its Clock, so an hour's cooldown
+// passes as quickly as the clock says it does
+func TestBreakerPoolClock(t *testing.T) {
+	sw := &scriptedWriter{failures: failing(2, 4)}
+	spec := benchSpec
+	spec.NoProvenance = true
+	clock := newTestClock()
+	// each reading of the clock is an hour on
+	poolSpec := PoolSpec{Size: 1, Breaker: BreakerConfig{Threshold: 1, Cooldown: time.Hour, BufferSize: 10},
+		Clock: func() time.Time {
+			clock.advance(time.Hour)
+			return clock.clock()()
+		}}
+
+	done := make(chan WorkerStatus)
+	go func() {
+		chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(8, 10)),
+			FactoryOf(func() ItemWriter { return sw }), poolSpec, spec)
+		for err := range chErrors {
+			t.Error(err)
+		}
+		done <- <-chStatus
+	}()
+	select {
+	case status := <-done:
+		if sw.cw.Count() != 8 || status.BreakerTrips < 1 {
+			t.Errorf("wrote %d items with %d trips, want 8 and at least 1", sw.cw.Count(), status.BreakerTrips)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the breaker waited out its cooldown by the system clock")
+	}
+}
+
+//TestBreakerPool checks a pool's workers hold items through an outage and write them all in order,
+// counting no errors
+func TestBreakerPool(t *testing.T) {
+	sw := &scriptedWriter{failures: failing(2, 4)}
+	spec := benchSpec
+	spec.NoProvenance = true
+	poolSpec := PoolSpec{Size: 1, Breaker: BreakerConfig{Threshold: 2, Cooldown: 5 * time.Millisecond, BufferSize: 2, SpillDir: t.TempDir()}}
+
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(8, 10)), FactoryOf(func() ItemWriter { return sw }), poolSpec, spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	status := <-chStatus
+
+	// the 2nd write fails under the threshold, failing its item
+	var want []string
+	for n := 0; n < 8; n++ {
+		if n != 1 {
+			want = append(want, fmt.Sprintf("r-%08d", n))
+		}
+	}
+	if got := writtenIDs(&sw.cw); got != strings.Join(want, " ") {
+		t.Errorf("wrote %s, want %s", got, strings.Join(want, " "))
+	}
+	if status.ErrorCount != 1 || status.BreakerTrips < 1 {
+		t.Errorf("%d errors and %d trips, want 1 and at least 1", status.ErrorCount, status.BreakerTrips)
+	}
+}
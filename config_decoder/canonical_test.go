@@ -0,0 +1,100 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendCanonicalJSON(t *testing.T) {
+	doc := `{"z": 1, "a": {"y": [1.5, 1e21, 0.0000001, 12345678901234, -0.0, true, null]},
+		"s": "<tag> & \"quoted\" \\ \n\t\u0001   héllo", "m": 1.0}`
+
+	// decoded as float64s, canonical json is encoding/json's without HTML escaping
+	var item map[string]any
+	if err := json.Unmarshal([]byte(doc), &item); err != nil {
+		t.Fatal(err)
+	}
+	got, err := AppendCanonicalJSON(nil, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	enc := json.NewEncoder(&want)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(item)
+	if string(got) != strings.TrimSuffix(want.String(), "\n") {
+		t.Errorf("got  %s\nwant %s", got, want.String())
+	}
+
+	// decoded as json.Numbers, as from a spool, it's the same
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	var numbers map[string]any
+	if err := dec.Decode(&numbers); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := AppendCanonicalJSON(nil, numbers); err != nil || string(again) != string(got) {
+		t.Errorf("json.Numbers give %s, %v", again, err)
+	}
+
+	// values of other types are written as their json decodes
+	b, err := AppendCanonicalJSON(nil, map[string]any{"metadata": map[string]string{"b": "2", "a": "1"}, "n": 7})
+	if err != nil || string(b) != `{"metadata":{"a":"1","b":"2"},"n":7}` {
+		t.Errorf("got %s, %v", b, err)
+	}
+	if _, err := AppendCanonicalJSON(nil, map[string]any{"c": make(chan int)}); err == nil {
+		t.Error("no error writing a channel")
+	}
+}
+
+func TestReproducibleMetadata(t *testing.T) {
+	spec := benchSpec
+	spec.RunID = "run-1"
+	spec.Reproducible = true
+	var outs [2]bytes.Buffer
+	for i := range outs {
+		f := EncodedFileWriterFactory(&outs[i], FileEncoding{Termination: []byte("\n"), Canonical: true})
+		chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(3, 10)), f, PoolSpec{Size: 1}, spec)
+		for err := range chErrors {
+			t.Fatal(err)
+		}
+		<-chStatus
+	}
+	if outs[0].String() != outs[1].String() {
+		t.Errorf("runs differ:\n%s%s", outs[0].String(), outs[1].String())
+	}
+	for _, volatile := range []string{"ingest_time", "decoder_version", "run_id"} {
+		if strings.Contains(outs[0].String(), volatile) {
+			t.Errorf("%s in reproducible output %s", volatile, outs[0].String())
+		}
+	}
+}
+
+func TestFixedClock(t *testing.T) {
+	delivered := time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	spec := benchSpec
+	spec.Reproducible = true
+	spec.Clock = FixedClock(delivered)
+	var outs [2]bytes.Buffer
+	for i := range outs {
+		f := EncodedFileWriterFactory(&outs[i], FileEncoding{Termination: []byte("\n"), Canonical: true})
+		chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(3, 10)), f,
+			PoolSpec{Size: 1, Clock: spec.Clock}, spec)
+		for err := range chErrors {
+			t.Fatal(err)
+		}
+		if status := <-chStatus; status.StartTime != "2024-05-01T12:00:00Z" || status.EndTime != status.StartTime {
+			t.Errorf("worker ran from %s to %s, want the clock's time", status.StartTime, status.EndTime)
+		}
+	}
+	if outs[0].String() != outs[1].String() {
+		t.Errorf("runs differ:\n%s%s", outs[0].String(), outs[1].String())
+	}
+	if n := strings.Count(outs[0].String(), `"ingest_time":"2024-05-01T12:00:00Z"`); n != 6 {
+		t.Errorf("%d ingest_times of the clock's time in %s, want 6", n, outs[0].String())
+	}
+}
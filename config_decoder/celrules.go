@@ -0,0 +1,149 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+)
+
+//ruleFile is a file of rules, yaml or json, as LoadRules reads it
+type ruleFile struct {
+	Rules []struct {
+		ID           string `yaml:"id"`
+		Severity     string `yaml:"severity"`
+		ResourceType string `yaml:"resourceType"`
+		Message      string `yaml:"message"`
+		Expression   string `yaml:"expression"`
+	} `yaml:"rules"`
+}
+
+//defaultSeverity is the severity of a rule loaded without one
+const defaultSeverity = "medium"
+
+//LoadRules reads rules from a yaml or json file, each a CEL expression over a configuration item
+// Each rule has an id, a severity, medium by default, and optionally the resourceType of the items it
+// checks, otherwise all of them. Its expression sees the item as item, e.g.
+// has(item.configuration.storageEncrypted) && !item.configuration.storageEncrypted, and finds a
+// problem if it's true, the rule's message describing it, or else by returning the message, or a
+// list of messages, one for each problem. An item whose expression fails, say for want of a has()
+// guard, has no findings, and the error is logged at DebugLevel.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules: %w", err)
+	}
+	var f ruleFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("LoadRules: %s: %w", path, err)
+	}
+
+	env, err := cel.NewEnv(cel.Variable("item", cel.MapType(cel.StringType, cel.DynType)))
+	if err == nil {
+		env, err = env.Extend(cel.CustomTypeAdapter(numberAdapter{env.CELTypeAdapter()}))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules: %w", err)
+	}
+	rules := make([]Rule, 0, len(f.Rules))
+	for i, r := range f.Rules {
+		if r.ID == "" || r.Expression == "" {
+			return nil, fmt.Errorf("LoadRules: %s: rule %d: id and expression are required", path, i)
+		}
+		rule := Rule{ID: r.ID, Severity: r.Severity, ResourceType: r.ResourceType}
+		if rule.Severity == "" {
+			rule.Severity = defaultSeverity
+		}
+		message := r.Message
+		if message == "" {
+			message = r.ID
+		}
+		if rule.Check, err = compileCheck(env, r.ID, r.Expression, message); err != nil {
+			return nil, fmt.Errorf("LoadRules: %s: rule %s: %w", path, r.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+//celResultTypes are the types a rule's expression may have
+var celResultTypes = []*cel.Type{cel.BoolType, cel.StringType, cel.ListType(cel.StringType), cel.ListType(cel.DynType), cel.DynType}
+
+//stringsType is the Go type a list of messages is converted to
+var stringsType = reflect.TypeOf([]string(nil))
+
+//compileCheck compiles a rule's expression to its Check; message describes the problem it finds if true
+func compileCheck(env *cel.Env, id, expression, message string) (func(map[string]any) []string, error) {
+	ast, iss := env.Compile(expression)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	valid := false
+	for _, t := range celResultTypes {
+		valid = valid || ast.OutputType().IsExactType(t)
+	}
+	if !valid {
+		return nil, fmt.Errorf("expression is a %s, not a bool, a string or a list of strings", ast.OutputType())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(item map[string]any) []string {
+		out, _, err := prg.Eval(map[string]any{"item": item})
+		if err != nil {
+			logger.Debugf("rule %s: %v %v: %s", id, item["resourceType"], item["resourceId"], err)
+			return nil
+		}
+		switch v := out.Value().(type) {
+		case bool:
+			if v {
+				return []string{message}
+			}
+			return nil
+		case string:
+			if v != "" {
+				return []string{v}
+			}
+			return nil
+		}
+		msgs, err := out.ConvertToNative(stringsType)
+		if err != nil {
+			logger.Debugf("rule %s: %v %v: %s", id, item["resourceType"], item["resourceId"], err)
+			return nil
+		}
+		return msgs.([]string)
+	}, nil
+}
+
+//numberAdapter adapts the json.Numbers of items decoded with ItemTransformSpec.UseNumber to CEL ints,
+// or doubles if they aren't integers, as it would a float64, rather than strings
+// Maps and lists are adapted by it too, so their elements' numbers are.
+type numberAdapter struct {
+	types.Adapter
+}
+
+// NativeToValue implements types.Adapter for numberAdapter
+func (a numberAdapter) NativeToValue(v any) ref.Val {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return types.Int(n)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return types.NewErr("%s", err)
+		}
+		return types.Double(f)
+	case map[string]any:
+		return types.NewStringInterfaceMap(a, v)
+	case []any:
+		return types.NewDynamicList(a, v)
+	}
+	return a.Adapter.NativeToValue(v)
+}
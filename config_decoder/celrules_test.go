@@ -0,0 +1,92 @@
+package config_decoder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//customRules are rules of each kind of expression LoadRules takes
+const customRules = `
+rules:
+  - id: volume-plain
+    severity: low
+    resourceType: AWS::EC2::Volume
+    message: volume isn't encrypted
+    expression: has(item.configuration.encrypted) && !item.configuration.encrypted
+  - id: open-ports
+    resourceType: AWS::EC2::SecurityGroup
+    expression: >
+      item.configuration.ipPermissions
+        .filter(p, has(p.ipv4Ranges) && p.ipv4Ranges.exists(r, r.cidrIp == "0.0.0.0/0"))
+        .map(p, "port " + string(int(p.fromPort)) + " is open")
+  - id: named
+    expression: 'item.resourceId.startsWith("public-") ? "named " + item.resourceId : ""'
+  - id: no-guard
+    expression: item.configuration.encrypted == false
+`
+
+//writeRules writes a rules file with content, returning its path
+func writeRules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := LoadRules(writeRules(t, customRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 || rules[1].Severity != defaultSeverity {
+		t.Fatalf("loaded %+v", rules)
+	}
+
+	spec := benchSpec
+	found := &CollectorWriter{}
+	spec.Rules = ItemRules{Rules: []string{"ebs-volume-unencrypted"}, Custom: rules, Findings: found}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), strings.NewReader(rulesSnapshot), NullWriterFactory(), PoolSpec{Size: 1}, spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+
+	var got []string
+	for _, f := range found.Items() {
+		got = append(got, f["resourceId"].(string)+" "+f["rule"].(string)+" "+f["severity"].(string)+": "+f["message"].(string))
+	}
+	sort.Strings(got)
+	// no-guard finds nothing where the configuration lacks the field, or there's no configuration
+	want := []string{
+		"public-acl named medium: named public-acl",
+		"public-policy named medium: named public-policy",
+		"sg-open open-ports medium: port 22 is open",
+		"vol-plain ebs-volume-unencrypted medium: volume is not encrypted",
+		"vol-plain no-guard medium: no-guard",
+		"vol-plain volume-plain low: volume isn't encrypted",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	for _, content := range []string{
+		"rules:\n  - id: no-expression\n",
+		"rules:\n  - id: bad-syntax\n    expression: item.(\n",
+		"rules:\n  - id: a-number\n    expression: 1 + 2\n",
+		"rules: [",
+	} {
+		if _, err := LoadRules(writeRules(t, content)); err == nil {
+			t.Errorf("%q: no error", content)
+		}
+	}
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file: no error")
+	}
+}
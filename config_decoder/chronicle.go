@@ -0,0 +1,367 @@
+package config_decoder
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//chronicleMaxBody is the most the Chronicle ingestion API accepts in one request
+const chronicleMaxBody = 1_000_000
+
+//chronicleScope is the OAuth scope of the Chronicle ingestion API
+const chronicleScope = "https://www.googleapis.com/auth/malachite-ingestion"
+
+//ChronicleConfig configures ChronicleWriters, sending items to Google Chronicle as UDM entities with
+// the ingestion API's entities:batchCreate
+// Endpoint is the regional ingestion endpoint, https://malachiteingestion-pa.googleapis.com by default.
+// CustomerID is the Chronicle instance's customer id, and LogType the log type entities are ingested
+// as, AWS_CONFIG by default.
+// Credentials is the json key of the service account the ingestion API authenticates.
+// BatchSize is the most items sent in each request, which is kept under the API's 1 MB limit too.
+// HTTPClient, if set, sends the requests; by default requests time out after a minute.
+type ChronicleConfig struct {
+	Endpoint    string
+	CustomerID  string
+	LogType     string
+	Credentials []byte
+	BatchSize   int
+	HTTPClient  *http.Client
+}
+
+//serviceAccountKey is the part of a Google service account's json key used to get access tokens
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+//chronicleClient is shared by the ChronicleWriters of one factory, which share its access token
+type chronicleClient struct {
+	cfg      ChronicleConfig
+	http     *http.Client
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+	// prefix opens the body of each request, before its entities
+	prefix []byte
+	mu     sync.Mutex
+	// access is refreshed a minute before it expires
+	access  string
+	expires time.Time
+}
+
+//ChronicleWriter is an ItemWriter sending items to Chronicle as UDM entities in batches
+// Each item is a RESOURCE entity: the resource's name, ARN and type, its account, availability zone and
+// tags as attributes, and its region as location, valid from its capture time.
+// Items are buffered until BatchSize are waiting, or another wouldn't fit in a request, so Flush must
+// be called after the last Write; the writer pool does so when its items channel is closed.
+// A failed request fails the Write that sent it; the rest of the batch is kept and sent with the next
+// batch. A batch the API rejects as invalid is reported as a BatchError, not retried.
+type ChronicleWriter struct {
+	client *chronicleClient
+	buf    *bytes.Buffer
+	batch  batch
+}
+
+//ChronicleWriterFactory creates ChronicleWriter objects sharing one connection pool and access token
+func ChronicleWriterFactory(cfg ChronicleConfig) (WriterFactory, error) {
+	if cfg.CustomerID == "" || len(cfg.Credentials) == 0 {
+		return nil, fmt.Errorf("ChronicleWriterFactory: CustomerID and Credentials are required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://malachiteingestion-pa.googleapis.com"
+	}
+	if cfg.LogType == "" {
+		cfg.LogType = "AWS_CONFIG"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	var sa serviceAccountKey
+	if err := json.Unmarshal(cfg.Credentials, &sa); err != nil {
+		return nil, fmt.Errorf("ChronicleWriterFactory: credentials: %w", err)
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if sa.ClientEmail == "" || block == nil {
+		return nil, fmt.Errorf("ChronicleWriterFactory: credentials aren't a service account key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ChronicleWriterFactory: private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("ChronicleWriterFactory: private key isn't RSA")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	customerID, err := json.Marshal(cfg.CustomerID)
+	if err != nil {
+		return nil, fmt.Errorf("ChronicleWriterFactory: %w", err)
+	}
+	logType, err := json.Marshal(cfg.LogType)
+	if err != nil {
+		return nil, fmt.Errorf("ChronicleWriterFactory: %w", err)
+	}
+
+	client := &chronicleClient{cfg: cfg, http: cfg.HTTPClient, email: sa.ClientEmail, key: key, tokenURI: sa.TokenURI,
+		prefix: []byte(fmt.Sprintf(`{"customer_id":%s,"log_type":%s,"entities":[`, customerID, logType))}
+	if client.http == nil {
+		client.http = &http.Client{Timeout: time.Minute}
+	}
+	return FactoryOf(func() ItemWriter {
+		return &ChronicleWriter{client: client, buf: new(bytes.Buffer), batch: batch{size: cfg.BatchSize}}
+	}), nil
+}
+
+//udmResourceTypes are the UDM resource types of the AWS resource types that have one
+var udmResourceTypes = map[string]string{
+	"AWS::EC2::Instance":      "VIRTUAL_MACHINE",
+	"AWS::S3::Bucket":         "STORAGE_BUCKET",
+	"AWS::RDS::DBInstance":    "DATABASE",
+	"AWS::DynamoDB::Table":    "TABLE",
+	"AWS::EC2::VPC":           "VPC_NETWORK",
+	"AWS::EC2::SecurityGroup": "FIREWALL_RULE",
+	"AWS::IAM::Policy":        "ACCESS_POLICY",
+	"AWS::Lambda::Function":   "FUNCTION",
+}
+
+//udmEntity maps a configuration item to a UDM entity
+func udmEntity(item map[string]any) (map[string]any, error) {
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	captured, _ := item["configurationItemCaptureTime"].(string)
+	if t == "" || id == "" || captured == "" {
+		return nil, fmt.Errorf("item has no resourceType, resourceId and configurationItemCaptureTime")
+	}
+
+	objectID := id
+	for _, k := range []string{"ARN", "arn"} {
+		if arn, _ := item[k].(string); arn != "" {
+			objectID = arn
+		}
+	}
+	resourceType := udmResourceTypes[t]
+	if resourceType == "" {
+		resourceType = "UNSPECIFIED"
+	}
+
+	cloud := map[string]any{"environment": "AMAZON_WEB_SERVICES"}
+	if account, _ := item["awsAccountId"].(string); account != "" {
+		cloud["project"] = map[string]any{"id": account}
+	}
+	if zone, _ := item["availabilityZone"].(string); zone != "" && zone != "Not Applicable" {
+		cloud["availability_zone"] = zone
+	}
+	attribute := map[string]any{"cloud": cloud}
+	var labels []map[string]string
+	switch tags := item["tags"].(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			labels = append(labels, map[string]string{"key": k, "value": fmt.Sprint(tags[k])})
+		}
+	case []any:
+		for _, tag := range tags {
+			if kv, ok := tag.(map[string]any); ok {
+				labels = append(labels, map[string]string{"key": fmt.Sprint(kv["key"]), "value": fmt.Sprint(kv["value"])})
+			}
+		}
+	}
+	if len(labels) > 0 {
+		attribute["labels"] = labels
+	}
+	if created, _ := item["resourceCreationTime"].(string); created != "" {
+		attribute["creation_time"] = created
+	}
+
+	resource := map[string]any{
+		"product_object_id": objectID,
+		"resource_type":     resourceType,
+		"resource_subtype":  t,
+		"attribute":         attribute,
+	}
+	name, _ := item["resourceName"].(string)
+	if name == "" {
+		name = id
+	}
+	resource["name"] = name
+	entity := map[string]any{"resource": resource}
+	if region, _ := item["awsRegion"].(string); region != "" {
+		entity["location"] = map[string]any{"name": region}
+	}
+
+	return map[string]any{
+		"metadata": map[string]any{
+			"collected_timestamp": captured,
+			"entity_type":         "RESOURCE",
+			"vendor_name":         "AWS",
+			"product_name":        "AWS Config",
+			"product_entity_id":   objectID,
+			"interval":            map[string]any{"start_time": captured},
+		},
+		"entity": entity,
+	}, nil
+}
+
+// Write implements ItemWriter for ChronicleWriter
+func (cw *ChronicleWriter) Write(item map[string]interface{}) error {
+	entity, err := udmEntity(item)
+	if err != nil {
+		return fmt.Errorf("ChronicleWriter: %w", err)
+	}
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	// the envelope of the batch takes a few hundred bytes
+	if len(b)+512 > chronicleMaxBody {
+		return fmt.Errorf("ChronicleWriter: entity of %d bytes is over the API's limit", len(b))
+	}
+	if cw.buf.Len()+len(b)+512 > chronicleMaxBody {
+		if err := cw.batch.makeRoom(cw.Flush); err != nil {
+			return err
+		}
+	}
+
+	start := cw.buf.Len()
+	if cw.batch.n > 0 {
+		cw.buf.WriteByte(',')
+	}
+	cw.buf.Write(b)
+	return cw.batch.add(cw.Flush, func() { cw.buf.Truncate(start) })
+}
+
+// BytesWritten implements ByteCounter for ChronicleWriter, counting the requests the API accepted
+func (cw *ChronicleWriter) BytesWritten() int64 {
+	return cw.batch.written
+}
+
+// Flush implements Flusher for ChronicleWriter, sending any buffered items
+// The items are kept for another attempt if the request couldn't be made.
+func (cw *ChronicleWriter) Flush() error {
+	err := cw.batch.flush(func() (int64, bool, error) {
+		var body bytes.Buffer
+		body.Write(cw.client.prefix)
+		body.Write(cw.buf.Bytes())
+		body.WriteString("]}")
+		retry, err := cw.client.batchCreate(body.Bytes())
+		return int64(body.Len()), retry, err
+	}, cw.buf.Reset)
+	if err != nil {
+		return fmt.Errorf("ChronicleWriter: %w", err)
+	}
+	return nil
+}
+
+// Healthy implements HealthChecker for ChronicleWriter, checking an access token can be had
+func (cw *ChronicleWriter) Healthy() error {
+	_, err := cw.client.accessToken()
+	return err
+}
+
+//batchCreate sends a batch of entities, reporting whether it should be retried
+func (c *chronicleClient) batchCreate(body []byte) (bool, error) {
+	token, err := c.accessToken()
+	if err != nil {
+		return true, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.cfg.Endpoint+"/v2/entities:batchCreate", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		// the token may have been revoked, so the next attempt gets another
+		c.mu.Lock()
+		c.access = ""
+		c.mu.Unlock()
+		return true, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	default:
+		return false, fmt.Errorf("batch rejected: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+}
+
+//accessToken returns a token for the ingestion API, exchanging a JWT signed by the service account's
+// key for one if there's none, or it's about to expire
+func (c *chronicleClient) accessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.access != "" && time.Now().Before(c.expires) {
+		return c.access, nil
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss": c.email, "scope": chronicleScope, "aud": c.tokenURI, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(nil, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("access token: %w", err)
+	}
+
+	resp, err := c.http.PostForm(c.tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("access token: %w", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("access token: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(b, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("access token: bad response: %s", bytes.TrimSpace(b))
+	}
+	c.access = token.AccessToken
+	c.expires = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.access, nil
+}
@@ -0,0 +1,210 @@
+package config_decoder
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//chronicleServer fakes Google's token endpoint and the Chronicle ingestion API, collecting the
+// entities created for customer; status, if set, is the response to the next batch
+type chronicleServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	customer string
+	tokens   int
+	batches  int
+	entities []map[string]any
+	status   int
+}
+
+func newChronicleServer(t *testing.T) *chronicleServer {
+	s := &chronicleServer{customer: "customer"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.URL.Path {
+		case "/token":
+			if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.FormValue("assertion"), ".") != 2 {
+				http.Error(w, "bad assertion", http.StatusBadRequest)
+				return
+			}
+			s.tokens++
+			_, _ = io.WriteString(w, `{"access_token":"token","expires_in":3600}`)
+		case "/v2/entities:batchCreate":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if s.status != 0 {
+				http.Error(w, "failed", s.status)
+				s.status = 0
+				return
+			}
+			var batch struct {
+				CustomerID string           `json:"customer_id"`
+				LogType    string           `json:"log_type"`
+				Entities   []map[string]any `json:"entities"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil || batch.CustomerID != s.customer || batch.LogType != "AWS_CONFIG" {
+				http.Error(w, "bad batch", http.StatusBadRequest)
+				return
+			}
+			s.batches++
+			s.entities = append(s.entities, batch.Entities...)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+//serviceAccount returns a service account key whose tokens come from srv
+func serviceAccount(t *testing.T, srv *chronicleServer) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "ingest@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL + "/token",
+	})
+	return b
+}
+
+func TestUDMEntity(t *testing.T) {
+	e, err := udmEntity(map[string]any{
+		"resourceType": "AWS::EC2::Instance", "resourceId": "i-1", "ARN": "arn:aws:ec2:us-east-1:123:instance/i-1",
+		"awsRegion": "us-east-1", "awsAccountId": "123", "availabilityZone": "us-east-1a",
+		"configurationItemCaptureTime": "2024-01-02T03:04:05.000Z", "tags": map[string]any{"b": "2", "a": "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(e)
+	for _, want := range []string{
+		`"entity_type":"RESOURCE"`, `"product_entity_id":"arn:aws:ec2:us-east-1:123:instance/i-1"`,
+		`"resource_type":"VIRTUAL_MACHINE"`, `"resource_subtype":"AWS::EC2::Instance"`, `"name":"i-1"`,
+		`"availability_zone":"us-east-1a"`, `"project":{"id":"123"}`, `"location":{"name":"us-east-1"}`,
+		`"labels":[{"key":"a","value":"1"},{"key":"b","value":"2"}]`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("entity %s has no %s", b, want)
+		}
+	}
+
+	if _, err := udmEntity(map[string]any{"resourceId": "i-1"}); err == nil {
+		t.Error("no error mapping an item without a type")
+	}
+}
+
+func TestChronicleWriter(t *testing.T) {
+	srv := newChronicleServer(t)
+	f, err := ChronicleWriterFactory(ChronicleConfig{Endpoint: srv.URL, CustomerID: "customer",
+		Credentials: serviceAccount(t, srv), BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := func(id string) map[string]any {
+		return map[string]any{"resourceType": "AWS::S3::Bucket", "resourceId": id,
+			"configurationItemCaptureTime": "2024-01-02T03:04:05.000Z"}
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := w.Write(item(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failed batch keeps the rest of the batch, failing only the item that sent it
+	srv.mu.Lock()
+	srv.status = http.StatusServiceUnavailable
+	srv.mu.Unlock()
+	if err := w.Write(item("d")); err == nil {
+		t.Error("no error from a failed batch")
+	}
+	if err := w.Write(item("e")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.(Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, e := range srv.entities {
+		ids = append(ids, e["entity"].(map[string]any)["resource"].(map[string]any)["product_object_id"].(string))
+	}
+	if strings.Join(ids, " ") != "a b c e" {
+		t.Errorf("created %v, want a b c e", ids)
+	}
+	if srv.tokens != 1 || srv.batches != 2 {
+		t.Errorf("%d tokens and %d batches, want 1 and 2", srv.tokens, srv.batches)
+	}
+	if w.(ByteCounter).BytesWritten() == 0 {
+		t.Error("no bytes written")
+	}
+
+	// a batch rejected for good is dropped, failing as a BatchError of all its items, and not counted
+	written := w.(ByteCounter).BytesWritten()
+	srv.mu.Lock()
+	srv.status = http.StatusBadRequest
+	srv.mu.Unlock()
+	if err := w.Write(item("f")); err != nil {
+		t.Fatal(err)
+	}
+	var be *BatchError
+	if err := w.Write(item("g")); !errors.As(err, &be) || be.Items != 2 {
+		t.Errorf("rejected batch failed with %v, want a BatchError of 2 items", err)
+	}
+	if w.(ByteCounter).BytesWritten() != written {
+		t.Errorf("%d bytes written, want %d", w.(ByteCounter).BytesWritten(), written)
+	}
+}
+
+func TestChronicleWriterEscaping(t *testing.T) {
+	// customer ids and log types are json strings, whatever they hold
+	srv := newChronicleServer(t)
+	srv.customer = "cust\u00e9mer\x01\u2028"
+	f, err := ChronicleWriterFactory(ChronicleConfig{Endpoint: srv.URL, CustomerID: srv.customer,
+		Credentials: serviceAccount(t, srv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := f(context.Background(), 0)
+	if err := w.Write(map[string]any{"resourceType": "AWS::S3::Bucket", "resourceId": "a",
+		"configurationItemCaptureTime": "2024-01-02T03:04:05.000Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.(Flusher).Flush(); err != nil || srv.batches != 1 {
+		t.Errorf("%d batches created (%v), want 1", srv.batches, err)
+	}
+}
+
+func TestChronicleWriterFactory(t *testing.T) {
+	if _, err := ChronicleWriterFactory(ChronicleConfig{CustomerID: "customer"}); err == nil {
+		t.Error("no error without credentials")
+	}
+	if _, err := ChronicleWriterFactory(ChronicleConfig{CustomerID: "customer", Credentials: []byte(`{"client_email":"x"}`)}); err == nil {
+		t.Error("no error without a private key")
+	}
+}
@@ -0,0 +1,22 @@
+package config_decoder
+
+import "time"
+
+//Clock tells the time items' ingest_time and workers' reports are stamped with; nil is the system clock
+type Clock func() time.Time
+
+//FixedClock returns the Clock always telling t, for metadata that's the same every run, or for stamping
+// replayed items with the time they were first delivered rather than when they're decoded again
+func FixedClock(t time.Time) Clock {
+	return func() time.Time {
+		return t
+	}
+}
+
+//now returns the time c tells, in UTC
+func (c Clock) now() time.Time {
+	if c == nil {
+		return time.Now().UTC()
+	}
+	return c().UTC()
+}
@@ -0,0 +1,81 @@
+package config_decoder
+
+import "sync"
+
+//CollectorWriter is an ItemWriter that collects items in memory, for tests and embedding applications
+// to assert on decoded output without writing it anywhere. One CollectorWriter is shared by all the
+// workers of a pool, so it's safe for concurrent use. Each item is copied as it's written, so it may
+// be released for reuse; its values are not copied and must not be modified.
+type CollectorWriter struct {
+	mu    sync.Mutex
+	items []map[string]any
+}
+
+//CollectorWriterFactory returns a factory whose ItemWriters are all cw
+//
+// Deprecated: importers should use writers.Collect.
+func CollectorWriterFactory(cw *CollectorWriter) WriterFactory {
+	return FactoryOf(func() ItemWriter {
+		return cw
+	})
+}
+
+// Write implements ItemWriter for CollectorWriter
+func (cw *CollectorWriter) Write(item map[string]interface{}) error {
+	c := make(map[string]any, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.items = append(cw.items, c)
+	return nil
+}
+
+//Items returns the items collected, in the order they were written
+func (cw *CollectorWriter) Items() []map[string]any {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return append([]map[string]any(nil), cw.items...)
+}
+
+//Count returns the number of items collected
+func (cw *CollectorWriter) Count() int {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return len(cw.items)
+}
+
+//ByResourceType returns the items collected of resourceType, in the order they were written
+func (cw *CollectorWriter) ByResourceType(resourceType string) []map[string]any {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	var items []map[string]any
+	for _, item := range cw.items {
+		if item["resourceType"] == resourceType {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+//CountByResourceType returns the number of items collected of each resource type
+// Items without a resourceType are counted under "".
+func (cw *CollectorWriter) CountByResourceType() map[string]int {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	counts := make(map[string]int)
+	for _, item := range cw.items {
+		t, _ := item["resourceType"].(string)
+		counts[t]++
+	}
+	return counts
+}
+
+//Reset discards the items collected
+func (cw *CollectorWriter) Reset() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.items = nil
+}
@@ -0,0 +1,82 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"github.com/mfrasier/decode_json_stream/generator"
+	"testing"
+)
+
+//collect decodes doc with a pool of CollectorWriters releasing items for reuse, returning the collector
+func collect(t *testing.T, doc []byte) *CollectorWriter {
+	cw := &CollectorWriter{}
+	const poolSize = 4
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(doc), CollectorWriterFactory(cw),
+		PoolSpec{Size: poolSize, ReuseItems: true}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	for i := 0; i < poolSize; i++ {
+		<-chStatus
+	}
+	return cw
+}
+
+func TestCollectorWriter(t *testing.T) {
+	var doc bytes.Buffer
+	_, err := generator.Write(&doc, generator.Options{Count: 200, Synthesize: true, Seed: 5,
+		Mix: []generator.MixEntry{{ResourceType: "AWS::EC2::Instance", Weight: 3}, {ResourceType: "AWS::S3::Bucket", Weight: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw := collect(t, doc.Bytes())
+
+	if n := cw.Count(); n != 200 {
+		t.Fatalf("Count() = %d, want 200", n)
+	}
+	counts := cw.CountByResourceType()
+	if len(counts) != 2 || counts["AWS::EC2::Instance"]+counts["AWS::S3::Bucket"] != 200 {
+		t.Fatalf("CountByResourceType() = %v, want 200 EC2 instances and S3 buckets", counts)
+	}
+	for resourceType, n := range counts {
+		items := cw.ByResourceType(resourceType)
+		if len(items) != n {
+			t.Errorf("ByResourceType(%s) has %d items, CountByResourceType %d", resourceType, len(items), n)
+		}
+		for _, item := range items {
+			if item["resourceType"] != resourceType || item["resourceId"] == nil {
+				t.Fatalf("ByResourceType(%s) has item %v", resourceType, item)
+			}
+		}
+	}
+	if items := cw.ByResourceType("AWS::IAM::Role"); items != nil {
+		t.Errorf("ByResourceType(AWS::IAM::Role) = %d items, want none", len(items))
+	}
+
+	// items released for reuse after each write must have been copied
+	seen := make(map[any]bool)
+	for _, item := range cw.Items() {
+		if item["source_offset_start"] == nil || seen[item["source_offset_start"]] {
+			t.Fatalf("collected item %v was reused or is missing its offset", item)
+		}
+		seen[item["source_offset_start"]] = true
+	}
+
+	cw.Reset()
+	if n := cw.Count(); n != 0 {
+		t.Errorf("Count() after Reset() = %d, want 0", n)
+	}
+}
+
+func TestCollectorWriterEmpty(t *testing.T) {
+	cw := collect(t, []byte(`{"fileVersion":"1.0","configurationItems":[]}`))
+	if n := cw.Count(); n != 0 {
+		t.Errorf("Count() = %d, want 0", n)
+	}
+	if counts := cw.CountByResourceType(); len(counts) != 0 {
+		t.Errorf("CountByResourceType() = %v, want none", counts)
+	}
+	if items := cw.Items(); len(items) != 0 {
+		t.Errorf("Items() = %v, want none", items)
+	}
+}
@@ -0,0 +1,206 @@
+package config_decoder
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+//configurationField is the item field a ConfigurationCache decodes
+const configurationField = "configuration"
+
+//minCachedConfiguration is the size, in bytes of json, of the smallest configuration cached; smaller
+// ones are about as quick to decode as to look up
+const minCachedConfiguration = 256
+
+//ConfigurationCache decodes each distinct configuration of the items it's shared by once, as fleets of
+// identical resources, such as the launch templates of many auto scaling groups, repeat theirs
+// An item whose configuration is byte for byte that of one decoded before shares its decoded value, and
+// its ItemHash, if that's of the configuration alone. The least recently used configurations are evicted
+// to keep the cache within its size, in bytes of json. Shared by the decodes of a run, it also spares
+// decoding the configurations a series of snapshots repeat. Items sharing configurations, writers
+// mustn't modify them; none of this package's do.
+type ConfigurationCache struct {
+	maxBytes int64
+	seed     maphash.Seed
+
+	mu      sync.Mutex
+	bytes   int64
+	lru     *list.List
+	entries map[uint64]*list.Element
+	// values finds the entries of decoded objects, for their hashes
+	values map[unsafe.Pointer]*configEntry
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+//configEntry is a configuration in a ConfigurationCache
+type configEntry struct {
+	key     uint64
+	raw     []byte
+	numbers bool
+	value   any
+	hash    string
+}
+
+//NewConfigurationCache returns a ConfigurationCache of at most maxBytes of configurations
+func NewConfigurationCache(maxBytes int64) *ConfigurationCache {
+	return &ConfigurationCache{maxBytes: maxBytes, seed: maphash.MakeSeed(), lru: list.New(),
+		entries: make(map[uint64]*list.Element), values: make(map[unsafe.Pointer]*configEntry)}
+}
+
+//Hits returns the number of configurations found in the cache
+func (c *ConfigurationCache) Hits() int64 {
+	return c.hits.Load()
+}
+
+//Misses returns the number of configurations decoded, as they weren't in the cache; those too small to
+// cache aren't counted
+func (c *ConfigurationCache) Misses() int64 {
+	return c.misses.Load()
+}
+
+//String summarizes the cache's hits and misses
+func (c *ConfigurationCache) String() string {
+	hits, misses := c.Hits(), c.Misses()
+	if hits+misses == 0 {
+		return "no configurations cached"
+	}
+	return fmt.Sprintf("%d of %d configurations (%.1f%%) found in the cache", hits, hits+misses,
+		100*float64(hits)/float64(hits+misses))
+}
+
+//decode decodes the next item of dec into item, through the cache, its numbers as json.Numbers if
+// numbers is set
+func (c *ConfigurationCache) decode(dec *json.Decoder, item map[string]any, numbers bool) error {
+	var fields map[string]json.RawMessage
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+	return c.fill(fields, item, numbers)
+}
+
+//unmarshal decodes the item raw into item, through the cache, as decode does
+func (c *ConfigurationCache) unmarshal(raw json.RawMessage, item map[string]any, numbers bool) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	return c.fill(fields, item, numbers)
+}
+
+//fill decodes the fields of an item into item, its configuration through the cache
+func (c *ConfigurationCache) fill(fields map[string]json.RawMessage, item map[string]any, numbers bool) error {
+	if fields == nil {
+		return fmt.Errorf("%w: item is null", ErrNotObject)
+	}
+	for k, raw := range fields {
+		var v any
+		var err error
+		if k == configurationField {
+			v, err = c.value(raw, numbers)
+		} else {
+			err = unmarshal(raw, &v, numbers)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		item[k] = v
+	}
+	return nil
+}
+
+//value returns the decoded configuration raw, from the cache if it's there, and otherwise adding it
+// A configuration decoded with its numbers otherwise than numbers says isn't shared.
+func (c *ConfigurationCache) value(raw json.RawMessage, numbers bool) (any, error) {
+	var v any
+	if len(raw) < minCachedConfiguration || int64(len(raw)) > c.maxBytes {
+		err := unmarshal(raw, &v, numbers)
+		return v, err
+	}
+
+	key := maphash.Bytes(c.seed, raw)
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok && el.Value.(*configEntry).numbers == numbers && bytes.Equal(el.Value.(*configEntry).raw, raw) {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return el.Value.(*configEntry).value, nil
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	if err := unmarshal(raw, &v, numbers); err != nil {
+		return nil, err
+	}
+	c.add(&configEntry{key: key, raw: raw, numbers: numbers, value: v})
+	return v, nil
+}
+
+//add adds e to the cache, evicting the least recently used configurations to make room
+// A configuration with the same key, decoded at once by another decoder or colliding, is replaced.
+func (c *ConfigurationCache) add(e *configEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += int64(len(e.raw))
+	if p, ok := mapPointer(e.value); ok {
+		c.values[p] = e
+	}
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+//remove removes the configuration of el from the cache
+func (c *ConfigurationCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*configEntry)
+	delete(c.entries, e.key)
+	c.bytes -= int64(len(e.raw))
+	if p, ok := mapPointer(e.value); ok {
+		delete(c.values, p)
+	}
+}
+
+//hash returns the hash of configuration v, computing it with compute only if v is a cached configuration
+// whose hash isn't known yet, or not a cached configuration at all
+func (c *ConfigurationCache) hash(v any, compute func(v any) (string, error)) (string, error) {
+	p, ok := mapPointer(v)
+	if c == nil || !ok {
+		return compute(v)
+	}
+	c.mu.Lock()
+	e := c.values[p]
+	if e != nil && e.hash != "" {
+		c.mu.Unlock()
+		return e.hash, nil
+	}
+	c.mu.Unlock()
+
+	sum, err := compute(v)
+	if err == nil && e != nil {
+		c.mu.Lock()
+		e.hash = sum
+		c.mu.Unlock()
+	}
+	return sum, err
+}
+
+//mapPointer returns the pointer identifying v, if it's a decoded object
+func mapPointer(v any) (unsafe.Pointer, bool) {
+	m, ok := v.(map[string]any)
+	if !ok || m == nil {
+		return nil, false
+	}
+	return reflect.ValueOf(m).UnsafePointer(), true
+}
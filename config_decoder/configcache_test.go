@@ -0,0 +1,140 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConfigurationCache(t *testing.T) {
+	// every item has the same configuration, but for the last, with a small one that isn't cached
+	doc := benchSnapshot(100, 1000)
+	doc = bytes.Replace(doc, []byte(`"configuration":{"blob"`), []byte(`"configuration":{"small":true},"x":{"blob"`), 1)
+	spec := benchSpec
+	spec.Hash = ItemHash{Field: "config_hash"}
+	spec.Reproducible = true
+
+	for name, decode := range provenanceDecoders {
+		var want, got []string
+		for _, cached := range []bool{false, true} {
+			var out lockedBuffer
+			spec := spec
+			if cached {
+				spec.Configurations = NewConfigurationCache(1 << 20)
+			}
+			chStatus, chErrors := decode(doc, FileWriterFactory(&out, []byte{'\n'}), spec)
+			for err := range chErrors {
+				t.Fatalf("%s: %v", name, err)
+			}
+			<-chStatus
+			<-chStatus
+
+			lines := strings.Split(strings.TrimSpace(out.b.String()), "\n")
+			slices.Sort(lines)
+			if !cached {
+				want = lines
+				continue
+			}
+			got = lines
+			c := spec.Configurations
+			if hits, misses := c.Hits(), c.Misses(); hits+misses != 99 || misses < 1 || hits < 90 {
+				t.Errorf("%s: %d hits and %d misses, want 99 configurations cached", name, hits, misses)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: items decoded through the cache differ from those decoded without it", name)
+		}
+	}
+}
+
+func TestConfigurationCacheEviction(t *testing.T) {
+	// two configurations of about 1000 bytes don't both fit in 1500
+	c := NewConfigurationCache(1500)
+	configs := []json.RawMessage{
+		json.RawMessage(fmt.Sprintf(`{"blob":%q}`, strings.Repeat("a", 1000))),
+		json.RawMessage(fmt.Sprintf(`{"blob":%q}`, strings.Repeat("b", 1000))),
+	}
+	var first any
+	for i := 0; i < 4; i++ {
+		v, err := c.value(configs[i%2], false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = v
+		}
+	}
+	if hits, misses := c.Hits(), c.Misses(); hits != 0 || misses != 4 {
+		t.Errorf("alternating configurations made %d hits and %d misses, want 0 and 4", hits, misses)
+	}
+	if c.bytes > 1500 || c.lru.Len() != 1 || len(c.values) != 1 {
+		t.Errorf("cache holds %d bytes in %d entries", c.bytes, c.lru.Len())
+	}
+
+	// an evicted configuration's hash is computed again
+	computed := 0
+	hash := func(v any) (string, error) {
+		computed++
+		return canonicalHash(v)
+	}
+	v, _ := c.value(configs[0], false)
+	for _, v := range []any{v, v, first} {
+		if _, err := c.hash(v, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if computed != 2 {
+		t.Errorf("hashed %d times, want 2", computed)
+	}
+}
+
+//fleetItems returns a json array of count config items of a fleet, sharing a configuration of many objects
+func fleetItems(count int) []byte {
+	var config strings.Builder
+	for i := 0; i < 60; i++ {
+		_, _ = fmt.Fprintf(&config, `,"setting%d":{"name":"value-%d","enabled":true,"size":%d,"list":[1,2,3,"a","b"]}`, i, i, i)
+	}
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		_, _ = fmt.Fprintf(&b, `{"awsAccountId":"123456789012","resourceId":"lt-%08d","resourceType":"AWS::EC2::LaunchTemplate",`+
+			`"configuration":{%s},"relationships":[],"tags":{}}`, i, config.String()[1:])
+	}
+	b.WriteByte(']')
+	return b.Bytes()
+}
+
+func BenchmarkConfigurationCache(b *testing.B) {
+	data := fleetItems(2000)
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached-%v", cached), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			src := itemSource{hash: ItemHash{Field: "config_hash"}}
+			if cached {
+				src.configs = NewConfigurationCache(64 << 20)
+			}
+			for n := 0; n < b.N; n++ {
+				cItems := make(chan map[string]any)
+				go func() {
+					for i := range cItems {
+						ReleaseItem(i)
+					}
+				}()
+
+				dec := json.NewDecoder(bytes.NewReader(data))
+				if err := decodeItems(context.Background(), dec, src, map[string]any{}, cItems, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+				close(cItems)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package config_decoder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//TextEncoding is the encoding of a document's text, as detected by DetectEncoding
+type TextEncoding string
+
+//Text encodings of json documents
+const (
+	EncodingUTF8    TextEncoding = "utf-8"
+	EncodingUTF8BOM TextEncoding = "utf-8 with byte order mark"
+	EncodingUTF16LE TextEncoding = "utf-16le"
+	EncodingUTF16BE TextEncoding = "utf-16be"
+)
+
+//ErrUTF16 is returned by NewUTF8Reader for UTF-16 text it isn't asked to transcode
+var ErrUTF16 = errors.New("input is UTF-16 text")
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+//DetectEncoding detects the encoding of a json document from prefix, its first bytes, returning it and
+// the length of its byte order mark
+// Without a byte order mark, UTF-16 is recognized by the zero byte of the document's first character,
+// which json requires to be ASCII.
+func DetectEncoding(prefix []byte) (TextEncoding, int) {
+	switch {
+	case bytes.HasPrefix(prefix, bomUTF8):
+		return EncodingUTF8BOM, len(bomUTF8)
+	case bytes.HasPrefix(prefix, bomUTF16LE):
+		return EncodingUTF16LE, len(bomUTF16LE)
+	case bytes.HasPrefix(prefix, bomUTF16BE):
+		return EncodingUTF16BE, len(bomUTF16BE)
+	case len(prefix) >= 2 && prefix[0] != 0 && prefix[1] == 0:
+		return EncodingUTF16LE, 0
+	case len(prefix) >= 2 && prefix[0] == 0 && prefix[1] != 0:
+		return EncodingUTF16BE, 0
+	}
+	return EncodingUTF8, 0
+}
+
+//NewUTF8Reader returns a reader of the UTF-8 text of the json document in r, and the encoding detected
+// A UTF-8 byte order mark is stripped, so decoding doesn't fail on it with an "invalid character"
+// error. UTF-16 text is transcoded to UTF-8 if transcode is set, and is otherwise an ErrUTF16;
+// invalid UTF-16 becomes the Unicode replacement character. Offsets into the document, as in
+// provenance, are then offsets into its UTF-8 text, after any byte order mark.
+func NewUTF8Reader(r io.Reader, transcode bool) (io.Reader, TextEncoding, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	prefix, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, "", fmt.Errorf("NewUTF8Reader: %w", err)
+	}
+
+	enc, bom := DetectEncoding(prefix)
+	_, _ = br.Discard(bom)
+	switch enc {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		if !transcode {
+			return nil, enc, fmt.Errorf("NewUTF8Reader: %w (%s)", ErrUTF16, enc)
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if enc == EncodingUTF16BE {
+			order = binary.BigEndian
+		}
+		return &utf16Reader{r: br, order: order}, enc, nil
+	}
+	return br, enc, nil
+}
+
+//utf16Reader transcodes UTF-16 text to UTF-8
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [32 << 10]byte
+	// in holds bytes read but not yet transcoded: an odd byte, or a high surrogate awaiting its pair
+	in   []byte
+	out  []byte
+	obuf []byte
+	err  error
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 && u.err == nil {
+		u.fill()
+	}
+	if len(u.out) == 0 {
+		return 0, u.err
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+//fill reads and transcodes the next block of text into out
+func (u *utf16Reader) fill() {
+	k := copy(u.buf[:], u.in)
+	n, err := u.r.Read(u.buf[k:])
+	data := u.buf[:k+n]
+
+	u.obuf = u.obuf[:0]
+	i := 0
+	for ; i+1 < len(data); i += 2 {
+		c := rune(u.order.Uint16(data[i:]))
+		if utf16.IsSurrogate(c) {
+			if i+3 >= len(data) {
+				// wait for the rest of a pair split between blocks
+				break
+			}
+			if r := utf16.DecodeRune(c, rune(u.order.Uint16(data[i+2:]))); r != utf8.RuneError {
+				c = r
+				i += 2
+			} else {
+				c = utf8.RuneError
+			}
+		}
+		u.obuf = utf8.AppendRune(u.obuf, c)
+	}
+	u.in = append(u.in[:0], data[i:]...)
+
+	if err != nil {
+		if err == io.EOF && len(u.in) > 0 {
+			// a trailing odd byte or lone surrogate
+			u.obuf = utf8.AppendRune(u.obuf, utf8.RuneError)
+			u.in = u.in[:0]
+		}
+		u.err = err
+	}
+	u.out = u.obuf
+}
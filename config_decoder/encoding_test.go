@@ -0,0 +1,84 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+//encodeUTF16 encodes s as UTF-16, big-endian if be is set, with a byte order mark if bom is set
+func encodeUTF16(s string, be, bom bool) []byte {
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xfeff}, units...)
+	}
+	b := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		if be {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return b
+}
+
+func TestNewUTF8Reader(t *testing.T) {
+	// a character outside the Basic Multilingual Plane is a surrogate pair in UTF-16
+	const text = `{"name":"bücket 🪣"}`
+	tests := []struct {
+		input []byte
+		enc   TextEncoding
+	}{
+		{[]byte(text), EncodingUTF8},
+		{append([]byte{0xef, 0xbb, 0xbf}, text...), EncodingUTF8BOM},
+		{encodeUTF16(text, false, true), EncodingUTF16LE},
+		{encodeUTF16(text, true, true), EncodingUTF16BE},
+		{encodeUTF16(text, false, false), EncodingUTF16LE},
+		{encodeUTF16(text, true, false), EncodingUTF16BE},
+	}
+	for _, tt := range tests {
+		// one byte at a time, so surrogate pairs are split between reads
+		r, enc, err := NewUTF8Reader(iotest.OneByteReader(bytes.NewReader(tt.input)), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || enc != tt.enc || string(got) != text {
+			t.Errorf("read %s %q, error %v, want %s %q", enc, got, err, tt.enc, text)
+		}
+
+		if tt.enc == EncodingUTF16LE || tt.enc == EncodingUTF16BE {
+			if _, _, err := NewUTF8Reader(bytes.NewReader(tt.input), false); !errors.Is(err, ErrUTF16) {
+				t.Errorf("%s without transcoding: error %v, want ErrUTF16", tt.enc, err)
+			}
+		}
+	}
+
+	// a truncated pair is replaced
+	r, _, _ := NewUTF8Reader(bytes.NewReader(encodeUTF16("a🪣", false, true)[:6]), true)
+	if got, _ := io.ReadAll(r); string(got) != "a�" {
+		t.Errorf("read truncated text as %q", got)
+	}
+}
+
+func TestDecodeUTF16Snapshot(t *testing.T) {
+	doc := benchSnapshot(3, 10)
+	r, _, err := NewUTF8Reader(bytes.NewReader(encodeUTF16(string(doc), false, true)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw := &CollectorWriter{}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), r, CollectorWriterFactory(cw), PoolSpec{Size: 1}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	if cw.Count() != 3 {
+		t.Errorf("decoded %d items, want 3", cw.Count())
+	}
+}
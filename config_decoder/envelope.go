@@ -0,0 +1,154 @@
+package config_decoder
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//Metadata envelope shapes
+const (
+	// EnvelopeBoth nests the metadata under "metadata" and copies its fields to the top level
+	EnvelopeBoth = "both"
+	// EnvelopeNested only nests the metadata under "metadata"
+	EnvelopeNested = "nested"
+	// EnvelopeFlat only copies the metadata's fields to the top level
+	EnvelopeFlat = "flat"
+)
+
+//CollisionPolicy chooses what happens when a metadata field collides with one of the item's own fields
+type CollisionPolicy string
+
+const (
+	// CollisionOverwrite replaces the item's field with the metadata's
+	CollisionOverwrite CollisionPolicy = "overwrite"
+	// CollisionPreserve keeps the item's field, leaving the metadata's out of the item's top level
+	CollisionPreserve CollisionPolicy = "preserve"
+	// CollisionPrefix keeps the item's field, adding the metadata's with collisionPrefix
+	CollisionPrefix CollisionPolicy = "prefix"
+	// CollisionError fails decoding
+	CollisionError CollisionPolicy = "error"
+)
+
+//collisionPrefix is prepended to the name of a metadata field colliding with the item's own, with CollisionPrefix
+const collisionPrefix = "_meta_"
+
+//MetadataEnvelope shapes how an item's metadata is added to it; the zero value is EnvelopeBoth
+// Shape is one of the envelope shapes. Prefix, if set, is prepended to the names of the fields copied
+// to the top level, e.g. "_meta_" gives "_meta_run_id", so they can't collide with the item's own
+// fields; it doesn't apply to EnvelopeNested. An item's provenance is always at its top level.
+// OnCollision is the policy for a metadata field, "metadata" itself included, named like one of the
+// item's own fields; the zero value is CollisionOverwrite. Collisions, if set, counts them.
+type MetadataEnvelope struct {
+	Shape       string
+	Prefix      string
+	OnCollision CollisionPolicy
+	Collisions  *MetadataCollisions `json:"-"`
+}
+
+//Validate checks the envelope's shape and collision policy are known
+func (e MetadataEnvelope) Validate() error {
+	switch e.Shape {
+	case "", EnvelopeBoth, EnvelopeFlat:
+	case EnvelopeNested:
+		if e.Prefix != "" {
+			return fmt.Errorf("MetadataEnvelope: Prefix %q doesn't apply to %s metadata", e.Prefix, e.Shape)
+		}
+	default:
+		return fmt.Errorf("MetadataEnvelope: unknown Shape %q", e.Shape)
+	}
+	switch e.OnCollision {
+	case "", CollisionOverwrite, CollisionPreserve, CollisionPrefix, CollisionError:
+	default:
+		return fmt.Errorf("MetadataEnvelope: unknown collision policy %q", e.OnCollision)
+	}
+	return nil
+}
+
+//wrap adds metadata to item in the envelope's shape, applying the collision policy
+func (e MetadataEnvelope) wrap(item map[string]any, metadata map[string]any) error {
+	if e.Shape != EnvelopeFlat {
+		if err := e.set(item, "metadata", metadata); err != nil {
+			return err
+		}
+	}
+	if e.Shape == EnvelopeNested {
+		return nil
+	}
+	for key, val := range metadata {
+		if err := e.set(item, e.Prefix+key, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//set sets the metadata field key of item, applying the collision policy if item has one already
+func (e MetadataEnvelope) set(item map[string]any, key string, val any) error {
+	if _, ok := item[key]; !ok {
+		item[key] = val
+		return nil
+	}
+
+	if e.Collisions != nil {
+		e.Collisions.add(key)
+	}
+	switch e.OnCollision {
+	case CollisionPreserve:
+	case CollisionPrefix:
+		item[collisionPrefix+key] = val
+	case CollisionError:
+		return fmt.Errorf("metadata field %q collides with the item's own", key)
+	default:
+		item[key] = val
+	}
+	return nil
+}
+
+//MetadataCollisions counts the metadata fields that collided with items' own fields, by name
+// It's safe for use by concurrent decoders; decoders sharing one total their collisions.
+type MetadataCollisions struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+//NewMetadataCollisions creates a MetadataCollisions with no collisions
+func NewMetadataCollisions() *MetadataCollisions {
+	return &MetadataCollisions{counts: make(map[string]int64)}
+}
+
+//add counts a collision of field key
+func (mc *MetadataCollisions) add(key string) {
+	mc.mu.Lock()
+	mc.counts[key]++
+	mc.mu.Unlock()
+}
+
+//Counts returns the collisions of each field so far
+func (mc *MetadataCollisions) Counts() map[string]int64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	counts := make(map[string]int64, len(mc.counts))
+	for key, n := range mc.counts {
+		counts[key] = n
+	}
+	return counts
+}
+
+//String lists the collisions of each field so far, in order of name
+func (mc *MetadataCollisions) String() string {
+	counts := mc.Counts()
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s := ""
+	for i, key := range keys {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s %d", key, counts[key])
+	}
+	return s
+}
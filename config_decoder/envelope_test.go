@@ -0,0 +1,116 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//envelopeItem decodes a one item snapshot with envelope, returning the item
+func envelopeItem(t *testing.T, envelope MetadataEnvelope) map[string]any {
+	spec := benchSpec
+	spec.RunID = "run-1"
+	spec.Envelope = envelope
+	cw := &CollectorWriter{}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(1, 10)),
+		CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	if cw.Count() != 1 {
+		t.Fatalf("decoded %d items, want 1", cw.Count())
+	}
+	return cw.Items()[0]
+}
+
+func TestMetadataEnvelope(t *testing.T) {
+	tests := []struct {
+		envelope MetadataEnvelope
+		nested   bool
+		flat     string
+	}{
+		{MetadataEnvelope{}, true, "run_id"},
+		{MetadataEnvelope{Shape: EnvelopeNested}, true, ""},
+		{MetadataEnvelope{Shape: EnvelopeFlat}, false, "run_id"},
+		{MetadataEnvelope{Shape: EnvelopeFlat, Prefix: "_meta_"}, false, "_meta_run_id"},
+		{MetadataEnvelope{Prefix: "_meta_"}, true, "_meta_run_id"},
+	}
+	for _, tt := range tests {
+		item := envelopeItem(t, tt.envelope)
+		metadata, nested := item["metadata"].(map[string]any)
+		if nested != tt.nested || nested && metadata["run_id"] != "run-1" {
+			t.Errorf("%+v: nested metadata %v, want it %v", tt.envelope, item["metadata"], tt.nested)
+		}
+		for _, key := range []string{"run_id", "_meta_run_id"} {
+			if got, want := item[key] != nil, key == tt.flat; got != want {
+				t.Errorf("%+v: top level %s is %v", tt.envelope, key, item[key])
+			}
+		}
+		if _, ok := item["source_index"]; !ok {
+			t.Errorf("%+v: no provenance", tt.envelope)
+		}
+	}
+}
+
+func TestMetadataEnvelopeValidate(t *testing.T) {
+	for _, e := range []MetadataEnvelope{{Shape: "wrapped"}, {Shape: EnvelopeNested, Prefix: "_meta_"}, {OnCollision: "rename"}} {
+		if err := e.Validate(); err == nil {
+			t.Errorf("%+v validated", e)
+		}
+	}
+}
+
+func TestMetadataCollisions(t *testing.T) {
+	const doc = `{"configurationItems":[{"resourceId":"r1","event_type":"mine","metadata":{"tag":"a"}},{"resourceId":"r2"}]}`
+	tests := []struct {
+		policy    CollisionPolicy
+		eventType any
+		metadata  any
+		prefixed  bool
+	}{
+		{"", "config_snapshot", nil, false},
+		{CollisionPreserve, "mine", map[string]any{"tag": "a"}, false},
+		{CollisionPrefix, "mine", map[string]any{"tag": "a"}, true},
+	}
+	for _, tt := range tests {
+		collisions := NewMetadataCollisions()
+		spec := ItemTransformSpec{ItemsField: "configurationItems",
+			Envelope: MetadataEnvelope{OnCollision: tt.policy, Collisions: collisions}}
+		cw := &CollectorWriter{}
+		chStatus, chErrors := DecodeAndSplitItems(context.Background(), strings.NewReader(doc),
+			CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+		for err := range chErrors {
+			t.Fatal(err)
+		}
+		<-chStatus
+
+		for _, item := range cw.Items() {
+			if item["resourceId"] != "r1" {
+				continue
+			}
+			if item["event_type"] != tt.eventType {
+				t.Errorf("%q: event_type is %v, want %v", tt.policy, item["event_type"], tt.eventType)
+			}
+			if tt.metadata != nil && !reflect.DeepEqual(item["metadata"], tt.metadata) {
+				t.Errorf("%q: metadata is %v, want the item's own", tt.policy, item["metadata"])
+			}
+			if _, ok := item["_meta_event_type"]; ok != tt.prefixed {
+				t.Errorf("%q: _meta_event_type is %v", tt.policy, item["_meta_event_type"])
+			}
+		}
+		if counts := collisions.Counts(); len(counts) != 2 || counts["event_type"] != 1 || counts["metadata"] != 1 {
+			t.Errorf("%q: counted collisions %v, want event_type 1, metadata 1", tt.policy, counts)
+		}
+	}
+
+	spec := ItemTransformSpec{ItemsField: "configurationItems", Envelope: MetadataEnvelope{OnCollision: CollisionError}}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), strings.NewReader(doc), NullWriterFactory(), PoolSpec{Size: 1}, spec)
+	err := <-chErrors
+	<-chStatus
+	if err == nil || !strings.Contains(err.Error(), "collides") {
+		t.Errorf("decoding with CollisionError ended with %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//Sentinel errors wrapped by the errors decoding ends with, so callers can tell them apart with errors.Is
+var (
+	//ErrNotObject is a document, item or change event that isn't a json object
+	ErrNotObject = errors.New("not a json object")
+	//ErrItemsFieldMissing is a document without the ItemsField of its ItemTransformSpec
+	ErrItemsFieldMissing = errors.New("items field missing")
+	//ErrCancelled is decoding stopped because its context was done; the context's error, such as
+	// context.DeadlineExceeded, is wrapped too
+	ErrCancelled = errors.New("decoding cancelled")
+	//ErrWriterFailed is decoding stopped by a writer that couldn't be created, or whose write failed
+	// with PoolSpec.StopOnError; it's wrapped by a WriteError, saying which
+	ErrWriterFailed = errors.New("writer failed")
+)
+
+//stopped returns why decoding stopped once ctx is done: the WriteError of the writer that stopped it,
+// or ErrCancelled wrapping ctx's cause
+func stopped(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, ErrWriterFailed) {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", ErrCancelled, cause)
+}
+
+//objectError wraps err, from decoding a value into a map, in ErrNotObject if the value isn't an object
+func objectError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("%w: %w", ErrNotObject, err)
+	}
+	return err
+}
+
+//expectObject consumes the opening brace of an object, or returns ErrNotObject if the next value isn't one
+func expectObject(d *json.Decoder) error {
+	t, err := d.Token()
+	if err != nil {
+		return fmt.Errorf("expect: %w", err)
+	}
+	if t != json.Delim('{') {
+		return fmt.Errorf("%w: got token %v", ErrNotObject, t)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+//firstError waits for decoding to end, returning the first of its errors
+func firstError(chStatus chan WorkerStatus, chErrors chan error, workers int) error {
+	var first error
+	for err := range chErrors {
+		if first == nil {
+			first = err
+		}
+	}
+	for i := 0; i < workers; i++ {
+		<-chStatus
+	}
+	return first
+}
+
+func TestSentinelErrors(t *testing.T) {
+	strict := benchSpec
+	strict.Strict = true
+	limited := benchSpec
+	limited.Limits = ItemLimits{MaxItemSize: 1000, Oversize: OversizeTruncate}
+	cases := []struct {
+		name string
+		doc  string
+		spec ItemTransformSpec
+		want error
+	}{
+		{"array document", `[{"a": 1}]`, benchSpec, ErrNotObject},
+		{"string item", `{"configurationItems": [{"a": 1}, "b"]}`, benchSpec, ErrNotObject},
+		{"null item", `{"configurationItems": [null]}`, benchSpec, ErrNotObject},
+		{"limited string item", `{"configurationItems": [{"a": 1}, "b"]}`, limited, ErrNotObject},
+		{"no items", `{"fileVersion": "1.0", "other": []}`, benchSpec, ErrItemsFieldMissing},
+		{"strict, no items", `{"fileVersion": "1.0", "configSnapshotId": "s-1"}`, strict, ErrItemsFieldMissing},
+	}
+	for _, c := range cases {
+		for name, decode := range provenanceDecoders {
+			chStatus, chErrors := decode([]byte(c.doc), NullWriterFactory(), c.spec)
+			err := firstError(chStatus, chErrors, 2)
+			if !errors.Is(err, c.want) {
+				t.Errorf("%s %s: got %v, want %v", c.name, name, err, c.want)
+			}
+			if c.spec.Strict && !errors.As(err, new(*SchemaError)) {
+				t.Errorf("%s %s: %v isn't a SchemaError", c.name, name, err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	chStatus, chErrors := DecodeAndSplitItems(ctx, bytes.NewReader(benchSnapshot(5, 10)), NullWriterFactory(), PoolSpec{Size: 1}, benchSpec)
+	if err := firstError(chStatus, chErrors, 1); !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled decoding ended with %v", err)
+	}
+
+	failing := func(ctx context.Context, worker int) (ItemWriter, error) {
+		return nil, errors.New("no credentials")
+	}
+	chStatus, chErrors = DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(5, 10)), failing, PoolSpec{Size: 1}, benchSpec)
+	err := firstError(chStatus, chErrors, 1)
+	if !errors.Is(err, ErrWriterFailed) || !errors.As(err, new(*WriteError)) || errors.Is(err, ErrCancelled) {
+		t.Errorf("decoding without a writer ended with %v", err)
+	}
+
+	chStatus, chErrors = DecodeChangeEvents(context.Background(), strings.NewReader(`[{"detail-type": "x"}, 1]`), NullWriterFactory(), PoolSpec{Size: 1}, benchSpec)
+	if err := firstError(chStatus, chErrors, 1); !errors.Is(err, ErrNotObject) {
+		t.Errorf("events ended with %v, want ErrNotObject", err)
+	}
+}
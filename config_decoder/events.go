@@ -0,0 +1,222 @@
+package config_decoder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//Message types of the AWS Config notifications that carry a configuration item
+const (
+	ItemChangeNotification          = "ConfigurationItemChangeNotification"
+	OversizedItemChangeNotification = "OversizedConfigurationItemChangeNotification"
+)
+
+//ChangeEvent is an AWS Config notification, as EventBridge delivers it
+type ChangeEvent struct {
+	ID         string            `json:"id"`
+	DetailType string            `json:"detail-type"`
+	Source     string            `json:"source"`
+	Account    string            `json:"account"`
+	Time       string            `json:"time"`
+	Region     string            `json:"region"`
+	Detail     ChangeEventDetail `json:"detail"`
+}
+
+//ChangeEventDetail is the notification in a ChangeEvent
+// An oversized item's notification has only a summary of the item, which is delivered to S3.
+type ChangeEventDetail struct {
+	MessageType              string         `json:"messageType"`
+	NotificationCreationTime string         `json:"notificationCreationTime"`
+	RecordVersion            string         `json:"recordVersion"`
+	ConfigurationItem        map[string]any `json:"configurationItem"`
+	ConfigurationItemDiff    map[string]any `json:"configurationItemDiff"`
+	ConfigurationItemSummary map[string]any `json:"configurationItemSummary"`
+}
+
+//changeType is the change the event reports: CREATE, UPDATE or DELETE, or "" if it doesn't say
+func (e ChangeEvent) changeType() string {
+	if t, ok := e.Detail.ConfigurationItemDiff["changeType"].(string); ok {
+		return t
+	}
+	t, _ := e.Detail.ConfigurationItemSummary["changeType"].(string)
+	return t
+}
+
+//Item normalizes the event to an item shaped like a snapshot's, with the metadata of spec,
+// reporting whether it has one: only configuration item change notifications do.
+// The metadata's event_type is config_change, and its change_type the change reported.
+// An oversized item's summary stands in for the item. Provenance is added by the decoder.
+// It fails if a metadata field collides with the item's own under the CollisionError policy.
+func (e ChangeEvent) Item(spec ItemTransformSpec) (map[string]any, bool, error) {
+	var ci map[string]any
+	switch e.Detail.MessageType {
+	case ItemChangeNotification:
+		ci = e.Detail.ConfigurationItem
+	case OversizedItemChangeNotification:
+		ci = e.Detail.ConfigurationItemSummary
+	}
+	if ci == nil {
+		return nil, false, nil
+	}
+
+	item := make(map[string]any, len(ci)+8)
+	for k, v := range ci {
+		item[k] = v
+	}
+	if e.Detail.ConfigurationItemDiff != nil {
+		item["configurationItemDiff"] = e.Detail.ConfigurationItemDiff
+	}
+
+	metadata := newMetadata(spec)
+	metadata["event_type"] = "config_change"
+	metadata["event_source"] = e.Source
+	metadata["change_type"] = e.changeType()
+	metadata["config_event"] = map[string]string{
+		"id":                       e.ID,
+		"time":                     e.Time,
+		"messageType":              e.Detail.MessageType,
+		"notificationCreationTime": e.Detail.NotificationCreationTime,
+	}
+	if err := spec.Hash.stamp(item, nil); err != nil {
+		return nil, true, err
+	}
+	if err := spec.Envelope.wrap(item, metadata); err != nil {
+		return nil, true, err
+	}
+	return item, true, nil
+}
+
+//DecodeChangeEvents decodes a stream of AWS Config change events delivered by EventBridge, json
+// objects one after another or in an array, writing the item of each with the writer pool
+// Notifications without a configuration item are skipped. Items are stamped with spec's provenance:
+// source_index is the event's index in the stream, and the offsets are the event's. spec.Selection
+// chooses among the items; spec.Limits doesn't apply, as EventBridge events are at most 256 KB.
+// Decoding stops at the first malformed event, or when ctx is done.
+//
+// Deprecated: importers should use decode.DecodeEvents.
+func DecodeChangeEvents(ctx context.Context, r io.Reader, writerFactory WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+	cItems := make(chan map[string]any, 0)
+	// the decoder sends at most one error, so it never blocks if the caller has stopped listening
+	cErrors := make(chan error, 1)
+	sel := newSelector(spec.Selection)
+	ctx, stop := context.WithCancelCause(ctx)
+	pool := newWriterPool(ctx, writerFactory, poolSpec, cItems, nil, stop)
+
+	go func() {
+		defer close(cItems)
+		defer close(cErrors)
+
+		if err := spec.Selection.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeChangeEvents: %w", err)
+			return
+		}
+		if err := spec.Envelope.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeChangeEvents: %w", err)
+			return
+		}
+		if err := spec.Hash.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeChangeEvents: %w", err)
+			return
+		}
+		if err := spec.Rules.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeChangeEvents: %w", err)
+			return
+		}
+		err := decodeChangeEvents(ctx, r, spec, sel, cItems)
+		if errors.Is(err, errMaxItems) {
+			logger.Infof("stopped after %d items", spec.Selection.MaxItems)
+			return
+		}
+		if err != nil {
+			cErrors <- fmt.Errorf("DecodeChangeEvents: %w", err)
+			return
+		}
+		logger.Debug("decoder goroutine ended normally")
+	}()
+
+	return pool.chStatus, cErrors
+}
+
+//decodeChangeEvents decodes the events of r, sending their items to cItems
+func decodeChangeEvents(ctx context.Context, r io.Reader, spec ItemTransformSpec, sel *selector, cItems chan map[string]any) error {
+	br := bufio.NewReader(r)
+	inArray := false
+	// base counts the whitespace read before the decoder's input
+	var base int64
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			_, _ = br.ReadByte()
+			base++
+			continue
+		}
+		inArray = b[0] == '['
+		break
+	}
+
+	dec := json.NewDecoder(br)
+	if inArray {
+		if err := expect(dec, json.Delim('[')); err != nil {
+			return err
+		}
+	}
+
+	rules := newRuleChecker(spec)
+	// each event is scanned raw, so its offsets are exact
+	var raw json.RawMessage
+	for index := 0; ; index++ {
+		if ctx.Err() != nil {
+			return stopped(ctx)
+		}
+		if inArray && !dec.More() {
+			return expect(dec, json.Delim(']'))
+		}
+		if err := dec.Decode(&raw); err == io.EOF && !inArray {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("event %d: %w", index, err)
+		}
+		end := base + dec.InputOffset()
+
+		if raw[0] != '{' {
+			return fmt.Errorf("event %d: %w", index, ErrNotObject)
+		}
+		var event ChangeEvent
+		if err := unmarshal(raw, &event, spec.UseNumber); err != nil {
+			return fmt.Errorf("event %d: %w", index, err)
+		}
+		item, ok, err := event.Item(spec)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", index, err)
+		}
+		if !ok {
+			logger.Debugf("skipping event %d, a %s %q notification", index, event.DetailType, event.Detail.MessageType)
+			continue
+		}
+		if ok, err := sel.next(); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		if err := rules.check(item, index); err != nil {
+			return fmt.Errorf("event %d: %w", index, err)
+		}
+
+		if !spec.NoProvenance {
+			item["source_index"] = index
+			item["source_offset_start"] = end - int64(len(raw))
+			item["source_offset_end"] = end
+		}
+		cItems <- item
+	}
+}
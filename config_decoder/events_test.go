@@ -0,0 +1,120 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//changeEvents are EventBridge events of AWS Config: an update, a delivery notification without an
+// item, and an oversized item's deletion
+var changeEvents = []string{
+	`{"id":"e1","detail-type":"Config Configuration Item Change","source":"aws.config","time":"2026-10-15T08:14:22Z",` +
+		`"detail":{"messageType":"ConfigurationItemChangeNotification","configurationItemDiff":{"changeType":"UPDATE"},` +
+		`"configurationItem":{"resourceType":"AWS::EC2::Instance","resourceId":"i-1","configuration":{"state":"stopped"}}}}`,
+	`{"id":"e2","detail-type":"Config Configuration Snapshot Delivery Status","source":"aws.config",` +
+		`"detail":{"messageType":"ConfigurationSnapshotDeliveryCompleted","s3Bucket":"config-bucket"}}`,
+	`{"id":"e3","detail-type":"Config Configuration Item Change","source":"aws.config",` +
+		`"detail":{"messageType":"OversizedConfigurationItemChangeNotification",` +
+		`"configurationItemSummary":{"changeType":"DELETE","resourceType":"AWS::IAM::Role","resourceId":"r-1"}}}`,
+}
+
+func decodeEvents(t *testing.T, doc string, spec ItemTransformSpec) []map[string]any {
+	cw := &CollectorWriter{}
+	const poolSize = 2
+	chStatus, chErrors := DecodeChangeEvents(context.Background(), strings.NewReader(doc), CollectorWriterFactory(cw),
+		PoolSpec{Size: poolSize}, spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	for i := 0; i < poolSize; i++ {
+		<-chStatus
+	}
+	return cw.Items()
+}
+
+func TestDecodeChangeEvents(t *testing.T) {
+	docs := map[string]string{
+		"stream": "\n" + strings.Join(changeEvents, "\n") + "\n",
+		"array":  " [" + strings.Join(changeEvents, ",\n ") + "]",
+	}
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			items := decodeEvents(t, doc, ItemTransformSpec{Source: "events.json"})
+			if len(items) != 2 {
+				t.Fatalf("got %d items, want 2", len(items))
+			}
+
+			want := map[string]struct {
+				changeType string
+				index      int
+			}{"i-1": {"UPDATE", 0}, "r-1": {"DELETE", 2}}
+			for _, item := range items {
+				w, ok := want[item["resourceId"].(string)]
+				if !ok {
+					t.Fatalf("unexpected item %v", item)
+				}
+				if item["change_type"] != w.changeType || item["event_type"] != "config_change" || item["event_source"] != "aws.config" {
+					t.Errorf("%s: change_type %v, event_type %v, event_source %v", item["resourceId"],
+						item["change_type"], item["event_type"], item["event_source"])
+				}
+				if metadata, _ := item["metadata"].(map[string]any); metadata["source_file"] != "events.json" {
+					t.Errorf("%s: metadata %v has no source_file", item["resourceId"], metadata)
+				}
+
+				// the offsets locate the whole event
+				start, end := item["source_offset_start"].(int64), item["source_offset_end"].(int64)
+				if item["source_index"] != w.index || doc[start:end] != changeEvents[w.index] {
+					t.Errorf("%s: source_index %v, offsets %d-%d locate %q", item["resourceId"], item["source_index"], start, end, doc[start:end])
+				}
+			}
+		})
+	}
+}
+
+func TestChangeEventItem(t *testing.T) {
+	var event ChangeEvent
+	if err := json.Unmarshal([]byte(changeEvents[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	item, ok, err := event.Item(ItemTransformSpec{RunID: "run", NoProvenance: true})
+	if err != nil || !ok {
+		t.Fatal("change notification has no item")
+	}
+	if item["resourceType"] != "AWS::EC2::Instance" || item["configuration"] == nil || item["run_id"] != "run" {
+		t.Errorf("item %v isn't the configuration item with its metadata", item)
+	}
+	if diff, _ := item["configurationItemDiff"].(map[string]any); diff["changeType"] != "UPDATE" {
+		t.Errorf("item has configurationItemDiff %v", item["configurationItemDiff"])
+	}
+	if e, _ := item["config_event"].(map[string]string); e["id"] != "e1" || e["messageType"] != ItemChangeNotification {
+		t.Errorf("item has config_event %v", item["config_event"])
+	}
+
+	if err := json.Unmarshal([]byte(changeEvents[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if item, ok, _ := event.Item(ItemTransformSpec{}); ok {
+		t.Errorf("delivery notification has item %v", item)
+	}
+}
+
+func TestDecodeChangeEventsMalformed(t *testing.T) {
+	cw := &CollectorWriter{}
+	doc := changeEvents[0] + "\n" + changeEvents[2][:40]
+	chStatus, chErrors := DecodeChangeEvents(context.Background(), bytes.NewReader([]byte(doc)), CollectorWriterFactory(cw),
+		PoolSpec{Size: 1}, ItemTransformSpec{})
+	var err error
+	for e := range chErrors {
+		err = e
+	}
+	<-chStatus
+	if err == nil || !strings.Contains(err.Error(), "event 1") {
+		t.Errorf("got error %v, want one for event 1", err)
+	}
+	if n := cw.Count(); n != 1 {
+		t.Errorf("wrote %d items before the malformed event, want 1", n)
+	}
+}
@@ -0,0 +1,12 @@
+package config_decoder
+
+import "testing"
+
+//AddGoldenWriter adds a writer TestGolden decodes each input with, for packages importing this one,
+// whose writers' tests can't be in it
+func AddGoldenWriter(name string, writer func(t *testing.T) (WriterFactory, func() []byte)) {
+	goldenWriters = append(goldenWriters, struct {
+		name   string
+		writer goldenWriter
+	}{name, writer})
+}
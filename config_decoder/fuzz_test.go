@@ -0,0 +1,100 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+//snapshotSeeds are well-formed and damaged snapshot fragments used to seed the fuzz targets
+func snapshotSeeds() [][]byte {
+	good := benchSnapshot(3, 10)
+	return [][]byte{
+		good,
+		good[:len(good)/2],
+		good[:len(good)-1],
+		good[:len(good)-2],
+		[]byte(`{"fileVersion":"1.0","configurationItems":[null]}`),
+		[]byte(`{"fileVersion":{"a":1},"configurationItems":[]}`),
+		[]byte(`{"configurationItems":[1,"a",[]]}`),
+		[]byte(`{"configurationItems":{}}`),
+		[]byte(`{"configurationItems":[]} trailing`),
+		[]byte(`{"other":[{"a":[1,2,{"b":null}]}],"configurationItems":[{}]}`),
+		[]byte(`[]`),
+		[]byte(``),
+	}
+}
+
+func FuzzDecodeAndSplitItems(f *testing.F) {
+	for _, seed := range snapshotSeeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		const poolSize = 2
+		chStatus, chErrors := DecodeAndSplitItems(ctx, bytes.NewReader(data), NullWriterFactory(),
+			PoolSpec{Size: poolSize}, benchSpec)
+
+		errCount := 0
+		for range chErrors {
+			errCount++
+		}
+		if errCount > 1 {
+			t.Errorf("got %d errors, want at most 1", errCount)
+		}
+
+		for i := 0; i < poolSize; i++ {
+			select {
+			case <-chStatus:
+			case <-ctx.Done():
+				t.Fatal("writer pool did not finish")
+			}
+		}
+
+		if errCount == 0 && !json.Valid(bytes.TrimSpace(data)) {
+			t.Errorf("invalid document %q decoded without error", data)
+		}
+	})
+}
+
+func FuzzSkip(f *testing.F) {
+	for _, seed := range snapshotSeeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		err := skip(dec)
+
+		// skipping the only value of a valid document consumes it entirely
+		if json.Valid(data) {
+			if err != nil {
+				t.Fatalf("skip of valid document %q: %s", data, err)
+			}
+			if dec.More() {
+				t.Errorf("skip of valid document %q left more values", data)
+			}
+		}
+	})
+}
+
+func FuzzExpect(f *testing.F) {
+	for _, seed := range snapshotSeeds() {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		err := expect(json.NewDecoder(bytes.NewReader(data)), json.Delim('{'))
+
+		tok, tokErr := json.NewDecoder(bytes.NewReader(data)).Token()
+		want := tokErr == nil && tok == json.Delim('{')
+		if (err == nil) != want {
+			t.Errorf("expect('{') on %q returned %v, first token %v (%v)", data, err, tok, tokErr)
+		}
+	})
+}
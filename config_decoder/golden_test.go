@@ -0,0 +1,270 @@
+package config_decoder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/generator"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TestGolden from the current output")
+
+//goldenVolatile are the metadata fields that differ between runs, left out of golden output, from
+// the item and its metadata
+var goldenVolatile = []string{"ingest_time", "decoder_version"}
+
+//goldenInputs are the seeded documents TestGolden generates and decodes, each with its golden
+// output in testdata/golden/<name>.ndjson
+var goldenInputs = []struct {
+	name string
+	opts generator.Options
+}{
+	{"snapshot", generator.Options{Count: 25, Synthesize: true, Seed: 1,
+		Accounts: []string{"123456789012"}, Regions: []string{"us-east-1", "eu-west-1"}}},
+	{"history", generator.Options{Count: 25, Format: generator.FormatHistory, Seed: 2}},
+	{"mixed", generator.Options{Count: 25, Synthesize: true, Seed: 3, Oversized: 2, OversizedBytes: 1024,
+		Mix: []generator.MixEntry{{ResourceType: "AWS::EC2::Instance", Weight: 2}, {ResourceType: "AWS::SQS::Queue", Weight: 1}}}},
+}
+
+//goldenDecoder decodes doc with the writers of f
+type goldenDecoder func(ctx context.Context, doc []byte, f WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error)
+
+//goldenDecoders are the ways TestGolden decodes each input; they must all give the same items
+var goldenDecoders = []struct {
+	name   string
+	decode goldenDecoder
+}{
+	{"stream", func(ctx context.Context, doc []byte, f WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+		return DecodeAndSplitItems(ctx, bytes.NewReader(doc), f, poolSpec, spec)
+	}},
+	{"gzip", func(ctx context.Context, doc []byte, f WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		_, _ = zw.Write(doc)
+		_ = zw.Close()
+		zr, err := gzip.NewReader(&b)
+		if err != nil {
+			panic(err)
+		}
+		return DecodeAndSplitItems(ctx, zr, f, poolSpec, spec)
+	}},
+	{"parallel", func(ctx context.Context, doc []byte, f WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+		spec.Decoders = 3
+		return DecodeAndSplitItemsAt(ctx, bytes.NewReader(doc), int64(len(doc)), f, poolSpec, spec)
+	}},
+}
+
+//goldenWriter returns a factory of writers to a fake destination, and a func returning what was
+// written to it once decoding is done, as ndjson
+type goldenWriter func(t *testing.T) (WriterFactory, func() []byte)
+
+//goldenWriters are the writers TestGolden decodes each input with
+var goldenWriters = []struct {
+	name   string
+	writer goldenWriter
+}{
+	{"file", fileDestination},
+	{"opensearch", openSearchDestination},
+	{"collector", collectorDestination},
+}
+
+//lockedBuffer is a bytes.Buffer safe for the concurrent writes of a writer pool
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.b.Write(p)
+}
+
+//fileDestination collects the output of FileWriters
+func fileDestination(t *testing.T) (WriterFactory, func() []byte) {
+	var lb lockedBuffer
+	return FileWriterFactory(&lb, []byte{'\n'}), func() []byte { return lb.b.Bytes() }
+}
+
+//collectorDestination collects the items of a CollectorWriter
+func collectorDestination(t *testing.T) (WriterFactory, func() []byte) {
+	cw := &CollectorWriter{}
+	return CollectorWriterFactory(cw), func() []byte {
+		var b bytes.Buffer
+		for _, item := range cw.Items() {
+			line, err := json.Marshal(item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+		return b.Bytes()
+	}
+}
+
+//openSearchDestination collects the documents indexed by OpenSearchWriters in a fake cluster
+func openSearchDestination(t *testing.T) (WriterFactory, func() []byte) {
+	const index = "config-items"
+	var mu sync.Mutex
+	var docs bytes.Buffer
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/_index_template/"+index:
+			_, _ = io.WriteString(w, `{"acknowledged":true}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+			mu.Lock()
+			defer mu.Unlock()
+			for i := 0; i+1 < len(lines); i += 2 {
+				if want := `{"index":{"_index":"` + index + `"}}`; lines[i] != want {
+					http.Error(w, fmt.Sprintf("action %s, want %s", lines[i], want), http.StatusBadRequest)
+					return
+				}
+				docs.WriteString(lines[i+1] + "\n")
+			}
+			_, _ = io.WriteString(w, `{"errors":false,"items":[]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	f, err := OpenSearchWriterFactory(OpenSearchConfig{URL: srv.URL, Index: index, BatchSize: 7, Template: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, func() []byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return docs.Bytes()
+	}
+}
+
+//normalize returns items written as ndjson in a canonical form for comparison: without the volatile
+// fields, with sorted keys, one item a line, ordered by source offset as the pool writes them in any order
+func normalize(t *testing.T, ndjson []byte) []byte {
+	type line struct {
+		offset float64
+		b      []byte
+	}
+	var lines []line
+	sc := bufio.NewScanner(bytes.NewReader(ndjson))
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var item map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &item); err != nil {
+			t.Fatalf("written item is not json: %v: %s", err, sc.Bytes())
+		}
+		metadata, _ := item["metadata"].(map[string]any)
+		for _, k := range goldenVolatile {
+			delete(item, k)
+			delete(metadata, k)
+		}
+		b, err := json.Marshal(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset, _ := item["source_offset_start"].(float64)
+		lines = append(lines, line{offset, b})
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].offset < lines[j].offset })
+	var b bytes.Buffer
+	for _, l := range lines {
+		b.Write(l.b)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+//compareGolden fails t with the first difference between got and the golden file want
+func compareGolden(t *testing.T, golden string, got, want []byte) {
+	if bytes.Equal(got, want) {
+		return
+	}
+	gotLines, wantLines := bytes.Split(got, []byte{'\n'}), bytes.Split(want, []byte{'\n'})
+	for i := 0; i < len(gotLines) && i < len(wantLines); i++ {
+		if !bytes.Equal(gotLines[i], wantLines[i]) {
+			t.Fatalf("output differs from %s at item %d:\n got: %s\nwant: %s\n(go test -run TestGolden -update rewrites it)",
+				golden, i+1, gotLines[i], wantLines[i])
+		}
+	}
+	t.Fatalf("output has %d items, %s has %d (go test -run TestGolden -update rewrites it)",
+		len(gotLines)-1, golden, len(wantLines)-1)
+}
+
+//TestGolden decodes generated documents end to end, with every decoder and writer, comparing the
+// items written with golden files, so changes to writers or transforms can't silently alter output
+func TestGolden(t *testing.T) {
+	for _, in := range goldenInputs {
+		var doc bytes.Buffer
+		if _, err := generator.Write(&doc, in.opts); err != nil {
+			t.Fatalf("%s: %v", in.name, err)
+		}
+		golden := filepath.Join("testdata", "golden", in.name+".ndjson")
+		spec := benchSpec
+		spec.RunID, spec.Source = "golden", in.name+".json"
+
+		updated := false
+		for _, d := range goldenDecoders {
+			for _, w := range goldenWriters {
+				t.Run(fmt.Sprintf("%s/%s/%s", in.name, d.name, w.name), func(t *testing.T) {
+					ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+					defer cancel()
+
+					f, written := w.writer(t)
+					const poolSize = 3
+					chStatus, chErrors := d.decode(ctx, doc.Bytes(), f, PoolSpec{Size: poolSize, StopOnError: true}, spec)
+					for err := range chErrors {
+						t.Fatal(err)
+					}
+					for i := 0; i < poolSize; i++ {
+						if status := <-chStatus; status.ErrorCount > 0 {
+							t.Fatalf("worker %d: %d write errors", status.WorkerNum, status.ErrorCount)
+						}
+					}
+					got := normalize(t, written())
+
+					if *update && !updated {
+						if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+							t.Fatal(err)
+						}
+						if err := os.WriteFile(golden, got, 0o644); err != nil {
+							t.Fatal(err)
+						}
+						updated = true
+						return
+					}
+					want, err := os.ReadFile(golden)
+					if err != nil {
+						t.Fatalf("%v (go test -run TestGolden -update writes it)", err)
+					}
+					compareGolden(t, golden, got, want)
+				})
+			}
+		}
+	}
+}
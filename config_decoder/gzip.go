@@ -0,0 +1,18 @@
+package config_decoder
+
+import (
+	"github.com/klauspost/pgzip"
+	"io"
+)
+
+//NewGzipReader returns a reader decompressing r in parallel blocks
+// r may be a multi-member gzip stream, of concatenated gzip files, as S3 multipart uploads of separately
+// compressed parts and re-compressed snapshots are; every member is read, not just the first.
+func NewGzipReader(r io.Reader) (*pgzip.Reader, error) {
+	gz, err := pgzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	gz.Multistream(true)
+	return gz, nil
+}
@@ -0,0 +1,65 @@
+package config_decoder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/mfrasier/decode_json_stream/generator"
+	"io"
+	"testing"
+)
+
+//multiMember gzips doc as a multi-member stream of members of memberBytes, with an empty member at the end
+// as some re-compressors leave, returning it with its number of members
+func multiMember(t *testing.T, doc []byte, memberBytes int64) ([]byte, int) {
+	var b bytes.Buffer
+	mw := generator.NewMemberWriter(&b, memberBytes)
+	if _, err := mw.Write(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	empty := gzip.NewWriter(&b)
+	if err := empty.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes(), mw.Members() + 1
+}
+
+func TestGzipMembers(t *testing.T) {
+	var doc bytes.Buffer
+	if _, err := generator.Write(&doc, generator.Options{Count: 100, Synthesize: true, Seed: 5}); err != nil {
+		t.Fatal(err)
+	}
+	gzipped, members := multiMember(t, doc.Bytes(), 4096)
+	if members < 3 {
+		t.Fatalf("fixture has %d members, want several", members)
+	}
+
+	gz, err := NewGzipReader(bytes.NewReader(gzipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, doc.Bytes()) {
+		t.Fatalf("read %d bytes of %d members, want the %d bytes of the document", len(got), members, doc.Len())
+	}
+
+	gz, err = NewGzipReader(bytes.NewReader(gzipped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw := &CollectorWriter{}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), gz, CollectorWriterFactory(cw), PoolSpec{Size: 1}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	if cw.Count() != 100 {
+		t.Errorf("decoded %d items from %d members, want 100", cw.Count(), members)
+	}
+}
@@ -0,0 +1,82 @@
+package config_decoder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+//defaultHashFields are the fields an ItemHash hashes by default
+var defaultHashFields = []string{"configuration"}
+
+//ItemHash configures the content hash stamped on each item, so consumers can detect changed
+// configurations, and duplicates across consecutive snapshots, without diffing items
+// Field names the item's field holding the hash, without which items aren't hashed. The hash is the
+// hex-encoded SHA-256 of the canonical JSON of the item's Fields, by default its configuration: of the
+// field's value alone if there's one, and otherwise of an object of those present, so it's the same
+// however the item was decoded.
+type ItemHash struct {
+	Field  string
+	Fields []string
+}
+
+//Validate checks the fields hashed are named
+func (h ItemHash) Validate() error {
+	for _, f := range h.Fields {
+		if f == "" {
+			return fmt.Errorf("ItemHash: empty field name")
+		}
+	}
+	if h.Field == "" && len(h.Fields) > 0 {
+		return fmt.Errorf("ItemHash: Fields %v are hashed into no Field", h.Fields)
+	}
+	return nil
+}
+
+//stamp sets the hash field of item, if items are hashed
+// A configuration from configs, if items are hashed by theirs alone, is hashed once for all its items.
+func (h ItemHash) stamp(item map[string]any, configs *ConfigurationCache) error {
+	if h.Field == "" {
+		return nil
+	}
+	fields := h.Fields
+	if len(fields) == 0 {
+		fields = defaultHashFields
+	}
+
+	var v any
+	if len(fields) == 1 {
+		v = item[fields[0]]
+	} else {
+		hashed := make(map[string]any, len(fields))
+		for _, f := range fields {
+			if fv, ok := item[f]; ok {
+				hashed[f] = fv
+			}
+		}
+		v = hashed
+	}
+
+	var sum string
+	var err error
+	if len(fields) == 1 && fields[0] == configurationField {
+		sum, err = configs.hash(v, canonicalHash)
+	} else {
+		sum, err = canonicalHash(v)
+	}
+	if err != nil {
+		return fmt.Errorf("hashing %v: %w", fields, err)
+	}
+	item[h.Field] = sum
+	return nil
+}
+
+//canonicalHash returns the hex-encoded SHA-256 of the canonical JSON of v (see AppendCanonicalJSON)
+func canonicalHash(v any) (string, error) {
+	b, err := AppendCanonicalJSON(nil, v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
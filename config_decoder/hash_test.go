@@ -0,0 +1,62 @@
+package config_decoder
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+//hashedItems decodes doc with hash, returning the hashes of its items by resourceId
+func hashedItems(t *testing.T, doc string, hash ItemHash) map[string]any {
+	spec := benchSpec
+	spec.Hash = hash
+	cw := &CollectorWriter{}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), strings.NewReader(doc), CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	hashes := make(map[string]any)
+	for _, item := range cw.Items() {
+		hashes[item["resourceId"].(string)] = item[hash.Field]
+	}
+	return hashes
+}
+
+func TestItemHash(t *testing.T) {
+	// a and b have the same configuration, written differently; c's differs, and d's tags
+	doc := `{"fileVersion":"1.0","configSnapshotId":"x","configurationItems":[
+		{"resourceId":"a","configuration":{"size":8,"name":"<vol>","tags":["x","y"]},"tags":{"env":"prod"}},
+		{"resourceId":"b","configuration":{ "tags" : ["x","y"], "name":"<vol>", "size":8.0 },"tags":{"env":"prod"}},
+		{"resourceId":"c","configuration":{"size":16,"name":"<vol>","tags":["x","y"]},"tags":{"env":"prod"}},
+		{"resourceId":"d","configuration":{"size":8,"name":"<vol>","tags":["x","y"]},"tags":{"env":"dev"}}]}`
+
+	hashes := hashedItems(t, doc, ItemHash{Field: "config_hash"})
+	if hashes["a"] != hashes["b"] || hashes["a"] != hashes["d"] || hashes["a"] == hashes["c"] {
+		t.Errorf("configuration hashes %v, want a, b and d alike and c different", hashes)
+	}
+	// the SHA-256 of {"name":"<vol>","size":8,"tags":["x","y"]}
+	if want := "9ca0ad61ce45eac0e8640051fbee5d9413a34c7c78d2223a14e773f11ee6f000"; hashes["a"] != want {
+		t.Errorf("hash %v, want %s", hashes["a"], want)
+	}
+
+	hashes = hashedItems(t, doc, ItemHash{Field: "content_hash", Fields: []string{"configuration", "tags"}})
+	if hashes["a"] != hashes["b"] || hashes["a"] == hashes["c"] || hashes["a"] == hashes["d"] {
+		t.Errorf("configuration and tags hashes %v, want a and b alike and c and d different", hashes)
+	}
+
+	if hashes := hashedItems(t, doc, ItemHash{}); hashes["a"] != nil {
+		t.Errorf("hashed %v without a Field", hashes)
+	}
+}
+
+func TestItemHashValidate(t *testing.T) {
+	for _, h := range []ItemHash{{Field: "h", Fields: []string{""}}, {Fields: []string{"tags"}}} {
+		if err := h.Validate(); err == nil {
+			t.Errorf("%+v: no error", h)
+		}
+	}
+	if err := (ItemHash{Field: "h", Fields: []string{"configuration", "tags"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,75 @@
+package config_decoder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+//HTTPConfig configures the TLS and proxy of the HTTP clients of writers, e.g. OpenSearchConfig's
+// HTTPClient, for networks with their own certificate authorities and proxies
+// CAFile is a PEM file of certificate authorities trusted besides the system's. CertFile and KeyFile are
+// a PEM client certificate and its key, presented to servers that ask for one. InsecureSkipVerify skips
+// verifying servers' certificates; never in production.
+// Proxy is the url of the proxy requests go through, none for no proxy; by default it's that of
+// $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY.
+type HTTPConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	Proxy              string
+}
+
+//IsZero reports whether c leaves clients as they are by default
+func (c HTTPConfig) IsZero() bool {
+	return c == HTTPConfig{}
+}
+
+//Client returns an HTTP client configured by c whose requests time out after timeout, or never if 0
+func (c HTTPConfig) Client(timeout time.Duration) (*http.Client, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("HTTPConfig.Client: a client certificate needs both CertFile and KeyFile")
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPConfig.Client: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("HTTPConfig.Client: no certificates in %s", c.CAFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPConfig.Client: %w", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	switch c.Proxy {
+	case "":
+	case "none":
+		t.Proxy = nil
+	default:
+		u, err := url.Parse(c.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("HTTPConfig.Client: bad proxy url %q", c.Proxy)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Timeout: timeout, Transport: t}, nil
+}
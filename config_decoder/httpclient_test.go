@@ -0,0 +1,103 @@
+package config_decoder
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//writePEM writes a PEM block of type typ to a file in dir, returning its path
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestHTTPConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+	var peers int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers = len(r.TLS.PeerCertificates)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	plain, err := HTTPConfig{}.Client(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Get(srv.URL); err == nil {
+		t.Error("trusted the test server without its CA")
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+	cfg := HTTPConfig{
+		CAFile:   writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw),
+		CertFile: writePEM(t, dir, "client.pem", "CERTIFICATE", der),
+		KeyFile:  writePEM(t, dir, "client.key", "PRIVATE KEY", keyDER),
+	}
+	c, err := cfg.Client(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if peers != 1 {
+		t.Errorf("server saw %d client certificates, want 1", peers)
+	}
+
+	if _, err := (HTTPConfig{CertFile: cfg.CertFile}).Client(0); err == nil {
+		t.Error("no error for a certificate without a key")
+	}
+	if _, err := (HTTPConfig{CAFile: cfg.KeyFile}).Client(0); err == nil {
+		t.Error("no error for a CA file without certificates")
+	}
+}
+
+func TestHTTPConfigProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	c, err := HTTPConfig{Proxy: proxy.URL}.Client(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get("http://opensearch.internal:9200/_bulk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://opensearch.internal:9200/_bulk" {
+		t.Errorf("proxy got %q", proxied)
+	}
+
+	if _, err := (HTTPConfig{Proxy: "proxy:3128"}).Client(0); err == nil {
+		t.Error("no error for a proxy that isn't a url")
+	}
+}
@@ -0,0 +1,28 @@
+package config_decoder
+
+import "sync"
+
+//itemPool holds item maps for reuse across decoded items
+var itemPool = sync.Pool{
+	New: func() any {
+		return make(map[string]any)
+	},
+}
+
+//getItem returns an empty item map, reusing a released one if available
+func getItem() map[string]any {
+	return itemPool.Get().(map[string]any)
+}
+
+//ReleaseItem returns item to the pool for reuse by the decoder
+// The item must not be used after it is released.
+// The writer pool calls it after each write when PoolSpec.ReuseItems is set.
+func ReleaseItem(item map[string]any) {
+	if item == nil {
+		return
+	}
+	for k := range item {
+		delete(item, k)
+	}
+	itemPool.Put(item)
+}
@@ -0,0 +1,47 @@
+package config_decoder_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/internal/kafkatest"
+	"github.com/mfrasier/decode_json_stream/kafka"
+	"testing"
+)
+
+func init() {
+	config_decoder.AddGoldenWriter("kafka", kafkaDestination)
+}
+
+//kafkaDestination collects the records produced by Kafka Writers to a fake broker, checking each is
+// keyed by its item's resource id
+func kafkaDestination(t *testing.T) (config_decoder.WriterFactory, func() []byte) {
+	const topic = "config-items"
+	broker, err := kafkatest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = broker.Close() })
+
+	f, err := kafka.WriterFactory(kafka.Config{Brokers: []string{broker.Addr()}, Topic: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, func() []byte {
+		var b bytes.Buffer
+		for _, rec := range broker.Records(topic) {
+			var item struct {
+				ResourceID string `json:"resourceId"`
+			}
+			if err := json.Unmarshal(rec.Value, &item); err != nil {
+				t.Fatalf("record is not json: %v: %s", err, rec.Value)
+			}
+			if string(rec.Key) != item.ResourceID {
+				t.Errorf("record keyed %q, want its resource id %q", rec.Key, item.ResourceID)
+			}
+			b.Write(rec.Value)
+			b.WriteByte('\n')
+		}
+		return b.Bytes()
+	}
+}
@@ -0,0 +1,48 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+//ItemSize is the size of one item as written, identifying it by its resourceType, resourceId and ARN
+// File is the item's source, where items of several files are listed together.
+type ItemSize struct {
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	ARN          string `json:"arn,omitempty"`
+	File         string `json:"file,omitempty"`
+	Bytes        int64  `json:"bytes"`
+}
+
+//newItemSize identifies item, of size bytes
+func newItemSize(item map[string]any, size int64) ItemSize {
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	arn, _ := item["ARN"].(string)
+	return ItemSize{ResourceType: t, ResourceID: id, ARN: arn, Bytes: size}
+}
+
+//KeepLargest adds item to largest, a list of the n largest items, largest first, if it's among them
+func KeepLargest(largest []ItemSize, n int, item ItemSize) []ItemSize {
+	if n <= 0 || len(largest) == n && item.Bytes <= largest[n-1].Bytes {
+		return largest
+	}
+	i := sort.Search(len(largest), func(i int) bool { return largest[i].Bytes < item.Bytes })
+	largest = append(largest, ItemSize{})
+	copy(largest[i+1:], largest[i:])
+	largest[i] = item
+	if len(largest) > n {
+		largest = largest[:n]
+	}
+	return largest
+}
+
+//marshalledSize is the size of item marshalled to json, for writers that don't count what they write
+func marshalledSize(item map[string]any) int64 {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
@@ -0,0 +1,58 @@
+package config_decoder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestKeepLargest(t *testing.T) {
+	var largest []ItemSize
+	for i, size := range []int64{5, 1, 9, 3, 7} {
+		largest = KeepLargest(largest, 3, ItemSize{ResourceID: fmt.Sprint(i), Bytes: size})
+	}
+	var got []int64
+	for _, l := range largest {
+		got = append(got, l.Bytes)
+	}
+	if fmt.Sprint(got) != "[9 7 5]" {
+		t.Errorf("kept %v, want [9 7 5]", got)
+	}
+}
+
+func TestPoolLargest(t *testing.T) {
+	var items []string
+	for i, size := range []int{10, 300, 50, 200} {
+		items = append(items, fmt.Sprintf(`{"resourceType":"AWS::S3::Bucket","resourceId":"r%d","ARN":"arn:aws:s3:::r%d","configuration":{"pad":%q}}`,
+			i, i, strings.Repeat("x", size)))
+	}
+	doc := `{"fileVersion":"1.0","configSnapshotId":"x","configurationItems":[` + strings.Join(items, ",") + `]}`
+
+	writers := map[string]WriterFactory{
+		"marshalled": NullWriterFactory(),
+		"written":    FileWriterFactory(io.Discard, []byte{'\n'}),
+	}
+	for name, f := range writers {
+		t.Run(name, func(t *testing.T) {
+			chStatus, chErrors := DecodeAndSplitItems(context.Background(), strings.NewReader(doc), f,
+				PoolSpec{Size: 1, ReuseItems: true, Largest: 2}, benchSpec)
+			for err := range chErrors {
+				t.Fatal(err)
+			}
+			status := <-chStatus
+
+			if len(status.Largest) != 2 {
+				t.Fatalf("listed %d items, want 2", len(status.Largest))
+			}
+			first, second := status.Largest[0], status.Largest[1]
+			if first.ResourceID != "r1" || first.ARN != "arn:aws:s3:::r1" || second.ResourceID != "r3" {
+				t.Errorf("listed %+v, want r1 then r3", status.Largest)
+			}
+			if first.Bytes <= 300 || second.Bytes <= 200 || first.Bytes <= second.Bytes {
+				t.Errorf("sizes %d and %d aren't those of the items", first.Bytes, second.Bytes)
+			}
+		})
+	}
+}
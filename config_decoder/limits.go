@@ -0,0 +1,336 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+//OversizePolicy chooses what happens to items larger than ItemLimits.MaxItemSize
+type OversizePolicy string
+
+const (
+	// OversizeTruncate drops the item's largest fields until it fits, listing them in "truncatedFields"
+	OversizeTruncate OversizePolicy = "truncate"
+	// OversizeOffload saves the whole item to ItemLimits.OffloadDir and emits it truncated,
+	// with the saved file's path in "offloadedTo"
+	OversizeOffload OversizePolicy = "offload"
+	// OversizeDeadLetter appends the item to a dead-letter file in ItemLimits.OffloadDir and does not emit it
+	OversizeDeadLetter OversizePolicy = "deadletter"
+)
+
+//deadLetterFile is the name of the dead-letter file in ItemLimits.OffloadDir
+const deadLetterFile = "deadletter.ndjson"
+
+//ItemLimits bounds the memory used by decoded items
+// MaxItemSize is the largest item, in encoded bytes, emitted as is; 0 is unlimited.
+// Oversize is the policy applied to larger items.
+// OffloadDir is where offloaded and dead-lettered items are written.
+// MaxInFlight is the budget, in bytes, for items decoded but not yet written, or held by a circuit breaker;
+// 0 is unlimited. The decoder waits for writers to catch up when the budget is spent.
+// Quotas caps the items of each resourceType decoded from a document, such as 100000 for
+// AWS::EC2::NetworkInterface; OverQuota is the policy applied to the items over it, and QuotaSample
+// the 1 in N of them emitted by OverQuotaSample.
+// LazyFieldSize is the smallest field of an item, in encoded bytes, kept as a RawField rather than
+// decoded; 0 decodes every field. Items over MaxItemSize have the oversize policy applied first.
+type ItemLimits struct {
+	MaxItemSize   int
+	Oversize      OversizePolicy
+	OffloadDir    string
+	MaxInFlight   int64
+	Quotas        map[string]int64
+	OverQuota     OverQuotaPolicy
+	QuotaSample   int64
+	LazyFieldSize int
+}
+
+//enabled reports whether items must be measured as they are decoded
+func (l ItemLimits) enabled() bool {
+	return l.MaxItemSize > 0 || l.MaxInFlight > 0 || len(l.Quotas) > 0 || l.LazyFieldSize > 0
+}
+
+//Validate checks the limits are usable
+func (l ItemLimits) Validate() error {
+	if err := l.validateQuotas(); err != nil {
+		return err
+	}
+	if l.LazyFieldSize < 0 {
+		return fmt.Errorf("ItemLimits: lazy field size %d is negative", l.LazyFieldSize)
+	}
+	if l.MaxItemSize <= 0 {
+		return nil
+	}
+
+	switch l.Oversize {
+	case OversizeTruncate:
+	case OversizeOffload, OversizeDeadLetter:
+		if l.OffloadDir == "" {
+			return fmt.Errorf("ItemLimits: oversize policy %q requires an offload directory", l.Oversize)
+		}
+	default:
+		return fmt.Errorf("ItemLimits: unknown oversize policy %q", l.Oversize)
+	}
+	return nil
+}
+
+//itemGuard applies ItemLimits to items as they are decoded
+// It's safe for use by concurrent decoders.
+type itemGuard struct {
+	limits     ItemLimits
+	budget     *memoryBudget
+	quotas     *quotas
+	mu         sync.Mutex
+	deadLetter *os.File
+	count      int64
+}
+
+func newItemGuard(limits ItemLimits) *itemGuard {
+	g := &itemGuard{limits: limits, quotas: newQuotas(limits)}
+	if limits.MaxInFlight > 0 {
+		g.budget = newMemoryBudget(limits.MaxInFlight)
+	}
+	return g
+}
+
+//decode decodes the next item, applying the limits
+// A nil item with a nil error means the item was dead-lettered, or over its quota. src's configs, if
+// set, decodes the configuration of an item emitted whole; its fields kept as RawFields are read from
+// src's document, if it has one. Its source fields are held for writing verbatim if src's verbatim is set.
+func (g *itemGuard) decode(dec *json.Decoder, src itemSource) (map[string]any, error) {
+	n := atomic.AddInt64(&g.count, 1)
+
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var item map[string]any
+	var err error
+	if g.limits.MaxItemSize > 0 && len(raw) > g.limits.MaxItemSize {
+		item, err = g.oversize(raw, n, src.numbers)
+	} else if g.limits.LazyFieldSize > 0 && len(raw) >= g.limits.LazyFieldSize {
+		// the decoder's offset is just past the item
+		item, err = lazyItem(raw, g.limits.LazyFieldSize, src.base+dec.InputOffset()-int64(len(raw)), src)
+	} else {
+		item = getItem()
+		if src.configs != nil {
+			err = objectError(src.configs.unmarshal(raw, item, src.numbers))
+		} else {
+			err = objectError(unmarshal(raw, &item, src.numbers))
+		}
+		if err == nil && item == nil {
+			err = fmt.Errorf("%w: item is null", ErrNotObject)
+		}
+	}
+	if err != nil || item == nil {
+		return nil, err
+	}
+	if g.quotas != nil {
+		if item, err = g.overQuota(item, raw, n); err != nil || item == nil {
+			return nil, err
+		}
+	}
+	if src.verbatim {
+		if err := holdVerbatim(item, raw); err != nil {
+			ReleaseItem(item)
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+//oversize applies the oversize policy to raw, the <n>th item decoded, with its numbers as json.Numbers
+// if numbers is set
+func (g *itemGuard) oversize(raw json.RawMessage, n int64, numbers bool) (map[string]any, error) {
+	logger.Warnf("item %d is %d bytes, over the %d byte limit: %s",
+		n, len(raw), g.limits.MaxItemSize, g.limits.Oversize)
+
+	switch g.limits.Oversize {
+	case OversizeDeadLetter:
+		return nil, g.writeDeadLetter(raw, n)
+	case OversizeOffload:
+		path, err := g.offload(raw, n)
+		if err != nil {
+			return nil, err
+		}
+		item, err := truncateItem(raw, g.limits.MaxItemSize, numbers)
+		if err != nil {
+			return nil, err
+		}
+		item["offloadedTo"] = path
+		return item, nil
+	default:
+		return truncateItem(raw, g.limits.MaxItemSize, numbers)
+	}
+}
+
+//offload saves raw to its own file in the offload directory, returning the file's path
+// The file is named for the item's number and made unique, as the numbers of each input decoded into the
+// directory start again from 1.
+func (g *itemGuard) offload(raw json.RawMessage, n int64) (string, error) {
+	f, err := os.CreateTemp(g.limits.OffloadDir, fmt.Sprintf("item-%08d-*.json", n))
+	if err != nil {
+		return "", fmt.Errorf("itemGuard: offloading item %d: %w", n, err)
+	}
+	// CreateTemp's files are private to their owner
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return "", fmt.Errorf("itemGuard: offloading item %d: %w", n, err)
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return "", fmt.Errorf("itemGuard: offloading item %d: %w", n, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("itemGuard: offloading item %d: %w", n, err)
+	}
+	return f.Name(), nil
+}
+
+//writeDeadLetter appends raw to the dead-letter file
+func (g *itemGuard) writeDeadLetter(raw json.RawMessage, n int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.deadLetter == nil {
+		f, err := os.OpenFile(filepath.Join(g.limits.OffloadDir, deadLetterFile),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("itemGuard: opening dead-letter file: %w", err)
+		}
+		g.deadLetter = f
+	}
+
+	if _, err := g.deadLetter.Write(append(raw, '\n')); err != nil {
+		return fmt.Errorf("itemGuard: dead-lettering item %d: %w", n, err)
+	}
+	return nil
+}
+
+//close releases the guard's resources, logging the items over their quota
+func (g *itemGuard) close() error {
+	if g.quotas != nil {
+		if over := g.quotas.String(); over != "" {
+			logger.Warnf("items over their quota (%s): %s", g.limits.OverQuota, over)
+		}
+	}
+	if g.deadLetter != nil {
+		return g.deadLetter.Close()
+	}
+	return nil
+}
+
+//truncateItem decodes raw without its largest fields, dropping fields until it fits in max bytes, its
+// numbers as json.Numbers if numbers is set
+func truncateItem(raw json.RawMessage, max int, numbers bool) (map[string]any, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("item is null, want object")
+	}
+
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(fields[names[i]]) > len(fields[names[j]]) })
+
+	size := len(raw)
+	var dropped []any
+	for _, k := range names {
+		if size <= max {
+			break
+		}
+		size -= len(k) + len(fields[k]) + 4 // quotes, colon, comma
+		dropped = append(dropped, k)
+		delete(fields, k)
+	}
+
+	item := getItem()
+	for k, v := range fields {
+		var val any
+		if err := unmarshal(v, &val, numbers); err != nil {
+			return nil, err
+		}
+		item[k] = val
+	}
+	item["truncatedFields"] = dropped
+	return item, nil
+}
+
+//memoryBudget limits the bytes of items in flight between the decoder and the writers
+// An item is charged its inFlightSize as it's sent to the writers, and the same is freed when a writer is
+// done with it: once it's written, or fails to be, or a circuit breaker holding it spills it to disk.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+//acquire charges size bytes to the budget, waiting for room if it's spent, or until ctx is done
+// An item larger than the whole budget is admitted once nothing else is in flight.
+func (b *memoryBudget) acquire(ctx context.Context, size int64) error {
+	// ctx ending wakes the wait, as items held by a sink that never recovers may never be freed
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	defer stop()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.used > 0 && b.used+size > b.limit {
+		if ctx.Err() != nil {
+			return stopped(ctx)
+		}
+		b.cond.Wait()
+	}
+	b.used += size
+	return nil
+}
+
+//free returns size bytes to the budget
+// A nil budget is ignored, so writers needn't check for one.
+func (b *memoryBudget) free(size int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= size
+	b.cond.Broadcast()
+}
+
+//inFlightSize is the size item is charged to the budget: its length as it's formatted, which counts a
+// RawField as its size, with the json of RawFields held in memory and the source fields held to write it
+// verbatim
+// It's measured from the item alone, so it's the same when the item is sent and when it's freed.
+func inFlightSize(item map[string]any) int64 {
+	size := int64(len(fmt.Sprintf("%s", item)))
+	for _, v := range item {
+		if f, ok := v.(*RawField); ok && f.r == nil {
+			size += f.size
+		}
+	}
+	for _, f := range verbatimOf(item) {
+		size += int64(len(f.raw))
+	}
+	return size
+}
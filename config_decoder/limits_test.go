@@ -0,0 +1,363 @@
+package config_decoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//sizedSnapshot returns a config snapshot document of items with configurations of the sizes given
+func sizedSnapshot(sizes ...int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"fileVersion":"1.0","configSnapshotId":"0f1d63cc","configurationItems":[`)
+	for i, size := range sizes {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		_, _ = fmt.Fprintf(&b, `{"resourceId":"r-%d","resourceType":"AWS::EC2::Instance","configuration":{"blob":%q}}`,
+			i, strings.Repeat("x", size))
+	}
+	b.WriteString(`]}`)
+	return b.Bytes()
+}
+
+func TestOversize(t *testing.T) {
+	doc := sizedSnapshot(10, 2000, 10, 3000)
+
+	tests := []struct {
+		policy     OversizePolicy
+		emitted    []string
+		deadLetter []string
+	}{
+		{OversizeTruncate, []string{"r-0", "r-1", "r-2", "r-3"}, nil},
+		{OversizeOffload, []string{"r-0", "r-1", "r-2", "r-3"}, nil},
+		{OversizeDeadLetter, []string{"r-0", "r-2"}, []string{"r-1", "r-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			spec := benchSpec
+			spec.Limits = ItemLimits{MaxItemSize: 500, Oversize: tt.policy, OffloadDir: dir}
+
+			cw := &CollectorWriter{}
+			chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(doc),
+				CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+			for err := range chErrors {
+				t.Fatal(err)
+			}
+			<-chStatus
+
+			var emitted []string
+			for _, item := range cw.Items() {
+				id := item["resourceId"].(string)
+				emitted = append(emitted, id)
+				big := id == "r-1" || id == "r-3"
+
+				truncated, _ := item["truncatedFields"].([]any)
+				if big != (len(truncated) == 1 && truncated[0] == "configuration") {
+					t.Errorf("%s truncatedFields = %v", id, item["truncatedFields"])
+				}
+				if _, ok := item["configuration"]; ok == big {
+					t.Errorf("%s has configuration %t, want %t", id, ok, !big)
+				}
+
+				path, offloaded := item["offloadedTo"].(string)
+				if offloaded != (big && tt.policy == OversizeOffload) {
+					t.Errorf("%s offloadedTo = %v", id, item["offloadedTo"])
+				}
+				if offloaded {
+					var whole map[string]any
+					b, err := os.ReadFile(path)
+					if err != nil || json.Unmarshal(b, &whole) != nil || whole["resourceId"] != id || whole["configuration"] == nil {
+						t.Errorf("%s offloaded to %s as %.80s, %v", id, path, b, err)
+					}
+				}
+			}
+			if fmt.Sprint(emitted) != fmt.Sprint(tt.emitted) {
+				t.Errorf("emitted %v, want %v", emitted, tt.emitted)
+			}
+
+			var deadLetter []string
+			if f, err := os.Open(filepath.Join(dir, deadLetterFile)); err == nil {
+				s := bufio.NewScanner(f)
+				s.Buffer(nil, 1<<20)
+				for s.Scan() {
+					var item map[string]any
+					if err := json.Unmarshal(s.Bytes(), &item); err != nil {
+						t.Fatal(err)
+					}
+					deadLetter = append(deadLetter, item["resourceId"].(string))
+				}
+				f.Close()
+			}
+			if fmt.Sprint(deadLetter) != fmt.Sprint(tt.deadLetter) {
+				t.Errorf("dead-lettered %v, want %v", deadLetter, tt.deadLetter)
+			}
+		})
+	}
+}
+
+//TestOffloadInputs checks the items offloaded by inputs decoded into the same directory each keep their
+// own file, though each input's items are numbered from 1
+func TestOffloadInputs(t *testing.T) {
+	dir := t.TempDir()
+	spec := benchSpec
+	spec.Limits = ItemLimits{MaxItemSize: 500, Oversize: OversizeOffload, OffloadDir: dir}
+
+	blobs := map[string]int{}
+	for _, size := range []int{2000, 3000} {
+		cw := &CollectorWriter{}
+		chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(sizedSnapshot(size)),
+			CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+		for err := range chErrors {
+			t.Fatal(err)
+		}
+		<-chStatus
+		for _, item := range cw.Items() {
+			blobs[item["offloadedTo"].(string)] = size
+		}
+	}
+
+	if len(blobs) != 2 {
+		t.Fatalf("offloaded to %v, want a file for each input", blobs)
+	}
+	for path, size := range blobs {
+		var whole struct{ Configuration struct{ Blob string } }
+		b, err := os.ReadFile(path)
+		if err != nil || json.Unmarshal(b, &whole) != nil || len(whole.Configuration.Blob) != size {
+			t.Errorf("%s holds %.80s, %v; want the item with the %d byte configuration", path, b, err, size)
+		}
+	}
+}
+
+func TestOversizeValidate(t *testing.T) {
+	bad := []ItemLimits{
+		{MaxItemSize: 10, Oversize: "drop"},
+		{MaxItemSize: 10, Oversize: OversizeOffload},
+		{MaxItemSize: 10, Oversize: OversizeDeadLetter},
+	}
+	for _, l := range bad {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", l)
+		}
+	}
+}
+
+//usedBytes returns the bytes charged to b
+func (b *memoryBudget) usedBytes() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+func TestMemoryBudget(t *testing.T) {
+	b := newMemoryBudget(100)
+	// an item larger than the budget is admitted when nothing else is in flight
+	_ = b.acquire(context.Background(), 150)
+	b.free(150)
+
+	_ = b.acquire(context.Background(), 60)
+	acquired := make(chan bool)
+	go func() {
+		_ = b.acquire(context.Background(), 60)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired 120 bytes of a 100 byte budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.free(60)
+	<-acquired
+	if used := b.usedBytes(); used != 60 {
+		t.Errorf("used %d bytes, want 60", used)
+	}
+
+	// waiting for room ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := b.acquire(ctx, 60); !errors.Is(err, ErrCancelled) {
+		t.Errorf("acquire = %v, want it cancelled", err)
+	}
+	if used := b.usedBytes(); used != 60 {
+		t.Errorf("used %d bytes after a cancelled acquire, want 60", used)
+	}
+}
+
+func TestInFlightSize(t *testing.T) {
+	item := map[string]any{"resourceId": "r-1", "configuration": &RawField{raw: []byte(`{"a":1}`), size: 7}}
+	want := int64(len(fmt.Sprintf("%s", item))) + 7
+	if got := inFlightSize(item); got != want {
+		t.Errorf("inFlightSize = %d, want %d", got, want)
+	}
+
+	// a RawField left in the document isn't held
+	item["configuration"] = &RawField{r: bytes.NewReader(nil), size: 7}
+	if got := inFlightSize(item); got != want-7 {
+		t.Errorf("inFlightSize = %d, want %d", got, want-7)
+	}
+}
+
+//flakyWriter fails its first fails writes; it's safe for concurrent use
+type flakyWriter struct {
+	mu      sync.Mutex
+	fails   int
+	cw      CollectorWriter
+	onWrite func()
+}
+
+func (fw *flakyWriter) Write(item map[string]interface{}) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.onWrite != nil {
+		fw.onWrite()
+	}
+	if fw.fails > 0 {
+		fw.fails--
+		return errors.New("sink unavailable")
+	}
+	return fw.cw.Write(item)
+}
+
+//TestMemoryBudgetBreaker checks items held by an open breaker stay charged to the budget until they're
+// written, and that the breaker writes them once the sink recovers though the budget's spent, and no more
+// items arrive
+func TestMemoryBudgetBreaker(t *testing.T) {
+	items := make([]map[string]any, 10)
+	for i := range items {
+		items[i] = map[string]any{"resourceId": fmt.Sprintf("r-%d", i), "resourceType": "AWS::EC2::Instance"}
+	}
+	budget := newMemoryBudget(3 * inFlightSize(items[0]))
+
+	var usedOnRecovery int64 = -1
+	fw := &flakyWriter{fails: 2}
+	fw.onWrite = func() {
+		if fw.fails == 0 && usedOnRecovery < 0 {
+			usedOnRecovery = budget.usedBytes()
+		}
+	}
+
+	ch := make(chan map[string]any)
+	spec := PoolSpec{Size: 1, Breaker: BreakerConfig{Threshold: 1, Cooldown: 10 * time.Millisecond, BufferSize: 100}}
+	wp := newWriterPool(context.Background(), FactoryOf(func() ItemWriter { return fw }), spec, ch, budget, nil)
+
+	go func() {
+		for _, item := range items {
+			_ = budget.acquire(context.Background(), inFlightSize(item))
+			ch <- item
+		}
+		close(ch)
+	}()
+
+	select {
+	case status := <-wp.chStatus:
+		if status.ErrorCount != 0 || status.BreakerTrips == 0 {
+			t.Errorf("%d errors and %d trips, want none and some", status.ErrorCount, status.BreakerTrips)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the breaker didn't write the items it held with the budget spent")
+	}
+
+	if fw.cw.Count() != len(items) {
+		t.Errorf("wrote %d items, want %d", fw.cw.Count(), len(items))
+	}
+	if usedOnRecovery <= 0 {
+		t.Errorf("%d bytes in flight when the sink recovered, want the held items'", usedOnRecovery)
+	}
+	if used := budget.usedBytes(); used != 0 {
+		t.Errorf("%d bytes left in flight, want 0", used)
+	}
+}
+
+//TestMaxInFlight decodes with a budget of a byte, admitting an item at a time, through writers whose
+// items are held by an open breaker, reused, held as RawFields or to write verbatim
+func TestMaxInFlight(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ItemLimits
+		spec   func(*ItemTransformSpec)
+	}{
+		{"decoded", ItemLimits{MaxInFlight: 1}, nil},
+		{"lazy", ItemLimits{MaxInFlight: 1, LazyFieldSize: 50}, nil},
+		{"verbatim", ItemLimits{MaxInFlight: 1}, func(s *ItemTransformSpec) { s.Verbatim = true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := benchSpec
+			spec.Limits = tt.limits
+			if tt.spec != nil {
+				tt.spec(&spec)
+			}
+			fw := &flakyWriter{fails: 3}
+			poolSpec := PoolSpec{Size: 2, ReuseItems: true, Breaker: BreakerConfig{Threshold: 1, Cooldown: 5 * time.Millisecond, BufferSize: 4}}
+
+			done := make(chan bool)
+			go func() {
+				defer close(done)
+				chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(20, 100)),
+					FactoryOf(func() ItemWriter { return fw }), poolSpec, spec)
+				for err := range chErrors {
+					t.Error(err)
+				}
+				for i := 0; i < poolSpec.Size; i++ {
+					<-chStatus
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("decoding didn't finish")
+			}
+			if fw.cw.Count() != 20 {
+				t.Errorf("wrote %d items, want 20", fw.cw.Count())
+			}
+		})
+	}
+}
+
+//TestMaxInFlightBreakerNeverRecovers checks decoding ends with its context when the items a breaker holds
+// for a sink that never recovers have spent the budget, rather than the decoder waiting for room forever
+func TestMaxInFlightBreakerNeverRecovers(t *testing.T) {
+	spec := benchSpec
+	spec.Limits = ItemLimits{MaxInFlight: 1}
+	sw := &scriptedWriter{failures: failing(1, 100), unhealthy: true}
+	poolSpec := PoolSpec{Size: 1, Breaker: BreakerConfig{Threshold: 1, Cooldown: 10 * time.Millisecond, BufferSize: 100,
+		DrainTimeout: time.Hour}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan bool)
+	var errs []error
+	var status WorkerStatus
+	go func() {
+		defer close(done)
+		chStatus, chErrors := DecodeAndSplitItems(ctx, bytes.NewReader(benchSnapshot(20, 100)),
+			FactoryOf(func() ItemWriter { return sw }), poolSpec, spec)
+		for err := range chErrors {
+			errs = append(errs, err)
+		}
+		status = <-chStatus
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("decoding didn't end with its context")
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], ErrCancelled) || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("decoding errors %v, want it cancelled at the deadline", errs)
+	}
+	// the item held is failed, not left waiting for the sink
+	if status.ErrorCount == 0 || sw.cw.Count() != 0 {
+		t.Errorf("%d errors and %d items written, want the held item failed", status.ErrorCount, sw.cw.Count())
+	}
+}
@@ -0,0 +1,29 @@
+package config_decoder
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+//TraceLevel is for token-level detail, below zap's DebugLevel
+const TraceLevel = zapcore.DebugLevel - 1
+
+//logger receives the package's diagnostics; they're discarded unless SetLogger is called
+var logger = zap.NewNop().Sugar()
+
+//SetLogger sets the logger for the package's diagnostics
+// Fields skipped and the like are logged at DebugLevel, each token and item at TraceLevel,
+// and write errors at ErrorLevel. It must be called before decoding starts.
+func SetLogger(l *zap.Logger) {
+	logger = l.Sugar()
+}
+
+//tracef logs token-level detail at TraceLevel, formatting the message only if it's enabled
+func tracef(template string, args ...any) {
+	l := logger.Desugar()
+	if ce := l.Check(TraceLevel, ""); ce != nil {
+		ce.Message = fmt.Sprintf(template, args...)
+		ce.Write()
+	}
+}
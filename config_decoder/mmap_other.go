@@ -0,0 +1,14 @@
+//go:build !unix
+
+package config_decoder
+
+import "os"
+
+//OpenMmap reads the named file into memory where memory-mapping isn't supported
+func OpenMmap(name string) (*MappedFile, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &MappedFile{data: data}, nil
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package config_decoder
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+//OpenMmap memory-maps the named file for reading
+func OpenMmap(name string) (*MappedFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		return &MappedFile{}, nil
+	}
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("OpenMmap: %s is too large to map", name)
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("OpenMmap: %w", err)
+	}
+	return &MappedFile{data: data, unmap: syscall.Munmap}, nil
+}
@@ -0,0 +1,87 @@
+package config_decoder
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//nameFuncs are the functions of NameTemplates, besides text/template's own
+var nameFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"default": func(def string, v any) string {
+		if v == nil || v == "" {
+			return def
+		}
+		return fmt.Sprint(v)
+	},
+	"date": func(layout string, v any) (string, error) {
+		s, _ := v.(string)
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", fmt.Errorf("date: %q isn't an RFC 3339 time", s)
+		}
+		return t.UTC().Format(layout), nil
+	},
+}
+
+//NameTemplate names the destination of each item, a file path, Kafka topic or OpenSearch index, so
+// writers route items by their fields
+// It's a text/template executed with the item, so {{.awsAccountId}} is a field, {{.configuration.state.name}}
+// a nested one, and {{index . "awsRegion" | default "global"}} one that may be missing, which is otherwise
+// an error. Besides text/template's functions it has lower, upper, replace OLD NEW, trimPrefix P, trimSuffix S,
+// default D and date LAYOUT, formatting an RFC 3339 time with a Go layout, e.g.
+// {{date "2006.01" .configurationItemCaptureTime}}. Text without actions names every item the same.
+type NameTemplate struct {
+	text string
+	t    *template.Template
+}
+
+//ParseNameTemplate parses text as a NameTemplate
+func ParseNameTemplate(text string) (*NameTemplate, error) {
+	t, err := template.New("name").Funcs(nameFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("ParseNameTemplate: %w", err)
+	}
+	return &NameTemplate{text: text, t: t}, nil
+}
+
+//IsNameTemplate reports whether text has template actions, naming items by their fields
+func IsNameTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+//Static reports whether the template names every item the same
+func (nt *NameTemplate) Static() bool {
+	return !IsNameTemplate(nt.text)
+}
+
+//Prefix returns the text before the template's first action, which every name starts with
+func (nt *NameTemplate) Prefix() string {
+	prefix, _, _ := strings.Cut(nt.text, "{{")
+	return prefix
+}
+
+func (nt *NameTemplate) String() string {
+	return nt.text
+}
+
+//Name returns the name of item's destination
+func (nt *NameTemplate) Name(item map[string]interface{}) (string, error) {
+	if nt.Static() {
+		return nt.text, nil
+	}
+	var sb strings.Builder
+	if err := nt.t.Execute(&sb, item); err != nil {
+		return "", fmt.Errorf("NameTemplate: %w", err)
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("NameTemplate: %s names no destination for the item", nt.text)
+	}
+	return sb.String(), nil
+}
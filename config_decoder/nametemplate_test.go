@@ -0,0 +1,97 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNameTemplate(t *testing.T) {
+	item := map[string]any{
+		"resourceType":                 "AWS::EC2::Instance",
+		"awsAccountId":                 "123456789012",
+		"configurationItemCaptureTime": "2024-03-02T23:04:05.000Z",
+		"configuration":                map[string]any{"state": map[string]any{"name": "running"}},
+	}
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"config-items", "config-items"},
+		{"config-{{.awsAccountId}}", "config-123456789012"},
+		{`{{.resourceType | replace "::" "-" | lower}}`, "aws-ec2-instance"},
+		{`items-{{date "2006.01.02" .configurationItemCaptureTime}}`, "items-2024.03.02"},
+		{"{{.configuration.state.name}}", "running"},
+		{`{{index . "awsRegion" | default "global"}}`, "global"},
+		{`{{.resourceType | trimPrefix "AWS::" | upper}}`, "EC2::INSTANCE"},
+	} {
+		nt, err := ParseNameTemplate(tc.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := nt.Name(item); err != nil || got != tc.want {
+			t.Errorf("%s: got %q, %v, want %q", tc.text, got, err, tc.want)
+		}
+	}
+
+	for _, text := range []string{"{{.awsRegion}}", `{{date "2006" .awsAccountId}}`, `{{index . "awsRegion" | default ""}}`} {
+		nt, err := ParseNameTemplate(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, err := nt.Name(item); err == nil {
+			t.Errorf("%s: no error, named %q", text, name)
+		}
+	}
+	if _, err := ParseNameTemplate("{{.awsRegion"); err == nil {
+		t.Error("no error parsing an unclosed action")
+	}
+
+	nt, _ := ParseNameTemplate("config-{{.awsAccountId}}-items")
+	if nt.Static() || nt.Prefix() != "config-" {
+		t.Errorf("static %v, prefix %q", nt.Static(), nt.Prefix())
+	}
+}
+
+func TestRoutedFileWriter(t *testing.T) {
+	route, err := ParseNameTemplate("{{.awsRegion}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	files := make(map[string]*bytes.Buffer)
+	open := func(name string) (io.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if files[name] == nil {
+			files[name] = new(bytes.Buffer)
+		}
+		return files[name], nil
+	}
+
+	w, err := RoutedFileWriterFactory(route, open, FileEncoding{Termination: []byte("\n")})(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []map[string]any{
+		{"resourceId": "a", "awsRegion": "us-east-1"},
+		{"resourceId": "b", "awsRegion": "eu-west-1"},
+		{"resourceId": "c", "awsRegion": "us-east-1"},
+	} {
+		if err := w.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(map[string]any{"resourceId": "d"}); err == nil {
+		t.Error("no error routing an item without the field")
+	}
+
+	if got := strings.Count(files["us-east-1"].String(), "\n"); got != 2 || files["eu-west-1"].Len() == 0 {
+		t.Errorf("us-east-1 has %d items, eu-west-1 %q", got, files["eu-west-1"])
+	}
+	if w.(ByteCounter).BytesWritten() != int64(files["us-east-1"].Len()+files["eu-west-1"].Len()) {
+		t.Errorf("%d bytes written", w.(ByteCounter).BytesWritten())
+	}
+}
@@ -0,0 +1,258 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//Neo4jConfig configures Neo4jWriters
+// URL is the server's HTTP endpoint, e.g. http://localhost:7474; credentials for basic auth may be
+// given in its user info.
+// Database is the database written to, neo4j by default.
+// BatchSize is the number of items sent in each transaction.
+// HTTPClient, if set, sends the requests; by default requests time out after a minute.
+type Neo4jConfig struct {
+	URL        string
+	Database   string
+	BatchSize  int
+	HTTPClient *http.Client
+}
+
+//neo4jNodeStatement merges a batch of items as Resource nodes, keyed by resource type and id
+const neo4jNodeStatement = `UNWIND $rows AS row
+MERGE (r:Resource {resourceType: row.resourceType, resourceId: row.resourceId})
+SET r += row.properties`
+
+//neo4jEdgeStatement merges a batch of relationships of one type, given by %s, as edges between
+// Resource nodes, creating the nodes of resources not yet seen
+const neo4jEdgeStatement = "UNWIND $rows AS row\n" +
+	"MERGE (a:Resource {resourceType: row.fromType, resourceId: row.fromId})\n" +
+	"MERGE (b:Resource {resourceType: row.toType, resourceId: row.toId})\n" +
+	"ON CREATE SET b.resourceName = row.toName\n" +
+	"MERGE (a)-[e:`%s`]->(b)\n" +
+	"SET e.name = row.name"
+
+//neo4jIndexStatement indexes Resource nodes by their key, so merges don't scan every node
+const neo4jIndexStatement = `CREATE INDEX resource_key IF NOT EXISTS FOR (r:Resource) ON (r.resourceType, r.resourceId)`
+
+//neo4jClient is shared by the Neo4jWriters of one factory
+type neo4jClient struct {
+	cfg  Neo4jConfig
+	http *http.Client
+	mu   sync.Mutex
+	// indexed is set once the Resource index has been created
+	indexed bool
+}
+
+//Neo4jWriter is an ItemWriter that builds a resource graph in Neo4j with the HTTP Cypher API
+// Each item is merged as a Resource node, with its top-level scalar fields as properties, and each
+// of its relationships as an edge to the related resource, typed by the relationship's name, e.g.
+// IS_ATTACHED_TO_INSTANCE. Items are buffered until BatchSize are waiting, so Flush must be called
+// after the last Write; the writer pool does so when its items channel is closed.
+// A failed request fails the Write that sent it; the rest of the batch is kept and sent with the next
+// batch. A batch whose transaction Neo4j rolls back is reported as a BatchError, not retried.
+type Neo4jWriter struct {
+	client *neo4jClient
+	nodes  []map[string]any
+	edges  map[string][]map[string]any
+	batch  batch
+}
+
+//Neo4jWriterFactory creates Neo4jWriter objects sharing one connection pool
+func Neo4jWriterFactory(cfg Neo4jConfig) (WriterFactory, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("Neo4jWriterFactory: URL is required")
+	}
+	if cfg.Database == "" {
+		cfg.Database = "neo4j"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+
+	client := &neo4jClient{cfg: cfg, http: cfg.HTTPClient}
+	if client.http == nil {
+		client.http = &http.Client{Timeout: time.Minute}
+	}
+	return FactoryOf(func() ItemWriter {
+		return &Neo4jWriter{client: client, edges: make(map[string][]map[string]any), batch: batch{size: cfg.BatchSize}}
+	}), nil
+}
+
+// Write implements ItemWriter for Neo4jWriter
+func (nw *Neo4jWriter) Write(item map[string]interface{}) error {
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	if t == "" || id == "" {
+		return fmt.Errorf("Neo4jWriter: item has no resourceType and resourceId")
+	}
+
+	properties := make(map[string]any)
+	for k, v := range item {
+		switch v.(type) {
+		case string, bool, float64, json.Number:
+			properties[k] = v
+		}
+	}
+	nw.nodes = append(nw.nodes, map[string]any{"resourceType": t, "resourceId": id, "properties": properties})
+
+	added := make(map[string]int)
+	for _, r := range asList(item["relationships"]) {
+		rel, _ := r.(map[string]any)
+		toType, _ := rel["resourceType"].(string)
+		toID, _ := rel["resourceId"].(string)
+		if toType == "" || toID == "" {
+			continue
+		}
+		name, _ := rel["name"].(string)
+		if name == "" {
+			name, _ = rel["relationshipName"].(string)
+		}
+		relType := neo4jRelType(name)
+		nw.edges[relType] = append(nw.edges[relType], map[string]any{
+			"fromType": t, "fromId": id, "toType": toType, "toId": toID, "toName": rel["resourceName"], "name": name,
+		})
+		added[relType]++
+	}
+
+	return nw.batch.add(nw.Flush, func() {
+		nw.nodes = nw.nodes[:len(nw.nodes)-1]
+		for relType, n := range added {
+			nw.edges[relType] = nw.edges[relType][:len(nw.edges[relType])-n]
+		}
+	})
+}
+
+//neo4jRelType is the relationship type of a relationship's name, e.g. IS_ATTACHED_TO_INSTANCE for
+// "Is attached to Instance", or RELATED_TO if it has none
+func neo4jRelType(name string) string {
+	relType := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, strings.TrimSpace(name))
+	if relType == "" {
+		return "RELATED_TO"
+	}
+	return relType
+}
+
+// BytesWritten implements ByteCounter for Neo4jWriter, counting the requests of committed transactions
+func (nw *Neo4jWriter) BytesWritten() int64 {
+	return nw.batch.written
+}
+
+// Flush implements Flusher for Neo4jWriter, sending any buffered items in one transaction
+// The items are kept for another attempt if the request couldn't be made.
+func (nw *Neo4jWriter) Flush() error {
+	n := nw.batch.n
+	err := nw.batch.flush(nw.commit, func() {
+		nw.nodes = nw.nodes[:0]
+		nw.edges = make(map[string][]map[string]any)
+	})
+	if err != nil {
+		return fmt.Errorf("Neo4jWriter: transaction of %d items: %w", n, err)
+	}
+	return nil
+}
+
+//commit runs the buffered items' transaction, returning the size of its request and whether a failure
+// should be retried
+func (nw *Neo4jWriter) commit() (int64, bool, error) {
+	if err := nw.client.ensureIndex(); err != nil {
+		return 0, true, err
+	}
+
+	// nodes are merged first, so edges find them with their properties set
+	statements := []map[string]any{{"statement": neo4jNodeStatement, "parameters": map[string]any{"rows": nw.nodes}}}
+	relTypes := make([]string, 0, len(nw.edges))
+	for relType := range nw.edges {
+		relTypes = append(relTypes, relType)
+	}
+	sort.Strings(relTypes)
+	for _, relType := range relTypes {
+		statements = append(statements, map[string]any{
+			"statement":  fmt.Sprintf(neo4jEdgeStatement, relType),
+			"parameters": map[string]any{"rows": nw.edges[relType]},
+		})
+	}
+	body, err := json.Marshal(map[string]any{"statements": statements})
+	if err != nil {
+		return 0, false, err
+	}
+	retry, err := nw.client.commit(body)
+	return int64(len(body)), retry, err
+}
+
+// Healthy implements HealthChecker for Neo4jWriter
+func (nw *Neo4jWriter) Healthy() error {
+	_, err := nw.client.commit([]byte(`{"statements":[]}`))
+	return err
+}
+
+//ensureIndex creates the Resource index, if not already created
+func (c *neo4jClient) ensureIndex() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.indexed {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]any{"statements": []map[string]string{{"statement": neo4jIndexStatement}}})
+	if err != nil {
+		return err
+	}
+	if _, err := c.commit(body); err != nil {
+		return fmt.Errorf("index: %w", err)
+	}
+	c.indexed = true
+	return nil
+}
+
+//commit runs the statements of body in a transaction, reporting whether it should be retried
+func (c *neo4jClient) commit(body []byte) (bool, error) {
+	path := "/db/" + c.cfg.Database + "/tx/commit"
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode >= 300 {
+		return true, fmt.Errorf("POST %s: %s: %s", path, resp.Status, bytes.TrimSpace(b))
+	}
+
+	var result struct {
+		Errors []struct {
+			Code    string
+			Message string
+		}
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return false, fmt.Errorf("response: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return false, fmt.Errorf("rolled back: %s: %s", result.Errors[0].Code, result.Errors[0].Message)
+	}
+	return false, nil
+}
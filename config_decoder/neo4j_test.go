@@ -0,0 +1,149 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//neo4jStatement is a statement of a transaction received by a fake Neo4j server
+type neo4jStatement struct {
+	Statement  string
+	Parameters struct {
+		Rows []map[string]any
+	}
+}
+
+//neo4jServer fakes Neo4j's transaction endpoint, collecting the statements committed; a statement
+// containing fail is rolled back
+func neo4jServer(t *testing.T, fail string) (*httptest.Server, func() []neo4jStatement) {
+	var mu sync.Mutex
+	var committed []neo4jStatement
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/db/graph/tx/commit" {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		var tx struct{ Statements []neo4jStatement }
+		if err := json.Unmarshal(b, &tx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, s := range tx.Statements {
+			if fail != "" && strings.Contains(s.Statement, fail) {
+				_, _ = io.WriteString(w, `{"results":[],"errors":[{"code":"Neo.ClientError.Statement.SyntaxError","message":"bad"}]}`)
+				return
+			}
+		}
+		mu.Lock()
+		committed = append(committed, tx.Statements...)
+		mu.Unlock()
+		_, _ = io.WriteString(w, `{"results":[],"errors":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []neo4jStatement {
+		mu.Lock()
+		defer mu.Unlock()
+		return committed
+	}
+}
+
+func TestNeo4jWriter(t *testing.T) {
+	srv, committed := neo4jServer(t, "")
+	f, err := Neo4jWriterFactory(Neo4jConfig{URL: srv.URL, Database: "graph", BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []map[string]any{
+		{"resourceType": "AWS::EC2::Volume", "resourceId": "vol-1", "encrypted": true, "configuration": map[string]any{"size": 8.0},
+			"relationships": []any{map[string]any{"resourceType": "AWS::EC2::Instance", "resourceId": "i-1", "name": "Is attached to Instance"}}},
+		{"resourceType": "AWS::EC2::Instance", "resourceId": "i-1", "awsRegion": "us-east-1",
+			"relationships": []any{map[string]any{"resourceType": "AWS::EC2::VPC", "resourceId": "vpc-1", "relationshipName": "Is contained in Vpc"},
+				map[string]any{"resourceType": "AWS::EC2::SecurityGroup", "resourceName": "no id"}}},
+		{"resourceType": "AWS::EC2::VPC", "resourceId": "vpc-1"},
+	}
+	for _, item := range items {
+		if err := w.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.(Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var nodes []string
+	edges := make(map[string]int)
+	for _, s := range committed() {
+		switch {
+		case strings.HasPrefix(s.Statement, "CREATE INDEX"):
+		case strings.Contains(s.Statement, "SET r += row.properties"):
+			for _, row := range s.Parameters.Rows {
+				nodes = append(nodes, row["resourceId"].(string))
+				if props := row["properties"].(map[string]any); props["configuration"] != nil || props["resourceId"] != row["resourceId"] {
+					t.Errorf("node properties %v", props)
+				}
+			}
+		default:
+			for _, relType := range []string{"IS_ATTACHED_TO_INSTANCE", "IS_CONTAINED_IN_VPC"} {
+				if strings.Contains(s.Statement, "`"+relType+"`") {
+					edges[relType] += len(s.Parameters.Rows)
+				}
+			}
+		}
+	}
+	if strings.Join(nodes, " ") != "vol-1 i-1 vpc-1" {
+		t.Errorf("nodes %v", nodes)
+	}
+	if len(edges) != 2 || edges["IS_ATTACHED_TO_INSTANCE"] != 1 || edges["IS_CONTAINED_IN_VPC"] != 1 {
+		t.Errorf("edges %v", edges)
+	}
+	if w.(ByteCounter).BytesWritten() == 0 {
+		t.Error("no bytes written")
+	}
+}
+
+func TestNeo4jWriterRolledBack(t *testing.T) {
+	srv, committed := neo4jServer(t, "MERGE (a)")
+	f, err := Neo4jWriterFactory(Neo4jConfig{URL: srv.URL, Database: "graph", BatchSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := f(context.Background(), 0)
+	item := map[string]any{"resourceType": "AWS::EC2::Volume", "resourceId": "vol-1",
+		"relationships": []any{map[string]any{"resourceType": "AWS::EC2::Instance", "resourceId": "i-1"}}}
+	for i := 0; i < 2; i++ {
+		if err := w.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the items of the transaction all fail, and its request isn't counted as written
+	var be *BatchError
+	if err := w.(Flusher).Flush(); !errors.As(err, &be) || be.Items != 2 || !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("Flush error %v, want a rolled back BatchError of 2 items", err)
+	}
+	if w.(ByteCounter).BytesWritten() != 0 {
+		t.Errorf("%d bytes written by a rolled back transaction", w.(ByteCounter).BytesWritten())
+	}
+	// a rolled back batch isn't retried
+	if err := w.(Flusher).Flush(); err != nil {
+		t.Error(err)
+	}
+	if len(committed()) != 1 {
+		t.Errorf("committed %d statements, want only the index", len(committed()))
+	}
+
+	if err := w.Write(map[string]any{"resourceType": "AWS::EC2::Volume"}); err == nil {
+		t.Error("no error writing an item without a resourceId")
+	}
+}
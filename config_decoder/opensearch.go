@@ -0,0 +1,278 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//OpenSearchConfig configures OpenSearchWriters
+// URL is the cluster endpoint; credentials for basic auth may be given in its user info.
+// Index is the index items are written to, or a NameTemplate naming each item's index.
+// BatchSize is the number of items sent in each bulk request.
+// Template, if set, creates or updates an index template for Index, or the indices starting with a
+// templated Index's prefix, before the first bulk write, so fields are mapped correctly from the first
+// document.
+// Sign, if set, signs each request, whose body is body, e.g. with Signature Version 4 for Amazon
+// OpenSearch Service.
+// HTTPClient, if set, sends the requests, e.g. to trust a test cluster's certificate; by default
+// requests time out after a minute.
+type OpenSearchConfig struct {
+	URL        string
+	Index      string
+	BatchSize  int
+	Template   bool
+	Sign       func(req *http.Request, body []byte) error
+	HTTPClient *http.Client
+}
+
+//itemTemplateMappings are the index template mappings for configuration items
+// The configuration of different resource types varies widely, so it's mapped as a single
+// flat_object field, OpenSearch's equivalent of a flattened field, instead of thousands of fields.
+var itemTemplateMappings = map[string]any{
+	"properties": map[string]any{
+		"configurationItemCaptureTime": map[string]string{"type": "date"},
+		"resourceCreationTime":         map[string]string{"type": "date"},
+		"ingest_time":                  map[string]string{"type": "date"},
+		"decoder_version":              map[string]string{"type": "keyword"},
+		"run_id":                       map[string]string{"type": "keyword"},
+		"source_file":                  map[string]string{"type": "keyword"},
+		"source_offset_start":          map[string]string{"type": "long"},
+		"source_offset_end":            map[string]string{"type": "long"},
+		"source_index":                 map[string]string{"type": "long"},
+		"resourceType":                 map[string]string{"type": "keyword"},
+		"resourceId":                   map[string]string{"type": "keyword"},
+		"awsRegion":                    map[string]string{"type": "keyword"},
+		"awsAccountId":                 map[string]string{"type": "keyword"},
+		"arn":                          map[string]string{"type": "keyword"},
+		"configuration":                map[string]string{"type": "flat_object"},
+		"supplementaryConfiguration":   map[string]string{"type": "flat_object"},
+	},
+}
+
+//openSearchClient is shared by the OpenSearchWriters of one factory
+type openSearchClient struct {
+	cfg  OpenSearchConfig
+	http *http.Client
+	mu   sync.Mutex
+	// route names the index of each item if Index is a template
+	route *NameTemplate
+	// templated is set once the index template has been put
+	templated bool
+}
+
+//OpenSearchWriter is an ItemWriter that indexes items in OpenSearch with the bulk API, in Index or the
+// index it names for each item
+// Items are buffered until BatchSize are waiting, so Flush must be called after the last Write;
+// the writer pool does so when its items channel is closed.
+// A failed bulk request fails the Write that sent it; the rest of the batch is kept and sent
+// with the next batch. Items rejected individually by OpenSearch are reported as a BatchError, not retried.
+type OpenSearchWriter struct {
+	client *openSearchClient
+	action []byte
+	buf    *bytes.Buffer
+	batch  batch
+	// written counts the bytes of the bulk requests' lines, as each item's are buffered
+	written int64
+}
+
+//OpenSearchWriterFactory creates OpenSearchWriter objects sharing one connection pool
+func OpenSearchWriterFactory(cfg OpenSearchConfig) (WriterFactory, error) {
+	if cfg.URL == "" || cfg.Index == "" {
+		return nil, fmt.Errorf("OpenSearchWriterFactory: URL and Index are required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+
+	client := &openSearchClient{cfg: cfg, http: cfg.HTTPClient}
+	if client.http == nil {
+		client.http = &http.Client{Timeout: time.Minute}
+	}
+	if IsNameTemplate(cfg.Index) {
+		route, err := ParseNameTemplate(cfg.Index)
+		if err != nil {
+			return nil, fmt.Errorf("OpenSearchWriterFactory: %w", err)
+		}
+		if cfg.Template && strings.TrimRight(route.Prefix(), "-_.") == "" {
+			return nil, fmt.Errorf("OpenSearchWriterFactory: Template requires a templated Index with a prefix")
+		}
+		client.route = route
+	}
+	action, err := indexAction(cfg.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	return FactoryOf(func() ItemWriter {
+		return &OpenSearchWriter{client: client, action: action, buf: new(bytes.Buffer), batch: batch{size: cfg.BatchSize}}
+	}), nil
+}
+
+//indexAction returns the bulk action line indexing an item in index
+func indexAction(index string) ([]byte, error) {
+	action, err := json.Marshal(map[string]any{"index": map[string]string{"_index": index}})
+	if err != nil {
+		return nil, err
+	}
+	return append(action, '\n'), nil
+}
+
+// Write implements ItemWriter for OpenSearchWriter
+func (ow *OpenSearchWriter) Write(item map[string]interface{}) error {
+	action := ow.action
+	if ow.client.route != nil {
+		index, err := ow.client.route.Name(item)
+		if err != nil {
+			return fmt.Errorf("OpenSearchWriter: %w", err)
+		}
+		if action, err = indexAction(index); err != nil {
+			return err
+		}
+	}
+	start := ow.buf.Len()
+	ow.buf.Write(action)
+	b, err := json.Marshal(item)
+	if err != nil {
+		ow.buf.Truncate(start)
+		return err
+	}
+	ow.buf.Write(b)
+	ow.buf.WriteByte('\n')
+	ow.written += int64(ow.buf.Len() - start)
+
+	return ow.batch.add(ow.Flush, func() {
+		ow.written -= int64(ow.buf.Len() - start)
+		ow.buf.Truncate(start)
+	})
+}
+
+// BytesWritten implements ByteCounter for OpenSearchWriter
+func (ow *OpenSearchWriter) BytesWritten() int64 {
+	return ow.written
+}
+
+// Flush implements Flusher for OpenSearchWriter, sending any buffered items
+// The items are kept for another attempt if the request couldn't be made.
+func (ow *OpenSearchWriter) Flush() error {
+	return ow.batch.flush(func() (int64, bool, error) {
+		if err := ow.client.ensureTemplate(); err != nil {
+			return 0, true, err
+		}
+		retry, err := ow.client.bulk(ow.buf.Bytes(), ow.batch.n)
+		return int64(ow.buf.Len()), retry, err
+	}, ow.buf.Reset)
+}
+
+// Healthy implements HealthChecker for OpenSearchWriter
+func (ow *OpenSearchWriter) Healthy() error {
+	_, err := ow.client.do(http.MethodGet, "/_cluster/health", nil)
+	return err
+}
+
+//ensureTemplate puts the index template, if configured and not already put
+func (c *openSearchClient) ensureTemplate() error {
+	if !c.cfg.Template {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.templated {
+		return nil
+	}
+
+	name := c.cfg.Index
+	if c.route != nil {
+		name = strings.TrimRight(c.route.Prefix(), "-_.")
+	}
+	body, err := json.Marshal(map[string]any{
+		"index_patterns": []string{name + "*"},
+		"template":       map[string]any{"mappings": itemTemplateMappings},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(http.MethodPut, "/_index_template/"+name, body); err != nil {
+		return fmt.Errorf("OpenSearchWriter: index template: %w", err)
+	}
+	c.templated = true
+	return nil
+}
+
+//bulk sends a bulk request of n items, reporting whether it should be retried
+func (c *openSearchClient) bulk(body []byte, n int) (bool, error) {
+	b, err := c.do(http.MethodPost, "/_bulk", body)
+	if err != nil {
+		return true, fmt.Errorf("OpenSearchWriter: bulk request of %d items: %w", n, err)
+	}
+
+	var resp struct {
+		Errors bool
+		Items  []map[string]struct {
+			Status int
+			Error  json.RawMessage
+		}
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return false, fmt.Errorf("OpenSearchWriter: bulk response: %w", err)
+	}
+	if !resp.Errors {
+		return false, nil
+	}
+
+	failed := 0
+	var first json.RawMessage
+	for _, item := range resp.Items {
+		for _, r := range item {
+			if r.Status >= 300 {
+				failed++
+				if first == nil {
+					first = r.Error
+				}
+			}
+		}
+	}
+	return false, &BatchError{Items: failed, Err: fmt.Errorf("OpenSearchWriter: %d of %d items failed, first: %s", failed, n, first)}
+}
+
+//do makes a request to the cluster, returning the response body
+func (c *openSearchClient) do(method, path string, body []byte) ([]byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.cfg.URL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if c.cfg.Sign != nil {
+		if err := c.cfg.Sign(req, body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(b))
+	}
+	return b, nil
+}
@@ -0,0 +1,101 @@
+package config_decoder
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//PoolStats are the live counters of a writer pool's workers, for reporting throughput while decoding runs
+// A WorkerStatus is only sent once its worker ends; Snapshot reports every worker as of now. Pools
+// given the same PoolStats in their PoolSpec total their workers by number, as a run summary does.
+//
+// Deprecated: importers should use pool.Stats.
+type PoolStats struct {
+	mu      sync.Mutex
+	workers map[int]*workerCounters
+}
+
+//workerCounters are one worker's counters, updated by the worker as it writes each item
+type workerCounters struct {
+	items   atomic.Int64
+	bytes   atomic.Int64
+	errors  atomic.Int64
+	trips   atomic.Int64
+	running atomic.Int32
+	busy    atomic.Bool
+	// start is the time the worker first started, in Unix nanoseconds
+	start atomic.Int64
+	// active is the time spent running before the current run, in nanoseconds
+	active atomic.Int64
+	// since is the time the current run started, in Unix nanoseconds
+	since atomic.Int64
+	// clock tells the times of the current run, that of the pool it's in; it's guarded by PoolStats.mu
+	clock Clock
+}
+
+//NewPoolStats creates a PoolStats with no workers
+//
+// Deprecated: importers should use pool.NewStats.
+func NewPoolStats() *PoolStats {
+	return &PoolStats{workers: make(map[int]*workerCounters)}
+}
+
+//worker returns the counters of worker, noting that it has started at start, as clock tells it
+func (ps *PoolStats) worker(worker int, start time.Time, clock Clock) *workerCounters {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	wc, ok := ps.workers[worker]
+	if !ok {
+		wc = &workerCounters{}
+		wc.start.Store(start.UnixNano())
+		ps.workers[worker] = wc
+	}
+	if wc.running.Add(1) == 1 {
+		wc.since.Store(start.UnixNano())
+		wc.clock = clock
+	}
+	return wc
+}
+
+//end notes that a run of the worker has ended
+func (wc *workerCounters) end(end time.Time) {
+	if wc.running.Add(-1) == 0 {
+		wc.active.Add(end.UnixNano() - wc.since.Load())
+	}
+}
+
+//Snapshot returns the status of each worker so far, in order of worker number
+// Duration is the time the worker has been running, over every pool, so ItemCount / Duration is its
+// throughput. Status is "busy" while it's writing an item, "waiting" for one, or "idle" when none
+// of the pools it's in is running. BreakerTrips are counted once a worker ends, and ResourceTypes
+// aren't totalled. Times are told by the Clock of the pool a worker is running in.
+func (ps *PoolStats) Snapshot() []WorkerStatus {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	snapshot := make([]WorkerStatus, 0, len(ps.workers))
+	for n, wc := range ps.workers {
+		status := WorkerStatus{
+			WorkerNum:    n,
+			ItemCount:    int(wc.items.Load()),
+			ByteCount:    int(wc.bytes.Load()),
+			ErrorCount:   int(wc.errors.Load()),
+			BreakerTrips: int(wc.trips.Load()),
+			StartTime:    time.Unix(0, wc.start.Load()).UTC().Format(time.RFC3339Nano),
+			Duration:     time.Duration(wc.active.Load()),
+			Status:       "idle",
+		}
+		if wc.running.Load() > 0 {
+			status.Duration += wc.clock.now().Sub(time.Unix(0, wc.since.Load()))
+			status.Status = "waiting"
+			if wc.busy.Load() {
+				status.Status = "busy"
+			}
+		}
+		snapshot = append(snapshot, status)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].WorkerNum < snapshot[j].WorkerNum })
+	return snapshot
+}
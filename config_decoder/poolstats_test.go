@@ -0,0 +1,112 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//gatedWriter blocks each write until it's let through
+type gatedWriter struct {
+	gate chan struct{}
+}
+
+func (gw gatedWriter) Write(item map[string]interface{}) error {
+	<-gw.gate
+	return nil
+}
+
+//waitFor polls stats until ok holds of its snapshot
+func waitFor(t *testing.T, stats *PoolStats, ok func([]WorkerStatus) bool) []WorkerStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot := stats.Snapshot()
+		if ok(snapshot) {
+			return snapshot
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats never reached the expected state: %+v", snapshot)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	gate := make(chan struct{})
+	stats := NewPoolStats()
+	spec := PoolSpec{Size: 2, Stats: stats}
+	f := FactoryOf(func() ItemWriter { return gatedWriter{gate} })
+
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(5, 10)), f, spec, benchSpec)
+
+	// both workers are stuck writing their first item
+	waitFor(t, stats, func(s []WorkerStatus) bool {
+		return len(s) == 2 && s[0].Status == "busy" && s[1].Status == "busy"
+	})
+	gate <- struct{}{}
+	waitFor(t, stats, func(s []WorkerStatus) bool { return s[0].ItemCount+s[1].ItemCount == 3 })
+
+	close(gate)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	var ended int
+	for i := 0; i < spec.Size; i++ {
+		ended += (<-chStatus).ItemCount
+	}
+
+	snapshot := waitFor(t, stats, func(s []WorkerStatus) bool { return s[0].Status == "idle" && s[1].Status == "idle" })
+	if live := snapshot[0].ItemCount + snapshot[1].ItemCount; live != 5 || ended != 5 {
+		t.Errorf("stats counted %d items, the ended workers %d, want 5", live, ended)
+	}
+	if snapshot[0].Duration <= 0 {
+		t.Errorf("worker 0 ran for %s", snapshot[0].Duration)
+	}
+
+	// another pool's workers are totalled with the first's
+	chStatus, chErrors = DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(4, 10)), NullWriterFactory(), spec, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	for i := 0; i < spec.Size; i++ {
+		<-chStatus
+	}
+	snapshot = stats.Snapshot()
+	if n := snapshot[0].ItemCount + snapshot[1].ItemCount; n != 9 {
+		t.Errorf("stats counted %d items over both pools, want 9", n)
+	}
+}
+
+//TestPoolStatsClock checks the workers' live times are told by the pool's Clock
+func TestPoolStatsClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var elapsed atomic.Int64
+	clock := Clock(func() time.Time { return start.Add(time.Duration(elapsed.Load())) })
+
+	gate := make(chan struct{})
+	stats := NewPoolStats()
+	spec := PoolSpec{Size: 1, Stats: stats, Clock: clock}
+	f := FactoryOf(func() ItemWriter { return gatedWriter{gate} })
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(2, 10)), f, spec, benchSpec)
+
+	waitFor(t, stats, func(s []WorkerStatus) bool { return len(s) == 1 && s[0].Status == "busy" })
+	elapsed.Store(int64(10 * time.Second))
+	snapshot := stats.Snapshot()
+	if snapshot[0].StartTime != start.Format(time.RFC3339Nano) || snapshot[0].Duration != 10*time.Second {
+		t.Errorf("running worker started %s, ran for %s, want %s and 10s by the pool's clock", snapshot[0].StartTime,
+			snapshot[0].Duration, start.Format(time.RFC3339Nano))
+	}
+
+	elapsed.Store(int64(15 * time.Second))
+	close(gate)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	snapshot = waitFor(t, stats, func(s []WorkerStatus) bool { return s[0].Status == "idle" })
+	if snapshot[0].Duration != 15*time.Second {
+		t.Errorf("ended worker ran for %s, want 15s by the pool's clock", snapshot[0].Duration)
+	}
+}
@@ -0,0 +1,432 @@
+package config_decoder
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//ItemProto is the proto3 definition of configuration items, the ConfigurationItem message
+// AppendProtoItem encodes; it's generated from the message's descriptor, so protoc can generate
+// consumers' code from it, and registered with schema registries as is.
+//
+//go:embed configuration_item.proto
+var ItemProto string
+
+//protoField is a field of the ConfigurationItem message, the item field it's set from, and its kind
+type protoField struct {
+	name    string
+	item    string
+	kind    int
+	comment string
+}
+
+const (
+	protoKindString = iota
+	protoKindInt64
+	protoKindStringMap
+	protoKindStruct
+	protoKindList
+)
+
+//protoItemFields are the fields of the ConfigurationItem message, numbered from 1 in order
+var protoItemFields = []protoField{
+	{"resource_type", "resourceType", protoKindString, ""},
+	{"resource_id", "resourceId", protoKindString, ""},
+	{"resource_name", "resourceName", protoKindString, ""},
+	{"arn", "ARN", protoKindString, "The resource's ARN, from either ARN or arn."},
+	{"aws_account_id", "awsAccountId", protoKindString, ""},
+	{"aws_region", "awsRegion", protoKindString, ""},
+	{"availability_zone", "availabilityZone", protoKindString, ""},
+	{"configuration_item_capture_time", "configurationItemCaptureTime", protoKindString, ""},
+	{"configuration_item_status", "configurationItemStatus", protoKindString, ""},
+	{"resource_creation_time", "resourceCreationTime", protoKindString, ""},
+	{"configuration_item_version", "configurationItemVersion", protoKindString, ""},
+	{"configuration_state_id", "configurationStateId", protoKindInt64, ""},
+	{"tags", "tags", protoKindStringMap, "Tags, whose values that aren't strings are json text."},
+	{"configuration", "configuration", protoKindStruct, ""},
+	{"supplementary_configuration", "supplementaryConfiguration", protoKindStruct, ""},
+	{"relationships", "relationships", protoKindList, ""},
+	{"metadata", "metadata", protoKindStruct, ""},
+	{"extra", "", protoKindStruct,
+		"The item's fields that have no field above, or whose values don't fit theirs, by their own names."},
+}
+
+//protoItemPackage is the package of the ConfigurationItem message, the namespace of the Avro schema
+const protoItemPackage = "com.amazonaws.config"
+
+//itemFileDescriptor returns the descriptor of the file defining the ConfigurationItem message
+func itemFileDescriptor() *descriptorpb.FileDescriptorProto {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String("ConfigurationItem")}
+	for i, f := range protoItemFields {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(f.name),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		switch f.kind {
+		case protoKindString:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		case protoKindInt64:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		case protoKindStringMap:
+			entry := &descriptorpb.DescriptorProto{
+				Name: proto.String("TagsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{Name: proto.String("key"), Number: proto.Int32(1), JsonName: proto.String("key"),
+						Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:  descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+					{Name: proto.String("value"), Number: proto.Int32(2), JsonName: proto.String("value"),
+						Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+						Type:  descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}
+			msg.NestedType = append(msg.NestedType, entry)
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = proto.String("." + protoItemPackage + ".ConfigurationItem.TagsEntry")
+		case protoKindStruct:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = proto.String(".google.protobuf.Struct")
+		case protoKindList:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = proto.String(".google.protobuf.ListValue")
+		}
+		msg.Field = append(msg.Field, fd)
+	}
+
+	return &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("configuration_item.proto"),
+		Package:     proto.String(protoItemPackage),
+		Dependency:  []string{"google/protobuf/struct.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{msg},
+		Syntax:      proto.String("proto3"),
+	}
+}
+
+//itemMessage is the descriptor of the ConfigurationItem message
+var itemMessage = func() protoreflect.MessageDescriptor {
+	fd, err := protodesc.NewFile(itemFileDescriptor(), protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("ConfigurationItem descriptor: %v", err))
+	}
+	return fd.Messages().Get(0)
+}()
+
+//ItemMessageDescriptor returns the descriptor of the ConfigurationItem message, so its messages can be
+// read without generated code, e.g. with dynamicpb
+func ItemMessageDescriptor() protoreflect.MessageDescriptor {
+	return itemMessage
+}
+
+//protoFieldsByItem are the fields of the ConfigurationItem message by the item fields they're set from
+var protoFieldsByItem = func() map[string]protoField {
+	m := make(map[string]protoField, len(protoItemFields)+1)
+	for _, f := range protoItemFields {
+		if f.item != "" {
+			m[f.item] = f
+		}
+	}
+	m["arn"] = m["ARN"]
+	return m
+}()
+
+//AppendProtoItem appends the protobuf encoding of item, a ConfigurationItem message, to b
+// Fields the message has no field for, and ones whose values don't fit theirs, e.g. a configuration
+// that isn't an object, are kept in its extra Struct, so no field of the item is lost. Scalars
+// written to string fields are formatted as strings.
+func AppendProtoItem(b []byte, item map[string]any) ([]byte, error) {
+	m := dynamicpb.NewMessage(itemMessage)
+	fields := itemMessage.Fields()
+	var extra map[string]any
+
+	// in key order, so the first of ARN and arn is set, and the other kept in extra, consistently
+	keys := make([]string, 0, len(item))
+	for k := range item {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := item[k]
+		if v == nil {
+			continue
+		}
+		f, ok := protoFieldsByItem[k]
+		if ok {
+			fd := fields.ByName(protoreflect.Name(f.name))
+			ok = !m.Has(fd) && setProtoField(m, fd, f.kind, v)
+		}
+		if !ok {
+			if extra == nil {
+				extra = make(map[string]any)
+			}
+			extra[k] = v
+		}
+	}
+
+	if extra != nil {
+		s, err := protoStruct(extra)
+		if err != nil {
+			return nil, fmt.Errorf("AppendProtoItem: extra: %w", err)
+		}
+		m.Set(fields.ByName("extra"), protoreflect.ValueOfMessage(s.ProtoReflect()))
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.MarshalAppend(b, m)
+	if err != nil {
+		return nil, fmt.Errorf("AppendProtoItem: %w", err)
+	}
+	return b, nil
+}
+
+//AppendDelimitedProtoItem appends item's ConfigurationItem message to b, prefixed by its length as a
+// varint, as protobuf streams of messages are delimited, e.g. by Java's writeDelimitedTo
+func AppendDelimitedProtoItem(b []byte, item map[string]any) ([]byte, error) {
+	msg, err := AppendProtoItem(nil, item)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendVarint(b, uint64(len(msg)))
+	return append(b, msg...), nil
+}
+
+//setProtoField sets fd of m, of kind, from v, reporting whether v fits it
+func setProtoField(m *dynamicpb.Message, fd protoreflect.FieldDescriptor, kind int, v any) bool {
+	if kind != protoKindString && kind != protoKindInt64 {
+		var err error
+		if v, err = decodedJSON(v); err != nil {
+			return false
+		}
+	}
+	switch kind {
+	case protoKindString:
+		switch v.(type) {
+		case map[string]any, []any:
+			return false
+		}
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		m.Set(fd, protoreflect.ValueOfString(s))
+	case protoKindInt64:
+		n, ok := protoInt(v)
+		if !ok {
+			return false
+		}
+		m.Set(fd, protoreflect.ValueOfInt64(n))
+	case protoKindStringMap:
+		tags, ok := protoTags(v)
+		if !ok {
+			return false
+		}
+		mv := m.Mutable(fd).Map()
+		for k, tv := range tags {
+			mv.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(tv))
+		}
+	case protoKindStruct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		s, err := protoStruct(obj)
+		if err != nil {
+			return false
+		}
+		m.Set(fd, protoreflect.ValueOfMessage(s.ProtoReflect()))
+	case protoKindList:
+		arr, ok := v.([]any)
+		if !ok {
+			return false
+		}
+		l, err := protoList(arr)
+		if err != nil {
+			return false
+		}
+		m.Set(fd, protoreflect.ValueOfMessage(l.ProtoReflect()))
+	}
+	return true
+}
+
+//protoInt returns v as an int64, if it's an integer or a string of one
+func protoInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+//protoTags returns tags, a json object or, as in query results, an array of key and value pairs,
+// as a map of strings; values that aren't strings are json encoded
+func protoTags(v any) (map[string]string, bool) {
+	m, ok := v.(map[string]any)
+	if pairs, isArray := v.([]any); isArray {
+		m, ok = make(map[string]any, len(pairs)), true
+		for _, p := range pairs {
+			kv, isObject := p.(map[string]any)
+			k, isString := kv["key"].(string)
+			if !isObject || !isString {
+				return nil, false
+			}
+			m[k] = kv["value"]
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	tags := make(map[string]string, len(m))
+	for k, tv := range m {
+		s, isString := tv.(string)
+		if !isString {
+			js, err := json.Marshal(tv)
+			if err != nil {
+				return nil, false
+			}
+			s = string(js)
+		}
+		tags[k] = s
+	}
+	return tags, true
+}
+
+//protoValue returns v, a decoded json value, as a google.protobuf.Value
+func protoValue(v any) (*structpb.Value, error) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewNumberValue(f), nil
+	case map[string]any:
+		s, err := protoStruct(v)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewStructValue(s), nil
+	case []any:
+		l, err := protoList(v)
+		if err != nil {
+			return nil, err
+		}
+		return structpb.NewListValue(l), nil
+	}
+	pv, err := structpb.NewValue(v)
+	if err != nil {
+		// e.g. the metadata envelope's map[string]string
+		if v, err = decodedJSON(v); err != nil {
+			return nil, err
+		}
+		return protoValue(v)
+	}
+	return pv, nil
+}
+
+//decodedJSON returns v as decoding its json gives it, if it's of a type decoding json doesn't give,
+// so values of other Go types, e.g. the metadata envelope's map[string]string, convert as their json does
+// Its numbers are json.Numbers, so a RawField's are as they're written.
+func decodedJSON(v any) (any, error) {
+	switch v.(type) {
+	case nil, bool, float64, string, json.Number, map[string]any, []any:
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	if err := unmarshal(b, &decoded, true); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func protoStruct(obj map[string]any) (*structpb.Struct, error) {
+	s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(obj))}
+	for k, v := range obj {
+		pv, err := protoValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		s.Fields[k] = pv
+	}
+	return s, nil
+}
+
+func protoList(arr []any) (*structpb.ListValue, error) {
+	l := &structpb.ListValue{Values: make([]*structpb.Value, len(arr))}
+	for i, v := range arr {
+		pv, err := protoValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %w", i, err)
+		}
+		l.Values[i] = pv
+	}
+	return l, nil
+}
+
+//protoText returns the proto3 definition of the file fd describes, as ItemProto holds it
+func protoText(fd *descriptorpb.FileDescriptorProto) string {
+	var sb strings.Builder
+	sb.WriteString("// Code generated from the ConfigurationItem descriptor in protobuf.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&sb, "syntax = %q;\n\npackage %s;\n\n", fd.GetSyntax(), fd.GetPackage())
+	for _, dep := range fd.Dependency {
+		fmt.Fprintf(&sb, "import %q;\n", dep)
+	}
+
+	for _, msg := range fd.MessageType {
+		fmt.Fprintf(&sb, "\n// A configuration item recorded by AWS Config.\nmessage %s {\n", msg.GetName())
+		// map fields are of the message's nested entry types
+		entries := make(map[string]bool)
+		for _, nested := range msg.NestedType {
+			entries["."+fd.GetPackage()+"."+msg.GetName()+"."+nested.GetName()] = nested.GetOptions().GetMapEntry()
+		}
+		for i, f := range msg.Field {
+			if c := protoItemFields[i].comment; c != "" {
+				fmt.Fprintf(&sb, "  // %s\n", c)
+			}
+			var typ string
+			switch f.GetType() {
+			case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+				typ = "string"
+			case descriptorpb.FieldDescriptorProto_TYPE_INT64:
+				typ = "int64"
+			case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+				typ = strings.TrimPrefix(f.GetTypeName(), ".")
+				if entries[f.GetTypeName()] {
+					typ = "map<string, string>"
+				}
+			}
+			fmt.Fprintf(&sb, "  %s %s = %d;\n", typ, f.GetName(), f.GetNumber())
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
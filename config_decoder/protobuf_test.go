@@ -0,0 +1,117 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"os"
+	"testing"
+)
+
+//TestItemProto checks configuration_item.proto is the definition generated from the ConfigurationItem
+// descriptor; -update rewrites it
+func TestItemProto(t *testing.T) {
+	text := protoText(itemFileDescriptor())
+	if *update {
+		if err := os.WriteFile("configuration_item.proto", []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if text != ItemProto {
+		t.Errorf("configuration_item.proto is stale, rewrite it with go test -run TestItemProto -update:\n%s", text)
+	}
+}
+
+func TestAppendProtoItem(t *testing.T) {
+	item := map[string]any{
+		"resourceType": "AWS::S3::Bucket", "resourceId": "logs", "ARN": "arn:aws:s3:::logs", "arn": "other",
+		"awsRegion": "us-east-1", "configurationStateId": json.Number("1700000000000"),
+		"tags":                       []any{map[string]any{"key": "env", "value": "prod"}, map[string]any{"key": "n", "value": 2.0}},
+		"configuration":              map[string]any{"versioning": map[string]any{"status": "Enabled"}, "size": json.Number("12")},
+		"relationships":              []any{map[string]any{"resourceId": "key-1"}},
+		"metadata":                   map[string]any{"config_snapshot": map[string]string{"fileVersion": "1.0"}},
+		"supplementaryConfiguration": "not an object",
+		"event_type":                 "config_snapshot",
+		"run_id":                     7,
+		"resourceName":               nil,
+	}
+	b, err := AppendProtoItem([]byte{0xff}, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != 0xff {
+		t.Fatal("AppendProtoItem didn't append")
+	}
+
+	m := dynamicpb.NewMessage(ItemMessageDescriptor())
+	if err := proto.Unmarshal(b[1:], m); err != nil {
+		t.Fatal(err)
+	}
+	fields := ItemMessageDescriptor().Fields()
+	get := func(name string) any { return m.Get(fields.ByName(protoreflect.Name(name))).Interface() }
+	for name, want := range map[string]any{
+		"resource_type": "AWS::S3::Bucket", "arn": "arn:aws:s3:::logs", "aws_region": "us-east-1",
+		"configuration_state_id": int64(1700000000000), "resource_name": "",
+	} {
+		if got := get(name); got != want {
+			t.Errorf("%s is %v, want %v", name, got, want)
+		}
+	}
+	tags := m.Get(fields.ByName("tags")).Map()
+	if tags.Len() != 2 || tags.Get(protoreflect.ValueOfString("n").MapKey()).String() != "2" {
+		t.Errorf("tags are %v", tags)
+	}
+
+	var config structpb.Struct
+	if err := proto.Unmarshal(mustMarshal(t, m.Get(fields.ByName("configuration")).Message().Interface()), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Fields["versioning"].GetStructValue().Fields["status"].GetStringValue() != "Enabled" || config.Fields["size"].GetNumberValue() != 12 {
+		t.Errorf("configuration is %v", &config)
+	}
+
+	var metadata structpb.Struct
+	if err := proto.Unmarshal(mustMarshal(t, m.Get(fields.ByName("metadata")).Message().Interface()), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Fields["config_snapshot"].GetStructValue().Fields["fileVersion"].GetStringValue() != "1.0" {
+		t.Errorf("metadata is %v", &metadata)
+	}
+
+	var extra structpb.Struct
+	if err := proto.Unmarshal(mustMarshal(t, m.Get(fields.ByName("extra")).Message().Interface()), &extra); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"arn", "supplementaryConfiguration", "event_type", "run_id"} {
+		if _, ok := extra.Fields[k]; !ok {
+			t.Errorf("extra has no %s: %v", k, &extra)
+		}
+	}
+	if _, ok := extra.Fields["resourceName"]; ok {
+		t.Error("extra has the null resourceName")
+	}
+
+	again, _ := AppendProtoItem(nil, item)
+	if string(again) != string(b[1:]) {
+		t.Error("encoding the same item again differs")
+	}
+	delimited, err := AppendDelimitedProtoItem(nil, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, size := protowire.ConsumeVarint(delimited); int(n) != len(again) || string(delimited[size:]) != string(again) {
+		t.Errorf("delimited message has length %d of %d", n, len(again))
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
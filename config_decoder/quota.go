@@ -0,0 +1,128 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//OverQuotaPolicy chooses what happens to items of a resourceType over its quota in ItemLimits.Quotas
+type OverQuotaPolicy string
+
+const (
+	// OverQuotaDrop skips the item
+	OverQuotaDrop OverQuotaPolicy = "drop"
+	// OverQuotaSample emits one in every ItemLimits.QuotaSample items over the quota, skipping the rest
+	OverQuotaSample OverQuotaPolicy = "sample"
+	// OverQuotaDeadLetter appends the item to the dead-letter file in ItemLimits.OffloadDir
+	OverQuotaDeadLetter OverQuotaPolicy = "deadletter"
+)
+
+//validateQuotas checks the quotas of l are usable
+func (l ItemLimits) validateQuotas() error {
+	if len(l.Quotas) == 0 {
+		return nil
+	}
+	for resourceType, quota := range l.Quotas {
+		if quota < 0 {
+			return fmt.Errorf("ItemLimits: quota %d of %s is negative", quota, resourceType)
+		}
+	}
+
+	switch l.OverQuota {
+	case OverQuotaDrop:
+	case OverQuotaSample:
+		if l.QuotaSample < 1 {
+			return fmt.Errorf("ItemLimits: over quota policy %q requires a sample of 1 in 1 or more items", l.OverQuota)
+		}
+	case OverQuotaDeadLetter:
+		if l.OffloadDir == "" {
+			return fmt.Errorf("ItemLimits: over quota policy %q requires an offload directory", l.OverQuota)
+		}
+	default:
+		return fmt.Errorf("ItemLimits: unknown over quota policy %q", l.OverQuota)
+	}
+	return nil
+}
+
+//quotaCount counts the items of a resourceType with a quota
+type quotaCount struct {
+	seen int64
+	over int64
+}
+
+//quotas applies ItemLimits.Quotas to items as they are decoded
+// It's safe for use by concurrent decoders.
+type quotas struct {
+	limits ItemLimits
+	mu     sync.Mutex
+	counts map[string]*quotaCount
+}
+
+func newQuotas(limits ItemLimits) *quotas {
+	if len(limits.Quotas) == 0 {
+		return nil
+	}
+	return &quotas{limits: limits, counts: make(map[string]*quotaCount)}
+}
+
+//admit reports whether item is within its resourceType's quota, or is emitted regardless by the
+// over quota policy
+func (q *quotas) admit(item map[string]any) bool {
+	resourceType, _ := item["resourceType"].(string)
+	quota, ok := q.limits.Quotas[resourceType]
+	if !ok {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	c, ok := q.counts[resourceType]
+	if !ok {
+		c = &quotaCount{}
+		q.counts[resourceType] = c
+	}
+	c.seen++
+	if c.seen <= quota {
+		return true
+	}
+
+	c.over++
+	if c.over == 1 {
+		logger.Warnf("%s items reached their quota of %d: %s the rest", resourceType, quota, q.limits.OverQuota)
+	}
+	return q.limits.OverQuota == OverQuotaSample && c.over%q.limits.QuotaSample == 0
+}
+
+//String lists the items over their quota by resourceType, e.g. "AWS::EC2::NetworkInterface 1520",
+// or is empty if there were none
+func (q *quotas) String() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var over []string
+	for resourceType, c := range q.counts {
+		if c.over > 0 {
+			over = append(over, fmt.Sprintf("%s %d", resourceType, c.over))
+		}
+	}
+	sort.Strings(over)
+	return strings.Join(over, ", ")
+}
+
+//overQuota applies the over quota policy to item, decoded from raw, the <n>th item decoded, if it's over
+// its quota, returning the item to emit, or nil if it isn't emitted
+func (g *itemGuard) overQuota(item map[string]any, raw json.RawMessage, n int64) (map[string]any, error) {
+	if g.quotas.admit(item) {
+		return item, nil
+	}
+
+	ReleaseItem(item)
+	if g.limits.OverQuota == OverQuotaDeadLetter {
+		return nil, g.writeDeadLetter(raw, n)
+	}
+	return nil, nil
+}
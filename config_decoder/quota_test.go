@@ -0,0 +1,66 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	quota := map[string]int64{"AWS::EC2::Instance": 3, "AWS::S3::Bucket": 0}
+	tests := []struct {
+		name       string
+		limits     ItemLimits
+		items      int
+		deadLetter int
+	}{
+		{"drop", ItemLimits{Quotas: quota, OverQuota: OverQuotaDrop}, 3, 0},
+		// the 2nd, 4th and 6th of the 7 items over the quota
+		{"sample", ItemLimits{Quotas: quota, OverQuota: OverQuotaSample, QuotaSample: 2}, 6, 0},
+		{"deadletter", ItemLimits{Quotas: quota, OverQuota: OverQuotaDeadLetter}, 3, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.limits.OffloadDir = dir
+			if err := tt.limits.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			spec := benchSpec
+			spec.Limits = tt.limits
+
+			cw := &CollectorWriter{}
+			chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(10, 20)),
+				CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+			for err := range chErrors {
+				t.Fatal(err)
+			}
+			<-chStatus
+
+			if cw.Count() != tt.items {
+				t.Errorf("emitted %d items, want %d", cw.Count(), tt.items)
+			}
+			b, _ := os.ReadFile(filepath.Join(dir, deadLetterFile))
+			if n := bytes.Count(b, []byte{'\n'}); n != tt.deadLetter {
+				t.Errorf("dead-lettered %d items, want %d", n, tt.deadLetter)
+			}
+		})
+	}
+}
+
+func TestQuotasValidate(t *testing.T) {
+	quota := map[string]int64{"AWS::EC2::Instance": 3}
+	bad := []ItemLimits{
+		{Quotas: map[string]int64{"AWS::EC2::Instance": -1}, OverQuota: OverQuotaDrop},
+		{Quotas: quota, OverQuota: "keep"},
+		{Quotas: quota, OverQuota: OverQuotaSample},
+		{Quotas: quota, OverQuota: OverQuotaDeadLetter},
+	}
+	for _, l := range bad {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%+v is valid, want an error", l)
+		}
+	}
+}
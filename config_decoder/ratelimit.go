@@ -0,0 +1,116 @@
+package config_decoder
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//RateLimiter spaces out the items written by any number of writers, to at most a number a second
+// It allows no bursts: a writer that's been idle doesn't make up for lost time.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+//NewRateLimiter returns a RateLimiter of perSecond items a second, or nil, which doesn't limit, if
+// perSecond isn't positive
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+//Wait blocks until the next item may be written, or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//RateLimitedWriterFactory returns the factory of writers from f that wait for l before each item
+// they write, so l limits all the writers it's shared by together. A nil l doesn't limit f.
+func RateLimitedWriterFactory(f WriterFactory, l *RateLimiter) WriterFactory {
+	if l == nil {
+		return f
+	}
+	return func(ctx context.Context, worker int) (ItemWriter, error) {
+		w, err := f(ctx, worker)
+		if err != nil {
+			return nil, err
+		}
+		rw := rateLimitedWriter{ItemWriter: w, ctx: ctx, l: l}
+		if _, ok := w.(ByteCounter); ok {
+			return countingRateLimitedWriter{rw}, nil
+		}
+		return rw, nil
+	}
+}
+
+//rateLimitedWriter is an ItemWriter waiting for its RateLimiter before each item
+// It passes Flush, Close and Healthy through to the writer it wraps; countingRateLimitedWriter passes
+// BytesWritten through too.
+type rateLimitedWriter struct {
+	ItemWriter
+	ctx context.Context
+	l   *RateLimiter
+}
+
+// Write implements ItemWriter for rateLimitedWriter
+func (rw rateLimitedWriter) Write(item map[string]interface{}) error {
+	if err := rw.l.Wait(rw.ctx); err != nil {
+		return err
+	}
+	return rw.ItemWriter.Write(item)
+}
+
+// Flush implements Flusher for rateLimitedWriter
+func (rw rateLimitedWriter) Flush() error {
+	if f, ok := rw.ItemWriter.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close implements io.Closer for rateLimitedWriter
+func (rw rateLimitedWriter) Close() error {
+	return closeWriter(rw.ItemWriter)
+}
+
+// Healthy implements HealthChecker for rateLimitedWriter
+func (rw rateLimitedWriter) Healthy() error {
+	if hc, ok := rw.ItemWriter.(HealthChecker); ok {
+		return hc.Healthy()
+	}
+	return nil
+}
+
+//countingRateLimitedWriter is a rateLimitedWriter of a ByteCounter
+type countingRateLimitedWriter struct {
+	rateLimitedWriter
+}
+
+// BytesWritten implements ByteCounter for countingRateLimitedWriter
+func (rw countingRateLimitedWriter) BytesWritten() int64 {
+	return rw.ItemWriter.(ByteCounter).BytesWritten()
+}
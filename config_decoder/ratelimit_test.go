@@ -0,0 +1,56 @@
+package config_decoder
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedWriterFactory(t *testing.T) {
+	l := NewRateLimiter(200)
+	var items []map[string]interface{}
+	cw := &CollectorWriter{}
+	// two factories sharing the limiter are limited together
+	f1 := RateLimitedWriterFactory(CollectorWriterFactory(cw), l)
+	f2 := RateLimitedWriterFactory(NullWriterFactory(), l)
+
+	start := time.Now()
+	for _, f := range []WriterFactory{f1, f2} {
+		w, err := f(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			item := map[string]interface{}{"i": i}
+			items = append(items, item)
+			if err := w.Write(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 40 items at 200 a second take 195ms, the first being written at once
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("wrote %d items in %s, faster than 200 a second", len(items), elapsed)
+	}
+	if n := len(cw.Items()); n != 20 {
+		t.Errorf("collected %d items, want 20", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := RateLimitedWriterFactory(NullWriterFactory(), NewRateLimiter(0.1))(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := w.Write(map[string]interface{}{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("writing after cancellation returned %v", err)
+	}
+
+	if RateLimitedWriterFactory(nil, NewRateLimiter(0)) != nil {
+		t.Error("a limit of 0 wrapped the factory")
+	}
+}
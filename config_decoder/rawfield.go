@@ -0,0 +1,247 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+//RawField is the value of an item field of at least ItemLimits.LazyFieldSize bytes, kept as its json
+// rather than decoded, so an item with a configuration of hundreds of megabytes isn't materialized as
+// maps in memory; it's written as it appears in the document, less insignificant whitespace
+// A field of a document decoded with DecodeAndSplitItemsAt is only located as it's decoded, and read
+// from the document again as it's written, so it must be written before the decode's workers have all
+// sent their status; one decoded from a stream holds its json. A FileWriter streams the field to a
+// StreamWriter without buffering it; other writers, hashes and proto encodings marshal it, decoding it
+// if they need its value. Rules and transforms see the *RawField, not its value.
+type RawField struct {
+	r    io.ReaderAt
+	off  int64
+	size int64
+	raw  []byte
+}
+
+//Size returns the size of the field's json, in bytes
+func (f *RawField) Size() int64 {
+	return f.size
+}
+
+//String describes the field, rather than formatting its json, which may be huge
+func (f *RawField) String() string {
+	return fmt.Sprintf("<%d bytes of json>", f.size)
+}
+
+//Reader returns a reader of the field's json, as it appears in the document
+func (f *RawField) Reader() io.Reader {
+	if f.r == nil {
+		return bytes.NewReader(f.raw)
+	}
+	return io.NewSectionReader(f.r, f.off, f.size)
+}
+
+//WriteTo implements io.WriterTo, writing the field's json to w without insignificant whitespace, as
+// a line of ndjson needs
+func (f *RawField) WriteTo(w io.Writer) (int64, error) {
+	cw := &compactWriter{w: w}
+	if _, err := io.Copy(cw, f.Reader()); err != nil {
+		return cw.n, fmt.Errorf("RawField: %w", err)
+	}
+	return cw.n, nil
+}
+
+//MarshalJSON implements json.Marshaler, reading the field's json into memory
+func (f *RawField) MarshalJSON() ([]byte, error) {
+	if f.r == nil {
+		return f.raw, nil
+	}
+	b, err := io.ReadAll(f.Reader())
+	if err != nil {
+		return nil, fmt.Errorf("RawField: %w", err)
+	}
+	return b, nil
+}
+
+//compactWriter writes json to w without the whitespace between its tokens
+type compactWriter struct {
+	w        io.Writer
+	n        int64
+	inString bool
+	escape   bool
+	buf      []byte
+}
+
+//compactChunk is the most compactWriter buffers of a write at once
+const compactChunk = 64 << 10
+
+// Write implements io.Writer for compactWriter
+func (cw *compactWriter) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); written += compactChunk {
+		if err := cw.write(p[written:min(written+compactChunk, len(p))]); err != nil {
+			return written, err
+		}
+	}
+	return len(p), nil
+}
+
+//write compacts p, of at most compactChunk bytes, to cw's writer
+func (cw *compactWriter) write(p []byte) error {
+	cw.buf = cw.buf[:0]
+	for _, c := range p {
+		switch {
+		case cw.inString:
+			switch {
+			case cw.escape:
+				cw.escape = false
+			case c == '\\':
+				cw.escape = true
+			case c == '"':
+				cw.inString = false
+			}
+		case isSpace(c):
+			continue
+		case c == '"':
+			cw.inString = true
+		}
+		cw.buf = append(cw.buf, c)
+	}
+	n, err := cw.w.Write(cw.buf)
+	cw.n += int64(n)
+	return err
+}
+
+//lazyItem decodes the item raw, from src, keeping its fields of at least size bytes as RawFields; src's
+// configs, if set, decodes its configuration if it's smaller
+// If src's document can be read again, raw is at offset off of it, and the fields are left in it to be
+// read as they're written; otherwise they refer to raw.
+func lazyItem(raw json.RawMessage, size int, off int64, src itemSource) (map[string]any, error) {
+	spans, err := scanTopLevel(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("lazyItem: %w", err)
+	}
+
+	r := src.at
+	item := getItem()
+	for _, s := range spans {
+		// a span ends at the next separator, after any whitespace
+		value := bytes.TrimRight(raw[s.Start:s.End], " \t\r\n")
+		if len(value) >= size {
+			f := &RawField{size: int64(len(value))}
+			if r != nil {
+				f.r, f.off = r, off+s.Start
+			} else {
+				f.raw = value
+			}
+			item[s.Key] = f
+			continue
+		}
+
+		var v any
+		if s.Key == configurationField && src.configs != nil {
+			v, err = src.configs.value(value, src.numbers)
+		} else {
+			err = unmarshal(value, &v, src.numbers)
+		}
+		if err != nil {
+			ReleaseItem(item)
+			return nil, fmt.Errorf("lazyItem: %s: %w", s.Key, err)
+		}
+		item[s.Key] = v
+	}
+	return item, nil
+}
+
+//rawFields returns the names of the fields of item that are RawFields, sorted
+func rawFields(item map[string]any) []string {
+	var names []string
+	for k, v := range item {
+		if _, ok := v.(*RawField); ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//rawSize returns the size of the json of item's RawFields
+func rawSize(item map[string]any) int64 {
+	var size int64
+	for _, v := range item {
+		if f, ok := v.(*RawField); ok {
+			size += f.size
+		}
+	}
+	return size
+}
+
+//StreamWriter is optionally implemented by the io.Writer of FileWriters, shared by the workers of a pool,
+// to let a worker write an item in parts without another's writes coming between them
+// WriteStream calls write with a writer the worker has to itself until write returns.
+type StreamWriter interface {
+	io.Writer
+	WriteStream(write func(w io.Writer) error) error
+}
+
+//writeRaw writes item as json, its fields named raw being RawFields streamed after the others
+func (fw FileWriter) writeRaw(item map[string]any, raw []string) error {
+	rest := make(map[string]any, len(item)-len(raw))
+	for k, v := range item {
+		if _, ok := v.(*RawField); !ok {
+			rest[k] = v
+		}
+	}
+	if err := fw.enc.Encode(rest); err != nil {
+		return err
+	}
+	// leave the object open, less its closing brace and the encoder's newline
+	fw.buf.Truncate(fw.buf.Len() - 2)
+	return fw.writeRawFields(item, raw, len(rest) > 0)
+}
+
+//writeRawFields writes the RawFields of item named raw after the fields of the open object in the
+// buffer, separated from them if separate is set, and closes it
+// The fields are streamed to a StreamWriter; for any other writer, the item is buffered and written whole.
+func (fw FileWriter) writeRawFields(item map[string]any, raw []string, separate bool) error {
+	write := func(w io.Writer) error {
+		for i, k := range raw {
+			if i > 0 || separate {
+				fw.buf.WriteByte(',')
+			}
+			key, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			fw.buf.Write(key)
+			fw.buf.WriteByte(':')
+			n, err := w.Write(fw.buf.Bytes())
+			*fw.written += int64(n)
+			if err != nil {
+				return err
+			}
+			fw.buf.Reset()
+
+			m, err := item[k].(*RawField).WriteTo(w)
+			*fw.written += m
+			if err != nil {
+				return err
+			}
+		}
+		fw.buf.WriteByte('}')
+		fw.buf.Write(fw.encoding.Termination)
+		n, err := w.Write(fw.buf.Bytes())
+		*fw.written += int64(n)
+		return err
+	}
+
+	if sw, ok := fw.writer.(StreamWriter); ok {
+		return sw.WriteStream(write)
+	}
+	// counted as it's written to the buffer
+	var b bytes.Buffer
+	if err := write(&b); err != nil {
+		return err
+	}
+	_, err := fw.writer.Write(b.Bytes())
+	return err
+}
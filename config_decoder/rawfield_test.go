@@ -0,0 +1,104 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//streamBuffer is a lockedBuffer that FileWriters can stream items to
+type streamBuffer struct {
+	lockedBuffer
+	streamed int
+}
+
+func (sb *streamBuffer) WriteStream(write func(w io.Writer) error) error {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.streamed++
+	return write(&sb.b)
+}
+
+func TestLazyFields(t *testing.T) {
+	// one item has a large configuration, with whitespace between its tokens and in its strings
+	big := `{
+  "big": "` + strings.Repeat(`a \"b\" <c> `, 200) + `",
+  "list": [1, 2.50,
+    {"x": null}]
+}`
+	doc := bytes.Replace(benchSnapshot(20, 10), []byte(`"configuration":{"blob"`), []byte(`"configuration":`+big+`,"x":{"blob"`), 1)
+	spec := benchSpec
+	spec.Hash = ItemHash{Field: "config_hash"}
+	spec.Reproducible = true
+
+	// lines decoded by resourceId
+	decoded := func(out []byte) map[string]map[string]any {
+		items := make(map[string]map[string]any)
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			var item map[string]any
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatalf("%v: %s", err, line)
+			}
+			items[item["resourceId"].(string)] = item
+		}
+		return items
+	}
+
+	for name, decode := range provenanceDecoders {
+		var want lockedBuffer
+		chStatus, chErrors := decode(doc, FileWriterFactory(&want, []byte{'\n'}), spec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		<-chStatus
+		<-chStatus
+
+		spec := spec
+		spec.Limits.LazyFieldSize = 1000
+		for _, streamed := range []bool{false, true} {
+			var out streamBuffer
+			var w io.Writer = &out.lockedBuffer
+			if streamed {
+				w = &out
+			}
+			chStatus, chErrors := decode(doc, FileWriterFactory(w, []byte{'\n'}), spec)
+			for err := range chErrors {
+				t.Fatalf("%s: %v", name, err)
+			}
+			<-chStatus
+			<-chStatus
+
+			if got := decoded(out.b.Bytes()); !reflect.DeepEqual(got, decoded(want.b.Bytes())) {
+				t.Errorf("%s: items with lazy fields differ from those decoded whole", name)
+			}
+			if streamed && out.streamed != 1 {
+				t.Errorf("%s: streamed %d items, want 1", name, out.streamed)
+			}
+		}
+
+		cw := &CollectorWriter{}
+		chStatus, chErrors = decode(doc, CollectorWriterFactory(cw), spec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		<-chStatus
+		<-chStatus
+		lazy := 0
+		for _, item := range cw.Items() {
+			f, ok := item["configuration"].(*RawField)
+			if !ok {
+				continue
+			}
+			lazy++
+			if inMemory := f.r == nil; inMemory != (name == "stream") {
+				t.Errorf("%s: field read from the document is %v", name, !inMemory)
+			}
+		}
+		if lazy != 1 {
+			t.Errorf("%s: %d lazy configurations, want 1", name, lazy)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+//MappedFile is a read-only, memory-mapped file implementing io.ReaderAt
+type MappedFile struct {
+	data  []byte
+	unmap func([]byte) error
+}
+
+// ReadAt implements io.ReaderAt for MappedFile
+func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("MappedFile: negative offset %d", off)
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+//Len returns the size of the file
+func (m *MappedFile) Len() int64 {
+	return int64(len(m.data))
+}
+
+//Close unmaps the file
+func (m *MappedFile) Close() error {
+	if m.unmap == nil || m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return m.unmap(data)
+}
+
+//DecodeAndSplitItemsAt decodes a json document of <size> bytes from a seekable source
+//persisting specified parent field values to the emitted item
+// Unlike DecodeAndSplitItems, the spec Fields may appear anywhere in the document,
+// as they are located in a cheap first pass before the items are decoded. That pass finds a truncated
+// document; the whole items it holds are decoded, one by one, before it ends with a TruncatedError, as
+// DecodeAndSplitItems does.
+//
+// Deprecated: importers should use decode.DecodeAt.
+func DecodeAndSplitItemsAt(ctx context.Context, r io.ReaderAt, size int64, writerFactory WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+
+	cItems := make(chan map[string]any, 0)
+	cErrors := make(chan error, 1)
+	guard := newItemGuard(spec.Limits)
+	sel := newSelector(spec.Selection)
+	ctx, stop := context.WithCancelCause(ctx)
+	pool := newWriterPool(ctx, writerFactory, poolSpec, cItems, guard.budget, stop)
+
+	metadata := newMetadata(spec)
+
+	go func() {
+		defer close(cItems)
+		defer close(cErrors)
+		defer guard.close()
+
+		if err := spec.Limits.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+			return
+		}
+		if err := spec.Selection.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+			return
+		}
+		if err := spec.Envelope.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+			return
+		}
+		if err := spec.Hash.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+			return
+		}
+		if err := spec.Rules.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+			return
+		}
+
+		spans, truncated := scanTopLevel(r, size)
+		if truncated != nil && (!endedEarly(truncated) || !hasItemsField(spec, spans)) {
+			// the document is scanned whole before any item is decoded
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", (&lastItem{}).truncated(truncated))
+			return
+		}
+		keys := make([]string, 0, len(spans))
+		for _, s := range spans {
+			if !spec.Strict && isDuplicate(spec, keys, s.Key) {
+				logger.Warnf("top-level field %q is duplicated at offset %d; the input may be corrupt", s.Key, s.Start)
+			}
+			keys = append(keys, s.Key)
+		}
+		if err := checkItemsField(spec, keys); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+			return
+		}
+		if spec.Matches != nil {
+			spec.Matches.add(spec, keys)
+		}
+
+		// first collect the parent fields, wherever they are
+		var items *span
+		for i, s := range spans {
+			if s.Key == spec.ItemsField {
+				items = &spans[i]
+				continue
+			}
+
+			tfv, ok := spec.Fields[s.Key]
+			if !ok {
+				logger.Debugf("skipping field %q", s.Key)
+				if spec.Skipped != nil {
+					// a span ends at the next separator, so the value is read to leave out the whitespace before it
+					raw := make([]byte, s.End-s.Start)
+					if _, err := r.ReadAt(raw, s.Start); err != nil && err != io.EOF {
+						cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: reading field %q: %w", s.Key, err)
+						return
+					}
+					raw = bytes.TrimRight(raw, " \t\r\n")
+					spec.Skipped.add(spec.Source, s.Key, raw)
+				}
+				continue
+			}
+
+			var v any
+			raw := make([]byte, s.End-s.Start)
+			if _, err := r.ReadAt(raw, s.Start); err != nil && err != io.EOF {
+				cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: reading field %q: %w", s.Key, err)
+				return
+			}
+			if err := unmarshal(raw, &v, spec.UseNumber); err != nil {
+				cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: error decoding field %q: %w", s.Key, err)
+				return
+			}
+
+			// use original field name if destination name is ""
+			if tfv == "" {
+				tfv = s.Key
+			}
+			if err := addMetadata(metadata, tfv, v); err != nil {
+				cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", err)
+				return
+			}
+		}
+
+		// then re-read just the items array
+		logger.Debugf("handling %s array...", items.Key)
+		var err error
+		var last lastItem
+		if truncated != nil {
+			// the items that were delivered are decoded in order, so the last of them tells where it ended
+			dec := json.NewDecoder(io.NewSectionReader(r, items.Start, items.End-items.Start))
+			err = decodeItems(ctx, dec, itemSource{at: r, base: items.Start, omit: spec.NoProvenance, envelope: spec.Envelope, hash: spec.Hash,
+				rules: newRuleChecker(spec), configs: spec.Configurations, last: &last, numbers: spec.UseNumber, verbatim: spec.Verbatim}, metadata, cItems, guard, sel)
+			if err == nil {
+				err = truncated
+			}
+		} else if spec.Decoders > 1 {
+			err = decodeItemsParallel(ctx, r, *items, spec.Decoders, itemSource{at: r, omit: spec.NoProvenance, envelope: spec.Envelope, hash: spec.Hash, rules: newRuleChecker(spec),
+				configs: spec.Configurations, numbers: spec.UseNumber, verbatim: spec.Verbatim}, metadata, cItems, guard, sel)
+		} else {
+			dec := json.NewDecoder(io.NewSectionReader(r, items.Start, items.End-items.Start))
+			err = decodeItems(ctx, dec, itemSource{at: r, base: items.Start, omit: spec.NoProvenance, envelope: spec.Envelope, hash: spec.Hash,
+				rules: newRuleChecker(spec), configs: spec.Configurations, numbers: spec.UseNumber, verbatim: spec.Verbatim}, metadata, cItems, guard, sel)
+		}
+		if errors.Is(err, errMaxItems) {
+			logger.Infof("stopped after %d items", spec.Selection.MaxItems)
+			return
+		}
+		if err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItemsAt: %w", last.truncated(err))
+			return
+		}
+	}()
+
+	return pool.chStatus, cErrors
+}
+
+//hasItemsField reports whether spans, of a truncated document, include the items field, at least in part
+func hasItemsField(spec ItemTransformSpec, spans []span) bool {
+	for _, s := range spans {
+		if s.Key == spec.ItemsField {
+			return true
+		}
+	}
+	return false
+}
+
+//minRangeSize is the smallest run of items, in bytes, handed to a parallel decoder
+const minRangeSize = 64 << 10
+
+//decodeItemsParallel decodes the items array at s with <decoders> goroutines
+// The array is split into ranges of whole items in a cheap first pass; the decoders then take ranges
+// in turn, so the items are emitted out of order. Each range is decoded from a copy of src, based at its start.
+func decodeItemsParallel(ctx context.Context, r io.ReaderAt, s span, decoders int, src itemSource, metadata map[string]any, cItems chan map[string]any, guard *itemGuard, sel *selector) error {
+	target := (s.End - s.Start) / int64(decoders*4)
+	if target < minRangeSize {
+		target = minRangeSize
+	}
+
+	ranges, err := scanItemRanges(r, s, target)
+	if err != nil {
+		return fmt.Errorf("decodeItemsParallel: %w", err)
+	}
+
+	// each range's items follow those of the ranges before it
+	type indexedRange struct {
+		itemRange
+		first int
+	}
+	chRanges := make(chan indexedRange, len(ranges))
+	first := 0
+	for _, ir := range ranges {
+		chRanges <- indexedRange{ir, first}
+		first += ir.Count
+	}
+	close(chRanges)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, decoders)
+	var wg sync.WaitGroup
+	for d := 0; d < decoders; d++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ir := range chRanges {
+				if ctx.Err() != nil {
+					return
+				}
+
+				// present the range to the decoder as an array of its own, less the opening bracket
+				dec := json.NewDecoder(io.MultiReader(
+					strings.NewReader("["),
+					io.NewSectionReader(r, ir.Start, ir.End-ir.Start),
+					strings.NewReader("]"),
+				))
+				src := src
+				src.base, src.index = ir.Start-1, ir.first
+				if err := decodeItems(ctx, dec, src, metadata, cItems, guard, sel); err != nil {
+					errs <- fmt.Errorf("decodeItemsParallel: items at offset %d: %w", ir.Start, err)
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	// decoders stop taking ranges once ctx is done
+	if ctx.Err() != nil {
+		return fmt.Errorf("decodeItemsParallel: %w", stopped(ctx))
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+//Record envelope formats
+const (
+	// RecordCloudEvents wraps items in CloudEvents 1.0 events, in the structured json format
+	RecordCloudEvents = "cloudevents"
+	// RecordTemplate wraps items in a json document executed from a user's template
+	RecordTemplate = "template"
+)
+
+//DefaultEventType is the CloudEvents type of configuration items
+const DefaultEventType = "com.amazonaws.config.ConfigurationItem"
+
+//RecordEnvelope wraps each item written in an event record, for consumers that require CloudEvents
+// framing, or another of their own; unlike the MetadataEnvelope, it's applied as items are written,
+// so writers, and their name templates, see the record, with the item as its data
+// Format is RecordCloudEvents or RecordTemplate; the zero value wraps nothing.
+// Source is the event source, or a NameTemplate naming each item's; by default it's
+// arn:aws:config:<region>:<account> of the item. Type is the event type, DefaultEventType by default.
+// Template, for RecordTemplate, is a text/template of a json object executed with a RecordEvent, e.g.
+// {"eventId": {{json .ID}}, "origin": {{json .Source}}, "at": {{json .Time}}, "payload": {{json .Data}}};
+// its json function encodes a value as json.
+type RecordEnvelope struct {
+	Format   string
+	Source   string
+	Type     string
+	Template string
+}
+
+//RecordEvent holds the attributes of an item's event record
+// ID is derived from the resource and its capture time and state, so the record of an item decoded
+// again has the same ID, and consumers may drop it as a duplicate. Subject is the resource's ARN, or
+// its id; PartitionKey is its id, so records of one resource are kept in order.
+type RecordEvent struct {
+	ID           string
+	Source       string
+	Type         string
+	Time         string
+	Subject      string
+	PartitionKey string
+	Data         map[string]any
+}
+
+//recordFuncs are the functions of RecordEnvelope templates, besides text/template's own
+var recordFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+//recordWrapper wraps the items of one RecordEnvelope's writers
+type recordWrapper struct {
+	env    RecordEnvelope
+	source *NameTemplate
+	t      *template.Template
+}
+
+//Wrap returns a factory of writers writing the records of items to writers from f, or f itself if
+// the envelope wraps nothing
+func (e RecordEnvelope) Wrap(f WriterFactory) (WriterFactory, error) {
+	if e.Format == "" {
+		return f, nil
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	rw := &recordWrapper{env: e}
+	if rw.env.Type == "" {
+		rw.env.Type = DefaultEventType
+	}
+	if e.Source != "" {
+		rw.source, _ = ParseNameTemplate(e.Source)
+	}
+	if e.Format == RecordTemplate {
+		rw.t, _ = template.New("record").Funcs(recordFuncs).Parse(e.Template)
+	}
+
+	return func(ctx context.Context, worker int) (ItemWriter, error) {
+		w, err := f(ctx, worker)
+		if err != nil {
+			return nil, err
+		}
+		ew := recordWriter{w: w, wrapper: rw}
+		if _, ok := w.(ByteCounter); ok {
+			return countingRecordWriter{ew}, nil
+		}
+		return ew, nil
+	}, nil
+}
+
+//Validate checks the envelope's format is known, and its source and template parse
+func (e RecordEnvelope) Validate() error {
+	switch e.Format {
+	case "", RecordCloudEvents:
+	case RecordTemplate:
+		if e.Template == "" {
+			return fmt.Errorf("RecordEnvelope: %s records need a Template", e.Format)
+		}
+		if _, err := template.New("record").Funcs(recordFuncs).Parse(e.Template); err != nil {
+			return fmt.Errorf("RecordEnvelope: %w", err)
+		}
+	default:
+		return fmt.Errorf("RecordEnvelope: unknown Format %q", e.Format)
+	}
+	if e.Source != "" {
+		if _, err := ParseNameTemplate(e.Source); err != nil {
+			return fmt.Errorf("RecordEnvelope: Source: %w", err)
+		}
+	}
+	return nil
+}
+
+//event returns the attributes of item's event record
+func (rw *recordWrapper) event(item map[string]any) (RecordEvent, error) {
+	str := func(k string) string {
+		s, _ := item[k].(string)
+		return s
+	}
+	e := RecordEvent{Type: rw.env.Type, Time: str("configurationItemCaptureTime"), Subject: str("resourceId"),
+		PartitionKey: str("resourceId"), Data: item}
+	for _, k := range []string{"ARN", "arn"} {
+		if arn := str(k); arn != "" {
+			e.Subject = arn
+		}
+	}
+	if rw.source != nil {
+		var err error
+		if e.Source, err = rw.source.Name(item); err != nil {
+			return RecordEvent{}, err
+		}
+	} else {
+		e.Source = fmt.Sprintf("arn:aws:config:%s:%s", str("awsRegion"), str("awsAccountId"))
+	}
+
+	var state string
+	if v, ok := item["configurationStateId"]; ok && v != nil {
+		state = fmt.Sprint(v)
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{str("resourceType"), str("resourceId"), e.Time, state}, "\x00")))
+	e.ID = hex.EncodeToString(sum[:16])
+	return e, nil
+}
+
+//record returns item's event record
+func (rw *recordWrapper) record(item map[string]any) (map[string]any, error) {
+	e, err := rw.event(item)
+	if err != nil {
+		return nil, err
+	}
+	if rw.t == nil {
+		record := map[string]any{
+			"specversion":     "1.0",
+			"id":              e.ID,
+			"source":          e.Source,
+			"type":            e.Type,
+			"datacontenttype": "application/json",
+			"data":            item,
+		}
+		// optional attributes are left out rather than empty
+		for k, v := range map[string]string{"time": e.Time, "subject": e.Subject, "partitionkey": e.PartitionKey} {
+			if v != "" {
+				record[k] = v
+			}
+		}
+		return record, nil
+	}
+
+	var buf bytes.Buffer
+	if err := rw.t.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		return nil, fmt.Errorf("template gave no json object: %w", err)
+	}
+	return record, nil
+}
+
+//recordWriter is an ItemWriter writing the event records of items
+// It passes Flush, Close and Healthy through to writers implementing them; countingRecordWriter passes
+// BytesWritten through too, so writers not counting bytes aren't taken for ones that do.
+type recordWriter struct {
+	w       ItemWriter
+	wrapper *recordWrapper
+}
+
+// Write implements ItemWriter for recordWriter
+func (rw recordWriter) Write(item map[string]interface{}) error {
+	record, err := rw.wrapper.record(item)
+	if err != nil {
+		return fmt.Errorf("RecordEnvelope: %w", err)
+	}
+	return rw.w.Write(record)
+}
+
+// Flush implements Flusher for recordWriter
+func (rw recordWriter) Flush() error {
+	if f, ok := rw.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close implements io.Closer for recordWriter
+func (rw recordWriter) Close() error {
+	return closeWriter(rw.w)
+}
+
+// Healthy implements HealthChecker for recordWriter
+func (rw recordWriter) Healthy() error {
+	if hc, ok := rw.w.(HealthChecker); ok {
+		return hc.Healthy()
+	}
+	return nil
+}
+
+//countingRecordWriter is a recordWriter of a ByteCounter
+type countingRecordWriter struct {
+	recordWriter
+}
+
+// BytesWritten implements ByteCounter for countingRecordWriter
+func (rw countingRecordWriter) BytesWritten() int64 {
+	return rw.w.(ByteCounter).BytesWritten()
+}
@@ -0,0 +1,102 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+//recordsOf writes items through writers wrapped in e, returning the records written
+func recordsOf(t *testing.T, e RecordEnvelope, items ...map[string]any) []map[string]any {
+	var buf bytes.Buffer
+	f, err := e.Wrap(FileWriterFactory(&buf, []byte("\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.(ByteCounter); !ok {
+		t.Error("the wrapped writer of a ByteCounter isn't one")
+	}
+	for _, item := range items {
+		if err := w.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var records []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func testItem() map[string]any {
+	return map[string]any{
+		"resourceType": "AWS::S3::Bucket", "resourceId": "logs", "ARN": "arn:aws:s3:::logs",
+		"awsRegion": "us-east-1", "awsAccountId": "123456789012", "configurationStateId": 1700000000000.0,
+		"configurationItemCaptureTime": "2024-01-02T03:04:05.000Z",
+	}
+}
+
+func TestRecordEnvelopeCloudEvents(t *testing.T) {
+	records := recordsOf(t, RecordEnvelope{Format: RecordCloudEvents}, testItem(), testItem())
+	r := records[0]
+	for k, want := range map[string]any{
+		"specversion": "1.0", "source": "arn:aws:config:us-east-1:123456789012", "type": DefaultEventType,
+		"time": "2024-01-02T03:04:05.000Z", "subject": "arn:aws:s3:::logs", "partitionkey": "logs",
+		"datacontenttype": "application/json",
+	} {
+		if r[k] != want {
+			t.Errorf("%s is %v, want %v", k, r[k], want)
+		}
+	}
+	if data, _ := r["data"].(map[string]any); data["resourceId"] != "logs" {
+		t.Errorf("data is %v", r["data"])
+	}
+	if id, _ := r["id"].(string); id == "" || id != records[1]["id"] {
+		t.Errorf("ids %v and %v of the same item differ", r["id"], records[1]["id"])
+	}
+
+	records = recordsOf(t, RecordEnvelope{Format: RecordCloudEvents, Source: "config/{{.awsAccountId}}", Type: "x.y"}, testItem())
+	if records[0]["source"] != "config/123456789012" || records[0]["type"] != "x.y" {
+		t.Errorf("source %v, type %v", records[0]["source"], records[0]["type"])
+	}
+}
+
+func TestRecordEnvelopeTemplate(t *testing.T) {
+	e := RecordEnvelope{Format: RecordTemplate,
+		Template: `{"eventId": {{json .ID}}, "origin": {{json .Source}}, "at": {{json .Time}}, "payload": {{json .Data}}}`}
+	r := recordsOf(t, e, testItem())[0]
+	if r["origin"] != "arn:aws:config:us-east-1:123456789012" || r["at"] != "2024-01-02T03:04:05.000Z" || r["eventId"] == "" {
+		t.Errorf("record %v", r)
+	}
+	if payload, _ := r["payload"].(map[string]any); payload["resourceType"] != "AWS::S3::Bucket" {
+		t.Errorf("payload is %v", r["payload"])
+	}
+
+	for _, bad := range []RecordEnvelope{
+		{Format: "avro"},
+		{Format: RecordTemplate},
+		{Format: RecordTemplate, Template: "{{json .ID"},
+		{Format: RecordCloudEvents, Source: "{{.awsRegion"},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%+v is valid", bad)
+		}
+	}
+
+	f, _ := RecordEnvelope{Format: RecordTemplate, Template: "not json"}.Wrap(NullWriterFactory())
+	w, _ := f(context.Background(), 0)
+	if err := w.Write(testItem()); err == nil {
+		t.Error("no error from a template giving no json object")
+	}
+}
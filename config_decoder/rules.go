@@ -0,0 +1,280 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//Rule checks the items of one resource type for a problem, such as a resource open to the internet
+// Check returns a message describing each instance of the problem in item.
+type Rule struct {
+	ID           string
+	Severity     string
+	ResourceType string
+	Check        func(item map[string]any) []string
+}
+
+//BuiltinRules are the rules ItemRules selects from
+var BuiltinRules = []Rule{
+	{ID: "s3-bucket-public", Severity: "high", ResourceType: "AWS::S3::Bucket", Check: checkBucketPublic},
+	{ID: "ebs-volume-unencrypted", Severity: "medium", ResourceType: "AWS::EC2::Volume", Check: checkVolumeUnencrypted},
+	{ID: "security-group-open-ingress", Severity: "high", ResourceType: "AWS::EC2::SecurityGroup", Check: checkOpenIngress},
+}
+
+//ItemRules configures the rules stage, checking each item for problems as it's decoded
+// Rules are the ids of the BuiltinRules checked, or "all" for all of them; none are by default.
+// Custom rules, such as those LoadRules reads, are checked as well; one without a ResourceType checks
+// every item.
+// Findings writes a finding for each problem found: an item with the rule's id and severity, a message,
+// and the resource's type, id, ARN, region, account and capture time, with the run id, source file and
+// index of the item. It's required if there are Rules, and must be safe for concurrent use, as
+// concurrent decoders share it.
+type ItemRules struct {
+	Rules    []string
+	Custom   []Rule     `json:"-"`
+	Findings ItemWriter `json:"-"`
+}
+
+//Validate checks the rules exist and their findings have a writer
+func (r ItemRules) Validate() error {
+	if len(r.Rules) == 0 && len(r.Custom) == 0 {
+		return nil
+	}
+	if _, err := SelectRules(r.Rules); err != nil {
+		return fmt.Errorf("ItemRules: %w", err)
+	}
+	for _, rule := range r.Custom {
+		if rule.ID == "" || rule.Check == nil {
+			return fmt.Errorf("ItemRules: custom rules need an ID and a Check")
+		}
+	}
+	if r.Findings == nil {
+		return fmt.Errorf("ItemRules: rules have no Findings writer")
+	}
+	return nil
+}
+
+//SelectRules returns the BuiltinRules with ids, or all of them for "all"
+func SelectRules(ids []string) ([]Rule, error) {
+	var rules []Rule
+NextID:
+	for _, id := range ids {
+		if id == "all" {
+			return BuiltinRules, nil
+		}
+		for _, rule := range BuiltinRules {
+			if rule.ID == id {
+				rules = append(rules, rule)
+				continue NextID
+			}
+		}
+		return nil, fmt.Errorf("SelectRules: unknown rule %q", id)
+	}
+	return rules, nil
+}
+
+//ruleChecker checks items with the selected rules, by resource type
+// The zero ruleChecker checks nothing.
+type ruleChecker struct {
+	byType   map[string][]Rule
+	findings ItemWriter
+	runID    string
+	source   string
+}
+
+//newRuleChecker returns the ruleChecker for spec, whose Rules must be valid
+// Rules for every resource type are kept under "".
+func newRuleChecker(spec ItemTransformSpec) ruleChecker {
+	rules, _ := SelectRules(spec.Rules.Rules)
+	rules = append(rules[:len(rules):len(rules)], spec.Rules.Custom...)
+	if len(rules) == 0 {
+		return ruleChecker{}
+	}
+	c := ruleChecker{byType: make(map[string][]Rule), findings: spec.Rules.Findings, runID: spec.RunID, source: spec.Source}
+	for _, rule := range rules {
+		c.byType[rule.ResourceType] = append(c.byType[rule.ResourceType], rule)
+	}
+	return c
+}
+
+//check writes the findings of the rules for item's resource type; index is the item's in its document
+func (c ruleChecker) check(item map[string]any, index int) error {
+	if c.byType == nil {
+		return nil
+	}
+	t, _ := item["resourceType"].(string)
+	if err := c.checkRules(c.byType[t], item, index); err != nil {
+		return err
+	}
+	return c.checkRules(c.byType[""], item, index)
+}
+
+//checkRules writes the findings of rules for item
+func (c ruleChecker) checkRules(rules []Rule, item map[string]any, index int) error {
+	for _, rule := range rules {
+		for _, msg := range rule.Check(item) {
+			finding := map[string]any{
+				"rule":         rule.ID,
+				"severity":     rule.Severity,
+				"message":      msg,
+				"source_index": index,
+			}
+			for _, k := range []string{"resourceType", "resourceId", "ARN", "awsRegion", "awsAccountId", "configurationItemCaptureTime"} {
+				if v, ok := item[k]; ok {
+					finding[k] = v
+				}
+			}
+			if c.runID != "" {
+				finding["run_id"] = c.runID
+			}
+			if c.source != "" {
+				finding["source_file"] = c.source
+			}
+			if err := c.findings.Write(finding); err != nil {
+				return fmt.Errorf("writing finding of rule %s: %w", rule.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+//embeddedJSON returns v, decoding it first if it's a string of json, as AWS Config gives some
+// supplementary configuration and policies
+func embeddedJSON(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
+//publicGroups are the ACL grantees that make a bucket public
+var publicGroups = []string{"AllUsers", "AuthenticatedUsers"}
+
+//checkBucketPublic finds ACL grants to everyone, and bucket policy statements allowing anyone, that
+// the bucket's public access block doesn't override
+func checkBucketPublic(item map[string]any) []string {
+	supplementary, _ := item["supplementaryConfiguration"].(map[string]any)
+	block, _ := embeddedJSON(supplementary["PublicAccessBlockConfiguration"]).(map[string]any)
+	var msgs []string
+
+	if ignoreACLs, _ := block["ignorePublicAcls"].(bool); !ignoreACLs {
+		acl, _ := embeddedJSON(supplementary["AccessControlList"]).(map[string]any)
+		grants, _ := acl["grantList"].([]any)
+		for _, g := range grants {
+			grant, _ := g.(map[string]any)
+			grantee := fmt.Sprint(grant["grantee"])
+			if m, ok := grant["grantee"].(map[string]any); ok {
+				grantee = fmt.Sprint(m["uri"], m["URI"], m["identifier"])
+			}
+			for _, group := range publicGroups {
+				if strings.Contains(grantee, group) {
+					msgs = append(msgs, fmt.Sprintf("ACL grants %v to %s", grant["permission"], group))
+				}
+			}
+		}
+	}
+
+	if restrict, _ := block["restrictPublicBuckets"].(bool); !restrict {
+		policy, _ := embeddedJSON(supplementary["BucketPolicy"]).(map[string]any)
+		document, _ := embeddedJSON(policy["policyText"]).(map[string]any)
+		for _, s := range asList(document["Statement"]) {
+			statement, _ := s.(map[string]any)
+			if statement["Effect"] == "Allow" && statement["Condition"] == nil && anyonePrincipal(statement["Principal"]) {
+				msgs = append(msgs, fmt.Sprintf("bucket policy allows anyone %v", statement["Action"]))
+			}
+		}
+	}
+	return msgs
+}
+
+//anyonePrincipal reports whether an IAM policy principal is everyone
+func anyonePrincipal(principal any) bool {
+	if principal == "*" {
+		return true
+	}
+	p, _ := principal.(map[string]any)
+	for _, v := range asList(p["AWS"]) {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+//asList returns v as a list, as IAM policies give single values without one
+func asList(v any) []any {
+	switch l := v.(type) {
+	case nil:
+		return nil
+	case []any:
+		return l
+	default:
+		return []any{l}
+	}
+}
+
+//checkVolumeUnencrypted finds volumes that aren't encrypted
+func checkVolumeUnencrypted(item map[string]any) []string {
+	configuration, _ := item["configuration"].(map[string]any)
+	if encrypted, _ := configuration["encrypted"].(bool); !encrypted {
+		return []string{"volume is not encrypted"}
+	}
+	return nil
+}
+
+//openCIDRs are the ranges of every IPv4 and IPv6 address
+var openCIDRs = map[string]bool{"0.0.0.0/0": true, "::/0": true}
+
+//checkOpenIngress finds ingress rules open to every address
+func checkOpenIngress(item map[string]any) []string {
+	configuration, _ := item["configuration"].(map[string]any)
+	var msgs []string
+	for _, p := range asList(configuration["ipPermissions"]) {
+		permission, _ := p.(map[string]any)
+
+		// ipRanges lists bare CIDRs, ipv4Ranges and ipv6Ranges objects holding them
+		var cidrs []any
+		cidrs = append(cidrs, asList(permission["ipRanges"])...)
+		for _, r := range asList(permission["ipv4Ranges"]) {
+			m, _ := r.(map[string]any)
+			cidrs = append(cidrs, m["cidrIp"])
+		}
+		for _, r := range asList(permission["ipv6Ranges"]) {
+			m, _ := r.(map[string]any)
+			cidrs = append(cidrs, m["cidrIpv6"])
+		}
+
+		seen := make(map[string]bool)
+		for _, c := range cidrs {
+			cidr, _ := c.(string)
+			if !openCIDRs[cidr] || seen[cidr] {
+				continue
+			}
+			seen[cidr] = true
+			msgs = append(msgs, fmt.Sprintf("ingress from %s to %s", cidr, portRange(permission)))
+		}
+	}
+	return msgs
+}
+
+//portRange describes the protocol and ports of a security group permission
+func portRange(permission map[string]any) string {
+	protocol := fmt.Sprint(permission["ipProtocol"])
+	if protocol == "-1" {
+		return "all traffic"
+	}
+	from, to := permission["fromPort"], permission["toPort"]
+	if from == nil {
+		return protocol
+	}
+	if fmt.Sprint(from) == fmt.Sprint(to) {
+		return fmt.Sprintf("%s port %v", protocol, from)
+	}
+	return fmt.Sprintf("%s ports %v-%v", protocol, from, to)
+}
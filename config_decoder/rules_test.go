@@ -0,0 +1,88 @@
+package config_decoder
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//rulesSnapshot has a resource of each kind the BuiltinRules check, with and without problems
+const rulesSnapshot = `{"fileVersion":"1.0","configSnapshotId":"x","configurationItems":[
+	{"resourceType":"AWS::S3::Bucket","resourceId":"public-acl","supplementaryConfiguration":{
+		"AccessControlList":"{\"grantList\":[{\"grantee\":\"AllUsers\",\"permission\":\"Read\"},{\"grantee\":{\"id\":\"abc\"},\"permission\":\"FullControl\"}]}"}},
+	{"resourceType":"AWS::S3::Bucket","resourceId":"public-policy","supplementaryConfiguration":{
+		"BucketPolicy":{"policyText":"{\"Statement\":{\"Effect\":\"Allow\",\"Principal\":{\"AWS\":[\"*\"]},\"Action\":\"s3:GetObject\"}}"}}},
+	{"resourceType":"AWS::S3::Bucket","resourceId":"blocked","supplementaryConfiguration":{
+		"AccessControlList":"{\"grantList\":[{\"grantee\":\"AllUsers\",\"permission\":\"Read\"}]}",
+		"BucketPolicy":{"policyText":"{\"Statement\":[{\"Effect\":\"Allow\",\"Principal\":\"*\",\"Action\":\"s3:GetObject\"}]}"},
+		"PublicAccessBlockConfiguration":{"blockPublicAcls":true,"ignorePublicAcls":true,"blockPublicPolicy":true,"restrictPublicBuckets":true}}},
+	{"resourceType":"AWS::S3::Bucket","resourceId":"conditional","supplementaryConfiguration":{
+		"BucketPolicy":{"policyText":"{\"Statement\":[{\"Effect\":\"Allow\",\"Principal\":\"*\",\"Action\":\"s3:GetObject\",\"Condition\":{\"IpAddress\":{\"aws:SourceIp\":\"10.0.0.0/8\"}}}]}"}}},
+	{"resourceType":"AWS::EC2::Volume","resourceId":"vol-plain","configuration":{"encrypted":false}},
+	{"resourceType":"AWS::EC2::Volume","resourceId":"vol-encrypted","configuration":{"encrypted":true}},
+	{"resourceType":"AWS::EC2::SecurityGroup","resourceId":"sg-open","configuration":{"ipPermissions":[
+		{"ipProtocol":"tcp","fromPort":22,"toPort":22,"ipRanges":["0.0.0.0/0"],"ipv4Ranges":[{"cidrIp":"0.0.0.0/0"}]},
+		{"ipProtocol":"-1","ipv6Ranges":[{"cidrIpv6":"::/0"}]},
+		{"ipProtocol":"tcp","fromPort":5432,"toPort":5432,"ipv4Ranges":[{"cidrIp":"10.0.0.0/8"}]}]}},
+	{"resourceType":"AWS::EC2::SecurityGroup","resourceId":"sg-private","configuration":{"ipPermissions":[
+		{"ipProtocol":"tcp","fromPort":0,"toPort":65535,"ipv4Ranges":[{"cidrIp":"10.0.0.0/8"}]}]}}]}`
+
+//findings decodes rulesSnapshot checking rules, returning the findings as "resourceId rule: message"
+func findings(t *testing.T, rules ...string) []string {
+	spec := benchSpec
+	spec.Source = "rules.json"
+	found := &CollectorWriter{}
+	spec.Rules = ItemRules{Rules: rules, Findings: found}
+	cw := &CollectorWriter{}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), strings.NewReader(rulesSnapshot), CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	if cw.Count() != 8 {
+		t.Errorf("decoded %d items, want 8", cw.Count())
+	}
+
+	var lines []string
+	for _, f := range found.Items() {
+		if f["source_file"] != "rules.json" || f["source_index"] == nil {
+			t.Errorf("finding %v doesn't locate its item", f)
+		}
+		lines = append(lines, f["resourceId"].(string)+" "+f["rule"].(string)+": "+f["message"].(string))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestItemRules(t *testing.T) {
+	want := []string{
+		"public-acl s3-bucket-public: ACL grants Read to AllUsers",
+		"public-policy s3-bucket-public: bucket policy allows anyone s3:GetObject",
+		"sg-open security-group-open-ingress: ingress from 0.0.0.0/0 to tcp port 22",
+		"sg-open security-group-open-ingress: ingress from ::/0 to all traffic",
+		"vol-plain ebs-volume-unencrypted: volume is not encrypted",
+	}
+	if got := findings(t, "all"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	got := findings(t, "ebs-volume-unencrypted")
+	if len(got) != 1 || got[0] != want[4] {
+		t.Errorf("findings of one rule %v, want %q", got, want[4])
+	}
+	if got := findings(t); len(got) != 0 {
+		t.Errorf("findings %v without rules", got)
+	}
+}
+
+func TestItemRulesValidate(t *testing.T) {
+	for _, r := range []ItemRules{{Rules: []string{"no-such-rule"}, Findings: NullWriter{}}, {Rules: []string{"all"}}} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("%+v: no error", r)
+		}
+	}
+	if err := (ItemRules{}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
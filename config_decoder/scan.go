@@ -0,0 +1,259 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+//scanChunkSize is the size of the reads made while scanning
+const scanChunkSize = 1 << 20
+
+//span locates the value of a top-level field in a document
+// The value occupies bytes [Start, End), possibly followed by whitespace.
+type span struct {
+	Key   string
+	Start int64
+	End   int64
+}
+
+//scanTopLevel finds the values of the top-level fields of the json object in r
+// It tracks structure byte by byte without decoding values, so it's cheap to run over large documents.
+// If the document is truncated, the fields found before it ended are returned with the error, the last
+// ending at size if its value was cut off, so what was delivered can still be decoded.
+func scanTopLevel(r io.ReaderAt, size int64) ([]span, error) {
+	var spans []span
+	var key []byte
+
+	depth := 0
+	inString, escape := false, false
+	inKey, expectKey, expectValue := false, false, false
+	started := false
+	var cur span
+
+	buf := make([]byte, scanChunkSize)
+	for off := int64(0); off < size; off += scanChunkSize {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("scanTopLevel: %w", err)
+		}
+
+		for i, c := range buf[:n] {
+			pos := off + int64(i)
+
+			if inString {
+				if inKey {
+					key = append(key, c)
+				}
+				switch {
+				case escape:
+					escape = false
+				case c == '\\':
+					escape = true
+				case c == '"':
+					inString = false
+					if inKey {
+						inKey = false
+						if err := json.Unmarshal(key, &cur.Key); err != nil {
+							return nil, fmt.Errorf("scanTopLevel: bad key at offset %d: %w", pos, err)
+						}
+					}
+				}
+				continue
+			}
+
+			if isSpace(c) {
+				continue
+			}
+
+			if depth == 0 {
+				if !started && c != '{' {
+					return nil, fmt.Errorf("scanTopLevel: %w: unexpected %q at offset %d", ErrNotObject, c, pos)
+				}
+				if started {
+					return nil, fmt.Errorf("scanTopLevel: unexpected %q at offset %d", c, pos)
+				}
+				started, expectKey, depth = true, true, 1
+				continue
+			}
+
+			if depth == 1 {
+				switch {
+				case expectKey && c == '"':
+					expectKey, inKey, inString = false, true, true
+					key = append(key[:0], c)
+					continue
+				case expectKey && c == '}' && len(spans) == 0:
+					depth = 0
+					continue
+				case expectKey:
+					return nil, fmt.Errorf("scanTopLevel: want field name at offset %d, got %q", pos, c)
+				case c == ':':
+					expectValue = true
+					continue
+				case expectValue:
+					expectValue = false
+					cur.Start = pos
+				case c == ',' || c == '}':
+					cur.End = pos
+					spans = append(spans, cur)
+					cur = span{}
+					if c == ',' {
+						expectKey = true
+					} else {
+						depth = 0
+					}
+					continue
+				}
+			}
+
+			switch c {
+			case '"':
+				inString = true
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+	}
+
+	if !started || depth != 0 || inString {
+		if cur.Start > 0 {
+			cur.End = size
+			spans = append(spans, cur)
+		}
+		return spans, fmt.Errorf("scanTopLevel: document is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	return spans, nil
+}
+
+//isSpace reports whether c is json whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+//itemRange locates a run of whole items in an items array
+// Items occupy bytes [Start, End), separated by commas.
+type itemRange struct {
+	Start int64
+	End   int64
+	Count int
+}
+
+//scanItemRanges splits the items array at s into ranges of whole items of about target bytes each
+// Like scanTopLevel, it tracks structure byte by byte without decoding the items.
+func scanItemRanges(r io.ReaderAt, s span, target int64) ([]itemRange, error) {
+	var ranges []itemRange
+	var cur itemRange
+
+	depth := 0
+	inString, escape := false, false
+	inItem, open, afterComma := false, false, false
+	var lastByte int64
+
+	buf := make([]byte, scanChunkSize)
+	for off := s.Start; off < s.End; off += scanChunkSize {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("scanItemRanges: %w", err)
+		}
+		if rest := s.End - off; int64(n) > rest {
+			n = int(rest)
+		}
+
+		for i, c := range buf[:n] {
+			pos := off + int64(i)
+
+			if inString {
+				switch {
+				case escape:
+					escape = false
+				case c == '\\':
+					escape = true
+				case c == '"':
+					inString = false
+				}
+				lastByte = pos
+				continue
+			}
+
+			if isSpace(c) {
+				continue
+			}
+
+			if depth == 0 {
+				if c != '[' || open {
+					return nil, fmt.Errorf("scanItemRanges: want '[' at offset %d, got %q", pos, c)
+				}
+				depth, open = 1, true
+				continue
+			}
+
+			if depth == 1 && (c == ',' || c == ']') {
+				if !inItem {
+					if c == ',' || afterComma {
+						return nil, fmt.Errorf("scanItemRanges: missing item at offset %d", pos)
+					}
+					return ranges, nil
+				}
+
+				inItem, afterComma = false, c == ','
+				cur.End = lastByte + 1
+				cur.Count++
+				if c == ']' || cur.End-cur.Start >= target {
+					ranges = append(ranges, cur)
+					cur = itemRange{}
+				}
+				if c == ']' {
+					return ranges, nil
+				}
+				continue
+			}
+
+			if depth == 1 && !inItem {
+				inItem, afterComma = true, false
+				if cur.Count == 0 {
+					cur.Start = pos
+				}
+			}
+
+			switch c {
+			case '"':
+				inString = true
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			lastByte = pos
+		}
+	}
+
+	return nil, fmt.Errorf("scanItemRanges: items array is truncated")
+}
+
+//TopLevelFields returns the names of the top-level fields of the json object prefix begins, as far as
+// prefix goes, such as to tell what kind of document it is from its first bytes
+// A field whose value is cut off by the end of prefix is included; nothing is returned if prefix
+// doesn't begin an object.
+func TopLevelFields(prefix []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(prefix))
+	if err := expectObject(dec); err != nil {
+		return nil
+	}
+	var fields []string
+	for dec.More() {
+		t, err := dec.Token()
+		key, ok := t.(string)
+		if err != nil || !ok {
+			break
+		}
+		fields = append(fields, key)
+		if err := skip(dec); err != nil {
+			break
+		}
+	}
+	return fields
+}
@@ -0,0 +1,268 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+//scanField is a top-level field as scanTopLevel should find it, its value as it appears in the document
+type scanField struct {
+	key, value string
+}
+
+//scanned returns the fields of spans in doc, each value less any whitespace after it
+func scanned(doc []byte, spans []span) []scanField {
+	var fields []scanField
+	for _, s := range spans {
+		fields = append(fields, scanField{s.Key, string(bytes.TrimRight(doc[s.Start:s.End], " \t\r\n"))})
+	}
+	return fields
+}
+
+func TestScanTopLevel(t *testing.T) {
+	long := `"` + strings.Repeat(`\"{`, scanChunkSize/2) + `"`
+
+	tests := []struct {
+		name      string
+		doc       string
+		want      []scanField
+		truncated bool
+		err       bool
+	}{
+		{name: "fields", doc: `{"a":1,"b":"x","c":null}`, want: []scanField{{"a", "1"}, {"b", `"x"`}, {"c", "null"}}},
+		{name: "empty", doc: `{}`},
+		{name: "whitespace", doc: " {\n \"a\" : 1 ,\t\"b\" :\r\n[ ] \n} ", want: []scanField{{"a", "1"}, {"b", "[ ]"}}},
+		{name: "braces in strings", doc: `{"a":"}{][,:","b":{"c":"]"},"d":1}`,
+			want: []scanField{{"a", `"}{][,:"`}, {"b", `{"c":"]"}`}, {"d", "1"}}},
+		{name: "escaped quotes", doc: `{"a":"say \"hi\" {","b":"c:\\","c":[1]}`,
+			want: []scanField{{"a", `"say \"hi\" {"`}, {"b", `"c:\\"`}, {"c", "[1]"}}},
+		{name: "escaped keys", doc: `{"k\"ey":1,"\u0062":2,"{":3}`, want: []scanField{{`k"ey`, "1"}, {"b", "2"}, {"{", "3"}}},
+		{name: "nested", doc: `{"a":[[[{"b":[{}]}]]],"c":{"d":{"e":{"f":[]}}},"g":0}`,
+			want: []scanField{{"a", `[[[{"b":[{}]}]]]`}, {"c", `{"d":{"e":{"f":[]}}}`}, {"g", "0"}}},
+		{name: "chunks", doc: `{"a":` + long + `,"b":{"c":"}"}}`, want: []scanField{{"a", long}, {"b", `{"c":"}"}`}}},
+
+		{name: "truncated value", doc: `{"a":1,"b":{"c":"x`, want: []scanField{{"a", "1"}, {"b", `{"c":"x`}}, truncated: true},
+		{name: "truncated in string", doc: `{"a":"}{`, want: []scanField{{"a", `"}{`}}, truncated: true},
+		{name: "truncated key", doc: `{"a":1,"b`, want: []scanField{{"a", "1"}}, truncated: true},
+		{name: "truncated after colon", doc: `{"a":1,"b":`, want: []scanField{{"a", "1"}}, truncated: true},
+		{name: "truncated before close", doc: `{"a":[1]`, want: []scanField{{"a", "[1]"}}, truncated: true},
+		{name: "nothing", doc: ``, truncated: true},
+
+		{name: "not an object", doc: `[{"a":1}]`, err: true},
+		{name: "trailing data", doc: `{"a":1} {}`, err: true},
+		{name: "no field name", doc: `{1:2}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := []byte(tt.doc)
+			spans, err := scanTopLevel(bytes.NewReader(doc), int64(len(doc)))
+
+			switch {
+			case tt.truncated:
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("error %v, want truncated", err)
+				}
+			case tt.err:
+				if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Fatalf("error %v, want one not of truncation", err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			got := scanned(doc, spans)
+			if len(got) != len(tt.want) {
+				t.Fatalf("found %d fields, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("field %d = %.40q, want %.40q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	if _, err := scanTopLevel(bytes.NewReader([]byte(`[]`)), 2); !errors.Is(err, ErrNotObject) {
+		t.Errorf("error %v, want ErrNotObject", err)
+	}
+}
+
+func FuzzScanTopLevel(f *testing.F) {
+	for _, seed := range snapshotSeeds() {
+		f.Add(seed)
+	}
+	f.Add([]byte(`{"a":"say \"hi\" {","b":"c:\\","k\"ey":[[{"}":"]"}]]}`))
+	f.Add([]byte(" {\n \"a\" : 1 ,\t\"b\" :\r\n[ ] \n} "))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		spans, err := scanTopLevel(bytes.NewReader(data), int64(len(data)))
+		for _, s := range spans {
+			if s.Start < 0 || s.Start > s.End || s.End > int64(len(data)) {
+				t.Fatalf("span %+v outside the %d byte document %q", s, len(data), data)
+			}
+		}
+
+		trimmed := bytes.TrimSpace(data)
+		if !json.Valid(trimmed) || trimmed[0] != '{' {
+			return
+		}
+
+		// a valid object's fields are found as encoding/json decodes them
+		if err != nil {
+			t.Fatalf("scan of valid object %q: %s", data, err)
+		}
+		var want []scanField
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if _, err := dec.Token(); err != nil {
+			t.Fatal(err)
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, scanField{key.(string), string(value)})
+		}
+
+		got := scanned(data, spans)
+		if len(got) != len(want) {
+			t.Fatalf("scan of %q found %d fields, want %d", data, len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("scan of %q: field %d = %q, want %q", data, i, got[i], want[i])
+			}
+		}
+	})
+}
+
+func TestScanItemRanges(t *testing.T) {
+	items := []string{`{"a":"],[,"}`, `{"b":["\"]"]}`, `1`, `"x"`, `[{"c":{}}]`, `null`}
+	doc := []byte(`{"items": [ ` + strings.Join(items, " ,\n") + ` ] }`)
+	spans, err := scanTopLevel(bytes.NewReader(doc), int64(len(doc)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []int64{1, 20, 1 << 20} {
+		ranges, err := scanItemRanges(bytes.NewReader(doc), spans[0], target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the ranges follow one another, each holding whole items, together all of them
+		var got []string
+		for i, r := range ranges {
+			if i > 0 && !bytes.Equal(bytes.TrimSpace(doc[ranges[i-1].End:r.Start]), []byte(",")) {
+				t.Errorf("target %d: %q between ranges %d and %d", target, doc[ranges[i-1].End:r.Start], i-1, i)
+			}
+			var values []json.RawMessage
+			if err := json.Unmarshal([]byte("["+string(doc[r.Start:r.End])+"]"), &values); err != nil {
+				t.Fatalf("target %d: range %d %q: %s", target, i, doc[r.Start:r.End], err)
+			}
+			if len(values) != r.Count {
+				t.Errorf("target %d: range %d holds %d items, counted %d", target, i, len(values), r.Count)
+			}
+			for _, v := range values {
+				got = append(got, string(v))
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(items, " ") {
+			t.Errorf("target %d: items %v, want %v", target, got, items)
+		}
+		if target == 1 && len(ranges) != len(items) {
+			t.Errorf("target 1: %d ranges, want one per item", len(ranges))
+		}
+		if target == 1<<20 && len(ranges) != 1 {
+			t.Errorf("target 1 MiB: %d ranges, want 1", len(ranges))
+		}
+	}
+
+	for _, bad := range []string{`{"items":[1,,2]}`, `{"items":[1,]}`, `{"items":{}}`, `{"items":[1,2`} {
+		spans, _ := scanTopLevel(bytes.NewReader([]byte(bad)), int64(len(bad)))
+		if len(spans) == 0 {
+			t.Fatalf("%s: no items field found", bad)
+		}
+		if _, err := scanItemRanges(bytes.NewReader([]byte(bad)), spans[0], 1); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+	empty := []byte(`{"items":[ ]}`)
+	spans, _ = scanTopLevel(bytes.NewReader(empty), int64(len(empty)))
+	if ranges, err := scanItemRanges(bytes.NewReader(empty), spans[0], 1); err != nil || len(ranges) != 0 {
+		t.Errorf("empty array: %v, %v; want no ranges", ranges, err)
+	}
+}
+
+//TestDecodersEmitOnce checks parallel decoders emit each item exactly once, with the provenance a single
+// decoder gives it
+func TestDecodersEmitOnce(t *testing.T) {
+	const count = 3000
+	doc := benchSnapshot(count, 200)
+
+	decode := func(decoders int) map[int64]map[string]any {
+		spec := benchSpec
+		spec.Decoders = decoders
+		cw := &CollectorWriter{}
+		chStatus, chErrors := DecodeAndSplitItemsAt(context.Background(), bytes.NewReader(doc), int64(len(doc)),
+			CollectorWriterFactory(cw), PoolSpec{Size: 4}, spec)
+		for err := range chErrors {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			<-chStatus
+		}
+
+		byIndex := make(map[int64]map[string]any)
+		for _, item := range cw.Items() {
+			index := toInt64(item["source_index"])
+			if _, ok := byIndex[index]; ok {
+				t.Errorf("%d decoders: item %d emitted twice", decoders, index)
+			}
+			if want := fmt.Sprintf("r-%08d", index); item["resourceId"] != want {
+				t.Errorf("%d decoders: item %d is %v, want %s", decoders, index, item["resourceId"], want)
+			}
+			byIndex[index] = item
+		}
+		if len(byIndex) != count {
+			t.Errorf("%d decoders: emitted %d items, want %d", decoders, len(byIndex), count)
+		}
+		return byIndex
+	}
+
+	// the document is split into several ranges
+	if len(doc)/(minRangeSize) < 4 {
+		t.Fatalf("a %d byte document isn't split", len(doc))
+	}
+	want := decode(1)
+	for _, decoders := range []int{2, 4, 8} {
+		for index, item := range decode(decoders) {
+			for _, k := range []string{"source_offset_start", "source_offset_end"} {
+				if toInt64(item[k]) != toInt64(want[index][k]) {
+					t.Errorf("%d decoders: item %d %s = %v, want %v", decoders, index, k, item[k], want[index][k])
+				}
+			}
+		}
+	}
+}
+
+//toInt64 returns v, a provenance field, as an int64
+func toInt64(v any) int64 {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	}
+	return -1
+}
@@ -0,0 +1,72 @@
+package config_decoder
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+//ItemSelection chooses which items are decoded and emitted; the zero value emits every item
+// MaxItems stops decoding once that many items have been emitted (0 is unlimited), leaving
+// the rest of the input unread.
+// SampleRate emits each item with that probability, e.g. 0.01 for a 1-in-100 or 1% sample
+// (0 emits every item). Items not sampled are skipped without being decoded into maps.
+// Seed seeds the sample, so it can be repeated; a sample is only repeatable with one decoder.
+type ItemSelection struct {
+	MaxItems   int64
+	SampleRate float64
+	Seed       int64
+}
+
+//Validate checks the selection is usable
+func (s ItemSelection) Validate() error {
+	if s.MaxItems < 0 {
+		return fmt.Errorf("ItemSelection: MaxItems %d is negative", s.MaxItems)
+	}
+	if s.SampleRate < 0 || s.SampleRate > 1 {
+		return fmt.Errorf("ItemSelection: SampleRate %g is not between 0 and 1", s.SampleRate)
+	}
+	return nil
+}
+
+//errMaxItems ends decoding once ItemSelection.MaxItems items have been emitted
+var errMaxItems = errors.New("maximum items emitted")
+
+//selector applies an ItemSelection to items before they are decoded
+// It's safe for use by concurrent decoders; a nil selector selects every item.
+type selector struct {
+	selection ItemSelection
+	mu        sync.Mutex
+	rnd       *rand.Rand
+	emitted   atomic.Int64
+}
+
+func newSelector(selection ItemSelection) *selector {
+	if selection == (ItemSelection{}) {
+		return nil
+	}
+	return &selector{selection: selection, rnd: rand.New(rand.NewSource(selection.Seed))}
+}
+
+//next reports whether the next item is to be emitted, or errMaxItems if decoding is to stop
+func (s *selector) next() (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+
+	if s.selection.SampleRate > 0 {
+		s.mu.Lock()
+		sampled := s.rnd.Float64() < s.selection.SampleRate
+		s.mu.Unlock()
+		if !sampled {
+			return false, nil
+		}
+	}
+
+	if s.selection.MaxItems > 0 && s.emitted.Add(1) > s.selection.MaxItems {
+		return false, errMaxItems
+	}
+	return true, nil
+}
@@ -0,0 +1,225 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//sentinelMaxBody is the most the Logs Ingestion API accepts in one request
+const sentinelMaxBody = 1_000_000
+
+//SentinelConfig configures SentinelWriters, sending items to a Log Analytics workspace, and so
+// Microsoft Sentinel, with the Azure Monitor Logs Ingestion API
+// Endpoint is the logs ingestion endpoint of the data collection rule, or of its data collection
+// endpoint; RuleID is the rule's immutable id, and Stream the stream it declares, e.g.
+// Custom-ConfigItems_CL.
+// TenantID, ClientID and ClientSecret are those of the Entra ID (AAD) application the rule grants the
+// Monitoring Metrics Publisher role; Authority is the login endpoint, https://login.microsoftonline.com
+// by default.
+// BatchSize is the most items sent in each request, which is kept under the API's 1 MB limit too.
+// HTTPClient, if set, sends the requests; by default requests time out after a minute.
+type SentinelConfig struct {
+	Endpoint     string
+	RuleID       string
+	Stream       string
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	Authority    string
+	BatchSize    int
+	HTTPClient   *http.Client
+}
+
+//sentinelClient is shared by the SentinelWriters of one factory, which share its access token
+type sentinelClient struct {
+	cfg  SentinelConfig
+	http *http.Client
+	url  string
+	mu   sync.Mutex
+	// token is refreshed a minute before it expires
+	token   string
+	expires time.Time
+}
+
+//SentinelWriter is an ItemWriter posting items to a Log Analytics workspace in batches
+// Each item is given a TimeGenerated, its capture time, unless it has one, as tables require it.
+// Items are buffered until BatchSize are waiting, or another wouldn't fit in a request, so Flush must
+// be called after the last Write; the writer pool does so when its items channel is closed.
+// A failed request fails the Write that sent it; the rest of the batch is kept and sent with the next
+// batch.
+// A batch the API rejects as invalid is reported as a BatchError, not retried.
+type SentinelWriter struct {
+	client *sentinelClient
+	buf    *bytes.Buffer
+	batch  batch
+}
+
+//SentinelWriterFactory creates SentinelWriter objects sharing one connection pool and access token
+func SentinelWriterFactory(cfg SentinelConfig) (WriterFactory, error) {
+	if cfg.Endpoint == "" || cfg.RuleID == "" || cfg.Stream == "" {
+		return nil, fmt.Errorf("SentinelWriterFactory: Endpoint, RuleID and Stream are required")
+	}
+	if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, fmt.Errorf("SentinelWriterFactory: TenantID, ClientID and ClientSecret are required")
+	}
+	if cfg.Authority == "" {
+		cfg.Authority = "https://login.microsoftonline.com"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+
+	client := &sentinelClient{cfg: cfg, http: cfg.HTTPClient}
+	if client.http == nil {
+		client.http = &http.Client{Timeout: time.Minute}
+	}
+	client.url = fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=2023-01-01",
+		strings.TrimSuffix(cfg.Endpoint, "/"), url.PathEscape(cfg.RuleID), url.PathEscape(cfg.Stream))
+
+	return FactoryOf(func() ItemWriter {
+		return &SentinelWriter{client: client, buf: new(bytes.Buffer), batch: batch{size: cfg.BatchSize}}
+	}), nil
+}
+
+// Write implements ItemWriter for SentinelWriter
+func (sw *SentinelWriter) Write(item map[string]interface{}) error {
+	if _, ok := item["TimeGenerated"]; !ok {
+		if captured, ok := item["configurationItemCaptureTime"]; ok {
+			// a copy, as the caller may still hold the item
+			stamped := make(map[string]any, len(item)+1)
+			for k, v := range item {
+				stamped[k] = v
+			}
+			stamped["TimeGenerated"] = captured
+			item = stamped
+		}
+	}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	// the batch is a json array, so each item takes a separator or bracket too
+	if len(b)+2 > sentinelMaxBody {
+		return fmt.Errorf("SentinelWriter: item of %d bytes is over the API's limit", len(b))
+	}
+	if sw.buf.Len()+len(b)+2 > sentinelMaxBody {
+		if err := sw.batch.makeRoom(sw.Flush); err != nil {
+			return err
+		}
+	}
+
+	start := sw.buf.Len()
+	if sw.batch.n == 0 {
+		sw.buf.WriteByte('[')
+	} else {
+		sw.buf.WriteByte(',')
+	}
+	sw.buf.Write(b)
+	return sw.batch.add(sw.Flush, func() { sw.buf.Truncate(start) })
+}
+
+// BytesWritten implements ByteCounter for SentinelWriter, counting the requests the API accepted
+func (sw *SentinelWriter) BytesWritten() int64 {
+	return sw.batch.written
+}
+
+// Flush implements Flusher for SentinelWriter, posting any buffered items
+// The items are kept for another attempt if the request couldn't be made.
+func (sw *SentinelWriter) Flush() error {
+	err := sw.batch.flush(func() (int64, bool, error) {
+		// the closing bracket isn't buffered, so more items may follow a batch that's kept
+		body := append(sw.buf.Bytes(), ']')
+		retry, err := sw.client.post(body)
+		return int64(len(body)), retry, err
+	}, sw.buf.Reset)
+	if err != nil {
+		return fmt.Errorf("SentinelWriter: %w", err)
+	}
+	return nil
+}
+
+// Healthy implements HealthChecker for SentinelWriter, checking an access token can be had
+func (sw *SentinelWriter) Healthy() error {
+	_, err := sw.client.accessToken()
+	return err
+}
+
+//post sends a batch of items, reporting whether it should be retried
+func (c *sentinelClient) post(body []byte) (bool, error) {
+	token, err := c.accessToken()
+	if err != nil {
+		return true, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusUnauthorized:
+		// the token may have been revoked, so the next attempt gets another
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		return true, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	default:
+		return false, fmt.Errorf("batch rejected: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+}
+
+//accessToken returns a token for the Logs Ingestion API, getting one with the client credentials grant
+// if there's none, or it's about to expire
+func (c *sentinelClient) accessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+		"scope":         {"https://monitor.azure.com/.default"},
+	}
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(c.cfg.Authority, "/"), url.PathEscape(c.cfg.TenantID))
+	resp, err := c.http.PostForm(tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("access token: %w", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("access token: %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(b, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("access token: bad response: %s", bytes.TrimSpace(b))
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
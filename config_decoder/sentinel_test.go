@@ -0,0 +1,154 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//sentinelServer fakes Entra ID's token endpoint and a Logs Ingestion endpoint, collecting the items
+// posted; status, if set, is the response to the next post
+type sentinelServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	tokens int
+	posts  int
+	items  []map[string]any
+	status int
+}
+
+func newSentinelServer(t *testing.T) *sentinelServer {
+	s := &sentinelServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			if r.FormValue("client_secret") != "secret" || r.FormValue("scope") != "https://monitor.azure.com/.default" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			s.tokens++
+			_, _ = io.WriteString(w, `{"access_token":"token","expires_in":3600}`)
+		case "/dataCollectionRules/dcr-1/streams/Custom-ConfigItems_CL":
+			if r.Header.Get("Authorization") != "Bearer token" || r.URL.Query().Get("api-version") == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if s.status != 0 {
+				http.Error(w, "failed", s.status)
+				s.status = 0
+				return
+			}
+			var batch []map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.posts++
+			s.items = append(s.items, batch...)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSentinelWriter(t *testing.T) {
+	srv := newSentinelServer(t)
+	f, err := SentinelWriterFactory(SentinelConfig{Endpoint: srv.URL, RuleID: "dcr-1", Stream: "Custom-ConfigItems_CL",
+		TenantID: "tenant", ClientID: "app", ClientSecret: "secret", Authority: srv.URL, BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := f(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := func(id string) map[string]any {
+		return map[string]any{"resourceId": id, "configurationItemCaptureTime": "2024-01-02T03:04:05.000Z"}
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := w.Write(item(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failed post keeps the rest of the batch, failing only the item that sent it
+	srv.mu.Lock()
+	srv.status = http.StatusServiceUnavailable
+	srv.mu.Unlock()
+	if err := w.Write(item("d")); err == nil {
+		t.Error("no error from a failed post")
+	}
+	if err := w.Write(item("e")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.(Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, i := range srv.items {
+		ids = append(ids, i["resourceId"].(string))
+		if i["TimeGenerated"] != "2024-01-02T03:04:05.000Z" {
+			t.Errorf("item %v has no TimeGenerated", i)
+		}
+	}
+	if strings.Join(ids, " ") != "a b c e" {
+		t.Errorf("posted %v, want a b c e", ids)
+	}
+	if srv.tokens != 1 || srv.posts != 2 {
+		t.Errorf("%d tokens and %d posts, want 1 and 2", srv.tokens, srv.posts)
+	}
+	if w.(ByteCounter).BytesWritten() == 0 {
+		t.Error("no bytes written")
+	}
+
+	// a batch rejected for good is dropped, failing as a BatchError of all its items, and not counted
+	written := w.(ByteCounter).BytesWritten()
+	srv.mu.Lock()
+	srv.status = http.StatusBadRequest
+	srv.mu.Unlock()
+	if err := w.Write(item("f")); err != nil {
+		t.Fatal(err)
+	}
+	var be *BatchError
+	if err := w.Write(item("g")); !errors.As(err, &be) || be.Items != 2 {
+		t.Errorf("rejected batch failed with %v, want a BatchError of 2 items", err)
+	}
+	if w.(ByteCounter).BytesWritten() != written {
+		t.Errorf("%d bytes written, want %d", w.(ByteCounter).BytesWritten(), written)
+	}
+	if err := w.(Flusher).Flush(); err != nil || len(srv.items) != 4 {
+		t.Errorf("flushed %d items (%v) after the rejected batch, want it dropped", len(srv.items)-4, err)
+	}
+}
+
+func TestSentinelWriterRejected(t *testing.T) {
+	srv := newSentinelServer(t)
+	f, err := SentinelWriterFactory(SentinelConfig{Endpoint: srv.URL, RuleID: "dcr-1", Stream: "Custom-ConfigItems_CL",
+		TenantID: "tenant", ClientID: "app", ClientSecret: "wrong", Authority: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := f(context.Background(), 0)
+	if err := w.(HealthChecker).Healthy(); err == nil {
+		t.Error("healthy with bad credentials")
+	}
+
+	if _, err := SentinelWriterFactory(SentinelConfig{Endpoint: srv.URL}); err == nil {
+		t.Error("no error without a rule and stream")
+	}
+	if err := w.Write(map[string]any{"blob": strings.Repeat("x", sentinelMaxBody)}); err == nil {
+		t.Error("no error writing an item over the limit")
+	}
+}
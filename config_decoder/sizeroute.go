@@ -0,0 +1,131 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+//SizeRoute sends the items whose json is over MinSize bytes to writers from Factory, rather than the
+// default writers, as sinks limit the size of what they take
+// It counts the items it's sent, which may be read while they're written.
+type SizeRoute struct {
+	MinSize int
+	Factory WriterFactory
+	items   atomic.Int64
+}
+
+//Items returns the number of items sent to the route so far
+func (r *SizeRoute) Items() int64 {
+	return r.items.Load()
+}
+
+//SizeRoutedWriterFactory returns the factory of writers sending each item to the writer of the route
+// with the largest MinSize the item's json is over, or to the writer from f if it's over none
+// Each worker has a writer from every route's Factory, and from f. Items are marshalled to size them,
+// after any RecordEnvelope wrapping the factory returned, so they're sized as the sinks are sent them.
+func SizeRoutedWriterFactory(f WriterFactory, routes []*SizeRoute) WriterFactory {
+	if len(routes) == 0 {
+		return f
+	}
+	routes = append([]*SizeRoute(nil), routes...)
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].MinSize > routes[j].MinSize })
+
+	return func(ctx context.Context, worker int) (ItemWriter, error) {
+		w, err := f(ctx, worker)
+		if err != nil {
+			return nil, err
+		}
+		sw := sizeRoutedWriter{w: w, routes: routes, writers: make([]ItemWriter, len(routes))}
+		counting := isByteCounter(w)
+		for i, r := range routes {
+			if sw.writers[i], err = r.Factory(ctx, worker); err != nil {
+				return nil, fmt.Errorf("SizeRoutedWriterFactory: items over %d bytes: %w", r.MinSize, err)
+			}
+			counting = counting && isByteCounter(sw.writers[i])
+		}
+		if counting {
+			return countingSizeRoutedWriter{sw}, nil
+		}
+		return sw, nil
+	}
+}
+
+//isByteCounter reports whether w counts the bytes it writes
+func isByteCounter(w ItemWriter) bool {
+	_, ok := w.(ByteCounter)
+	return ok
+}
+
+//sizeRoutedWriter is an ItemWriter sending items to the writer of their SizeRoute, or to w
+// It passes Flush, Close and Healthy through to every writer implementing them; countingSizeRoutedWriter
+// totals their BytesWritten, if all of them count bytes.
+type sizeRoutedWriter struct {
+	w       ItemWriter
+	routes  []*SizeRoute
+	writers []ItemWriter
+}
+
+// Write implements ItemWriter for sizeRoutedWriter
+func (sw sizeRoutedWriter) Write(item map[string]interface{}) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("sizeRoutedWriter: %w", err)
+	}
+	for i, r := range sw.routes {
+		if len(b) > r.MinSize {
+			r.items.Add(1)
+			return sw.writers[i].Write(item)
+		}
+	}
+	return sw.w.Write(item)
+}
+
+// Flush implements Flusher for sizeRoutedWriter
+func (sw sizeRoutedWriter) Flush() error {
+	var errs []error
+	for _, w := range append([]ItemWriter{sw.w}, sw.writers...) {
+		if f, ok := w.(Flusher); ok {
+			errs = append(errs, f.Flush())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements io.Closer for sizeRoutedWriter
+func (sw sizeRoutedWriter) Close() error {
+	var errs []error
+	for _, w := range append([]ItemWriter{sw.w}, sw.writers...) {
+		errs = append(errs, closeWriter(w))
+	}
+	return errors.Join(errs...)
+}
+
+// Healthy implements HealthChecker for sizeRoutedWriter
+func (sw sizeRoutedWriter) Healthy() error {
+	for _, w := range append([]ItemWriter{sw.w}, sw.writers...) {
+		if hc, ok := w.(HealthChecker); ok {
+			if err := hc.Healthy(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//countingSizeRoutedWriter is a sizeRoutedWriter whose writers are all ByteCounters
+type countingSizeRoutedWriter struct {
+	sizeRoutedWriter
+}
+
+// BytesWritten implements ByteCounter for countingSizeRoutedWriter
+func (sw countingSizeRoutedWriter) BytesWritten() int64 {
+	n := sw.w.(ByteCounter).BytesWritten()
+	for _, w := range sw.writers {
+		n += w.(ByteCounter).BytesWritten()
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSizeRoutedWriterFactory(t *testing.T) {
+	// items of about 900, 1800 and 3800 bytes, with their metadata
+	var items [][]byte
+	for _, size := range []int{100, 1000, 3000} {
+		items = append(items, bytes.Trim(benchItems(100, size), "[]"))
+	}
+	doc := bytes.Replace(benchSnapshot(0, 0), []byte("[]"), []byte("["+string(bytes.Join(items, []byte(",")))+"]"), 1)
+
+	for name, decode := range provenanceDecoders {
+		var small, mid, large lockedBuffer
+		routes := []*SizeRoute{
+			{MinSize: 1500, Factory: FileWriterFactory(&mid, []byte{'\n'})},
+			{MinSize: 3000, Factory: FileWriterFactory(&large, []byte{'\n'})},
+		}
+		f := SizeRoutedWriterFactory(FileWriterFactory(&small, []byte{'\n'}), routes)
+		chStatus, chErrors := decode(doc, f, benchSpec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		<-chStatus
+		<-chStatus
+
+		for _, out := range []struct {
+			lb       *lockedBuffer
+			min, max int
+		}{{&small, 0, 1500}, {&mid, 1501, 3000}, {&large, 3001, 1 << 30}} {
+			lines := bytes.Split(bytes.TrimSpace(out.lb.b.Bytes()), []byte{'\n'})
+			if len(lines) != 100 {
+				t.Errorf("%s: routed %d items to the writer of %d to %d bytes, want 100", name, len(lines), out.min, out.max)
+			}
+			for _, line := range lines {
+				if len(line) < out.min || len(line) > out.max || !json.Valid(line) {
+					t.Errorf("%s: item of %d bytes routed to the writer of %d to %d bytes", name, len(line), out.min, out.max)
+				}
+			}
+		}
+		if routes[0].Items() != 100 || routes[1].Items() != 100 {
+			t.Errorf("%s: routes counted %d and %d items, want 100 each", name, routes[0].Items(), routes[1].Items())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+)
+
+//SkippedField totals the values of a top-level field that was neither copied nor the items field
+// Bytes is the size of the values in the uncompressed document.
+type SkippedField struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+//SkippedFields records the top-level fields decoders skipped, by name, so a run can show what it discarded
+// If raw is set, each skipped value is also written to it as a line of json naming its source and field:
+// {"source": "snapshot.json", "field": "configSnapshotId", "value": ...}. It's safe for use by concurrent
+// decoders; decoders sharing one total their fields.
+type SkippedFields struct {
+	mu     sync.Mutex
+	fields map[string]SkippedField
+	raw    io.Writer
+	err    error
+}
+
+//NewSkippedFields creates a SkippedFields with no fields, writing their raw values to raw unless it's nil
+func NewSkippedFields(raw io.Writer) *SkippedFields {
+	return &SkippedFields{fields: make(map[string]SkippedField), raw: raw}
+}
+
+//add records a skipped value of field key in the document source, and writes it if there's a raw writer
+// Values can't be written once a write has failed; the error is kept for Err.
+func (sf *SkippedFields) add(source, key string, value json.RawMessage) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	f := sf.fields[key]
+	f.Count++
+	f.Bytes += int64(len(value))
+	sf.fields[key] = f
+
+	if sf.raw == nil || sf.err != nil {
+		return
+	}
+	line, err := json.Marshal(struct {
+		Source string          `json:"source"`
+		Field  string          `json:"field"`
+		Value  json.RawMessage `json:"value"`
+	}{source, key, value})
+	if err == nil {
+		_, err = sf.raw.Write(append(line, '\n'))
+	}
+	if err != nil {
+		sf.err = fmt.Errorf("skipped field %q: %w", key, err)
+	}
+}
+
+//Fields returns the skipped values of each field so far
+func (sf *SkippedFields) Fields() map[string]SkippedField {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	fields := make(map[string]SkippedField, len(sf.fields))
+	for key, f := range sf.fields {
+		fields[key] = f
+	}
+	return fields
+}
+
+//Err returns the first error writing the raw values, if any
+func (sf *SkippedFields) Err() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.err
+}
+
+//String lists the skipped values and bytes of each field so far, in order of name
+func (sf *SkippedFields) String() string {
+	fields := sf.Fields()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	s := ""
+	for i, key := range keys {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s %d (%d bytes)", key, fields[key].Count, fields[key].Bytes)
+	}
+	return s
+}
+
+//FieldMatches counts the documents each of a spec's Fields was copied from, so fields never found,
+// as a typo or the wrong kind of document leaves them, can be reported once decoding ends
+// A document counts once its items array is reached: reading a stream, the fields after it aren't
+// copied. It's safe for use by concurrent decoders; decoders sharing one total their documents.
+type FieldMatches struct {
+	mu        sync.Mutex
+	documents int64
+	counts    map[string]int64
+}
+
+//NewFieldMatches creates a FieldMatches with no documents
+func NewFieldMatches() *FieldMatches {
+	return &FieldMatches{counts: make(map[string]int64)}
+}
+
+//add counts a document decoded with spec whose top-level fields copied from were keys
+func (fm *FieldMatches) add(spec ItemTransformSpec, keys []string) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	fm.documents++
+	for key := range spec.Fields {
+		if slices.Contains(keys, key) {
+			fm.counts[key]++
+		} else if _, ok := fm.counts[key]; !ok {
+			fm.counts[key] = 0
+		}
+	}
+}
+
+//Documents returns the number of documents counted so far
+func (fm *FieldMatches) Documents() int64 {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	return fm.documents
+}
+
+//Unmatched returns the names of the specs' Fields not found in any document so far, in order of name
+func (fm *FieldMatches) Unmatched() []string {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+	var unmatched []string
+	for key, n := range fm.counts {
+		if n == 0 {
+			unmatched = append(unmatched, key)
+		}
+	}
+	sort.Strings(unmatched)
+	return unmatched
+}
@@ -0,0 +1,92 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSkippedFields(t *testing.T) {
+	const doc = `{"fileVersion": "1.0", "note": "hello" ,
+		"deliveryInfo": {"bucket": "b", "keys": [1, 2]},
+		"configurationItems": [{"resourceId": "r1"}, {"resourceId": "r2"}],
+		"trailer": null
+	}`
+	for name, decode := range provenanceDecoders {
+		var raw bytes.Buffer
+		spec := benchSpec
+		spec.Source = "doc.json"
+		spec.Skipped = NewSkippedFields(&raw)
+		chStatus, chErrors := decode([]byte(doc), NullWriterFactory(), spec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		<-chStatus
+		<-chStatus
+
+		fields := spec.Skipped.Fields()
+		want := map[string]SkippedField{"note": {1, 7}, "deliveryInfo": {1, 31}, "trailer": {1, 4}}
+		if len(fields) != len(want) {
+			t.Errorf("%s: skipped %v, want %v", name, fields, want)
+		}
+		for key, f := range want {
+			if fields[key] != f {
+				t.Errorf("%s: skipped %s %+v, want %+v", name, key, fields[key], f)
+			}
+		}
+		if got := spec.Skipped.String(); got != "deliveryInfo 1 (31 bytes), note 1 (7 bytes), trailer 1 (4 bytes)" {
+			t.Errorf("%s: String is %q", name, got)
+		}
+
+		lines := strings.Split(strings.TrimSpace(raw.String()), "\n")
+		if len(lines) != 3 || !strings.Contains(raw.String(),
+			`{"source":"doc.json","field":"deliveryInfo","value":{"bucket":"b","keys":[1,2]}}`) {
+			t.Errorf("%s: raw values %q", name, raw.String())
+		}
+	}
+}
+
+func TestFieldMatches(t *testing.T) {
+	// the snapshot id follows the items, so a stream doesn't copy it to any of them
+	const doc = `{"fileVersion": "1.0", "configurationItems": [{"resourceId": "r1"}, {"resourceId": "r2"}], "configSnapshotId": "s-1"}`
+	for name, decode := range provenanceDecoders {
+		spec := benchSpec
+		spec.Matches = NewFieldMatches()
+		var snapshotIDs []string
+		for i := 0; i < 2; i++ {
+			f, output := fileDestination(t)
+			chStatus, chErrors := decode([]byte(doc), f, spec)
+			for err := range chErrors {
+				t.Fatalf("%s: %v", name, err)
+			}
+			<-chStatus
+			<-chStatus
+
+			for _, line := range strings.Split(strings.TrimSpace(string(output())), "\n") {
+				var item struct {
+					Snapshot map[string]string `json:"config_snapshot"`
+				}
+				if err := json.Unmarshal([]byte(line), &item); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if item.Snapshot["fileVersion"] != "1.0" {
+					t.Errorf("%s: item %s lacks the fileVersion", name, line)
+				}
+				snapshotIDs = append(snapshotIDs, item.Snapshot["configSnapshotId"])
+			}
+		}
+
+		want, wantIDs := []string(nil), []string{"s-1", "s-1", "s-1", "s-1"}
+		if name == "stream" {
+			want, wantIDs = []string{"configSnapshotId"}, []string{"", "", "", ""}
+		}
+		if n, unmatched := spec.Matches.Documents(), spec.Matches.Unmatched(); n != 2 || !reflect.DeepEqual(unmatched, want) {
+			t.Errorf("%s: %d documents left %v unmatched, want 2 and %v", name, n, unmatched, want)
+		}
+		if !reflect.DeepEqual(snapshotIDs, wantIDs) {
+			t.Errorf("%s: items have snapshot ids %q, want %q", name, snapshotIDs, wantIDs)
+		}
+	}
+}
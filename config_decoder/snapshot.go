@@ -1,29 +1,71 @@
-//Package config_decoder is used to decode AWS Config message streams
+//Package config_decoder is used to decode AWS Config message streams by the decode_config_history command; importers should use packages decode, transform, writers and pool instead
 package config_decoder
 
 // todo
 // check context.Done() in leaf funcs
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/mfrasier/decode_json_stream/version"
 	"io"
-	"os"
+	"slices"
 	"time"
 )
 
 //ItemTransformSpec specifies which fields to copy from parent to child items and the items field
-// Fields maps source key name to dest key name. If dest value is "", use the original name.
-//  The Field value must be of type string.
-// ItemsField identifies the key holding the array of items to split
-// Currently, the Fields must be encountered before ItemsField in the source stream
+// Its fields change as the command needs.
+//
+// Deprecated: importers should build a transform.Spec, which is part of the stable API.
 type ItemTransformSpec struct {
-	Fields     map[string]string
+	// Fields maps source key name to dest key name, or "" for the original name; values must be strings
+	// A stream copies only those before ItemsField, as the items after it have been written.
+	Fields map[string]string
+	// ItemsField identifies the key holding the array of items to split
 	ItemsField string
+	// Limits bounds the memory used by decoded items; the zero value is unlimited
+	Limits ItemLimits
+	// Decoders is the number of goroutines DecodeAndSplitItemsAt decodes the items array with
+	Decoders int
+	// Selection chooses which items are emitted; the zero value emits every item
+	Selection ItemSelection
+	// RunID is each item's run_id metadata
+	RunID string
+	// Source is each item's source_file metadata
+	Source string
+	// NoProvenance leaves out source_file, source_index and the source offsets
+	NoProvenance bool
+	// Envelope shapes how the metadata is added to each item
+	Envelope MetadataEnvelope
+	// Strict fails decoding with a SchemaError unless the top-level fields are exactly Fields and ItemsField
+	Strict bool
+	// Reproducible leaves out ingest_time, decoder_version and run_id
+	Reproducible bool
+	// Hash stamps each item with a hash of its content, if its Field is set
+	Hash ItemHash
+	// Rules check each item emitted, writing what they find to their own writer
+	Rules ItemRules
+	// Skipped, if set, records the top-level fields neither copied nor split
+	Skipped *SkippedFields `json:"-"`
+	// Matches, if set, counts the documents each of the Fields was found in
+	Matches *FieldMatches `json:"-"`
+	// Configurations, if set, decodes each distinct item configuration once
+	Configurations *ConfigurationCache `json:"-"`
+	// Clock, if set, tells the ingest_time rather than the system clock, even if Reproducible
+	Clock Clock `json:"-"`
+	// UseNumber decodes numbers as json.Numbers rather than float64s
+	UseNumber bool
+	// Verbatim holds each item's source fields as their json in the item, for FileEncoding.Verbatim;
+	// other writers would write them too
+	Verbatim bool
 }
 
 //WorkerStatus are worker status messages
+//
+// Deprecated: importers should use pool.WorkerStatus, which decode.Decode reports.
 type WorkerStatus struct {
 	WorkerNum  int
 	ItemCount  int
@@ -32,7 +74,57 @@ type WorkerStatus struct {
 	EndTime    string
 	Duration   time.Duration
 	ErrorCount int
-	Status     string
+	// BreakerTrips counts how often the worker's circuit breaker opened
+	BreakerTrips int
+	Status       string
+	// ResourceTypes totals the worker's items by resourceType
+	ResourceTypes map[string]ResourceTypeBytes
+	// Largest lists the worker's PoolSpec.Largest largest items, largest first
+	Largest []ItemSize
+}
+
+//ResourceTypeBytes totals the items of a resourceType
+// DecodedBytes is the size of the items in the uncompressed document, from their provenance offsets,
+// so it's 0 without provenance. WrittenBytes is their size as encoded for the sink, before any
+// compression, if the writer is a ByteCounter; items a circuit breaker held aren't counted.
+type ResourceTypeBytes struct {
+	Items        int
+	DecodedBytes int64
+	WrittenBytes int64
+}
+
+//ByteCounter is optionally implemented by ItemWriters able to report the bytes of the items they've written
+// BytesWritten is the running total of the items as encoded for the sink, before any compression.
+type ByteCounter interface {
+	BytesWritten() int64
+}
+
+//add counts item, decoded from decoded bytes of its document and written as written bytes
+func (status *WorkerStatus) add(resourceType string, decoded, written int64) {
+	if status.ResourceTypes == nil {
+		status.ResourceTypes = make(map[string]ResourceTypeBytes)
+	}
+	t := status.ResourceTypes[resourceType]
+	t.Items++
+	t.DecodedBytes += decoded
+	t.WrittenBytes += written
+	status.ResourceTypes[resourceType] = t
+}
+
+//decodedSize is the size of item in its document, from its provenance offsets, or 0 if it has none
+// Offsets are json.Numbers in items decoded again from a spool.
+func decodedSize(item map[string]any) int64 {
+	offset := func(key string) int64 {
+		switch v := item[key].(type) {
+		case int64:
+			return v
+		case json.Number:
+			n, _ := v.Int64()
+			return n
+		}
+		return 0
+	}
+	return offset("source_offset_end") - offset("source_offset_start")
 }
 
 //ItemWriter is the interface for item writers
@@ -40,6 +132,37 @@ type ItemWriter interface {
 	Write(map[string]interface{}) error
 }
 
+//WriterFactory creates the ItemWriter of worker <worker> of a writer pool
+// ctx is that of the decoding the pool writes for, and worker its index in the pool, from 0, so writers
+// may, for example, write to a file of their own. The pool creates its writers before any item is
+// written; an error ends decoding as a WriteError.
+type WriterFactory func(ctx context.Context, worker int) (ItemWriter, error)
+
+//FactoryOf adapts f, a factory of writers not needing their worker or context and unable to fail,
+// to a WriterFactory
+//
+// Deprecated: importers should use writers.Of.
+func FactoryOf(f func() ItemWriter) WriterFactory {
+	return func(context.Context, int) (ItemWriter, error) {
+		return f(), nil
+	}
+}
+
+//Flusher is optionally implemented by ItemWriters that buffer items
+// The writer pool calls Flush once a worker has written its last item. It then calls Close, of writers
+// implementing io.Closer, to release what they hold, such as a client shared with the pool's other writers.
+type Flusher interface {
+	Flush() error
+}
+
+//closeWriter closes w, if it's an io.Closer
+func closeWriter(w ItemWriter) error {
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 //NullWriter is a noop ItemWriter
 type NullWriter struct{}
 
@@ -50,88 +173,409 @@ func (nw NullWriter) Write(item map[string]interface{}) error {
 }
 
 // NullWriterFactory creates NullWriter objects
-func NullWriterFactory() func() ItemWriter {
-	return func() ItemWriter {
+//
+// Deprecated: importers should use writers.Null.
+func NullWriterFactory() WriterFactory {
+	return FactoryOf(func() ItemWriter {
 		return NullWriter{}
-	}
+	})
+}
+
+//FileEncoding is how FileWriters encode items
+// Items are json, each followed by Termination, or with Proto, ConfigurationItem messages each prefixed
+// by its length as a varint (see AppendDelimitedProtoItem). Canonical json (see AppendCanonicalJSON)
+// is byte for byte the same for the same item, however it was decoded. Verbatim json isn't HTML
+// escaped, and an item decoded with ItemTransformSpec.Verbatim has its source fields written in their
+// order in its document, as it has them, so it byte-compares with the source record.
+//
+// Deprecated: importers should encode files with writers.FileOptions.
+type FileEncoding struct {
+	Termination []byte
+	Proto       bool
+	Canonical   bool
+	Verbatim    bool
 }
 
 //FileWriter is an ItemWriter that writes to an io.Writer
-//todo add things like line terminator, if needed
+// Each FileWriter reuses its own marshal buffer, so one must not be shared between workers.
 type FileWriter struct {
-	writer      io.Writer
-	termination []byte
+	writer   io.Writer
+	encoding FileEncoding
+	buf      *bytes.Buffer
+	enc      *json.Encoder
+	written  *int64
+}
+
+//newFileWriter returns a FileWriter writing to w with encoding
+func newFileWriter(w io.Writer, encoding FileEncoding) FileWriter {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(!encoding.Verbatim)
+	return FileWriter{writer: w, encoding: encoding, buf: buf, enc: enc, written: new(int64)}
 }
 
 // WriteItem implements ItemWriter for FileWriter
 func (fw FileWriter) Write(item map[string]interface{}) error {
-	b, err := json.Marshal(item)
+	fw.buf.Reset()
+	switch {
+	case fw.encoding.Proto:
+		b, err := AppendDelimitedProtoItem(fw.buf.AvailableBuffer(), item)
+		if err != nil {
+			return err
+		}
+		fw.buf.Write(b)
+	case fw.encoding.Canonical:
+		b, err := AppendCanonicalJSON(fw.buf.AvailableBuffer(), item)
+		if err != nil {
+			return err
+		}
+		fw.buf.Write(b)
+		fw.buf.Write(fw.encoding.Termination)
+	default:
+		if fw.encoding.Verbatim {
+			if fields := verbatimOf(item); fields != nil {
+				return fw.writeVerbatim(item, fields)
+			}
+		}
+		if raw := rawFields(item); raw != nil {
+			return fw.writeRaw(item, raw)
+		}
+		if err := fw.enc.Encode(item); err != nil {
+			return err
+		}
+		// replace the encoder's newline with our termination
+		fw.buf.Truncate(fw.buf.Len() - 1)
+		fw.buf.Write(fw.encoding.Termination)
+	}
+	return fw.flushItem()
+}
+
+//flushItem writes the item encoded in the buffer
+func (fw FileWriter) flushItem() error {
+	_, err := fw.writer.Write(fw.buf.Bytes())
 	if err != nil {
 		return err
 	}
+	*fw.written += int64(fw.buf.Len())
 
-	if fw.termination != nil {
-		b = append(b, fw.termination...)
+	return nil
+}
+
+// BytesWritten implements ByteCounter for FileWriter
+func (fw FileWriter) BytesWritten() int64 {
+	return *fw.written
+}
+
+// Flush implements Flusher for FileWriter, flushing its io.Writer if that's a Flusher too
+func (fw FileWriter) Flush() error {
+	if f, ok := fw.writer.(Flusher); ok {
+		return f.Flush()
 	}
+	return nil
+}
 
-	_, err = fw.writer.Write(b)
+// FileWriterFactory creates FileWriter objects that write to io.Writer w
+//
+// Deprecated: importers should use writers.File.
+func FileWriterFactory(w io.Writer, termination []byte) WriterFactory {
+	return EncodedFileWriterFactory(w, FileEncoding{Termination: termination})
+}
+
+//EncodedFileWriterFactory creates FileWriter objects that write items to io.Writer w with encoding
+//
+// Deprecated: importers should use writers.File, with FileOptions for the encoding.
+func EncodedFileWriterFactory(w io.Writer, encoding FileEncoding) WriterFactory {
+	return FactoryOf(func() ItemWriter {
+		return newFileWriter(w, encoding)
+	})
+}
+
+//RoutedFileWriter is an ItemWriter that writes each item to the io.Writer of the destination its
+// NameTemplate names
+type RoutedFileWriter struct {
+	fw    FileWriter
+	route *NameTemplate
+	open  func(name string) (io.Writer, error)
+}
+
+// Write implements ItemWriter for RoutedFileWriter
+func (rw RoutedFileWriter) Write(item map[string]interface{}) error {
+	name, err := rw.route.Name(item)
 	if err != nil {
 		return err
 	}
+	if rw.fw.writer, err = rw.open(name); err != nil {
+		return err
+	}
+	return rw.fw.Write(item)
+}
 
-	return nil
+// BytesWritten implements ByteCounter for RoutedFileWriter
+func (rw RoutedFileWriter) BytesWritten() int64 {
+	return *rw.fw.written
 }
 
-// FileWriterFactory creates FileWriter objects that write to io.Writer w
-func FileWriterFactory(w io.Writer, termination []byte) func() ItemWriter {
-	return func() ItemWriter {
-		return FileWriter{w, termination}
+//RoutedFileWriterFactory creates RoutedFileWriter objects writing items with encoding to the io.Writer
+// open returns for the name route gives them; open is called for every item, by every worker, so it
+// must be safe for concurrent use, and return the same writer for the same name
+//
+// Deprecated: importers should use writers.Routed.
+func RoutedFileWriterFactory(route *NameTemplate, open func(name string) (io.Writer, error), encoding FileEncoding) WriterFactory {
+	return FactoryOf(func() ItemWriter {
+		return RoutedFileWriter{fw: newFileWriter(nil, encoding), route: route, open: open}
+	})
+}
+
+//PoolSpec specifies the writer pool
+// Its fields change as the command needs.
+//
+// Deprecated: importers should build a pool.Config, which is part of the stable API.
+type PoolSpec struct {
+	// Size is the number of ItemWriters in the pool
+	Size int
+	// Breaker configures the circuit breaker guarding each writer; the zero value disables it
+	Breaker BreakerConfig
+	// ReuseItems releases each item for reuse once written (see ReleaseItem)
+	ReuseItems bool
+	// StopOnError stops decoding at the first write error, as a WriteError
+	StopOnError bool
+	// Stats, if set, is updated by the workers as they write
+	Stats *PoolStats
+	// Largest is how many of its largest items each worker lists in its WorkerStatus
+	Largest int
+	// Clock, if set, tells the times of each WorkerStatus, and those Stats measures, rather than the system
+	// clock, and times the Breaker's cooldowns
+	Clock Clock
+}
+
+//WriteError is a write error that stopped decoding, with PoolSpec.StopOnError set, or the error of a
+// writer that couldn't be created; it wraps ErrWriterFailed as well as Err
+//
+// Deprecated: importers should use pool.WriteError, which a decode's error wraps.
+type WriteError struct {
+	Worker int
+	Err    error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("writer (%d): %s", e.Worker, e.Err)
+}
+
+func (e *WriteError) Unwrap() []error {
+	return []error{ErrWriterFailed, e.Err}
+}
+
+//TruncatedError is a document that ended before it was complete, as a partly delivered file does
+// Items is the number of whole items emitted before it ended, and Offset the end of the last of them
+// in the uncompressed document, or 0 if there was none.
+//
+// Deprecated: importers should use decode.TruncatedError, which a decode's error wraps.
+type TruncatedError struct {
+	Items  int64
+	Offset int64
+	Err    error
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("document is truncated, after %d items ending at offset %d: %s", e.Items, e.Offset, e.Err)
+}
+
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+//lastItem tracks the items emitted by a decoder, to report how much of a truncated document was decoded
+type lastItem struct {
+	count int64
+	end   int64
+}
+
+//truncated makes err, which stopped decoding, a TruncatedError if it's the document ending early
+func (last *lastItem) truncated(err error) error {
+	var te *TruncatedError
+	if errors.As(err, &te) || !endedEarly(err) {
+		return err
+	}
+	return &TruncatedError{Items: last.count, Offset: last.end, Err: err}
+}
+
+//endedEarly reports whether err is the end of a json document before it's complete
+// The decoder mostly reports input ending early as io.ErrUnexpectedEOF, but reading a token, as a
+// SyntaxError only distinguished by its message.
+func endedEarly(err error) bool {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Error() == "unexpected end of JSON input"
 	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
 }
 
 //WriterPool is a pool of <size> ItemWriters, created by the <writerFactory>
 type WriterPool struct {
 	size          int
-	writerFactory func() ItemWriter
+	writerFactory WriterFactory
+	breaker       BreakerConfig
+	reuseItems    bool
+	stats         *PoolStats
+	largest       int
+	clock         Clock
+	budget        *memoryBudget
+	stop          context.CancelCauseFunc
 	chItem        chan map[string]interface{}
 	chStatus      chan WorkerStatus
 }
 
 //NewWriterPool creates and returns a WriterPool
-// Creates <size> ItemWriters, which read data items from <chData>
+// Creates <spec.Size> ItemWriters, which read data items from <chData>
+// If a writer can't be created, the pool's workers count the items they receive as errors.
 // todo report errors up
-func NewWriterPool(ctx context.Context, f func() ItemWriter, size int, chData chan map[string]any) WriterPool {
-	wp := WriterPool{writerFactory: f, size: size}
+func NewWriterPool(ctx context.Context, f WriterFactory, spec PoolSpec, chData chan map[string]any) WriterPool {
+	return newWriterPool(ctx, f, spec, chData, nil, nil)
+}
+
+//newWriterPool creates a WriterPool whose workers return written items' sizes to budget
+// With spec.StopOnError, the first write error is passed to stop, cancelling the decoder's context.
+// An error creating a writer is passed to stop regardless.
+func newWriterPool(ctx context.Context, f WriterFactory, spec PoolSpec, chData chan map[string]any, budget *memoryBudget, stop context.CancelCauseFunc) WriterPool {
+	wp := WriterPool{writerFactory: f, size: spec.Size, breaker: spec.Breaker, reuseItems: spec.ReuseItems, stats: spec.Stats,
+		largest: spec.Largest, clock: spec.Clock, budget: budget}
+	if spec.StopOnError {
+		wp.stop = stop
+	}
 	wp.chItem = chData
 	wp.chStatus = make(chan WorkerStatus, 8)
 
+	writers, err := wp.createWriters(ctx)
+	if err != nil {
+		logger.Error(err)
+		if stop != nil {
+			stop(err)
+		}
+		for c := 0; c < wp.size; c++ {
+			go wp.discard(c, c == err.Worker)
+		}
+		return wp
+	}
+
 	// init pool of <size> goroutines receiving from chData
-	for c := 0; c < size; c++ {
+	for c := 0; c < wp.size; c++ {
 		go func(ctx context.Context, worker int) {
-			w := wp.writerFactory()
+			w := writers[worker]
 
-			startTime := time.Now().UTC()
+			var cb *circuitBreaker
+			if wp.breaker.Threshold > 0 {
+				cb = newCircuitBreaker(wp.breaker, worker, w, wp.reuseItems, wp.budget, wp.clock)
+			}
+
+			startTime := wp.clock.now()
 			status := WorkerStatus{
 				WorkerNum: worker,
 				StartTime: startTime.Format(time.RFC3339Nano),
 				Status:    "starting",
 			}
+			live := &workerCounters{}
+			if wp.stats != nil {
+				live = wp.stats.worker(worker, startTime, wp.clock)
+			}
 
-			for i := range wp.chItem {
+			bc, _ := w.(ByteCounter)
+			for {
+				// an open breaker keeps probing its sink while no items arrive
+				i, ok := cb.receive(ctx, wp.chItem)
+				if !ok {
+					break
+				}
 				status.ItemCount++
+				resourceType, _ := i["resourceType"].(string)
+				decoded := decodedSize(i)
+				var before int64
+				if bc != nil {
+					before = bc.BytesWritten()
+				}
+				// the item is identified before it's written, as a reused item is cleared by the write
+				var itemSize ItemSize
+				if wp.largest > 0 {
+					itemSize = newItemSize(i, 0)
+					if bc == nil {
+						itemSize.Bytes = marshalledSize(i)
+					}
+				}
 
 				// todo should benchmark this to see if it's costly
-				status.ByteCount += len(fmt.Sprintf("%s", i))
+				// RawFields format as their size, so it's counted instead
+				n := len(fmt.Sprintf("%s", i)) + int(rawSize(i))
+				status.ByteCount += n
+				live.items.Add(1)
+				live.bytes.Add(int64(n))
 
-				err := w.Write(i)
+				// the item's charged to the budget as it was sent, before a write changes it
+				var size int64
+				if wp.budget != nil {
+					size = inFlightSize(i)
+				}
+
+				var err error
+				live.busy.Store(true)
+				if cb != nil {
+					// the breaker frees the item's size once it's done with it
+					err = cb.write(ctx, i, size)
+				} else {
+					err = w.Write(i)
+					if wp.reuseItems {
+						ReleaseItem(i)
+					}
+				}
+				live.busy.Store(false)
 				if err != nil {
-					status.ErrorCount++
-					_, _ = fmt.Fprintf(os.Stderr, "writer (%d) write error: %s", worker, err)
+					// a batch that failed for good fails the items sent with this one too
+					status.ErrorCount += itemErrors(err)
+					live.errors.Add(int64(itemErrors(err)))
+					logger.Errorf("writer (%d) write error: %s", worker, err)
+					if wp.stop != nil {
+						wp.stop(&WriteError{Worker: worker, Err: err})
+					}
+				}
+				var written int64
+				if bc != nil {
+					written = bc.BytesWritten() - before
+					itemSize.Bytes = written
+				}
+				status.add(resourceType, decoded, written)
+				if itemSize.Bytes > 0 {
+					status.Largest = KeepLargest(status.Largest, wp.largest, itemSize)
+				}
+
+				if cb == nil {
+					wp.budget.free(size)
 				}
 			}
 
+			if cb != nil {
+				if held, err := cb.drain(ctx); err != nil {
+					status.ErrorCount += held
+					live.errors.Add(int64(held))
+					logger.Errorf("writer (%d) write error: %s", worker, err)
+				}
+				status.BreakerTrips = cb.trips
+				live.trips.Add(int64(cb.trips))
+			}
+
+			if f, ok := w.(Flusher); ok {
+				live.busy.Store(true)
+				if err := f.Flush(); err != nil {
+					status.ErrorCount += itemErrors(err)
+					live.errors.Add(int64(itemErrors(err)))
+					logger.Errorf("writer (%d) flush error: %s", worker, err)
+				}
+				live.busy.Store(false)
+			}
+			if err := closeWriter(w); err != nil {
+				logger.Errorf("writer (%d) close error: %s", worker, err)
+			}
+
 			// populate status and signal with data
-			endTime := time.Now().UTC()
+			endTime := wp.clock.now()
+			live.end(endTime)
 			status.EndTime = endTime.Format(time.RFC3339Nano)
 			status.Duration = endTime.Sub(startTime)
 			status.Status = "ended normally"
@@ -142,6 +586,65 @@ func NewWriterPool(ctx context.Context, f func() ItemWriter, size int, chData ch
 	return wp
 }
 
+//createWriters creates the writer of each worker, stopping at the first that fails, as a WriteError, and
+// closing those already created
+func (wp WriterPool) createWriters(ctx context.Context) ([]ItemWriter, *WriteError) {
+	writers := make([]ItemWriter, wp.size)
+	for c := range writers {
+		w, err := wp.writerFactory(ctx, c)
+		if err != nil {
+			for _, w := range writers[:c] {
+				_ = closeWriter(w)
+			}
+			return nil, &WriteError{Worker: c, Err: fmt.Errorf("creating writer: %w", err)}
+		}
+		writers[c] = w
+	}
+	return writers, nil
+}
+
+//discard stands in for a worker of a pool whose writers couldn't all be created, counting the items it
+// receives as errors until decoding stops; failed is set for the worker whose writer failed
+func (wp WriterPool) discard(worker int, failed bool) {
+	status := WorkerStatus{WorkerNum: worker, StartTime: wp.clock.now().Format(time.RFC3339Nano)}
+	if failed {
+		status.ErrorCount++
+	}
+	for i := range wp.chItem {
+		status.ItemCount++
+		status.ErrorCount++
+		if wp.budget != nil {
+			wp.budget.free(inFlightSize(i))
+		}
+		if wp.reuseItems {
+			ReleaseItem(i)
+		}
+	}
+	status.EndTime = wp.clock.now().Format(time.RFC3339Nano)
+	status.Status = "not started"
+	wp.chStatus <- status
+}
+
+//newMetadata returns the metadata added to every item by spec, before any parent fields
+func newMetadata(spec ItemTransformSpec) map[string]any {
+	metadata := make(map[string]any)
+	metadata["event_type"] = "config_snapshot"
+	metadata["event_source"] = "something_useful"
+	if !spec.Reproducible || spec.Clock != nil {
+		metadata["ingest_time"] = spec.Clock.now().Format(time.RFC3339Nano)
+	}
+	if !spec.Reproducible {
+		metadata["decoder_version"] = version.Get().Version
+		if spec.RunID != "" {
+			metadata["run_id"] = spec.RunID
+		}
+	}
+	if spec.Source != "" && !spec.NoProvenance {
+		metadata["source_file"] = spec.Source
+	}
+	return metadata
+}
+
 //addMetadata adds data from original message to metadata for new message
 func addMetadata(metadata map[string]any, key string, val json.Token) error {
 	//snapshotKey is the new field where snapshot-specific data is added to metadata
@@ -165,47 +668,105 @@ func addMetadata(metadata map[string]any, key string, val json.Token) error {
 
 //DecodeAndSplitItems decodes json containing an array of items
 //persisting specified parent field values to the emitted item
-// todo make use of ctx
-func DecodeAndSplitItems(ctx context.Context, r io.Reader, writerFactory func() ItemWriter, poolSize int, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+// Decoding stops with an error when ctx is done, or at the first write error with poolSpec.StopOnError.
+// A document ending early is a TruncatedError, saying how many whole items were emitted.
+//
+// Deprecated: importers should use decode.Decode.
+func DecodeAndSplitItems(ctx context.Context, r io.Reader, writerFactory WriterFactory, poolSpec PoolSpec, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
 
 	cItems := make(chan map[string]any, 0)
-	cErrors := make(chan error, 0)
-	pool := NewWriterPool(ctx, writerFactory, poolSize, cItems)
+	// the decoder sends at most one error, so it never blocks if the caller has stopped listening
+	cErrors := make(chan error, 1)
+	guard := newItemGuard(spec.Limits)
+	sel := newSelector(spec.Selection)
+	ctx, stop := context.WithCancelCause(ctx)
+	pool := newWriterPool(ctx, writerFactory, poolSpec, cItems, guard.budget, stop)
 
 	//metadata is map of field additions from source to new item
-	metadata := make(map[string]any)
-	metadata["event_type"] = "config_snapshot"
-	metadata["event_source"] = "something_useful"
-	metadata["ingest_time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	metadata := newMetadata(spec)
 
 	go func() {
 		defer close(cItems)
 		defer close(cErrors)
+		defer guard.close()
 		dec := json.NewDecoder(r)
 
+		if err := spec.Limits.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+			return
+		}
+		if err := spec.Selection.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+			return
+		}
+		if err := spec.Envelope.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+			return
+		}
+		if err := spec.Hash.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+			return
+		}
+		if err := spec.Rules.Validate(); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+			return
+		}
+
 		// we expect the json document is an object
-		if err := expect(dec, json.Delim('{')); err != nil {
+		if err := expectObject(dec); err != nil {
 			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
 			return
 		}
 
+		var keys []string
+		var last lastItem
 		for dec.More() {
 			// get field name
 			t, err := dec.Token()
 			if err != nil {
-				cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+				cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", last.truncated(err))
 				return
 			}
 
+			tracef("token %v at offset %d", t, dec.InputOffset())
+
 			// handle fields
 			if f, ok := t.(string); ok {
+				if !spec.Strict && isDuplicate(spec, keys, f) {
+					logger.Warnf("top-level field %q is duplicated at offset %d; the input may be corrupt",
+						f, dec.InputOffset())
+				}
+				keys = append(keys, f)
 				if f == spec.ItemsField {
 					// items array
-					_, _ = fmt.Fprintf(os.Stderr, "handling %s array...\n", t)
-					err := decodeItems(dec, metadata, cItems, cErrors)
+					if spec.Strict {
+						if err := checkTopLevel(spec, keys, false); err != nil {
+							cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+							return
+						}
+					}
+					if spec.Matches != nil {
+						spec.Matches.add(spec, keys)
+					}
+					logger.Debugf("handling %s array...", t)
+					err := decodeItems(ctx, dec, itemSource{omit: spec.NoProvenance, envelope: spec.Envelope, hash: spec.Hash, rules: newRuleChecker(spec),
+						configs: spec.Configurations, last: &last, numbers: spec.UseNumber, verbatim: spec.Verbatim}, metadata, cItems, guard, sel)
+					if errors.Is(err, errMaxItems) {
+						// the rest of the document is left unread
+						logger.Infof("stopped after %d items", spec.Selection.MaxItems)
+						return
+					}
 					if err != nil {
 						// presume we can't continue. e.g. didn't find starting '['
-						cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+						cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", last.truncated(err))
+						return
+					}
+				} else if _, ok := spec.Fields[f]; ok && slices.Contains(keys, spec.ItemsField) {
+					// the items already emitted share metadata with the writers, so a field following
+					// them isn't copied, as Matches counts it
+					logger.Debugf("skipping field %q, which follows the %s array", f, spec.ItemsField)
+					if err := skip(dec); err != nil {
+						cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", last.truncated(err))
 						return
 					}
 				} else if tfv, ok := spec.Fields[f]; ok {
@@ -239,49 +800,223 @@ func DecodeAndSplitItems(ctx context.Context, r io.Reader, writerFactory func()
 					}
 				} else {
 					// skip value if not a field we want
-					_, _ = fmt.Fprintf(os.Stderr, "skipping field %q\n", t)
-					if err := skip(dec); err != nil {
-						cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
-						return
+					logger.Debugf("skipping field %q", t)
+					if spec.Skipped == nil {
+						if err := skip(dec); err != nil {
+							cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", last.truncated(err))
+							return
+						}
+					} else {
+						var raw json.RawMessage
+						if err := dec.Decode(&raw); err != nil {
+							cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", last.truncated(err))
+							return
+						}
+						spec.Skipped.add(spec.Source, f, raw)
 					}
 				}
 			} else {
-				fmt.Printf("token %v is not of type string\n", t)
+				logger.Warnf("token %v is not of type string", t)
 			}
 		}
 
-		fmt.Println("\ndecoder goroutine ended normally")
+		// a truncated document ends without the closing brace
+		if err := expect(dec, json.Delim('}')); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: end brace not found: %w", last.truncated(err))
+			return
+		}
+		if err := checkItemsField(spec, keys); err != nil {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: %w", err)
+			return
+		}
+		if t, err := dec.Token(); err != io.EOF {
+			cErrors <- fmt.Errorf("DecodeAndSplitItems: unexpected data after document: %v %v", t, err)
+			return
+		}
+
+		logger.Debug("decoder goroutine ended normally")
 	}()
 
 	return pool.chStatus, cErrors
 }
 
 //decodeItems decodes and emits new items, enriched with fields from transforms
-func decodeItems(dec *json.Decoder, metadata map[string]any, cItems chan map[string]any, cErrors chan error) error {
+// Decoding stops at the first malformed item, as the decoder can't resynchronize with the stream.
+// Items are measured and limited by guard when its limits are enabled, and chosen by sel;
+// errMaxItems is returned once sel's MaxItems have been emitted. Decoding stops when ctx is done,
+// with the cause of its cancellation. src locates dec's input in the document, so items' provenance
+// is relative to the document.
+func decodeItems(ctx context.Context, dec *json.Decoder, src itemSource, metadata map[string]any, cItems chan map[string]any, guard *itemGuard, sel *selector) error {
+	if src.numbers {
+		dec.UseNumber()
+	}
+	if guard != nil {
+		src.budget = guard.budget
+	}
 	// we expect a json array of items
 	if err := expect(dec, json.Delim('[')); err != nil {
 		return fmt.Errorf("decodeItems: begin bracket not found: %w", err)
 	}
 
+	// skipped items are only scanned, into a reused buffer
+	var skipped json.RawMessage
+
 	// while there are more json array elements ...
-	for dec.More() {
-		var v map[string]any
+	for index := src.index; dec.More(); index++ {
+		if ctx.Err() != nil {
+			return fmt.Errorf("decodeItems: %w", stopped(ctx))
+		}
+		start := src.base + itemStart(dec)
+		tracef("item %d at offset %d", index, start)
+		if ok, err := sel.next(); err != nil {
+			return err
+		} else if !ok {
+			if err := dec.Decode(&skipped); err != nil {
+				return fmt.Errorf("decodeItems: %w", err)
+			}
+			continue
+		}
 
-		if err := dec.Decode(&v); err != nil {
-			cErrors <- fmt.Errorf("decodeItems: %w", err)
+		// items written verbatim are held as their json, as the guard holds them
+		if guard != nil && (guard.limits.enabled() || src.verbatim) {
+			v, err := guard.decode(dec, src)
+			if err != nil {
+				return fmt.Errorf("decodeItems: %w", err)
+			}
+			if v != nil {
+				if err := src.emit(ctx, v, metadata, index, start, src.base+dec.InputOffset(), cItems); err != nil {
+					return fmt.Errorf("decodeItems: item %d: %w", index, err)
+				}
+			}
+			continue
 		}
 
-		// assign any parent values to item and signal the channel with data
-		v["metadata"] = metadata
-		for key, val := range metadata {
-			v[key] = val
+		v := getItem()
+		var err error
+		if src.configs != nil {
+			err = src.configs.decode(dec, v, src.numbers)
+		} else {
+			err = dec.Decode(&v)
+		}
+		if err != nil {
+			return fmt.Errorf("decodeItems: item %d: %w", index, objectError(err))
 		}
+		if v == nil {
+			return fmt.Errorf("decodeItems: item %d: %w: item is null", index, ErrNotObject)
+		}
+		if err := src.emit(ctx, v, metadata, index, start, src.base+dec.InputOffset(), cItems); err != nil {
+			return fmt.Errorf("decodeItems: item %d: %w", index, err)
+		}
+	}
 
-		cItems <- v
+	if err := expect(dec, json.Delim(']')); err != nil {
+		return fmt.Errorf("decodeItems: end bracket not found: %w", err)
+	}
+	return nil
+}
+
+//itemStart returns the offset in dec's input of the item following a call to dec.More
+// More stops at the separator before any but the first item, so it and whitespace after it are skipped.
+// Whitespace before the separator is already in dec's offset, though encoding/json built on json v2
+// leaves it buffered, so it isn't counted again.
+func itemStart(dec *json.Decoder) int64 {
+	off := dec.InputOffset()
+	r := dec.Buffered()
+	var b [1]byte
+	separated := false
+	for {
+		if n, _ := r.Read(b[:]); n == 0 {
+			return off
+		}
+		switch b[0] {
+		case ',':
+			separated = true
+			off++
+		case ' ', '\t', '\r', '\n':
+			if separated {
+				off++
+			}
+		default:
+			return off
+		}
+	}
+}
+
+//unmarshal decodes the json data into v, as json.Unmarshal does, its numbers as json.Numbers if useNumber is set
+func unmarshal(data []byte, v any, useNumber bool) error {
+	if !useNumber {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid character after top-level value")
 	}
 	return nil
 }
 
+//itemSource locates the input of a decoder of items in the document
+// at is the document, if it can be read again, base the offset of the input in it, and index the index
+// in the items array of its first item. Items aren't stamped with their provenance when omit is set,
+// get their metadata in the shape of envelope, are hashed by hash and checked by rules. last, if set,
+// tracks the items emitted. numbers decodes their numbers as json.Numbers. verbatim holds their source
+// fields to be written as they were. budget, if set, is charged for each item emitted.
+type itemSource struct {
+	at       io.ReaderAt
+	base     int64
+	index    int
+	omit     bool
+	envelope MetadataEnvelope
+	hash     ItemHash
+	rules    ruleChecker
+	configs  *ConfigurationCache
+	last     *lastItem
+	numbers  bool
+	verbatim bool
+	budget   *memoryBudget
+}
+
+//emit assigns any parent values to item, and its provenance: its index in the items array and the
+// byte range [start, end) it was decoded from, and signals the channel with data, unless ctx is done first
+func (src itemSource) emit(ctx context.Context, v map[string]any, metadata map[string]any, index int, start, end int64, cItems chan map[string]any) error {
+	if err := src.rules.check(v, index); err != nil {
+		return err
+	}
+	if err := src.hash.stamp(v, src.configs); err != nil {
+		return err
+	}
+	if err := src.envelope.wrap(v, metadata); err != nil {
+		return err
+	}
+	if !src.omit {
+		v["source_index"] = index
+		v["source_offset_start"] = start
+		v["source_offset_end"] = end
+	}
+	if src.last != nil {
+		src.last.count++
+		src.last.end = end
+	}
+
+	var size int64
+	if src.budget != nil {
+		size = inFlightSize(v)
+		if err := src.budget.acquire(ctx, size); err != nil {
+			return err
+		}
+	}
+	select {
+	case cItems <- v:
+		return nil
+	case <-ctx.Done():
+		src.budget.free(size)
+		return stopped(ctx)
+	}
+}
+
 // skip skips the next value in the JSON document.
 func skip(d *json.Decoder) error {
 	n := 0
@@ -303,6 +1038,23 @@ func skip(d *json.Decoder) error {
 	}
 }
 
+//skipRest skips the rest of the object or array whose opening delimiter was the last token read
+func skipRest(d *json.Decoder) error {
+	for n := 1; n > 0; {
+		t, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("skipRest: %w", err)
+		}
+		switch t {
+		case json.Delim('['), json.Delim('{'):
+			n++
+		case json.Delim(']'), json.Delim('}'):
+			n--
+		}
+	}
+	return nil
+}
+
 // expect returns an error if the next token in the document is not expectedT.
 func expect(d *json.Decoder, expectedT interface{}) error {
 	t, err := d.Token()
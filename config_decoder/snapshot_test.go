@@ -0,0 +1,376 @@
+package config_decoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var benchSpec = ItemTransformSpec{
+	Fields: map[string]string{
+		"configSnapshotId": "",
+		"fileVersion":      "",
+	},
+	ItemsField: "configurationItems",
+}
+
+//benchItems returns a json array of count config items, each carrying about size bytes of configuration
+func benchItems(count, size int) []byte {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		_, _ = fmt.Fprintf(&b, `{"awsAccountId":"123456789012","awsRegion":"us-east-1",`+
+			`"resourceId":"r-%08d","resourceType":"AWS::EC2::Instance",`+
+			`"configurationItemCaptureTime":"2022-08-01T21:59:26.276Z",`+
+			`"configuration":{"blob":%q,"port":443},"relationships":[],"tags":{}}`,
+			i, strings.Repeat("x", size))
+	}
+	b.WriteByte(']')
+	return b.Bytes()
+}
+
+//benchSnapshot returns a config snapshot document holding count items of about size bytes
+func benchSnapshot(count, size int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"fileVersion":"1.0","configSnapshotId":"0f1d63cc-aee4-48b8-82ab-4f38087be14e",`)
+	b.WriteString(`"configurationItems":`)
+	b.Write(benchItems(count, size))
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+//runPipeline decodes data through a writer pool, waiting for all workers to finish
+func runPipeline(b *testing.B, data []byte, f WriterFactory, poolSize int) {
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(data), f,
+		PoolSpec{Size: poolSize, ReuseItems: true}, benchSpec)
+
+	for err := range chErrors {
+		b.Fatal(err)
+	}
+	for i := 0; i < poolSize; i++ {
+		<-chStatus
+	}
+}
+
+//provenanceDecoders decode doc as DecodeAndSplitItems and DecodeAndSplitItemsAt would, the latter in parallel
+var provenanceDecoders = map[string]func(doc []byte, f WriterFactory, spec ItemTransformSpec) (chan WorkerStatus, chan error){
+	"stream": func(doc []byte, f WriterFactory, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+		return DecodeAndSplitItems(context.Background(), bytes.NewReader(doc), f, PoolSpec{Size: 2}, spec)
+	},
+	"at": func(doc []byte, f WriterFactory, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+		return DecodeAndSplitItemsAt(context.Background(), bytes.NewReader(doc), int64(len(doc)), f, PoolSpec{Size: 2}, spec)
+	},
+	"parallel": func(doc []byte, f WriterFactory, spec ItemTransformSpec) (chan WorkerStatus, chan error) {
+		spec.Decoders = 4
+		return DecodeAndSplitItemsAt(context.Background(), bytes.NewReader(doc), int64(len(doc)), f, PoolSpec{Size: 2}, spec)
+	},
+}
+
+func TestItemProvenance(t *testing.T) {
+	const count = 400
+	items := benchItems(count, 1000)
+	for _, sep := range []string{",", ", ", "\n,", " ,\n  ", "\r\n,\r\n"} {
+		doc := bytes.Replace(benchSnapshot(0, 0), []byte("[]"), bytes.ReplaceAll(items, []byte("},{"), []byte("}"+sep+"{")), 1)
+		doc = bytes.Replace(doc, []byte("["), []byte("[ \n"), 1)
+
+		for name, decode := range provenanceDecoders {
+			cw := &CollectorWriter{}
+			chStatus, chErrors := decode(doc, CollectorWriterFactory(cw), benchSpec)
+			for err := range chErrors {
+				t.Fatalf("%s %q: %v", name, sep, err)
+			}
+			<-chStatus
+			<-chStatus
+
+			seen := make(map[int]bool)
+			for _, item := range cw.Items() {
+				index := item["source_index"].(int)
+				start, end := item["source_offset_start"].(int64), item["source_offset_end"].(int64)
+				want := fmt.Sprintf(`"r-%08d"`, index)
+				if doc[start] != '{' || doc[end-1] != '}' || !bytes.Contains(doc[start:end], []byte(want)) {
+					t.Fatalf("%s %q: item %d at [%d, %d) is %.40q...", name, sep, index, start, end, doc[start:end])
+				}
+				seen[index] = true
+			}
+			if len(seen) != count {
+				t.Errorf("%s %q: %d distinct indexes of %d items", name, sep, len(seen), count)
+			}
+		}
+	}
+}
+
+func TestNoProvenance(t *testing.T) {
+	spec := benchSpec
+	spec.Source, spec.NoProvenance = "snapshot.json", true
+	for name, decode := range provenanceDecoders {
+		cw := &CollectorWriter{}
+		chStatus, chErrors := decode(benchSnapshot(3, 10), CollectorWriterFactory(cw), spec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		<-chStatus
+		<-chStatus
+
+		for _, item := range cw.Items() {
+			for _, k := range []string{"source_file", "source_index", "source_offset_start", "source_offset_end"} {
+				if _, ok := item[k]; ok {
+					t.Errorf("%s: item has %s with NoProvenance", name, k)
+				}
+			}
+		}
+	}
+}
+
+func TestResourceTypeBytes(t *testing.T) {
+	doc := benchSnapshot(10, 100)
+	// a second resource type, of larger items
+	doc = bytes.Replace(doc, []byte(`"r-00000003","resourceType":"AWS::EC2::Instance"`),
+		[]byte(`"r-00000003","resourceType":"AWS::S3::Bucket","supplementaryConfiguration":{"policy":"`+strings.Repeat("p", 500)+`"}`), 1)
+
+	for name, decode := range provenanceDecoders {
+		var out lockedBuffer
+		chStatus, chErrors := decode(doc, FileWriterFactory(&out, []byte{'\n'}), benchSpec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		totals := make(map[string]ResourceTypeBytes)
+		for i := 0; i < 2; i++ {
+			for resourceType, b := range (<-chStatus).ResourceTypes {
+				tb := totals[resourceType]
+				tb.Items += b.Items
+				tb.DecodedBytes += b.DecodedBytes
+				tb.WrittenBytes += b.WrittenBytes
+				totals[resourceType] = tb
+			}
+		}
+
+		ec2, s3 := totals["AWS::EC2::Instance"], totals["AWS::S3::Bucket"]
+		if ec2.Items != 9 || s3.Items != 1 {
+			t.Fatalf("%s: totals %v, want 9 EC2 instances and 1 S3 bucket", name, totals)
+		}
+		if s3.DecodedBytes <= 500 || ec2.DecodedBytes+s3.DecodedBytes >= int64(len(doc)) {
+			t.Errorf("%s: decoded %d and %d bytes of a %d byte document", name, ec2.DecodedBytes, s3.DecodedBytes, len(doc))
+		}
+		if written := ec2.WrittenBytes + s3.WrittenBytes; written != int64(out.b.Len()) {
+			t.Errorf("%s: counted %d bytes written, wrote %d", name, written, out.b.Len())
+		}
+	}
+}
+
+var benchSizes = []struct {
+	name  string
+	count int
+	size  int
+}{
+	{"small", 5000, 100},
+	{"large", 500, 10000},
+}
+
+func BenchmarkDecodeItems(b *testing.B) {
+	for _, bs := range benchSizes {
+		data := benchItems(bs.count, bs.size)
+		b.Run(bs.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				cItems := make(chan map[string]any)
+				go func() {
+					for i := range cItems {
+						ReleaseItem(i)
+					}
+				}()
+
+				dec := json.NewDecoder(bytes.NewReader(data))
+				if err := decodeItems(context.Background(), dec, itemSource{}, map[string]any{}, cItems, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+				close(cItems)
+			}
+		})
+	}
+}
+
+func BenchmarkNullWriter(b *testing.B) {
+	for _, bs := range benchSizes {
+		data := benchSnapshot(bs.count, bs.size)
+		for _, poolSize := range []int{1, 4, 8} {
+			b.Run(fmt.Sprintf("%s/pool-%d", bs.name, poolSize), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for n := 0; n < b.N; n++ {
+					runPipeline(b, data, NullWriterFactory(), poolSize)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFileWriter(b *testing.B) {
+	for _, bs := range benchSizes {
+		data := benchSnapshot(bs.count, bs.size)
+		for _, poolSize := range []int{1, 4, 8} {
+			b.Run(fmt.Sprintf("%s/pool-%d", bs.name, poolSize), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for n := 0; n < b.N; n++ {
+					runPipeline(b, data, FileWriterFactory(io.Discard, []byte{'\n'}), poolSize)
+				}
+			})
+		}
+	}
+}
+
+//closingWriter counts the writers closed, and those closed before they were flushed
+type closingWriter struct {
+	NullWriter
+	flushed bool
+	closed  *closeCount
+}
+
+//closeCount counts the closingWriters closed
+type closeCount struct {
+	mu        sync.Mutex
+	closed    int
+	unflushed int
+}
+
+func (cw *closingWriter) Flush() error {
+	cw.flushed = true
+	return nil
+}
+
+func (cw *closingWriter) Close() error {
+	cw.closed.mu.Lock()
+	defer cw.closed.mu.Unlock()
+	cw.closed.closed++
+	if !cw.flushed {
+		cw.closed.unflushed++
+	}
+	return nil
+}
+
+func TestWriterFactory(t *testing.T) {
+	var mu sync.Mutex
+	workers := make(map[int]bool)
+	closed := &closeCount{}
+	f := func(ctx context.Context, worker int) (ItemWriter, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		workers[worker] = true
+		return &closingWriter{closed: closed}, nil
+	}
+	// closed through the wrapper
+	f = RateLimitedWriterFactory(f, NewRateLimiter(1e6))
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(5, 10)), f, PoolSpec{Size: 3}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		<-chStatus
+	}
+	if len(workers) != 3 || !workers[0] || !workers[1] || !workers[2] {
+		t.Errorf("created writers for workers %v, want 0, 1 and 2", workers)
+	}
+	if closed.closed != 3 || closed.unflushed != 0 {
+		t.Errorf("%d writers closed, %d before they were flushed, want 3 closed once flushed", closed.closed, closed.unflushed)
+	}
+
+	// the writers created before one fails are closed
+	closed = &closeCount{}
+	failing := func(ctx context.Context, worker int) (ItemWriter, error) {
+		if worker == 1 {
+			return nil, errors.New("no credentials")
+		}
+		return &closingWriter{closed: closed}, nil
+	}
+	chStatus, chErrors = DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(5, 10)), failing, PoolSpec{Size: 2}, benchSpec)
+	var errs []error
+	for err := range chErrors {
+		errs = append(errs, err)
+	}
+	for i := 0; i < 2; i++ {
+		if status := <-chStatus; status.ErrorCount < status.ItemCount {
+			t.Errorf("worker %d wrote %d of %d items, want none", status.WorkerNum, status.ItemCount-status.ErrorCount, status.ItemCount)
+		}
+	}
+	var we *WriteError
+	if len(errs) != 1 || !errors.As(errs[0], &we) || we.Worker != 1 {
+		t.Errorf("decoding ended with %v, want the WriteError of worker 1", errs)
+	}
+	if closed.closed != 1 {
+		t.Errorf("%d writers closed, want that of worker 0", closed.closed)
+	}
+}
+
+func TestFileWriterFlush(t *testing.T) {
+	// the pool's flush reaches a buffered io.Writer, so nothing is left in its buffer
+	var out bytes.Buffer
+	buffered := bufio.NewWriterSize(&out, 1<<20)
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(benchSnapshot(5, 10)),
+		FileWriterFactory(buffered, []byte{'\n'}), PoolSpec{Size: 1}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	if buffered.Buffered() > 0 || strings.Count(out.String(), "\n") != 5 {
+		t.Errorf("%d bytes left buffered, wrote %q", buffered.Buffered(), out.String())
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	// 2^53+1 isn't a float64, and a float64 of 10^21 is written in scientific notation
+	doc := bytes.Replace(benchSnapshot(3, 10), []byte(`"port":443`),
+		[]byte(`"port":443,"id":9007199254740993,"big":1000000000000000000000,"ratio":1.50`), -1)
+	rules, err := LoadRules(writeRules(t, `
+rules:
+  - id: numbers
+    expression: item.configuration.port == 443 && item.configuration.id > 9007199254740992 && item.configuration.ratio < 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, decode := range provenanceDecoders {
+		for _, useNumber := range []bool{false, true} {
+			for _, canonical := range []bool{false, true} {
+				var out lockedBuffer
+				found := &CollectorWriter{}
+				spec := benchSpec
+				spec.UseNumber = useNumber
+				spec.Rules = ItemRules{Custom: rules, Findings: found}
+				f := EncodedFileWriterFactory(&out, FileEncoding{Termination: []byte{'\n'}, Canonical: canonical})
+				chStatus, chErrors := decode(doc, f, spec)
+				for err := range chErrors {
+					t.Fatalf("%s: %v", name, err)
+				}
+				<-chStatus
+				<-chStatus
+
+				want := []string{`"big":1e+21`, `"id":9007199254740992`, `"ratio":1.5}`}
+				if useNumber && canonical {
+					want = []string{`"big":1e+21`, `"id":9007199254740993`, `"ratio":1.5}`}
+				} else if useNumber {
+					want = []string{`"big":1000000000000000000000`, `"id":9007199254740993`, `"ratio":1.50}`}
+				}
+				for _, w := range want {
+					if n := strings.Count(out.b.String(), w); n != 3 {
+						t.Errorf("%s, UseNumber %v, canonical %v: %d items with %s", name, useNumber, canonical, n, w)
+					}
+				}
+				if n := len(found.Items()); (n == 3) != useNumber {
+					t.Errorf("%s, UseNumber %v: %d findings", name, useNumber, n)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/spool"
+	"io"
+	"time"
+)
+
+//DefaultSpoolBatch is the most items DrainSpool delivers before acknowledging them, by default
+const DefaultSpoolBatch = 1000
+
+//spoolRetryDelay is how long DrainSpool waits before delivering a failed batch again
+const spoolRetryDelay = 5 * time.Second
+
+//SpoolWriter is an ItemWriter that appends items to a spool, to be delivered by DrainSpool
+// Flush syncs the spool, so once a pool's workers have ended the items they wrote survive a crash.
+type SpoolWriter struct {
+	s       *spool.Spool
+	written int64
+}
+
+//SpoolWriterFactory returns a factory whose ItemWriters append to s
+func SpoolWriterFactory(s *spool.Spool) WriterFactory {
+	return FactoryOf(func() ItemWriter {
+		return &SpoolWriter{s: s}
+	})
+}
+
+// Write implements ItemWriter for SpoolWriter
+func (sw *SpoolWriter) Write(item map[string]interface{}) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("SpoolWriter.Write: %w", err)
+	}
+	if err := sw.s.Append(b); err != nil {
+		return err
+	}
+	sw.written += int64(len(b))
+	return nil
+}
+
+// BytesWritten implements ByteCounter for SpoolWriter
+func (sw *SpoolWriter) BytesWritten() int64 {
+	return sw.written
+}
+
+// Flush implements Flusher for SpoolWriter
+func (sw *SpoolWriter) Flush() error {
+	return sw.s.Sync()
+}
+
+//DrainSpool delivers the items of s with a writer pool from writerFactory, until s is closed for
+// writing and drained, or ctx is done
+// Items are delivered in batches of at most batchSize, or of those already spooled, each by a pool of
+// its own whose workers flush at its end; the batch is then acknowledged, so it isn't delivered again.
+// A batch with write errors isn't acknowledged, and is delivered again after a delay, so an item may
+// be delivered more than once; with poolSpec.StopOnError draining stops instead. Items left
+// unacknowledged are delivered when the spool is next drained. Once draining ends, a status is sent
+// for each of the pool's workers, totalling its batches, then the error that ended it, if any.
+func DrainSpool(ctx context.Context, s *spool.Spool, writerFactory WriterFactory, poolSpec PoolSpec, batchSize int) (chan WorkerStatus, chan error) {
+	if batchSize <= 0 {
+		batchSize = DefaultSpoolBatch
+	}
+	chStatus := make(chan WorkerStatus, poolSpec.Size)
+	cErrors := make(chan error, 1)
+
+	go func() {
+		defer close(cErrors)
+		totals := make([]WorkerStatus, poolSpec.Size)
+		for i := range totals {
+			totals[i] = WorkerStatus{WorkerNum: i, Status: "ended normally"}
+		}
+		err := drainSpool(ctx, s, writerFactory, poolSpec, batchSize, totals)
+		for _, status := range totals {
+			chStatus <- status
+		}
+		if err != nil {
+			cErrors <- fmt.Errorf("DrainSpool: %w", err)
+			return
+		}
+		logger.Debug("spool drained")
+	}()
+
+	return chStatus, cErrors
+}
+
+func drainSpool(ctx context.Context, s *spool.Spool, writerFactory WriterFactory, poolSpec PoolSpec, batchSize int, totals []WorkerStatus) error {
+	r := s.NewReader()
+	defer func() { _ = r.Close() }()
+	for {
+		// wait for an item before starting a pool to deliver it
+		record, pos, err := r.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && ctx.Err() != nil {
+			return stopped(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		ok, err := deliverBatch(ctx, r, record, &pos, writerFactory, poolSpec, batchSize, totals)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := s.Ack(pos); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if poolSpec.StopOnError {
+			return fmt.Errorf("batch ending at segment %d offset %d had write errors", pos.Segment, pos.Offset)
+		}
+		logger.Warnf("batch ending at segment %d offset %d had write errors; delivering it again in %s",
+			pos.Segment, pos.Offset, spoolRetryDelay)
+		select {
+		case <-ctx.Done():
+			return stopped(ctx)
+		case <-time.After(spoolRetryDelay):
+		}
+		_ = r.Close()
+		r = s.NewReader()
+	}
+}
+
+//deliverBatch writes a batch of items, beginning with record, reporting whether it was written without
+// error; pos is advanced past the batch
+func deliverBatch(ctx context.Context, r *spool.Reader, record []byte, pos *spool.Position, writerFactory WriterFactory, poolSpec PoolSpec, batchSize int, totals []WorkerStatus) (bool, error) {
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	cItems := make(chan map[string]any, 0)
+	pool := newWriterPool(ctx, writerFactory, poolSpec, cItems, nil, stop)
+
+	err := func() error {
+		defer close(cItems)
+		for n := 1; ; n++ {
+			item := make(map[string]any)
+			if poolSpec.ReuseItems {
+				item = getItem()
+			}
+			dec := json.NewDecoder(bytes.NewReader(record))
+			dec.UseNumber()
+			if err := dec.Decode(&item); err != nil {
+				return fmt.Errorf("segment %d offset %d: %w", pos.Segment, pos.Offset, err)
+			}
+			select {
+			case cItems <- item:
+			case <-ctx.Done():
+				return stopped(ctx)
+			}
+
+			if n == batchSize || !r.Available() {
+				return nil
+			}
+			var err error
+			if record, *pos, err = r.Next(ctx); err != nil && ctx.Err() != nil {
+				return stopped(ctx)
+			} else if err != nil {
+				return err
+			}
+		}
+	}()
+
+	ok := true
+	for i := 0; i < poolSpec.Size; i++ {
+		status := <-pool.chStatus
+		if status.ErrorCount > 0 {
+			ok = false
+		}
+		t := &totals[status.WorkerNum]
+		if t.StartTime == "" {
+			t.StartTime = status.StartTime
+		}
+		t.EndTime = status.EndTime
+		t.ItemCount += status.ItemCount
+		t.ByteCount += status.ByteCount
+		t.ErrorCount += status.ErrorCount
+		t.BreakerTrips += status.BreakerTrips
+		t.Duration += status.Duration
+		for resourceType, b := range status.ResourceTypes {
+			if t.ResourceTypes == nil {
+				t.ResourceTypes = make(map[string]ResourceTypeBytes)
+			}
+			tb := t.ResourceTypes[resourceType]
+			tb.Items += b.Items
+			tb.DecodedBytes += b.DecodedBytes
+			tb.WrittenBytes += b.WrittenBytes
+			t.ResourceTypes[resourceType] = tb
+		}
+		for _, l := range status.Largest {
+			t.Largest = KeepLargest(t.Largest, poolSpec.Largest, l)
+		}
+	}
+	var writeErr *WriteError
+	if errors.As(err, &writeErr) {
+		return false, nil
+	}
+	return ok, err
+}
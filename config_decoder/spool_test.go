@@ -0,0 +1,135 @@
+package config_decoder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/mfrasier/decode_json_stream/spool"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//failingWriter fails every write after the first ok
+type failingWriter struct {
+	ok int
+	cw *CollectorWriter
+}
+
+func (fw *failingWriter) Write(item map[string]interface{}) error {
+	if fw.cw.Count() >= fw.ok {
+		return errors.New("sink unavailable")
+	}
+	return fw.cw.Write(item)
+}
+
+//spoolItems appends n items to the spool in dir, numbered from 0
+func spoolItems(t *testing.T, dir string, n int) {
+	s, err := spool.Open(dir, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := SpoolWriterFactory(s)(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := w.Write(map[string]any{"resourceId": i, "source_offset_end": int64(1) << 60}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//drain drains the spool in dir, returning the items written and the error draining ended with
+func drain(t *testing.T, dir string, f WriterFactory, poolSpec PoolSpec, batchSize int) error {
+	s, err := spool.Open(dir, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.CloseWrites(); err != nil {
+		t.Fatal(err)
+	}
+	chStatus, chErrors := DrainSpool(context.Background(), s, f, poolSpec, batchSize)
+	for i := 0; i < poolSpec.Size; i++ {
+		<-chStatus
+	}
+	return <-chErrors
+}
+
+func TestDrainSpool(t *testing.T) {
+	dir := t.TempDir()
+	spoolItems(t, dir, 20)
+	if segs, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segs) < 2 {
+		t.Fatalf("spooled %d segments, want several", len(segs))
+	}
+
+	cw := &CollectorWriter{}
+	if err := drain(t, dir, CollectorWriterFactory(cw), PoolSpec{Size: 2}, 3); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, item := range cw.Items() {
+		seen[item["resourceId"].(json.Number).String()] = true
+		if n := item["source_offset_end"].(json.Number).String(); n != "1152921504606846976" {
+			t.Errorf("source_offset_end %s isn't exact", n)
+		}
+	}
+	if cw.Count() != 20 || len(seen) != 20 {
+		t.Errorf("delivered %d items, %d distinct, want 20", cw.Count(), len(seen))
+	}
+
+	// everything was acknowledged, so nothing is delivered again
+	cw = &CollectorWriter{}
+	if err := drain(t, dir, CollectorWriterFactory(cw), PoolSpec{Size: 2}, 3); err != nil || cw.Count() != 0 {
+		t.Errorf("redelivered %d items, error %v", cw.Count(), err)
+	}
+}
+
+func TestDrainSpoolResumes(t *testing.T) {
+	dir := t.TempDir()
+	spoolItems(t, dir, 10)
+
+	// batches of 4 are acknowledged until the sink fails during the second
+	fw := &failingWriter{ok: 6, cw: &CollectorWriter{}}
+	f := FactoryOf(func() ItemWriter { return fw })
+	if err := drain(t, dir, f, PoolSpec{Size: 1, StopOnError: true}, 4); err == nil {
+		t.Fatal("draining didn't stop at the write error")
+	}
+
+	cw := &CollectorWriter{}
+	if err := drain(t, dir, CollectorWriterFactory(cw), PoolSpec{Size: 1}, 4); err != nil {
+		t.Fatal(err)
+	}
+	items := cw.Items()
+	if len(items) != 6 || items[0]["resourceId"].(json.Number).String() != "4" {
+		t.Errorf("resumed with %d items from %v, want 6 from the unacknowledged batch", len(items), items[0]["resourceId"])
+	}
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	spoolItems(t, dir, 1)
+	segs, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	f, err := os.OpenFile(segs[len(segs)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the start of a frame, as a crash might leave it
+	if _, err := f.Write([]byte{200, 0, 0, 0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	spoolItems(t, dir, 1)
+	cw := &CollectorWriter{}
+	if err := drain(t, dir, CollectorWriterFactory(cw), PoolSpec{Size: 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if cw.Count() != 2 {
+		t.Errorf("delivered %d items around the torn record, want 2", cw.Count())
+	}
+}
@@ -0,0 +1,109 @@
+package config_decoder
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+//SchemaError reports a document whose top-level fields aren't those a strict ItemTransformSpec expects,
+// as when a decoder is pointed at the wrong file, or when a field is duplicated, as in a corrupt one
+//
+// Deprecated: importers should use decode.SchemaError, which a decode's error wraps.
+type SchemaError struct {
+	Missing    []string
+	Unexpected []string
+	Duplicated []string
+}
+
+func (e *SchemaError) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing "+quoteFields(e.Missing))
+	}
+	if len(e.Unexpected) > 0 {
+		problems = append(problems, "unexpected "+quoteFields(e.Unexpected))
+	}
+	if len(e.Duplicated) > 0 {
+		problems = append(problems, "duplicated "+quoteFields(e.Duplicated))
+	}
+	hint := "is this the right file?"
+	if len(e.Missing) == 0 && len(e.Unexpected) == 0 {
+		hint = "is the file corrupt?"
+	}
+	return fmt.Sprintf("top-level fields aren't those of the spec (%s): %s", hint, strings.Join(problems, "; "))
+}
+
+func quoteFields(fields []string) string {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = fmt.Sprintf("%q", f)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+//checkTopLevel checks keys, the top-level fields of a document in order, are those spec expects: its
+// Fields and ItemsField, each once, and nothing else
+// The items field is only required when end is set, once the whole document has been read.
+func checkTopLevel(spec ItemTransformSpec, keys []string, end bool) error {
+	seen := make(map[string]bool, len(keys))
+	var e SchemaError
+	for _, k := range keys {
+		if seen[k] {
+			e.Duplicated = append(e.Duplicated, k)
+			continue
+		}
+		seen[k] = true
+		if _, ok := spec.Fields[k]; !ok && k != spec.ItemsField {
+			e.Unexpected = append(e.Unexpected, k)
+		}
+	}
+	for k := range spec.Fields {
+		if !seen[k] {
+			e.Missing = append(e.Missing, k)
+		}
+	}
+	sort.Strings(e.Missing)
+	if end && !seen[spec.ItemsField] {
+		e.Missing = append(e.Missing, spec.ItemsField)
+	}
+
+	if len(e.Missing) > 0 || len(e.Unexpected) > 0 || len(e.Duplicated) > 0 {
+		return &e
+	}
+	return nil
+}
+
+//checkItemsField checks a whole document, whose top-level fields were keys, had spec's ItemsField, and
+// with spec.Strict, that it had exactly the fields spec expects; a document without the items field is
+// ErrItemsFieldMissing, wrapping its SchemaError if it's strict
+func checkItemsField(spec ItemTransformSpec, keys []string) error {
+	var err error
+	if spec.Strict {
+		err = checkTopLevel(spec, keys, true)
+	}
+	if slices.Contains(keys, spec.ItemsField) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrItemsFieldMissing, err)
+	}
+	return fmt.Errorf("%w: %q", ErrItemsFieldMissing, spec.ItemsField)
+}
+
+//isDuplicate reports whether key, met at the top level of a document after keys, is one of the spec's
+// fields met already
+// encoding/json would let its value silently replace the first, though duplicates suggest the
+// document is corrupt.
+func isDuplicate(spec ItemTransformSpec, keys []string, key string) bool {
+	if _, ok := spec.Fields[key]; !ok && key != spec.ItemsField {
+		return false
+	}
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package config_decoder
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//decodeStrict decodes doc with a strict benchSpec, streaming it or, with at, from a ReaderAt, returning
+// the items emitted and the error decoding ended with
+func decodeStrict(doc string, at bool) (int, error) {
+	spec := benchSpec
+	spec.Strict = true
+	cw := &CollectorWriter{}
+	var chStatus chan WorkerStatus
+	var chErrors chan error
+	if at {
+		chStatus, chErrors = DecodeAndSplitItemsAt(context.Background(), strings.NewReader(doc), int64(len(doc)),
+			CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+	} else {
+		chStatus, chErrors = DecodeAndSplitItems(context.Background(), strings.NewReader(doc),
+			CollectorWriterFactory(cw), PoolSpec{Size: 1}, spec)
+	}
+	err := <-chErrors
+	<-chStatus
+	return cw.Count(), err
+}
+
+func TestStrictTopLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		doc        string
+		missing    []string
+		unexpected []string
+	}{
+		{"snapshot", string(benchSnapshot(2, 10)), nil, nil},
+		{"wrong file", `{"Records":[{"eventName":"PutObject"}],"version":"1"}`,
+			[]string{"configSnapshotId", "fileVersion", "configurationItems"}, []string{"Records", "version"}},
+		{"extra field", `{"fileVersion":"1.0","configSnapshotId":"s","extra":1,"configurationItems":[{}]}`,
+			nil, []string{"extra"}},
+	}
+	for _, tt := range tests {
+		for _, at := range []bool{false, true} {
+			n, err := decodeStrict(tt.doc, at)
+			if tt.missing == nil && tt.unexpected == nil {
+				if err != nil || n != 2 {
+					t.Errorf("%s (at %v): decoded %d items, error %v", tt.name, at, n, err)
+				}
+				continue
+			}
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) {
+				t.Fatalf("%s (at %v): ended with %v, want a SchemaError", tt.name, at, err)
+			}
+			if !reflect.DeepEqual(schemaErr.Missing, tt.missing) || !reflect.DeepEqual(schemaErr.Unexpected, tt.unexpected) {
+				t.Errorf("%s (at %v): missing %q, unexpected %q, want %q, %q", tt.name, at,
+					schemaErr.Missing, schemaErr.Unexpected, tt.missing, tt.unexpected)
+			}
+			if n != 0 {
+				t.Errorf("%s (at %v): emitted %d items", tt.name, at, n)
+			}
+		}
+	}
+}
+
+func TestStrictFieldsAfterItems(t *testing.T) {
+	doc := `{"fileVersion":"1.0","configurationItems":[{}],"configSnapshotId":"s"}`
+
+	// streaming, the snapshot id is missing when the items are reached
+	if n, err := decodeStrict(doc, false); err == nil || n != 0 || !strings.Contains(err.Error(), `missing "configSnapshotId"`) {
+		t.Errorf("streaming decoded %d items, error %v", n, err)
+	}
+	// from a ReaderAt the fields may be anywhere
+	if n, err := decodeStrict(doc, true); err != nil || n != 1 {
+		t.Errorf("ReaderAt decoded %d items, error %v", n, err)
+	}
+}
+
+func TestDuplicateTopLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"parent field", `{"fileVersion":"1.0","configSnapshotId":"s","fileVersion":"2.0","configurationItems":[{}]}`},
+		{"items array", `{"fileVersion":"1.0","configSnapshotId":"s","configurationItems":[{}],"configurationItems":[{}]}`},
+	}
+	for _, tt := range tests {
+		for _, at := range []bool{false, true} {
+			_, err := decodeStrict(tt.doc, at)
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) || len(schemaErr.Duplicated) != 1 || !strings.Contains(err.Error(), "corrupt") {
+				t.Errorf("%s (at %v): ended with %v, want a duplicate", tt.name, at, err)
+			}
+
+			// without Strict, duplicates are only warned of
+			var chErrors chan error
+			if at {
+				_, chErrors = DecodeAndSplitItemsAt(context.Background(), strings.NewReader(tt.doc), int64(len(tt.doc)),
+					NullWriterFactory(), PoolSpec{Size: 1}, benchSpec)
+			} else {
+				_, chErrors = DecodeAndSplitItems(context.Background(), strings.NewReader(tt.doc), NullWriterFactory(), PoolSpec{Size: 1}, benchSpec)
+			}
+			if err := <-chErrors; err != nil {
+				t.Errorf("%s (at %v): ended with %v without Strict", tt.name, at, err)
+			}
+		}
+	}
+
+	vr := Validate(strings.NewReader(`{"fileVersion":"1.0","configSnapshotId":"s","fileVersion":"2.0","configurationItems":[]}`), benchSpec, 0)
+	if vr.ProblemCount != 1 || vr.Problems[0].Kind != ProblemDuplicateField {
+		t.Errorf("validate found %+v, want a duplicate field", vr.Problems)
+	}
+}
+
+func TestTopLevelFields(t *testing.T) {
+	doc := `{"fileVersion": "1.0", "configSnapshotId": "s-1", "configurationItems": [{"resourceId": "r1"}], "trailer": 1}`
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{doc, []string{"fileVersion", "configSnapshotId", "configurationItems", "trailer"}},
+		{doc[:strings.Index(doc, "r1")], []string{"fileVersion", "configSnapshotId", "configurationItems"}},
+		{doc[:10], nil},
+		{`[{"resourceId": "r1"}]`, nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := TopLevelFields([]byte(tt.prefix)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopLevelFields(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
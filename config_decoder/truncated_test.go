@@ -0,0 +1,59 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTruncatedDocument(t *testing.T) {
+	doc := benchSnapshot(10, 50)
+	cw := &CollectorWriter{}
+	chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(doc), CollectorWriterFactory(cw), PoolSpec{Size: 1}, benchSpec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	ends := make(map[int64]bool)
+	for _, item := range cw.Items() {
+		ends[item["source_offset_end"].(int64)] = true
+	}
+
+	// a ReaderAt salvages the same items as a stream, even decoding them in parallel
+	parallel := benchSpec
+	parallel.Decoders = 4
+	for _, cut := range []int{len(doc) / 2, len(doc) - 1, 20} {
+		salvaged := -1
+		for _, at := range []bool{false, true} {
+			cw := &CollectorWriter{}
+			var chStatus chan WorkerStatus
+			var chErrors chan error
+			if at {
+				chStatus, chErrors = DecodeAndSplitItemsAt(context.Background(), bytes.NewReader(doc[:cut]), int64(cut), CollectorWriterFactory(cw), PoolSpec{Size: 1}, parallel)
+			} else {
+				chStatus, chErrors = DecodeAndSplitItems(context.Background(), bytes.NewReader(doc[:cut]), CollectorWriterFactory(cw), PoolSpec{Size: 1}, benchSpec)
+			}
+			err := <-chErrors
+			<-chStatus
+
+			var te *TruncatedError
+			if !errors.As(err, &te) {
+				t.Fatalf("cut at %d (at %v): ended with %v, want a TruncatedError", cut, at, err)
+			}
+			if te.Items != int64(cw.Count()) {
+				t.Errorf("cut at %d (at %v): reported %d items, %d were emitted", cut, at, te.Items, cw.Count())
+			}
+			if te.Items > 0 && !ends[te.Offset] || te.Items == 0 && te.Offset != 0 {
+				t.Errorf("cut at %d (at %v): last good offset %d isn't the end of an item", cut, at, te.Offset)
+			}
+			if salvaged >= 0 && cw.Count() != salvaged {
+				t.Errorf("cut at %d: %d items salvaged from a ReaderAt, %d from a stream", cut, cw.Count(), salvaged)
+			}
+			salvaged = cw.Count()
+		}
+	}
+	if salvaged := len(ends); salvaged != 10 {
+		t.Fatalf("%d items decoded whole, want 10", salvaged)
+	}
+}
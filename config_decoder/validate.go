@@ -0,0 +1,217 @@
+package config_decoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+//Problem kinds reported by Validate
+const (
+	ProblemSyntax       = "syntax"
+	ProblemRead         = "read"
+	ProblemMissingField = "missing_field"
+	ProblemFieldType    = "field_type"
+	ProblemItemSchema   = "item_schema"
+	ProblemTrailingData = "trailing_data"
+	// ProblemUnexpectedField is only reported for a strict ItemTransformSpec
+	ProblemUnexpectedField = "unexpected_field"
+	// ProblemDuplicateField is one of the spec's top-level fields found more than once
+	ProblemDuplicateField = "duplicate_field"
+	// ProblemGzip isn't found by Validate, which reads uncompressed documents, but by its callers
+	ProblemGzip = "gzip"
+)
+
+//Problem is something wrong with a document
+// Offset is the byte offset in the (uncompressed) document where it was found.
+// Item is the index of the item concerned, or -1.
+type Problem struct {
+	Kind    string `json:"kind"`
+	Offset  int64  `json:"offset"`
+	Item    int    `json:"item"`
+	Message string `json:"message"`
+}
+
+//ValidationReport lists the problems found in a document
+// Problems beyond the maximum asked for are counted but not listed.
+type ValidationReport struct {
+	Items        int       `json:"items"`
+	ProblemCount int       `json:"problemCount"`
+	Problems     []Problem `json:"problems"`
+	max          int
+}
+
+//Valid reports whether no problems were found
+func (vr *ValidationReport) Valid() bool {
+	return vr.ProblemCount == 0
+}
+
+//Add records a problem
+func (vr *ValidationReport) Add(p Problem) {
+	vr.ProblemCount++
+	if vr.max <= 0 || len(vr.Problems) < vr.max {
+		vr.Problems = append(vr.Problems, p)
+	}
+}
+
+//Validate reads the json document in r, checking it's well-formed, has the top-level fields of spec,
+// and only those if spec is Strict, and that its items look like configuration items. Up to maxProblems problems are listed (0 lists all).
+// Reading stops at the first syntax or read error, since nothing after it can be located reliably.
+func Validate(r io.Reader, spec ItemTransformSpec, maxProblems int) *ValidationReport {
+	vr := &ValidationReport{Problems: []Problem{}, max: maxProblems}
+	dec := json.NewDecoder(r)
+
+	if err := validateDocument(dec, spec, vr); err != nil {
+		vr.Add(streamProblem(dec, err, vr.Items))
+		return vr
+	}
+
+	if t, err := dec.Token(); err != io.EOF {
+		p := Problem{Kind: ProblemTrailingData, Offset: dec.InputOffset(), Item: -1,
+			Message: fmt.Sprintf("unexpected %v after the document", t)}
+		if err != nil {
+			p = streamProblem(dec, err, -1)
+		}
+		vr.Add(p)
+	}
+	return vr
+}
+
+//streamProblem describes an error reading or tokenizing the document
+func streamProblem(dec *json.Decoder, err error, item int) Problem {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return Problem{Kind: ProblemSyntax, Offset: syntaxErr.Offset, Item: item, Message: err.Error()}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Problem{Kind: ProblemSyntax, Offset: dec.InputOffset(), Item: item, Message: "document is truncated"}
+	}
+	return Problem{Kind: ProblemRead, Offset: dec.InputOffset(), Item: item, Message: err.Error()}
+}
+
+//validateDocument checks the top-level object, returning any error that stops reading
+func validateDocument(dec *json.Decoder, spec ItemTransformSpec, vr *ValidationReport) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		vr.Add(Problem{Kind: ProblemSyntax, Offset: dec.InputOffset(), Item: -1,
+			Message: fmt.Sprintf("want a json object, got %v", t)})
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := t.(string)
+		offset := dec.InputOffset()
+		if _, ok := spec.Fields[key]; seen[key] && (ok || key == spec.ItemsField) {
+			vr.Add(Problem{Kind: ProblemDuplicateField, Offset: offset, Item: -1,
+				Message: fmt.Sprintf("field %q is duplicated", key)})
+		}
+		seen[key] = true
+		if _, ok := spec.Fields[key]; spec.Strict && !ok && key != spec.ItemsField {
+			vr.Add(Problem{Kind: ProblemUnexpectedField, Offset: offset, Item: -1,
+				Message: fmt.Sprintf("field %q is unexpected", key)})
+		}
+
+		if key == spec.ItemsField {
+			if err := validateItems(dec, vr); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		if _, ok := spec.Fields[key]; ok {
+			var s string
+			if json.Unmarshal(v, &s) != nil {
+				vr.Add(Problem{Kind: ProblemFieldType, Offset: offset, Item: -1,
+					Message: fmt.Sprintf("field %q is not a string", key)})
+			}
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	required := []string{spec.ItemsField}
+	for k := range spec.Fields {
+		required = append(required, k)
+	}
+	for _, k := range required {
+		if !seen[k] {
+			vr.Add(Problem{Kind: ProblemMissingField, Offset: dec.InputOffset(), Item: -1,
+				Message: fmt.Sprintf("field %q is missing", k)})
+		}
+	}
+	return nil
+}
+
+//validateItems checks each item of the items array
+func validateItems(dec *json.Decoder, vr *ValidationReport) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('[') {
+		vr.Add(Problem{Kind: ProblemFieldType, Offset: dec.InputOffset(), Item: -1,
+			Message: fmt.Sprintf("items field is not an array, got %v", t)})
+		// t was the whole value, unless it opened an object, whose rest is skipped
+		if t == json.Delim('{') {
+			return skipRest(dec)
+		}
+		return nil
+	}
+
+	for dec.More() {
+		offset := dec.InputOffset()
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		for _, msg := range itemSchemaProblems(raw) {
+			vr.Add(Problem{Kind: ProblemItemSchema, Offset: offset, Item: vr.Items, Message: msg})
+		}
+		vr.Items++
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+//itemSchemaProblems describes what's wrong with a configuration item
+func itemSchemaProblems(raw json.RawMessage) []string {
+	var item map[string]any
+	if err := json.Unmarshal(raw, &item); err != nil || item == nil {
+		return []string{"item is not a json object"}
+	}
+
+	var problems []string
+	for _, k := range []string{"resourceType", "awsRegion", "awsAccountId"} {
+		if s, ok := item[k].(string); !ok || s == "" {
+			problems = append(problems, fmt.Sprintf("%s is missing or not a string", k))
+		}
+	}
+	if _, ok := item["resourceId"].(string); !ok {
+		if _, ok := item["ARN"].(string); !ok {
+			problems = append(problems, "item has neither a resourceId nor an ARN")
+		}
+	}
+	if v, ok := item["configurationItemCaptureTime"]; ok {
+		s, _ := v.(string)
+		if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			problems = append(problems, fmt.Sprintf("configurationItemCaptureTime %v is not a RFC 3339 time", v))
+		}
+	}
+	return problems
+}
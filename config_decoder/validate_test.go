@@ -0,0 +1,55 @@
+package config_decoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	good := string(benchSnapshot(3, 10))
+	tests := []struct {
+		name  string
+		doc   string
+		items int
+		kinds []string
+	}{
+		{"snapshot", good, 3, nil},
+		{"items not an array", `{"configurationItems":"x","fileVersion":"1.0","configSnapshotId":"s"}`, 0,
+			[]string{ProblemFieldType}},
+		{"items an object", `{"configurationItems":{"a":[1,{"b":2}]},"fileVersion":"1.0","configSnapshotId":"s"}`, 0,
+			[]string{ProblemFieldType}},
+		{"items not an array, field after it checked", `{"configurationItems":"x","fileVersion":1,"configSnapshotId":"s"}`, 0,
+			[]string{ProblemFieldType, ProblemFieldType}},
+		{"items missing", `{"fileVersion":"1.0","configSnapshotId":"s"}`, 0, []string{ProblemMissingField}},
+		{"truncated", good[:len(good)/2], 1, []string{ProblemSyntax}},
+		{"item schema", `{"fileVersion":"1.0","configSnapshotId":"s","configurationItems":[` +
+			`{"awsAccountId":"123456789012","awsRegion":"us-east-1","resourceType":"AWS::S3::Bucket",` +
+			`"configurationItemCaptureTime":"yesterday"},1]}`, 2,
+			[]string{ProblemItemSchema, ProblemItemSchema, ProblemItemSchema}},
+		{"duplicate field", `{"fileVersion":"1.0","fileVersion":"1.0","configSnapshotId":"s","configurationItems":[]}`, 0,
+			[]string{ProblemDuplicateField}},
+		{"trailing data", good + ` {}`, 3, []string{ProblemTrailingData}},
+	}
+	for _, tt := range tests {
+		vr := Validate(strings.NewReader(tt.doc), benchSpec, 0)
+		var kinds []string
+		for _, p := range vr.Problems {
+			kinds = append(kinds, p.Kind)
+		}
+		if strings.Join(kinds, ",") != strings.Join(tt.kinds, ",") || vr.ProblemCount != len(tt.kinds) {
+			t.Errorf("%s: problems %+v, want kinds %v", tt.name, vr.Problems, tt.kinds)
+		}
+		if vr.Items != tt.items {
+			t.Errorf("%s: %d items, want %d", tt.name, vr.Items, tt.items)
+		}
+		if vr.Valid() != (tt.kinds == nil) {
+			t.Errorf("%s: valid %v", tt.name, vr.Valid())
+		}
+	}
+
+	// problems beyond the maximum are counted, not listed
+	vr := Validate(strings.NewReader(`{"configurationItems":[1,2,3]}`), benchSpec, 2)
+	if vr.ProblemCount != 5 || len(vr.Problems) != 2 {
+		t.Errorf("%d problems, %d listed, want 5, 2", vr.ProblemCount, len(vr.Problems))
+	}
+}
@@ -0,0 +1,154 @@
+package config_decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"unsafe"
+)
+
+//verbatimKey is the key under which an item decoded with ItemTransformSpec.Verbatim holds its source
+// fields, until a FileWriter with FileEncoding.Verbatim writes them; it's cleared with the item's others
+// when the item is reused
+const verbatimKey = "\x00verbatim"
+
+//verbatimField is a top-level field of an item as its document has it, and the value it was decoded to
+type verbatimField struct {
+	key   string
+	raw   []byte
+	value any
+}
+
+//verbatimSource is the source fields of an item, held in it under verbatimKey
+type verbatimSource struct {
+	fields []verbatimField
+}
+
+//String formats the source fields as nothing, as an item's in-flight size counts them by their json
+func (*verbatimSource) String() string {
+	return ""
+}
+
+//holdVerbatim holds the fields of item, decoded from raw, in the order of raw, for a FileWriter with
+// FileEncoding.Verbatim to write as they were
+// A field kept as a RawField isn't held again; it's written from the RawField.
+func holdVerbatim(item map[string]any, raw json.RawMessage) error {
+	spans, err := scanTopLevel(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return fmt.Errorf("holdVerbatim: %w", err)
+	}
+	fields := make([]verbatimField, 0, len(spans))
+	for _, s := range spans {
+		v := item[s.Key]
+		f := verbatimField{key: s.Key, value: v}
+		if _, ok := v.(*RawField); !ok {
+			// a span ends at the next separator, after any whitespace; it's copied so a RawField's
+			// json, left in raw, isn't held with it
+			f.raw = bytes.Clone(bytes.TrimRight(raw[s.Start:s.End], " \t\r\n"))
+		}
+		fields = append(fields, f)
+	}
+	item[verbatimKey] = &verbatimSource{fields: fields}
+	return nil
+}
+
+//verbatimOf returns the source fields held in item, if any
+func verbatimOf(item map[string]any) []verbatimField {
+	if src, ok := item[verbatimKey].(*verbatimSource); ok {
+		return src.fields
+	}
+	return nil
+}
+
+//sameValue reports whether a, a decoded json value, is still b, rather than replaced since it was decoded
+// Objects and arrays are compared by identity, so one changed in place isn't noticed.
+func sameValue(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		return ok && a != nil && b != nil && reflect.ValueOf(a).UnsafePointer() == reflect.ValueOf(b).UnsafePointer()
+	case []any:
+		b, ok := b.([]any)
+		return ok && len(a) == len(b) && (len(a) == 0 || unsafe.SliceData(a) == unsafe.SliceData(b))
+	}
+	// the types decoding gives are comparable, and values of other types aren't equal to them
+	return a == b
+}
+
+//writeVerbatim writes item, whose source fields are held, as json: its source fields in their order in
+// its document, as it has them less any whitespace between tokens, and then those the decoder added or
+// replaced, sorted, as the encoder writes them
+func (fw FileWriter) writeVerbatim(item map[string]any, fields []verbatimField) error {
+	written := make(map[string]bool, len(fields))
+	var raw []string
+	fw.buf.WriteByte('{')
+	field := func(k string) error {
+		if fw.buf.Len() > 1 {
+			fw.buf.WriteByte(',')
+		}
+		if err := fw.enc.Encode(k); err != nil {
+			return err
+		}
+		// replace the encoder's newline
+		fw.buf.Truncate(fw.buf.Len() - 1)
+		fw.buf.WriteByte(':')
+		return nil
+	}
+
+	for _, f := range fields {
+		v, ok := item[f.key]
+		if !ok || written[f.key] {
+			continue
+		}
+		written[f.key] = true
+		if _, isRaw := v.(*RawField); isRaw {
+			raw = append(raw, f.key)
+			continue
+		}
+		if err := field(f.key); err != nil {
+			return err
+		}
+		if f.raw != nil && sameValue(f.value, v) {
+			if err := json.Compact(fw.buf, f.raw); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fw.enc.Encode(v); err != nil {
+			return err
+		}
+		fw.buf.Truncate(fw.buf.Len() - 1)
+	}
+
+	added := make([]string, 0, len(item)-len(written))
+	for k, v := range item {
+		if written[k] || k == verbatimKey {
+			continue
+		}
+		if _, isRaw := v.(*RawField); isRaw {
+			raw = append(raw, k)
+			continue
+		}
+		added = append(added, k)
+	}
+	sort.Strings(added)
+	for _, k := range added {
+		if err := field(k); err != nil {
+			return err
+		}
+		if err := fw.enc.Encode(item[k]); err != nil {
+			return err
+		}
+		fw.buf.Truncate(fw.buf.Len() - 1)
+	}
+
+	if len(raw) > 0 {
+		// RawFields are streamed after the rest, as writeRaw does
+		return fw.writeRawFields(item, raw, fw.buf.Len() > 1)
+	}
+	fw.buf.WriteByte('}')
+	fw.buf.Write(fw.encoding.Termination)
+	return fw.flushItem()
+}
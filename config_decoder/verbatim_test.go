@@ -0,0 +1,152 @@
+package config_decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerbatim(t *testing.T) {
+	// items with their fields out of order, html characters, escapes and numbers floats don't keep
+	var items []string
+	for i := 0; i < 50; i++ {
+		items = append(items, fmt.Sprintf(`{"resourceType": "AWS::EC2::Instance", "resourceId":"i-%03d",
+  "configuration": {"zeta": "<a&b> é", "alpha": 1.50, "big": 9007199254740993},
+  "tags": {"b": "1", "a": "2"}}`, i))
+	}
+	doc := bytes.Replace(benchSnapshot(0, 0), []byte("[]"), []byte("[\n"+strings.Join(items, ",\n")+"\n]"), 1)
+	spec := benchSpec
+	spec.Reproducible = true
+	spec.NoProvenance = true
+	spec.UseNumber = true
+	spec.Verbatim = true
+	spec.Hash = ItemHash{Field: "config_hash"}
+
+	// each line starts with its source item, compacted, its metadata following
+	want := make(map[string]bool)
+	for _, item := range items {
+		var b bytes.Buffer
+		if err := json.Compact(&b, []byte(item)); err != nil {
+			t.Fatal(err)
+		}
+		want[strings.TrimSuffix(b.String(), "}")+`,"config_hash":`] = true
+	}
+
+	for name, decode := range provenanceDecoders {
+		var out lockedBuffer
+		chStatus, chErrors := decode(doc, EncodedFileWriterFactory(&out, FileEncoding{Termination: []byte{'\n'}, Verbatim: true}), spec)
+		for err := range chErrors {
+			t.Fatalf("%s: %v", name, err)
+		}
+		<-chStatus
+		<-chStatus
+
+		lines := strings.Split(strings.TrimSpace(out.b.String()), "\n")
+		if len(lines) != len(items) {
+			t.Fatalf("%s: %d items written, want %d", name, len(lines), len(items))
+		}
+		for _, line := range lines {
+			prefix, _, ok := strings.Cut(line, `"config_hash":`)
+			if !ok || !want[prefix+`"config_hash":`] {
+				t.Errorf("%s: item not written verbatim: %s", name, line)
+			}
+			if !json.Valid([]byte(line)) || !strings.Contains(line, `"metadata":{`) {
+				t.Errorf("%s: item without its metadata: %s", name, line)
+			}
+		}
+	}
+
+	// items decoded otherwise are written as they're encoded, unescaped
+	var out lockedBuffer
+	spec.Verbatim = false
+	chStatus, chErrors := provenanceDecoders["stream"](doc, EncodedFileWriterFactory(&out, FileEncoding{Termination: []byte{'\n'}, Verbatim: true}), spec)
+	for err := range chErrors {
+		t.Fatal(err)
+	}
+	<-chStatus
+	<-chStatus
+	if line, _, _ := strings.Cut(out.b.String(), "\n"); !strings.Contains(line, `"configuration":{"alpha":1.50,"big":9007199254740993,"zeta":"<a&b> é"}`) {
+		t.Errorf("item not encoded unescaped: %s", line)
+	}
+}
+
+//verbatimFileWriter writes items to a FileWriter with FileEncoding.Verbatim, failing its first fails writes
+type verbatimFileWriter struct {
+	fails int
+	w     FileWriter
+}
+
+func (vw *verbatimFileWriter) Write(item map[string]interface{}) error {
+	if vw.fails > 0 {
+		vw.fails--
+		return errors.New("sink unavailable")
+	}
+	return vw.w.Write(item)
+}
+
+//TestVerbatimBreaker checks items held by an open breaker, and those written as it closes, are written
+// verbatim with their own source fields though items are reused, and spilled items as they're encoded
+func TestVerbatimBreaker(t *testing.T) {
+	var items []string
+	for i := 0; i < 40; i++ {
+		// the fields out of order, so an item written as it's encoded is told apart
+		items = append(items, fmt.Sprintf(`{"resourceType": "AWS::EC2::Instance", "resourceId": "i-%03d"}`, i))
+	}
+	doc := bytes.Replace(benchSnapshot(0, 0), []byte("[]"), []byte("["+strings.Join(items, ",")+"]"), 1)
+	spec := benchSpec
+	spec.NoProvenance = true
+	spec.Verbatim = true
+	spec.Envelope = MetadataEnvelope{Shape: "nested"}
+
+	for _, spill := range []bool{false, true} {
+		var out lockedBuffer
+		breaker := BreakerConfig{Threshold: 1, Cooldown: time.Millisecond, BufferSize: 4}
+		if spill {
+			breaker.BufferSize, breaker.SpillDir = 1, t.TempDir()
+		}
+		poolSpec := PoolSpec{Size: 2, ReuseItems: true, Breaker: breaker}
+		factory := FactoryOf(func() ItemWriter {
+			return &verbatimFileWriter{fails: 3, w: newFileWriter(&out, FileEncoding{Termination: []byte{'\n'}, Verbatim: true})}
+		})
+		chStatus, chErrors := DecodeAndSplitItems(context.Background(), bytes.NewReader(doc), factory, poolSpec, spec)
+		for err := range chErrors {
+			t.Fatal(err)
+		}
+		<-chStatus
+		<-chStatus
+
+		lines := strings.Split(strings.TrimSpace(out.b.String()), "\n")
+		if len(lines) != len(items) {
+			t.Fatalf("spill %v: %d items written, want %d", spill, len(lines), len(items))
+		}
+		ids, verbatim := make(map[string]bool), 0
+		for _, line := range lines {
+			var item map[string]any
+			if err := json.Unmarshal([]byte(line), &item); err != nil {
+				t.Fatalf("spill %v: %v: %s", spill, err, line)
+			}
+			if _, ok := item[verbatimKey]; ok || len(item) != 3 {
+				t.Errorf("spill %v: item written with fields %v", spill, line)
+			}
+			id, _ := item["resourceId"].(string)
+			ids[id] = true
+			if strings.HasPrefix(line, fmt.Sprintf(`{"resourceType":"AWS::EC2::Instance","resourceId":%q,"metadata":`, id)) {
+				verbatim++
+			}
+		}
+		if len(ids) != len(items) {
+			t.Errorf("spill %v: %d distinct items written, want %d", spill, len(ids), len(items))
+		}
+		if !spill && verbatim != len(items) {
+			t.Errorf("%d of %d items written verbatim", verbatim, len(items))
+		}
+		if spill && (verbatim == 0 || verbatim == len(items)) {
+			t.Errorf("spill: %d of %d items written verbatim, want those not spilled", verbatim, len(items))
+		}
+	}
+}
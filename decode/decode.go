@@ -0,0 +1,162 @@
+//Package decode decodes the array of items of json documents, such as AWS Config snapshots, writing
+// each item with a pool of writers
+// It's the entry point of the decoder's stable API, with packages transform, writers and pool: within
+// an APIVersion, their identifiers are only added to, never changed or removed, and their specs and
+// configs are built with options, so they gain settings without breaking importers. Package
+// config_decoder, which they're built on, serves the decode_config_history command, and its exported
+// identifiers change with it; importers should use these packages instead. Unlike config_decoder's
+// functions, which report on channels for the command's progress and signal handling, a decode
+// returns once it's done, with the status of every worker.
+package decode
+
+import (
+	"context"
+	"errors"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/internal/apispec"
+	"github.com/mfrasier/decode_json_stream/pool"
+	"github.com/mfrasier/decode_json_stream/transform"
+	"github.com/mfrasier/decode_json_stream/writers"
+	"io"
+)
+
+//APIVersion is the version of the stable API of packages decode, transform, writers and pool
+// Its major version changes only if an identifier of theirs is changed or removed.
+const APIVersion = "1.0"
+
+//Sentinel errors wrapped by the errors a decode ends with, so callers can tell them apart with errors.Is
+var (
+	//ErrNotObject is a document, item or change event that isn't a json object
+	ErrNotObject = config_decoder.ErrNotObject
+	//ErrItemsFieldMissing is a document without its spec's items field
+	ErrItemsFieldMissing = config_decoder.ErrItemsFieldMissing
+	//ErrCancelled is a decode stopped because its context was done, whose error is wrapped too
+	ErrCancelled = config_decoder.ErrCancelled
+	//ErrWriterFailed is a decode stopped by a writer that couldn't be created, or whose write failed with
+	// pool.StopOnError, wrapped by a pool.WriteError saying which
+	ErrWriterFailed = config_decoder.ErrWriterFailed
+)
+
+//TruncatedError is a document that ended before it was complete, saying how many whole items were
+// written, the offset the last of them ended at, and the error reading the rest
+type TruncatedError struct {
+	Items  int64
+	Offset int64
+	Err    error
+}
+
+func (e *TruncatedError) Error() string {
+	return (&config_decoder.TruncatedError{Items: e.Items, Offset: e.Offset, Err: e.Err}).Error()
+}
+
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+//SchemaError is a document whose top-level fields aren't those a transform.Strict spec expects: its
+// copied fields or items field Missing or Duplicated, or fields that are neither Unexpected
+type SchemaError struct {
+	Missing    []string
+	Unexpected []string
+	Duplicated []string
+}
+
+func (e *SchemaError) Error() string {
+	return (&config_decoder.SchemaError{Missing: e.Missing, Unexpected: e.Unexpected, Duplicated: e.Duplicated}).Error()
+}
+
+//Result is the outcome of a decode: the status of each worker of its pool
+type Result struct {
+	Workers []pool.WorkerStatus
+}
+
+//Items returns the number of items the workers were given to write
+func (r Result) Items() int {
+	n := 0
+	for _, s := range r.Workers {
+		n += s.ItemCount
+	}
+	return n
+}
+
+//Errors returns the number of items the workers failed to write
+func (r Result) Errors() int {
+	n := 0
+	for _, s := range r.Workers {
+		n += s.ErrorCount
+	}
+	return n
+}
+
+//Decode decodes the items of the document read from r with spec, writing them with a pool of
+// writers made by f
+// It returns when every worker is done, with an error if decoding stopped early: at a malformed or
+// TruncatedError document, one that's ErrNotObject or ErrItemsFieldMissing, when ctx is done, as
+// ErrCancelled, or at the first write error with pool.StopOnError, as ErrWriterFailed. Other write
+// errors are counted in the Result.
+func Decode(ctx context.Context, r io.Reader, spec transform.Spec, f writers.Factory, p pool.Config) (Result, error) {
+	chStatus, chErrors := config_decoder.DecodeAndSplitItems(ctx, r, f, apispec.PoolSpec(p), apispec.ItemTransformSpec(spec))
+	return await(chStatus, chErrors, p.Size())
+}
+
+//DecodeAt decodes the document of size bytes r reads as Decode does, but with the items array decoded
+// by the goroutines of transform.Decoders, and the document's fields copied to items wherever they are
+func DecodeAt(ctx context.Context, r io.ReaderAt, size int64, spec transform.Spec, f writers.Factory, p pool.Config) (Result, error) {
+	chStatus, chErrors := config_decoder.DecodeAndSplitItemsAt(ctx, r, size, f, apispec.PoolSpec(p), apispec.ItemTransformSpec(spec))
+	return await(chStatus, chErrors, p.Size())
+}
+
+//DecodeEvents decodes a stream of AWS Config change events delivered by EventBridge, writing the
+// configuration item of each, with its change_type, as Decode does; spec's items and copied fields
+// don't apply
+func DecodeEvents(ctx context.Context, r io.Reader, spec transform.Spec, f writers.Factory, p pool.Config) (Result, error) {
+	chStatus, chErrors := config_decoder.DecodeChangeEvents(ctx, r, f, apispec.PoolSpec(p), apispec.ItemTransformSpec(spec))
+	return await(chStatus, chErrors, p.Size())
+}
+
+//await waits for decoding to end, then collects the status of each of its workers
+func await(chStatus chan config_decoder.WorkerStatus, chErrors chan error, workers int) (Result, error) {
+	// the errors channel is closed once decoding ends, so its first value is nil unless it failed
+	err := <-chErrors
+	result := Result{Workers: make([]pool.WorkerStatus, 0, workers)}
+	for i := 0; i < workers; i++ {
+		result.Workers = append(result.Workers, apispec.WorkerStatus(<-chStatus).(pool.WorkerStatus))
+	}
+	return result, stableErr(err)
+}
+
+//stableError is an error a decode ended with, also wrapping the stable API's errors standing for the
+// config_decoder errors in it, so callers find them with errors.As
+type stableError struct {
+	err    error
+	stable []error
+}
+
+func (e *stableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stableError) Unwrap() []error {
+	return append([]error{e.err}, e.stable...)
+}
+
+//stableErr returns err, the error decoding ended with, wrapping the stable API's errors for those in it
+func stableErr(err error) error {
+	var stable []error
+	var te *config_decoder.TruncatedError
+	if errors.As(err, &te) {
+		stable = append(stable, &TruncatedError{Items: te.Items, Offset: te.Offset, Err: te.Err})
+	}
+	var se *config_decoder.SchemaError
+	if errors.As(err, &se) {
+		stable = append(stable, &SchemaError{Missing: se.Missing, Unexpected: se.Unexpected, Duplicated: se.Duplicated})
+	}
+	var we *config_decoder.WriteError
+	if errors.As(err, &we) {
+		stable = append(stable, apispec.WriteError(we))
+	}
+	if len(stable) == 0 {
+		return err
+	}
+	return &stableError{err: err, stable: stable}
+}
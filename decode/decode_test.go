@@ -0,0 +1,93 @@
+package decode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/mfrasier/decode_json_stream/pool"
+	"github.com/mfrasier/decode_json_stream/transform"
+	"github.com/mfrasier/decode_json_stream/writers"
+	"io"
+	"strings"
+	"testing"
+)
+
+const snapshot = `{"fileVersion": "1.0", "configSnapshotId": "s-1", "configurationItems": [
+	{"resourceId": "i-1", "resourceType": "AWS::EC2::Instance", "awsRegion": "us-east-1", "configuration": {"a": 1}},
+	{"resourceId": "b-1", "resourceType": "AWS::S3::Bucket", "awsRegion": "eu-west-1", "configuration": {"b": 2}},
+	{"resourceId": "i-2", "resourceType": "AWS::EC2::Instance", "awsRegion": "us-east-1", "configuration": {"c": 3}}]}`
+
+func TestDecode(t *testing.T) {
+	c := &writers.Collector{}
+	spec := transform.Snapshot(transform.RunID("run-1"), transform.Hash("config_hash"))
+	result, err := Decode(context.Background(), strings.NewReader(snapshot), spec, writers.Collect(c), pool.New(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Workers) != 2 || result.Items() != 3 || result.Errors() != 0 || c.Count() != 3 {
+		t.Fatalf("decoded %d items with %d errors by %d workers, collected %d", result.Items(), result.Errors(), len(result.Workers), c.Count())
+	}
+	for _, item := range c.Items() {
+		snapshot, _ := item["config_snapshot"].(map[string]string)
+		if snapshot["configSnapshotId"] != "s-1" || item["run_id"] != "run-1" || item["config_hash"] == nil {
+			t.Errorf("item %v lacks the snapshot id, run id or hash", item)
+		}
+	}
+
+	// items routed to writers by a template are encoded as the FileOptions say
+	var us, eu bytes.Buffer
+	outs := map[string]*bytes.Buffer{"us-east-1": &us, "eu-west-1": &eu}
+	open := func(name string) (io.Writer, error) {
+		return outs[name], nil
+	}
+	f, err := writers.Routed("{{.awsRegion}}", open, writers.Canonical(), writers.Terminator([]byte("\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec = transform.Snapshot(transform.NoProvenance(), transform.Reproducible(), transform.Envelope("nested", ""))
+	if _, err := Decode(context.Background(), strings.NewReader(snapshot), spec, f, pool.New(1)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(us.String(), "\r\n") != 2 || !strings.HasPrefix(eu.String(), `{"awsRegion":"eu-west-1","configuration":{"b":2},"metadata":{"config_snapshot":{"configSnapshotId":"s-1"`) {
+		t.Errorf("routed us-east-1 %q and eu-west-1 %q", us.String(), eu.String())
+	}
+
+	// a document ending early is a TruncatedError
+	var truncated *TruncatedError
+	_, err = Decode(context.Background(), strings.NewReader(snapshot[:len(snapshot)/2]), transform.Snapshot(), writers.Null(), pool.New(1))
+	if !errors.As(err, &truncated) {
+		t.Errorf("decoding half the document ended with %v, want a TruncatedError", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	spec, err := transform.Load(strings.NewReader(`{"ItemsField": "items", "Fields": {"id": "document_id"}}`), transform.CopyField("kind", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.ItemsField() != "items" || spec.Fields()["id"] != "document_id" || spec.Fields()["kind"] != "kind" {
+		t.Errorf("loaded items field %q, fields %v", spec.ItemsField(), spec.Fields())
+	}
+	if _, err := transform.Load(strings.NewReader(`{"Fields": {}}`)); err == nil {
+		t.Error("loaded a spec without ItemsField")
+	}
+}
+
+//TestDecodeErrors checks the errors a decode ends with are found as the stable API's types
+func TestDecodeErrors(t *testing.T) {
+	failing := func(ctx context.Context, worker int) (writers.ItemWriter, error) {
+		return nil, errors.New("sink unavailable")
+	}
+	_, err := Decode(context.Background(), strings.NewReader(snapshot), transform.Snapshot(), failing, pool.New(1))
+	var we *pool.WriteError
+	if !errors.As(err, &we) || we.Worker != 0 || !errors.Is(err, ErrWriterFailed) {
+		t.Errorf("a writer that couldn't be created ended decoding with %v, want a pool.WriteError", err)
+	}
+
+	_, err = Decode(context.Background(), strings.NewReader(snapshot), transform.New("configurationItems", transform.Strict()),
+		writers.Null(), pool.New(1))
+	var se *SchemaError
+	if !errors.As(err, &se) || strings.Join(se.Unexpected, ",") != "fileVersion,configSnapshotId" {
+		t.Errorf("decoding a snapshot with unexpected fields ended with %v, want a SchemaError", err)
+	}
+}
@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+//BatchFile is one file of a batch: its name, following AWS Config's naming convention, and the
+// options it's generated with
+type BatchFile struct {
+	Name    string
+	Options Options
+}
+
+//Batch plans a batch of n synthesized files, as AWS Config would deliver them
+// Each file is of one account and region, cycling through every combination of opts.Accounts and
+// opts.Regions, which default to three random accounts and several regions. Snapshots each have a new
+// snapshot id, unless there's just the one and opts has one. With opts.Seed, the files are seeded in
+// turn from it, so the batch is reproducible too.
+func Batch(opts Options, n int, gzipped bool) []BatchFile {
+	seed, delivered := opts.Seed, SeedTime
+	if seed == 0 {
+		seed, delivered = time.Now().UnixNano(), time.Now()
+	}
+	s := NewSynthesizer(rand.New(rand.NewSource(seed)), opts, delivered)
+
+	var files []BatchFile
+	for i := 0; i < n; i++ {
+		fo := opts
+		fo.Synthesize = true
+		fo.Accounts = []string{s.accounts[i%len(s.accounts)]}
+		fo.Regions = []string{s.regions[i/len(s.accounts)%len(s.regions)]}
+		if fo.Format != FormatHistory && (opts.SnapshotID == "" || n > 1) {
+			fo.SnapshotID = s.uuid()
+		}
+		if opts.Seed != 0 {
+			fo.Seed = opts.Seed + int64(i)
+		}
+		files = append(files, BatchFile{Name: FileName(fo, delivered, gzipped), Options: fo})
+	}
+	return files
+}
+
+//FileName returns the name AWS Config gives the file generated with opts, delivered at t
+// A snapshot is <account>_Config_<region>_ConfigSnapshot_<time>_<snapshot id>.json, and a history
+// <account>_Config_<region>_ConfigHistory_<resource type>_<start>_<end>_1.json, of the six hours to t.
+// History files are of one resource type; one with a mix of them is named for AWS::All.
+func FileName(opts Options, t time.Time, gzipped bool) string {
+	const stamp = "20060102T150405Z"
+	account, region := "", ""
+	if len(opts.Accounts) > 0 {
+		account = opts.Accounts[0]
+	}
+	if len(opts.Regions) > 0 {
+		region = opts.Regions[0]
+	}
+	t = t.UTC().Truncate(time.Second)
+
+	var name string
+	if opts.Format == FormatHistory {
+		resourceType := "AWS::All"
+		if len(opts.Mix) == 1 {
+			resourceType = opts.Mix[0].ResourceType
+		}
+		name = fmt.Sprintf("%s_Config_%s_ConfigHistory_%s_%s_%s_1.json",
+			account, region, resourceType, t.Add(-6*time.Hour).Format(stamp), t.Format(stamp))
+	} else {
+		name = fmt.Sprintf("%s_Config_%s_ConfigSnapshot_%s_%s.json", account, region, t.Format(stamp), opts.SnapshotID)
+	}
+	if gzipped {
+		name += ".gz"
+	}
+	return name
+}
@@ -0,0 +1,91 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+//ItemDefect is a defect injected into an item
+type ItemDefect int
+
+const (
+	//InvalidUTF8 puts invalid UTF-8 in the item's resourceId
+	InvalidUTF8 ItemDefect = iota + 1
+	//Malformed makes the item invalid json, missing the colon after its first key
+	Malformed
+	//DuplicateKey gives the item a second resourceId, before its own
+	DuplicateKey
+)
+
+func (d ItemDefect) String() string {
+	switch d {
+	case InvalidUTF8:
+		return "invalid UTF-8"
+	case Malformed:
+		return "malformed"
+	case DuplicateKey:
+		return "duplicate key"
+	default:
+		return fmt.Sprintf("ItemDefect(%d)", int(d))
+	}
+}
+
+//Defects are defects injected into a snapshot, to test the decoder's error handling reproducibly
+// Truncate cuts the snapshot off after that many bytes; 0 doesn't.
+// MissingEnd leaves off the closing `]}` of the items array and the document.
+// Items maps the index of an item to the defect injected into it; only synthesized items have them.
+type Defects struct {
+	Truncate   int64
+	MissingEnd bool
+	Items      map[int]ItemDefect
+}
+
+//validate checks the defects can be injected into a snapshot made with opts
+func (d Defects) validate(opts Options) error {
+	if d.Truncate < 0 {
+		return fmt.Errorf("Defects: Truncate %d is negative", d.Truncate)
+	}
+	if len(d.Items) > 0 && !opts.synthesized() {
+		return fmt.Errorf("Defects: item defects need synthesized items")
+	}
+	for i, defect := range d.Items {
+		if i < 0 || i >= opts.Count {
+			return fmt.Errorf("Defects: %s item %d is not one of the %d items", defect, i, opts.Count)
+		}
+	}
+	return nil
+}
+
+//inject injects defect into item, the item encoded as json
+func (d ItemDefect) inject(item []byte) []byte {
+	switch d {
+	case InvalidUTF8:
+		return bytes.Replace(item, []byte(`"resourceId":"`), []byte("\"resourceId\":\"\xff\xfe"), 1)
+	case Malformed:
+		return bytes.Replace(item, []byte(`":`), []byte(`" `), 1)
+	case DuplicateKey:
+		return append([]byte(`{"resourceId":"duplicate",`), item[1:]...)
+	}
+	return item
+}
+
+//truncatingWriter writes up to n bytes, discarding the rest as if they had been written
+type truncatingWriter struct {
+	w       io.Writer
+	n       int64
+	written int64
+}
+
+func (tw *truncatingWriter) Write(p []byte) (int, error) {
+	keep := p
+	if left := tw.n - tw.written; int64(len(keep)) > left {
+		keep = keep[:left]
+	}
+	n, err := tw.w.Write(keep)
+	tw.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,369 @@
+//Package generator makes bigger snapshot files for testing the decoder
+// A snapshot starts with config_snapshot.json.part1, followed by as many copies of the
+// config_snapshot.json.items contents (24 items) as needed, and ends with `]}` to make it valid json.
+// The partial files are embedded into the binary.
+// Alternatively, a snapshot's items are synthesized: randomized, realistic items of several resource types.
+// ConfigHistory files, of synthesized resource timelines, can be generated too.
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	part1File = "config_snapshot.json.part1"
+	itemsFile = "config_snapshot.json.items"
+	ending    = "]}\n"
+)
+
+//go:embed config_snapshot.json.*
+var res embed.FS
+
+//Options are the options for generating a snapshot
+// Count is the approximate number of items; it's rounded up to a whole number of item blocks,
+// unless they're synthesized.
+// Synthesize makes new randomized items instead of repeating the embedded ones.
+// Format is the document generated, FormatSnapshot or FormatHistory; "" is a snapshot.
+// A history's items are always synthesized.
+// Accounts and Regions are the accounts and regions of synthesized items; by default they're spread
+// across a few random accounts and several regions.
+// SnapshotID and FileVersion are those of a synthesized document; by default a random UUID (snapshots
+// only) and "1.0".
+// Mix is the share of synthesized items of each resource type; by default the types with templates
+// are equally likely. Oversized items, chosen at random, are padded out to OversizedBytes each.
+// Seed seeds the random content of synthesized documents, so it's the same, byte for byte, every time
+// it's generated with the same options; items are then captured in the 30 days before SeedTime instead of now.
+// 0 seeds from the time.
+// Endless streams synthesized items without end, always synthesized, never closing the items array; Count then only bounds
+// the items that may be oversized or have defects. Rate paces items to that many per second, flushing each
+// through to w, and through w too if it has a Flush method; 0 is as fast as possible.
+// Defects are injected into the snapshot, for testing; the zero value injects none.
+type Options struct {
+	Count          int
+	Synthesize     bool
+	Format         string
+	Accounts       []string
+	Regions        []string
+	SnapshotID     string
+	FileVersion    string
+	Mix            []MixEntry
+	Oversized      int
+	OversizedBytes int
+	Seed           int64
+	Endless        bool
+	Rate           float64
+	Defects        Defects
+}
+
+//SeedTime is the time items are synthesized before with a seed
+var SeedTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+//the formats of generated documents
+const (
+	//FormatSnapshot is a ConfigSnapshot file, of the configuration of many resources at one time
+	FormatSnapshot = "snapshot"
+	//FormatHistory is a ConfigHistory file, of the timelines of resources' configuration, with diffs
+	FormatHistory = "history"
+)
+
+//validate checks the settings of a synthesized document are only given for one
+func (o Options) validate() error {
+	synthOnly := len(o.Accounts) > 0 || len(o.Regions) > 0 || o.SnapshotID != "" || o.FileVersion != ""
+	if synthOnly && !o.synthesized() {
+		return fmt.Errorf("Options: accounts, regions, snapshot id and file version need synthesized items")
+	}
+	if (len(o.Mix) > 0 || o.Oversized > 0) && !o.synthesized() {
+		return fmt.Errorf("Options: a mix and oversized items need synthesized items")
+	}
+	if o.Rate != 0 && !o.synthesized() {
+		return fmt.Errorf("Options: paced documents need synthesized items")
+	}
+	if o.Rate < 0 {
+		return fmt.Errorf("Options: Rate %g is negative", o.Rate)
+	}
+	if o.Oversized < 0 || o.Oversized > o.Count {
+		return fmt.Errorf("Options: %d oversized items is not between 0 and the %d items", o.Oversized, o.Count)
+	}
+	if o.Oversized > 0 && o.OversizedBytes <= 0 {
+		return fmt.Errorf("Options: OversizedBytes %d is not positive", o.OversizedBytes)
+	}
+	for _, a := range o.Accounts {
+		if len(a) != 12 || strings.Trim(a, "0123456789") != "" {
+			return fmt.Errorf("Options: account %q is not 12 digits", a)
+		}
+	}
+	return nil
+}
+
+//synthesized reports whether items are synthesized
+func (o Options) synthesized() bool {
+	return o.Synthesize || o.Format == FormatHistory || o.Endless
+}
+
+//Stats describes a generated snapshot
+type Stats struct {
+	Chunks int
+	Items  int
+	Bytes  int64
+}
+
+//itemCount counts the items in a block of items, the objects at its top level
+// Braces of nested objects, and in strings, aren't items.
+func itemCount(items []byte) int {
+	numItems := 0
+	depth := 0
+	inString, escaped := false, false
+
+	for _, i := range items {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch i {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case i == '"':
+			inString = true
+		case i == '{' || i == '[':
+			if depth == 0 && i == '{' {
+				numItems++
+			}
+			depth++
+		case i == '}' || i == ']':
+			depth--
+		}
+	}
+	return numItems
+}
+
+//Write writes a snapshot of about opts.Count items to w
+// With Defects.Truncate, Stats.Bytes counts the bytes written before the snapshot was cut off.
+func Write(w io.Writer, opts Options) (Stats, error) {
+	if opts.Format != "" && opts.Format != FormatSnapshot && opts.Format != FormatHistory {
+		return Stats{}, fmt.Errorf("Write: unknown format %q", opts.Format)
+	}
+	if err := opts.validate(); err != nil {
+		return Stats{}, fmt.Errorf("Write: %w", err)
+	}
+	if err := opts.Defects.validate(opts); err != nil {
+		return Stats{}, fmt.Errorf("Write: %w", err)
+	}
+
+	var tw *truncatingWriter
+	if opts.Defects.Truncate > 0 {
+		tw = &truncatingWriter{w: w, n: opts.Defects.Truncate}
+		w = tw
+	}
+
+	var stats Stats
+	var err error
+	if opts.synthesized() {
+		stats, err = writeSynthesized(w, opts)
+	} else {
+		stats, err = writeRepeated(w, opts)
+	}
+	if tw != nil {
+		stats.Bytes = tw.written
+	}
+	return stats, err
+}
+
+//writeRepeated writes a snapshot of about opts.Count items to w, repeating the embedded items
+func writeRepeated(w io.Writer, opts Options) (Stats, error) {
+	var stats Stats
+
+	part1, err := res.ReadFile(part1File)
+	if err != nil {
+		return stats, fmt.Errorf("Write: %w", err)
+	}
+	items, err := res.ReadFile(itemsFile)
+	if err != nil {
+		return stats, fmt.Errorf("Write: %w", err)
+	}
+
+	itemsSize := itemCount(items)
+	if itemsSize == 0 {
+		return stats, fmt.Errorf("Write: %s has no items", itemsFile)
+	}
+	// how many item chunks to write?
+	if opts.Count > 0 {
+		stats.Chunks = (opts.Count / itemsSize) + 1
+	}
+
+	n, err := bytes.NewBuffer(part1).WriteTo(w)
+	stats.Bytes += n
+	if err != nil {
+		return stats, fmt.Errorf("Write: %w", err)
+	}
+
+	// write item chunks, separated by commas
+	for c := 0; c < stats.Chunks; c++ {
+		if c > 0 {
+			n, err := w.Write([]byte(","))
+			stats.Bytes += int64(n)
+			if err != nil {
+				return stats, fmt.Errorf("Write: %w", err)
+			}
+		}
+
+		n, err := bytes.NewBuffer(items).WriteTo(w)
+		stats.Bytes += n
+		if err != nil {
+			return stats, fmt.Errorf("Write: %w", err)
+		}
+		stats.Items += itemsSize
+	}
+
+	if opts.Defects.MissingEnd {
+		return stats, nil
+	}
+	m, err := io.WriteString(w, ending)
+	stats.Bytes += int64(m)
+	if err != nil {
+		return stats, fmt.Errorf("Write: %w", err)
+	}
+	return stats, nil
+}
+
+//writeSynthesized writes a snapshot or history of opts.Count synthesized items to w, or endlessly
+func writeSynthesized(w io.Writer, opts Options) (Stats, error) {
+	var stats Stats
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	seed, now := opts.Seed, SeedTime
+	if seed == 0 {
+		seed, now = time.Now().UnixNano(), time.Now()
+	}
+	s := NewSynthesizer(rand.New(rand.NewSource(seed)), opts, now)
+
+	oversized := make(map[int]bool, opts.Oversized)
+	for len(oversized) < opts.Oversized {
+		oversized[s.rnd.Intn(opts.Count)] = true
+	}
+
+	fileVersion, snapshotID := opts.FileVersion, opts.SnapshotID
+	if fileVersion == "" {
+		fileVersion = "1.0"
+	}
+	if snapshotID == "" {
+		snapshotID = s.uuid()
+	}
+
+	header := fmt.Sprintf(`{"fileVersion":%s,"configSnapshotId":%s,"configurationItems":[`, quote(fileVersion), quote(snapshotID))
+	next := s.Item
+	if opts.Format == FormatHistory {
+		header = fmt.Sprintf(`{"fileVersion":%s,"configurationItems":[`, quote(fileVersion))
+		var timeline []Item
+		next = func() Item {
+			if len(timeline) == 0 {
+				n := opts.Count - stats.Items
+				if opts.Endless {
+					n = maxVersions
+				}
+				timeline = s.History(n)
+			}
+			item := timeline[0]
+			timeline = timeline[1:]
+			return item
+		}
+	}
+	if _, err := io.WriteString(cw, header); err != nil {
+		return stats, fmt.Errorf("writeSynthesized: %w", err)
+	}
+
+	// items are encoded to buf first, so defects can be injected into them
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	start := time.Now()
+	for i := 0; opts.Endless || i < opts.Count; i++ {
+		if opts.Rate > 0 {
+			if err := pace(bw, w, start.Add(time.Duration(float64(i)/opts.Rate*float64(time.Second)))); err != nil {
+				return stats, fmt.Errorf("writeSynthesized: %w", err)
+			}
+		}
+		if i > 0 {
+			if _, err := io.WriteString(cw, ","); err != nil {
+				return stats, fmt.Errorf("writeSynthesized: %w", err)
+			}
+		}
+
+		buf.Reset()
+		item := next()
+		if oversized[i] {
+			s.oversize(&item, opts.OversizedBytes)
+		}
+		if err := enc.Encode(item); err != nil {
+			return stats, fmt.Errorf("writeSynthesized: %w", err)
+		}
+		b := buf.Bytes()
+		if defect, ok := opts.Defects.Items[i]; ok {
+			b = defect.inject(b)
+		}
+		if _, err := cw.Write(b); err != nil {
+			return stats, fmt.Errorf("writeSynthesized: %w", err)
+		}
+		stats.Items++
+	}
+
+	var err error
+	if !opts.Defects.MissingEnd {
+		_, err = io.WriteString(cw, ending)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	stats.Bytes = cw.n
+	if err != nil {
+		return stats, fmt.Errorf("writeSynthesized: %w", err)
+	}
+	return stats, nil
+}
+
+//pace flushes bw and then w, if it has a Flush method, and waits until the next item is due
+func pace(bw *bufio.Writer, w io.Writer, due time.Time) error {
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if f, ok := w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	time.Sleep(wait)
+	return nil
+}
+
+//quote returns s as a json string
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+//countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestItemCount(t *testing.T) {
+	for _, tc := range []struct {
+		items string
+		want  int
+	}{
+		{``, 0},
+		{`{}`, 1},
+		{`{"a":{"b":{}}},{"c":[{},{}]}`, 2},
+		{`{"policy":"{\"Statement\":[{}]}"},{"name":"a \\\" {"}`, 2},
+		{"{\"a\":1},\n{\"b\":2},\n{\"c\":3}", 3},
+	} {
+		if got := itemCount([]byte(tc.items)); got != tc.want {
+			t.Errorf("itemCount(%s) = %d, want %d", tc.items, got, tc.want)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	items, err := res.ReadFile(itemsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := itemCount(items)
+
+	for _, count := range []int{0, 1, block, block + 1, 500} {
+		var buf bytes.Buffer
+		stats, err := Write(&buf, Options{Count: count})
+		if err != nil {
+			t.Fatalf("%d: %v", count, err)
+		}
+		var doc struct {
+			ConfigSnapshotID   string           `json:"configSnapshotId"`
+			ConfigurationItems []map[string]any `json:"configurationItems"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("%d: %v", count, err)
+		}
+		wantChunks := 0
+		if count > 0 {
+			wantChunks = count/block + 1
+		}
+		if stats.Chunks != wantChunks || stats.Items != wantChunks*block || stats.Bytes != int64(buf.Len()) {
+			t.Errorf("%d: stats %+v of %d bytes, want %d chunks", count, stats, buf.Len(), wantChunks)
+		}
+		if len(doc.ConfigurationItems) != stats.Items || doc.ConfigSnapshotID == "" {
+			t.Errorf("%d: %d items in snapshot %q, stats %+v", count, len(doc.ConfigurationItems), doc.ConfigSnapshotID, stats)
+		}
+		for i, item := range doc.ConfigurationItems {
+			if item["resourceType"] == nil || item["ARN"] == nil {
+				t.Errorf("%d: item %d has no resourceType or ARN", count, i)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+//MemberWriter gzips what's written to it as a multi-member gzip stream, of concatenated gzip files,
+// as S3 multipart uploads of separately compressed parts and re-compressed snapshots are
+// A new member is started every memberBytes of uncompressed input.
+type MemberWriter struct {
+	w           io.Writer
+	memberBytes int64
+	gz          *gzip.Writer
+	n           int64
+	members     int
+}
+
+//NewMemberWriter creates a MemberWriter to w starting a new member every memberBytes
+func NewMemberWriter(w io.Writer, memberBytes int64) *MemberWriter {
+	return &MemberWriter{w: w, memberBytes: memberBytes}
+}
+
+func (mw *MemberWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if mw.gz == nil {
+			mw.gz = gzip.NewWriter(mw.w)
+			mw.members++
+			mw.n = 0
+		}
+
+		chunk := p
+		if room := mw.memberBytes - mw.n; int64(len(chunk)) > room {
+			chunk = chunk[:room]
+		}
+		n, err := mw.gz.Write(chunk)
+		written += n
+		mw.n += int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		if mw.n == mw.memberBytes {
+			if err := mw.gz.Close(); err != nil {
+				return written, err
+			}
+			mw.gz = nil
+		}
+	}
+	return written, nil
+}
+
+//Close ends the last member
+func (mw *MemberWriter) Close() error {
+	if mw.gz == nil {
+		return nil
+	}
+	return mw.gz.Close()
+}
+
+//Members is the number of members written so far
+func (mw *MemberWriter) Members() int {
+	return mw.members
+}
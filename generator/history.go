@@ -0,0 +1,149 @@
+package generator
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+//ItemDiff is the change recorded by an item of a ConfigHistory file, from the resource's previous item
+type ItemDiff struct {
+	ChangedProperties map[string]PropertyChange `json:"changedProperties"`
+	ChangeType        string                    `json:"changeType"`
+}
+
+//PropertyChange is the change to one property of a resource
+type PropertyChange struct {
+	PreviousValue any    `json:"previousValue"`
+	UpdatedValue  any    `json:"updatedValue"`
+	ChangeType    string `json:"changeType"`
+}
+
+//maxVersions is the most items in the timeline of a resource
+const maxVersions = 5
+
+//History synthesizes the timeline of a new resource, as in a ConfigHistory file: up to n items, in order
+// of capture over the last 30 days, recording its discovery, changes to its tags and configuration,
+// and sometimes its deletion.
+func (s *Synthesizer) History(n int) []Item {
+	t := s.template()
+	versions := 1 + s.rnd.Intn(maxVersions)
+	if versions > n {
+		versions = n
+	}
+
+	at := s.now.Add(-30 * 24 * time.Hour)
+	step := 30 * 24 * time.Hour / time.Duration(versions)
+	next := func() time.Time {
+		at = at.Add(time.Duration(1 + s.rnd.Int63n(int64(step))))
+		return at
+	}
+
+	first := s.item(t, next())
+	first.Status = "ResourceDiscovered"
+	first.Diff = &ItemDiff{ChangedProperties: map[string]PropertyChange{}, ChangeType: "CREATE"}
+	items := []Item{first}
+	for len(items) < versions {
+		prev := items[len(items)-1]
+		if len(items) == versions-1 && s.rnd.Intn(5) == 0 {
+			items = append(items, s.deleted(prev, next()))
+			break
+		}
+		items = append(items, s.changed(t, prev, next()))
+	}
+	return items
+}
+
+//changed returns the next item of prev's resource, captured at, with a change to its tags or configuration
+func (s *Synthesizer) changed(t template, prev Item, at time.Time) Item {
+	item := prev
+	item.CaptureTime = at.Format(timeFormat)
+	item.StateID = at.UnixMilli()
+	item.Status = "OK"
+	item.Tags = make(map[string]string, len(prev.Tags))
+	for k, v := range prev.Tags {
+		item.Tags[k] = v
+	}
+	item.Configuration = make(map[string]any, len(prev.Configuration))
+	for k, v := range prev.Configuration {
+		item.Configuration[k] = v
+	}
+
+	changes := make(map[string]PropertyChange)
+	for len(changes) == 0 {
+		if s.rnd.Intn(2) == 0 {
+			s.changeTag(&item, changes)
+		} else {
+			s.changeConfiguration(t, &item, changes)
+		}
+	}
+	item.Diff = &ItemDiff{ChangedProperties: changes, ChangeType: "UPDATE"}
+	return item
+}
+
+//changeTag adds, updates or removes one of item's tags, recording the change in changes
+func (s *Synthesizer) changeTag(item *Item, changes map[string]PropertyChange) {
+	k := s.pick(tagKeys...)
+	prev, ok := item.Tags[k]
+	if ok && s.rnd.Intn(3) == 0 {
+		delete(item.Tags, k)
+		changes["Tags."+k] = PropertyChange{PreviousValue: prev, ChangeType: "DELETE"}
+		return
+	}
+
+	v := s.pick(tagValues[k]...)
+	if v == prev {
+		return
+	}
+	item.Tags[k] = v
+	if ok {
+		changes["Tags."+k] = PropertyChange{PreviousValue: prev, UpdatedValue: v, ChangeType: "UPDATE"}
+	} else {
+		changes["Tags."+k] = PropertyChange{UpdatedValue: v, ChangeType: "CREATE"}
+	}
+}
+
+//changeConfiguration changes one scalar property of item's configuration, recording the change in changes
+// The new value is that of a freshly synthesized configuration for the resource.
+func (s *Synthesizer) changeConfiguration(t template, item *Item, changes map[string]PropertyChange) {
+	relationships := item.Relationships
+	fresh := t.configure(s, item)
+	item.Relationships = relationships
+
+	var keys []string
+	for k, v := range fresh {
+		switch v.(type) {
+		case string, int, bool:
+			if !reflect.DeepEqual(v, item.Configuration[k]) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	// sorted, so a seeded synthesizer is repeatable
+	sort.Strings(keys)
+
+	k := s.pick(keys...)
+	changes["Configuration."+k] = PropertyChange{PreviousValue: item.Configuration[k], UpdatedValue: fresh[k], ChangeType: "UPDATE"}
+	item.Configuration[k] = fresh[k]
+}
+
+//deleted returns the item recording the deletion of prev's resource, captured at
+func (s *Synthesizer) deleted(prev Item, at time.Time) Item {
+	item := prev
+	item.CaptureTime = at.Format(timeFormat)
+	item.StateID = at.UnixMilli()
+	item.Status = "ResourceDeleted"
+	item.Tags = map[string]string{}
+	item.Relationships = []Relationship{}
+	item.Configuration = nil
+	item.Diff = &ItemDiff{
+		ChangedProperties: map[string]PropertyChange{
+			"Configuration": {PreviousValue: prev.Configuration, ChangeType: "DELETE"},
+		},
+		ChangeType: "DELETE",
+	}
+	return item
+}
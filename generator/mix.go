@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"fmt"
+	"strings"
+)
+
+//MixEntry is the share of synthesized items of one resource type
+type MixEntry struct {
+	ResourceType string
+	Weight       float64
+}
+
+//ParseMix parses a resource type mix of comma-separated type=weight pairs, such as
+// AWS::EC2::Instance=50,AWS::IAM::Role=30,AWS::S3::Bucket=20. Weights are relative, needn't sum to 100,
+// and types needn't be ones with templates.
+func ParseMix(s string) ([]MixEntry, error) {
+	var mix []MixEntry
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		t, w, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("ParseMix: want type=weight, not %q", pair)
+		}
+		var weight float64
+		if _, err := fmt.Sscan(w, &weight); err != nil || weight <= 0 {
+			return nil, fmt.Errorf("ParseMix: weight of %s is not a positive number: %q", t, w)
+		}
+		mix = append(mix, MixEntry{ResourceType: strings.TrimSpace(t), Weight: weight})
+	}
+	return mix, nil
+}
+
+//mixTemplates returns the templates of the types in mix, with their cumulative weights
+// A type without a template gets a generic one.
+func mixTemplates(mix []MixEntry) ([]template, []float64) {
+	ts := make([]template, len(mix))
+	cumulative := make([]float64, len(mix))
+	total := 0.0
+	for i, e := range mix {
+		ts[i] = genericTemplate(e.ResourceType)
+		for _, t := range templates {
+			if t.resourceType == e.ResourceType {
+				ts[i] = t
+			}
+		}
+		total += e.Weight
+		cumulative[i] = total
+	}
+	return ts, cumulative
+}
+
+//genericTemplate is the template of a resource type without one of its own
+// Its items have an ARN in the type's service and a small configuration.
+func genericTemplate(resourceType string) template {
+	parts := strings.Split(resourceType, "::")
+	service, kind := "unknown", "resource"
+	if len(parts) == 3 {
+		service, kind = strings.ToLower(parts[1]), strings.ToLower(parts[2])
+	}
+
+	return template{
+		resourceType: resourceType,
+		id:           func(s *Synthesizer) string { return kind + "-" + s.hex(17) },
+		name:         func(s *Synthesizer, id string) string { return "" },
+		arn: func(region, account, id, name string) string {
+			return fmt.Sprintf("arn:aws:%s:%s:%s:%s/%s", service, region, account, kind, id)
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			return map[string]any{
+				"id":     item.ResourceID,
+				"status": s.pick("ACTIVE", "ACTIVE", "PENDING", "INACTIVE"),
+				"size":   s.rnd.Intn(1000),
+			}
+		},
+	}
+}
@@ -0,0 +1,342 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+//Item is a configuration item as delivered in an AWS Config snapshot, with its fields in the same order
+type Item struct {
+	Version                    string            `json:"configurationItemVersion"`
+	CaptureTime                string            `json:"configurationItemCaptureTime"`
+	StateID                    int64             `json:"configurationStateId"`
+	AccountID                  string            `json:"awsAccountId"`
+	Status                     string            `json:"configurationItemStatus"`
+	ResourceType               string            `json:"resourceType"`
+	ResourceID                 string            `json:"resourceId"`
+	ResourceName               string            `json:"resourceName,omitempty"`
+	ARN                        string            `json:"ARN"`
+	Region                     string            `json:"awsRegion"`
+	AvailabilityZone           string            `json:"availabilityZone"`
+	StateMD5Hash               string            `json:"configurationStateMd5Hash"`
+	CreationTime               string            `json:"resourceCreationTime,omitempty"`
+	Tags                       map[string]string `json:"tags"`
+	RelatedEvents              []string          `json:"relatedEvents"`
+	Relationships              []Relationship    `json:"relationships"`
+	Configuration              map[string]any    `json:"configuration"`
+	SupplementaryConfiguration map[string]any    `json:"supplementaryConfiguration"`
+	Diff                       *ItemDiff         `json:"configurationItemDiff,omitempty"`
+}
+
+//Relationship relates a configuration item to another resource
+type Relationship struct {
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	Name         string `json:"name"`
+}
+
+//timeFormat is the format of the times in configuration items
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+//defaultRegions are the regions synthesized items are spread across, unless others are given
+var defaultRegions = []string{"us-east-1", "us-east-2", "us-west-2", "eu-west-1", "eu-central-1", "ap-southeast-2"}
+
+//tagValues are the tags synthesized items may have, and their values
+var tagValues = map[string][]string{
+	"Environment": {"prod", "staging", "dev", "test"},
+	"Team":        {"platform", "payments", "search", "data", "security"},
+	"CostCenter":  {"cc-1001", "cc-1002", "cc-2040", "cc-3300"},
+	"Owner":       {"alice", "bob", "carol", "dan", "erin"},
+	"Application": {"checkout", "catalog", "ledger", "ingest", "reporting"},
+}
+
+//tagKeys are the keys of tagValues, in a fixed order so a seeded generator is repeatable
+var tagKeys = []string{"Environment", "Team", "CostCenter", "Owner", "Application"}
+
+//template synthesizes the items of one resource type
+// id and name make a new resource's id and name, arn its ARN, and configure its configuration.
+type template struct {
+	resourceType string
+	id           func(s *Synthesizer) string
+	name         func(s *Synthesizer, id string) string
+	arn          func(region, account, id, name string) string
+	configure    func(s *Synthesizer, item *Item) map[string]any
+}
+
+//templates are the resource types items are synthesized from
+var templates = []template{
+	{
+		resourceType: "AWS::EC2::Instance",
+		id:           func(s *Synthesizer) string { return "i-" + s.hex(17) },
+		name:         func(s *Synthesizer, id string) string { return "" },
+		arn: func(region, account, id, name string) string {
+			return fmt.Sprintf("arn:aws:ec2:%s:%s:instance/%s", region, account, id)
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			subnet, vpc, sg := "subnet-"+s.hex(17), "vpc-"+s.hex(17), "sg-"+s.hex(17)
+			item.Relationships = []Relationship{
+				{ResourceType: "AWS::EC2::SecurityGroup", ResourceID: sg, Name: "Is associated with SecurityGroup"},
+				{ResourceType: "AWS::EC2::Subnet", ResourceID: subnet, Name: "Is contained in Subnet"},
+				{ResourceType: "AWS::EC2::VPC", ResourceID: vpc, Name: "Is contained in Vpc"},
+			}
+			return map[string]any{
+				"instanceId":       item.ResourceID,
+				"instanceType":     s.pick("t3.micro", "t3.large", "m5.xlarge", "c6i.2xlarge", "r6g.large"),
+				"imageId":          "ami-" + s.hex(17),
+				"state":            map[string]any{"code": 16, "name": s.pick("running", "running", "running", "stopped")},
+				"privateIpAddress": fmt.Sprintf("10.%d.%d.%d", s.rnd.Intn(256), s.rnd.Intn(256), 1+s.rnd.Intn(254)),
+				"subnetId":         subnet,
+				"vpcId":            vpc,
+				"securityGroups":   []map[string]any{{"groupId": sg}},
+				"launchTime":       item.CreationTime,
+				"placement":        map[string]any{"availabilityZone": item.AvailabilityZone, "tenancy": "default"},
+			}
+		},
+	},
+	{
+		resourceType: "AWS::S3::Bucket",
+		id: func(s *Synthesizer) string {
+			return s.pick("logs", "assets", "backups", "data", "artifacts") + "-" + s.hex(12)
+		},
+		name: func(s *Synthesizer, id string) string { return id },
+		arn: func(region, account, id, name string) string {
+			return "arn:aws:s3:::" + id
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			return map[string]any{
+				"name":         item.ResourceID,
+				"owner":        map[string]any{"displayName": nil, "id": s.hex(64)},
+				"creationDate": item.CreationTime,
+			}
+		},
+	},
+	{
+		resourceType: "AWS::IAM::Role",
+		id:           func(s *Synthesizer) string { return "AROA" + strings.ToUpper(s.alnum(17)) },
+		name: func(s *Synthesizer, id string) string {
+			return s.pick("app", "lambda", "ecs-task", "ci", "readonly") + "-role-" + s.alnum(6)
+		},
+		arn: func(region, account, id, name string) string {
+			return fmt.Sprintf("arn:aws:iam::%s:role/%s", account, name)
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			return map[string]any{
+				"path":       "/",
+				"roleName":   item.ResourceName,
+				"roleId":     item.ResourceID,
+				"arn":        item.ARN,
+				"createDate": item.CreationTime,
+				"assumeRolePolicyDocument": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"` +
+					s.pick("ec2", "lambda", "ecs-tasks") + `.amazonaws.com"},"Action":"sts:AssumeRole"}]}`,
+				"attachedManagedPolicies": []map[string]any{
+					{"policyName": "ReadOnlyAccess", "policyArn": "arn:aws:iam::aws:policy/ReadOnlyAccess"},
+				},
+			}
+		},
+	},
+	{
+		resourceType: "AWS::EC2::SecurityGroup",
+		id:           func(s *Synthesizer) string { return "sg-" + s.hex(17) },
+		name:         func(s *Synthesizer, id string) string { return s.pick("web", "db", "default", "bastion") + "-sg" },
+		arn: func(region, account, id, name string) string {
+			return fmt.Sprintf("arn:aws:ec2:%s:%s:security-group/%s", region, account, id)
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			return map[string]any{
+				"groupId":   item.ResourceID,
+				"groupName": item.ResourceName,
+				"vpcId":     "vpc-" + s.hex(17),
+				"ipPermissions": []map[string]any{
+					{"ipProtocol": "tcp", "fromPort": 443, "toPort": 443, "ipv4Ranges": []map[string]any{{"cidrIp": "0.0.0.0/0"}}},
+				},
+			}
+		},
+	},
+	{
+		resourceType: "AWS::Lambda::Function",
+		id: func(s *Synthesizer) string {
+			return s.pick("process", "handle", "sync", "export") + "-" + s.pick("orders", "events", "users", "reports") + "-" + s.alnum(4)
+		},
+		name: func(s *Synthesizer, id string) string { return id },
+		arn: func(region, account, id, name string) string {
+			return fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", region, account, id)
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			return map[string]any{
+				"functionName": item.ResourceID,
+				"runtime":      s.pick("python3.12", "nodejs20.x", "java21", "provided.al2023"),
+				"memorySize":   128 << s.rnd.Intn(5),
+				"timeout":      3 + s.rnd.Intn(300),
+				"handler":      "index.handler",
+				"codeSize":     1024 + s.rnd.Intn(50<<20),
+			}
+		},
+	},
+	{
+		resourceType: "AWS::RDS::DBInstance",
+		id:           func(s *Synthesizer) string { return "db-" + strings.ToUpper(s.alnum(26)) },
+		name: func(s *Synthesizer, id string) string {
+			return s.pick("orders", "users", "ledger", "catalog") + "-db-" + s.alnum(4)
+		},
+		arn: func(region, account, id, name string) string {
+			return fmt.Sprintf("arn:aws:rds:%s:%s:db:%s", region, account, name)
+		},
+		configure: func(s *Synthesizer, item *Item) map[string]any {
+			return map[string]any{
+				"dBInstanceIdentifier": item.ResourceName,
+				"dBInstanceClass":      s.pick("db.t3.medium", "db.r6g.large", "db.m5.xlarge"),
+				"engine":               s.pick("postgres", "mysql", "aurora-postgresql"),
+				"allocatedStorage":     20 * (1 + s.rnd.Intn(50)),
+				"multiAZ":              s.rnd.Intn(2) == 1,
+				"storageEncrypted":     s.rnd.Intn(4) != 0,
+			}
+		},
+	},
+}
+
+//Synthesizer makes realistic, randomized configuration items from templates for a number of
+// resource types, with random ids, ARNs, regions, capture times and tags
+// Items are spread across accounts and regions and captured in the 30 days before the snapshot.
+// Their resource types are equally likely, unless a mix is given.
+type Synthesizer struct {
+	rnd        *rand.Rand
+	accounts   []string
+	regions    []string
+	templates  []template
+	cumulative []float64
+	now        time.Time
+	made       int
+}
+
+//NewSynthesizer creates a synthesizer of items drawing on rnd, captured before now, with the
+// Accounts, Regions and Mix of opts
+// Without accounts, items are spread across three random accounts, and without regions, several regions.
+// Items are only the same from the same rnd seed if now is the same too.
+func NewSynthesizer(rnd *rand.Rand, opts Options, now time.Time) *Synthesizer {
+	s := &Synthesizer{rnd: rnd, accounts: opts.Accounts, regions: opts.Regions, templates: templates, now: now.UTC().Truncate(time.Second)}
+	if len(opts.Mix) > 0 {
+		s.templates, s.cumulative = mixTemplates(opts.Mix)
+	}
+	if len(s.accounts) == 0 {
+		for i := 0; i < 3; i++ {
+			s.accounts = append(s.accounts, fmt.Sprintf("%012d", s.rnd.Int63n(1e12)))
+		}
+	}
+	if len(s.regions) == 0 {
+		s.regions = defaultRegions
+	}
+	return s
+}
+
+//Item synthesizes a new configuration item
+func (s *Synthesizer) Item() Item {
+	t := s.template()
+	captured := s.now.Add(-time.Duration(s.rnd.Int63n(int64(30 * 24 * time.Hour))))
+	return s.item(t, captured)
+}
+
+//item synthesizes a new configuration item of template t, captured at captured
+func (s *Synthesizer) item(t template, captured time.Time) Item {
+	created := captured.Add(-time.Duration(s.rnd.Int63n(int64(2 * 365 * 24 * time.Hour))))
+	region := s.pick(s.regions...)
+	s.made++
+
+	item := Item{
+		Version:       "1.3",
+		CaptureTime:   captured.Format(timeFormat),
+		StateID:       captured.UnixMilli(),
+		AccountID:     s.pick(s.accounts...),
+		Status:        s.pick("OK", "OK", "OK", "ResourceDiscovered"),
+		ResourceType:  t.resourceType,
+		ResourceID:    t.id(s),
+		Region:        region,
+		CreationTime:  created.Format(timeFormat),
+		Tags:          s.tags(),
+		RelatedEvents: []string{},
+		Relationships: []Relationship{},
+		// AWS Config has left the hash empty since 2019
+		StateMD5Hash:               "",
+		SupplementaryConfiguration: map[string]any{},
+	}
+	item.ResourceName = t.name(s, item.ResourceID)
+	item.ARN = t.arn(region, item.AccountID, item.ResourceID, item.ResourceName)
+	item.AvailabilityZone = "Not Applicable"
+	if t.resourceType == "AWS::EC2::Instance" || t.resourceType == "AWS::RDS::DBInstance" {
+		item.AvailabilityZone = region + s.pick("a", "b", "c")
+	}
+	item.Configuration = t.configure(s, &item)
+	return item
+}
+
+//template picks the template of the next resource, by the weights of the mix if there is one
+func (s *Synthesizer) template() template {
+	if s.cumulative == nil {
+		return s.templates[s.rnd.Intn(len(s.templates))]
+	}
+	r := s.rnd.Float64() * s.cumulative[len(s.cumulative)-1]
+	for i, c := range s.cumulative {
+		if r < c {
+			return s.templates[i]
+		}
+	}
+	return s.templates[len(s.templates)-1]
+}
+
+//oversize pads item's supplementary configuration out to at least size bytes
+func (s *Synthesizer) oversize(item *Item, size int) {
+	supplementary := make(map[string]any, len(item.SupplementaryConfiguration)+1)
+	for k, v := range item.SupplementaryConfiguration {
+		supplementary[k] = v
+	}
+	supplementary["padding"] = s.alnum(size)
+	item.SupplementaryConfiguration = supplementary
+}
+
+//tags makes a random set of tags
+func (s *Synthesizer) tags() map[string]string {
+	tags := make(map[string]string)
+	for _, k := range tagKeys {
+		if s.rnd.Intn(2) == 0 {
+			tags[k] = s.pick(tagValues[k]...)
+		}
+	}
+	if s.rnd.Intn(3) != 0 {
+		tags["Name"] = fmt.Sprintf("%s-%d", s.pick(tagValues["Application"]...), s.made)
+	}
+	return tags
+}
+
+func (s *Synthesizer) pick(choices ...string) string {
+	return choices[s.rnd.Intn(len(choices))]
+}
+
+//hex returns n random hex digits
+func (s *Synthesizer) hex(n int) string {
+	const digits = "0123456789abcdef"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = digits[s.rnd.Intn(len(digits))]
+	}
+	return string(b)
+}
+
+//alnum returns n random lowercase letters and digits
+func (s *Synthesizer) alnum(n int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[s.rnd.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+//uuid returns a random (version 4) UUID
+func (s *Synthesizer) uuid() string {
+	var u [16]byte
+	_, _ = s.rnd.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
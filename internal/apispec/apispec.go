@@ -0,0 +1,18 @@
+//Package apispec hands package decode the config_decoder specs that the stable API's specs and configs
+// stand for, and the stable types of what decoding reports, without them being part of the stable API
+// Packages transform and pool set its functions as they're initialised, so they're set before package
+// decode, which imports both, can call them.
+package apispec
+
+import "github.com/mfrasier/decode_json_stream/config_decoder"
+
+var (
+	//ItemTransformSpec returns the config_decoder.ItemTransformSpec of a transform.Spec
+	ItemTransformSpec func(spec any) config_decoder.ItemTransformSpec
+	//PoolSpec returns the config_decoder.PoolSpec of a pool.Config
+	PoolSpec func(config any) config_decoder.PoolSpec
+	//WorkerStatus returns the pool.WorkerStatus of a config_decoder.WorkerStatus
+	WorkerStatus func(status config_decoder.WorkerStatus) any
+	//WriteError returns the *pool.WriteError of a config_decoder.WriteError
+	WriteError func(err *config_decoder.WriteError) error
+)
@@ -0,0 +1,335 @@
+//Package kafkatest runs a fake Kafka broker for tests of the Kafka writer, speaking as much of the protocol
+// as a producer needs
+package kafkatest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/s2"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+//Record is a record produced to the broker
+type Record struct {
+	Key   []byte
+	Value []byte
+}
+
+//Broker is a fake single-broker cluster keeping the records produced to it in memory
+// It answers ApiVersions, Metadata, InitProducerID and Produce requests; every topic asked for exists,
+// with one partition, which the broker leads. Any other request closes its connection.
+type Broker struct {
+	ln    net.Listener
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	// records are those produced, by topic
+	records map[string][]Record
+}
+
+//NewBroker starts a Broker listening on a loopback port
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("NewBroker: %w", err)
+	}
+	b := &Broker{ln: ln, conns: make(map[net.Conn]bool), records: make(map[string][]Record)}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+//Addr is the broker's host:port, its clients' seed broker
+func (b *Broker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+//Records returns the records produced to topic so far, in the order they were produced
+func (b *Broker) Records(topic string) []Record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Record(nil), b.records[topic]...)
+}
+
+//Topics returns the topics records have been produced to, sorted
+func (b *Broker) Topics() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var topics []string
+	for topic := range b.records {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+//Close stops the broker, closing its clients' connections
+func (b *Broker) Close() error {
+	err := b.ln.Close()
+	b.mu.Lock()
+	for conn := range b.conns {
+		_ = conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return err
+}
+
+//accept serves each connection made to the broker until it's closed
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = true
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serve(conn)
+			b.mu.Lock()
+			delete(b.conns, conn)
+			b.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+//serve answers the requests made on conn, in order, until it fails
+func (b *Broker) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		req := make([]byte, size)
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		resp, err := b.handle(req)
+		if err != nil {
+			return
+		}
+		if resp == nil {
+			// no response is expected
+			continue
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+//handle returns the response to the request req, framed by its size, or nil if the request has none
+func (b *Broker) handle(req []byte) ([]byte, error) {
+	if len(req) < 10 {
+		return nil, errors.New("short request")
+	}
+	key, version := int16(binary.BigEndian.Uint16(req)), int16(binary.BigEndian.Uint16(req[2:]))
+	correlationID := req[4:8]
+	body := req[8:]
+	// the client id is a nullable string
+	if n := int16(binary.BigEndian.Uint16(body)); n > 0 {
+		body = body[n:]
+	}
+	body = body[2:]
+
+	r := kmsg.RequestForKey(key)
+	if r == nil {
+		return nil, fmt.Errorf("unknown request key %d", key)
+	}
+	r.SetVersion(version)
+	// a flexible request's header ends with tagged fields, but ApiVersions' responses never have them
+	headerTags := r.IsFlexible() && key != kmsg.ApiVersions.Int16()
+	if r.IsFlexible() {
+		var err error
+		if body, err = skipTags(body); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.ReadFrom(body); err != nil {
+		return nil, fmt.Errorf("reading %s request: %w", kmsg.NameForKey(key), err)
+	}
+
+	var resp kmsg.Response
+	switch r := r.(type) {
+	case *kmsg.ApiVersionsRequest:
+		resp = b.apiVersions(r)
+	case *kmsg.MetadataRequest:
+		resp = b.metadata(r)
+	case *kmsg.InitProducerIDRequest:
+		resp = b.initProducerID(r)
+	case *kmsg.ProduceRequest:
+		var err error
+		if resp, err = b.produce(r); err != nil || r.Acks == 0 {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported request %s", kmsg.NameForKey(key))
+	}
+
+	out := append(make([]byte, 4, 64), correlationID...)
+	if headerTags {
+		out = append(out, 0)
+	}
+	out = resp.AppendTo(out)
+	binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+	return out, nil
+}
+
+//skipTags skips the tagged fields at the start of b
+func skipTags(b []byte) ([]byte, error) {
+	n, size := binary.Uvarint(b)
+	if size <= 0 {
+		return nil, errors.New("bad tagged fields")
+	}
+	b = b[size:]
+	for i := uint64(0); i < n; i++ {
+		if _, size = binary.Uvarint(b); size <= 0 {
+			return nil, errors.New("bad tag")
+		}
+		b = b[size:]
+		length, size := binary.Uvarint(b)
+		if size <= 0 || uint64(len(b)-size) < length {
+			return nil, errors.New("bad tag")
+		}
+		b = b[uint64(size)+length:]
+	}
+	return b, nil
+}
+
+//apiVersions answers with the requests the broker takes, at every version the protocol package knows
+func (b *Broker) apiVersions(r *kmsg.ApiVersionsRequest) kmsg.Response {
+	resp := r.ResponseKind().(*kmsg.ApiVersionsResponse)
+	for _, req := range []kmsg.Request{new(kmsg.ApiVersionsRequest), new(kmsg.MetadataRequest),
+		new(kmsg.InitProducerIDRequest), new(kmsg.ProduceRequest)} {
+		k := kmsg.NewApiVersionsResponseApiKey()
+		k.ApiKey, k.MaxVersion = req.Key(), req.MaxVersion()
+		resp.ApiKeys = append(resp.ApiKeys, k)
+	}
+	return resp
+}
+
+//metadata answers that the broker is the cluster and leads the one partition of each topic asked for, or
+// of those produced to if none is
+func (b *Broker) metadata(r *kmsg.MetadataRequest) kmsg.Response {
+	resp := r.ResponseKind().(*kmsg.MetadataResponse)
+	host, port, _ := net.SplitHostPort(b.Addr())
+	p, _ := strconv.Atoi(port)
+	broker := kmsg.NewMetadataResponseBroker()
+	broker.NodeID, broker.Host, broker.Port = 0, host, int32(p)
+	resp.Brokers = []kmsg.MetadataResponseBroker{broker}
+	resp.ClusterID = kmsg.StringPtr("kafkatest")
+	resp.ControllerID = 0
+
+	var topics []string
+	if r.Topics == nil {
+		topics = b.Topics()
+	}
+	for _, t := range r.Topics {
+		if t.Topic != nil {
+			topics = append(topics, *t.Topic)
+		}
+	}
+	for _, name := range topics {
+		topic := kmsg.NewMetadataResponseTopic()
+		topic.Topic = kmsg.StringPtr(name)
+		partition := kmsg.NewMetadataResponseTopicPartition()
+		partition.Partition, partition.Leader, partition.LeaderEpoch = 0, 0, 0
+		partition.Replicas, partition.ISR = []int32{0}, []int32{0}
+		topic.Partitions = []kmsg.MetadataResponseTopicPartition{partition}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp
+}
+
+//initProducerID gives an idempotent producer its id
+func (b *Broker) initProducerID(r *kmsg.InitProducerIDRequest) kmsg.Response {
+	resp := r.ResponseKind().(*kmsg.InitProducerIDResponse)
+	resp.ProducerID, resp.ProducerEpoch = 1, 0
+	return resp
+}
+
+//produce keeps the records of each topic's batch, answering with the offset of each batch's first
+func (b *Broker) produce(r *kmsg.ProduceRequest) (kmsg.Response, error) {
+	resp := r.ResponseKind().(*kmsg.ProduceResponse)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range r.Topics {
+		topic := kmsg.NewProduceResponseTopic()
+		topic.Topic = t.Topic
+		for _, p := range t.Partitions {
+			records, err := readBatches(p.Records)
+			if err != nil {
+				return nil, fmt.Errorf("topic %s: %w", t.Topic, err)
+			}
+			partition := kmsg.NewProduceResponseTopicPartition()
+			partition.Partition, partition.BaseOffset = p.Partition, int64(len(b.records[t.Topic]))
+			b.records[t.Topic] = append(b.records[t.Topic], records...)
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+	}
+	return resp, nil
+}
+
+//readBatches returns the records of the record batches in data, which may be compressed with gzip or snappy
+func readBatches(data []byte) ([]Record, error) {
+	var records []Record
+	for len(data) > 0 {
+		var batch kmsg.RecordBatch
+		if err := batch.ReadFrom(data); err != nil {
+			return nil, fmt.Errorf("reading record batch: %w", err)
+		}
+		// the length counts the bytes after it
+		data = data[12+int(batch.Length):]
+
+		raw := batch.Records
+		switch codec := batch.Attributes & 0x07; codec {
+		case 0:
+		case 1:
+			zr, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, err
+			}
+			if raw, err = io.ReadAll(zr); err != nil {
+				return nil, err
+			}
+		case 2:
+			var err error
+			if raw, err = s2.Decode(nil, raw); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported compression codec %d", codec)
+		}
+
+		for i := int32(0); i < batch.NumRecords; i++ {
+			length, size := binary.Varint(raw)
+			if size <= 0 || int64(len(raw)-size) < length {
+				return nil, errors.New("truncated record")
+			}
+			var rec kmsg.Record
+			if err := rec.ReadFrom(raw[:size+int(length)]); err != nil {
+				return nil, fmt.Errorf("reading record: %w", err)
+			}
+			records = append(records, Record{Key: rec.Key, Value: rec.Value})
+			raw = raw[size+int(length):]
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,166 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+//ItemSchema is the Avro schema of configuration items
+// Identifying fields are strings. The configuration and other fields whose shape varies by
+// resource type are json text, as are any item fields not in the schema, in extra.
+const ItemSchema = `{
+  "type": "record",
+  "name": "ConfigurationItem",
+  "namespace": "com.amazonaws.config",
+  "fields": [
+    {"name": "resourceType", "type": ["null", "string"], "default": null},
+    {"name": "resourceId", "type": ["null", "string"], "default": null},
+    {"name": "resourceName", "type": ["null", "string"], "default": null},
+    {"name": "arn", "type": ["null", "string"], "default": null},
+    {"name": "awsAccountId", "type": ["null", "string"], "default": null},
+    {"name": "awsRegion", "type": ["null", "string"], "default": null},
+    {"name": "availabilityZone", "type": ["null", "string"], "default": null},
+    {"name": "configurationItemCaptureTime", "type": ["null", "string"], "default": null},
+    {"name": "configurationItemStatus", "type": ["null", "string"], "default": null},
+    {"name": "resourceCreationTime", "type": ["null", "string"], "default": null},
+    {"name": "tags", "type": ["null", {"type": "map", "values": "string"}], "default": null},
+    {"name": "configuration", "type": ["null", "string"], "default": null},
+    {"name": "supplementaryConfiguration", "type": ["null", "string"], "default": null},
+    {"name": "relationships", "type": ["null", "string"], "default": null},
+    {"name": "metadata", "type": ["null", "string"], "default": null},
+    {"name": "extra", "type": ["null", "string"], "default": null}
+  ]
+}`
+
+//avroField is a field of ItemSchema and how it's encoded
+type avroField struct {
+	name string
+	kind int
+}
+
+const (
+	avroString = iota
+	avroStringMap
+	avroJSON
+)
+
+//itemFields are the fields of ItemSchema in order, except extra
+var itemFields = []avroField{
+	{"resourceType", avroString},
+	{"resourceId", avroString},
+	{"resourceName", avroString},
+	{"arn", avroString},
+	{"awsAccountId", avroString},
+	{"awsRegion", avroString},
+	{"availabilityZone", avroString},
+	{"configurationItemCaptureTime", avroString},
+	{"configurationItemStatus", avroString},
+	{"resourceCreationTime", avroString},
+	{"tags", avroStringMap},
+	{"configuration", avroJSON},
+	{"supplementaryConfiguration", avroJSON},
+	{"relationships", avroJSON},
+	{"metadata", avroJSON},
+}
+
+//appendAvroItem appends the Avro binary encoding of item, following ItemSchema, to b
+func appendAvroItem(b []byte, item map[string]any) ([]byte, error) {
+	known := 0
+	for _, f := range itemFields {
+		v, ok := item[f.name]
+		if !ok || v == nil {
+			b = appendLong(b, 0)
+			continue
+		}
+		known++
+
+		var err error
+		b = appendLong(b, 1)
+		switch f.kind {
+		case avroString:
+			s, isString := v.(string)
+			if !isString {
+				s = fmt.Sprint(v)
+			}
+			b = appendString(b, s)
+		case avroStringMap:
+			b, err = appendStringMap(b, v)
+		case avroJSON:
+			b, err = appendJSON(b, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("appendAvroItem: %s: %w", f.name, err)
+		}
+	}
+
+	if known == len(item) {
+		return appendLong(b, 0), nil
+	}
+	extra := make(map[string]any, len(item)-known)
+	for k, v := range item {
+		extra[k] = v
+	}
+	for _, f := range itemFields {
+		delete(extra, f.name)
+	}
+	b = appendLong(b, 1)
+	return appendJSON(b, extra)
+}
+
+//appendStringMap encodes v, a json object, as an Avro map of strings
+// Tags in snapshots are objects; in query results they're arrays of key and value pairs,
+// which are converted. Values that aren't strings are json encoded.
+func appendStringMap(b []byte, v any) ([]byte, error) {
+	m, ok := v.(map[string]any)
+	if pairs, isArray := v.([]any); isArray {
+		m, ok = make(map[string]any, len(pairs)), true
+		for _, p := range pairs {
+			kv, isObject := p.(map[string]any)
+			k, isString := kv["key"].(string)
+			if !isObject || !isString {
+				return nil, fmt.Errorf("want key and value pairs, got %v", p)
+			}
+			m[k] = kv["value"]
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("want an object, got %T", v)
+	}
+
+	if len(m) > 0 {
+		b = appendLong(b, int64(len(m)))
+		for k, mv := range m {
+			s, isString := mv.(string)
+			if !isString {
+				js, err := json.Marshal(mv)
+				if err != nil {
+					return nil, err
+				}
+				s = string(js)
+			}
+			b = appendString(b, k)
+			b = appendString(b, s)
+		}
+	}
+	return appendLong(b, 0), nil
+}
+
+func appendJSON(b []byte, v any) ([]byte, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b = appendLong(b, int64(len(js)))
+	return append(b, js...), nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendLong(b, int64(len(s)))
+	return append(b, s...)
+}
+
+//appendLong appends the zig-zag varint encoding Avro uses for ints and longs
+func appendLong(b []byte, n int64) []byte {
+	return binary.AppendVarint(b, n)
+}
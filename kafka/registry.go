@@ -0,0 +1,72 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//registryContentType is the media type of Schema Registry requests
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+//RegisterSchema registers schema, of schemaType, e.g. PROTOBUF, or else Avro, under subject in the
+// Schema Registry at registryURL, returning its id. Registering a schema already registered returns
+// its existing id, so it doubles as a lookup. Credentials for basic auth may be given in the url's user info.
+func RegisterSchema(registryURL, subject, schemaType, schema string) (int, error) {
+	req := map[string]string{"schema": schema}
+	if schemaType != "" {
+		req["schemaType"] = schemaType
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+
+	u := strings.TrimSuffix(registryURL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	hreq, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("RegisterSchema: %w", err)
+	}
+	hreq.Header.Set("Content-Type", registryContentType)
+	hreq.Header.Set("Accept", registryContentType)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return 0, fmt.Errorf("RegisterSchema: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("RegisterSchema: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("RegisterSchema: %s: %s: %s", subject, resp.Status, bytes.TrimSpace(b))
+	}
+
+	var r struct{ ID int }
+	if err := json.Unmarshal(b, &r); err != nil {
+		return 0, fmt.Errorf("RegisterSchema: %w", err)
+	}
+	return r.ID, nil
+}
+
+//appendWireHeader appends the Confluent wire format header: a zero magic byte and the schema id
+func appendWireHeader(b []byte, schemaID int) []byte {
+	b = append(b, 0)
+	return binary.BigEndian.AppendUint32(b, uint32(schemaID))
+}
+
+//appendProtoWireHeader appends the Confluent wire format header of protobuf records, the header of
+// appendWireHeader and the indexes of the record's message in the schema; the first message's, [0],
+// are written as a single zero byte
+func appendProtoWireHeader(b []byte, schemaID int) []byte {
+	return append(appendWireHeader(b, schemaID), 0)
+}
@@ -0,0 +1,265 @@
+//Package kafka writes configuration items to Kafka topics, as json, or as Avro or protobuf
+// registered with a Confluent Schema Registry, so standard deserializers can read them.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//deliveryTimeout is how long a record may take to be delivered before it's reported as failed
+const deliveryTimeout = 2 * time.Minute
+
+//Config configures Kafka Writers
+// Topic is the topic items are produced to, or a config_decoder.NameTemplate naming each item's topic.
+// Format is json, avro or protobuf. Avro records are in the Confluent wire format, with the id of ItemSchema
+// as registered in SchemaRegistry under the subject <topic>-value of each topic produced to. Protobuf
+// records are config_decoder.ItemProto's ConfigurationItem messages, in the wire format with its id if
+// SchemaRegistry is set, and bare otherwise. Canonical writes json records as canonical json (see
+// config_decoder.AppendCanonicalJSON), the same bytes for the same item.
+type Config struct {
+	Brokers        []string
+	Topic          string
+	Format         string
+	SchemaRegistry string
+	Canonical      bool
+}
+
+//producer is the client shared by the Writers of one factory
+// The client is closed once all the Writers are closed, and opened again for any created after that,
+// as a factory may serve a pool for each input.
+type producer struct {
+	opts      []kgo.Opt
+	clientMu  sync.Mutex
+	client    *kgo.Client
+	writers   int
+	format    string
+	canonical bool
+	// topic is the topic of every item, unless route names each one's
+	topic string
+	// route names the topic of each item if Topic is a template; with a registry, the schema is
+	// registered under the subject of each topic the first time it's produced to
+	route    *config_decoder.NameTemplate
+	registry string
+	mu       sync.Mutex
+	// schemaIDs are the schema's ids under the subjects of the topics produced to
+	schemaIDs map[string]int
+}
+
+//Writer is an ItemWriter producing items to a Kafka topic, keyed by resource id, or by the partition
+// key of CloudEvents records
+// Records are produced asynchronously; delivery failures are returned by Flush. Close releases the
+// Writer's share of its factory's client; the writer pool calls it once the Writer is flushed.
+type Writer struct {
+	p      *producer
+	client *kgo.Client
+	closed bool
+	failed atomic.Int64
+	mu     sync.Mutex
+	err    error
+	// written counts the bytes of the records' values
+	written int64
+}
+
+//WriterFactory creates Writers sharing one Kafka client
+// For avro, and protobuf with a registry, the schema is registered, or looked up, before any item is
+// written to a topic that isn't a template.
+func WriterFactory(cfg Config) (config_decoder.WriterFactory, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("WriterFactory: brokers and topic are required")
+	}
+
+	p := &producer{format: cfg.Format, canonical: cfg.Canonical, topic: cfg.Topic, registry: cfg.SchemaRegistry,
+		schemaIDs: make(map[string]int)}
+	if config_decoder.IsNameTemplate(cfg.Topic) {
+		route, err := config_decoder.ParseNameTemplate(cfg.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("WriterFactory: %w", err)
+		}
+		p.route = route
+	}
+
+	switch cfg.Format {
+	case "", "json":
+	case "avro", "protobuf":
+		if cfg.SchemaRegistry == "" {
+			if cfg.Format == "avro" {
+				return nil, fmt.Errorf("WriterFactory: avro requires a schema registry")
+			}
+			break
+		}
+		if p.route == nil {
+			if _, err := p.schemaID(cfg.Topic); err != nil {
+				return nil, fmt.Errorf("WriterFactory: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("WriterFactory: unknown format %q", cfg.Format)
+	}
+
+	p.opts = []kgo.Opt{
+		kgo.SeedBrokers(cfg.Brokers...),
+		kgo.RecordDeliveryTimeout(deliveryTimeout),
+	}
+	// the options are checked now, rather than by the first writer
+	client, err := kgo.NewClient(p.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("WriterFactory: %w", err)
+	}
+	client.Close()
+
+	return func(context.Context, int) (config_decoder.ItemWriter, error) {
+		client, err := p.open()
+		if err != nil {
+			return nil, fmt.Errorf("kafka.WriterFactory: %w", err)
+		}
+		return &Writer{p: p, client: client}, nil
+	}, nil
+}
+
+//open returns the client for a new Writer, creating it if no other Writer holds it
+func (p *producer) open() (*kgo.Client, error) {
+	p.clientMu.Lock()
+	defer p.clientMu.Unlock()
+	if p.client == nil {
+		client, err := kgo.NewClient(p.opts...)
+		if err != nil {
+			return nil, err
+		}
+		p.client = client
+	}
+	p.writers++
+	return p.client, nil
+}
+
+//release gives up a Writer's share of the client, closing it once no Writer holds it
+func (p *producer) release() {
+	p.clientMu.Lock()
+	defer p.clientMu.Unlock()
+	if p.writers--; p.writers == 0 {
+		p.client.Close()
+		p.client = nil
+	}
+}
+
+//schemaID returns the id of the format's schema, ItemSchema or ItemProto, under the subject of topic,
+// registering it the first time
+func (p *producer) schemaID(topic string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id, ok := p.schemaIDs[topic]; ok {
+		return id, nil
+	}
+	var id int
+	var err error
+	if p.format == "protobuf" {
+		id, err = RegisterSchema(p.registry, topic+"-value", "PROTOBUF", config_decoder.ItemProto)
+	} else {
+		id, err = RegisterSchema(p.registry, topic+"-value", "", ItemSchema)
+	}
+	if err != nil {
+		return 0, err
+	}
+	p.schemaIDs[topic] = id
+	return id, nil
+}
+
+// Write implements ItemWriter for Writer
+func (w *Writer) Write(item map[string]interface{}) error {
+	topic := w.p.topic
+	var err error
+	if w.p.route != nil {
+		if topic, err = w.p.route.Name(item); err != nil {
+			return fmt.Errorf("kafka.Writer: %w", err)
+		}
+	}
+
+	var value []byte
+	switch {
+	case w.p.format == "avro":
+		var id int
+		if id, err = w.p.schemaID(topic); err != nil {
+			return fmt.Errorf("kafka.Writer: %w", err)
+		}
+		value, err = appendAvroItem(appendWireHeader(nil, id), item)
+	case w.p.format == "protobuf" && w.p.registry != "":
+		var id int
+		if id, err = w.p.schemaID(topic); err != nil {
+			return fmt.Errorf("kafka.Writer: %w", err)
+		}
+		value, err = config_decoder.AppendProtoItem(appendProtoWireHeader(nil, id), item)
+	case w.p.format == "protobuf":
+		value, err = config_decoder.AppendProtoItem(nil, item)
+	case w.p.canonical:
+		value, err = config_decoder.AppendCanonicalJSON(nil, item)
+	default:
+		value, err = json.Marshal(item)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.written += int64(len(value))
+	rec := &kgo.Record{Topic: topic, Value: value}
+	if id, ok := item["resourceId"].(string); ok {
+		rec.Key = []byte(id)
+	} else if key, ok := item["partitionkey"].(string); ok {
+		// a CloudEvents record, whose partition key is its item's resource id
+		rec.Key = []byte(key)
+	}
+
+	w.client.Produce(context.Background(), rec, func(_ *kgo.Record, err error) {
+		if err != nil {
+			w.failed.Add(1)
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+	})
+	return nil
+}
+
+// Flush implements Flusher for Writer, waiting for produced records to be delivered
+func (w *Writer) Flush() error {
+	if err := w.client.Flush(context.Background()); err != nil {
+		return fmt.Errorf("kafka.Writer: %w", err)
+	}
+	if n := w.failed.Swap(0); n > 0 {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		err := w.err
+		w.err = nil
+		return fmt.Errorf("kafka.Writer: %d items not delivered, first: %w", n, err)
+	}
+	return nil
+}
+
+// Close implements io.Closer for Writer, closing its factory's client if no other Writer holds it
+// The Writer mustn't be used after it's closed.
+func (w *Writer) Close() error {
+	if !w.closed {
+		w.closed = true
+		w.p.release()
+	}
+	return nil
+}
+
+// BytesWritten implements ByteCounter for Writer
+func (w *Writer) BytesWritten() int64 {
+	return w.written
+}
+
+// Healthy implements HealthChecker for Writer
+func (w *Writer) Healthy() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return w.client.Ping(ctx)
+}
@@ -0,0 +1,75 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//boltBucket is the bucket holding ledger entries
+var boltBucket = []byte("entries")
+
+//BoltLedger is a Ledger kept in a bolt database
+// Puts are transactional, so the ledger survives crashes mid-write.
+type BoltLedger struct {
+	db *bolt.DB
+}
+
+//OpenBolt opens or creates the bolt database ledger at path
+func OpenBolt(path string) (*BoltLedger, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("OpenBolt: %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("OpenBolt: %w", err)
+	}
+	return &BoltLedger{db: db}, nil
+}
+
+// Get implements Ledger for BoltLedger
+func (bl *BoltLedger) Get(key string) (Entry, bool, error) {
+	var e Entry
+	var ok bool
+	err := bl.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &e)
+	})
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("BoltLedger: %w", err)
+	}
+	return e, ok, nil
+}
+
+// Put implements Ledger for BoltLedger
+func (bl *BoltLedger) Put(e Entry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	err = bl.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(e.Key), v)
+	})
+	if err != nil {
+		return fmt.Errorf("BoltLedger: %w", err)
+	}
+	return nil
+}
+
+// Close implements Ledger for BoltLedger
+func (bl *BoltLedger) Close() error {
+	return bl.db.Close()
+}
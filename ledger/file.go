@@ -0,0 +1,84 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//FileLedger is a Ledger kept in a small json file, rewritten atomically on each Put
+type FileLedger struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]Entry
+}
+
+//OpenFile opens the json file ledger at path, which need not exist yet
+func OpenFile(path string) (*FileLedger, error) {
+	fl := &FileLedger{path: path, entries: make(map[string]Entry)}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fl, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("OpenFile: %w", err)
+	}
+	if err := json.Unmarshal(b, &fl.entries); err != nil {
+		return nil, fmt.Errorf("OpenFile: %s: %w", path, err)
+	}
+	return fl, nil
+}
+
+// Get implements Ledger for FileLedger
+func (fl *FileLedger) Get(key string) (Entry, bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	e, ok := fl.entries[key]
+	return e, ok, nil
+}
+
+// Put implements Ledger for FileLedger
+func (fl *FileLedger) Put(e Entry) error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	fl.entries[e.Key] = e
+	return fl.save()
+}
+
+// Close implements Ledger for FileLedger
+func (fl *FileLedger) Close() error {
+	return nil
+}
+
+//save writes the ledger file atomically
+func (fl *FileLedger) save() error {
+	b, err := json.MarshalIndent(fl.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fl.path), ".ledger-*")
+	if err != nil {
+		return fmt.Errorf("FileLedger: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("FileLedger: %w", err)
+	}
+	// an entry must be on disk before the output it records is published
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("FileLedger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("FileLedger: %w", err)
+	}
+	return os.Rename(tmp.Name(), fl.path)
+}
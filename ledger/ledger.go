@@ -0,0 +1,84 @@
+//Package ledger records which input files have been decoded, so restarts and redeliveries
+//don't ingest their items twice
+package ledger
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+//State is the decoding state of an input file
+type State string
+
+const (
+	// Started files were being decoded; if still started on restart, decoding was interrupted
+	Started State = "started"
+	// Staged files were completely decoded to staged output, not yet published; if still staged on
+	// restart, the output is published without decoding the file again
+	Staged State = "staged"
+	// Done files were completely decoded
+	Done State = "done"
+	// Failed files stopped decoding with an error
+	Failed State = "failed"
+)
+
+//Entry records the decoding of one input file
+// Key identifies the file, e.g. its name or object key.
+// Fingerprint identifies the file's content, e.g. size and modification time, or an ETag;
+// a file whose fingerprint changes is decoded again.
+// Staged is the temporary output a file is being decoded to, and Output where it's published once
+// complete; both are empty unless the output is staged.
+type Entry struct {
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	State       State     `json:"state"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Items       int       `json:"items"`
+	Error       string    `json:"error,omitempty"`
+	Staged      string    `json:"staged,omitempty"`
+	Output      string    `json:"output,omitempty"`
+}
+
+//Ledger stores Entries by Key
+type Ledger interface {
+	// Get returns the entry for key, and whether there is one
+	Get(key string) (Entry, bool, error)
+	// Put stores e, replacing any entry with the same Key
+	Put(e Entry) error
+	Close() error
+}
+
+//IsDone reports whether the file with key and fingerprint has already been completely decoded
+func IsDone(l Ledger, key, fingerprint string) (bool, error) {
+	e, ok, err := l.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return e.State == Done && e.Fingerprint == fingerprint, nil
+}
+
+//Open opens the ledger at uri
+// Supported schemes are file:// for a json file and bolt:// for a bolt database,
+// e.g. bolt:///var/lib/chd/ledger.db. A bare path is a json file.
+func Open(uri string) (Ledger, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("ledger.Open: %w", err)
+	}
+
+	path := u.Path
+	if u.Host != "" {
+		// relative paths, as in file://state.json
+		path = u.Host + u.Path
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		return OpenFile(path)
+	case "bolt":
+		return OpenBolt(path)
+	default:
+		return nil, fmt.Errorf("ledger.Open: unsupported ledger %q", uri)
+	}
+}
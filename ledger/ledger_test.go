@@ -0,0 +1,118 @@
+package ledger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//backends open a ledger of each kind in a directory, by URI
+var backends = map[string]func(dir string) string{
+	"file": func(dir string) string { return "file://" + filepath.Join(dir, "state.json") },
+	"bolt": func(dir string) string { return "bolt://" + filepath.Join(dir, "ledger.db") },
+}
+
+func open(t *testing.T, uri string) Ledger {
+	l, err := Open(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+//TestMarkCommitReopen marks files started, commits them done, and reopens the ledger, as a restart does
+func TestMarkCommitReopen(t *testing.T) {
+	for name, uri := range backends {
+		t.Run(name, func(t *testing.T) {
+			uri := uri(t.TempDir())
+			now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+			l := open(t, uri)
+			if _, ok, err := l.Get("a.json"); ok || err != nil {
+				t.Fatalf("Get of an empty ledger: %t, %v", ok, err)
+			}
+			// a.json is decoded; b.json is interrupted, so left started
+			for _, key := range []string{"a.json", "b.json"} {
+				if err := l.Put(Entry{Key: key, Fingerprint: "v1", State: Started, UpdatedAt: now}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if done, _ := IsDone(l, "a.json", "v1"); done {
+				t.Error("a started file is done")
+			}
+			done := Entry{Key: "a.json", Fingerprint: "v1", State: Done, UpdatedAt: now, Items: 42, Output: "out/a.ndjson"}
+			if err := l.Put(done); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			l = open(t, uri)
+			defer l.Close()
+			e, ok, err := l.Get("a.json")
+			if err != nil || !ok || e != done {
+				t.Errorf("reopened, a.json is %+v, %t, %v; want %+v", e, ok, err, done)
+			}
+			if e, _, _ := l.Get("b.json"); e.State != Started {
+				t.Errorf("reopened, b.json is %s, want started", e.State)
+			}
+
+			tests := []struct {
+				key, fingerprint string
+				want             bool
+			}{
+				{"a.json", "v1", true},
+				// a file whose content changed is decoded again
+				{"a.json", "v2", false},
+				{"b.json", "v1", false},
+				{"c.json", "v1", false},
+			}
+			for _, tt := range tests {
+				if done, err := IsDone(l, tt.key, tt.fingerprint); done != tt.want || err != nil {
+					t.Errorf("IsDone(%s, %s) = %t, %v; want %t", tt.key, tt.fingerprint, done, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// a bare path, and a relative one in a URI, are json files
+	for _, uri := range []string{"bare.json", "file://relative.json"} {
+		l := open(t, uri)
+		if _, ok := l.(*FileLedger); !ok {
+			t.Errorf("%s opened a %T", uri, l)
+		}
+		if err := l.Put(Entry{Key: "k", State: Done}); err != nil {
+			t.Fatal(err)
+		}
+		l.Close()
+	}
+	for _, name := range []string{"bare.json", "relative.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := Open("redis://localhost/ledger"); err == nil {
+		t.Error("opened an unsupported ledger")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("corrupt.json"); err == nil {
+		t.Error("opened a corrupt ledger")
+	}
+}
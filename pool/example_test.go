@@ -0,0 +1,25 @@
+package pool_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/decode"
+	"github.com/mfrasier/decode_json_stream/pool"
+	"github.com/mfrasier/decode_json_stream/transform"
+	"github.com/mfrasier/decode_json_stream/writers"
+	"strings"
+)
+
+func ExampleNew() {
+	doc := `{"configSnapshotId": "s-1", "configurationItems": [{"resourceId": "i-1"}, {"resourceId": "i-2"}, {"resourceId": "i-3"}]}`
+	stats := pool.NewStats()
+	result, err := decode.Decode(context.Background(), strings.NewReader(doc), transform.Snapshot(), writers.Null(),
+		pool.New(2, pool.WithStats(stats), pool.StopOnError()))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(len(result.Workers), "workers wrote", result.Items(), "items with", result.Errors(), "errors")
+	// Output:
+	// 2 workers wrote 3 items with 0 errors
+}
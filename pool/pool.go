@@ -0,0 +1,211 @@
+//Package pool configures the pool of writers decoded items are written by, and reports on its workers
+// It's part of the decoder's stable API, with packages decode, transform and writers (see
+// decode.APIVersion). A Config is built with Options rather than by setting fields, so the pool can
+// gain settings without breaking importers.
+// Each worker writes the items it's given with its own writer, and reports once it's done in its
+// WorkerStatus: its items, bytes, errors and their sizes by resourceType. Items a circuit breaker held
+// while its writer was paused aren't counted in the sizes, nor listed among the largest.
+package pool
+
+import (
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/internal/apispec"
+	"time"
+)
+
+func init() {
+	apispec.PoolSpec = func(c any) config_decoder.PoolSpec {
+		return c.(Config).poolSpec()
+	}
+	apispec.WorkerStatus = func(s config_decoder.WorkerStatus) any {
+		return workerStatus(s)
+	}
+	apispec.WriteError = func(err *config_decoder.WriteError) error {
+		return &WriteError{Worker: err.Worker, Err: err.Err}
+	}
+}
+
+//WorkerStatus is the report of a worker once it has written its last item
+type WorkerStatus struct {
+	WorkerNum  int
+	ItemCount  int
+	ByteCount  int
+	StartTime  string
+	EndTime    string
+	Duration   time.Duration
+	ErrorCount int
+	// BreakerTrips counts how often the worker's circuit breaker opened
+	BreakerTrips int
+	Status       string
+	// ResourceTypes totals the worker's items by resourceType
+	ResourceTypes map[string]ResourceTypeBytes
+	// Largest lists the worker's WithLargest largest items, largest first
+	Largest []ItemSize
+}
+
+//ResourceTypeBytes totals a worker's items of a resourceType, in WorkerStatus.ResourceTypes
+// DecodedBytes is the size of the items in the uncompressed document, so it's 0 without provenance.
+// WrittenBytes is their size as encoded for the sink, if the writer is a writers.ByteCounter.
+type ResourceTypeBytes struct {
+	Items        int
+	DecodedBytes int64
+	WrittenBytes int64
+}
+
+//ItemSize is one of the largest items a worker wrote, in WorkerStatus.Largest
+type ItemSize struct {
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	ARN          string `json:"arn,omitempty"`
+	File         string `json:"file,omitempty"`
+	Bytes        int64  `json:"bytes"`
+}
+
+//workerStatus returns the WorkerStatus of s
+func workerStatus(s config_decoder.WorkerStatus) WorkerStatus {
+	status := WorkerStatus{WorkerNum: s.WorkerNum, ItemCount: s.ItemCount, ByteCount: s.ByteCount, StartTime: s.StartTime,
+		EndTime: s.EndTime, Duration: s.Duration, ErrorCount: s.ErrorCount, BreakerTrips: s.BreakerTrips, Status: s.Status}
+	if s.ResourceTypes != nil {
+		status.ResourceTypes = make(map[string]ResourceTypeBytes, len(s.ResourceTypes))
+		for t, b := range s.ResourceTypes {
+			status.ResourceTypes[t] = ResourceTypeBytes{Items: b.Items, DecodedBytes: b.DecodedBytes, WrittenBytes: b.WrittenBytes}
+		}
+	}
+	for _, size := range s.Largest {
+		status.Largest = append(status.Largest, ItemSize{ResourceType: size.ResourceType, ResourceID: size.ResourceID, ARN: size.ARN,
+			File: size.File, Bytes: size.Bytes})
+	}
+	return status
+}
+
+//WriteError is a write error that stopped decoding, with StopOnError, or the error of a writer that
+// couldn't be created; it wraps decode.ErrWriterFailed as well as Err
+type WriteError struct {
+	Worker int
+	Err    error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("writer (%d): %s", e.Worker, e.Err)
+}
+
+func (e *WriteError) Unwrap() []error {
+	return []error{config_decoder.ErrWriterFailed, e.Err}
+}
+
+//Stats are the live counters of a pool's workers, for reporting throughput while decoding runs
+type Stats struct {
+	stats *config_decoder.PoolStats
+}
+
+//NewStats creates Stats with no workers, to be given to pools with WithStats
+func NewStats() *Stats {
+	return &Stats{stats: config_decoder.NewPoolStats()}
+}
+
+//Snapshot returns the status of each worker so far, in order of worker number
+// Duration is the time the worker has been running, over every pool, so ItemCount / Duration is its
+// throughput. Status is "busy" while it's writing an item, "waiting" for one, or "idle" when none
+// of the pools it's in is running. BreakerTrips are counted once a worker ends, and ResourceTypes
+// aren't totalled. Times are told by the clock of the pool a worker is running in (see WithClock).
+func (s *Stats) Snapshot() []WorkerStatus {
+	var snapshot []WorkerStatus
+	for _, status := range s.stats.Snapshot() {
+		snapshot = append(snapshot, workerStatus(status))
+	}
+	return snapshot
+}
+
+//Config configures a writer pool; the zero value is a pool of one writer
+type Config struct {
+	spec config_decoder.PoolSpec
+}
+
+//Option sets an optional setting of a Config
+type Option func(*Config)
+
+//New returns the Config of a pool of size writers, at least one, with opts
+func New(size int, opts ...Option) Config {
+	c := Config{spec: config_decoder.PoolSpec{Size: max(size, 1)}}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+//Size returns the number of writers in the pool
+func (c Config) Size() int {
+	return max(c.spec.Size, 1)
+}
+
+//poolSpec returns the config_decoder.PoolSpec the pool is created with
+func (c Config) poolSpec() config_decoder.PoolSpec {
+	spec := c.spec
+	spec.Size = c.Size()
+	return spec
+}
+
+//StopOnError stops decoding at the first write error, which ends it as a WriteError; otherwise
+// write errors are counted in each WorkerStatus and decoding continues
+func StopOnError() Option {
+	return func(c *Config) {
+		c.spec.StopOnError = true
+	}
+}
+
+//ReuseItems releases each item for reuse once written, so writers must not retain items after Write returns
+func ReuseItems() Option {
+	return func(c *Config) {
+		c.spec.ReuseItems = true
+	}
+}
+
+//Breaker configures the circuit breaker guarding each writer
+// Threshold is the number of consecutive write failures that pauses the writer. While it's paused its
+// health is probed every Cooldown, a second if 0, and up to BufferSize items are held in memory; those
+// beyond are spilled to a file in SpillDir, if set, or else the writer stops receiving items until its
+// sink recovers. Once its items end, the writer waits up to DrainTimeout, 0 for three Cooldowns, for
+// its sink to recover, then fails the items still held, which are kept in the spill file if there's
+// a SpillDir.
+type Breaker struct {
+	Threshold    int
+	Cooldown     time.Duration
+	BufferSize   int
+	SpillDir     string
+	DrainTimeout time.Duration
+}
+
+//WithBreaker guards each writer with the circuit breaker b; a Threshold of 0 disables it
+func WithBreaker(b Breaker) Option {
+	return func(c *Config) {
+		c.spec.Breaker = config_decoder.BreakerConfig{Threshold: b.Threshold, Cooldown: b.Cooldown, BufferSize: b.BufferSize,
+			SpillDir: b.SpillDir, DrainTimeout: b.DrainTimeout}
+	}
+}
+
+//WithStats updates stats as the workers write, so throughput can be reported live; nil updates none
+func WithStats(stats *Stats) Option {
+	return func(c *Config) {
+		c.spec.Stats = nil
+		if stats != nil {
+			c.spec.Stats = stats.stats
+		}
+	}
+}
+
+//WithClock tells the times of each WorkerStatus with now rather than the system clock, as well as the
+// time Stats measures the pool's workers running for, and the cooldowns of their circuit breakers
+func WithClock(now func() time.Time) Option {
+	return func(c *Config) {
+		c.spec.Clock = now
+	}
+}
+
+//WithLargest lists the n largest items of each worker in its WorkerStatus
+// Items are sized as written if the writer counts its bytes, and otherwise as marshalled to json.
+func WithLargest(n int) Option {
+	return func(c *Config) {
+		c.spec.Largest = n
+	}
+}
@@ -0,0 +1,53 @@
+package pool
+
+import (
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	if size := (Config{}).Size(); size != 1 {
+		t.Errorf("zero Config has %d writers", size)
+	}
+	if spec := (Config{}).poolSpec(); spec.Size != 1 {
+		t.Errorf("zero Config's poolSpec has %d writers", spec.Size)
+	}
+	if size := New(-3).Size(); size != 1 {
+		t.Errorf("New(-3) has %d writers", size)
+	}
+
+	stats := NewStats()
+	now := func() time.Time { return time.Unix(0, 0) }
+	breaker := Breaker{Threshold: 5, Cooldown: time.Second, BufferSize: 100, SpillDir: "/spill", DrainTimeout: time.Minute}
+	spec := New(4, StopOnError(), ReuseItems(), WithBreaker(breaker), WithStats(stats), WithClock(now), WithLargest(3)).poolSpec()
+	if spec.Size != 4 || !spec.StopOnError || !spec.ReuseItems || spec.Stats != stats.stats || spec.Clock == nil || spec.Largest != 3 {
+		t.Errorf("options not all applied: %+v", spec)
+	}
+	if b := spec.Breaker; b.Threshold != 5 || b.Cooldown != time.Second || b.BufferSize != 100 || b.SpillDir != "/spill" ||
+		b.DrainTimeout != time.Minute {
+		t.Errorf("breaker %+v", b)
+	}
+}
+
+func TestWorkerStatus(t *testing.T) {
+	s := workerStatus(config_decoder.WorkerStatus{WorkerNum: 2, ItemCount: 3, ErrorCount: 1, BreakerTrips: 1, Status: "ended normally",
+		ResourceTypes: map[string]config_decoder.ResourceTypeBytes{"AWS::S3::Bucket": {Items: 3, DecodedBytes: 300, WrittenBytes: 290}},
+		Largest:       []config_decoder.ItemSize{{ResourceType: "AWS::S3::Bucket", ResourceID: "b-1", Bytes: 120}}})
+	if s.WorkerNum != 2 || s.ItemCount != 3 || s.ErrorCount != 1 || s.BreakerTrips != 1 || s.Status != "ended normally" {
+		t.Errorf("status %+v", s)
+	}
+	if b := s.ResourceTypes["AWS::S3::Bucket"]; len(s.ResourceTypes) != 1 || b.Items != 3 || b.DecodedBytes != 300 || b.WrittenBytes != 290 {
+		t.Errorf("resource types %v", s.ResourceTypes)
+	}
+	if len(s.Largest) != 1 || s.Largest[0].ResourceID != "b-1" || s.Largest[0].Bytes != 120 {
+		t.Errorf("largest %v", s.Largest)
+	}
+
+	if snapshot := NewStats().Snapshot(); len(snapshot) != 0 {
+		t.Errorf("new Stats has workers %v", snapshot)
+	}
+	if spec := New(1, WithStats(nil)).poolSpec(); spec.Stats != nil {
+		t.Errorf("WithStats(nil) updates %v", spec.Stats)
+	}
+}
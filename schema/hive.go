@@ -0,0 +1,99 @@
+package schema
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//hiveName matches names usable as Hive struct field names and Athena column names
+var hiveName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+//Column is a top-level column of a table
+// Key is the item field the column is read from, which differs from Name
+// when the field's name isn't a valid column name.
+type Column struct {
+	Name string
+	Key  string
+	Type string
+}
+
+//HiveOptions limit the types rendered by Hive
+// Objects with more than MaxFields fields, typically the configuration of many resource types
+// merged together, are rendered as string, which json serdes fill with the object's json text.
+type HiveOptions struct {
+	MaxFields int
+}
+
+//Columns renders the fields of t, an Object, as Hive columns sorted by name
+func (t *Type) Columns(opts HiveOptions) []Column {
+	keys := sortedKeys(t.Fields)
+	cols := make([]Column, 0, len(keys))
+	used := make(map[string]bool)
+	for _, k := range keys {
+		name := columnName(k)
+		for used[name] {
+			name += "_"
+		}
+		used[name] = true
+		cols = append(cols, Column{Name: name, Key: k, Type: t.Fields[k].Hive(opts)})
+	}
+	return cols
+}
+
+//Hive renders t as a Hive column type
+// Objects whose field names aren't valid, or collide ignoring case, are rendered as string.
+func (t *Type) Hive(opts HiveOptions) string {
+	switch t.Kind {
+	case Bool:
+		return "boolean"
+	case Int:
+		return "bigint"
+	case Float:
+		return "double"
+	case Array:
+		return "array<" + t.Elem.Hive(opts) + ">"
+	case Object:
+		if len(t.Fields) == 0 || (opts.MaxFields > 0 && len(t.Fields) > opts.MaxFields) {
+			return "string"
+		}
+		keys := sortedKeys(t.Fields)
+		fields := make([]string, len(keys))
+		seen := make(map[string]bool)
+		for i, k := range keys {
+			lk := strings.ToLower(k)
+			if !hiveName.MatchString(lk) || seen[lk] {
+				return "string"
+			}
+			seen[lk] = true
+			fields[i] = lk + ":" + t.Fields[k].Hive(opts)
+		}
+		return "struct<" + strings.Join(fields, ",") + ">"
+	default:
+		return "string"
+	}
+}
+
+//columnName makes a valid column name from item field name k
+func columnName(k string) string {
+	b := []byte(strings.ToLower(k))
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	name := string(b)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func sortedKeys(m map[string]*Type) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+//Package schema infers the structure of decoded items from a sample of them
+// The inferred Type renders as column types for table definitions.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//Kind is the kind of json value a Type describes
+type Kind int
+
+const (
+	Null Kind = iota
+	Bool
+	Int
+	Float
+	String
+	Array
+	Object
+)
+
+//Type is the inferred type of a json value
+// Elem is the type of the elements of an Array, Fields the types of the fields of an Object.
+type Type struct {
+	Kind   Kind
+	Elem   *Type
+	Fields map[string]*Type
+}
+
+//Add widens t to also describe v, a value decoded with json.Decoder.UseNumber
+// Values of conflicting kinds widen to String, except Int and Float, which widen to Float.
+func (t *Type) Add(v any) {
+	switch v := v.(type) {
+	case nil:
+		return
+	case bool:
+		t.widen(Bool)
+	case json.Number:
+		if strings.ContainsAny(string(v), ".eE") {
+			t.widen(Float)
+		} else {
+			t.widen(Int)
+		}
+	case float64:
+		t.widen(Float)
+	case string:
+		t.widen(String)
+	case []any:
+		if t.widen(Array) {
+			if t.Elem == nil {
+				t.Elem = &Type{}
+			}
+			for _, e := range v {
+				t.Elem.Add(e)
+			}
+		}
+	case map[string]any:
+		if t.widen(Object) {
+			if t.Fields == nil {
+				t.Fields = make(map[string]*Type)
+			}
+			for k, fv := range v {
+				ft, ok := t.Fields[k]
+				if !ok {
+					ft = &Type{}
+					t.Fields[k] = ft
+				}
+				ft.Add(fv)
+			}
+		}
+	default:
+		t.widen(String)
+	}
+}
+
+//widen makes t describe values of kind k too, reporting whether t is still of kind k
+func (t *Type) widen(k Kind) bool {
+	switch {
+	case t.Kind == k:
+	case t.Kind == Null:
+		t.Kind = k
+	case t.Kind == Int && k == Float:
+		t.Kind = Float
+	case t.Kind == Float && k == Int:
+	default:
+		t.Kind, t.Elem, t.Fields = String, nil, nil
+	}
+	return t.Kind == k
+}
+
+//Infer reads up to limit newline-delimited json objects from r, returning their combined Type
+// A limit of 0 reads all of r.
+func Infer(r io.Reader, limit int) (*Type, int, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	t := &Type{}
+	n := 0
+	for limit == 0 || n < limit {
+		var v map[string]any
+		if err := dec.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, n, fmt.Errorf("Infer: item %d: %w", n+1, err)
+		}
+		t.Add(v)
+		n++
+	}
+
+	if t.Kind != Object {
+		return nil, n, fmt.Errorf("Infer: no items in sample")
+	}
+	return t, n, nil
+}
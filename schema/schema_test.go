@@ -0,0 +1,124 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		limit  int
+		want   map[string]string
+		n      int
+	}{
+		{
+			name:   "kinds",
+			sample: `{"b":true,"i":1,"f":1.5,"e":1e3,"s":"x","n":null,"a":[1,2],"o":{"k":"v"}}`,
+			want: map[string]string{"b": "boolean", "i": "bigint", "f": "double", "e": "double", "s": "string",
+				"n": "string", "a": "array<bigint>", "o": "struct<k:string>"},
+			n: 1,
+		},
+		{
+			name:   "int widens to float",
+			sample: "{\"v\":1}\n{\"v\":2.5}\n{\"v\":3}",
+			want:   map[string]string{"v": "double"},
+			n:      3,
+		},
+		{
+			name:   "conflicts widen to string",
+			sample: "{\"v\":1,\"w\":true,\"x\":[1]}\n{\"v\":\"one\",\"w\":{\"k\":1},\"x\":{\"k\":1}}",
+			want:   map[string]string{"v": "string", "w": "string", "x": "string"},
+			n:      2,
+		},
+		{
+			name:   "null takes the kind of other values",
+			sample: "{\"v\":null}\n{\"v\":true}\n{\"v\":null}",
+			want:   map[string]string{"v": "boolean"},
+			n:      3,
+		},
+		{
+			name:   "objects merge their fields",
+			sample: "{\"o\":{\"a\":1}}\n{\"o\":{\"b\":\"x\"}}\n{\"p\":[{\"a\":1},{\"a\":1.5,\"b\":[]}]}",
+			want:   map[string]string{"o": "struct<a:bigint,b:string>", "p": "array<struct<a:double,b:array<string>>>"},
+			n:      3,
+		},
+		{
+			name:   "limit",
+			sample: "{\"v\":1}\n{\"v\":\"x\"}",
+			limit:  1,
+			want:   map[string]string{"v": "bigint"},
+			n:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, n, err := Infer(strings.NewReader(tt.sample), tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.n {
+				t.Errorf("read %d items, want %d", n, tt.n)
+			}
+			if len(typ.Fields) != len(tt.want) {
+				t.Errorf("%d fields, want %d", len(typ.Fields), len(tt.want))
+			}
+			for k, want := range tt.want {
+				ft, ok := typ.Fields[k]
+				if !ok {
+					t.Errorf("no field %q", k)
+					continue
+				}
+				if got := ft.Hive(HiveOptions{}); got != want {
+					t.Errorf("%s: %s, want %s", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInferErrors(t *testing.T) {
+	for _, sample := range []string{"", "  \n", `{"v":1}` + "\n" + `{"v":`, `[1,2]`} {
+		if _, _, err := Infer(strings.NewReader(sample), 0); err == nil {
+			t.Errorf("%q: no error", sample)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	typ, _, err := Infer(strings.NewReader(
+		`{"resourceType":"t","ARN":"a","arn":"b","1st":1,"tag-set":{"Name":"x","name":"y"},"big":{"a":1,"b":2,"c":3},` +
+			`"odd":{"not valid":1},"empty":{}}`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := typ.Columns(HiveOptions{MaxFields: 2})
+	want := []Column{
+		{Name: "_1st", Key: "1st", Type: "bigint"},
+		// names colliding once lowercased are told apart by a trailing _
+		{Name: "arn", Key: "ARN", Type: "string"},
+		{Name: "arn_", Key: "arn", Type: "string"},
+		// too many fields
+		{Name: "big", Key: "big", Type: "string"},
+		{Name: "empty", Key: "empty", Type: "string"},
+		// field names that aren't valid struct fields
+		{Name: "odd", Key: "odd", Type: "string"},
+		{Name: "resourcetype", Key: "resourceType", Type: "string"},
+		// struct fields colliding once lowercased
+		{Name: "tag_set", Key: "tag-set", Type: "string"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("columns %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("column %d: %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got := typ.Fields["big"].Hive(HiveOptions{}); got != "struct<a:bigint,b:bigint,c:bigint>" {
+		t.Errorf("unlimited big: %s", got)
+	}
+}
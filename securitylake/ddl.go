@@ -0,0 +1,78 @@
+package securitylake
+
+import (
+	"github.com/mfrasier/decode_json_stream/schema"
+	"reflect"
+	"strings"
+)
+
+//PartitionKeys are the partition columns of a custom source, read from the objects' keys
+var PartitionKeys = []schema.Column{
+	{Name: "region", Key: "region", Type: "string"},
+	{Name: "accountid", Key: "accountId", Type: "string"},
+	{Name: "eventday", Key: "eventDay", Type: "string"},
+}
+
+//PartitionTemplate is the key prefix of a partition's objects, under the custom source's location, with
+// the partition columns as ${column}, as Athena partition projection takes it
+const PartitionTemplate = "region=${region}/accountId=${accountid}/eventDay=${eventday}/"
+
+//path returns the key prefix of the partition's objects
+func (p partition) path() string {
+	return "region=" + p.region + "/accountId=" + p.account + "/eventDay=" + p.day + "/"
+}
+
+//Columns returns the Hive columns of the events a Writer writes, as their parquet tags define them
+func Columns() []schema.Column {
+	t := reflect.TypeOf(Event{})
+	cols := make([]schema.Column, t.NumField())
+	for i := range cols {
+		f := t.Field(i)
+		name := parquetTag(f)["name"]
+		cols[i] = schema.Column{Name: name, Key: name, Type: hiveType(f)}
+	}
+	return cols
+}
+
+//parquetTag returns the settings of the parquet tag of f
+func parquetTag(f reflect.StructField) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range strings.Split(f.Tag.Get("parquet"), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		m[strings.ToLower(k)] = v
+	}
+	return m
+}
+
+//hiveType returns the Hive type of the parquet column of f
+func hiveType(f reflect.StructField) string {
+	tag := parquetTag(f)
+	t := f.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Slice:
+		return "array<" + hiveStruct(t.Elem()) + ">"
+	case t.Kind() == reflect.Struct:
+		return hiveStruct(t)
+	case tag["convertedtype"] == "TIMESTAMP_MILLIS":
+		return "timestamp"
+	case tag["type"] == "INT32":
+		return "int"
+	case tag["type"] == "INT64":
+		return "bigint"
+	default:
+		return "string"
+	}
+}
+
+//hiveStruct returns the Hive struct type of the parquet group of struct t
+func hiveStruct(t reflect.Type) string {
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		f := t.Field(i)
+		fields[i] = parquetTag(f)["name"] + ":" + hiveType(f)
+	}
+	return "struct<" + strings.Join(fields, ",") + ">"
+}
@@ -0,0 +1,57 @@
+package securitylake
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestColumns(t *testing.T) {
+	want := map[string]string{
+		"activity_id": "int",
+		"type_uid":    "bigint",
+		"time":        "timestamp",
+		"severity":    "string",
+		"metadata":    "struct<version:string,product:struct<name:string,vendor_name:string>,uid:string>",
+		"cloud":       "struct<provider:string,region:string,account:struct<uid:string>>",
+		"resources":   "array<struct<uid:string,name:string,type:string,region:string,data:string>>",
+		"unmapped":    "string",
+	}
+
+	// the columns are the events' fields, which are named alike in json and parquet
+	b, err := json.Marshal(Event{Unmapped: new(string)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	cols := Columns()
+	if len(cols) != len(fields) {
+		t.Errorf("%d columns, want %d", len(cols), len(fields))
+	}
+	for _, c := range cols {
+		if _, ok := fields[c.Name]; !ok {
+			t.Errorf("column %s isn't an event field", c.Name)
+		}
+		if w, ok := want[c.Name]; ok && c.Type != w {
+			t.Errorf("%s: %s, want %s", c.Name, c.Type, w)
+		}
+	}
+}
+
+func TestPartitionTemplate(t *testing.T) {
+	p := partition{region: "eu-west-1", account: "111122223333", day: "20240229"}
+	path := strings.NewReplacer("${region}", p.region, "${accountid}", p.account, "${eventday}", p.day).
+		Replace(PartitionTemplate)
+	if path != p.path() {
+		t.Errorf("template expands to %s, objects are under %s", path, p.path())
+	}
+	for _, k := range PartitionKeys {
+		if !strings.Contains(PartitionTemplate, k.Key+"=${"+k.Name+"}") {
+			t.Errorf("partition key %s isn't in the template", k.Name)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+//Package securitylake writes configuration items to an Amazon Security Lake custom source, as OCSF
+// Cloud Resources Inventory Info events in Parquet files, partitioned as Security Lake requires.
+package securitylake
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+//OCSF classification of configuration items: Cloud Resources Inventory Info events of the Discovery
+// category, whose activity is Collect, of informational severity
+const (
+	OCSFVersion  = "1.3.0"
+	ClassUID     = 5023
+	ClassName    = "Cloud Resources Inventory Info"
+	CategoryUID  = 5
+	CategoryName = "Discovery"
+	ActivityID   = 2
+	ActivityName = "Collect"
+	TypeUID      = ClassUID*100 + ActivityID
+	TypeName     = ClassName + ": " + ActivityName
+	SeverityID   = 1
+	SeverityName = "Informational"
+)
+
+//Product is the OCSF product reporting the events
+type Product struct {
+	Name       string `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	VendorName string `json:"vendor_name" parquet:"name=vendor_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+//Metadata is the OCSF metadata of an event
+// UID is the item's configurationStateId, if it has one.
+type Metadata struct {
+	Version string  `json:"version" parquet:"name=version, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Product Product `json:"product" parquet:"name=product"`
+	UID     *string `json:"uid,omitempty" parquet:"name=uid, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+//Account is an OCSF cloud account
+type Account struct {
+	UID string `json:"uid" parquet:"name=uid, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+//Cloud is the OCSF cloud of an event
+type Cloud struct {
+	Provider string  `json:"provider" parquet:"name=provider, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Region   string  `json:"region" parquet:"name=region, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Account  Account `json:"account" parquet:"name=account"`
+}
+
+//Resource is the OCSF resource details of a configuration item
+// UID is its ARN, or else its resource id; Data is its configuration, as json text.
+type Resource struct {
+	UID    string  `json:"uid" parquet:"name=uid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name   *string `json:"name,omitempty" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Type   string  `json:"type" parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Region string  `json:"region" parquet:"name=region, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Data   *string `json:"data,omitempty" parquet:"name=data, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+//Event is the OCSF event of a configuration item
+// Time is the item's capture time in milliseconds since the epoch. Unmapped holds, as json text, the
+// item's fields not otherwise mapped, such as its status, tags and relationships.
+type Event struct {
+	ActivityID   int32      `json:"activity_id" parquet:"name=activity_id, type=INT32"`
+	ActivityName string     `json:"activity_name" parquet:"name=activity_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CategoryUID  int32      `json:"category_uid" parquet:"name=category_uid, type=INT32"`
+	CategoryName string     `json:"category_name" parquet:"name=category_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ClassUID     int32      `json:"class_uid" parquet:"name=class_uid, type=INT32"`
+	ClassName    string     `json:"class_name" parquet:"name=class_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TypeUID      int64      `json:"type_uid" parquet:"name=type_uid, type=INT64"`
+	TypeName     string     `json:"type_name" parquet:"name=type_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SeverityID   int32      `json:"severity_id" parquet:"name=severity_id, type=INT32"`
+	Severity     string     `json:"severity" parquet:"name=severity, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Time         int64      `json:"time" parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Metadata     Metadata   `json:"metadata" parquet:"name=metadata"`
+	Cloud        Cloud      `json:"cloud" parquet:"name=cloud"`
+	Resources    []Resource `json:"resources" parquet:"name=resources, type=LIST"`
+	Unmapped     *string    `json:"unmapped,omitempty" parquet:"name=unmapped, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+//mappedFields are the item fields an Event carries in its own fields, rather than in Unmapped
+var mappedFields = map[string]bool{
+	"ARN": true, "arn": true, "resourceId": true, "resourceName": true, "resourceType": true, "awsRegion": true,
+	"awsAccountId": true, "configuration": true, "configurationItemCaptureTime": true, "configurationStateId": true,
+}
+
+//ToOCSF maps a configuration item to its OCSF event
+// The item must have a resourceType, a resourceId and a configurationItemCaptureTime.
+func ToOCSF(item map[string]any) (Event, error) {
+	t, _ := item["resourceType"].(string)
+	id, _ := item["resourceId"].(string)
+	captured, _ := item["configurationItemCaptureTime"].(string)
+	if t == "" || id == "" {
+		return Event{}, fmt.Errorf("ToOCSF: item has no resourceType and resourceId")
+	}
+	captureTime, err := time.Parse(time.RFC3339, captured)
+	if err != nil {
+		return Event{}, fmt.Errorf("ToOCSF: %s %s: configurationItemCaptureTime: %w", t, id, err)
+	}
+
+	region, _ := item["awsRegion"].(string)
+	account, _ := item["awsAccountId"].(string)
+	resource := Resource{UID: id, Type: t, Region: region}
+	for _, k := range []string{"ARN", "arn"} {
+		if arn, _ := item[k].(string); arn != "" {
+			resource.UID = arn
+		}
+	}
+	if name, _ := item["resourceName"].(string); name != "" {
+		resource.Name = &name
+	}
+	if configuration, ok := item["configuration"]; ok && configuration != nil {
+		if resource.Data, err = jsonText(configuration); err != nil {
+			return Event{}, fmt.Errorf("ToOCSF: %s %s: configuration: %w", t, id, err)
+		}
+	}
+
+	e := Event{
+		ActivityID: ActivityID, ActivityName: ActivityName,
+		CategoryUID: CategoryUID, CategoryName: CategoryName,
+		ClassUID: ClassUID, ClassName: ClassName,
+		TypeUID: TypeUID, TypeName: TypeName,
+		SeverityID: SeverityID, Severity: SeverityName,
+		Time:      captureTime.UnixMilli(),
+		Metadata:  Metadata{Version: OCSFVersion, Product: Product{Name: "Config", VendorName: "AWS"}},
+		Cloud:     Cloud{Provider: "AWS", Region: region, Account: Account{UID: account}},
+		Resources: []Resource{resource},
+	}
+	var state string
+	switch v := item["configurationStateId"].(type) {
+	case string:
+		state = v
+	case float64:
+		state = fmt.Sprint(int64(v))
+	case json.Number:
+		state = v.String()
+	}
+	if state != "" {
+		e.Metadata.UID = &state
+	}
+
+	unmapped := make(map[string]any)
+	for k, v := range item {
+		if !mappedFields[k] {
+			unmapped[k] = v
+		}
+	}
+	if len(unmapped) > 0 {
+		if e.Unmapped, err = jsonText(unmapped); err != nil {
+			return Event{}, fmt.Errorf("ToOCSF: %s %s: %w", t, id, err)
+		}
+	}
+	return e, nil
+}
+
+//jsonText returns v encoded as json
+func jsonText(v any) (*string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
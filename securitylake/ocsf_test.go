@@ -0,0 +1,115 @@
+package securitylake
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToOCSF(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name     string
+		item     map[string]any
+		resource Resource
+		time     int64
+		account  string
+		state    *string
+		unmapped *string
+	}{
+		{
+			name: "complete",
+			item: map[string]any{
+				"resourceType": "AWS::S3::Bucket", "resourceId": "logs", "resourceName": "logs",
+				"ARN": "arn:aws:s3:::logs", "awsRegion": "eu-west-1", "awsAccountId": "111122223333",
+				"configurationItemCaptureTime": "2024-02-29T12:00:00.500Z", "configurationStateId": float64(1709208000500),
+				"configuration": map[string]any{"name": "logs"}, "tags": map[string]any{"team": "sec"},
+			},
+			resource: Resource{UID: "arn:aws:s3:::logs", Name: str("logs"), Type: "AWS::S3::Bucket", Region: "eu-west-1",
+				Data: str(`{"name":"logs"}`)},
+			time:     1709208000500,
+			account:  "111122223333",
+			state:    str("1709208000500"),
+			unmapped: str(`{"tags":{"team":"sec"}}`),
+		},
+		{
+			name: "lowercase arn and string state",
+			item: map[string]any{
+				"resourceType": "AWS::IAM::Role", "resourceId": "AROA1", "arn": "arn:aws:iam::111122223333:role/r",
+				"configurationItemCaptureTime": "2024-01-01T00:00:00Z", "configurationStateId": "42",
+			},
+			resource: Resource{UID: "arn:aws:iam::111122223333:role/r", Type: "AWS::IAM::Role"},
+			time:     1704067200000,
+			state:    str("42"),
+		},
+		{
+			name: "number state, no arn",
+			item: map[string]any{
+				"resourceType": "AWS::EC2::Volume", "resourceId": "vol-1", "awsRegion": "us-east-1",
+				"configurationItemCaptureTime": "2024-01-01T00:00:00+01:00", "configurationStateId": json.Number("7"),
+				"configuration": nil, "configurationItemStatus": "OK",
+			},
+			resource: Resource{UID: "vol-1", Type: "AWS::EC2::Volume", Region: "us-east-1"},
+			time:     1704063600000,
+			state:    str("7"),
+			unmapped: str(`{"configurationItemStatus":"OK"}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ToOCSF(tt.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.ClassUID != ClassUID || e.TypeUID != 502302 || e.Metadata.Version != OCSFVersion || e.Cloud.Provider != "AWS" {
+				t.Errorf("classification %+v", e)
+			}
+			if e.Time != tt.time {
+				t.Errorf("time %d, want %d", e.Time, tt.time)
+			}
+			if e.Cloud.Account.UID != tt.account || e.Cloud.Region != tt.resource.Region {
+				t.Errorf("cloud %+v", e.Cloud)
+			}
+			if len(e.Resources) != 1 {
+				t.Fatalf("%d resources", len(e.Resources))
+			}
+			if got := e.Resources[0]; !sameResource(got, tt.resource) {
+				t.Errorf("resource %s, want %s", show(got), show(tt.resource))
+			}
+			if deref(e.Metadata.UID) != deref(tt.state) {
+				t.Errorf("metadata uid %s, want %s", deref(e.Metadata.UID), deref(tt.state))
+			}
+			if deref(e.Unmapped) != deref(tt.unmapped) {
+				t.Errorf("unmapped %s, want %s", deref(e.Unmapped), deref(tt.unmapped))
+			}
+		})
+	}
+}
+
+func TestToOCSFErrors(t *testing.T) {
+	for _, item := range []map[string]any{
+		{"resourceId": "vol-1", "configurationItemCaptureTime": "2024-01-01T00:00:00Z"},
+		{"resourceType": "AWS::EC2::Volume", "configurationItemCaptureTime": "2024-01-01T00:00:00Z"},
+		{"resourceType": "AWS::EC2::Volume", "resourceId": "vol-1"},
+		{"resourceType": "AWS::EC2::Volume", "resourceId": "vol-1", "configurationItemCaptureTime": "yesterday"},
+	} {
+		if _, err := ToOCSF(item); err == nil {
+			t.Errorf("%v: no error", item)
+		}
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
+
+func sameResource(a, b Resource) bool {
+	return a.UID == b.UID && a.Type == b.Type && a.Region == b.Region && deref(a.Name) == deref(b.Name) && deref(a.Data) == deref(b.Data)
+}
+
+func show(r Resource) string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
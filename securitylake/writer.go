@@ -0,0 +1,167 @@
+package securitylake
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"strings"
+	"time"
+)
+
+//DefaultFileSize is the size objects are rolled over at by default, within the range Security Lake
+// recommends for query performance
+const DefaultFileSize = 256 << 20
+
+//maxRowGroupSize is the most item data buffered before it's encoded as a row group, bounding the
+// memory of each open file
+const maxRowGroupSize = 64 << 20
+
+//Config configures Writers
+// Bucket and Prefix are the custom source's location, e.g. aws-security-data-lake-us-east-1-abc and
+// ext/config-history/1.0/, under which objects are partitioned by region, account and event day.
+// FileSize is the size objects are rolled over at, DefaultFileSize if 0.
+// RunID names the objects, with the writer's worker and a sequence number, so runs don't overwrite
+// each other's.
+// Put stores an object, e.g. S3Client.PutObject.
+type Config struct {
+	Bucket   string
+	Prefix   string
+	FileSize int64
+	RunID    string
+	Put      func(ctx context.Context, bucket, key string, body []byte) error
+}
+
+//partition is a Security Lake partition of a custom source
+type partition struct {
+	region, account, day string
+}
+
+//parquetFile is an object being written, encoded in memory
+type parquetFile struct {
+	buf bytes.Buffer
+	pw  *writer.ParquetWriter
+}
+
+//object is an encoded object waiting to be stored
+type object struct {
+	key  string
+	body []byte
+}
+
+//Writer is an ItemWriter writing items as OCSF events to Parquet objects in a Security Lake custom
+// source, one open object for each partition written to
+// Objects are compressed with zstd, and stored once they reach the FileSize, or by Flush, which must be
+// called after the last Write; the writer pool does so when its items channel is closed. An object
+// that couldn't be stored is kept, and stored with the next, or by the next Flush, which reports the
+// error.
+type Writer struct {
+	cfg     Config
+	ctx     context.Context
+	worker  int
+	seq     int
+	files   map[partition]*parquetFile
+	pending []object
+	// written counts the bytes of the objects stored
+	written int64
+}
+
+//WriterFactory creates Writers for a Security Lake custom source
+func WriterFactory(cfg Config) (config_decoder.WriterFactory, error) {
+	if cfg.Bucket == "" || cfg.Put == nil {
+		return nil, fmt.Errorf("WriterFactory: Bucket and Put are required")
+	}
+	if cfg.FileSize <= 0 {
+		cfg.FileSize = DefaultFileSize
+	}
+	if cfg.Prefix != "" && !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+	if cfg.RunID == "" {
+		cfg.RunID = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	return func(ctx context.Context, worker int) (config_decoder.ItemWriter, error) {
+		return &Writer{cfg: cfg, ctx: ctx, worker: worker, files: make(map[partition]*parquetFile)}, nil
+	}, nil
+}
+
+// Write implements ItemWriter for Writer
+func (w *Writer) Write(item map[string]interface{}) error {
+	e, err := ToOCSF(item)
+	if err != nil {
+		return fmt.Errorf("Writer: %w", err)
+	}
+	if e.Cloud.Region == "" || e.Cloud.Account.UID == "" {
+		return fmt.Errorf("Writer: %s: Security Lake partitions need an awsRegion and awsAccountId", e.Resources[0].UID)
+	}
+	p := partition{region: e.Cloud.Region, account: e.Cloud.Account.UID,
+		day: time.UnixMilli(e.Time).UTC().Format("20060102")}
+
+	f, ok := w.files[p]
+	if !ok {
+		f = &parquetFile{}
+		if f.pw, err = writer.NewParquetWriterFromWriter(&f.buf, new(Event), 1); err != nil {
+			return fmt.Errorf("Writer: %w", err)
+		}
+		f.pw.CompressionType = parquet.CompressionCodec_ZSTD
+		f.pw.RowGroupSize = min(w.cfg.FileSize, maxRowGroupSize)
+		w.files[p] = f
+	}
+	if err := f.pw.Write(e); err != nil {
+		return fmt.Errorf("Writer: %w", err)
+	}
+
+	if int64(f.buf.Len())+f.pw.ObjsSize < w.cfg.FileSize {
+		return nil
+	}
+	if err := w.finish(p); err != nil {
+		return err
+	}
+	// the item is in the object, so it isn't failed if the object can't be stored yet
+	_ = w.store()
+	return nil
+}
+
+//finish completes the open object of partition p, queueing it to be stored
+func (w *Writer) finish(p partition) error {
+	f := w.files[p]
+	delete(w.files, p)
+	if err := f.pw.WriteStop(); err != nil {
+		return fmt.Errorf("Writer: %w", err)
+	}
+	w.seq++
+	key := fmt.Sprintf("%s%s%s-%d-%d.zstd.parquet", w.cfg.Prefix, p.path(), w.cfg.RunID, w.worker, w.seq)
+	w.pending = append(w.pending, object{key: key, body: f.buf.Bytes()})
+	return nil
+}
+
+//store stores the objects waiting to be, stopping at the first that fails
+func (w *Writer) store() error {
+	for len(w.pending) > 0 {
+		o := w.pending[0]
+		if err := w.cfg.Put(w.ctx, w.cfg.Bucket, o.key, o.body); err != nil {
+			return fmt.Errorf("Writer: %d objects not stored, first: %w", len(w.pending), err)
+		}
+		w.written += int64(len(o.body))
+		w.pending = w.pending[1:]
+	}
+	return nil
+}
+
+// BytesWritten implements ByteCounter for Writer
+func (w *Writer) BytesWritten() int64 {
+	return w.written
+}
+
+// Flush implements Flusher for Writer, completing and storing every open object
+func (w *Writer) Flush() error {
+	for p := range w.files {
+		if err := w.finish(p); err != nil {
+			return err
+		}
+	}
+	return w.store()
+}
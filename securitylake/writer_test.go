@@ -0,0 +1,205 @@
+package securitylake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//bucket is an object store for Config.Put, failing while fail is set
+type bucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	fail    error
+}
+
+func (b *bucket) put(_ context.Context, bucket, key string, body []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail != nil {
+		return b.fail
+	}
+	if b.objects == nil {
+		b.objects = make(map[string][]byte)
+	}
+	b.objects[bucket+"/"+key] = body
+	return nil
+}
+
+func (b *bucket) keys() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keys := make([]string, 0, len(b.objects))
+	for k := range b.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//events reads the events of the object key
+func (b *bucket) events(t *testing.T, key string) []Event {
+	t.Helper()
+	f, err := buffer.NewBufferFile(b.objects[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(f, new(Event), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	events := make([]Event, pr.GetNumRows())
+	if err := pr.Read(&events); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func lakeItem(id, region, account, captured string) map[string]any {
+	return map[string]any{"resourceType": "AWS::EC2::Volume", "resourceId": id, "awsRegion": region, "awsAccountId": account,
+		"configurationItemCaptureTime": captured, "configuration": map[string]any{"size": 8}}
+}
+
+//newWriter creates a writer of worker 1 storing objects in b
+func newWriter(t *testing.T, b *bucket, fileSize int64) *Writer {
+	t.Helper()
+	factory, err := WriterFactory(Config{Bucket: "lake", Prefix: "ext/config/1.0", FileSize: fileSize, RunID: "run", Put: b.put})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := factory(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.(*Writer)
+}
+
+func TestWriterPartitions(t *testing.T) {
+	b := &bucket{}
+	w := newWriter(t, b, 0)
+	items := []map[string]any{
+		lakeItem("vol-1", "eu-west-1", "111122223333", "2024-02-29T10:00:00Z"),
+		lakeItem("vol-2", "eu-west-1", "111122223333", "2024-02-29T23:59:59Z"),
+		lakeItem("vol-3", "eu-west-1", "111122223333", "2024-03-01T00:00:00Z"),
+		lakeItem("vol-4", "us-east-1", "111122223333", "2024-02-29T10:00:00Z"),
+		lakeItem("vol-5", "eu-west-1", "444455556666", "2024-02-29T10:00:00Z"),
+	}
+	for _, item := range items {
+		if err := w.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.keys()) != 0 {
+		t.Errorf("objects stored before they're full or flushed: %v", b.keys())
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]string{
+		"region=eu-west-1/accountId=111122223333/eventDay=20240229/": {"vol-1", "vol-2"},
+		"region=eu-west-1/accountId=111122223333/eventDay=20240301/": {"vol-3"},
+		"region=us-east-1/accountId=111122223333/eventDay=20240229/": {"vol-4"},
+		"region=eu-west-1/accountId=444455556666/eventDay=20240229/": {"vol-5"},
+	}
+	keys := b.keys()
+	if len(keys) != len(want) {
+		t.Fatalf("objects %v", keys)
+	}
+	var written int64
+	for _, k := range keys {
+		written += int64(len(b.objects[k]))
+		prefix, name, ok := strings.Cut(strings.TrimPrefix(k, "lake/ext/config/1.0/"), "run-1-")
+		if !ok || !strings.HasSuffix(name, ".zstd.parquet") {
+			t.Errorf("object %s isn't named by run, worker and sequence", k)
+			continue
+		}
+		ids, ok := want[prefix]
+		if !ok {
+			t.Errorf("object %s isn't in a partition written to", k)
+			continue
+		}
+		events := b.events(t, k)
+		got := make([]string, len(events))
+		for i, e := range events {
+			got[i] = e.Resources[0].UID
+		}
+		if strings.Join(got, ",") != strings.Join(ids, ",") {
+			t.Errorf("%s: events of %v, want %v", prefix, got, ids)
+		}
+	}
+	if w.BytesWritten() != written {
+		t.Errorf("BytesWritten %d, want %d", w.BytesWritten(), written)
+	}
+}
+
+func TestWriterRoll(t *testing.T) {
+	b := &bucket{}
+	// objects roll over as soon as they've an event
+	w := newWriter(t, b, 1)
+	for i := 1; i <= 3; i++ {
+		if err := w.Write(lakeItem(fmt.Sprintf("vol-%d", i), "eu-west-1", "111122223333", "2024-02-29T10:00:00Z")); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(b.keys()); n != i {
+			t.Fatalf("after %d writes, %d objects", i, n)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i, k := range b.keys() {
+		if !strings.HasSuffix(k, fmt.Sprintf("/run-1-%d.zstd.parquet", i+1)) {
+			t.Errorf("object %d is %s", i+1, k)
+		}
+		if events := b.events(t, k); len(events) != 1 || events[0].Resources[0].UID != fmt.Sprintf("vol-%d", i+1) {
+			t.Errorf("%s: %+v", k, events)
+		}
+	}
+}
+
+func TestWriterPutFails(t *testing.T) {
+	b := &bucket{fail: errors.New("throttled")}
+	w := newWriter(t, b, 1)
+	// the item is in an object waiting to be stored, so it isn't failed
+	if err := w.Write(lakeItem("vol-1", "eu-west-1", "111122223333", "2024-02-29T10:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err == nil || !strings.Contains(err.Error(), "throttled") {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	b.fail = nil
+	if err := w.Write(lakeItem("vol-2", "eu-west-1", "111122223333", "2024-02-29T10:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := b.keys(); len(keys) != 2 {
+		t.Errorf("objects %v", keys)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	if _, err := WriterFactory(Config{Bucket: "lake"}); err == nil {
+		t.Errorf("no error without Put")
+	}
+	w := newWriter(t, &bucket{}, 0)
+	for _, item := range []map[string]any{
+		lakeItem("vol-1", "", "111122223333", "2024-02-29T10:00:00Z"),
+		lakeItem("vol-1", "eu-west-1", "", "2024-02-29T10:00:00Z"),
+		{"resourceId": "vol-1"},
+	} {
+		if err := w.Write(item); err == nil {
+			t.Errorf("%v: no error", item)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+//Package spool is a disk-backed write-ahead queue between decoding and a slow sink
+//Records are appended to segment files, each framed with its length and a CRC, and read back in order
+//by one reader, whose acknowledged position is kept in a cursor file so reading resumes after a restart.
+package spool
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefaultSegmentBytes is the size at which a segment is closed and the next begun, by default
+const DefaultSegmentBytes = 64 << 20
+
+//headerBytes is the size of a record's frame: its length, then the CRC of its payload
+const headerBytes = 8
+
+//segmentExt is the extension of segment files, named by their sequence number
+const segmentExt = ".seg"
+
+//cursorFile is the name of the file holding the acknowledged position
+const cursorFile = "cursor.json"
+
+//ErrCorrupt is returned for a record whose CRC doesn't match, other than a torn final record
+var ErrCorrupt = errors.New("corrupt spool record")
+
+//ErrClosed is returned by Append once the spool is closed for writing
+var ErrClosed = errors.New("spool closed")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//Position locates a record in the spool: the segment, and the offset in it
+type Position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+//less reports whether p comes before q
+func (p Position) less(q Position) bool {
+	return p.Segment < q.Segment || p.Segment == q.Segment && p.Offset < q.Offset
+}
+
+//Spool is a write-ahead queue of records in a directory
+// Appends may be concurrent; there is one reader, which sees records as soon as they're appended.
+type Spool struct {
+	dir          string
+	segmentBytes int64
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active *os.File
+	seg    uint64 // the segment appended to
+	size   int64  // bytes of complete records in the active segment
+	closed bool
+	cursor Position
+	buf    []byte
+}
+
+//Open opens the spool in dir, creating it if need be
+// A final record torn by a crash is truncated away. Segments are closed once they reach segmentBytes;
+// 0 is DefaultSegmentBytes.
+func Open(dir string, segmentBytes int64) (*Spool, error) {
+	if segmentBytes <= 0 {
+		segmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	s := &Spool{dir: dir, segmentBytes: segmentBytes}
+	s.cond = sync.NewCond(&s.mu)
+
+	b, err := os.ReadFile(filepath.Join(dir, cursorFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &s.cursor); err != nil {
+			return nil, fmt.Errorf("spool.Open: %s: %w", cursorFile, err)
+		}
+	}
+
+	segs, err := s.segments()
+	if err != nil {
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	s.seg = s.cursor.Segment
+	if len(segs) > 0 && segs[len(segs)-1] > s.seg {
+		s.seg = segs[len(segs)-1]
+	}
+	if s.seg == 0 {
+		s.seg = 1
+	}
+	if s.cursor.Segment == 0 {
+		s.cursor = Position{Segment: 1}
+		if len(segs) > 0 {
+			s.cursor.Segment = segs[0]
+		}
+	}
+
+	if s.active, err = os.OpenFile(s.segmentPath(s.seg), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	if s.size, err = validLength(s.active); err != nil {
+		_ = s.active.Close()
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	if err := s.active.Truncate(s.size); err != nil {
+		_ = s.active.Close()
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	if _, err := s.active.Seek(s.size, io.SeekStart); err != nil {
+		_ = s.active.Close()
+		return nil, fmt.Errorf("spool.Open: %w", err)
+	}
+	return s, nil
+}
+
+//segments returns the sequence numbers of the segment files, in order
+func (s *Spool) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(name, 10, 64); err == nil {
+			segs = append(segs, n)
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+func (s *Spool) segmentPath(seg uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seg, segmentExt))
+}
+
+//validLength returns the length of the complete, intact records at the start of f
+func validLength(f *os.File) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var n int64
+	r := &recordReader{r: f}
+	for {
+		_, size, err := r.next()
+		if err != nil {
+			// a crash may leave the final record incomplete or unsynced
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCorrupt) {
+				return n, nil
+			}
+			return n, err
+		}
+		n += size
+	}
+}
+
+//Append adds record to the spool, where the reader can read it at once
+// Records are written to the operating system, but not synced to disk until Sync.
+func (s *Spool) Append(record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.size >= s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("Append: %w", err)
+		}
+	}
+
+	s.buf = append(s.buf[:0], make([]byte, headerBytes)...)
+	binary.LittleEndian.PutUint32(s.buf[0:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(s.buf[4:8], crc32.Checksum(record, crcTable))
+	s.buf = append(s.buf, record...)
+	if _, err := s.active.Write(s.buf); err != nil {
+		// don't leave a partial record for the reader to stumble on
+		_ = s.active.Truncate(s.size)
+		_, _ = s.active.Seek(s.size, io.SeekStart)
+		return fmt.Errorf("Append: %w", err)
+	}
+	s.size += int64(len(s.buf))
+	s.cond.Broadcast()
+	return nil
+}
+
+//rotate syncs and closes the active segment, then begins the next
+func (s *Spool) rotate() error {
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.segmentPath(s.seg+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active, s.seg, s.size = f, s.seg+1, 0
+	return syncDir(s.dir)
+}
+
+//Sync commits the records appended so far to disk
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("Sync: %w", err)
+	}
+	return nil
+}
+
+//CloseWrites stops appends, syncing the spool; the reader then reaches io.EOF once it has read every record
+func (s *Spool) CloseWrites() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("CloseWrites: %w", err)
+	}
+	return nil
+}
+
+//Close closes the spool; what's unacknowledged is read again when it's next opened
+func (s *Spool) Close() error {
+	if err := s.CloseWrites(); err != nil {
+		return err
+	}
+	return s.active.Close()
+}
+
+//Pending returns the bytes of records not yet acknowledged, framing included
+func (s *Spool) Pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for seg := s.cursor.Segment; seg < s.seg; seg++ {
+		if info, err := os.Stat(s.segmentPath(seg)); err == nil {
+			n += info.Size()
+		}
+	}
+	return n + s.size - s.cursor.Offset
+}
+
+//Ack acknowledges the records before pos, which are not read again after a restart
+// Segments wholly acknowledged are removed.
+func (s *Spool) Ack(pos Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.cursor.less(pos) {
+		return nil
+	}
+
+	b, err := json.Marshal(pos)
+	if err != nil {
+		return fmt.Errorf("Ack: %w", err)
+	}
+	path := filepath.Join(s.dir, cursorFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("Ack: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Ack: %w", err)
+	}
+
+	for seg := s.cursor.Segment; seg < pos.Segment; seg++ {
+		if err := os.Remove(s.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Ack: %w", err)
+		}
+	}
+	s.cursor = pos
+	return nil
+}
+
+//Reader reads the spool's records in order, from the acknowledged position
+type Reader struct {
+	s   *Spool
+	pos Position
+	f   *os.File
+	r   *recordReader
+}
+
+//NewReader returns a reader from the acknowledged position
+func (s *Spool) NewReader() *Reader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Reader{s: s, pos: s.cursor}
+}
+
+//Next returns the next record, and the position after it, to acknowledge once it's been delivered
+// It waits for a record to be appended, returning io.EOF once writes are closed and every record has
+// been read, or the context's error if it's done first. The record is only valid until the next call.
+func (r *Reader) Next(ctx context.Context) ([]byte, Position, error) {
+	for {
+		s := r.s
+		s.mu.Lock()
+		for r.pos.Segment == s.seg && r.pos.Offset >= s.size && !s.closed && ctx.Err() == nil {
+			stop := context.AfterFunc(ctx, func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.cond.Broadcast()
+			})
+			s.cond.Wait()
+			stop()
+		}
+		active, limit, closed := r.pos.Segment == s.seg, s.size, s.closed
+		s.mu.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return nil, r.pos, err
+		}
+		if active && r.pos.Offset >= limit && closed {
+			return nil, r.pos, io.EOF
+		}
+		if !active && r.f != nil && r.r.atEOF() {
+			// the segment was complete; go on to the next
+			_ = r.f.Close()
+			r.f, r.pos = nil, Position{Segment: r.pos.Segment + 1}
+			continue
+		}
+
+		if r.f == nil {
+			f, err := os.Open(s.segmentPath(r.pos.Segment))
+			if os.IsNotExist(err) && !active {
+				r.pos = Position{Segment: r.pos.Segment + 1}
+				continue
+			}
+			if err != nil {
+				return nil, r.pos, fmt.Errorf("Next: %w", err)
+			}
+			if _, err := f.Seek(r.pos.Offset, io.SeekStart); err != nil {
+				_ = f.Close()
+				return nil, r.pos, fmt.Errorf("Next: %w", err)
+			}
+			r.f, r.r = f, &recordReader{r: f}
+		}
+
+		record, n, err := r.r.next()
+		if errors.Is(err, io.EOF) && !active {
+			continue
+		}
+		if err != nil {
+			return nil, r.pos, fmt.Errorf("Next: segment %d offset %d: %w", r.pos.Segment, r.pos.Offset, err)
+		}
+		r.pos.Offset += n
+		return record, r.pos, nil
+	}
+}
+
+//Available reports whether Next has a record to return without waiting for an append
+func (r *Reader) Available() bool {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.pos.Segment < r.s.seg || r.pos.Offset < r.s.size
+}
+
+//Close closes the reader's segment file
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+//recordReader reads framed records
+type recordReader struct {
+	r   io.Reader
+	buf []byte
+	eof bool
+}
+
+//next reads a record, returning it and its framed size
+func (rr *recordReader) next() ([]byte, int64, error) {
+	var header [headerBytes]byte
+	if _, err := io.ReadFull(rr.r, header[:]); err != nil {
+		if err == io.EOF {
+			rr.eof = true
+		}
+		return nil, 0, err
+	}
+	n := binary.LittleEndian.Uint32(header[0:4])
+	if cap(rr.buf) < int(n) {
+		rr.buf = make([]byte, n)
+	}
+	rr.buf = rr.buf[:n]
+	if _, err := io.ReadFull(rr.r, rr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(rr.buf, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, 0, ErrCorrupt
+	}
+	return rr.buf, int64(headerBytes) + int64(n), nil
+}
+
+//atEOF reports whether the last read found the end of the segment
+func (rr *recordReader) atEOF() bool {
+	return rr.eof
+}
+
+//syncDir syncs directory dir, so files created in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,147 @@
+//Package state keeps the latest known configuration of each resource, so a sequence of periodic
+//snapshots can be turned into a stream of the resources that changed
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//resourcesBucket is the bucket holding the entries of resources
+var resourcesBucket = []byte("resources")
+
+//Entry is the latest known configuration of one resource
+// Digest identifies its configuration, e.g. a hash of its item less metadata; the items themselves
+// are left to the writer they were emitted to. CaptureTime is when AWS Config recorded it, and
+// SourceFile the file it was decoded from.
+type Entry struct {
+	Digest      string    `json:"digest"`
+	CaptureTime time.Time `json:"captureTime"`
+	SourceFile  string    `json:"sourceFile"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+//Store is a bolt database of Entries by resource
+// Puts are held in one transaction until Commit, so the state can be advanced a whole input file at
+// a time, or not at all if the file's changes couldn't be emitted. Gets see uncommitted Puts. A Store
+// may be used by several goroutines.
+type Store struct {
+	mu sync.Mutex
+	db *bolt.DB
+	tx *bolt.Tx
+}
+
+//Open opens or creates the state database at path
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("state.Open: %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resourcesBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("state.Open: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+//Get returns the entry for the resource key, and whether there is one
+func (s *Store) Get(key string) (Entry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e Entry
+	var ok bool
+	get := func(tx *bolt.Tx) error {
+		v := tx.Bucket(resourcesBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(v, &e)
+	}
+
+	var err error
+	if s.tx != nil {
+		// a read transaction alongside the write one could block its commit
+		err = get(s.tx)
+	} else {
+		err = s.db.View(get)
+	}
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("Store.Get: %w", err)
+	}
+	return e, ok, nil
+}
+
+//Put stores e for the resource key, replacing any entry, once committed
+func (s *Store) Put(key string, e Entry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		if s.tx, err = s.db.Begin(true); err != nil {
+			return fmt.Errorf("Store.Put: %w", err)
+		}
+	}
+	if err := s.tx.Bucket(resourcesBucket).Put([]byte(key), v); err != nil {
+		return fmt.Errorf("Store.Put: %w", err)
+	}
+	return nil
+}
+
+//Commit makes the Puts since the last Commit or Rollback durable
+func (s *Store) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	if err != nil {
+		return fmt.Errorf("Store.Commit: %w", err)
+	}
+	return nil
+}
+
+//Rollback discards the Puts since the last Commit or Rollback
+func (s *Store) Rollback() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+//Len is the number of resources with committed entries
+func (s *Store) Len() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(resourcesBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+//Close discards any uncommitted Puts and closes the database
+func (s *Store) Close() error {
+	s.Rollback()
+	return s.db.Close()
+}
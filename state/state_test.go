@@ -0,0 +1,60 @@
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	captured := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	a := Entry{Digest: "d1", CaptureTime: captured, SourceFile: "day2.json", UpdatedAt: captured}
+	if err := s.Put("AWS::EC2::Volume vol-a", a); err != nil {
+		t.Fatal(err)
+	}
+	// Gets see uncommitted Puts, though Len doesn't
+	if e, ok, err := s.Get("AWS::EC2::Volume vol-a"); err != nil || !ok || e != a {
+		t.Errorf("Get before Commit: %+v, %t, %v", e, ok, err)
+	}
+	if n, err := s.Len(); err != nil || n != 0 {
+		t.Errorf("Len before Commit: %d, %v", n, err)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rollback discards the Puts since the Commit
+	if err := s.Put("AWS::EC2::Volume vol-b", a); err != nil {
+		t.Fatal(err)
+	}
+	s.Rollback()
+	if _, ok, err := s.Get("AWS::EC2::Volume vol-b"); err != nil || ok {
+		t.Errorf("Get after Rollback: %t, %v", ok, err)
+	}
+
+	// as does closing the store
+	if err := s.Put("AWS::EC2::Volume vol-c", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if e, ok, err := s.Get("AWS::EC2::Volume vol-a"); err != nil || !ok || !e.CaptureTime.Equal(captured) || e.Digest != "d1" {
+		t.Errorf("Get after reopening: %+v, %t, %v", e, ok, err)
+	}
+	if n, err := s.Len(); err != nil || n != 1 {
+		t.Errorf("Len after reopening: %d, %v", n, err)
+	}
+}
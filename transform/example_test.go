@@ -0,0 +1,28 @@
+package transform_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/decode"
+	"github.com/mfrasier/decode_json_stream/pool"
+	"github.com/mfrasier/decode_json_stream/transform"
+	"github.com/mfrasier/decode_json_stream/writers"
+	"strings"
+)
+
+func ExampleNew() {
+	doc := `{"batch": "b-7", "records": [{"id": 1}, {"id": 2}]}`
+	spec := transform.New("records", transform.CopyField("batch", "batch_id"), transform.Envelope("flat", ""),
+		transform.Reproducible())
+	c := &writers.Collector{}
+	if _, err := decode.Decode(context.Background(), strings.NewReader(doc), spec, writers.Collect(c), pool.New(1)); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, item := range c.Items() {
+		fmt.Println(item["id"], item["config_snapshot"], item["source_index"], item["source_offset_start"], item["source_offset_end"])
+	}
+	// Output:
+	// 1 map[batch_id:b-7] 0 29 38
+	// 2 map[batch_id:b-7] 1 40 49
+}
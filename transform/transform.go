@@ -0,0 +1,216 @@
+//Package transform specifies how a document's items are split out of it and what's added to each
+// It's part of the decoder's stable API, with packages decode, writers and pool (see
+// decode.APIVersion). A Spec is built with Options rather than by setting fields, so specs can gain
+// settings without breaking importers.
+// Each item is given metadata: the copied fields under config_snapshot, ingest_time, decoder_version,
+// run_id and source_file, if set, and its provenance, the byte offsets of its source in the uncompressed
+// document, as source_offset_start and source_offset_end, and its index in the items array, skipped items
+// included, as source_index. The copied fields must precede the items in the document, unless it's decoded
+// with decode.DecodeAt; decode.DecodeEvents copies none, and ignores Strict.
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"github.com/mfrasier/decode_json_stream/internal/apispec"
+	"io"
+	"maps"
+	"time"
+)
+
+func init() {
+	apispec.ItemTransformSpec = func(s any) config_decoder.ItemTransformSpec {
+		return s.(Spec).itemTransformSpec()
+	}
+}
+
+//Spec specifies the array of items split out of a document, and the document's fields copied to the
+// metadata of each item; the zero value has no items field, so decodes no items
+type Spec struct {
+	spec config_decoder.ItemTransformSpec
+}
+
+//Option sets an optional setting of a Spec
+type Option func(*Spec)
+
+//New returns the Spec splitting out the items of itemsField, with opts
+func New(itemsField string, opts ...Option) Spec {
+	s := Spec{spec: config_decoder.ItemTransformSpec{ItemsField: itemsField, Fields: map[string]string{}}}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+//Snapshot returns the Spec of an AWS Config snapshot, with opts: its configurationItems, each given the
+// snapshot's configSnapshotId and fileVersion in its config_snapshot metadata
+func Snapshot(opts ...Option) Spec {
+	return New("configurationItems", append([]Option{CopyField("configSnapshotId", ""), CopyField("fileVersion", "")}, opts...)...)
+}
+
+//Load reads a Spec from its json, with opts, as the decode_config_history command's -spec file:
+// {"ItemsField": "configurationItems", "Fields": {"configSnapshotId": ""}}
+func Load(r io.Reader, opts ...Option) (Spec, error) {
+	var s Spec
+	if err := json.NewDecoder(r).Decode(&s.spec); err != nil {
+		return Spec{}, fmt.Errorf("Load: %w", err)
+	}
+	if s.spec.ItemsField == "" {
+		return Spec{}, fmt.Errorf("Load: ItemsField is required")
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s, nil
+}
+
+//ItemsField returns the name of the document's field holding the array of items
+func (s Spec) ItemsField() string {
+	return s.spec.ItemsField
+}
+
+//Fields returns the document's fields copied to each item's metadata, mapped to their names there
+func (s Spec) Fields() map[string]string {
+	fields := make(map[string]string, len(s.spec.Fields))
+	for src, dest := range s.spec.Fields {
+		if dest == "" {
+			dest = src
+		}
+		fields[src] = dest
+	}
+	return fields
+}
+
+//itemTransformSpec returns the config_decoder.ItemTransformSpec decoding is done with
+func (s Spec) itemTransformSpec() config_decoder.ItemTransformSpec {
+	spec := s.spec
+	spec.Fields = maps.Clone(s.spec.Fields)
+	return spec
+}
+
+//CopyField copies the document's field src to each item's config_snapshot metadata as dest, or as src
+// if dest is ""
+// The field must be a string.
+func CopyField(src, dest string) Option {
+	return func(s *Spec) {
+		if s.spec.Fields == nil {
+			s.spec.Fields = map[string]string{}
+		}
+		s.spec.Fields[src] = dest
+	}
+}
+
+//Decoders decodes the items array with n goroutines, which only decode.DecodeAt does
+func Decoders(n int) Option {
+	return func(s *Spec) {
+		s.spec.Decoders = n
+	}
+}
+
+//MaxItems stops decoding once n items have been emitted, leaving the rest of the document unread
+func MaxItems(n int64) Option {
+	return func(s *Spec) {
+		s.spec.Selection.MaxItems = n
+	}
+}
+
+//Sample emits each item with probability rate, e.g. 0.01 for 1%; seed repeats a sample with one decoder
+func Sample(rate float64, seed int64) Option {
+	return func(s *Spec) {
+		s.spec.Selection.SampleRate, s.spec.Selection.Seed = rate, seed
+	}
+}
+
+//MaxItemSize truncates items larger than n encoded bytes
+func MaxItemSize(n int) Option {
+	return func(s *Spec) {
+		s.spec.Limits.MaxItemSize, s.spec.Limits.Oversize = n, config_decoder.OversizeTruncate
+	}
+}
+
+//MaxInFlight pauses decoding while n encoded bytes of items are waiting to be written
+func MaxInFlight(n int64) Option {
+	return func(s *Spec) {
+		s.spec.Limits.MaxInFlight = n
+	}
+}
+
+//RunID identifies the decode run in each item's metadata, as run_id
+func RunID(id string) Option {
+	return func(s *Spec) {
+		s.spec.RunID = id
+	}
+}
+
+//Source identifies the document in each item's metadata, as source_file
+func Source(name string) Option {
+	return func(s *Spec) {
+		s.spec.Source = name
+	}
+}
+
+//NoProvenance leaves out items' source_file, source_index and source offsets
+func NoProvenance() Option {
+	return func(s *Spec) {
+		s.spec.NoProvenance = true
+	}
+}
+
+//Strict fails decoding unless the document's top-level fields are exactly the copied fields and the
+// items field, each once
+// Decoding a stream, they're checked before each items array, so they must all precede it; without
+// Strict, copied fields found more than once are only warned of.
+func Strict() Option {
+	return func(s *Spec) {
+		s.spec.Strict = true
+	}
+}
+
+//Reproducible leaves out the metadata that differs between runs, ingest_time, decoder_version and run_id
+func Reproducible() Option {
+	return func(s *Spec) {
+		s.spec.Reproducible = true
+	}
+}
+
+//UseNumber decodes items' numbers as json.Numbers, their json as it's written, rather than float64s,
+// which round integers over 2^53 and write large ones in scientific notation
+func UseNumber() Option {
+	return func(s *Spec) {
+		s.spec.UseNumber = true
+	}
+}
+
+//Clock stamps items' ingest_time with the time now tells rather than the system clock's, e.g. a fixed
+// time, for the same metadata every run, kept even if Reproducible
+func Clock(now func() time.Time) Option {
+	return func(s *Spec) {
+		s.spec.Clock = now
+	}
+}
+
+//Verbatim holds items' source fields as their json, for writers.Verbatim to write them as they were
+// They're held in each item, so its items are for writers.Verbatim only.
+func Verbatim() Option {
+	return func(s *Spec) {
+		s.spec.Verbatim = true
+	}
+}
+
+//Envelope shapes the metadata added to items: both nested under metadata and copied to the top
+// level, the default, nested only, or flat, at the top level only; copies at the top level are
+// named with prefix
+func Envelope(shape, prefix string) Option {
+	return func(s *Spec) {
+		s.spec.Envelope.Shape, s.spec.Envelope.Prefix = shape, prefix
+	}
+}
+
+//Hash stamps each item with the SHA-256 of the canonical json of its fields, by default its
+// configuration, in field
+func Hash(field string, fields ...string) Option {
+	return func(s *Spec) {
+		s.spec.Hash = config_decoder.ItemHash{Field: field, Fields: fields}
+	}
+}
@@ -0,0 +1,63 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	now := func() time.Time { return time.Unix(0, 0) }
+	s := New("items", CopyField("id", "snapshot_id"), CopyField("version", ""), Decoders(4), MaxItems(10), Sample(0.5, 7),
+		MaxItemSize(1024), MaxInFlight(1<<20), RunID("run-1"), Source("a.json"), NoProvenance(), Strict(), Reproducible(),
+		UseNumber(), Clock(now), Verbatim(), Envelope("flat", "x_"), Hash("h", "tags"))
+
+	if s.ItemsField() != "items" {
+		t.Errorf("ItemsField %q", s.ItemsField())
+	}
+	if f := s.Fields(); len(f) != 2 || f["id"] != "snapshot_id" || f["version"] != "version" {
+		t.Errorf("Fields %v", f)
+	}
+
+	spec := s.itemTransformSpec()
+	if spec.Decoders != 4 || spec.Selection.MaxItems != 10 || spec.Selection.SampleRate != 0.5 || spec.Selection.Seed != 7 ||
+		spec.Limits.MaxItemSize != 1024 || spec.Limits.Oversize != config_decoder.OversizeTruncate || spec.Limits.MaxInFlight != 1<<20 ||
+		spec.RunID != "run-1" || spec.Source != "a.json" || !spec.NoProvenance || !spec.Strict || !spec.Reproducible ||
+		!spec.UseNumber || spec.Clock == nil || !spec.Verbatim || spec.Envelope.Shape != "flat" || spec.Envelope.Prefix != "x_" ||
+		spec.Hash.Field != "h" || len(spec.Hash.Fields) != 1 {
+		t.Errorf("options not all applied: %+v", spec)
+	}
+
+	// the spec returned is a copy, so changing it doesn't change s
+	spec.Fields["other"] = ""
+	if len(s.Fields()) != 2 {
+		t.Errorf("Fields changed through itemTransformSpec: %v", s.Fields())
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s, err := Load(strings.NewReader(`{"ItemsField": "configurationItems", "Fields": {"configSnapshotId": ""}}`), RunID("run-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ItemsField() != "configurationItems" || s.Fields()["configSnapshotId"] != "configSnapshotId" || s.itemTransformSpec().RunID != "run-1" {
+		t.Errorf("loaded %+v", s.itemTransformSpec())
+	}
+
+	for _, doc := range []string{`{"Fields": {"a": ""}}`, `{"ItemsField": 1}`, `not json`} {
+		if _, err := Load(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: no error", doc)
+		}
+	}
+}
+
+func ExampleSnapshot() {
+	s := Snapshot(CopyField("deliveredAt", "delivered_at"))
+	fmt.Println(s.ItemsField())
+	fmt.Println(s.Fields())
+	// Output:
+	// configurationItems
+	// map[configSnapshotId:configSnapshotId deliveredAt:delivered_at fileVersion:fileVersion]
+}
@@ -0,0 +1,83 @@
+//Package version reports the version of the build, for -version and item metadata
+// Release builds set it with ldflags:
+//
+//	go build -ldflags "-X github.com/mfrasier/decode_json_stream/version.Version=v1.2.0
+//	  -X github.com/mfrasier/decode_json_stream/version.Commit=$(git rev-parse HEAD)
+//	  -X github.com/mfrasier/decode_json_stream/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/...
+//
+// Anything not set is taken from the module and VCS information embedded by go build, where there is any.
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+//Set with -ldflags -X
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+//Info is the build's version information
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+//Get returns the build's version information
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, Commit: Commit, Date: Date}
+		bi, ok := debug.ReadBuildInfo()
+		if ok {
+			info.GoVersion = bi.GoVersion
+			if info.Version == "" && bi.Main.Version != "(devel)" {
+				info.Version = bi.Main.Version
+			}
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					if info.Commit == "" {
+						info.Commit = s.Value
+					}
+				case "vcs.time":
+					if info.Date == "" {
+						info.Date = s.Value
+					}
+				case "vcs.modified":
+					info.Modified = info.Modified || s.Value == "true"
+				}
+			}
+		}
+		if info.Version == "" {
+			info.Version = "devel"
+		}
+	})
+	return info
+}
+
+//String formats the information on one line
+func (i Info) String() string {
+	s := i.Version
+	if i.Commit != "" {
+		s += " commit " + i.Commit
+		if i.Modified {
+			s += " (modified)"
+		}
+	}
+	if i.Date != "" {
+		s += " built " + i.Date
+	}
+	return fmt.Sprintf("%s %s", s, i.GoVersion)
+}
@@ -0,0 +1,111 @@
+//Package writers writes decoded items, creating the writer of each worker of a pool with a Factory
+// It's part of the decoder's stable API, with packages decode, transform and pool (see
+// decode.APIVersion). Files are configured with FileOptions rather than by setting fields, so they
+// can gain settings without breaking importers. The writers of network sinks, such as config_decoder's
+// OpenSearchWriterFactory and package kafka's WriterFactory, aren't part of it yet; their configuration
+// may still change.
+package writers
+
+import (
+	"fmt"
+	"github.com/mfrasier/decode_json_stream/config_decoder"
+	"io"
+)
+
+//ItemWriter writes items; each worker of a pool has its own, unless its Factory shares one
+type ItemWriter = config_decoder.ItemWriter
+
+//Factory creates the ItemWriter of each worker of a pool, given the decoding's context and the
+// worker's index, from 0, before any item is written
+type Factory = config_decoder.WriterFactory
+
+//Flusher is optionally implemented by ItemWriters that buffer items; a worker flushes its writer
+// once it has written its last item
+type Flusher = config_decoder.Flusher
+
+//ByteCounter is optionally implemented by ItemWriters able to report the bytes of the items they've written
+type ByteCounter = config_decoder.ByteCounter
+
+//HealthChecker is optionally implemented by ItemWriters able to probe their sink, for a circuit breaker
+type HealthChecker = config_decoder.HealthChecker
+
+//Collector is an ItemWriter collecting items in memory, shared by the workers of a pool
+type Collector = config_decoder.CollectorWriter
+
+//Of returns the Factory of writers made by f, which needs neither context nor worker
+func Of(f func() ItemWriter) Factory {
+	return config_decoder.FactoryOf(f)
+}
+
+//Null returns the Factory of writers discarding items
+func Null() Factory {
+	return config_decoder.NullWriterFactory()
+}
+
+//Collect returns the Factory whose writers are all c
+func Collect(c *Collector) Factory {
+	return config_decoder.CollectorWriterFactory(c)
+}
+
+//FileOption sets how File and Routed writers encode items
+type FileOption func(*fileEncoding)
+
+//fileEncoding is how File and Routed writers encode items, as their FileOptions set
+type fileEncoding struct {
+	encoding config_decoder.FileEncoding
+}
+
+//Terminator follows each json item with terminator, rather than a newline
+func Terminator(terminator []byte) FileOption {
+	return func(e *fileEncoding) {
+		e.encoding.Termination = terminator
+	}
+}
+
+//Protobuf writes items as ConfigurationItem messages, each prefixed by its length as a varint
+func Protobuf() FileOption {
+	return func(e *fileEncoding) {
+		e.encoding.Proto = true
+	}
+}
+
+//Canonical writes items as canonical json, the same bytes for the same item
+func Canonical() FileOption {
+	return func(e *fileEncoding) {
+		e.encoding.Canonical = true
+	}
+}
+
+//Verbatim writes json items unescaped, those decoded with transform.Verbatim with their source fields as
+// they were, in their order
+func Verbatim() FileOption {
+	return func(e *fileEncoding) {
+		e.encoding.Verbatim = true
+	}
+}
+
+//File returns the Factory of writers writing items to w, as newline-delimited json unless opts say
+// otherwise; w is shared by the workers of a pool, so it must be safe for concurrent use with more than one
+func File(w io.Writer, opts ...FileOption) Factory {
+	return config_decoder.EncodedFileWriterFactory(w, encodingOf(opts))
+}
+
+//Routed returns the Factory of writers writing each item to the io.Writer open returns for the name
+// template gives it, e.g. out/{{.awsRegion}}.ndjson; open is called for every item by every worker,
+// so it must be safe for concurrent use, and return the same writer for the same name
+func Routed(template string, open func(name string) (io.Writer, error), opts ...FileOption) (Factory, error) {
+	route, err := config_decoder.ParseNameTemplate(template)
+	if err != nil {
+		return nil, fmt.Errorf("Routed: %w", err)
+	}
+	return config_decoder.RoutedFileWriterFactory(route, open, encodingOf(opts)), nil
+}
+
+//encodingOf returns the encoding opts set
+func encodingOf(opts []FileOption) config_decoder.FileEncoding {
+	e := fileEncoding{encoding: config_decoder.FileEncoding{Termination: []byte{'\n'}}}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	return e.encoding
+}
@@ -0,0 +1,115 @@
+package writers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//write writes items with the writer of worker 0 of f, flushing it after
+func write(t *testing.T, f Factory, items ...map[string]any) {
+	t.Helper()
+	w, err := f(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		if err := w.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fl, ok := w.(Flusher); ok {
+		if err := fl.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	item := map[string]any{"resourceType": "AWS::S3::Bucket", "resourceId": "logs", "tags": map[string]any{"b": "2", "a": "1"}}
+
+	var buf bytes.Buffer
+	write(t, File(&buf), item)
+	if !strings.HasSuffix(buf.String(), "}\n") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("json %q", buf.String())
+	}
+
+	buf.Reset()
+	write(t, File(&buf, Canonical(), Terminator([]byte{0})), item, item)
+	want := `{"resourceId":"logs","resourceType":"AWS::S3::Bucket","tags":{"a":"1","b":"2"}}` + "\x00"
+	if buf.String() != want+want {
+		t.Errorf("canonical %q, want %q", buf.String(), want+want)
+	}
+
+	buf.Reset()
+	write(t, File(&buf, Protobuf()), item)
+	// a varint length, then the message
+	if buf.Len() < 2 || int(buf.Bytes()[0]) != buf.Len()-1 {
+		t.Errorf("protobuf %x", buf.Bytes())
+	}
+}
+
+func TestRouted(t *testing.T) {
+	var mu sync.Mutex
+	outs := make(map[string]*bytes.Buffer)
+	open := func(name string) (io.Writer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if outs[name] == nil {
+			outs[name] = &bytes.Buffer{}
+		}
+		return outs[name], nil
+	}
+	f, err := Routed("out/{{.awsRegion}}.ndjson", open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, f, map[string]any{"awsRegion": "us-east-1"}, map[string]any{"awsRegion": "eu-west-1"}, map[string]any{"awsRegion": "us-east-1"})
+	if len(outs) != 2 || strings.Count(outs["out/us-east-1.ndjson"].String(), "\n") != 2 || strings.Count(outs["out/eu-west-1.ndjson"].String(), "\n") != 1 {
+		t.Errorf("routed %v", outs)
+	}
+
+	if _, err := Routed("out/{{.awsRegion", open); err == nil {
+		t.Errorf("no error for a bad template")
+	}
+}
+
+func TestFactories(t *testing.T) {
+	c := &Collector{}
+	write(t, Collect(c), map[string]any{"resourceType": "AWS::S3::Bucket"}, map[string]any{"resourceType": "AWS::IAM::Role"})
+	if c.Count() != 2 || len(c.ByResourceType("AWS::IAM::Role")) != 1 {
+		t.Errorf("collected %v", c.Items())
+	}
+
+	made := 0
+	f := Of(func() ItemWriter {
+		made++
+		return c
+	})
+	write(t, f, map[string]any{})
+	write(t, f, map[string]any{})
+	if made != 2 || c.Count() != 4 {
+		t.Errorf("Of made %d writers, which collected %d items", made, c.Count())
+	}
+
+	write(t, Null(), map[string]any{"resourceType": "AWS::S3::Bucket"})
+}
+
+func ExampleFile() {
+	w, err := File(os.Stdout, Canonical())(context.Background(), 0)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	_ = w.Write(map[string]any{"resourceType": "AWS::S3::Bucket", "resourceId": "logs", "awsRegion": "eu-west-1"})
+	if f, ok := w.(Flusher); ok {
+		_ = f.Flush()
+	}
+	// Output:
+	// {"awsRegion":"eu-west-1","resourceId":"logs","resourceType":"AWS::S3::Bucket"}
+}